@@ -0,0 +1,223 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package bytebuffer
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+const defaultSpillThreshold = 1024 * 1024 // 1 MiB
+
+// SpillCreator is a [Creator] that returns buffers
+// that are held in memory until they grow past a threshold,
+// at which point their contents are moved to a buffer from another [Creator]
+// (usually a [TempFileCreator]).
+// Small buffers thus avoid touching the filesystem
+// while large buffers do not consume unbounded memory.
+type SpillCreator struct {
+	// Threshold is the maximum number of bytes that a buffer holds in memory.
+	// Buffers created with a size larger than Threshold are spilled immediately.
+	// If Threshold is zero, then a reasonable default is used.
+	// If Threshold is negative, then all buffers are spilled immediately.
+	Threshold int64
+	// Spill is the [Creator] used for buffers that exceed Threshold.
+	// Spill must not be nil.
+	Spill Creator
+	// If Stats is not nil, then the buffers record their usage in it.
+	Stats *Stats
+}
+
+// CreateBuffer returns a new buffer of the given size.
+func (c SpillCreator) CreateBuffer(size int64) (ReadWriteSeekCloser, error) {
+	if c.Spill == nil {
+		return nil, fmt.Errorf("create buffer: no spill creator")
+	}
+	threshold := c.Threshold
+	if threshold == 0 {
+		threshold = defaultSpillThreshold
+	}
+	if c.Stats != nil {
+		c.Stats.buffers.Add(1)
+	}
+	if size > threshold {
+		f, err := c.Spill.CreateBuffer(size)
+		if err != nil {
+			return nil, err
+		}
+		if c.Stats != nil {
+			c.Stats.spills.Add(1)
+		}
+		return f, nil
+	}
+	sb := &spillBuffer{
+		mem:       New(make([]byte, max(size, 0))),
+		threshold: threshold,
+		spill:     c.Spill,
+		stats:     c.Stats,
+	}
+	sb.account()
+	return sb, nil
+}
+
+// spillBuffer is the buffer type returned by [SpillCreator].
+type spillBuffer struct {
+	// mem is the in-memory buffer.
+	// It is nil once the buffer has been spilled.
+	mem *Buffer
+	// file is the spilled buffer.
+	// It is nil until the buffer is spilled.
+	file ReadWriteSeekCloser
+
+	threshold int64
+	spill     Creator
+	stats     *Stats
+	// memSize is the number of bytes of mem recorded in stats.
+	memSize int64
+}
+
+func (sb *spillBuffer) Read(p []byte) (int, error) {
+	if sb.file != nil {
+		return sb.file.Read(p)
+	}
+	return sb.mem.Read(p)
+}
+
+func (sb *spillBuffer) Write(p []byte) (int, error) {
+	if sb.file == nil && sb.mem.i+int64(len(p)) > sb.threshold {
+		if err := sb.spillToFile(); err != nil {
+			return 0, err
+		}
+	}
+	if sb.file != nil {
+		return sb.file.Write(p)
+	}
+	n, err := sb.mem.Write(p)
+	sb.account()
+	return n, err
+}
+
+func (sb *spillBuffer) Seek(offset int64, whence int) (int64, error) {
+	if sb.file != nil {
+		return sb.file.Seek(offset, whence)
+	}
+	return sb.mem.Seek(offset, whence)
+}
+
+// Truncate changes the size of the buffer.
+// It does not change the I/O offset.
+// Growing an in-memory buffer past the threshold spills it.
+func (sb *spillBuffer) Truncate(size int64) error {
+	if sb.file == nil && size > sb.threshold {
+		if err := sb.spillToFile(); err != nil {
+			return err
+		}
+	}
+	if sb.file != nil {
+		t, ok := sb.file.(interface{ Truncate(size int64) error })
+		if !ok {
+			return fmt.Errorf("truncate spilled buffer: %T does not support truncation", sb.file)
+		}
+		return t.Truncate(size)
+	}
+	err := sb.mem.Truncate(size)
+	sb.account()
+	return err
+}
+
+func (sb *spillBuffer) Close() error {
+	if sb.file != nil {
+		return sb.file.Close()
+	}
+	if sb.mem != nil {
+		sb.mem = nil
+		sb.account()
+	}
+	return nil
+}
+
+// spillToFile moves the buffer's contents to a buffer from sb.spill,
+// preserving the I/O offset.
+func (sb *spillBuffer) spillToFile() error {
+	f, err := sb.spill.CreateBuffer(-1)
+	if err != nil {
+		return fmt.Errorf("spill buffer: %v", err)
+	}
+	if _, err := f.Write(sb.mem.s); err != nil {
+		f.Close()
+		return fmt.Errorf("spill buffer: %v", err)
+	}
+	if _, err := f.Seek(sb.mem.i, io.SeekStart); err != nil {
+		f.Close()
+		return fmt.Errorf("spill buffer: %v", err)
+	}
+	if sb.stats != nil {
+		sb.stats.spills.Add(1)
+		sb.stats.bytesSpilled.Add(int64(len(sb.mem.s)))
+	}
+	sb.file = f
+	sb.mem = nil
+	sb.account()
+	return nil
+}
+
+// account updates sb.stats to reflect the current size of sb.mem.
+func (sb *spillBuffer) account() {
+	var size int64
+	if sb.mem != nil {
+		size = int64(cap(sb.mem.s))
+	}
+	if sb.stats != nil && size != sb.memSize {
+		sb.stats.addMemory(size - sb.memSize)
+	}
+	sb.memSize = size
+}
+
+// Stats is a set of counters for buffers created by a [SpillCreator].
+// The zero value is a set of counters at zero.
+// Stats is safe to use concurrently from multiple goroutines.
+type Stats struct {
+	buffers      atomic.Int64
+	spills       atomic.Int64
+	bytesSpilled atomic.Int64
+	memory       atomic.Int64
+	peakMemory   atomic.Int64
+}
+
+// StatsSnapshot is the state of a [Stats] at a point in time.
+type StatsSnapshot struct {
+	// Buffers is the number of buffers created.
+	Buffers int64
+	// Spills is the number of buffers that were moved out of memory
+	// (including buffers that were too large to start in memory).
+	Spills int64
+	// BytesSpilled is the number of bytes copied out of memory when spilling buffers.
+	BytesSpilled int64
+	// Memory is the number of bytes of memory held by open buffers.
+	Memory int64
+	// PeakMemory is the largest value that Memory has had.
+	PeakMemory int64
+}
+
+// Snapshot returns the current values of the counters.
+func (stats *Stats) Snapshot() StatsSnapshot {
+	return StatsSnapshot{
+		Buffers:      stats.buffers.Load(),
+		Spills:       stats.spills.Load(),
+		BytesSpilled: stats.bytesSpilled.Load(),
+		Memory:       stats.memory.Load(),
+		PeakMemory:   stats.peakMemory.Load(),
+	}
+}
+
+func (stats *Stats) addMemory(delta int64) {
+	curr := stats.memory.Add(delta)
+	for {
+		peak := stats.peakMemory.Load()
+		if curr <= peak || stats.peakMemory.CompareAndSwap(peak, curr) {
+			return
+		}
+	}
+}
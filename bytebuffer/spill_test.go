@@ -0,0 +1,140 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package bytebuffer
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestSpillCreator(t *testing.T) {
+	stats := new(Stats)
+	c := SpillCreator{
+		Threshold: 16,
+		Spill:     BufferCreator{},
+		Stats:     stats,
+	}
+
+	small, err := c.CreateBuffer(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(small, "Hello"); err != nil {
+		t.Fatal(err)
+	}
+	if got := stats.Snapshot(); got.Spills != 0 || got.Memory == 0 {
+		t.Errorf("after small write, stats = %+v; want no spills and non-zero memory", got)
+	}
+
+	large, err := c.CreateBuffer(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const firstPart = "0123456789"
+	const secondPart = "abcdefghijklmnopqrstuvwxyz"
+	if _, err := io.WriteString(large, firstPart); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(large, secondPart); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := large.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(large)
+	if want := firstPart + secondPart; string(got) != want || err != nil {
+		t.Errorf("io.ReadAll(large) = %q, %v; want %q, <nil>", got, err, want)
+	}
+	snap := stats.Snapshot()
+	if snap.Buffers != 2 || snap.Spills != 1 || snap.BytesSpilled != int64(len(firstPart)) {
+		t.Errorf("after spill, stats = %+v; want 2 buffers, 1 spill, %d bytes spilled", snap, len(firstPart))
+	}
+
+	if err := small.Close(); err != nil {
+		t.Error(err)
+	}
+	if err := large.Close(); err != nil {
+		t.Error(err)
+	}
+	snap = stats.Snapshot()
+	if snap.Memory != 0 {
+		t.Errorf("after close, memory = %d; want 0", snap.Memory)
+	}
+	if snap.PeakMemory == 0 {
+		t.Error("peak memory = 0")
+	}
+}
+
+func TestSpillCreatorLargeSize(t *testing.T) {
+	stats := new(Stats)
+	c := SpillCreator{
+		Threshold: 16,
+		Spill:     BufferCreator{},
+		Stats:     stats,
+	}
+	buf, err := c.CreateBuffer(32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer buf.Close()
+	got, err := io.ReadAll(buf)
+	if want := strings.Repeat("\x00", 32); string(got) != want || err != nil {
+		t.Errorf("io.ReadAll(buf) = %q, %v; want %q, <nil>", got, err, want)
+	}
+	if snap := stats.Snapshot(); snap.Spills != 1 || snap.Memory != 0 {
+		t.Errorf("stats = %+v; want 1 spill and no memory", snap)
+	}
+}
+
+func TestSpillCreatorTruncate(t *testing.T) {
+	type truncater interface {
+		Truncate(size int64) error
+	}
+
+	tests := []struct {
+		name      string
+		init      string
+		size      int64
+		want      string
+		wantSpill bool
+	}{
+		{name: "ShrinkMemory", init: "Hello, World!", size: 5, want: "Hello"},
+		{name: "GrowMemory", init: "Hello", size: 8, want: "Hello\x00\x00\x00"},
+		{name: "GrowPastThreshold", init: "Hello", size: 20, want: "Hello" + strings.Repeat("\x00", 15), wantSpill: true},
+		{name: "ShrinkSpilled", init: strings.Repeat("x", 20), size: 3, want: "xxx", wantSpill: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stats := new(Stats)
+			c := SpillCreator{
+				Threshold: 16,
+				Spill:     BufferCreator{},
+				Stats:     stats,
+			}
+			buf, err := c.CreateBuffer(-1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer buf.Close()
+			if _, err := io.WriteString(buf, test.init); err != nil {
+				t.Fatal(err)
+			}
+			t.Logf("buf.Truncate(%d)", test.size)
+			if err := buf.(truncater).Truncate(test.size); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := buf.Seek(0, io.SeekStart); err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(buf)
+			if string(got) != test.want || err != nil {
+				t.Errorf("io.ReadAll(buf) = %q, %v; want %q, <nil>", got, err, test.want)
+			}
+			if snap := stats.Snapshot(); (snap.Spills > 0) != test.wantSpill {
+				t.Errorf("stats = %+v; want spilled = %t", snap, test.wantSpill)
+			}
+		})
+	}
+}
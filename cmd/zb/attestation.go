@@ -0,0 +1,158 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	jsonv2 "github.com/go-json-experiment/json"
+	"zb.256lights.llc/pkg/internal/jsonrpc"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+	"zb.256lights.llc/pkg/zbstore"
+	"zombiezen.com/go/log"
+)
+
+type storeAttestationCommand struct {
+	Path        string   `kong:"arg,completion-predictor=storepath"`
+	Verify      bool     `kong:"help=Verify that attestations are signed by a trusted key and match the store object instead of printing them."`
+	TrustedKeys []string `kong:"name=trusted-key,sep=none,placeholder=file,help=Public key file (as printed by zb key show-public) to trust in addition to the configured trusted public keys when verifying (can be passed multiple times)"`
+}
+
+func (c *storeAttestationCommand) Signature() string {
+	return `kong:"help=Show or verify the build provenance attestations for a store object."`
+}
+
+func (c *storeAttestationCommand) Run(ctx context.Context, g *globalConfig) error {
+	path, err := zbstore.ParsePath(c.Path)
+	if err != nil {
+		return err
+	}
+	if len(c.TrustedKeys) > 0 && !c.Verify {
+		return errors.New("--trusted-key requires --verify")
+	}
+	trustedKeys := slices.Clone(g.TrustedPublicKeys)
+	for _, keyPath := range c.TrustedKeys {
+		data, err := os.ReadFile(keyPath)
+		if err != nil {
+			return err
+		}
+		k := new(zbstore.RealizationPublicKey)
+		if err := jsonv2.Unmarshal(data, k); err != nil {
+			return fmt.Errorf("read %s: %v", keyPath, err)
+		}
+		trustedKeys = append(trustedKeys, k)
+	}
+	if c.Verify && len(trustedKeys) == 0 {
+		return errors.New("no trusted public keys (pass --trusted-key or set trustedPublicKeys in the configuration)")
+	}
+
+	storeClient := g.storeClient(nil)
+	defer storeClient.Close()
+
+	handshake, err := zbstorerpc.Handshake(ctx, storeClient)
+	if err != nil {
+		return err
+	}
+	if err := handshake.Require(zbstorerpc.CapabilityAttestations, "attestations"); err != nil {
+		return err
+	}
+	resp := new(zbstorerpc.AttestationsResponse)
+	err = jsonrpc.Do(ctx, storeClient, zbstorerpc.AttestationsMethod, resp, &zbstorerpc.AttestationsRequest{
+		Path: path,
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	if len(resp.Attestations) == 0 {
+		return fmt.Errorf("%s: no attestations", path)
+	}
+
+	if !c.Verify {
+		for _, env := range resp.Attestations {
+			data, err := jsonv2.Marshal(env)
+			if err != nil {
+				return err
+			}
+			data = append(data, '\n')
+			if _, err := os.Stdout.Write(data); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	infoResp := new(zbstorerpc.InfoResponse)
+	err = jsonrpc.Do(ctx, storeClient, zbstorerpc.InfoMethod, infoResp, &zbstorerpc.InfoRequest{
+		Path: path,
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	if infoResp.Info == nil {
+		return fmt.Errorf("%s: does not exist", path)
+	}
+
+	verified := 0
+	for i, env := range resp.Attestations {
+		stmt, err := verifyAttestation(path, infoResp.Info, env, trustedKeys)
+		if err != nil {
+			log.Warnf(ctx, "%s: attestation %d: %v", path, i+1, err)
+			continue
+		}
+		verified++
+		prov := stmt.Predicate
+		msg := fmt.Sprintf("%s: verified attestation: built from %s by %s",
+			path, prov.BuildDefinition.ExternalParameters.DerivationPath, prov.RunDetails.Builder.ID)
+		if md := prov.RunDetails.Metadata; md != nil && !md.FinishedOn.IsZero() {
+			msg += " at " + md.FinishedOn.Format(time.RFC3339)
+		}
+		if _, err := fmt.Println(msg); err != nil {
+			return err
+		}
+	}
+	if verified == 0 {
+		return fmt.Errorf("%s: no valid attestations", path)
+	}
+	return nil
+}
+
+// verifyAttestation checks that env is a SLSA provenance attestation
+// about the store object at path with the given info
+// that is signed by at least one of trustedKeys.
+// A signature alone does not make an attestation trustworthy,
+// since the envelope names the key that verifies it.
+func verifyAttestation(path zbstore.Path, info *zbstorerpc.ObjectInfo, env *zbstore.AttestationEnvelope, trustedKeys []*zbstore.RealizationPublicKey) (*zbstore.AttestationStatement, error) {
+	signers, err := env.Verify()
+	if err != nil {
+		return nil, err
+	}
+	trusted := slices.ContainsFunc(signers, func(k *zbstore.RealizationPublicKey) bool {
+		return slices.ContainsFunc(trustedKeys, k.Equal)
+	})
+	if !trusted {
+		return nil, errors.New("not signed by a trusted key")
+	}
+	stmt, err := env.Statement()
+	if err != nil {
+		return nil, err
+	}
+	if stmt.PredicateType != zbstore.SLSAProvenancePredicateType || stmt.Predicate == nil {
+		return nil, fmt.Errorf("unsupported predicate type %q", stmt.PredicateType)
+	}
+	if stmt.Predicate.BuildDefinition.ExternalParameters == nil {
+		return nil, errors.New("missing external parameters")
+	}
+	matchesSubject := slices.ContainsFunc(stmt.Subject, func(rd *zbstore.ResourceDescriptor) bool {
+		return rd.Name == string(path) && rd.MatchesNARHash(info.NARHash)
+	})
+	if !matchesSubject {
+		return nil, errors.New("subject does not match store object")
+	}
+	return stmt, nil
+}
@@ -0,0 +1,70 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"crypto/ed25519"
+	"testing"
+
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+	"zb.256lights.llc/pkg/zbstore"
+	"zombiezen.com/go/nix"
+)
+
+func TestVerifyAttestation(t *testing.T) {
+	const path zbstore.Path = "/opt/zb/store/aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa-foo"
+	narHash, err := nix.ParseHash("sha256-AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := &zbstorerpc.ObjectInfo{NARHash: narHash}
+	trustedKey := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	otherSeed := make([]byte, ed25519.SeedSize)
+	otherSeed[0] = 1
+	otherKey := ed25519.NewKeyFromSeed(otherSeed)
+	trustedPublicKeys := []*zbstore.RealizationPublicKey{{
+		Format: zbstore.Ed25519SignatureFormat,
+		Data:   trustedKey.Public().(ed25519.PublicKey),
+	}}
+
+	newEnvelope := func(t *testing.T, key ed25519.PrivateKey) *zbstore.AttestationEnvelope {
+		t.Helper()
+		env, err := zbstore.NewAttestationEnvelope(&zbstore.AttestationStatement{
+			Type:          zbstore.InTotoStatementType,
+			Subject:       []*zbstore.ResourceDescriptor{zbstore.NewObjectResourceDescriptor(path, narHash)},
+			PredicateType: zbstore.SLSAProvenancePredicateType,
+			Predicate: &zbstore.SLSAProvenance{
+				BuildDefinition: zbstore.SLSABuildDefinition{
+					BuildType: zbstore.SLSABuildType,
+					ExternalParameters: &zbstore.SLSAExternalParameters{
+						DerivationPath: "/opt/zb/store/bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb-foo.drv",
+						DerivationHash: narHash,
+						OutputName:     "out",
+					},
+				},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		env.SignEd25519(key)
+		return env
+	}
+
+	t.Run("Trusted", func(t *testing.T) {
+		if _, err := verifyAttestation(path, info, newEnvelope(t, trustedKey), trustedPublicKeys); err != nil {
+			t.Error("verifyAttestation:", err)
+		}
+	})
+	t.Run("Untrusted", func(t *testing.T) {
+		if _, err := verifyAttestation(path, info, newEnvelope(t, otherKey), trustedPublicKeys); err == nil {
+			t.Error("verifyAttestation did not return an error")
+		}
+	})
+	t.Run("NoTrustedKeys", func(t *testing.T) {
+		if _, err := verifyAttestation(path, info, newEnvelope(t, trustedKey), nil); err == nil {
+			t.Error("verifyAttestation did not return an error")
+		}
+	})
+}
@@ -0,0 +1,317 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"zb.256lights.llc/pkg/bytebuffer"
+	"zb.256lights.llc/pkg/internal/frontend"
+	"zb.256lights.llc/pkg/internal/jsonrpc"
+	"zb.256lights.llc/pkg/internal/ociimage"
+	"zb.256lights.llc/pkg/internal/system"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+	"zb.256lights.llc/pkg/sets"
+	"zb.256lights.llc/pkg/zbstore"
+	"zombiezen.com/go/log"
+)
+
+// ociEntrypointVar is the name of the derivation environment variable
+// that specifies the default entrypoint of a container image.
+// Relative paths are resolved against the derivation's output.
+const ociEntrypointVar = "ociEntrypoint"
+
+type bundleCommand struct {
+	Executable bundleExecutableCommand `kong:"cmd,name=exe,default=withargs"`
+	Docker     bundleDockerCommand     `kong:"cmd"`
+}
+
+func (bundleCommand) Signature() string {
+	return `kong:"help=Package build outputs for distribution."`
+}
+
+type bundleDockerCommand struct {
+	evalOptions `kong:"embed"`
+	OutputPath  string   `kong:"name=output,short=o,placeholder=file,help=File to write the image tarball to. (Default: stdout)"`
+	Tag         string   `kong:"placeholder=name:tag,help=Image reference to record in the image."`
+	Entrypoint  []string `kong:"sep=none,placeholder=arg,help=Override the image entrypoint. (Can be passed multiple times to add arguments.)"`
+	OutputName  string   `kong:"name=output-name,default=out,help=Derivation output to use as the image root. (Default: ${default})"`
+	MaxLayers   int      `kong:"default=100,help=Maximum number of layers in the image. Store objects beyond the limit share the last layer. (Default: ${default})"`
+}
+
+func (c *bundleDockerCommand) Signature() string {
+	return `kong:"help=Build a derivation and write an OCI image of its runtime closure that can be loaded with docker load."`
+}
+
+func (c *bundleDockerCommand) Run(ctx context.Context, g *globalConfig) error {
+	if c.MaxLayers < 1 {
+		return fmt.Errorf("--max-layers must be positive")
+	}
+	var platform ociPlatformInfo
+	b, err := buildForBundle(ctx, g, &c.evalOptions, c.OutputName, func(drv *frontend.Derivation) error {
+		var err error
+		platform, err = ociPlatform(drv.System)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	img := &ociimage.Image{
+		Layers: imageLayers(b.closure, c.MaxLayers),
+		Config: ociimage.Config{
+			Architecture: platform.Architecture,
+			OS:           platform.OS,
+			Variant:      platform.Variant,
+			Env:          []string{"PATH=" + b.outputPath.Join("bin")},
+		},
+		Tag: c.Tag,
+	}
+	if len(c.Entrypoint) > 0 {
+		img.Config.Entrypoint = c.Entrypoint
+	} else if ep := b.drv.Env[ociEntrypointVar]; ep != "" {
+		if !path.IsAbs(ep) {
+			ep = b.outputPath.Join(ep)
+		}
+		img.Config.Entrypoint = []string{ep}
+	}
+
+	outputFile, err := openOutputFile(cmp.Or(c.OutputPath, "-"))
+	if err != nil {
+		return err
+	}
+	defer outputFile.Close()
+	err = ociimage.Write(outputFile, img, bytebuffer.TempFileCreator{
+		Pattern: "zb-bundle-*.tar",
+	})
+	if err != nil {
+		return err
+	}
+	return outputFile.Close()
+}
+
+// bundleBuild is a built derivation output to be bundled.
+type bundleBuild struct {
+	drv        *frontend.Derivation
+	outputPath zbstore.Path
+	closure    []*closureObject
+}
+
+// buildForBundle evaluates opts to a single derivation,
+// builds it, and gathers the runtime closure of the named output.
+// checkDerivation is called before building
+// to reject derivations that cannot be bundled.
+func buildForBundle(ctx context.Context, g *globalConfig, opts *evalOptions, outputName string, checkDerivation func(*frontend.Derivation) error) (*bundleBuild, error) {
+	if !strings.HasPrefix(string(g.Directory), "/") {
+		return nil, fmt.Errorf("cannot bundle from %s: store directory must be an absolute Unix path", g.Directory)
+	}
+
+	httpClient, httpCloser, err := g.newHTTPClient()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		httpClient.CloseIdleConnections()
+		if err := httpCloser.Close(); err != nil {
+			log.Warnf(ctx, "%v", err)
+		}
+	}()
+	di := new(zbstorerpc.DeferredImporter)
+	storeClient := g.storeClient(&zbstorerpc.CodecOptions{
+		Importer: di,
+	})
+	defer storeClient.Close()
+	eval, err := opts.newEval(g, httpClient, storeClient, di)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := eval.Close(); err != nil {
+			log.Errorf(ctx, "%v", err)
+		}
+	}()
+
+	var results []any
+	if opts.Expression {
+		results = make([]any, 1)
+		results[0], err = eval.Expression(ctx, opts.Args[0])
+	} else {
+		results, err = eval.URLs(ctx, opts.Args)
+	}
+	if err != nil {
+		return nil, evaluationError(err)
+	}
+	if len(results) != 1 {
+		return nil, fmt.Errorf("evaluation produced %d results (need exactly one derivation)", len(results))
+	}
+	drv, _ := results[0].(*frontend.Derivation)
+	if drv == nil {
+		return nil, fmt.Errorf("%v is not a derivation", results[0])
+	}
+	if err := checkDerivation(drv); err != nil {
+		return nil, err
+	}
+
+	if err := opts.requireStoreSupport(ctx, storeClient); err != nil {
+		return nil, err
+	}
+	realizeResponse := new(zbstorerpc.RealizeResponse)
+	err = jsonrpc.Do(ctx, storeClient, zbstorerpc.RealizeMethod, realizeResponse, &zbstorerpc.RealizeRequest{
+		DrvPaths:       []zbstore.Path{drv.Path},
+		KeepFailed:     opts.KeepFailed,
+		Reuse:          opts.reusePolicy(g),
+		Priority:       opts.Priority,
+		SubstituteOnly: opts.SubstituteOnly,
+		Offline:        opts.Offline,
+	})
+	if err != nil {
+		return nil, err
+	}
+	build, _, err := waitForBuild(ctx, storeClient, realizeResponse.BuildID)
+	if err != nil {
+		return nil, substitutionError(opts.SubstituteOnly, err)
+	}
+	result, err := build.ResultForPath(drv.Path)
+	if err != nil {
+		return nil, err
+	}
+	output, err := result.OutputForName(outputName)
+	if err != nil {
+		return nil, err
+	}
+	if !output.Path.Valid {
+		return nil, fmt.Errorf("%v: not built", zbstore.OutputReference{DrvPath: drv.Path, OutputName: outputName})
+	}
+
+	closure, err := runtimeClosure(ctx, storeClient, output.Path.X)
+	if err != nil {
+		return nil, err
+	}
+	return &bundleBuild{
+		drv:        drv,
+		outputPath: output.Path.X,
+		closure:    closure,
+	}, nil
+}
+
+// closureObject is a store object in a [runtimeClosure].
+type closureObject struct {
+	path       zbstore.Path
+	references []zbstore.Path
+}
+
+// runtimeClosure returns the closure of root
+// with each store object appearing after all of its references.
+func runtimeClosure(ctx context.Context, storeClient jsonrpc.Handler, root zbstore.Path) ([]*closureObject, error) {
+	handshake, err := zbstorerpc.Handshake(ctx, storeClient)
+	if err != nil {
+		return nil, err
+	}
+	batch := handshake.Has(zbstorerpc.CapabilityBatch)
+	objects := make(map[zbstore.Path]*closureObject)
+	queued := sets.New(root)
+	pending := []zbstore.Path{root}
+	for len(pending) > 0 {
+		infos, err := objectInfos(ctx, storeClient, batch, pending)
+		if err != nil {
+			return nil, err
+		}
+		var next []zbstore.Path
+		for i, p := range pending {
+			obj := &closureObject{path: p}
+			for _, ref := range infos[i].References {
+				if ref == p {
+					continue
+				}
+				obj.references = append(obj.references, ref)
+				if !queued.Has(ref) {
+					queued.Add(ref)
+					next = append(next, ref)
+				}
+			}
+			slices.Sort(obj.references)
+			objects[p] = obj
+		}
+		pending = next
+	}
+	return sortClosure(objects, root), nil
+}
+
+// sortClosure returns the objects reachable from root
+// in dependency order.
+func sortClosure(objects map[zbstore.Path]*closureObject, root zbstore.Path) []*closureObject {
+	result := make([]*closureObject, 0, len(objects))
+	visited := make(sets.Set[zbstore.Path])
+	var visit func(p zbstore.Path)
+	visit = func(p zbstore.Path) {
+		if visited.Has(p) {
+			return
+		}
+		visited.Add(p)
+		obj := objects[p]
+		for _, ref := range obj.references {
+			visit(ref)
+		}
+		result = append(result, obj)
+	}
+	visit(root)
+	return result
+}
+
+// imageLayers assigns each object in closure to a layer.
+// Each store object gets its own layer
+// so that layers can be shared between images
+// until maxLayers is reached,
+// at which point the remaining objects are combined into the last layer.
+func imageLayers(closure []*closureObject, maxLayers int) []*ociimage.Layer {
+	layers := make([]*ociimage.Layer, 0, min(len(closure), maxLayers))
+	for _, obj := range closure {
+		if len(layers) < maxLayers {
+			layers = append(layers, new(ociimage.Layer))
+		}
+		last := layers[len(layers)-1]
+		last.Paths = append(last.Paths, string(obj.path))
+	}
+	return layers
+}
+
+// ociPlatformInfo describes the platform of an OCI image.
+type ociPlatformInfo struct {
+	Architecture string
+	OS           string
+	Variant      string
+}
+
+// ociPlatform returns the OCI platform for a derivation's system.
+func ociPlatform(s string) (ociPlatformInfo, error) {
+	sys, err := system.Parse(s)
+	if err != nil {
+		return ociPlatformInfo{}, err
+	}
+	if !sys.OS.IsLinux() {
+		return ociPlatformInfo{}, fmt.Errorf("cannot bundle %s derivation as an image: only Linux is supported", s)
+	}
+	p := ociPlatformInfo{OS: "linux"}
+	switch {
+	case sys.Arch.IsX86() && sys.Arch.Is64Bit():
+		p.Architecture = "amd64"
+	case sys.Arch.IsX86() && sys.Arch.Is32Bit():
+		p.Architecture = "386"
+	case sys.Arch.IsARM() && sys.Arch.Is64Bit():
+		p.Architecture = "arm64"
+		p.Variant = "v8"
+	case sys.Arch.IsARM() && sys.Arch.Is32Bit():
+		p.Architecture = "arm"
+		p.Variant = "v7"
+	case sys.Arch.IsRISCV() && sys.Arch.Is64Bit():
+		p.Architecture = "riscv64"
+	default:
+		return ociPlatformInfo{}, fmt.Errorf("cannot bundle %s derivation as an image: unsupported architecture", s)
+	}
+	return p, nil
+}
@@ -0,0 +1,223 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"zb.256lights.llc/pkg/internal/frontend"
+	"zb.256lights.llc/pkg/internal/selfextract"
+	"zb.256lights.llc/pkg/internal/system"
+	"zb.256lights.llc/pkg/zbstore"
+	"zombiezen.com/go/log"
+)
+
+// mainProgramVar is the name of the meta field
+// (or, for older derivations, the environment variable)
+// that names the program a self-extracting bundle runs.
+const mainProgramVar = "mainProgram"
+
+// bundlePrefixEnvVar is the name of the environment variable
+// that overrides where self-extracting bundles extract to.
+const bundlePrefixEnvVar = "ZB_BUNDLE_PREFIX"
+
+type bundleExecutableCommand struct {
+	evalOptions `kong:"embed"`
+	OutputPath  string `kong:"name=output,short=o,required,placeholder=file,help=File to write the executable to."`
+	OutputName  string `kong:"name=output-name,default=out,help=Derivation output to bundle. (Default: ${default})"`
+	Program     string `kong:"placeholder=name,help=Program to run from the output bin directory or a path relative to the output. (Default: meta.mainProgram derivation attribute)"`
+}
+
+func (c *bundleExecutableCommand) Signature() string {
+	return `kong:"help=Build a derivation and write a self-extracting executable of its runtime closure that runs without zb."`
+}
+
+func (c *bundleExecutableCommand) Run(ctx context.Context, g *globalConfig) error {
+	stub, err := openStub()
+	if err != nil {
+		return err
+	}
+	defer stub.Close()
+
+	b, err := buildForBundle(ctx, g, &c.evalOptions, c.OutputName, checkBundleSystem)
+	if err != nil {
+		return err
+	}
+	program, err := bundleProgram(b, c.Program)
+	if err != nil {
+		return err
+	}
+	m := &selfextract.Manifest{
+		StoreDirectory: g.Directory,
+		Objects:        make([]zbstore.Path, 0, len(b.closure)),
+		Program:        program,
+	}
+	for _, obj := range b.closure {
+		m.Objects = append(m.Objects, obj.path)
+	}
+
+	f, err := os.OpenFile(c.OutputPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o777)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := selfextract.Write(f, stub, m); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// checkBundleSystem returns an error if the derivation cannot run on this machine.
+// Self-extracting bundles use the running zb executable as their stub,
+// so the bundle's contents must match the platform.
+func checkBundleSystem(drv *frontend.Derivation) error {
+	sys, err := system.Parse(drv.System)
+	if err != nil {
+		return err
+	}
+	current := system.Current()
+	if sys.Arch != current.Arch || sys.OS != current.OS {
+		return fmt.Errorf("cannot bundle %s derivation as an executable on %v", drv.System, current)
+	}
+	return nil
+}
+
+// bundleProgram returns the path of the program that a bundle runs.
+// A name without slashes is looked up in the output's bin directory.
+// Any other name is interpreted relative to the output.
+func bundleProgram(b *bundleBuild, name string) (string, error) {
+	if name == "" {
+		name = cmp.Or(b.drv.Meta.MainProgram, b.drv.Env[mainProgramVar])
+	}
+	if name == "" {
+		entries, err := os.ReadDir(b.outputPath.Join("bin"))
+		if err != nil || len(entries) != 1 {
+			return "", fmt.Errorf("%s: cannot determine program to run (use --program or set meta.%s)", b.outputPath, mainProgramVar)
+		}
+		name = entries[0].Name()
+	}
+	if !strings.Contains(name, "/") {
+		return b.outputPath.Join("bin", name), nil
+	}
+	if filepath.IsAbs(name) {
+		return "", fmt.Errorf("program %s must be relative to the output", name)
+	}
+	return b.outputPath.Join(name), nil
+}
+
+// openStub opens the running executable
+// limited to the portion that is not a bundle payload.
+func openStub() (io.ReadCloser, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(exe)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	size := info.Size()
+	if b, err := selfextract.Open(f, size); err == nil {
+		size = b.StubSize()
+	} else if !errors.Is(err, selfextract.ErrNotBundle) {
+		f.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.NewSectionReader(f, 0, size), f}, nil
+}
+
+// runEmbeddedBundle checks whether the running executable is a self-extracting bundle.
+// If it is, runEmbeddedBundle extracts the bundle, runs its program,
+// and returns the program's exit code.
+func runEmbeddedBundle() (exitCode int, isBundle bool) {
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, false
+	}
+	f, err := os.Open(exe)
+	if err != nil {
+		return 0, false
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, false
+	}
+	b, err := selfextract.Open(f, info.Size())
+	if errors.Is(err, selfextract.ErrNotBundle) {
+		return 0, false
+	}
+	initLogging(false)
+	ctx := context.Background()
+	if err != nil {
+		log.Errorf(ctx, "%v", err)
+		return 1, true
+	}
+	dir, err := bundleExtractDirectory(b)
+	if err != nil {
+		log.Errorf(ctx, "%v", err)
+		return 1, true
+	}
+	program, err := b.Extract(dir)
+	if err != nil {
+		log.Errorf(ctx, "%v", err)
+		return 1, true
+	}
+	f.Close()
+
+	// The program receives interrupts directly from the terminal.
+	signal.Ignore(interruptSignals...)
+	c := exec.Command(program, os.Args[1:]...)
+	c.Stdin = os.Stdin
+	c.Stdout = os.Stdout
+	c.Stderr = os.Stderr
+	if err := c.Run(); err != nil {
+		if exitErr := (*exec.ExitError)(nil); errors.As(err, &exitErr) && exitErr.ExitCode() >= 0 {
+			return exitErr.ExitCode(), true
+		}
+		log.Errorf(ctx, "%v", err)
+		return 1, true
+	}
+	return 0, true
+}
+
+// bundleExtractDirectory returns the directory to extract b to.
+// It uses $ZB_BUNDLE_PREFIX if set,
+// otherwise the first of ~/.zb or a per-user temporary directory
+// that is short enough to replace the bundle's store directory.
+func bundleExtractDirectory(b *selfextract.Bundle) (string, error) {
+	if prefix := os.Getenv(bundlePrefixEnvVar); prefix != "" {
+		return b.ExtractDirectory(prefix)
+	}
+	var candidates []string
+	if home, err := os.UserHomeDir(); err == nil {
+		candidates = append(candidates, filepath.Join(home, ".zb"))
+	}
+	candidates = append(candidates, filepath.Join(os.TempDir(), "zb-"+strconv.Itoa(os.Getuid())))
+	for _, prefix := range candidates {
+		if dir, err := b.ExtractDirectory(prefix); err == nil {
+			return dir, nil
+		}
+	}
+	return "", fmt.Errorf("no extraction directory shorter than %s found (set %s)",
+		b.Manifest.StoreDirectory, bundlePrefixEnvVar)
+}
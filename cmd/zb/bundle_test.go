@@ -0,0 +1,113 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"zb.256lights.llc/pkg/internal/frontend"
+	"zb.256lights.llc/pkg/zbstore"
+)
+
+func TestImageLayers(t *testing.T) {
+	const (
+		libc zbstore.Path = "/opt/zb/store/aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa-libc"
+		libz zbstore.Path = "/opt/zb/store/bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb-libz"
+		app  zbstore.Path = "/opt/zb/store/cccccccccccccccccccccccccccccccc-app"
+	)
+	objects := map[zbstore.Path]*closureObject{
+		libc: {path: libc},
+		libz: {path: libz, references: []zbstore.Path{libc}},
+		app:  {path: app, references: []zbstore.Path{libc, libz}},
+	}
+	closure := sortClosure(objects, app)
+
+	tests := []struct {
+		maxLayers int
+		want      [][]string
+	}{
+		{
+			maxLayers: 100,
+			want:      [][]string{{string(libc)}, {string(libz)}, {string(app)}},
+		},
+		{
+			maxLayers: 2,
+			want:      [][]string{{string(libc)}, {string(libz), string(app)}},
+		},
+		{
+			maxLayers: 1,
+			want:      [][]string{{string(libc), string(libz), string(app)}},
+		},
+	}
+	for _, test := range tests {
+		var got [][]string
+		for _, layer := range imageLayers(closure, test.maxLayers) {
+			got = append(got, layer.Paths)
+		}
+		if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("imageLayers(closure, %d) (-want +got):\n%s", test.maxLayers, diff)
+		}
+	}
+}
+
+func TestOCIPlatform(t *testing.T) {
+	tests := []struct {
+		system  string
+		want    ociPlatformInfo
+		wantErr bool
+	}{
+		{system: "x86_64-linux", want: ociPlatformInfo{Architecture: "amd64", OS: "linux"}},
+		{system: "aarch64-linux", want: ociPlatformInfo{Architecture: "arm64", OS: "linux", Variant: "v8"}},
+		{system: "i686-linux", want: ociPlatformInfo{Architecture: "386", OS: "linux"}},
+		{system: "x86_64-macos", wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := ociPlatform(test.system)
+		if err != nil {
+			if !test.wantErr {
+				t.Errorf("ociPlatform(%q): %v", test.system, err)
+			}
+			continue
+		}
+		if test.wantErr {
+			t.Errorf("ociPlatform(%q) = %+v, <nil>; want error", test.system, got)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("ociPlatform(%q) = %+v; want %+v", test.system, got, test.want)
+		}
+	}
+}
+
+func TestBundleProgram(t *testing.T) {
+	out := zbstore.Path("/opt/zb/store/aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa-hello-1.0")
+	b := &bundleBuild{
+		drv: &frontend.Derivation{
+			Derivation: &zbstore.Derivation{
+				Env: map[string]string{mainProgramVar: "hello"},
+			},
+		},
+		outputPath: out,
+	}
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "", want: out.Join("bin", "hello")},
+		{name: "greet", want: out.Join("bin", "greet")},
+		{name: "libexec/hello", want: out.Join("libexec", "hello")},
+	}
+	for _, test := range tests {
+		got, err := bundleProgram(b, test.name)
+		if got != test.want || err != nil {
+			t.Errorf("bundleProgram(b, %q) = %q, %v; want %q, <nil>", test.name, got, err, test.want)
+		}
+	}
+	// meta.mainProgram takes precedence over the environment variable.
+	b.drv.Meta.MainProgram = "hi"
+	if got, err := bundleProgram(b, ""); got != out.Join("bin", "hi") || err != nil {
+		t.Errorf("with meta.mainProgram, bundleProgram(b, \"\") = %q, %v; want %q, <nil>", got, err, out.Join("bin", "hi"))
+	}
+}
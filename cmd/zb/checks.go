@@ -0,0 +1,68 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"zb.256lights.llc/pkg/internal/frontend"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+	"zb.256lights.llc/pkg/zbstore"
+	"zombiezen.com/go/log"
+)
+
+// checkDrvPaths returns the store paths of the given checks
+// that are not already in drvPaths.
+func checkDrvPaths(drvPaths []zbstore.Path, checks [][]*frontend.Check) []zbstore.Path {
+	seen := make(map[zbstore.Path]struct{}, len(drvPaths))
+	for _, drvPath := range drvPaths {
+		seen[drvPath] = struct{}{}
+	}
+	var result []zbstore.Path
+	for _, drvChecks := range checks {
+		for _, check := range drvChecks {
+			if _, dup := seen[check.Path]; dup {
+				continue
+			}
+			seen[check.Path] = struct{}{}
+			result = append(result, check.Path)
+		}
+	}
+	return result
+}
+
+// checkBuildError distinguishes failed checks from failed derivations
+// in a build started by zb build --with-checks.
+// If every derivation in drvPaths was built successfully
+// but one or more of the checks were not,
+// then checkBuildError logs the failed checks
+// and returns an error with [exitCheck].
+// Otherwise, checkBuildError returns buildError unchanged.
+func checkBuildError(ctx context.Context, build *zbstorerpc.Build, drvPaths []zbstore.Path, checks [][]*frontend.Check, buildError error) error {
+	if build == nil || buildError == nil {
+		return buildError
+	}
+	for _, drvPath := range drvPaths {
+		if result, err := build.ResultForPath(drvPath); err != nil || result.Status != zbstorerpc.BuildSuccess {
+			return buildError
+		}
+	}
+
+	total, failed := 0, 0
+	for i, drvChecks := range checks {
+		for _, check := range drvChecks {
+			total++
+			if result, err := build.ResultForPath(check.Path); err == nil && result.Status == zbstorerpc.BuildSuccess {
+				continue
+			}
+			failed++
+			log.Errorf(ctx, "Check %s of %s failed (%s)", check.Name, drvPaths[i], check.Path)
+		}
+	}
+	if failed == 0 {
+		return buildError
+	}
+	return withExitCode(exitCheck, fmt.Errorf("%d of %d checks failed", failed, total))
+}
@@ -0,0 +1,80 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"zb.256lights.llc/pkg/internal/frontend"
+	"zb.256lights.llc/pkg/internal/testcontext"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+	"zb.256lights.llc/pkg/zbstore"
+)
+
+func TestCheckBuildError(t *testing.T) {
+	const (
+		pkg   zbstore.Path = "/opt/zb/store/aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa-pkg.drv"
+		unit  zbstore.Path = "/opt/zb/store/bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb-unit.drv"
+		smoke zbstore.Path = "/opt/zb/store/cccccccccccccccccccccccccccccccc-smoke.drv"
+	)
+	checks := [][]*frontend.Check{{
+		{Name: "smoke", Derivation: &frontend.Derivation{Path: smoke}},
+		{Name: "unit", Derivation: &frontend.Derivation{Path: unit}},
+	}}
+	if got, want := checkDrvPaths([]zbstore.Path{pkg, unit}, checks), []zbstore.Path{smoke}; !cmp.Equal(got, want) {
+		t.Errorf("checkDrvPaths(...) = %v; want %v", got, want)
+	}
+
+	buildError := withExitCode(exitBuild, errors.New("build failed"))
+	tests := []struct {
+		name     string
+		statuses map[zbstore.Path]zbstorerpc.BuildStatus
+		want     exitCode
+	}{
+		{
+			name: "PackageFailed",
+			statuses: map[zbstore.Path]zbstorerpc.BuildStatus{
+				pkg:   zbstorerpc.BuildFail,
+				unit:  zbstorerpc.BuildFail,
+				smoke: zbstorerpc.BuildSuccess,
+			},
+			want: exitBuild,
+		},
+		{
+			name: "CheckFailed",
+			statuses: map[zbstore.Path]zbstorerpc.BuildStatus{
+				pkg:   zbstorerpc.BuildSuccess,
+				unit:  zbstorerpc.BuildFail,
+				smoke: zbstorerpc.BuildSuccess,
+			},
+			want: exitCheck,
+		},
+		{
+			name: "CheckNotAttempted",
+			statuses: map[zbstore.Path]zbstorerpc.BuildStatus{
+				pkg:  zbstorerpc.BuildSuccess,
+				unit: zbstorerpc.BuildSuccess,
+			},
+			want: exitCheck,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := testcontext.New(t)
+			build := &zbstorerpc.Build{Status: zbstorerpc.BuildFail}
+			for drvPath, status := range test.statuses {
+				build.Results = append(build.Results, &zbstorerpc.BuildResult{
+					DrvPath: drvPath,
+					Status:  status,
+				})
+			}
+			err := checkBuildError(ctx, build, []zbstore.Path{pkg}, checks, buildError)
+			if got := errorExitCode(err); got != test.want {
+				t.Errorf("errorExitCode(checkBuildError(...)) = %v; want %v (error: %v)", got, test.want, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,225 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/posener/complete"
+	"zb.256lights.llc/pkg/internal/frontend"
+	"zb.256lights.llc/pkg/zbstore"
+)
+
+// Predictor names used in completion-predictor struct tags.
+const (
+	installablePredictor = "installable"
+	storePathPredictor   = "storepath"
+	filePredictor        = "file"
+	dirPredictor         = "dir"
+	shellPredictor       = "shell"
+)
+
+type completionCommand struct {
+	Shell string `kong:"arg,optional,placeholder=SHELL,completion-predictor=shell,help=One of bash or zsh or fish or powershell. (Default: detected from the SHELL environment variable)"`
+}
+
+func (c *completionCommand) Signature() string {
+	return `kong:"help=Print a script that enables tab completion for zb. Source its output in your shell startup file."`
+}
+
+func (c *completionCommand) Run(ctx context.Context) error {
+	shell := c.Shell
+	if shell == "" {
+		shell = detectShell()
+	}
+	tmpl := completionScripts[shell]
+	if tmpl == nil {
+		return fmt.Errorf("unsupported shell %q (must be one of bash, zsh, fish, or powershell)", shell)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	return tmpl.Execute(os.Stdout, exe)
+}
+
+// detectShell returns the name of the user's shell.
+func detectShell() string {
+	if sh := os.Getenv("SHELL"); sh != "" {
+		return strings.TrimSuffix(filepath.Base(sh), ".exe")
+	}
+	if runtime.GOOS == "windows" {
+		return "powershell"
+	}
+	return "bash"
+}
+
+// completionScripts is the map of shell names to templates
+// of scripts that register zb for completion.
+// Each script sets $COMP_LINE and $COMP_POINT and runs zb,
+// which prints the completions instead of running a command.
+// The templates are executed with the path to the zb executable.
+var completionScripts = map[string]*template.Template{
+	"bash": template.Must(template.New("bash").Funcs(completionFuncs).Parse(
+		"complete -o default -o bashdefault -C {{ posixQuote . }} zb\n",
+	)),
+	"zsh": template.Must(template.New("zsh").Funcs(completionFuncs).Parse(
+		"autoload -U +X bashcompinit && bashcompinit\n" +
+			"complete -o default -o bashdefault -C {{ posixQuote . }} zb\n",
+	)),
+	"fish": template.Must(template.New("fish").Funcs(completionFuncs).Parse(`function __complete_zb
+    set -lx COMP_LINE (commandline -cp)
+    test -z (commandline -ct)
+    and set COMP_LINE "$COMP_LINE "
+    {{ posixQuote . }}
+end
+complete -f -c zb -a "(__complete_zb)"
+`)),
+	"powershell": template.Must(template.New("powershell").Funcs(completionFuncs).Parse(`Register-ArgumentCompleter -Native -CommandName zb -ScriptBlock {
+    param($wordToComplete, $commandAst, $cursorPosition)
+    $point = $cursorPosition - $commandAst.Extent.StartOffset
+    $line = $commandAst.ToString().PadRight($point)
+    $env:COMP_LINE = $line
+    $env:COMP_POINT = $point
+    try {
+        & {{ powershellQuote . }} | ForEach-Object {
+            [System.Management.Automation.CompletionResult]::new($_, $_, 'ParameterValue', $_)
+        }
+    } finally {
+        Remove-Item Env:COMP_LINE, Env:COMP_POINT -ErrorAction SilentlyContinue
+    }
+}
+`)),
+}
+
+var completionFuncs = template.FuncMap{
+	"posixQuote": func(s string) string {
+		return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+	},
+	"powershellQuote": func(s string) string {
+		return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+	},
+}
+
+// completionPredictors returns the predictors
+// for the completion-predictor struct tags used in zb's commands.
+func completionPredictors() map[string]complete.Predictor {
+	return map[string]complete.Predictor{
+		installablePredictor: complete.PredictFunc(predictInstallables),
+		storePathPredictor:   complete.PredictFunc(predictStorePaths),
+		filePredictor:        complete.PredictFiles("*"),
+		dirPredictor:         complete.PredictDirs("*"),
+		shellPredictor:       complete.PredictSet(slices.Sorted(maps.Keys(completionScripts))...),
+	}
+}
+
+// completionTimeout is the maximum amount of time
+// that a predictor spends reading from databases.
+const completionTimeout = 2 * time.Second
+
+// predictInstallables predicts Lua files
+// and attribute paths that were indexed by zb search.
+func predictInstallables(a complete.Args) []string {
+	source, fragment, hasFragment := strings.Cut(a.Last, "#")
+	if !hasFragment {
+		return complete.PredictFiles("*.lua").Predict(a)
+	}
+	absSource, err := absoluteURL(source)
+	if err != nil {
+		complete.Log("%v", err)
+		return nil
+	}
+	g := completionConfig(a)
+	ctx, cancel := context.WithTimeout(context.Background(), completionTimeout)
+	defer cancel()
+	attrPaths, err := frontend.IndexedAttrPaths(ctx, g.CacheDB, absSource)
+	if err != nil {
+		complete.Log("%v", err)
+		return nil
+	}
+	return completeAttrPaths(source, fragment, attrPaths)
+}
+
+// completeAttrPaths returns the installables for source
+// whose attribute paths start with fragment.
+// attrPaths are slash-separated.
+// If fragment uses dots instead of slashes to separate fields,
+// then the results do too.
+func completeAttrPaths(source, fragment string, attrPaths []string) []string {
+	dotted := strings.Contains(fragment, ".") && !strings.Contains(fragment, "/")
+	var result []string
+	for _, p := range attrPaths {
+		if dotted {
+			p = strings.ReplaceAll(p, "/", ".")
+		}
+		if strings.HasPrefix(p, fragment) {
+			result = append(result, source+"#"+p)
+		}
+	}
+	return result
+}
+
+// predictStorePaths predicts the paths of objects in the store directory.
+func predictStorePaths(a complete.Args) []string {
+	g := completionConfig(a)
+	entries, err := os.ReadDir(string(g.Directory))
+	if err != nil {
+		complete.Log("%v", err)
+		return nil
+	}
+	var result []string
+	for _, ent := range entries {
+		p, err := g.Directory.Object(ent.Name())
+		if err != nil {
+			continue
+		}
+		result = append(result, string(p))
+	}
+	return result
+}
+
+// completionConfig returns the configuration for the command line being completed.
+// Only configuration files, the environment,
+// and the --config, --store, and --cache flags are consulted.
+func completionConfig(a complete.Args) *globalConfig {
+	g := defaultGlobalConfig()
+	if err := g.mergeFiles(configFilePaths(completionFlagValues(a.Completed, "--config"))); err != nil {
+		complete.Log("%v", err)
+	}
+	if err := g.mergeEnvironment(); err != nil {
+		complete.Log("%v", err)
+	}
+	if v := completionFlagValues(a.Completed, "--store"); len(v) > 0 {
+		if dir, err := zbstore.CleanDirectory(v[len(v)-1]); err == nil {
+			g.Directory = dir
+		}
+	}
+	if v := completionFlagValues(a.Completed, "--cache"); len(v) > 0 {
+		g.CacheDB = v[len(v)-1]
+	}
+	return g
+}
+
+// completionFlagValues returns the values of the flag with the given name
+// in a partial command line.
+func completionFlagValues(args []string, name string) []string {
+	var values []string
+	for i, arg := range args {
+		if v, ok := strings.CutPrefix(arg, name+"="); ok {
+			values = append(values, v)
+		} else if arg == name && i+1 < len(args) {
+			values = append(values, args[i+1])
+		}
+	}
+	return values
+}
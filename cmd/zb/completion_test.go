@@ -0,0 +1,77 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/kong"
+	kongcompletion "github.com/jotaen/kong-completion"
+)
+
+func TestCompletionPredictors(t *testing.T) {
+	k, err := kong.New(new(zbCommand), zbKongOption())
+	if err != nil {
+		t.Fatal("kong.New:", err)
+	}
+	if _, err := kongcompletion.Command(k, kongcompletion.WithPredictors(completionPredictors())); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCompletionScripts(t *testing.T) {
+	const exe = "/opt/it's/zb"
+	for _, shell := range []string{"bash", "zsh", "fish", "powershell"} {
+		tmpl := completionScripts[shell]
+		if tmpl == nil {
+			t.Errorf("no script for %s", shell)
+			continue
+		}
+		sb := new(strings.Builder)
+		if err := tmpl.Execute(sb, exe); err != nil {
+			t.Errorf("%s: %v", shell, err)
+			continue
+		}
+		want := `'/opt/it'\''s/zb'`
+		if shell == "powershell" {
+			want = `'/opt/it''s/zb'`
+		}
+		if !strings.Contains(sb.String(), want) {
+			t.Errorf("%s script does not contain %s:\n%s", shell, want, sb)
+		}
+	}
+}
+
+func TestCompleteAttrPaths(t *testing.T) {
+	attrPaths := []string{"hello", "tools/goodbye", "tools/gcc"}
+	tests := []struct {
+		fragment string
+		want     []string
+	}{
+		{"", []string{"foo.lua#hello", "foo.lua#tools/goodbye", "foo.lua#tools/gcc"}},
+		{"he", []string{"foo.lua#hello"}},
+		{"tools", []string{"foo.lua#tools/goodbye", "foo.lua#tools/gcc"}},
+		{"tools/g", []string{"foo.lua#tools/goodbye", "foo.lua#tools/gcc"}},
+		{"tools.go", []string{"foo.lua#tools.goodbye"}},
+		{"tools.", []string{"foo.lua#tools.goodbye", "foo.lua#tools.gcc"}},
+		{"nope", nil},
+	}
+	for _, test := range tests {
+		if got := completeAttrPaths("foo.lua", test.fragment, attrPaths); !slices.Equal(got, test.want) {
+			t.Errorf("completeAttrPaths(\"foo.lua\", %q, %q) = %q; want %q", test.fragment, attrPaths, got, test.want)
+		}
+	}
+}
+
+func TestCompletionFlagValues(t *testing.T) {
+	args := []string{"--store=/a", "build", "--cache", "/b.db", "--store", "/c", "--store"}
+	if got, want := completionFlagValues(args, "--store"), []string{"/a", "/c"}; !slices.Equal(got, want) {
+		t.Errorf("completionFlagValues(args, \"--store\") = %q; want %q", got, want)
+	}
+	if got, want := completionFlagValues(args, "--cache"), []string{"/b.db"}; !slices.Equal(got, want) {
+		t.Errorf("completionFlagValues(args, \"--cache\") = %q; want %q", got, want)
+	}
+}
@@ -15,19 +15,20 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
-	"cloud.google.com/go/auth/credentials"
+	gcpcredentials "cloud.google.com/go/auth/credentials"
 	"cloud.google.com/go/auth/httptransport"
 	"cloud.google.com/go/storage"
 	jsonv2 "github.com/go-json-experiment/json"
 	"github.com/go-json-experiment/json/jsontext"
 	"github.com/tailscale/hujson"
 	"google.golang.org/api/option"
-	"zb.256lights.llc/pkg/bytebuffer"
 	"zb.256lights.llc/pkg/internal/althttp"
 	"zb.256lights.llc/pkg/internal/backend"
+	"zb.256lights.llc/pkg/internal/credentials"
 	"zb.256lights.llc/pkg/internal/fileurl"
 	"zb.256lights.llc/pkg/internal/httpcache"
 	"zb.256lights.llc/pkg/internal/jsonrpc"
@@ -41,14 +42,18 @@ import (
 // More details at https://main--zb-docs.netlify.app/configuration
 type globalConfig struct {
 	Debug             bool                            `json:"debug" kong:"help=Show debugging output."`
-	Directory         zbstore.Directory               `json:"storeDirectory" kong:"name=store,default=${default_store_dir},help=Store directory"`
-	StoreSocket       string                          `json:"storeSocket" kong:"default=${default_store_socket},help=Server socket"`
+	Directory         zbstore.Directory               `json:"storeDirectory" kong:"name=store,default=${default_store_dir},completion-predictor=dir,help=Store directory"`
+	StoreSocket       string                          `json:"storeSocket" kong:"default=${default_store_socket},completion-predictor=file,help=Server socket"`
 	NetrcPath         string                          `json:"netrcFile,omitempty" kong:"name=netrc-file,default=${netrc},help=Use HTTP credentials from the given path."`
+	CredentialHelper  []string                        `json:"credentialHelper,omitempty" kong:"-"`
 	CacheDB           string                          `json:"cacheDB" kong:"name=cache,default=${cache_db},help=Cache database"`
 	HTTPCacheDB       string                          `json:"httpCache" kong:"name=http-cache,default=${http_cache},help=Cache HTTP responses in the given file."`
 	AllowEnv          stringAllowList                 `json:"allowEnvironment" kong:"-"`
+	EntryFiles        []string                        `json:"entryFiles,omitempty" kong:"-"`
 	TrustedPublicKeys []*zbstore.RealizationPublicKey `json:"trustedPublicKeys" kong:"-"`
 	Server            serverConfig                    `json:"server,omitzero" kong:"-"`
+	ErrorFormat       string                          `json:"-" kong:"enum='text,json',default=text,help=Format of warnings and the final error message: text or json. (Default: ${default})"`
+	ShowTrace         bool                            `json:"-" kong:"help=Include Lua stack tracebacks in evaluation errors."`
 }
 
 // defaultGlobalConfig returns a [globalConfig] populated with values
@@ -160,6 +165,9 @@ func (g *globalConfig) resolveRelativePaths(dir string, prev *globalConfig) {
 	if prev == nil || g.NetrcPath != prev.NetrcPath {
 		g.NetrcPath = resolve(g.NetrcPath)
 	}
+	if caBundle := g.Server.Network.CABundle; caBundle != "" && (prev == nil || caBundle != prev.Server.Network.CABundle) {
+		g.Server.Network.CABundle = resolve(caBundle)
+	}
 	if prev == nil || !g.Server.Download.Equal(prev.Server.Download) {
 		if baseURL := dirToURL(); baseURL != nil {
 			g.Server.Download = g.Server.Download.resolve(baseURL)
@@ -222,6 +230,11 @@ func (g *globalConfig) UnmarshalJSONFrom(in *jsontext.Decoder) error {
 			if err := jsonv2.UnmarshalDecode(in, &g.AllowEnv); err != nil {
 				return fmt.Errorf("unmarshal config.allowEnvironment: %w", err)
 			}
+		case "entryFiles":
+			g.EntryFiles = nil
+			if err := jsonv2.UnmarshalDecode(in, &g.EntryFiles); err != nil {
+				return fmt.Errorf("unmarshal config.entryFiles: %w", err)
+			}
 		case "trustedPublicKeys":
 			// Use any unused capacity at end of the slice.
 			newKeys := g.TrustedPublicKeys[len(g.TrustedPublicKeys):]
@@ -234,6 +247,11 @@ func (g *globalConfig) UnmarshalJSONFrom(in *jsontext.Decoder) error {
 			if err := jsonv2.UnmarshalDecode(in, &g.NetrcPath); err != nil {
 				return fmt.Errorf("unmarshal config.netrcFile: %w", err)
 			}
+		case "credentialHelper":
+			g.CredentialHelper = nil
+			if err := jsonv2.UnmarshalDecode(in, &g.CredentialHelper); err != nil {
+				return fmt.Errorf("unmarshal config.credentialHelper: %w", err)
+			}
 		case "server":
 			if err := jsonv2.UnmarshalDecode(in, &g.Server); err != nil {
 				return fmt.Errorf("unmarshal config.server: %w", err)
@@ -272,18 +290,9 @@ func (g *globalConfig) reusePolicy() *zbstorerpc.ReusePolicy {
 }
 
 func (g *globalConfig) newHTTPClient() (*httpClient, io.Closer, error) {
-	baseTransport := &http.Transport{
-		// Settings copied from [http.DefaultTransport].
-		Proxy: http.ProxyFromEnvironment,
-		DialContext: (&net.Dialer{
-			Timeout:   30 * time.Second,
-			KeepAlive: 30 * time.Second,
-		}).DialContext,
-		ForceAttemptHTTP2:     true,
-		MaxIdleConns:          100,
-		IdleConnTimeout:       90 * time.Second,
-		TLSHandshakeTimeout:   10 * time.Second,
-		ExpectContinueTimeout: 1 * time.Second,
+	baseTransport, err := g.Server.Network.newTransport()
+	if err != nil {
+		return nil, nil, err
 	}
 	baseTransport.RegisterProtocol(althttp.GCSScheme, g.newGCSTransport(baseTransport))
 
@@ -298,26 +307,42 @@ func (g *globalConfig) newHTTPClient() (*httpClient, io.Closer, error) {
 			}
 		}),
 	})
-	client := &httpClient{
-		Transport: cache,
-	}
-	if g.NetrcPath == "" {
-		return client, cache, nil
-	}
-	netrcData, err := os.ReadFile(g.NetrcPath)
-	if errors.Is(err, os.ErrNotExist) {
-		return client, cache, nil
-	}
+	creds, err := g.credentials()
 	if err != nil {
 		cache.Close()
-		return nil, nil, fmt.Errorf("open netrc file: %v", err)
+		return nil, nil, err
+	}
+	client := &httpClient{
+		Transport:   cache,
+		Credentials: creds,
 	}
-	client.Netrc = netrcData
 	return client, cache, nil
 }
 
+// credentials returns the provider of credentials for authenticated HTTP requests.
+// Tokens in environment variables take precedence over the netrc file,
+// which takes precedence over the credential helper.
+func (g *globalConfig) credentials() (credentials.Provider, error) {
+	chain := credentials.Chain{credentials.Environment{}}
+	if g.NetrcPath != "" {
+		netrcData, err := os.ReadFile(g.NetrcPath)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("open netrc file: %v", err)
+		}
+		if len(netrcData) > 0 {
+			chain = append(chain, credentials.Netrc(netrcData))
+		}
+	}
+	if len(g.CredentialHelper) > 0 {
+		chain = append(chain, &credentials.Helper{
+			Command: slices.Clone(g.CredentialHelper),
+		})
+	}
+	return chain, nil
+}
+
 func (g *globalConfig) newGCSTransport(baseRoundTripper http.RoundTripper) http.RoundTripper {
-	adc, detectADCError := credentials.DetectDefault(&credentials.DetectOptions{
+	adc, detectADCError := gcpcredentials.DetectDefault(&gcpcredentials.DetectOptions{
 		Scopes: []string{storage.ScopeReadWrite},
 		Client: &http.Client{Transport: baseRoundTripper},
 	})
@@ -364,13 +389,39 @@ func (t *fileSplitTransport) RoundTrip(req *http.Request) (*http.Response, error
 	return t.fallback.RoundTrip(req)
 }
 
+// storeMethodTimeout is the maximum amount of time
+// that zb waits for the store to answer a request
+// that only reads or writes metadata.
+// Methods that can wait on builds or transfer store objects do not time out.
+const storeMethodTimeout = 1 * time.Minute
+
 func (g *globalConfig) storeClient(opts *zbstorerpc.CodecOptions) *jsonrpc.Client {
+	codecOptions := new(zbstorerpc.CodecOptions)
+	if opts != nil {
+		*codecOptions = *opts
+	}
+	codecOptions.Msgpack = true
+	methodTimeouts := make(map[string]time.Duration)
+	for _, method := range zbstorerpc.MetadataMethods() {
+		methodTimeouts[method] = storeMethodTimeout
+	}
 	return jsonrpc.NewClient(func(ctx context.Context) (jsonrpc.ClientCodec, error) {
 		conn, err := (&net.Dialer{}).DialContext(ctx, "unix", g.StoreSocket)
 		if err != nil {
 			return nil, err
 		}
-		return zbstorerpc.NewCodec(conn, opts), nil
+		return zbstorerpc.NewCodec(conn, codecOptions), nil
+	}, &jsonrpc.ClientOptions{
+		MethodTimeouts: methodTimeouts,
+		Replay:         zbstorerpc.IsReadOnlyMethod,
+		Renegotiate: func(ctx context.Context, h jsonrpc.Handler) error {
+			resp, err := zbstorerpc.Handshake(ctx, h)
+			if err != nil {
+				return err
+			}
+			log.Debugf(ctx, "Reconnected to store (protocol version %d)", resp.ProtocolVersion)
+			return nil
+		},
 	})
 }
 
@@ -459,8 +510,11 @@ func (sc *storeConfig) toStore(deps *storeDeps) (backend.Store, error) {
 			return nil, err
 		}
 		store := &zbstorehttp.Store{
-			HTTPClient: client,
-			CreateTemp: bytebuffer.TempFileCreator{Pattern: contentAddressTempFilePattern},
+			HTTPClient:             client,
+			CreateTemp:             contentAddressBufferCreator(),
+			MaxConcurrentDownloads: props.MaxConcurrentDownloads,
+			MaxRequestsPerSecond:   props.MaxRequestsPerSecond,
+			DownloadRetries:        props.DownloadRetries,
 		}
 		store.URL, err = url.Parse(props.URL)
 		if err != nil {
@@ -508,6 +562,11 @@ func (sc *storeConfig) resolve(base *url.URL) *storeConfig {
 // storeConfigHTTPProperties is the set of properties in [storeConfig] for the "http" type.
 type storeConfigHTTPProperties struct {
 	URL string `json:"url"`
+
+	// Download limits. See [zbstorehttp.Store] for details.
+	MaxConcurrentDownloads int     `json:"maxConcurrentDownloads,omitzero"`
+	MaxRequestsPerSecond   float64 `json:"maxRequestsPerSecond,omitzero"`
+	DownloadRetries        int     `json:"downloadRetries,omitzero"`
 }
 
 // defaultVarDir returns "/opt/zb/var/zb" on Unix-like systems or `C:\zb\var\zb` on Windows systems.
@@ -0,0 +1,375 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"text/tabwriter"
+
+	jsonv2 "github.com/go-json-experiment/json"
+	"github.com/go-json-experiment/json/jsontext"
+	"github.com/tailscale/hujson"
+)
+
+// configKeys is the list of top-level configuration file keys
+// in the order they are displayed.
+var configKeys = []string{
+	"debug",
+	"storeDirectory",
+	"storeSocket",
+	"cacheDB",
+	"httpCache",
+	"netrcFile",
+	"credentialHelper",
+	"allowEnvironment",
+	"entryFiles",
+	"trustedPublicKeys",
+	"server",
+}
+
+// configEnvVars maps the environment variables
+// read by [*globalConfig.mergeEnvironment]
+// to the configuration keys they set.
+var configEnvVars = map[string]string{
+	"ZB_STORE_DIR":    "storeDirectory",
+	"ZB_STORE_SOCKET": "storeSocket",
+	"NETRC":           "netrcFile",
+}
+
+type configCommand struct {
+	Show  configShowCommand  `kong:"cmd"`
+	Get   configGetCommand   `kong:"cmd"`
+	Set   configSetCommand   `kong:"cmd"`
+	Unset configUnsetCommand `kong:"cmd"`
+	Path  configPathCommand  `kong:"cmd"`
+}
+
+func (configCommand) Signature() string {
+	return `kong:"help=Inspect and edit zb configuration."`
+}
+
+type configShowCommand struct {
+	JSONFormat bool `kong:"name=json,help=Print the configuration as JSON."`
+}
+
+func (c *configShowCommand) Signature() string {
+	return `kong:"help=Print the effective configuration and where each setting came from."`
+}
+
+func (c *configShowCommand) Run(ctx context.Context, g *globalConfig, zb *zbCommand) error {
+	settings, err := configSettings(g, zb.ExtraConfigs)
+	if err != nil {
+		return err
+	}
+	if c.JSONFormat {
+		data, err := jsonv2.Marshal(settings, jsontext.Multiline(true))
+		if err != nil {
+			return err
+		}
+		data = append(data, '\n')
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	for _, s := range settings {
+		fmt.Fprintf(tw, "%s\t%s\t# %s\n", s.Key, s.Value, s.Source)
+	}
+	return tw.Flush()
+}
+
+type configGetCommand struct {
+	Key string `kong:"arg,help=Configuration key."`
+}
+
+func (c *configGetCommand) Signature() string {
+	return `kong:"help=Print the effective value of a configuration setting."`
+}
+
+func (c *configGetCommand) Run(ctx context.Context, g *globalConfig) error {
+	if !slices.Contains(configKeys, c.Key) {
+		return fmt.Errorf("unknown configuration key %q", c.Key)
+	}
+	values, err := configValues(g)
+	if err != nil {
+		return err
+	}
+	v := values[c.Key]
+	if v == nil {
+		v = jsontext.Value("null")
+	}
+	if v.Kind() == '"' {
+		var s string
+		if err := jsonv2.Unmarshal(v, &s); err != nil {
+			return err
+		}
+		_, err := fmt.Println(s)
+		return err
+	}
+	_, err = fmt.Println(v)
+	return err
+}
+
+type configSetCommand struct {
+	Key   string `kong:"arg,help=Configuration key."`
+	Value string `kong:"arg,help=JSON value. Values that are not valid JSON are treated as strings."`
+}
+
+func (c *configSetCommand) Signature() string {
+	return `kong:"help=Set a value in the user configuration file."`
+}
+
+func (c *configSetCommand) Run(ctx context.Context) error {
+	if !slices.Contains(configKeys, c.Key) {
+		return fmt.Errorf("unknown configuration key %q", c.Key)
+	}
+	value := jsontext.Value(c.Value)
+	if !value.IsValid() {
+		var err error
+		value, err = jsonv2.Marshal(c.Value)
+		if err != nil {
+			return err
+		}
+	}
+	path, err := userConfigFile()
+	if err != nil {
+		return err
+	}
+	return editConfigFile(path, func(v *hujson.Value) error {
+		patch, err := jsonv2.Marshal([]map[string]any{{
+			"op":    "add",
+			"path":  "/" + c.Key,
+			"value": value,
+		}})
+		if err != nil {
+			return err
+		}
+		return v.Patch(patch)
+	})
+}
+
+type configUnsetCommand struct {
+	Key string `kong:"arg,help=Configuration key."`
+}
+
+func (c *configUnsetCommand) Signature() string {
+	return `kong:"help=Remove a setting from the user configuration file."`
+}
+
+func (c *configUnsetCommand) Run(ctx context.Context) error {
+	if !slices.Contains(configKeys, c.Key) {
+		return fmt.Errorf("unknown configuration key %q", c.Key)
+	}
+	path, err := userConfigFile()
+	if err != nil {
+		return err
+	}
+	return editConfigFile(path, func(v *hujson.Value) error {
+		if v.Find("/"+c.Key) == nil {
+			return nil
+		}
+		patch, err := jsonv2.Marshal([]map[string]any{{
+			"op":   "remove",
+			"path": "/" + c.Key,
+		}})
+		if err != nil {
+			return err
+		}
+		return v.Patch(patch)
+	})
+}
+
+type configPathCommand struct {
+	All bool `kong:"help=Print every configuration file that zb reads in order of increasing precedence."`
+}
+
+func (c *configPathCommand) Signature() string {
+	return `kong:"help=Print the path of the user configuration file."`
+}
+
+func (c *configPathCommand) Run(ctx context.Context, zb *zbCommand) error {
+	if c.All {
+		for path := range configFilePaths(zb.ExtraConfigs) {
+			if _, err := fmt.Println(path); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	path, err := userConfigFile()
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Println(path)
+	return err
+}
+
+// configSetting is an entry printed by zb config show.
+type configSetting struct {
+	Key   string         `json:"key"`
+	Value jsontext.Value `json:"value"`
+	// Source is the path to the configuration file that set the value,
+	// the name of an environment variable prefixed with "$",
+	// "command line", or "default".
+	Source string `json:"source"`
+}
+
+// configSettings returns the settings in the effective configuration g
+// along with where each setting came from.
+func configSettings(g *globalConfig, extraConfigs []string) ([]*configSetting, error) {
+	sources := make(map[string]string)
+	for path := range configFilePaths(extraConfigs) {
+		keys, err := configFileKeys(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range keys {
+			sources[k] = path
+		}
+	}
+	for envVar, k := range configEnvVars {
+		if os.Getenv(envVar) != "" {
+			sources[k] = "$" + envVar
+		}
+	}
+
+	// Any value that differs from the configuration without flags
+	// must have been set on the command line.
+	unflagged := defaultGlobalConfig()
+	if err := unflagged.mergeFiles(configFilePaths(extraConfigs)); err != nil {
+		return nil, err
+	}
+	if err := unflagged.mergeEnvironment(); err != nil {
+		return nil, err
+	}
+	unflaggedValues, err := configValues(unflagged)
+	if err != nil {
+		return nil, err
+	}
+	values, err := configValues(g)
+	if err != nil {
+		return nil, err
+	}
+
+	settings := make([]*configSetting, 0, len(configKeys))
+	for _, k := range configKeys {
+		s := &configSetting{
+			Key:    k,
+			Value:  values[k],
+			Source: cmp.Or(sources[k], "default"),
+		}
+		if s.Value == nil {
+			s.Value = jsontext.Value("null")
+		}
+		if !bytes.Equal(values[k], unflaggedValues[k]) {
+			s.Source = "command line"
+		}
+		settings = append(settings, s)
+	}
+	return settings, nil
+}
+
+// configValues returns the compact JSON values of g's top-level keys.
+func configValues(g *globalConfig) (map[string]jsontext.Value, error) {
+	data, err := jsonv2.Marshal(g, jsonv2.Deterministic(true))
+	if err != nil {
+		return nil, err
+	}
+	var values map[string]jsontext.Value
+	if err := jsonv2.Unmarshal(data, &values); err != nil {
+		return nil, err
+	}
+	for k, v := range values {
+		if err := v.Compact(); err != nil {
+			return nil, err
+		}
+		values[k] = v
+	}
+	return values, nil
+}
+
+// configFileKeys returns the top-level keys set in the configuration file at path.
+func configFileKeys(path string) ([]string, error) {
+	huJSONData, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	jsonData, err := hujson.Standardize(huJSONData)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %v", path, err)
+	}
+	var m map[string]jsontext.Value
+	if err := jsonv2.Unmarshal(jsonData, &m); err != nil {
+		return nil, fmt.Errorf("read %s: %v", path, err)
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+// userConfigFile returns the path to the configuration file
+// in the user's configuration directory.
+// It prefers an existing config.jwcc, then an existing config.json,
+// and otherwise returns the path to config.jwcc.
+func userConfigFile() (string, error) {
+	var dir string
+	for d := range systemConfigDirs() {
+		dir = d
+	}
+	if dir == "" {
+		return "", errors.New("cannot determine user configuration directory")
+	}
+	jwccPath := filepath.Join(dir, "zb", "config.jwcc")
+	if _, err := os.Stat(jwccPath); err == nil {
+		return jwccPath, nil
+	}
+	jsonPath := filepath.Join(dir, "zb", "config.json")
+	if _, err := os.Stat(jsonPath); err == nil {
+		return jsonPath, nil
+	}
+	return jwccPath, nil
+}
+
+// editConfigFile applies f to the configuration file at path,
+// preserving comments and formatting.
+// If the file does not exist, it is created.
+// The file is only written if the result is a valid configuration.
+func editConfigFile(path string, f func(v *hujson.Value) error) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		data = []byte("{}\n")
+	} else if err != nil {
+		return err
+	}
+	v, err := hujson.Parse(data)
+	if err != nil {
+		return fmt.Errorf("read %s: %v", path, err)
+	}
+	if err := f(&v); err != nil {
+		return fmt.Errorf("edit %s: %v", path, err)
+	}
+	v.Format()
+
+	std := v.Clone()
+	std.Standardize()
+	if err := jsonv2.Unmarshal(std.Pack(), new(globalConfig), jsonv2.RejectUnknownMembers(true)); err != nil {
+		return fmt.Errorf("edit %s: %v", path, err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o777); err != nil {
+		return err
+	}
+	return os.WriteFile(path, v.Pack(), 0o666)
+}
@@ -0,0 +1,92 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tailscale/hujson"
+)
+
+func TestEditConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "zb", "config.jwcc")
+	set := func(key, value string) error {
+		return editConfigFile(path, func(v *hujson.Value) error {
+			return v.Patch([]byte(`[{"op": "add", "path": "/` + key + `", "value": ` + value + `}]`))
+		})
+	}
+
+	if err := set("storeSocket", `"/tmp/zb.sock"`); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, append([]byte("// My settings\n"), data...), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	if err := set("debug", `true`); err != nil {
+		t.Fatal(err)
+	}
+	if err := set("debug", `"yes"`); err == nil {
+		t.Error("Setting debug to a string did not return an error")
+	}
+
+	data, err = os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "// My settings") {
+		t.Errorf("comment not preserved:\n%s", data)
+	}
+	g := new(globalConfig)
+	if err := g.mergeFiles(func(yield func(string) bool) { yield(path) }); err != nil {
+		t.Fatal(err)
+	}
+	if !g.Debug || g.StoreSocket != "/tmp/zb.sock" {
+		t.Errorf("after edits, config = %+v; want debug and storeSocket set", g)
+	}
+}
+
+func TestConfigSettings(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_DIRS", t.TempDir())
+	t.Setenv("ZB_STORE_DIR", "/zb/store")
+	t.Setenv("ZB_STORE_SOCKET", "")
+	t.Setenv("NETRC", "")
+
+	configPath := filepath.Join(t.TempDir(), "extra.jwcc")
+	if err := os.WriteFile(configPath, []byte(`{"debug": true, /* comment */ "httpCache": "/http.db"}`), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	g := defaultGlobalConfig()
+	if err := g.mergeFiles(configFilePaths([]string{configPath})); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.mergeEnvironment(); err != nil {
+		t.Fatal(err)
+	}
+	g.CacheDB = "/flag.db"
+
+	settings, err := configSettings(g, []string{configPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"debug":          configPath,
+		"httpCache":      configPath,
+		"storeDirectory": "$ZB_STORE_DIR",
+		"cacheDB":        "command line",
+		"storeSocket":    "default",
+	}
+	for _, s := range settings {
+		if w, ok := want[s.Key]; ok && s.Source != w {
+			t.Errorf("%s source = %q; want %q", s.Key, s.Source, w)
+		}
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"path/filepath"
 	"slices"
 	"testing"
+	"time"
 
 	jsonv2 "github.com/go-json-experiment/json"
 	"github.com/google/go-cmp/cmp"
@@ -66,6 +67,31 @@ func TestGlobalConfigMergeFiles(t *testing.T) {
 				AllowEnv: stringAllowList{all: true},
 			},
 		},
+		{
+			name: "ReplaceCredentialHelper",
+			files: []string{
+				`{"credentialHelper": ["git", "credential-store", "--file", "/foo"]}` + "\n",
+				`{"credentialHelper": ["my-helper"]}` + "\n",
+			},
+			want: globalConfig{
+				CredentialHelper: []string{"my-helper"},
+			},
+		},
+		{
+			name: "MergeNetwork",
+			files: []string{
+				`{"server": {"network": {"httpsProxy": "http://proxy.example.com:3128", "connectTimeout": "5s"}}}` + "\n",
+				`{"server": {"network": {"connectTimeout": "1m"}}}` + "\n",
+			},
+			want: globalConfig{
+				Server: serverConfig{
+					Network: networkConfig{
+						HTTPSProxy:     "http://proxy.example.com:3128",
+						ConnectTimeout: 1 * time.Minute,
+					},
+				},
+			},
+		},
 		{
 			name: "MergePublicKeys",
 			files: []string{
@@ -129,6 +155,9 @@ func FuzzConfigMarshal(f *testing.F) {
 	f.Add([]byte(`{"trustedPublicKeys": [{"format": "ed25519", "publicKey": "+NMDNfvjCmdT9mLr9zadYQXwF/mPLsToMw36yX7w6HCVCSK9J2WsMGPCAT9U2Y959NFgAfdiSWGRvWbXYlGUcA=="}]}` + "\n"))
 	f.Add([]byte(`{"trustedPublicKeys": [{"format": "foo", "publicKey": "YmFy"}]}`))
 	f.Add([]byte(`{"netrcFile": "/etc/netrc"}` + "\n"))
+	f.Add([]byte(`{"credentialHelper": ["git", "credential-store"]}` + "\n"))
+	f.Add([]byte(`{"server": {"network": {"caBundle": "/etc/ssl/corp.pem", "tlsMinVersion": "1.3", "responseHeaderTimeout": "30s"}}}` + "\n"))
+	f.Add([]byte(`{"server": {"download": {"type": "http", "url": "https://cache.example.com/", "maxConcurrentDownloads": 8, "maxRequestsPerSecond": 50, "downloadRetries": 5}}}` + "\n"))
 
 	f.Fuzz(func(t *testing.T, in []byte) {
 		init := defaultGlobalConfig()
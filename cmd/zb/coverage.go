@@ -0,0 +1,91 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+
+	jsonv2 "github.com/go-json-experiment/json"
+	"github.com/go-json-experiment/json/jsontext"
+	"zb.256lights.llc/pkg/internal/frontend"
+)
+
+// writeCoverageFile writes the coverage recorded by cov to the file at path
+// in the given format ("lcov" or "json").
+func writeCoverageFile(path string, format string, cov *frontend.Coverage) error {
+	files, err := cov.Files()
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	switch format {
+	case "json":
+		err = writeCoverageJSON(w, files)
+	default:
+		err = writeLCOV(w, files)
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := f.Close(); err == nil && closeErr != nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
+
+// writeLCOV writes files to w in the [lcov] tracefile format.
+//
+// [lcov]: https://github.com/linux-test-project/lcov/blob/master/man/geninfo.1
+func writeLCOV(w io.Writer, files []*frontend.CoverageFile) error {
+	for _, f := range files {
+		if _, err := fmt.Fprintf(w, "TN:\nSF:%s\n", f.Path); err != nil {
+			return err
+		}
+		for _, line := range f.Lines {
+			if _, err := fmt.Fprintf(w, "DA:%d,%d\n", line.Line, line.Hits); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(w, "LF:%d\nLH:%d\nend_of_record\n", len(f.Lines), f.Covered()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// coverageFileJSON is the JSON representation of a [frontend.CoverageFile].
+type coverageFileJSON struct {
+	*frontend.CoverageFile
+	Total   int `json:"total"`
+	Covered int `json:"covered"`
+}
+
+// writeCoverageJSON writes files to w as a JSON array.
+func writeCoverageJSON(w io.Writer, files []*frontend.CoverageFile) error {
+	records := make([]coverageFileJSON, len(files))
+	for i, f := range files {
+		records[i] = coverageFileJSON{
+			CoverageFile: f,
+			Total:        len(f.Lines),
+			Covered:      f.Covered(),
+		}
+	}
+	data, err := jsonv2.Marshal(records, jsontext.Multiline(true))
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	_, err = w.Write(data)
+	return err
+}
@@ -0,0 +1,48 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"zb.256lights.llc/pkg/internal/frontend"
+)
+
+func TestWriteLCOV(t *testing.T) {
+	files := []*frontend.CoverageFile{
+		{
+			Path: "/src/a.lua",
+			Lines: []frontend.CoverageLine{
+				{Line: 1, Hits: 1},
+				{Line: 2, Hits: 0},
+				{Line: 4, Hits: 12},
+			},
+		},
+		{
+			Path: "/src/b.lua",
+		},
+	}
+	sb := new(strings.Builder)
+	if err := writeLCOV(sb, files); err != nil {
+		t.Fatal(err)
+	}
+	const want = "TN:\n" +
+		"SF:/src/a.lua\n" +
+		"DA:1,1\n" +
+		"DA:2,0\n" +
+		"DA:4,12\n" +
+		"LF:3\n" +
+		"LH:2\n" +
+		"end_of_record\n" +
+		"TN:\n" +
+		"SF:/src/b.lua\n" +
+		"LF:0\n" +
+		"LH:0\n" +
+		"end_of_record\n"
+	if diff := cmp.Diff(want, sb.String()); diff != "" {
+		t.Errorf("writeLCOV(...) (-want +got):\n%s", diff)
+	}
+}
@@ -5,6 +5,7 @@ package main
 
 import (
 	"context"
+	_ "embed"
 	"fmt"
 	"iter"
 	"maps"
@@ -25,8 +26,10 @@ import (
 )
 
 type derivationCommand struct {
-	Env  derivationEnvCommand  `kong:"cmd"`
-	Show derivationShowCommand `kong:"cmd"`
+	Env         derivationEnvCommand         `kong:"cmd"`
+	Show        derivationShowCommand        `kong:"cmd"`
+	Diff        derivationDiffCommand        `kong:"cmd"`
+	Instantiate derivationInstantiateCommand `kong:"cmd"`
 }
 
 func (c *derivationCommand) Signature() string {
@@ -36,12 +39,26 @@ func (c *derivationCommand) Signature() string {
 type derivationShowCommand struct {
 	evalOptions `kong:"embed"`
 	JSONFormat  bool `kong:"name=json,help=Print derivation as JSON."`
+	Recursive   bool `kong:"short=r,help=Also show the derivations that the derivations depend on."`
 }
 
 func (c *derivationShowCommand) Signature() string {
 	return `help:"Show the contents of one or more derivations."`
 }
 
+//go:embed docs/derivation_show.txt
+var derivationShowDoc string
+
+func (c *derivationShowCommand) Help() string {
+	return derivationShowDoc
+}
+
+// separate reports whether derivations printed in ATerm format
+// should be followed by a blank line.
+func (c *derivationShowCommand) separate(n int) bool {
+	return !c.JSONFormat && (n > 1 || c.Recursive)
+}
+
 func (c *derivationShowCommand) Run(ctx context.Context, g *globalConfig) error {
 	var drvPaths []string
 	if !c.Expression {
@@ -51,31 +68,36 @@ func (c *derivationShowCommand) Run(ctx context.Context, g *globalConfig) error
 		// These can be interspersed with other URLs.
 		drvPaths = make([]string, len(c.Args))
 		for i, arg := range c.Args {
-			u, err := frontend.ParseURL(arg)
+			var err error
+			drvPaths[i], err = localDerivationPath(arg)
 			if err != nil {
 				return err
 			}
-			if (u.Scheme == "" || u.Scheme == fileurl.Scheme) && u.Fragment == "" &&
-				strings.HasSuffix(u.Path, zbstore.DerivationExt) {
-				drvPaths[i], err = frontend.URLToPath(u)
-				if err != nil {
-					return err
-				}
-			}
 		}
 		if !slices.Contains(drvPaths, "") {
 			// Fast path: don't connect to the store. All arguments are local paths to .drv files.
+			var roots []*zbstore.Derivation
 			for _, drvPath := range drvPaths {
 				drvBytes, err := showDerivationFile(drvPath, c.JSONFormat)
 				if err != nil {
 					return err
 				}
-				if !c.JSONFormat && len(c.Args) > 1 {
+				if c.separate(len(c.Args)) {
 					drvBytes = append(drvBytes, '\n')
 				}
 				if _, err := os.Stdout.Write(drvBytes); err != nil {
 					return err
 				}
+				if c.Recursive {
+					drv, err := readLocalDerivationFile(drvPath)
+					if err != nil {
+						return err
+					}
+					roots = append(roots, drv)
+				}
+			}
+			if c.Recursive {
+				return c.showInputDerivations(roots)
 			}
 			return nil
 		}
@@ -121,18 +143,24 @@ func (c *derivationShowCommand) Run(ctx context.Context, g *globalConfig) error
 		results, err = eval.URLs(ctx, urls)
 	}
 	if err != nil {
-		return err
+		return evaluationError(err)
 	}
 	if len(results) == 0 {
 		return fmt.Errorf("no evaluation results")
 	}
 
 	resultIndex := 0
+	var roots []*zbstore.Derivation
 	for i := range c.Args {
 		var drvBytes []byte
 		var err error
 		if i < len(drvPaths) && drvPaths[i] != "" {
 			drvBytes, err = showDerivationFile(drvPaths[i], c.JSONFormat)
+			if err == nil && c.Recursive {
+				var drv *zbstore.Derivation
+				drv, err = readLocalDerivationFile(drvPaths[i])
+				roots = append(roots, drv)
+			}
 		} else {
 			result := results[resultIndex]
 			resultIndex++
@@ -141,11 +169,12 @@ func (c *derivationShowCommand) Run(ctx context.Context, g *globalConfig) error
 				return fmt.Errorf("%v is not a derivation", result)
 			}
 			drvBytes, err = showDerivation(drv, c.JSONFormat)
+			roots = append(roots, drv.Derivation)
 		}
 		if err != nil {
 			return err
 		}
-		if !c.JSONFormat && len(results) > 1 {
+		if c.separate(len(results)) {
 			drvBytes = append(drvBytes, '\n')
 		}
 		if _, err := os.Stdout.Write(drvBytes); err != nil {
@@ -153,29 +182,92 @@ func (c *derivationShowCommand) Run(ctx context.Context, g *globalConfig) error
 		}
 	}
 
+	if c.Recursive {
+		return c.showInputDerivations(roots)
+	}
 	return nil
 }
 
-func showDerivationFile(drvPath string, jsonFormat bool) ([]byte, error) {
-	drvPath, err := filepath.Abs(drvPath)
+// showInputDerivations prints the derivations
+// in the closure of the input derivations of roots, sorted by path.
+func (c *derivationShowCommand) showInputDerivations(roots []*zbstore.Derivation) error {
+	closure, err := inputDerivationClosure(roots)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	dir, err := zbstore.CleanDirectory(filepath.Dir(drvPath))
+	for _, drvPath := range slices.Sorted(maps.Keys(closure)) {
+		var drvBytes []byte
+		if c.JSONFormat {
+			drvBytes, err = marshalDerivationJSON(string(drvPath), closure[drvPath])
+		} else {
+			drvBytes, err = closure[drvPath].MarshalText()
+		}
+		if err != nil {
+			return err
+		}
+		// JSON output is one object per line.
+		// ATerm output has no trailing newline, so add one to separate derivations.
+		drvBytes = append(drvBytes, '\n')
+		if _, err := os.Stdout.Write(drvBytes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// inputDerivationClosure reads the derivations
+// in the closure of the input derivations of roots from the local filesystem.
+// Derivations in roots are not included in the result
+// unless another derivation in roots depends on them.
+func inputDerivationClosure(roots []*zbstore.Derivation) (map[zbstore.Path]*zbstore.Derivation, error) {
+	closure := make(map[zbstore.Path]*zbstore.Derivation)
+	var pending []zbstore.Path
+	for _, drv := range roots {
+		pending = slices.AppendSeq(pending, maps.Keys(drv.InputDerivations))
+	}
+	for len(pending) > 0 {
+		drvPath := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if closure[drvPath] != nil {
+			continue
+		}
+		drv, err := readDerivationFile(drvPath)
+		if err != nil {
+			return nil, err
+		}
+		closure[drvPath] = drv
+		pending = slices.AppendSeq(pending, maps.Keys(drv.InputDerivations))
+	}
+	return closure, nil
+}
+
+// localDerivationPath returns the filesystem path of the .drv file
+// named by the command-line argument arg
+// or the empty string if arg does not name a .drv file.
+func localDerivationPath(arg string) (string, error) {
+	u, err := frontend.ParseURL(arg)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-	drvBytes, err := os.ReadFile(drvPath)
+	if (u.Scheme != "" && u.Scheme != fileurl.Scheme) || u.Fragment != "" ||
+		!strings.HasSuffix(u.Path, zbstore.DerivationExt) {
+		return "", nil
+	}
+	return frontend.URLToPath(u)
+}
+
+func showDerivationFile(drvPath string, jsonFormat bool) ([]byte, error) {
+	drvPath, err := filepath.Abs(drvPath)
 	if err != nil {
 		return nil, err
 	}
 	if !jsonFormat {
 		// If we're not outputting JSON, no need to parse. Pass through, even if it's invalid.
-		return drvBytes, nil
+		return os.ReadFile(drvPath)
 	}
-	drv, err := zbstore.ParseDerivation(dir, inferDerivationName(drvPath), drvBytes)
+	drv, err := readLocalDerivationFile(drvPath)
 	if err != nil {
-		return nil, fmt.Errorf("parse %s: %v", drvPath, err)
+		return nil, err
 	}
 
 	jsonData, err := marshalDerivationJSON(drvPath, drv)
@@ -186,6 +278,28 @@ func showDerivationFile(drvPath string, jsonFormat bool) ([]byte, error) {
 	return jsonData, nil
 }
 
+// readLocalDerivationFile reads and parses the .drv file at the given filesystem path.
+// Unlike [readDerivationFile], the file does not need to be in a store directory.
+func readLocalDerivationFile(drvPath string) (*zbstore.Derivation, error) {
+	drvPath, err := filepath.Abs(drvPath)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := zbstore.CleanDirectory(filepath.Dir(drvPath))
+	if err != nil {
+		return nil, err
+	}
+	drvBytes, err := os.ReadFile(drvPath)
+	if err != nil {
+		return nil, err
+	}
+	drv, err := zbstore.ParseDerivation(dir, inferDerivationName(drvPath), drvBytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %v", drvPath, err)
+	}
+	return drv, nil
+}
+
 func showDerivation(drv *frontend.Derivation, jsonFormat bool) ([]byte, error) {
 	if !jsonFormat {
 		return drv.MarshalText()
@@ -207,34 +321,36 @@ func inferDerivationName(path string) string {
 	return strings.TrimSuffix(baseName, zbstore.DerivationExt)
 }
 
-func marshalDerivationJSON(drvPath string, drv *zbstore.Derivation) ([]byte, error) {
-	type jsonDerivationOutputType struct {
-		Path          string `json:"path,omitempty"`
-		HashType      string `json:"hashAlgo,omitempty"`
-		HashRawBase16 string `json:"hash,omitempty"`
-	}
+// jsonDerivation is the JSON representation of a derivation
+// used by `zb derivation show --json` and `zb derivation instantiate`.
+type jsonDerivation struct {
+	Path    string            `json:"drvPath"`
+	Name    string            `json:"name"`
+	System  string            `json:"system"`
+	Builder string            `json:"builder"`
+	Args    []string          `json:"args"`
+	Env     map[string]string `json:"env"`
 
-	type jsonOutputReference struct {
-		DrvPath    string `json:"drvPath"`
-		OutputName string `json:"outputName"`
-	}
+	InputSources     []string            `json:"inputSrcs"`
+	InputDerivations map[string][]string `json:"inputDrvs"`
 
-	type jsonDerivation struct {
-		Path    string            `json:"drvPath"`
-		Name    string            `json:"name"`
-		System  string            `json:"system"`
-		Builder string            `json:"builder"`
-		Args    []string          `json:"args"`
-		Env     map[string]string `json:"env"`
+	Outputs map[string]jsonDerivationOutputType `json:"outputs"`
 
-		InputSources     []string            `json:"inputSrcs"`
-		InputDerivations map[string][]string `json:"inputDrvs"`
+	Placeholders map[string]jsonOutputReference `json:"placeholders"`
+}
 
-		Outputs map[string]jsonDerivationOutputType `json:"outputs"`
+type jsonDerivationOutputType struct {
+	Path          string `json:"path,omitempty"`
+	HashType      string `json:"hashAlgo,omitempty"`
+	HashRawBase16 string `json:"hash,omitempty"`
+}
 
-		Placeholders map[string]jsonOutputReference `json:"placeholders"`
-	}
+type jsonOutputReference struct {
+	DrvPath    string `json:"drvPath"`
+	OutputName string `json:"outputName"`
+}
 
+func marshalDerivationJSON(drvPath string, drv *zbstore.Derivation) ([]byte, error) {
 	j := &jsonDerivation{
 		Path:    drvPath,
 		Name:    drv.Name,
@@ -259,8 +375,11 @@ func marshalDerivationJSON(drvPath string, drv *zbstore.Derivation) ([]byte, err
 				}
 				if ht, ok := outputType.HashType(); ok {
 					j.HashType = ht.String()
-					if outputType.IsRecursiveFile() {
+					switch {
+					case outputType.IsRecursiveFile():
 						j.HashType = "r:" + j.HashType
+					case outputType.IsText():
+						j.HashType = "text:" + j.HashType
 					}
 				}
 				if ca, ok := outputType.FixedCA(); ok {
@@ -346,7 +465,7 @@ func (c *derivationEnvCommand) Run(ctx context.Context, g *globalConfig) error {
 		results, err = eval.URLs(ctx, c.Args)
 	}
 	if err != nil {
-		return err
+		return evaluationError(err)
 	}
 	if len(results) == 0 {
 		return fmt.Errorf("no evaluation results")
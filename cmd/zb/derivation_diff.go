@@ -0,0 +1,420 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"io"
+	"iter"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"zb.256lights.llc/pkg/internal/frontend"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+	"zb.256lights.llc/pkg/sets"
+	"zb.256lights.llc/pkg/zbstore"
+	"zombiezen.com/go/log"
+)
+
+type derivationDiffCommand struct {
+	Old            string `kong:"arg,name=OLD,completion-predictor=installable,help=URL or .drv file of the old derivation."`
+	New            string `kong:"arg,name=NEW,completion-predictor=installable,help=URL or .drv file of the new derivation."`
+	evalEnvOptions `kong:"embed"`
+}
+
+func (c *derivationDiffCommand) Signature() string {
+	return `help:"Show the differences between two derivations."`
+}
+
+//go:embed docs/derivation_diff.txt
+var derivationDiffDoc string
+
+func (c *derivationDiffCommand) Help() string {
+	return derivationDiffDoc
+}
+
+func (c *derivationDiffCommand) Run(ctx context.Context, g *globalConfig) error {
+	args := []string{c.Old, c.New}
+	drvPaths := make([]zbstore.Path, len(args))
+	drvs := make([]*zbstore.Derivation, len(args))
+	var urls []string
+	for i, arg := range args {
+		localPath, err := localDerivationPath(arg)
+		if err != nil {
+			return err
+		}
+		if localPath == "" {
+			urls = append(urls, arg)
+			continue
+		}
+		drvs[i], err = readLocalDerivationFile(localPath)
+		if err != nil {
+			return err
+		}
+		// The path is only used for display,
+		// so it's fine if the file is not in a store directory.
+		absPath, err := filepath.Abs(localPath)
+		if err != nil {
+			return err
+		}
+		drvPaths[i] = zbstore.Path(absPath)
+	}
+
+	if len(urls) > 0 {
+		results, err := c.evalDerivations(ctx, g, urls)
+		if err != nil {
+			return err
+		}
+		for i := range drvs {
+			if drvs[i] != nil {
+				continue
+			}
+			drvPaths[i] = results[0].Path
+			drvs[i] = results[0].Derivation
+			results = results[1:]
+		}
+	}
+
+	diff, err := diffDerivations(drvPaths[0], drvs[0], drvPaths[1], drvs[1], readDerivationFile)
+	if err != nil {
+		return err
+	}
+	if diff == nil {
+		return nil
+	}
+	sb := new(strings.Builder)
+	diff.writeTo(sb, 0, make(sets.Set[derivationPathPair]))
+	_, err = io.WriteString(os.Stdout, sb.String())
+	return err
+}
+
+// evalDerivations evaluates the given URLs,
+// requiring each to produce exactly one derivation.
+func (c *derivationDiffCommand) evalDerivations(ctx context.Context, g *globalConfig, urls []string) ([]*frontend.Derivation, error) {
+	httpClient, httpCloser, err := g.newHTTPClient()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		httpClient.CloseIdleConnections()
+		if err := httpCloser.Close(); err != nil {
+			log.Warnf(ctx, "%v", err)
+		}
+	}()
+	di := new(zbstorerpc.DeferredImporter)
+	storeClient := g.storeClient(&zbstorerpc.CodecOptions{
+		Importer: di,
+	})
+	defer storeClient.Close()
+	eval, err := c.newEval(g, httpClient, storeClient, di)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := eval.Close(); err != nil {
+			log.Errorf(ctx, "%v", err)
+		}
+	}()
+
+	results, err := eval.URLs(ctx, urls)
+	if err != nil {
+		return nil, evaluationError(err)
+	}
+	if len(results) != len(urls) {
+		return nil, fmt.Errorf("evaluation produced %d results (expected %d)", len(results), len(urls))
+	}
+	drvs := make([]*frontend.Derivation, 0, len(results))
+	for _, result := range results {
+		drv, _ := result.(*frontend.Derivation)
+		if drv == nil {
+			return nil, fmt.Errorf("%v is not a derivation", result)
+		}
+		drvs = append(drvs, drv)
+	}
+	return drvs, nil
+}
+
+// A derivationDiff describes the differences between two derivations.
+type derivationDiff struct {
+	oldPath zbstore.Path
+	newPath zbstore.Path
+
+	// changes is a list of human-readable descriptions
+	// of the fields that differ between the two derivations.
+	changes []string
+	// inputs is the list of differences between input derivations
+	// that have the same name in both derivations but different paths.
+	inputs []*derivationDiff
+}
+
+// derivationPathPair is a key for a pair of compared derivations.
+type derivationPathPair struct {
+	oldPath zbstore.Path
+	newPath zbstore.Path
+}
+
+// diffDerivations compares two derivations field by field.
+// Input derivations that have the same name in both derivations
+// but different paths are read with readDrv and compared recursively,
+// so the result leads to the changes that caused a rebuild.
+// diffDerivations returns nil if the derivations are identical.
+func diffDerivations(oldPath zbstore.Path, oldDrv *zbstore.Derivation, newPath zbstore.Path, newDrv *zbstore.Derivation, readDrv func(zbstore.Path) (*zbstore.Derivation, error)) (*derivationDiff, error) {
+	d := &derivationDiffer{
+		readDrv: readDrv,
+		cache:   make(map[derivationPathPair]*derivationDiff),
+	}
+	return d.diff(oldPath, oldDrv, newPath, newDrv)
+}
+
+type derivationDiffer struct {
+	readDrv func(zbstore.Path) (*zbstore.Derivation, error)
+	cache   map[derivationPathPair]*derivationDiff
+}
+
+func (d *derivationDiffer) diff(oldPath zbstore.Path, oldDrv *zbstore.Derivation, newPath zbstore.Path, newDrv *zbstore.Derivation) (*derivationDiff, error) {
+	key := derivationPathPair{oldPath, newPath}
+	if diff, ok := d.cache[key]; ok {
+		return diff, nil
+	}
+	diff := &derivationDiff{
+		oldPath: oldPath,
+		newPath: newPath,
+	}
+
+	if oldDrv.Name != newDrv.Name {
+		diff.addf("name: %q -> %q", oldDrv.Name, newDrv.Name)
+	}
+	if oldDrv.System != newDrv.System {
+		diff.addf("system: %q -> %q", oldDrv.System, newDrv.System)
+	}
+
+	// Input derivations are compared first
+	// so that placeholders for changed inputs
+	// don't show up as differences in the builder, arguments, or environment.
+	oldInputs := pathsByName(maps.Keys(oldDrv.InputDerivations))
+	newInputs := pathsByName(maps.Keys(newDrv.InputDerivations))
+	var placeholderRewrites []string
+	var changedInputs [][2]zbstore.Path
+	for name := range unionKeys(oldInputs, newInputs) {
+		oldInput, inOld := oldInputs[name]
+		newInput, inNew := newInputs[name]
+		switch {
+		case !inNew:
+			diff.addf("input derivation removed: %s", oldInput)
+			continue
+		case !inOld:
+			diff.addf("input derivation added: %s", newInput)
+			continue
+		}
+		oldOutputs := oldDrv.InputDerivations[oldInput]
+		newOutputs := newDrv.InputDerivations[newInput]
+		if !slices.Equal(slices.Collect(oldOutputs.Values()), slices.Collect(newOutputs.Values())) {
+			diff.addf("input derivation %s outputs: %v -> %v", name, oldOutputs, newOutputs)
+		}
+		if oldInput == newInput {
+			continue
+		}
+		for outputName := range newOutputs.Values() {
+			placeholderRewrites = append(placeholderRewrites,
+				zbstore.UnknownCAOutputPlaceholder(zbstore.OutputReference{DrvPath: newInput, OutputName: outputName}),
+				zbstore.UnknownCAOutputPlaceholder(zbstore.OutputReference{DrvPath: oldInput, OutputName: outputName}),
+			)
+		}
+		changedInputs = append(changedInputs, [2]zbstore.Path{oldInput, newInput})
+	}
+	normalizedNewDrv := newDrv
+	if len(placeholderRewrites) > 0 {
+		normalizedNewDrv = newDrv.ReplaceStrings(strings.NewReplacer(placeholderRewrites...))
+	}
+
+	if oldDrv.Builder != normalizedNewDrv.Builder {
+		diff.addf("builder: %q -> %q", oldDrv.Builder, normalizedNewDrv.Builder)
+	}
+	if !slices.Equal(oldDrv.Args, normalizedNewDrv.Args) {
+		diff.addf("args: %q -> %q", oldDrv.Args, normalizedNewDrv.Args)
+	}
+	for k := range unionKeys(oldDrv.Env, normalizedNewDrv.Env) {
+		oldValue, inOld := oldDrv.Env[k]
+		newValue, inNew := normalizedNewDrv.Env[k]
+		switch {
+		case !inNew:
+			diff.addf("env %s removed: %q", k, oldValue)
+		case !inOld:
+			diff.addf("env %s added: %q", k, newValue)
+		case oldValue != newValue:
+			diff.addf("env %s: %q -> %q", k, oldValue, newValue)
+		}
+	}
+
+	oldSources := pathsByName(oldDrv.InputSources.Values())
+	newSources := pathsByName(newDrv.InputSources.Values())
+	for name := range unionKeys(oldSources, newSources) {
+		oldSource, inOld := oldSources[name]
+		newSource, inNew := newSources[name]
+		switch {
+		case !inNew:
+			diff.addf("source removed: %s", oldSource)
+		case !inOld:
+			diff.addf("source added: %s", newSource)
+		case oldSource != newSource:
+			diff.addf("source %s: %s -> %s", name, oldSource, newSource)
+		}
+	}
+
+	for outputName := range unionKeys(oldDrv.Outputs, newDrv.Outputs) {
+		oldType, inOld := oldDrv.Outputs[outputName]
+		newType, inNew := newDrv.Outputs[outputName]
+		switch {
+		case !inNew:
+			diff.addf("output removed: %s", outputName)
+		case !inOld:
+			diff.addf("output added: %s", outputName)
+		default:
+			if oldDesc, newDesc := describeOutputType(oldType), describeOutputType(newType); oldDesc != newDesc {
+				diff.addf("output %s: %s -> %s", outputName, oldDesc, newDesc)
+			}
+		}
+	}
+
+	// Cache before recursing so that cycles (which should not occur) terminate.
+	d.cache[key] = diff
+	for _, pair := range changedInputs {
+		oldInputDrv, err := d.readDrv(pair[0])
+		if err != nil {
+			return nil, err
+		}
+		newInputDrv, err := d.readDrv(pair[1])
+		if err != nil {
+			return nil, err
+		}
+		if sameFixedOutput(oldInputDrv, newInputDrv) {
+			// A fixed-output derivation's realization hash only depends on its output,
+			// so dependents do not need to be rebuilt.
+			continue
+		}
+		inputDiff, err := d.diff(pair[0], oldInputDrv, pair[1], newInputDrv)
+		if err != nil {
+			return nil, err
+		}
+		if inputDiff != nil {
+			diff.inputs = append(diff.inputs, inputDiff)
+		}
+	}
+
+	if len(diff.changes) == 0 && len(diff.inputs) == 0 {
+		d.cache[key] = nil
+		return nil, nil
+	}
+	return diff, nil
+}
+
+func (diff *derivationDiff) addf(format string, args ...any) {
+	diff.changes = append(diff.changes, fmt.Sprintf(format, args...))
+}
+
+// writeTo formats diff as an indented tree.
+// Input derivations that appear in seen are not expanded again.
+func (diff *derivationDiff) writeTo(sb *strings.Builder, depth int, seen sets.Set[derivationPathPair]) {
+	indent := strings.Repeat("  ", depth)
+	sb.WriteString(indent)
+	if diff.oldPath == diff.newPath {
+		sb.WriteString(string(diff.oldPath))
+	} else {
+		sb.WriteString(string(diff.oldPath))
+		sb.WriteString(" -> ")
+		sb.WriteString(string(diff.newPath))
+	}
+	key := derivationPathPair{diff.oldPath, diff.newPath}
+	if seen.Has(key) {
+		sb.WriteString(" (see above)\n")
+		return
+	}
+	seen.Add(key)
+	sb.WriteString("\n")
+	for _, change := range diff.changes {
+		sb.WriteString(indent)
+		sb.WriteString("  ")
+		sb.WriteString(change)
+		sb.WriteString("\n")
+	}
+	for _, input := range diff.inputs {
+		input.writeTo(sb, depth+1, seen)
+	}
+}
+
+// pathsByName returns a map of the store object names of the given paths
+// to the paths.
+// Names that are shared by more than one path are keyed by their full path
+// so that they are only matched with an identical path.
+func pathsByName(paths iter.Seq[zbstore.Path]) map[string]zbstore.Path {
+	byName := make(map[string][]zbstore.Path)
+	for p := range paths {
+		byName[p.Name()] = append(byName[p.Name()], p)
+	}
+	m := make(map[string]zbstore.Path, len(byName))
+	for name, group := range byName {
+		if len(group) == 1 {
+			m[name] = group[0]
+			continue
+		}
+		for _, p := range group {
+			m[string(p)] = p
+		}
+	}
+	return m
+}
+
+// unionKeys returns an iterator over the keys that appear in either m1 or m2
+// in sorted order.
+func unionKeys[M1 ~map[string]V1, M2 ~map[string]V2, V1, V2 any](m1 M1, m2 M2) iter.Seq[string] {
+	keys := make(sets.Set[string], len(m1)+len(m2))
+	keys.AddSeq(maps.Keys(m1))
+	keys.AddSeq(maps.Keys(m2))
+	return slices.Values(slices.Sorted(keys.All()))
+}
+
+// sameFixedOutput reports whether drv1 and drv2 are both fixed-output derivations
+// that produce the same output.
+func sameFixedOutput(drv1, drv2 *zbstore.Derivation) bool {
+	if !drv1.Outputs[zbstore.DefaultDerivationOutputName].IsFixed() ||
+		!drv2.Outputs[zbstore.DefaultDerivationOutputName].IsFixed() {
+		return false
+	}
+	noRealization := func(ref zbstore.OutputReference) (zbstore.Path, bool) {
+		return "", false
+	}
+	h1, err := drv1.SHA256RealizationHash(noRealization)
+	if err != nil {
+		return false
+	}
+	h2, err := drv2.SHA256RealizationHash(noRealization)
+	if err != nil {
+		return false
+	}
+	return h1.Equal(h2)
+}
+
+// describeOutputType returns a short description of a derivation output's type.
+func describeOutputType(t *zbstore.DerivationOutputType) string {
+	if ca, ok := t.FixedCA(); ok {
+		return "fixed " + ca.String()
+	}
+	ht, ok := t.HashType()
+	if !ok {
+		return "unknown"
+	}
+	switch {
+	case t.IsRecursiveFile():
+		return "floating r:" + ht.String()
+	case t.IsText():
+		return "floating text:" + ht.String()
+	default:
+		return "floating " + ht.String()
+	}
+}
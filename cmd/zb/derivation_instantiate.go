@@ -0,0 +1,299 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	jsonv2 "github.com/go-json-experiment/json"
+	"github.com/go-json-experiment/json/jsontext"
+	"golang.org/x/term"
+	"zb.256lights.llc/pkg/internal/xmaps"
+	"zb.256lights.llc/pkg/sets"
+	"zb.256lights.llc/pkg/zbstore"
+	"zombiezen.com/go/log"
+	"zombiezen.com/go/nix"
+)
+
+type derivationInstantiateCommand struct {
+	Files []string `kong:"arg,name=file,optional,help=Files of JSON derivations to read. Reads standard input if omitted or -."`
+}
+
+func (c *derivationInstantiateCommand) Signature() string {
+	return `help:"Add derivations described in JSON to the store."`
+}
+
+//go:embed docs/derivation_instantiate.txt
+var derivationInstantiateDoc string
+
+func (c *derivationInstantiateCommand) Help() string {
+	return derivationInstantiateDoc
+}
+
+func (c *derivationInstantiateCommand) Run(ctx context.Context, g *globalConfig) error {
+	inputPaths := c.Files
+	if len(inputPaths) == 0 {
+		inputPaths = []string{"-"}
+	}
+	if len(inputPaths) == 1 && inputPaths[0] == "-" && term.IsTerminal(int(os.Stdin.Fd())) {
+		log.Infof(ctx, "Waiting for data on stdin...")
+	}
+
+	var exports []*derivationExport
+	for _, path := range inputPaths {
+		var err error
+		exports, err = readJSONDerivationFile(exports, g.Directory, path)
+		if err != nil {
+			return err
+		}
+	}
+	if len(exports) == 0 {
+		return fmt.Errorf("no derivations in input")
+	}
+
+	storeClient := g.storeClient(nil)
+	defer storeClient.Close()
+	pr, pw := io.Pipe()
+	ch := make(chan error)
+	go func() {
+		err := importToStore(ctx, storeClient, pr, -1)
+		pr.CloseWithError(err)
+		ch <- err
+		close(ch)
+	}()
+	err := writeDerivationExports(pw, sortDerivationExports(exports))
+	pw.CloseWithError(err)
+	if importErr := <-ch; err == nil {
+		err = importErr
+	}
+	if err != nil {
+		return err
+	}
+
+	// Print paths in the order the derivations were given.
+	for _, e := range exports {
+		if _, err := fmt.Println(e.trailer.StorePath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// derivationExport is a derivation read by `zb derivation instantiate`
+// along with its serialized store object.
+type derivationExport struct {
+	drv     *zbstore.Derivation
+	nar     []byte
+	trailer *zbstore.ExportTrailer
+}
+
+// readJSONDerivationFile reads a sequence of JSON derivation objects
+// in the format printed by `zb derivation show --json`
+// from the named file (or stdin if name is "-")
+// and appends them to exports.
+func readJSONDerivationFile(exports []*derivationExport, dir zbstore.Directory, name string) ([]*derivationExport, error) {
+	f, err := openInputFile(name)
+	if err != nil {
+		return exports, err
+	}
+	defer f.Close()
+
+	dec := jsontext.NewDecoder(f)
+	for {
+		j := new(jsonDerivation)
+		if err := jsonv2.UnmarshalDecode(dec, j); errors.Is(err, io.EOF) {
+			return exports, nil
+		} else if err != nil {
+			return exports, fmt.Errorf("%s: %v", inputFileName(name), err)
+		}
+		e, err := j.export(dir)
+		if err != nil {
+			return exports, fmt.Errorf("%s: %v", inputFileName(name), err)
+		}
+		exports = append(exports, e)
+	}
+}
+
+// export converts j to a [zbstore.Derivation] in the given store directory
+// and serializes it.
+// If j has a drvPath or output paths,
+// then they must match the paths computed from the derivation.
+func (j *jsonDerivation) export(dir zbstore.Directory) (*derivationExport, error) {
+	if j.Name == "" {
+		return nil, fmt.Errorf("derivation missing name")
+	}
+	drv := &zbstore.Derivation{
+		Dir:     dir,
+		Name:    j.Name,
+		System:  j.System,
+		Builder: j.Builder,
+		Args:    j.Args,
+		Env:     j.Env,
+		Outputs: make(map[string]*zbstore.DerivationOutputType, len(j.Outputs)),
+	}
+	if drv.System == "" {
+		return nil, fmt.Errorf("%s derivation: missing system", drv.Name)
+	}
+	if drv.Builder == "" {
+		return nil, fmt.Errorf("%s derivation: missing builder", drv.Name)
+	}
+	for _, s := range j.InputSources {
+		p, err := parseDerivationJSONPath(dir, s)
+		if err != nil {
+			return nil, fmt.Errorf("%s derivation: inputSrcs: %v", drv.Name, err)
+		}
+		drv.InputSources.Add(p)
+	}
+	for s, outputNames := range j.InputDerivations {
+		p, err := parseDerivationJSONPath(dir, s)
+		if err != nil {
+			return nil, fmt.Errorf("%s derivation: inputDrvs: %v", drv.Name, err)
+		}
+		if !p.IsDerivation() {
+			return nil, fmt.Errorf("%s derivation: inputDrvs: %s is not a derivation", drv.Name, p)
+		}
+		if drv.InputDerivations == nil {
+			drv.InputDerivations = make(map[zbstore.Path]*sets.Sorted[string])
+		}
+		drv.InputDerivations[p] = sets.NewSorted(outputNames...)
+	}
+	if len(j.Outputs) == 0 {
+		return nil, fmt.Errorf("%s derivation: no outputs", drv.Name)
+	}
+	for outputName, jOutput := range j.Outputs {
+		if !zbstore.IsValidOutputName(outputName) {
+			return nil, fmt.Errorf("%s derivation: invalid output name %q", drv.Name, outputName)
+		}
+		var err error
+		drv.Outputs[outputName], err = jOutput.toOutputType()
+		if err != nil {
+			return nil, fmt.Errorf("%s derivation: outputs: %s: %v", drv.Name, outputName, err)
+		}
+	}
+
+	nar, trailer, err := drv.Export(nix.SHA256)
+	if err != nil {
+		return nil, err
+	}
+	if j.Path != "" && j.Path != string(trailer.StorePath) {
+		return nil, fmt.Errorf("%s derivation: drvPath is %s but contents hash to %s", drv.Name, j.Path, trailer.StorePath)
+	}
+	for outputName, jOutput := range j.Outputs {
+		if jOutput.Path == "" {
+			continue
+		}
+		if p, err := drv.OutputPath(outputName); err != nil || string(p) != jOutput.Path {
+			return nil, fmt.Errorf("%s derivation: outputs: %s: path %s does not match derivation", drv.Name, outputName, jOutput.Path)
+		}
+	}
+	return &derivationExport{
+		drv:     drv,
+		nar:     nar,
+		trailer: trailer,
+	}, nil
+}
+
+// toOutputType converts the JSON representation of an output type
+// back to a [zbstore.DerivationOutputType].
+// It is the inverse of the conversion in [marshalDerivationJSON].
+func (j jsonDerivationOutputType) toOutputType() (*zbstore.DerivationOutputType, error) {
+	if j.HashType == "" {
+		return nil, fmt.Errorf("missing hashAlgo")
+	}
+	algo, recursive := strings.CutPrefix(j.HashType, "r:")
+	algo, text := strings.CutPrefix(algo, "text:")
+	hashType, err := nix.ParseHashType(algo)
+	if err != nil {
+		return nil, fmt.Errorf("hashAlgo: %v", err)
+	}
+	if j.HashRawBase16 == "" {
+		switch {
+		case recursive:
+			return zbstore.RecursiveFileFloatingCAOutput(hashType), nil
+		case text:
+			return zbstore.TextFloatingCAOutput(hashType), nil
+		default:
+			return zbstore.FlatFileFloatingCAOutput(hashType), nil
+		}
+	}
+
+	hashBits, err := hex.DecodeString(j.HashRawBase16)
+	if err != nil {
+		return nil, fmt.Errorf("hash: %v", err)
+	}
+	if got, want := len(hashBits), hashType.Size(); got != want {
+		return nil, fmt.Errorf("hash: incorrect size (got %d bytes but %v uses %d)", got, hashType, want)
+	}
+	h := nix.NewHash(hashType, hashBits)
+	switch {
+	case recursive:
+		return zbstore.FixedCAOutput(nix.RecursiveFileContentAddress(h)), nil
+	case text:
+		return zbstore.FixedCAOutput(nix.TextContentAddress(h)), nil
+	default:
+		return zbstore.FixedCAOutput(nix.FlatFileContentAddress(h)), nil
+	}
+}
+
+// parseDerivationJSONPath parses s as a store object path in dir.
+func parseDerivationJSONPath(dir zbstore.Directory, s string) (zbstore.Path, error) {
+	p, sub, err := dir.ParsePath(s)
+	if err != nil {
+		return "", err
+	}
+	if sub != "" {
+		return "", fmt.Errorf("%s is not a store object", s)
+	}
+	return p, nil
+}
+
+// sortDerivationExports returns the exports
+// sorted so that every derivation comes after the derivations it depends on.
+func sortDerivationExports(exports []*derivationExport) []*derivationExport {
+	byPath := make(map[zbstore.Path]*derivationExport, len(exports))
+	for _, e := range exports {
+		byPath[e.trailer.StorePath] = e
+	}
+	sorted := make([]*derivationExport, 0, len(byPath))
+	visited := make(sets.Set[zbstore.Path])
+	var visit func(e *derivationExport)
+	visit = func(e *derivationExport) {
+		if visited.Has(e.trailer.StorePath) {
+			return
+		}
+		visited.Add(e.trailer.StorePath)
+		for _, input := range xmaps.SortedKeys(e.drv.InputDerivations) {
+			if dep := byPath[input]; dep != nil {
+				visit(dep)
+			}
+		}
+		sorted = append(sorted, e)
+	}
+	for _, e := range exports {
+		visit(e)
+	}
+	return sorted
+}
+
+// writeDerivationExports writes the exports to w
+// in `nix-store --export` format.
+func writeDerivationExports(w io.Writer, exports []*derivationExport) error {
+	exporter := zbstore.NewExportWriter(w)
+	for _, e := range exports {
+		if _, err := exporter.Write(e.nar); err != nil {
+			return err
+		}
+		if err := exporter.Trailer(e.trailer); err != nil {
+			return err
+		}
+	}
+	return exporter.Close()
+}
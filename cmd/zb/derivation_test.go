@@ -0,0 +1,252 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	jsonv2 "github.com/go-json-experiment/json"
+	"github.com/google/go-cmp/cmp"
+	"zb.256lights.llc/pkg/sets"
+	"zb.256lights.llc/pkg/zbstore"
+	"zombiezen.com/go/nix"
+)
+
+func TestInputDerivationClosure(t *testing.T) {
+	dir, err := zbstore.CleanDirectory(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeDrv := func(digest string, drv *zbstore.Derivation) zbstore.Path {
+		t.Helper()
+		drvPath, err := dir.Object(digest + "-" + drv.Name + zbstore.DerivationExt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := drv.MarshalText()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(string(drvPath), data, 0o666); err != nil {
+			t.Fatal(err)
+		}
+		return drvPath
+	}
+	newDrv := func(name string, inputs ...zbstore.Path) *zbstore.Derivation {
+		drv := &zbstore.Derivation{
+			Dir:              dir,
+			Name:             name,
+			System:           "x86_64-linux",
+			Builder:          "/bin/sh",
+			Env:              map[string]string{},
+			InputDerivations: make(map[zbstore.Path]*sets.Sorted[string]),
+			Outputs: map[string]*zbstore.DerivationOutputType{
+				zbstore.DefaultDerivationOutputName: zbstore.RecursiveFileFloatingCAOutput(nix.SHA256),
+			},
+		}
+		for _, input := range inputs {
+			drv.InputDerivations[input] = sets.NewSorted(zbstore.DefaultDerivationOutputName)
+		}
+		return drv
+	}
+
+	leaf := writeDrv("00000000000000000000000000000000", newDrv("leaf"))
+	mid := writeDrv("11111111111111111111111111111111", newDrv("mid", leaf))
+	other := writeDrv("22222222222222222222222222222222", newDrv("other", leaf))
+	root := newDrv("root", mid, other)
+
+	closure, err := inputDerivationClosure([]*zbstore.Derivation{root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []zbstore.Path{leaf, mid, other}
+	if diff := cmp.Diff(want, slices.Sorted(maps.Keys(closure))); diff != "" {
+		t.Errorf("closure paths (-want +got):\n%s", diff)
+	}
+	if got := closure[mid]; got != nil && got.Name != "mid" {
+		t.Errorf("closure[%s].Name = %q; want %q", mid, got.Name, "mid")
+	}
+}
+
+func TestDiffDerivations(t *testing.T) {
+	dir := zbstore.Directory("/zb/store")
+	newDrv := func(name string, env map[string]string, inputs ...zbstore.Path) *zbstore.Derivation {
+		drv := &zbstore.Derivation{
+			Dir:              dir,
+			Name:             name,
+			System:           "x86_64-linux",
+			Builder:          "/bin/sh",
+			Env:              env,
+			InputDerivations: make(map[zbstore.Path]*sets.Sorted[string]),
+			Outputs: map[string]*zbstore.DerivationOutputType{
+				zbstore.DefaultDerivationOutputName: zbstore.RecursiveFileFloatingCAOutput(nix.SHA256),
+			},
+		}
+		for _, input := range inputs {
+			drv.InputDerivations[input] = sets.NewSorted(zbstore.DefaultDerivationOutputName)
+		}
+		return drv
+	}
+	placeholder := func(drvPath zbstore.Path) string {
+		return zbstore.UnknownCAOutputPlaceholder(zbstore.OutputReference{
+			DrvPath:    drvPath,
+			OutputName: zbstore.DefaultDerivationOutputName,
+		})
+	}
+
+	const (
+		oldLibPath zbstore.Path = "/zb/store/00000000000000000000000000000000-lib.drv"
+		newLibPath zbstore.Path = "/zb/store/11111111111111111111111111111111-lib.drv"
+		oldAppPath zbstore.Path = "/zb/store/22222222222222222222222222222222-app.drv"
+		newAppPath zbstore.Path = "/zb/store/33333333333333333333333333333333-app.drv"
+	)
+	store := map[zbstore.Path]*zbstore.Derivation{
+		oldLibPath: newDrv("lib", map[string]string{"version": "1.0"}),
+		newLibPath: newDrv("lib", map[string]string{"version": "1.1"}),
+	}
+	oldApp := newDrv("app", map[string]string{
+		"lib":  placeholder(oldLibPath),
+		"mode": "fast",
+	}, oldLibPath)
+	newApp := newDrv("app", map[string]string{
+		"lib":   placeholder(newLibPath),
+		"extra": "1",
+	}, newLibPath)
+
+	readDrv := func(drvPath zbstore.Path) (*zbstore.Derivation, error) {
+		drv := store[drvPath]
+		if drv == nil {
+			return nil, fmt.Errorf("%s not found", drvPath)
+		}
+		return drv, nil
+	}
+	diff, err := diffDerivations(oldAppPath, oldApp, newAppPath, newApp, readDrv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff == nil {
+		t.Fatal("diffDerivations(...) = nil; want differences")
+	}
+	sb := new(strings.Builder)
+	diff.writeTo(sb, 0, make(sets.Set[derivationPathPair]))
+	want := string(oldAppPath) + " -> " + string(newAppPath) + "\n" +
+		`  env extra added: "1"` + "\n" +
+		`  env mode removed: "fast"` + "\n" +
+		"  " + string(oldLibPath) + " -> " + string(newLibPath) + "\n" +
+		`    env version: "1.0" -> "1.1"` + "\n"
+	if diff := cmp.Diff(want, sb.String()); diff != "" {
+		t.Errorf("output (-want +got):\n%s", diff)
+	}
+
+	if diff, err := diffDerivations(oldAppPath, oldApp, oldAppPath, oldApp.Clone(), readDrv); err != nil || diff != nil {
+		t.Errorf("diffDerivations(oldApp, oldApp) = %v, %v; want <nil>, <nil>", diff, err)
+	}
+}
+
+func TestJSONDerivationRoundTrip(t *testing.T) {
+	dir := zbstore.Directory("/zb/store")
+	const srcPath zbstore.Path = "/zb/store/00000000000000000000000000000000-src.tar.gz"
+	fixedHash, err := nix.ParseHash("sha256:1b8m03r63zqhnjf7l5wnldhh7c134ap5vpj0850ymkq1iyzicy5s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lib := &zbstore.Derivation{
+		Dir:          dir,
+		Name:         "lib",
+		System:       "x86_64-linux",
+		Builder:      "/bin/sh",
+		Args:         []string{"-c", "echo hi > $out"},
+		Env:          map[string]string{"src": string(srcPath)},
+		InputSources: *sets.NewSorted(srcPath),
+		Outputs: map[string]*zbstore.DerivationOutputType{
+			zbstore.DefaultDerivationOutputName: zbstore.RecursiveFileFloatingCAOutput(nix.SHA256),
+			"doc":                               zbstore.TextFloatingCAOutput(nix.SHA256),
+		},
+	}
+	_, libTrailer, err := lib.Export(nix.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := &zbstore.Derivation{
+		Dir:     dir,
+		Name:    "app",
+		System:  "x86_64-linux",
+		Builder: "/bin/sh",
+		Env:     map[string]string{},
+		InputDerivations: map[zbstore.Path]*sets.Sorted[string]{
+			libTrailer.StorePath: sets.NewSorted(zbstore.DefaultDerivationOutputName),
+		},
+		Outputs: map[string]*zbstore.DerivationOutputType{
+			zbstore.DefaultDerivationOutputName: zbstore.FixedCAOutput(nix.FlatFileContentAddress(fixedHash)),
+		},
+	}
+	_, appTrailer, err := app.Export(nix.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Give the dependent derivation first to check ordering.
+	var input []byte
+	for _, tc := range []struct {
+		path zbstore.Path
+		drv  *zbstore.Derivation
+	}{{appTrailer.StorePath, app}, {libTrailer.StorePath, lib}} {
+		data, err := marshalDerivationJSON(string(tc.path), tc.drv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		input = append(input, data...)
+		input = append(input, '\n')
+	}
+	inputPath := filepath.Join(t.TempDir(), "drvs.json")
+	if err := os.WriteFile(inputPath, input, 0o666); err != nil {
+		t.Fatal(err)
+	}
+	exports, err := readJSONDerivationFile(nil, dir, inputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []*zbstore.Derivation{app, lib} {
+		wantText, err := want.MarshalText()
+		if err != nil {
+			t.Fatal(err)
+		}
+		gotText, err := exports[i].drv.MarshalText()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(string(wantText), string(gotText)); diff != "" {
+			t.Errorf("%s derivation (-want +got):\n%s", want.Name, diff)
+		}
+	}
+
+	var order []zbstore.Path
+	for _, e := range sortDerivationExports(exports) {
+		order = append(order, e.trailer.StorePath)
+	}
+	wantOrder := []zbstore.Path{libTrailer.StorePath, appTrailer.StorePath}
+	if diff := cmp.Diff(wantOrder, order); diff != "" {
+		t.Errorf("export order (-want +got):\n%s", diff)
+	}
+
+	t.Run("MismatchedPath", func(t *testing.T) {
+		data, err := marshalDerivationJSON(string(libTrailer.StorePath), app)
+		if err != nil {
+			t.Fatal(err)
+		}
+		j := new(jsonDerivation)
+		if err := jsonv2.Unmarshal(data, j); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := j.export(dir); err == nil {
+			t.Error("export did not return an error")
+		}
+	})
+}
@@ -0,0 +1,109 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+
+	jsonv2 "github.com/go-json-experiment/json"
+	"github.com/go-json-experiment/json/jsontext"
+	"zb.256lights.llc/pkg/internal/frontend"
+	"zb.256lights.llc/pkg/internal/luadoc"
+)
+
+type docCommand struct {
+	Target     string `kong:"arg,optional,placeholder=TARGET,completion-predictor=installable,help=Lua file or built-in name. Append #name to a file to show a single definition. Built-ins are listed if omitted."`
+	JSONFormat bool   `kong:"name=json,help=Print the documentation as JSON."`
+}
+
+func (c *docCommand) Signature() string {
+	return `kong:"help=Show the documentation comments in a Lua file or for built-ins."`
+}
+
+func (c *docCommand) Run(ctx context.Context) error {
+	docs, err := findDocs(c.Target)
+	if err != nil {
+		return err
+	}
+	if c.JSONFormat {
+		data, err := jsonv2.Marshal(docs, jsontext.Multiline(true))
+		if err != nil {
+			return err
+		}
+		data = append(data, '\n')
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	out := bufio.NewWriter(os.Stdout)
+	writeDocs(out, docs)
+	return out.Flush()
+}
+
+// findDocs returns the documentation for the target argument of zb doc.
+func findDocs(target string) ([]*luadoc.Doc, error) {
+	if target == "" {
+		return frontend.BuiltinDocs(), nil
+	}
+	path, name, hasName := strings.Cut(target, "#")
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) && !hasName {
+		if doc, found := frontend.BuiltinDoc(target); found {
+			return []*luadoc.Doc{doc}, nil
+		}
+		return nil, fmt.Errorf("%s: no such file or built-in", target)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	docs, err := luadoc.Extract(bufio.NewReader(f))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if !hasName {
+		return docs, nil
+	}
+	for _, doc := range docs {
+		if doc.Name == name {
+			return []*luadoc.Doc{doc}, nil
+		}
+	}
+	return nil, fmt.Errorf("%s: %s is not defined", path, name)
+}
+
+// writeDocs writes docs to w in a human-readable format
+// similar to that of go doc.
+func writeDocs(w io.Writer, docs []*luadoc.Doc) {
+	for i, doc := range docs {
+		if i > 0 {
+			io.WriteString(w, "\n")
+		}
+		fmt.Fprintf(w, "%s %s\n", doc.Kind, doc.Signature())
+		writeIndented(w, doc.Text)
+		for _, a := range doc.Annotations {
+			writeIndented(w, a)
+		}
+	}
+}
+
+// writeIndented writes each line of s to w indented by four spaces.
+// Blank lines are not indented.
+func writeIndented(w io.Writer, s string) {
+	for line := range strings.Lines(s) {
+		if line != "\n" {
+			io.WriteString(w, "    ")
+		}
+		io.WriteString(w, line)
+	}
+	if s != "" && !strings.HasSuffix(s, "\n") {
+		io.WriteString(w, "\n")
+	}
+}
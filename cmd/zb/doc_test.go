@@ -0,0 +1,93 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"zb.256lights.llc/pkg/internal/luadoc"
+)
+
+func TestFindDocs(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "pkgs.lua")
+	const source = "---Say hello.\n" +
+		"function greet(name) end\n" +
+		"\n" +
+		"---The hello package.\n" +
+		"hello = derivation {}\n"
+	if err := os.WriteFile(path, []byte(source), 0o666); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		target string
+		want   []string
+		err    bool
+	}{
+		{target: path, want: []string{"greet", "hello"}},
+		{target: path + "#hello", want: []string{"hello"}},
+		{target: path + "#bogus", err: true},
+		{target: "zb.hasFeature", want: []string{"zb.hasFeature"}},
+		{target: filepath.Join(dir, "bogus.lua"), err: true},
+	}
+	for _, test := range tests {
+		docs, err := findDocs(test.target)
+		if err != nil {
+			if !test.err {
+				t.Errorf("findDocs(%q): %v", test.target, err)
+			}
+			continue
+		}
+		if test.err {
+			t.Errorf("findDocs(%q) did not return an error", test.target)
+			continue
+		}
+		var got []string
+		for _, doc := range docs {
+			got = append(got, doc.Name)
+		}
+		if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("findDocs(%q) names (-want +got):\n%s", test.target, diff)
+		}
+	}
+
+	if docs, err := findDocs(""); err != nil {
+		t.Errorf("findDocs(\"\"): %v", err)
+	} else if len(docs) == 0 {
+		t.Error("findDocs(\"\") returned no built-ins")
+	}
+}
+
+func TestWriteDocs(t *testing.T) {
+	docs := []*luadoc.Doc{
+		{
+			Name:        "greet",
+			Kind:        luadoc.Function,
+			Params:      []string{"name"},
+			Text:        "Say hello.\n\nReturns nothing.",
+			Annotations: []string{"@param name string"},
+		},
+		{
+			Name: "hello",
+			Kind: luadoc.Derivation,
+		},
+	}
+	sb := new(strings.Builder)
+	writeDocs(sb, docs)
+	const want = "function greet(name)\n" +
+		"    Say hello.\n" +
+		"\n" +
+		"    Returns nothing.\n" +
+		"    @param name string\n" +
+		"\n" +
+		"derivation hello\n"
+	if diff := cmp.Diff(want, sb.String()); diff != "" {
+		t.Errorf("writeDocs(...) (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,442 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+
+	jsonv2 "github.com/go-json-experiment/json"
+	"zb.256lights.llc/pkg/internal/backend"
+	"zb.256lights.llc/pkg/internal/frontend"
+	"zb.256lights.llc/pkg/internal/jsonrpc"
+	"zb.256lights.llc/pkg/internal/system"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+type doctorCommand struct {
+	storeDatabaseFlags `kong:"embed"`
+	KeyFiles           []string      `kong:"name=signing-key,sep=none,placeholder=file,completion-predictor=file,help=Check that the given signing key file can be read (can be passed multiple times)"`
+	Timeout            time.Duration `kong:"default=10s,help=Maximum amount of time to wait for each network check. (Default: ${default})"`
+}
+
+func (c *doctorCommand) Signature() string {
+	return `kong:"help=Check the zb installation for common problems and suggest fixes."`
+}
+
+func (c *doctorCommand) Run(ctx context.Context, g *globalConfig) error {
+	checks := []*doctorCheck{
+		checkStoreServer(ctx, g, c.Timeout),
+		checkStoreDirectory(g),
+		checkSandbox(),
+		checkFrontend(),
+		checkDatabase(ctx, "Store database", c.DBPath),
+		checkDatabase(ctx, "Cache database", g.CacheDB),
+		checkKeys(g, c.KeyFiles),
+		checkSubstituter(ctx, g, c.Timeout),
+	}
+	failures := 0
+	for _, check := range checks {
+		if check.Status == doctorFailure {
+			failures++
+		}
+		if _, err := fmt.Print(check); err != nil {
+			return err
+		}
+	}
+	switch failures {
+	case 0:
+		return nil
+	case 1:
+		return errors.New("1 check failed")
+	default:
+		return fmt.Errorf("%d checks failed", failures)
+	}
+}
+
+// doctorStatus is the outcome of a [doctorCheck].
+type doctorStatus int
+
+const (
+	doctorOK doctorStatus = iota
+	doctorWarning
+	doctorFailure
+)
+
+func (status doctorStatus) String() string {
+	switch status {
+	case doctorOK:
+		return "ok"
+	case doctorWarning:
+		return "warn"
+	case doctorFailure:
+		return "FAIL"
+	default:
+		return fmt.Sprintf("doctorStatus(%d)", int(status))
+	}
+}
+
+// doctorCheck is the result of a single zb doctor check.
+type doctorCheck struct {
+	Name    string
+	Status  doctorStatus
+	Message string
+	// Fix is a suggestion for resolving a warning or failure.
+	Fix string
+}
+
+// String formats the check result as one or two lines of text.
+func (check *doctorCheck) String() string {
+	sb := new(strings.Builder)
+	fmt.Fprintf(sb, "[%s] %s: %s\n", check.Status, check.Name, check.Message)
+	if check.Fix != "" && check.Status != doctorOK {
+		fmt.Fprintf(sb, "       fix: %s\n", check.Fix)
+	}
+	return sb.String()
+}
+
+// checkStoreServer checks that the store server is reachable
+// and that it speaks the same protocol version as this client.
+func checkStoreServer(ctx context.Context, g *globalConfig, timeout time.Duration) *doctorCheck {
+	check := &doctorCheck{Name: "Store server"}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// The store client retries failed connections,
+	// so dial once first to report a missing server promptly.
+	conn, err := (&net.Dialer{}).DialContext(ctx, "unix", g.StoreSocket)
+	if err != nil {
+		check.Status = doctorFailure
+		check.Message = fmt.Sprintf("cannot connect to %s: %v", g.StoreSocket, err)
+		check.Fix = "Start the store server with zb serve, or set storeSocket to the socket of a running server."
+		return check
+	}
+	conn.Close()
+	storeClient := g.storeClient(nil)
+	defer storeClient.Close()
+
+	if err := jsonrpc.Do(ctx, storeClient, zbstorerpc.NopMethod, nil, nil); err != nil {
+		check.Status = doctorFailure
+		check.Message = fmt.Sprintf("cannot connect to %s: %v", g.StoreSocket, err)
+		check.Fix = "Start the store server with zb serve, or set storeSocket to the socket of a running server."
+		return check
+	}
+
+	resp, err := zbstorerpc.Handshake(ctx, storeClient)
+	if err != nil {
+		check.Status = doctorFailure
+		check.Message = fmt.Sprintf("%s: %v", g.StoreSocket, err)
+		check.Fix = "Check the store server's logs."
+		return check
+	}
+	switch {
+	case resp.ProtocolVersion < zbstorerpc.ProtocolVersion:
+		check.Status = doctorWarning
+		check.Message = fmt.Sprintf("server at %s uses protocol version %d (this client uses %d)", g.StoreSocket, resp.ProtocolVersion, zbstorerpc.ProtocolVersion)
+		check.Fix = "Upgrade the store server to the same version of zb as this client."
+		return check
+	case resp.ProtocolVersion > zbstorerpc.ProtocolVersion:
+		check.Status = doctorWarning
+		check.Message = fmt.Sprintf("server at %s uses protocol version %d (this client uses %d)", g.StoreSocket, resp.ProtocolVersion, zbstorerpc.ProtocolVersion)
+		check.Fix = "Upgrade this zb client to the same version as the store server."
+		return check
+	}
+	for _, c := range zbstorerpc.Capabilities() {
+		if c == zbstorerpc.CapabilityExecTrace || c == zbstorerpc.CapabilityInputUsage {
+			// Only advertised by stores on platforms that support tracing.
+			continue
+		}
+		if !resp.Has(c) {
+			check.Status = doctorWarning
+			check.Message = fmt.Sprintf("server at %s does not support %q", g.StoreSocket, c)
+			check.Fix = "Upgrade the store server to the same version of zb as this client."
+			return check
+		}
+	}
+	check.Message = fmt.Sprintf("connected to %s (protocol version %d)", g.StoreSocket, resp.ProtocolVersion)
+	if len(resp.SystemFeatures) > 0 {
+		check.Message += "; system features: " + strings.Join(resp.SystemFeatures, ", ")
+	}
+	return check
+}
+
+// checkFrontend reports the version and feature names
+// that Lua code can detect with zb.version and zb.hasFeature.
+func checkFrontend() *doctorCheck {
+	check := &doctorCheck{Name: "Lua frontend"}
+	version := zbVersion
+	if version == "" {
+		version = "version unknown (zb.version is nil)"
+	}
+	check.Message = fmt.Sprintf("%s; features: %s", version, strings.Join(frontend.Features(), ", "))
+	return check
+}
+
+// checkStoreDirectory checks that the store directory exists
+// and has the permissions that zb serve gives it.
+func checkStoreDirectory(g *globalConfig) *doctorCheck {
+	check := &doctorCheck{Name: "Store directory"}
+	if !g.Directory.IsNative() {
+		check.Status = doctorFailure
+		check.Message = fmt.Sprintf("%s cannot be used on %v", g.Directory, system.Current())
+		check.Fix = "Set storeDirectory to a path for this operating system."
+		return check
+	}
+	info, err := os.Stat(string(g.Directory))
+	if errors.Is(err, os.ErrNotExist) {
+		check.Status = doctorWarning
+		check.Message = fmt.Sprintf("%s does not exist", g.Directory)
+		check.Fix = "Run zb serve once to create it."
+		return check
+	}
+	if err != nil {
+		check.Status = doctorFailure
+		check.Message = err.Error()
+		check.Fix = fmt.Sprintf("Make sure %s is readable by your user.", g.Directory)
+		return check
+	}
+	check.Message, check.Status, check.Fix = storeDirectoryModeProblem(string(g.Directory), info.Mode())
+	return check
+}
+
+// storeDirectoryModeProblem reports whether the given file mode
+// is suitable for a store directory.
+// If the mode is suitable, then storeDirectoryModeProblem returns a message with [doctorOK].
+func storeDirectoryModeProblem(path string, mode fs.FileMode) (msg string, status doctorStatus, fix string) {
+	if !mode.IsDir() {
+		return fmt.Sprintf("%s is not a directory", path), doctorFailure,
+			fmt.Sprintf("Move %s out of the way and run zb serve to create the store.", path)
+	}
+	if runtime.GOOS == "windows" {
+		return path + " exists", doctorOK, ""
+	}
+	if mode.Perm()&0o005 != 0o005 {
+		return fmt.Sprintf("%s has mode %v and is not readable by all users", path, mode), doctorWarning,
+			fmt.Sprintf("Run chmod a+rx %s.", path)
+	}
+	if mode.Perm()&0o022 != 0 && mode&fs.ModeSticky == 0 {
+		return fmt.Sprintf("%s has mode %v and other users can delete store objects", path, mode), doctorWarning,
+			fmt.Sprintf("Run chmod +t %s.", path)
+	}
+	return fmt.Sprintf("%s has mode %v", path, mode), doctorOK, ""
+}
+
+// sandboxFilesystems is the list of filesystem types
+// that the Linux build sandbox mounts.
+var sandboxFilesystems = []string{"proc", "tmpfs", "devpts"}
+
+// checkSandbox checks whether zb serve is able to run builders in a sandbox.
+func checkSandbox() *doctorCheck {
+	check := &doctorCheck{Name: "Build sandbox"}
+	if !backend.SystemSupportsSandbox() {
+		check.Status = doctorWarning
+		check.Message = fmt.Sprintf("sandboxing is not supported on %v", system.Current())
+		check.Fix = "Builds run without a sandbox on this system. Review the derivations you build."
+		return check
+	}
+	if runtime.GOOS == "linux" {
+		data, err := os.ReadFile("/proc/filesystems")
+		if err != nil {
+			check.Status = doctorFailure
+			check.Message = err.Error()
+			check.Fix = "Mount procfs at /proc."
+			return check
+		}
+		if missing := missingFilesystems(data, sandboxFilesystems); len(missing) > 0 {
+			check.Status = doctorFailure
+			check.Message = fmt.Sprintf("kernel does not support filesystems: %s", strings.Join(missing, ", "))
+			check.Fix = "Use a kernel with support for the listed filesystems, or run zb serve with --no-sandbox."
+			return check
+		}
+	}
+	if !backend.CanSandbox() {
+		check.Status = doctorWarning
+		check.Message = "sandboxing requires running zb serve as root or unprivileged user namespaces"
+		check.Fix = "Enable unprivileged user namespaces in the kernel, run zb serve as root with --build-users-group, or pass --no-sandbox to zb serve."
+		return check
+	}
+	check.Message = "sandboxing is available"
+	return check
+}
+
+// missingFilesystems returns the elements of want
+// that are not listed in the contents of a Linux /proc/filesystems file.
+func missingFilesystems(procFilesystems []byte, want []string) []string {
+	var have []string
+	s := bufio.NewScanner(bytes.NewReader(procFilesystems))
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) > 0 {
+			have = append(have, fields[len(fields)-1])
+		}
+	}
+	var missing []string
+	for _, fsType := range want {
+		if !slices.Contains(have, fsType) {
+			missing = append(missing, fsType)
+		}
+	}
+	return missing
+}
+
+// checkDatabase runs a consistency check on the SQLite database at path.
+func checkDatabase(ctx context.Context, name string, path string) *doctorCheck {
+	check := &doctorCheck{Name: name}
+	if path == "" {
+		check.Status = doctorWarning
+		check.Message = "no path configured"
+		return check
+	}
+	if path == backend.MemoryDatabase {
+		check.Message = "kept in memory by the server"
+		return check
+	}
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		check.Message = fmt.Sprintf("%s has not been created yet", path)
+		return check
+	}
+	conn, err := sqlite.OpenConn(path, sqlite.OpenReadOnly)
+	if err != nil {
+		check.Status = doctorWarning
+		check.Message = fmt.Sprintf("cannot open %s: %v", path, err)
+		check.Fix = "Run zb doctor as the user that owns the database to check it."
+		return check
+	}
+	defer conn.Close()
+	conn.SetInterrupt(ctx.Done())
+
+	var problems []string
+	err = sqlitex.ExecuteTransient(conn, "PRAGMA quick_check;", &sqlitex.ExecOptions{
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			if msg := stmt.ColumnText(0); msg != "ok" {
+				problems = append(problems, msg)
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		check.Status = doctorWarning
+		check.Message = fmt.Sprintf("cannot check %s: %v", path, err)
+		check.Fix = "Run zb doctor as the user that owns the database to check it."
+		return check
+	}
+	if len(problems) > 0 {
+		check.Status = doctorFailure
+		check.Message = fmt.Sprintf("%s is corrupted: %s", path, strings.Join(problems, "; "))
+		check.Fix = fmt.Sprintf("Restore %s from a backup, or stop zb and run sqlite3 %s 'PRAGMA integrity_check;' for details.", path, path)
+		return check
+	}
+	check.Message = path + " passed integrity check"
+	return check
+}
+
+// checkKeys checks that signing key files can be read
+// and reports whether realizations are verified.
+func checkKeys(g *globalConfig, keyFiles []string) *doctorCheck {
+	check := &doctorCheck{Name: "Keys"}
+	if _, err := readKeyringFromFiles(keyFiles); err != nil {
+		check.Status = doctorFailure
+		check.Message = err.Error()
+		check.Fix = "Generate a new signing key with zb key generate."
+		return check
+	}
+	var parts []string
+	if n := len(keyFiles); n == 1 {
+		parts = append(parts, "1 signing key")
+	} else if n > 1 {
+		parts = append(parts, fmt.Sprintf("%d signing keys", n))
+	}
+	if len(g.TrustedPublicKeys) == 0 {
+		check.Status = doctorWarning
+		check.Message = strings.Join(append(parts, "no trusted public keys, so realizations from any source are reused"), ", ")
+		check.Fix = "Add keys from zb key show-public to trustedPublicKeys in the configuration file."
+		return check
+	}
+	if n := len(g.TrustedPublicKeys); n == 1 {
+		parts = append(parts, "1 trusted public key")
+	} else {
+		parts = append(parts, fmt.Sprintf("%d trusted public keys", n))
+	}
+	check.Message = strings.Join(parts, ", ")
+	return check
+}
+
+// checkSubstituter checks that the server's download store is reachable.
+func checkSubstituter(ctx context.Context, g *globalConfig, timeout time.Duration) *doctorCheck {
+	check := &doctorCheck{Name: "Substituter"}
+	sc := g.Server.Download
+	if sc.isNull() {
+		check.Message = "none configured"
+		return check
+	}
+	if sc.Type != "http" {
+		check.Status = doctorFailure
+		check.Message = fmt.Sprintf("unknown store type %q", sc.Type)
+		check.Fix = "Set server.download.type to http or null."
+		return check
+	}
+	var props storeConfigHTTPProperties
+	if err := jsonv2.Unmarshal(sc.Properties, &props); err != nil {
+		check.Status = doctorFailure
+		check.Message = err.Error()
+		check.Fix = "Set server.download.url to an absolute URL."
+		return check
+	}
+	u, err := url.Parse(props.URL)
+	if err != nil || !u.IsAbs() {
+		check.Status = doctorFailure
+		check.Message = fmt.Sprintf("%q is not an absolute URL", props.URL)
+		check.Fix = "Set server.download.url to an absolute URL."
+		return check
+	}
+
+	httpClient, httpCloser, err := g.newHTTPClient()
+	if err != nil {
+		check.Status = doctorFailure
+		check.Message = err.Error()
+		return check
+	}
+	defer func() {
+		httpClient.CloseIdleConnections()
+		httpCloser.Close()
+	}()
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, u.String(), nil)
+	if err != nil {
+		check.Status = doctorFailure
+		check.Message = err.Error()
+		return check
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		check.Status = doctorFailure
+		check.Message = fmt.Sprintf("cannot reach %s: %v", u.Redacted(), err)
+		check.Fix = "Check your network connection and proxy settings, or change server.download in the configuration file."
+		return check
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		check.Status = doctorFailure
+		check.Message = fmt.Sprintf("%s responded with %s", u.Redacted(), resp.Status)
+		check.Fix = "Try again later, or change server.download in the configuration file."
+		return check
+	}
+	check.Message = u.Redacted() + " is reachable"
+	return check
+}
@@ -0,0 +1,48 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"io/fs"
+	"runtime"
+	"slices"
+	"testing"
+)
+
+func TestStoreDirectoryModeProblem(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Store directory modes are not checked on Windows")
+	}
+	tests := []struct {
+		mode fs.FileMode
+		want doctorStatus
+	}{
+		{mode: fs.ModeDir | fs.ModeSticky | 0o775, want: doctorOK},
+		{mode: fs.ModeDir | 0o755, want: doctorOK},
+		{mode: fs.ModeDir | 0o775, want: doctorWarning},
+		{mode: fs.ModeDir | fs.ModeSticky | 0o770, want: doctorWarning},
+		{mode: 0o644, want: doctorFailure},
+	}
+	for _, test := range tests {
+		msg, got, fix := storeDirectoryModeProblem("/opt/zb/store", test.mode)
+		if got != test.want {
+			t.Errorf("storeDirectoryModeProblem(\"/opt/zb/store\", %v) = %q, %v, %q; want status %v", test.mode, msg, got, fix, test.want)
+		}
+		if got != doctorOK && fix == "" {
+			t.Errorf("storeDirectoryModeProblem(\"/opt/zb/store\", %v) did not suggest a fix", test.mode)
+		}
+	}
+}
+
+func TestMissingFilesystems(t *testing.T) {
+	const procFilesystems = "nodev\tsysfs\n" +
+		"nodev\ttmpfs\n" +
+		"nodev\tproc\n" +
+		"\text4\n"
+	got := missingFilesystems([]byte(procFilesystems), []string{"proc", "tmpfs", "devpts"})
+	want := []string{"devpts"}
+	if !slices.Equal(got, want) {
+		t.Errorf("missingFilesystems(...) = %q; want %q", got, want)
+	}
+}
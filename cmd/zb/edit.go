@@ -0,0 +1,133 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"zb.256lights.llc/pkg/internal/frontend"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+	"zombiezen.com/go/log"
+)
+
+type editCommand struct {
+	evalOptions `kong:"embed"`
+	Print       bool `kong:"help=Print the source position instead of opening an editor."`
+}
+
+func (c *editCommand) Signature() string {
+	return `kong:"help=Open the Lua source that defines a derivation in an editor."`
+}
+
+func (c *editCommand) Run(ctx context.Context, g *globalConfig) error {
+	pos, err := c.position(ctx, g)
+	if err != nil {
+		return err
+	}
+	if c.Print {
+		_, err := fmt.Println(pos)
+		return err
+	}
+
+	editor := cmp.Or(os.Getenv("VISUAL"), os.Getenv("EDITOR"), defaultEditor())
+	argv, err := editorCommand(editor, pos)
+	if err != nil {
+		return err
+	}
+	log.Debugf(ctx, "Running %s", strings.Join(argv, " "))
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// position evaluates the command's arguments
+// and returns the position of the derivation function call.
+func (c *editCommand) position(ctx context.Context, g *globalConfig) (frontend.Position, error) {
+	httpClient, httpCloser, err := g.newHTTPClient()
+	if err != nil {
+		return frontend.Position{}, err
+	}
+	defer func() {
+		httpClient.CloseIdleConnections()
+		if err := httpCloser.Close(); err != nil {
+			log.Warnf(ctx, "%v", err)
+		}
+	}()
+	di := new(zbstorerpc.DeferredImporter)
+	storeClient := g.storeClient(&zbstorerpc.CodecOptions{
+		Importer: di,
+	})
+	defer storeClient.Close()
+	eval, err := c.newEval(g, httpClient, storeClient, di)
+	if err != nil {
+		return frontend.Position{}, err
+	}
+	defer func() {
+		if err := eval.Close(); err != nil {
+			log.Errorf(ctx, "%v", err)
+		}
+	}()
+
+	var results []any
+	if c.Expression {
+		results = make([]any, 1)
+		results[0], err = eval.Expression(ctx, c.Args[0])
+	} else {
+		results, err = eval.URLs(ctx, c.Args)
+	}
+	if err != nil {
+		return frontend.Position{}, evaluationError(err)
+	}
+	if len(results) != 1 {
+		return frontend.Position{}, fmt.Errorf("evaluation produced %d results (need exactly one derivation)", len(results))
+	}
+	drv, _ := results[0].(*frontend.Derivation)
+	if drv == nil {
+		return frontend.Position{}, fmt.Errorf("%v is not a derivation", results[0])
+	}
+	if !drv.Position.IsValid() {
+		return frontend.Position{}, fmt.Errorf("%s was not created from a Lua file", drv.Path)
+	}
+	return drv.Position, nil
+}
+
+// editorCommand returns the command line that opens the given position
+// in the editor named by the $EDITOR-style string editor.
+// Most editors accept a +line argument before the file name.
+// Visual Studio Code uses --goto instead.
+func editorCommand(editor string, pos frontend.Position) ([]string, error) {
+	argv := strings.Fields(editor)
+	if len(argv) == 0 {
+		return nil, errors.New("no editor configured (set $EDITOR)")
+	}
+	if pos.Line <= 0 {
+		return append(argv, pos.Filename), nil
+	}
+	switch name := strings.TrimSuffix(filepath.Base(argv[0]), ".exe"); name {
+	case "code", "code-insiders", "codium":
+		return append(argv, "--goto", pos.String()), nil
+	case "notepad":
+		return append(argv, pos.Filename), nil
+	default:
+		return append(argv, "+"+strconv.Itoa(pos.Line), pos.Filename), nil
+	}
+}
+
+func defaultEditor() string {
+	if runtime.GOOS == "windows" {
+		return "notepad"
+	}
+	return "vi"
+}
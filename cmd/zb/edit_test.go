@@ -0,0 +1,34 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"zb.256lights.llc/pkg/internal/frontend"
+)
+
+func TestEditorCommand(t *testing.T) {
+	pos := frontend.Position{Filename: "/src/hello.lua", Line: 42}
+	tests := []struct {
+		editor string
+		pos    frontend.Position
+		want   []string
+	}{
+		{editor: "vi", pos: pos, want: []string{"vi", "+42", "/src/hello.lua"}},
+		{editor: "emacs -nw", pos: pos, want: []string{"emacs", "-nw", "+42", "/src/hello.lua"}},
+		{editor: "/usr/bin/code --wait", pos: pos, want: []string{"/usr/bin/code", "--wait", "--goto", "/src/hello.lua:42"}},
+		{editor: "vi", pos: frontend.Position{Filename: "/src/hello.lua"}, want: []string{"vi", "/src/hello.lua"}},
+	}
+	for _, test := range tests {
+		got, err := editorCommand(test.editor, test.pos)
+		if err != nil || !slices.Equal(got, test.want) {
+			t.Errorf("editorCommand(%q, %v) = %q, %v; want %q, <nil>", test.editor, test.pos, got, err, test.want)
+		}
+	}
+	if got, err := editorCommand("", pos); err == nil {
+		t.Errorf("editorCommand(\"\", %v) = %q, <nil>; want error", pos, got)
+	}
+}
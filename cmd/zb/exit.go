@@ -0,0 +1,224 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	jsonv2 "github.com/go-json-experiment/json"
+	"zb.256lights.llc/pkg/internal/frontend"
+	"zb.256lights.llc/pkg/internal/jsonrpc"
+	"zombiezen.com/go/log"
+)
+
+// exitCode is a process exit status that zb uses
+// to indicate what kind of failure occurred.
+type exitCode int
+
+// Defined exit codes.
+const (
+	exitSuccess exitCode = 0
+	// exitFailure is used for errors that do not fit in another category.
+	exitFailure exitCode = 1
+	// exitUsage is used when the command line could not be parsed.
+	exitUsage exitCode = 2
+	// exitEvaluation is used when evaluating Lua failed.
+	exitEvaluation exitCode = 3
+	// exitBuild is used when one or more derivations failed to build.
+	exitBuild exitCode = 4
+	// exitSubstitution is used when store objects could not be obtained from a substituter
+	// and building them was not permitted (see zb build --substitute-only).
+	exitSubstitution exitCode = 5
+	// exitNetwork is used when communication with the store or a remote server failed.
+	exitNetwork exitCode = 6
+	// exitCheck is used when the derivations built successfully
+	// but one or more of their checks failed (see zb build --with-checks).
+	exitCheck exitCode = 7
+	// exitCanceled is used when the command was interrupted.
+	// It matches the status that shells use for SIGINT.
+	exitCanceled exitCode = 130
+)
+
+// String returns the category name used in --error-format=json output.
+func (code exitCode) String() string {
+	switch code {
+	case exitSuccess:
+		return "success"
+	case exitFailure:
+		return "error"
+	case exitUsage:
+		return "usage"
+	case exitEvaluation:
+		return "evaluation"
+	case exitBuild:
+		return "build"
+	case exitSubstitution:
+		return "substitution"
+	case exitNetwork:
+		return "network"
+	case exitCheck:
+		return "check"
+	case exitCanceled:
+		return "canceled"
+	default:
+		return fmt.Sprintf("exitCode(%d)", int(code))
+	}
+}
+
+// exitCodeError is an error with an explicit [exitCode].
+type exitCodeError struct {
+	code exitCode
+	err  error
+}
+
+// withExitCode returns an error that wraps err
+// and will return code from [errorExitCode].
+// If err is nil, withExitCode returns nil.
+func withExitCode(code exitCode, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &exitCodeError{code, err}
+}
+
+// evaluationError returns an error that wraps err
+// and will return [exitEvaluation] from [errorExitCode],
+// unless err already has a more specific exit code
+// (for example, because communicating with the store failed).
+// If err is nil, evaluationError returns nil.
+func evaluationError(err error) error {
+	if err == nil || errorExitCode(err) != exitFailure {
+		return err
+	}
+	return withExitCode(exitEvaluation, err)
+}
+
+// substitutionError returns an error that wraps err
+// and will return [exitSubstitution] from [errorExitCode]
+// if substituteOnly is true and err is a build failure.
+// Builds that only permit substitution fail
+// when a derivation's outputs cannot be reused or substituted.
+// Otherwise, substitutionError returns err unchanged.
+func substitutionError(substituteOnly bool, err error) error {
+	if !substituteOnly || errorExitCode(err) != exitBuild {
+		return err
+	}
+	return withExitCode(exitSubstitution, err)
+}
+
+func (e *exitCodeError) Error() string { return e.err.Error() }
+func (e *exitCodeError) Unwrap() error { return e.err }
+
+// errorExitCode returns the exit code that zb should use for err.
+func errorExitCode(err error) exitCode {
+	switch {
+	case err == nil:
+		return exitSuccess
+	case errors.Is(err, context.Canceled):
+		return exitCanceled
+	}
+	if e := (*exitCodeError)(nil); errors.As(err, &e) {
+		return e.code
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		// Requests to the store time out if the store stops responding.
+		return exitNetwork
+	}
+	if code, ok := jsonrpc.CodeFromError(err); ok && code != jsonrpc.RequestCancelled {
+		return exitNetwork
+	}
+	if e := net.Error(nil); errors.As(err, &e) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return exitNetwork
+	}
+	return exitFailure
+}
+
+// trimTraceback removes the Lua stack tracebacks from an evaluation error message.
+// The message before the first traceback
+// already includes the position where the error was raised.
+func trimTraceback(msg string) string {
+	if i := strings.Index(msg, "\nstack traceback:"); i >= 0 {
+		return msg[:i]
+	}
+	return msg
+}
+
+// Values for --error-format.
+const (
+	textErrorFormat = "text"
+	jsonErrorFormat = "json"
+)
+
+// errorRecord is the structure printed for --error-format=json.
+type errorRecord struct {
+	Error    string `json:"error"`
+	Category string `json:"category"`
+	ExitCode int    `json:"exitCode"`
+}
+
+// writeErrorRecord writes the JSON record for an error with the given exit code to w
+// as a single line.
+func writeErrorRecord(w io.Writer, code exitCode, err error) error {
+	data, marshalErr := jsonv2.Marshal(&errorRecord{
+		Error:    err.Error(),
+		Category: code.String(),
+		ExitCode: int(code),
+	})
+	if marshalErr != nil {
+		return marshalErr
+	}
+	data = append(data, '\n')
+	_, writeErr := w.Write(data)
+	return writeErr
+}
+
+// warningRecord is the structure printed for warnings with --error-format=json.
+type warningRecord struct {
+	Warning  string `json:"warning"`
+	Key      string `json:"key"`
+	Position string `json:"position,omitempty"`
+}
+
+// writeWarningRecord writes the JSON record for a warning to w as a single line.
+func writeWarningRecord(w io.Writer, warning *frontend.Warning) error {
+	data, err := jsonv2.Marshal(&warningRecord{
+		Warning:  warning.Message,
+		Key:      warning.Key,
+		Position: warning.Position,
+	})
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	_, err = w.Write(data)
+	return err
+}
+
+// reportWarning reports a warning from Lua code in the given format.
+func reportWarning(ctx context.Context, format string, w *frontend.Warning) {
+	if format == jsonErrorFormat {
+		if err := writeWarningRecord(os.Stderr, w); err == nil {
+			return
+		}
+	}
+	log.Warnf(ctx, "%v", w)
+}
+
+// exitWithError reports err in the given format and exits the process
+// with the given code.
+func exitWithError(ctx context.Context, format string, code exitCode, err error) {
+	if format == jsonErrorFormat {
+		if writeErr := writeErrorRecord(os.Stderr, code, err); writeErr == nil {
+			os.Exit(int(code))
+		}
+	}
+	log.Errorf(ctx, "%v", err)
+	os.Exit(int(code))
+}
@@ -0,0 +1,121 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	jsonv2 "github.com/go-json-experiment/json"
+	"zb.256lights.llc/pkg/internal/frontend"
+	"zb.256lights.llc/pkg/internal/jsonrpc"
+)
+
+func TestErrorExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want exitCode
+	}{
+		{name: "Nil", err: nil, want: exitSuccess},
+		{name: "Plain", err: errors.New("bork"), want: exitFailure},
+		{name: "Evaluation", err: withExitCode(exitEvaluation, errors.New("syntax error")), want: exitEvaluation},
+		{name: "WrappedBuild", err: fmt.Errorf("foo: %w", withExitCode(exitBuild, errors.New("build failed"))), want: exitBuild},
+		{name: "Canceled", err: fmt.Errorf("wait for build: %w", context.Canceled), want: exitCanceled},
+		{name: "CanceledEvaluation", err: withExitCode(exitEvaluation, context.Canceled), want: exitCanceled},
+		{name: "EvaluationError", err: evaluationError(errors.New("syntax error")), want: exitEvaluation},
+		{name: "EvaluationRPC", err: evaluationError(jsonrpc.Error(jsonrpc.InvalidParams, errors.New("bad path"))), want: exitNetwork},
+		{name: "EvaluationDial", err: evaluationError(fmt.Errorf("realize: %w", &net.OpError{Op: "dial", Net: "unix", Err: errors.New("connection refused")})), want: exitNetwork},
+		{name: "EvaluationBuild", err: evaluationError(fmt.Errorf("realize: %w", withExitCode(exitBuild, errors.New("build failed")))), want: exitBuild},
+		{name: "Substitution", err: substitutionError(true, withExitCode(exitBuild, errors.New("build failed"))), want: exitSubstitution},
+		{name: "SubstitutionDisabled", err: substitutionError(false, withExitCode(exitBuild, errors.New("build failed"))), want: exitBuild},
+		{name: "SubstitutionRPC", err: substitutionError(true, jsonrpc.Error(jsonrpc.InvalidParams, errors.New("bad path"))), want: exitNetwork},
+		{name: "RPC", err: jsonrpc.Error(jsonrpc.InvalidParams, errors.New("bad path")), want: exitNetwork},
+		{name: "Dial", err: &net.OpError{Op: "dial", Net: "unix", Err: errors.New("connection refused")}, want: exitNetwork},
+		{name: "Timeout", err: fmt.Errorf("call json rpc zb.info: %w", context.DeadlineExceeded), want: exitNetwork},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := errorExitCode(test.err); got != test.want {
+				t.Errorf("errorExitCode(%v) = %v; want %v", test.err, got, test.want)
+			}
+		})
+	}
+}
+
+func TestWriteErrorRecord(t *testing.T) {
+	sb := new(strings.Builder)
+	if err := writeErrorRecord(sb, exitBuild, errors.New("build 123 failed")); err != nil {
+		t.Fatal(err)
+	}
+	if got := sb.String(); strings.Count(got, "\n") != 1 || !strings.HasSuffix(got, "\n") {
+		t.Errorf("writeErrorRecord(...) wrote %q; want a single line", got)
+	}
+	var got errorRecord
+	if err := jsonv2.Unmarshal([]byte(sb.String()), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := errorRecord{
+		Error:    "build 123 failed",
+		Category: "build",
+		ExitCode: 4,
+	}
+	if got != want {
+		t.Errorf("writeErrorRecord(...) = %+v; want %+v", got, want)
+	}
+}
+
+func TestWriteWarningRecord(t *testing.T) {
+	sb := new(strings.Builder)
+	warning := &frontend.Warning{
+		Message:  "foo is deprecated; use bar",
+		Key:      "foo",
+		Position: "main.lua:12",
+	}
+	if err := writeWarningRecord(sb, warning); err != nil {
+		t.Fatal(err)
+	}
+	if got := sb.String(); strings.Count(got, "\n") != 1 || !strings.HasSuffix(got, "\n") {
+		t.Errorf("writeWarningRecord(...) wrote %q; want a single line", got)
+	}
+	var got warningRecord
+	if err := jsonv2.Unmarshal([]byte(sb.String()), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := warningRecord{
+		Warning:  "foo is deprecated; use bar",
+		Key:      "foo",
+		Position: "main.lua:12",
+	}
+	if got != want {
+		t.Errorf("writeWarningRecord(...) = %+v; want %+v", got, want)
+	}
+}
+
+func TestTrimTraceback(t *testing.T) {
+	tests := []struct {
+		msg  string
+		want string
+	}{
+		{msg: "syntax error", want: "syntax error"},
+		{
+			msg: "build.lua#hello: build.lua:3: assertion failed: boom\n" +
+				"stack traceback:\n" +
+				"\t[Go]: in function 'assertMsg'\n" +
+				"\tbuild.lua:3: in main chunk\n" +
+				"stack traceback:\n" +
+				"\t[Go]: in ?",
+			want: "build.lua#hello: build.lua:3: assertion failed: boom",
+		},
+	}
+	for _, test := range tests {
+		if got := trimTraceback(test.msg); got != test.want {
+			t.Errorf("trimTraceback(%q) = %q; want %q", test.msg, got, test.want)
+		}
+	}
+}
@@ -7,9 +7,11 @@ import (
 	"encoding/csv"
 	"fmt"
 	"iter"
+	"math"
 	"path/filepath"
 	"reflect"
 	"slices"
+	"strconv"
 	"strings"
 
 	"github.com/alecthomas/kong"
@@ -235,6 +237,33 @@ func decodeSlice(dc *kong.DecodeContext, mapper kong.Mapper, target reflect.Valu
 	return nil
 }
 
+// idRange is an inclusive range of Unix user or group IDs
+// given on the command line as "FIRST-LAST".
+// The zero value is an empty range.
+type idRange struct {
+	first int
+	count int
+}
+
+// UnmarshalText parses an ID range of the form "FIRST-LAST".
+func (r *idRange) UnmarshalText(text []byte) error {
+	firstString, lastString, ok := strings.Cut(string(text), "-")
+	if !ok {
+		return fmt.Errorf("parse id range %q: must be in the form FIRST-LAST", text)
+	}
+	first, err := strconv.Atoi(firstString)
+	if err != nil || first < 0 {
+		return fmt.Errorf("parse id range %q: invalid first id", text)
+	}
+	last, err := strconv.Atoi(lastString)
+	if err != nil || last < first {
+		return fmt.Errorf("parse id range %q: invalid last id", text)
+	}
+	r.first = first
+	r.count = last - first + 1
+	return nil
+}
+
 func parseNativeStorePath(s string) (zbstore.Path, error) {
 	s, err := filepath.Abs(s)
 	if err != nil {
@@ -251,3 +280,25 @@ func findFlagByName(name string, flags iter.Seq[*kong.Flag]) *kong.Flag {
 	}
 	return nil
 }
+
+// byteSize is a number of bytes given on the command line
+// as an integer with an optional binary unit suffix (e.g. "512M" or "2GiB").
+type byteSize int64
+
+// UnmarshalText parses a byte size.
+func (n *byteSize) UnmarshalText(text []byte) error {
+	s := strings.TrimSuffix(strings.TrimSuffix(string(text), "B"), "i")
+	shift := 0
+	if len(s) > 0 {
+		if i := strings.IndexByte("KMGT", s[len(s)-1]); i >= 0 {
+			shift = 10 * (i + 1)
+			s = s[:len(s)-1]
+		}
+	}
+	x, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || x < 0 || x > math.MaxInt64>>shift {
+		return fmt.Errorf("parse byte size %q: must be a non-negative integer with an optional K, M, G, or T suffix", text)
+	}
+	*n = byteSize(x << shift)
+	return nil
+}
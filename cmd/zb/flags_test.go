@@ -81,3 +81,64 @@ func TestMapPathMap(t *testing.T) {
 		}
 	}
 }
+
+func TestIDRange(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    idRange
+		wantErr bool
+	}{
+		{s: "30000-30999", want: idRange{first: 30000, count: 1000}},
+		{s: "5-5", want: idRange{first: 5, count: 1}},
+		{s: "30000", wantErr: true},
+		{s: "10-5", wantErr: true},
+		{s: "-1-5", wantErr: true},
+		{s: "a-b", wantErr: true},
+	}
+	for _, test := range tests {
+		var got idRange
+		err := got.UnmarshalText([]byte(test.s))
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("UnmarshalText(%q) = %+v, <nil>; want error", test.s, got)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("UnmarshalText(%q) = %+v, %v; want %+v, <nil>", test.s, got, err, test.want)
+		}
+	}
+}
+
+func TestByteSize(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    byteSize
+		wantErr bool
+	}{
+		{s: "0", want: 0},
+		{s: "4096", want: 4096},
+		{s: "512K", want: 512 << 10},
+		{s: "2G", want: 2 << 30},
+		{s: "2GiB", want: 2 << 30},
+		{s: "1TB", want: 1 << 40},
+		{s: "", wantErr: true},
+		{s: "G", wantErr: true},
+		{s: "-1M", wantErr: true},
+		{s: "1.5G", wantErr: true},
+		{s: "9999999T", wantErr: true},
+	}
+	for _, test := range tests {
+		var got byteSize
+		err := got.UnmarshalText([]byte(test.s))
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("UnmarshalText(%q) = %d, <nil>; want error", test.s, got)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("UnmarshalText(%q) = %d, %v; want %d, <nil>", test.s, got, err, test.want)
+		}
+	}
+}
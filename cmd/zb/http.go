@@ -11,8 +11,8 @@ import (
 	"net/http"
 	"net/url"
 
+	"zb.256lights.llc/pkg/internal/credentials"
 	"zb.256lights.llc/pkg/internal/fileurl"
-	"zb.256lights.llc/pkg/internal/netrc"
 	"zb.256lights.llc/pkg/internal/useragent"
 	"zb.256lights.llc/pkg/internal/xslices"
 )
@@ -20,13 +20,18 @@ import (
 type httpClient struct {
 	Transport     http.RoundTripper
 	FileTransport fileurl.Transport
-	Netrc         []byte
+	// Credentials is used to authenticate requests
+	// that do not already have credentials.
+	Credentials credentials.Provider
 }
 
 func (c *httpClient) Do(req *http.Request) (*http.Response, error) {
 	userAgentValues := req.Header.Values("User-Agent")
-	username, password, shouldAddAuth := c.authorization(req)
-	if len(userAgentValues) == 0 || shouldAddAuth {
+	creds, err := c.credentials(req)
+	if err != nil {
+		return nil, err
+	}
+	if len(userAgentValues) == 0 || creds != nil {
 		req = new(*req)
 		if req.Header == nil {
 			req.Header = make(http.Header)
@@ -36,8 +41,8 @@ func (c *httpClient) Do(req *http.Request) (*http.Response, error) {
 		if len(userAgentValues) == 0 {
 			req.Header.Set("User-Agent", useragent.String)
 		}
-		if shouldAddAuth {
-			req.SetBasicAuth(username, password)
+		if creds != nil {
+			creds.SetAuthorization(req)
 		}
 	}
 
@@ -60,34 +65,42 @@ func (c *httpClient) Do(req *http.Request) (*http.Response, error) {
 	return hc.Do(req)
 }
 
-func (c *httpClient) authorization(req *http.Request) (username, password string, rewrite bool) {
-	host := cmp.Or(req.Host, req.URL.Host)
+// credentials returns the credentials that should be added to req
+// or nil if req should be sent as-is.
+func (c *httpClient) credentials(req *http.Request) (*credentials.Credentials, error) {
+	if c.Credentials == nil || req.URL == nil {
+		return nil, nil
+	}
+	u := &url.URL{
+		Scheme: req.URL.Scheme,
+		User:   req.URL.User,
+		Host:   cmp.Or(req.Host, req.URL.Host),
+		Path:   req.URL.Path,
+	}
 	if req.Header.Get("Authorization") != "" {
-		var ok bool
-		username, password, ok = req.BasicAuth()
+		// Only fill in a missing password for a basic authentication username.
+		username, password, ok := req.BasicAuth()
 		if !ok || password != "" {
-			return username, password, false
+			return nil, nil
 		}
-		foundPassword, _ := netrc.FindUser(c.Netrc, host, username).Password()
-		if foundPassword == "" {
-			return username, password, false
+		u.User = url.User(username)
+		creds, err := c.Credentials.Credentials(req.Context(), u)
+		if err != nil {
+			return nil, fmt.Errorf("find credentials for %s: %v", u.Redacted(), err)
 		}
-		return username, foundPassword, true
-	}
-	if password, hasPassword := req.URL.User.Password(); hasPassword {
-		return req.URL.User.Username(), password, false
+		if creds == nil || creds.Token != "" || creds.Password == "" {
+			return nil, nil
+		}
+		return creds, nil
 	}
-	var userinfo *url.Userinfo
-	if username = req.URL.User.Username(); username != "" {
-		userinfo = netrc.FindUser(c.Netrc, host, username)
-	} else {
-		userinfo = netrc.Find(c.Netrc, host)
+	if _, hasPassword := req.URL.User.Password(); hasPassword {
+		return nil, nil
 	}
-	if userinfo == nil {
-		return req.URL.User.Username(), "", false
+	creds, err := c.Credentials.Credentials(req.Context(), u)
+	if err != nil {
+		return nil, fmt.Errorf("find credentials for %s: %v", u.Redacted(), err)
 	}
-	password, _ = userinfo.Password()
-	return userinfo.Username(), password, true
+	return creds, nil
 }
 
 func (c *httpClient) CloseIdleConnections() {
@@ -4,6 +4,7 @@
 package main
 
 import (
+	"bytes"
 	"cmp"
 	"context"
 	"crypto/ed25519"
@@ -11,12 +12,14 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 
 	"github.com/alecthomas/kong"
 	jsonv2 "github.com/go-json-experiment/json"
 	"github.com/go-json-experiment/json/jsontext"
 	"zb.256lights.llc/pkg/internal/backend"
 	"zb.256lights.llc/pkg/zbstore"
+	"zombiezen.com/go/log"
 )
 
 type privateKeyFile struct {
@@ -57,7 +60,8 @@ func readKeyringFromFiles(files []string) (*backend.Keyring, error) {
 
 type keyCommand struct {
 	Generate   generateKeyCommand   `kong:"cmd"`
-	ShowPublic showPublicKeyCommand `kong:"cmd"`
+	ShowPublic showPublicKeyCommand `kong:"cmd,aliases=export-public"`
+	Rotate     rotateKeyCommand     `kong:"cmd"`
 }
 
 func (*keyCommand) Signature() string {
@@ -79,26 +83,36 @@ func (c *generateKeyCommand) Run(ctx context.Context) error {
 	}
 	defer outputFile.Close()
 
-	_, newKey, err := ed25519.GenerateKey(nil)
+	keyFileData, err := generateKeyFile()
 	if err != nil {
 		return err
 	}
+	_, err = outputFile.Write(keyFileData)
+	err = errors.Join(err, outputFile.Close())
+	return err
+}
+
+// generateKeyFile returns the content of a new signing key file.
+func generateKeyFile() ([]byte, error) {
+	_, newKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		return nil, err
+	}
 	keyFile := &privateKeyFile{
 		Format: zbstore.Ed25519SignatureFormat,
 		Key:    newKey.Seed(),
 	}
 	keyFileData, err := jsonv2.Marshal(keyFile, jsontext.Multiline(true))
 	if err != nil {
-		return err
+		return nil, err
 	}
 	keyFileData = append(keyFileData, '\n')
-	_, err = outputFile.Write(keyFileData)
-	err = errors.Join(err, outputFile.Close())
-	return err
+	return keyFileData, nil
 }
 
 type showPublicKeyCommand struct {
-	Paths []string `kong:"arg,optional,name=file,help=Signing key files."`
+	Paths      []string `kong:"arg,optional,name=file,help=Signing key files."`
+	OutputPath string   `kong:"name=output,short=o,placeholder=file,help=File to write to. (Default: stdout)"`
 }
 
 func (c *showPublicKeyCommand) Signature() string {
@@ -106,15 +120,29 @@ func (c *showPublicKeyCommand) Signature() string {
 }
 
 func (c *showPublicKeyCommand) Run(k *kong.Kong) error {
+	if c.OutputPath == "" {
+		return c.runFiles(k.Stdout)
+	}
+	outputFile, err := openOutputFile(c.OutputPath)
+	if err != nil {
+		return err
+	}
+	defer outputFile.Close()
+	err = c.runFiles(outputFile)
+	err = errors.Join(err, outputFile.Close())
+	return err
+}
+
+func (c *showPublicKeyCommand) runFiles(dst io.Writer) error {
 	if len(c.Paths) == 0 {
-		return c.run(k.Stdout, os.Stdin)
+		return c.run(dst, os.Stdin)
 	}
 	for _, path := range c.Paths {
 		f, err := os.Open(path)
 		if err != nil {
 			return err
 		}
-		err = c.run(k.Stdout, f)
+		err = c.run(dst, f)
 		f.Close()
 		if err != nil {
 			return err
@@ -148,3 +176,71 @@ func (c *showPublicKeyCommand) run(dst io.Writer, src io.Reader) error {
 	_, err = dst.Write(data)
 	return err
 }
+
+type rotateKeyCommand struct {
+	Path        string `kong:"arg,name=file,help=Signing key file to replace."`
+	RetiredPath string `kong:"name=retired,placeholder=file,help=File to move the old key to. (Default: FILE.old)"`
+}
+
+func (c *rotateKeyCommand) Signature() string {
+	return `help:"Replace a signing key file with a new key and print the new public key."`
+}
+
+func (c *rotateKeyCommand) Run(ctx context.Context, k *kong.Kong) error {
+	retiredPath := cmp.Or(c.RetiredPath, c.Path+".old")
+	newKeyData, err := rotateKeyFile(c.Path, retiredPath)
+	if err != nil {
+		return err
+	}
+	if err := new(showPublicKeyCommand).run(k.Stdout, bytes.NewReader(newKeyData)); err != nil {
+		return err
+	}
+	log.Infof(ctx, "Moved old key to %s. "+
+		"Restart zb serve with the new key and run \"zb store sign --all --key %s\" to sign existing realizations.",
+		retiredPath, c.Path)
+	return nil
+}
+
+// rotateKeyFile moves the signing key file at path to retiredPath
+// and writes a new key to path.
+// It returns the content of the new key file.
+// rotateKeyFile returns an error without making any changes
+// if the file at path is not a valid key file or retiredPath already exists.
+func rotateKeyFile(path, retiredPath string) (newKeyData []byte, err error) {
+	if _, err := readKeyringFromFiles([]string{path}); err != nil {
+		return nil, err
+	}
+	if _, err := os.Lstat(retiredPath); err == nil {
+		return nil, fmt.Errorf("rotate %s: %s already exists", path, retiredPath)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("rotate %s: %v", path, err)
+	}
+	newKeyData, err = generateKeyFile()
+	if err != nil {
+		return nil, fmt.Errorf("rotate %s: %v", path, err)
+	}
+
+	// Write the new key next to the old one so that it can be renamed into place.
+	f, err := os.CreateTemp(filepath.Dir(path), ".zb-key-*")
+	if err != nil {
+		return nil, fmt.Errorf("rotate %s: %v", path, err)
+	}
+	tempPath := f.Name()
+	_, err = f.Write(newKeyData)
+	err = errors.Join(err, f.Close())
+	if err != nil {
+		os.Remove(tempPath)
+		return nil, fmt.Errorf("rotate %s: %v", path, err)
+	}
+	if err := os.Rename(path, retiredPath); err != nil {
+		os.Remove(tempPath)
+		return nil, fmt.Errorf("rotate %s: %v", path, err)
+	}
+	if err := os.Rename(tempPath, path); err != nil {
+		// Put the old key back so that the store can still use it.
+		os.Rename(retiredPath, path)
+		os.Remove(tempPath)
+		return nil, fmt.Errorf("rotate %s: %v", path, err)
+	}
+	return newKeyData, nil
+}
@@ -0,0 +1,55 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRotateKeyFile(t *testing.T) {
+	dir := t.TempDir()
+	keyPath := filepath.Join(dir, "key.json")
+	retiredPath := filepath.Join(dir, "key.json.old")
+	oldKeyData, err := generateKeyFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyPath, oldKeyData, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	newKeyData, err := rotateKeyFile(keyPath, retiredPath)
+	if err != nil {
+		t.Fatal("rotateKeyFile:", err)
+	}
+	if bytes.Equal(newKeyData, oldKeyData) {
+		t.Error("new key is the same as the old key")
+	}
+	if got, err := os.ReadFile(keyPath); err != nil {
+		t.Error(err)
+	} else if !bytes.Equal(got, newKeyData) {
+		t.Errorf("%s content = %q; want %q", keyPath, got, newKeyData)
+	}
+	if got, err := os.ReadFile(retiredPath); err != nil {
+		t.Error(err)
+	} else if !bytes.Equal(got, oldKeyData) {
+		t.Errorf("%s content = %q; want %q", retiredPath, got, oldKeyData)
+	}
+	if _, err := readKeyringFromFiles([]string{keyPath}); err != nil {
+		t.Error(err)
+	}
+
+	// Rotating again must not overwrite the retired key.
+	if _, err := rotateKeyFile(keyPath, retiredPath); err == nil {
+		t.Error("second rotateKeyFile with same retired path did not return an error")
+	}
+	if got, err := os.ReadFile(keyPath); err != nil {
+		t.Error(err)
+	} else if !bytes.Equal(got, newKeyData) {
+		t.Errorf("after failed rotation, %s content = %q; want %q", keyPath, got, newKeyData)
+	}
+}
@@ -0,0 +1,223 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	jsonv2 "github.com/go-json-experiment/json"
+	"github.com/go-json-experiment/json/jsontext"
+	"golang.org/x/term"
+	"zb.256lights.llc/pkg/internal/jsonrpc"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+	"zb.256lights.llc/pkg/zbstore"
+	"zombiezen.com/go/log"
+)
+
+type logCommand struct {
+	BuildID    string   `kong:"arg,name=build-id,help=ID of the build."`
+	DrvPaths   []string `kong:"arg,name=drv-path,optional,help=Derivations to show logs for. Shows every derivation in the build if omitted."`
+	Trace      bool     `kong:"help=Show the programs that each builder executed instead of its log. The build must have been started with zb build --trace-exec."`
+	JSONFormat bool     `kong:"name=json,help=Print each program as a JSON object (requires --trace)."`
+}
+
+func (c *logCommand) Signature() string {
+	return `kong:"help=Show the builder logs of a previous build."`
+}
+
+//go:embed docs/log.txt
+var logDoc string
+
+func (c *logCommand) Help() string {
+	return logDoc
+}
+
+func (c *logCommand) Run(ctx context.Context, g *globalConfig) error {
+	if c.JSONFormat && !c.Trace {
+		return fmt.Errorf("--json requires --trace")
+	}
+	storeClient := g.storeClient(nil)
+	defer storeClient.Close()
+	handshake, err := zbstorerpc.Handshake(ctx, storeClient)
+	if err != nil {
+		return err
+	}
+	if c.Trace {
+		// Stores that predate exec tracing ignore the trace field
+		// and would return the builder log.
+		if err := handshake.Require(zbstorerpc.CapabilityExecTrace, "zb log --trace"); err != nil {
+			return err
+		}
+	}
+
+	var drvPaths []zbstore.Path
+	for _, arg := range c.DrvPaths {
+		drvPath, err := zbstore.ParsePath(arg)
+		if err != nil {
+			return err
+		}
+		drvPaths = append(drvPaths, drvPath)
+	}
+	if len(drvPaths) == 0 {
+		build := new(zbstorerpc.Build)
+		err := jsonrpc.Do(ctx, storeClient, zbstorerpc.GetBuildMethod, build, &zbstorerpc.GetBuildRequest{
+			BuildID: c.BuildID,
+		})
+		if err != nil {
+			return err
+		}
+		if build.Status == zbstorerpc.BuildUnknown {
+			return fmt.Errorf("build %s not found in store", c.BuildID)
+		}
+		for _, result := range build.Results {
+			drvPaths = append(drvPaths, result.DrvPath)
+		}
+	}
+
+	if !c.Trace {
+		format := logFormat{
+			prefixed: handshake.Has(zbstorerpc.CapabilityLogTimestamps),
+			colorStderr: handshake.Has(zbstorerpc.CapabilityLogStreams) &&
+				os.Getenv("NO_COLOR") == "" &&
+				term.IsTerminal(int(os.Stdout.Fd())),
+		}
+		for _, drvPath := range drvPaths {
+			if err := copyLog(ctx, os.Stdout, storeClient, c.BuildID, drvPath, format); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, drvPath := range drvPaths {
+		data, err := readFullLog(ctx, storeClient, &zbstorerpc.ReadLogRequest{
+			BuildID: c.BuildID,
+			DrvPath: drvPath,
+			Trace:   true,
+		})
+		if err != nil {
+			return err
+		}
+		if len(data) == 0 {
+			if len(c.DrvPaths) > 0 {
+				log.Warnf(ctx, "No exec trace recorded for %s in build %s", drvPath, c.BuildID)
+			}
+			continue
+		}
+		if c.JSONFormat {
+			if _, err := os.Stdout.Write(data); err != nil {
+				return err
+			}
+			continue
+		}
+		events, err := parseExecTrace(data)
+		if err != nil {
+			return fmt.Errorf("read exec trace for %s in build %s: %v", drvPath, c.BuildID, err)
+		}
+		var buf []byte
+		buf = append(buf, "--- "...)
+		buf = append(buf, drvPath...)
+		buf = append(buf, " ---\n"...)
+		buf = appendExecTrace(buf, events)
+		if _, err := os.Stdout.Write(buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readFullLog reads the log described by req from its RangeStart to its end.
+func readFullLog(ctx context.Context, storeClient jsonrpc.Handler, req *zbstorerpc.ReadLogRequest) ([]byte, error) {
+	r := *req
+	req = &r
+	var data []byte
+	for {
+		payload, err := readLog(ctx, storeClient, req)
+		data = append(data, payload...)
+		if err == io.EOF {
+			return data, nil
+		}
+		if err != nil {
+			return data, err
+		}
+		req.RangeStart += int64(len(payload))
+	}
+}
+
+// parseExecTrace parses a sequence of [zbstorerpc.ExecTraceEvent] JSON objects
+// and returns them sorted by the time each program started.
+func parseExecTrace(data []byte) ([]*zbstorerpc.ExecTraceEvent, error) {
+	var events []*zbstorerpc.ExecTraceEvent
+	dec := jsontext.NewDecoder(bytes.NewReader(data))
+	for {
+		ev := new(zbstorerpc.ExecTraceEvent)
+		if err := jsonv2.UnmarshalDecode(dec, ev); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return events, err
+		}
+		events = append(events, ev)
+	}
+	slices.SortStableFunc(events, func(ev1, ev2 *zbstorerpc.ExecTraceEvent) int {
+		return ev1.StartedAt.Compare(ev2.StartedAt)
+	})
+	return events, nil
+}
+
+// appendExecTrace appends a human-readable listing of events to dst
+// and returns the resulting slice.
+// Each line shows when the program started relative to the first event,
+// how long it ran, its exit code, its working directory, and its arguments.
+// events must be sorted by start time.
+func appendExecTrace(dst []byte, events []*zbstorerpc.ExecTraceEvent) []byte {
+	if len(events) == 0 {
+		return dst
+	}
+	start := events[0].StartedAt
+	for _, ev := range events {
+		dst = fmt.Appendf(dst, "+%-9s %9s  ", formatTraceDuration(ev.StartedAt.Sub(start)), formatTraceDuration(ev.Duration()))
+		if ev.ExitCode.Valid {
+			dst = fmt.Appendf(dst, "exit %-3d", ev.ExitCode.X)
+		} else {
+			dst = append(dst, "-       "...)
+		}
+		dst = append(dst, "  "...)
+		dst = append(dst, cmp.Or(ev.Dir, "?")...)
+		dst = append(dst, " $"...)
+		for _, arg := range ev.Argv {
+			dst = append(dst, ' ')
+			dst = appendShellWord(dst, arg)
+		}
+		dst = append(dst, '\n')
+	}
+	return dst
+}
+
+func formatTraceDuration(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64) + "s"
+}
+
+// appendShellWord appends s to dst,
+// quoting it if it contains characters that a POSIX shell would interpret.
+func appendShellWord(dst []byte, s string) []byte {
+	const safe = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789@%_-+=:,./"
+	if s != "" && strings.Trim(s, safe) == "" {
+		return append(dst, s...)
+	}
+	dst = append(dst, '\'')
+	dst = append(dst, strings.ReplaceAll(s, "'", `'\''`)...)
+	dst = append(dst, '\'')
+	return dst
+}
@@ -0,0 +1,44 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+)
+
+func TestParseExecTrace(t *testing.T) {
+	// Events are written when programs end,
+	// so a parent comes after its children.
+	const data = `{"pid":2,"argv":["cc","-c","it's.c"],"dir":"/build/src","startedAt":"2026-01-01T00:00:00.5Z","endedAt":"2026-01-01T00:00:01.75Z","exitCode":1}` + "\n" +
+		`{"pid":1,"argv":["/bin/sh","-c","make"],"dir":"/build","startedAt":"2026-01-01T00:00:00Z","endedAt":"2026-01-01T00:00:02Z","exitCode":null}` + "\n"
+	events, err := parseExecTrace([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].PID != 1 || events[1].PID != 2 {
+		t.Fatalf("parseExecTrace(...) = %+v; want events sorted by start time", events)
+	}
+	const want = "+0.000s       2.000s  -         /build $ /bin/sh -c make\n" +
+		"+0.500s       1.250s  exit 1    /build/src $ cc -c 'it'\\''s.c'\n"
+	if got := string(appendExecTrace(nil, events)); got != want {
+		t.Errorf("appendExecTrace(nil, events) =\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestAppendExecTraceEmptyArgv(t *testing.T) {
+	start := time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC)
+	events := []*zbstorerpc.ExecTraceEvent{{
+		PID:       1,
+		StartedAt: start,
+		EndedAt:   start,
+		ExitCode:  zbstorerpc.NonNull(137),
+	}}
+	const want = "+0.000s       0.000s  exit 137  ? $\n"
+	if got := string(appendExecTrace(nil, events)); got != want {
+		t.Errorf("appendExecTrace(nil, events) = %q; want %q", got, want)
+	}
+}
@@ -4,7 +4,9 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
@@ -16,6 +18,7 @@ import (
 	"runtime"
 	"slices"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -23,6 +26,7 @@ import (
 	jsonv2 "github.com/go-json-experiment/json"
 	"github.com/go-json-experiment/json/jsontext"
 	kongcompletion "github.com/jotaen/kong-completion"
+	"golang.org/x/term"
 	"zb.256lights.llc/pkg/bytebuffer"
 	"zb.256lights.llc/pkg/internal/backend"
 	"zb.256lights.llc/pkg/internal/frontend"
@@ -30,6 +34,7 @@ import (
 	"zb.256lights.llc/pkg/internal/luac"
 	"zb.256lights.llc/pkg/internal/lualex"
 	"zb.256lights.llc/pkg/internal/osutil"
+	"zb.256lights.llc/pkg/internal/system"
 	"zb.256lights.llc/pkg/internal/zbstorerpc"
 	"zb.256lights.llc/pkg/sets"
 	"zb.256lights.llc/pkg/zbstore"
@@ -43,12 +48,22 @@ type zbCommand struct {
 	Build      buildCommand      `kong:"cmd"`
 	Eval       evalCommand       `kong:"cmd"`
 	Derivation derivationCommand `kong:"cmd"`
+	Search     searchCommand     `kong:"cmd"`
+	Edit       editCommand       `kong:"cmd"`
+	Doc        docCommand        `kong:"cmd"`
+	Test       testCommand       `kong:"cmd"`
+	SBOM       sbomCommand       `kong:"cmd"`
+	Bundle     bundleCommand     `kong:"cmd"`
+	Profile    profileCommand    `kong:"cmd"`
+	Log        logCommand        `kong:"cmd"`
 	Store      storeCommand      `kong:"cmd"`
+	ConfigCmd  configCommand     `kong:"cmd,name=config"`
 	Key        keyCommand        `kong:"cmd"`
 	Serve      serveCommand      `kong:"cmd"`
 	NAR        narCommand        `kong:"cmd"`
 
-	Completion kongcompletion.Completion `kong:"cmd"`
+	Doctor     doctorCommand     `kong:"cmd"`
+	Completion completionCommand `kong:"cmd"`
 
 	Version     versionCommand `kong:"cmd"`
 	VersionFlag versionFlag    `kong:"name=version,help=Show version information."`
@@ -70,7 +85,21 @@ func (c *zbCommand) BeforeApply(kc *kong.Context, p *kong.Path) error {
 		configFlag.Apply(configValue)
 	}
 
-	configFilePaths := iter.Seq[string](func(yield func(string) bool) {
+	if err := c.Config.mergeFiles(configFilePaths(c.ExtraConfigs)); err != nil {
+		return err
+	}
+
+	if err := c.Config.mergeEnvironment(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// configFilePaths returns the paths of the system configuration files
+// followed by the given extra configuration files.
+func configFilePaths(extra []string) iter.Seq[string] {
+	return func(yield func(string) bool) {
 		for dir := range systemConfigDirs() {
 			if !yield(filepath.Join(dir, "zb", "config.json")) {
 				return
@@ -79,21 +108,12 @@ func (c *zbCommand) BeforeApply(kc *kong.Context, p *kong.Path) error {
 				return
 			}
 		}
-		for _, path := range c.ExtraConfigs {
+		for _, path := range extra {
 			if !yield(path) {
 				return
 			}
 		}
-	})
-	if err := c.Config.mergeFiles(configFilePaths); err != nil {
-		return err
-	}
-
-	if err := c.Config.mergeEnvironment(); err != nil {
-		return err
 	}
-
-	return nil
 }
 
 func zbKongOption() kong.Option {
@@ -122,12 +142,14 @@ func zbKongOption() kong.Option {
 			"http_cache":                g.HTTPCacheDB,
 			"netrc":                     g.NetrcPath,
 			"default_store_db":          filepath.Join(defaultVarDir(), "db.sqlite"),
+			"default_profile":           defaultProfilePath(),
 			"build_users_group":         defaultBuildUsersGroup,
 			"default_build_users_group": backend.DefaultBuildUsersGroup,
 			"default_log_dir":           filepath.Join(filepath.Dir(string(zbstore.DefaultDirectory())), "var", "log", "zb"),
 			"temp_dir":                  os.TempDir(),
 			"num_cpu":                   strconv.Itoa(runtime.NumCPU()),
 			"supports_sandbox":          strconv.FormatBool(backend.SystemSupportsSandbox()),
+			"default_system_features":   strings.Join(backend.DefaultSystemFeatures(), ","),
 		}
 		if !yield(vars) {
 			return
@@ -144,6 +166,13 @@ func zbKongOption() kong.Option {
 }
 
 func main() {
+	if exitCode, isHelper := backend.RunSandboxHelper(); isHelper {
+		os.Exit(exitCode)
+	}
+	if exitCode, isBundle := runEmbeddedBundle(); isBundle {
+		os.Exit(exitCode)
+	}
+
 	c := new(zbCommand)
 	k := kong.Must(c,
 		kong.Name("zb"),
@@ -154,13 +183,18 @@ func main() {
 		kong.Bind(c),
 		zbKongOption(),
 	)
-	kongcompletion.Register(k)
+	kongcompletion.Register(k, kongcompletion.WithPredictors(completionPredictors()))
 
 	kc, err := k.Parse(os.Args[1:])
 	initLogging(c.Config.Debug)
 	if err != nil && !c.VersionFlag {
-		log.Errorf(context.Background(), "%v", err)
-		os.Exit(1)
+		// Flag values are not applied if parsing fails,
+		// so look for --error-format directly.
+		errorFormat := c.Config.ErrorFormat
+		if v := completionFlagValues(os.Args[1:], "--error-format"); len(v) > 0 {
+			errorFormat = v[len(v)-1]
+		}
+		exitWithError(context.Background(), errorFormat, exitUsage, err)
 	}
 
 	ignoreSIGPIPE()
@@ -171,24 +205,55 @@ func main() {
 		kc.BindTo(ctx, (*context.Context)(nil))
 		err = kc.Run()
 	}
+	interrupted := ctx.Err() != nil
 	cancel()
 	if err != nil {
-		log.Errorf(context.Background(), "%v", err)
-		os.Exit(1)
+		code := errorExitCode(err)
+		if interrupted {
+			code = exitCanceled
+		}
+		if code == exitEvaluation && !c.Config.ShowTrace {
+			err = errors.New(trimTraceback(err.Error()))
+		}
+		exitWithError(context.Background(), c.Config.ErrorFormat, code, err)
 	}
 }
 
 type evalOptions struct {
 	Expression bool     `kong:"short=e,help=Interpret argument as Lua expression."`
-	Args       []string `kong:"name=URL,arg"`
-	KeepFailed bool     `kong:"short=k,help=Keep temporary directories of failed builds."`
-	Clean      bool     `kong:"help=Ignore any previous realizations in the store."`
+	Args       []string `kong:"name=URL,arg,completion-predictor=installable"`
+
+	evalEnvOptions `kong:"embed"`
+}
+
+// evalEnvOptions is the set of flags that control evaluation and building
+// independent of what is being evaluated.
+type evalEnvOptions struct {
+	KeepFailed bool `kong:"short=k,help=Keep temporary directories of failed builds."`
+	Clean      bool `kong:"help=Ignore any previous realizations in the store."`
+	Priority   int  `kong:"placeholder=n,help=Start builders before those of lower-priority builds when the server limits concurrent builders. Negative values yield to other builds. (Default: 0)"`
+
+	SubstituteOnly bool `kong:"help=Fail instead of running builders for derivations whose outputs cannot be reused or downloaded from a substituter."`
+	Offline        bool `kong:"help=Forbid the store from using the network while building. Derivations that fetch from the network fail immediately."`
+	NoIFD          bool `kong:"name=no-ifd,help=Fail instead of building derivations whose outputs are read during evaluation (import from derivation)."`
+
+	System string `kong:"placeholder=triple,help=Evaluate for the given system instead of the current system. This sets zb.system.current and selects system-specific values in URL fragments and package listings."`
 
 	AllowEnv    sets.Set[string] `kong:"xor=allow_env,placeholder=var,help=Allow the given environment variable to be accessed with os.getenv. (Can be passed multiple times.)"`
 	AllowAllEnv *bool            `kong:"xor=allow_env,help=Allow all environment variables to be accessed with os.getenv."`
+
+	Quiet bool `kong:"short=q,help=Suppress warnings emitted by Lua code with zb.warn."`
+
+	// profiler and coverage are set by commands
+	// that support profiling evaluation or recording coverage.
+	profiler *frontend.Profiler
+	coverage *frontend.Coverage
+	stats    *frontend.Stats
+	// reportDerivation is set by commands that support --prefetch.
+	reportDerivation func(ctx context.Context, drvPath zbstore.Path)
 }
 
-func (opts *evalOptions) AfterApply(g *globalConfig) error {
+func (opts *evalEnvOptions) AfterApply(g *globalConfig) error {
 	if opts.AllowAllEnv != nil {
 		g.AllowEnv = stringAllowList{all: *opts.AllowAllEnv}
 	} else if opts.AllowEnv.Len() > 0 {
@@ -207,16 +272,27 @@ func (opts *evalOptions) Validate() error {
 	return nil
 }
 
-func (opts *evalOptions) newEval(g *globalConfig, httpClient frontend.HTTPClient, storeClient *jsonrpc.Client, di *zbstorerpc.DeferredImporter) (*frontend.Eval, error) {
+func (opts *evalEnvOptions) newEval(g *globalConfig, httpClient frontend.HTTPClient, storeClient jsonrpc.Handler, di *zbstorerpc.DeferredImporter) (*frontend.Eval, error) {
 	store := &rpcStore{
-		dir:        g.Directory,
-		keepFailed: opts.KeepFailed,
+		dir:            g.Directory,
+		keepFailed:     opts.KeepFailed,
+		priority:       opts.Priority,
+		substituteOnly: opts.SubstituteOnly,
+		offline:        opts.Offline,
 		Store: zbstorerpc.Store{
 			Handler: storeClient,
 		},
 		reuse: opts.reusePolicy(g),
 	}
 	di.SetImporter(store)
+	var sys system.System
+	if opts.System != "" {
+		var err error
+		sys, err = system.Parse(opts.System)
+		if err != nil {
+			return nil, fmt.Errorf("--system: %v", err)
+		}
+	}
 	return frontend.NewEval(&frontend.Options{
 		Store:          store,
 		StoreDirectory: g.Directory,
@@ -229,13 +305,64 @@ func (opts *evalOptions) newEval(g *globalConfig, httpClient frontend.HTTPClient
 			}
 			return os.LookupEnv(key)
 		},
-		DownloadBufferCreator: bytebuffer.TempFileCreator{
-			Pattern: "zb-download-*",
+		DownloadBufferCreator: bytebuffer.SpillCreator{
+			Threshold: downloadMemoryThreshold,
+			Spill: bytebuffer.TempFileCreator{
+				Pattern: "zb-download-*",
+			},
+		},
+		Version: zbVersion,
+		System:  sys,
+		Warn: func(ctx context.Context, w *frontend.Warning) {
+			if !opts.Quiet {
+				reportWarning(ctx, g.ErrorFormat, w)
+			}
 		},
+		Profiler: opts.profiler,
+		Coverage: opts.coverage,
+		Stats:    opts.stats,
+		ReportImportBuild: func(ctx context.Context, b *frontend.ImportBuild) {
+			log.Infof(ctx, "%v", b)
+		},
+		ReportDerivation:       opts.reportDerivation,
+		NoImportFromDerivation: opts.NoIFD,
+		EntryFiles:             g.EntryFiles,
 	})
 }
 
-func (opts *evalOptions) reusePolicy(g *globalConfig) *zbstorerpc.ReusePolicy {
+// requireStoreSupport returns an error
+// if the store does not support the requested options.
+func (opts *evalEnvOptions) requireStoreSupport(ctx context.Context, storeClient jsonrpc.Handler) error {
+	return requireRealizeCapabilities(ctx, storeClient, opts.SubstituteOnly, opts.Offline)
+}
+
+// requireRealizeCapabilities returns an error
+// if the store does not honor [zbstorerpc.RealizeRequest.SubstituteOnly]
+// or [zbstorerpc.RealizeRequest.Offline] when they are requested.
+// Stores that predate the fields would ignore them and run builders or use the network,
+// which is precisely what the caller wants to avoid.
+func requireRealizeCapabilities(ctx context.Context, storeClient jsonrpc.Handler, substituteOnly, offline bool) error {
+	if !substituteOnly && !offline {
+		return nil
+	}
+	handshake, err := zbstorerpc.Handshake(ctx, storeClient)
+	if err != nil {
+		return err
+	}
+	if substituteOnly {
+		if err := handshake.Require(zbstorerpc.CapabilitySubstituteOnly, "--substitute-only"); err != nil {
+			return err
+		}
+	}
+	if offline {
+		if err := handshake.Require(zbstorerpc.CapabilityOffline, "--offline"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (opts *evalEnvOptions) reusePolicy(g *globalConfig) *zbstorerpc.ReusePolicy {
 	if opts.Clean {
 		return nil
 	}
@@ -244,6 +371,15 @@ func (opts *evalOptions) reusePolicy(g *globalConfig) *zbstorerpc.ReusePolicy {
 
 type evalCommand struct {
 	evalOptions `kong:"embed"`
+	Profile     string `kong:"placeholder=file,completion-predictor=file,help=Write a pprof profile of the Lua call stack during evaluation to the given file."`
+
+	Coverage       string `kong:"placeholder=file,completion-predictor=file,help=Write a report of the lines of Lua files executed during evaluation to the given file."`
+	CoverageFormat string `kong:"enum='lcov,json',default=lcov,help=Format of the coverage report: lcov or json. (Default: ${default})"`
+
+	Stats bool `kong:"help=Log statistics about the work done during evaluation to help find what makes evaluation slow or memory hungry."`
+
+	RecordStore string `kong:"xor=store_log,placeholder=file,completion-predictor=file,help=Write the requests that evaluation makes to the store and their responses to the given file."`
+	ReplayStore string `kong:"xor=store_log,placeholder=file,completion-predictor=file,help=Answer store requests from a file written by --record-store instead of connecting to the store."`
 }
 
 func (c *evalCommand) Signature() string {
@@ -262,11 +398,53 @@ func (c *evalCommand) Run(ctx context.Context, g *globalConfig) error {
 		}
 	}()
 	di := new(zbstorerpc.DeferredImporter)
-	storeClient := g.storeClient(&zbstorerpc.CodecOptions{
-		Importer: di,
-	})
-	defer storeClient.Close()
-	eval, err := c.newEval(g, httpClient, storeClient, di)
+	var storeHandler jsonrpc.Handler
+	if c.ReplayStore != "" {
+		// Don't connect to the store at all when replaying.
+		f, err := os.Open(c.ReplayStore)
+		if err != nil {
+			return err
+		}
+		replayer, err := zbstorerpc.NewReplayer(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %v", c.ReplayStore, err)
+		}
+		storeHandler = replayer
+	} else {
+		storeClient := g.storeClient(&zbstorerpc.CodecOptions{
+			Importer: di,
+		})
+		defer storeClient.Close()
+		storeHandler = storeClient
+	}
+	if c.RecordStore != "" {
+		f, err := os.Create(c.RecordStore)
+		if err != nil {
+			return err
+		}
+		recorder := zbstorerpc.NewRecorder(storeHandler, f)
+		defer func() {
+			if err := recorder.Err(); err != nil {
+				log.Errorf(ctx, "%s: %v", c.RecordStore, err)
+			}
+			if err := f.Close(); err != nil {
+				log.Errorf(ctx, "%v", err)
+			}
+		}()
+		storeHandler = recorder
+	}
+	if c.Profile != "" {
+		c.profiler = frontend.NewProfiler()
+	}
+	if c.Coverage != "" {
+		c.coverage = frontend.NewCoverage()
+	}
+	if c.Stats {
+		c.stats = frontend.NewStats()
+	}
+	evalStart := time.Now()
+	eval, err := c.newEval(g, httpClient, storeHandler, di)
 	if err != nil {
 		return err
 	}
@@ -283,8 +461,23 @@ func (c *evalCommand) Run(ctx context.Context, g *globalConfig) error {
 	} else {
 		results, err = eval.URLs(ctx, c.Args)
 	}
+	if c.profiler != nil {
+		// Write the profile even if evaluation failed,
+		// since it can show what evaluation was doing.
+		if err := writeProfileFile(c.Profile, c.profiler); err != nil {
+			log.Errorf(ctx, "%v", err)
+		}
+	}
+	if c.coverage != nil {
+		if err := writeCoverageFile(c.Coverage, c.CoverageFormat, c.coverage); err != nil {
+			log.Errorf(ctx, "%v", err)
+		}
+	}
+	if c.stats != nil {
+		logEvalStats(ctx, c.stats.Summary(), time.Since(evalStart))
+	}
 	if err != nil {
-		return err
+		return evaluationError(err)
 	}
 
 	for _, result := range results {
@@ -294,13 +487,86 @@ func (c *evalCommand) Run(ctx context.Context, g *globalConfig) error {
 	return nil
 }
 
+// newPrefetchFunc returns a function that asks the store
+// to look up the outputs of each derivation written during evaluation
+// in its substituter.
+// If the store does not implement [zbstorerpc.PrefetchMethod],
+// then newPrefetchFunc returns nil.
+func newPrefetchFunc(ctx context.Context, storeClient jsonrpc.Handler) (func(ctx context.Context, drvPath zbstore.Path), error) {
+	handshake, err := zbstorerpc.Handshake(ctx, storeClient)
+	if err != nil {
+		return nil, err
+	}
+	if !handshake.Has(zbstorerpc.CapabilityPrefetch) {
+		log.Debugf(ctx, "Store does not support prefetching; ignoring --prefetch")
+		return nil, nil
+	}
+	return func(ctx context.Context, drvPath zbstore.Path) {
+		err := jsonrpc.Notify(ctx, storeClient, zbstorerpc.PrefetchMethod, &zbstorerpc.PrefetchNotification{
+			DrvPaths: []zbstore.Path{drvPath},
+		})
+		if err != nil {
+			log.Debugf(ctx, "Prefetch %s: %v", drvPath, err)
+		}
+	}, nil
+}
+
+// logEvalStats logs the statistics collected during an evaluation
+// that took the given amount of time.
+func logEvalStats(ctx context.Context, stats *frontend.StatsSummary, elapsed time.Duration) {
+	log.Infof(ctx, "Evaluation statistics (%v):", elapsed.Round(time.Millisecond))
+	log.Infof(ctx, "  Lua states: %d", stats.States)
+	log.Infof(ctx, "  Lua values: %d tables, %d functions, %d userdata, %d concatenated strings",
+		stats.Tables, stats.Functions, stats.Userdata, stats.Strings)
+	log.Infof(ctx, "  Derivations: %d", stats.Derivations)
+	log.Infof(ctx, "  Modules: %d (%d/%d cache hits, %.0f%%)",
+		stats.Modules, stats.ModuleCacheHits, stats.ModuleCacheLookups, 100*stats.ModuleCacheHitRate())
+	log.Infof(ctx, "  Imports: %d (%d already in store, %.0f%%)",
+		stats.Imports, stats.ImportsReused, 100*stats.ImportReuseRate())
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	log.Infof(ctx, "  Go heap: %.1f MiB allocated in total, %.1f MiB in use",
+		float64(mem.TotalAlloc)/(1<<20), float64(mem.HeapAlloc)/(1<<20))
+	if peak, ok := peakMemoryUsage(); ok {
+		log.Infof(ctx, "  Peak memory: %.1f MiB", float64(peak)/(1<<20))
+	}
+}
+
+// writeProfileFile writes the profile collected by p to the file at path.
+func writeProfileFile(path string, p *frontend.Profiler) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = p.WriteProfile(f)
+	if closeErr := f.Close(); err == nil && closeErr != nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
+
 type buildCommand struct {
 	evalOptions `kong:"embed"`
-	OutLink     string `kong:"short=o,default=result,placeholder=path,help=Change the name of the output path symlink. (Default: ${default})"`
+	OutLinks    []string `kong:"name=out-link,short=o,sep=none,default=result,placeholder=path,help=Change the name of the output path symlink. Pass once per installable to name each symlink. (Default: ${default})"`
+	NoLink      bool     `kong:"help=Do not create output path symlinks."`
+	Verbose     bool     `kong:"short=v,help=Show how each output was obtained."`
+	Explain     bool     `kong:"help=Show why each derivation that was built could not reuse an existing realization."`
+	Timings     bool     `kong:"help=Show how long each phase of each builder took."`
+	JSONFormat  bool     `kong:"name=json,help=Print the build results as JSON."`
+	Check       bool     `kong:"aliases=rebuild,help=Rebuild the derivations even if they have been built before and fail if the outputs differ."`
+	WithChecks  bool     `kong:"help=Also build the checks attached to each derivation."`
+	Prefetch    bool     `kong:"help=Ask the store to start looking up the outputs of derivations in its substituter while evaluation is still running."`
+
+	ReplaySchedule string `kong:"placeholder=build-id,help=Process derivations in the same order and with the same build users as the given earlier build. Derivations whose builders ran in the earlier build are rebuilt and checked."`
+	TraceExec      bool   `kong:"help=Record every program that the builders execute. View the records with zb log --trace."`
+	TraceInputs    bool   `kong:"help=Record which inputs each builder opens and warn about inputs that were not opened."`
 }
 
 func (c *buildCommand) Signature() string {
-	return `kong:"help=Build one or more derivations."`
+	return `kong:"help=Build one or more derivations. A directory URL refers to its zb.lua or default.lua file or that of the nearest parent directory. Builds the current directory if no URL is given."`
 }
 
 func (c *buildCommand) Run(ctx context.Context, g *globalConfig) error {
@@ -319,6 +585,12 @@ func (c *buildCommand) Run(ctx context.Context, g *globalConfig) error {
 		Importer: di,
 	})
 	defer storeClient.Close()
+	if c.Prefetch && !c.Clean && !c.Offline {
+		c.reportDerivation, err = newPrefetchFunc(ctx, storeClient)
+		if err != nil {
+			return err
+		}
+	}
 	eval, err := c.newEval(g, httpClient, storeClient, di)
 	if err != nil {
 		return err
@@ -329,46 +601,163 @@ func (c *buildCommand) Run(ctx context.Context, g *globalConfig) error {
 		}
 	}()
 
+	if c.WithChecks && c.Expression {
+		return fmt.Errorf("--with-checks and --expression are mutually exclusive")
+	}
+	if len(c.Args) == 0 && !c.Expression {
+		// Build the entry file of the current directory or its parents.
+		c.Args = []string{"."}
+	}
 	var results []any
+	var checks [][]*frontend.Check
+	selectors := make([][]string, len(c.Args))
 	if c.Expression {
 		results = make([]any, 1)
 		results[0], err = eval.Expression(ctx, c.Args[0])
 	} else {
-		results, err = eval.URLs(ctx, c.Args)
+		urls := make([]string, len(c.Args))
+		for i, arg := range c.Args {
+			urls[i], selectors[i] = frontend.CutOutputSelector(arg)
+		}
+		results, err = eval.URLs(ctx, urls)
+		if err == nil && c.WithChecks {
+			checks, err = eval.Checks(ctx, urls)
+		}
 	}
 	if err != nil {
-		return err
+		return evaluationError(err)
 	}
 	if len(results) == 0 {
 		return fmt.Errorf("no evaluation results")
 	}
 
+	drvs := make([]*frontend.Derivation, 0, len(results))
 	drvPaths := make([]zbstore.Path, 0, len(results))
-	for _, result := range results {
+	selectedOutputs := make([][]string, 0, len(results))
+	for i, result := range results {
 		drv, _ := result.(*frontend.Derivation)
 		if drv == nil {
 			return fmt.Errorf("%v is not a derivation", result)
 		}
+		outputNames, err := selectOutputs(drv, selectors[i])
+		if err != nil {
+			return evaluationError(err)
+		}
+		drvs = append(drvs, drv)
 		drvPaths = append(drvPaths, drv.Path)
+		selectedOutputs = append(selectedOutputs, outputNames)
+	}
+	var outLinks []string
+	if !c.NoLink {
+		outLinks, err = outLinkPaths(c.OutLinks, len(drvs))
+		if err != nil {
+			return err
+		}
+	}
+	if c.Check && c.SubstituteOnly {
+		return fmt.Errorf("--check and --substitute-only are mutually exclusive")
+	}
+	if c.ReplaySchedule != "" && c.SubstituteOnly {
+		return fmt.Errorf("--replay-schedule and --substitute-only are mutually exclusive")
+	}
+	if err := c.requireStoreSupport(ctx, storeClient); err != nil {
+		return err
+	}
+	if c.Check || c.ReplaySchedule != "" || c.TraceExec || c.TraceInputs {
+		// Stores that predate --check, --replay-schedule, --trace-exec, or --trace-inputs ignore the fields,
+		// so refuse instead of silently skipping the rebuild or trace.
+		handshake, err := zbstorerpc.Handshake(ctx, storeClient)
+		if err != nil {
+			return err
+		}
+		if c.Check {
+			if err := handshake.Require(zbstorerpc.CapabilityCheck, "zb build --check"); err != nil {
+				return err
+			}
+		}
+		if c.ReplaySchedule != "" {
+			if err := handshake.Require(zbstorerpc.CapabilityReplaySchedule, "zb build --replay-schedule"); err != nil {
+				return err
+			}
+		}
+		if c.TraceExec {
+			if err := handshake.Require(zbstorerpc.CapabilityExecTrace, "zb build --trace-exec"); err != nil {
+				return err
+			}
+		}
+		if c.TraceInputs {
+			if err := handshake.Require(zbstorerpc.CapabilityInputUsage, "zb build --trace-inputs"); err != nil {
+				return err
+			}
+		}
 	}
 	realizeResponse := new(zbstorerpc.RealizeResponse)
 	err = jsonrpc.Do(ctx, storeClient, zbstorerpc.RealizeMethod, realizeResponse, &zbstorerpc.RealizeRequest{
-		DrvPaths:   drvPaths,
-		KeepFailed: c.KeepFailed,
-		Reuse:      c.reusePolicy(g),
+		DrvPaths:       append(slices.Clip(drvPaths), checkDrvPaths(drvPaths, checks)...),
+		KeepFailed:     c.KeepFailed,
+		Reuse:          c.reusePolicy(g),
+		Check:          c.Check,
+		Priority:       c.Priority,
+		SubstituteOnly: c.SubstituteOnly,
+		Offline:        c.Offline,
+		ReplaySchedule: c.ReplaySchedule,
+		TraceExec:      c.TraceExec,
+		TraceInputs:    c.TraceInputs,
 	})
 	if err != nil {
 		return err
 	}
-	build, _, buildError := waitForBuild(ctx, storeClient, realizeResponse.BuildID)
+	build, rawBuild, buildError := waitForBuild(ctx, storeClient, realizeResponse.BuildID)
+	if c.TraceExec {
+		log.Infof(ctx, "To see what the builders executed, run: zb log --trace %s", realizeResponse.BuildID)
+	}
+	buildError = checkBuildError(ctx, build, drvPaths, checks, buildError)
+	buildError = substitutionError(c.SubstituteOnly, buildError)
+	if build != nil && c.Verbose {
+		logProvenance(ctx, build)
+	}
+	if build != nil && c.Explain {
+		logRebuildReasons(ctx, build)
+	}
+	if build != nil && c.Timings {
+		logPhaseTimings(ctx, build)
+	}
+	if build != nil && c.SubstituteOnly {
+		logUnsubstitutedDerivations(ctx, build)
+	}
+	if (buildError == nil || errorExitCode(buildError) == exitCheck) && len(outLinks) > 0 {
+		var createdLinks []string
+		for i, drv := range drvs {
+			links, err := createOutLinks(outLinks[i], build, drv, selectedOutputs[i])
+			createdLinks = append(createdLinks, links...)
+			if err != nil {
+				return err
+			}
+		}
+		if err := registerOutLinks(ctx, storeClient, createdLinks); err != nil {
+			return err
+		}
+	}
+	if rawBuild != nil && c.JSONFormat {
+		// Dump build response directly to preserve unknown fields.
+		rawBuild = rawBuild.Clone()
+		if err := rawBuild.Compact(); err != nil {
+			return err
+		}
+		if _, err := os.Stdout.Write(append(rawBuild, '\n')); err != nil {
+			return err
+		}
+		return buildError
+	}
 	if build != nil {
-		for _, drvPath := range drvPaths {
+		for i, drvPath := range drvPaths {
 			result, err := build.ResultForPath(drvPath)
 			if err != nil {
 				continue
 			}
-			for _, output := range result.Outputs {
-				if output.Path.Valid {
+			for _, outputName := range selectedOutputs[i] {
+				output, err := result.OutputForName(outputName)
+				if err == nil && output.Path.Valid {
 					fmt.Println(output.Path.X)
 				}
 			}
@@ -377,6 +766,205 @@ func (c *buildCommand) Run(ctx context.Context, g *globalConfig) error {
 	return buildError
 }
 
+// selectOutputs returns the names of drv's outputs
+// named by an output selector returned from [frontend.CutOutputSelector].
+// An empty selector selects the derivation's default output.
+func selectOutputs(drv *frontend.Derivation, selector []string) ([]string, error) {
+	if len(selector) == 0 {
+		return []string{drv.DefaultOutputName()}, nil
+	}
+	if len(selector) == 1 && selector[0] == frontend.AllOutputs {
+		return drv.OutputNames(), nil
+	}
+	for _, outputName := range selector {
+		if _, ok := drv.Outputs[outputName]; !ok {
+			return nil, fmt.Errorf("%s does not have an output named %q", drv.Path, outputName)
+		}
+	}
+	return selector, nil
+}
+
+// outLinkPaths returns the out-link path to use for each of n installables
+// given the --out-link flags.
+// A single flag is used as-is for a single installable
+// and is suffixed with "-1", "-2", etc. for multiple installables.
+// Otherwise, there must be exactly one flag per installable.
+func outLinkPaths(flags []string, n int) ([]string, error) {
+	switch {
+	case len(flags) == n:
+		return flags, nil
+	case len(flags) == 1:
+		paths := make([]string, n)
+		for i := range paths {
+			paths[i] = fmt.Sprintf("%s-%d", flags[0], i+1)
+		}
+		return paths, nil
+	default:
+		return nil, fmt.Errorf("%d --out-link flags given for %d installables (must be 1 or %d)", len(flags), n, n)
+	}
+}
+
+// createOutLinks creates a symlink to each of the given outputs of drv
+// and returns the paths of the symlinks it created.
+// The link for the default output is named linkPath
+// and the links for other outputs are named linkPath-OUTPUT.
+func createOutLinks(linkPath string, build *zbstorerpc.Build, drv *frontend.Derivation, outputNames []string) ([]string, error) {
+	result, err := build.ResultForPath(drv.Path)
+	if err != nil {
+		return nil, err
+	}
+	var links []string
+	for _, outputName := range outputNames {
+		output, err := result.OutputForName(outputName)
+		if err != nil {
+			return links, err
+		}
+		if !output.Path.Valid {
+			return links, fmt.Errorf("%s: output %s has no path", drv.Path, outputName)
+		}
+		name := linkPath
+		if outputName != drv.DefaultOutputName() {
+			name += "-" + outputName
+		}
+		if err := replaceSymlink(name, string(output.Path.X)); err != nil {
+			return links, err
+		}
+		links = append(links, name)
+	}
+	return links, nil
+}
+
+// registerOutLinks registers the given symlinks with the store
+// as garbage collection roots.
+// If the store does not support roots, registerOutLinks logs a warning.
+func registerOutLinks(ctx context.Context, storeClient jsonrpc.Handler, links []string) error {
+	if len(links) == 0 {
+		return nil
+	}
+	handshake, err := zbstorerpc.Handshake(ctx, storeClient)
+	if err != nil {
+		return err
+	}
+	if !handshake.Has(zbstorerpc.CapabilityAddRoot) {
+		log.Warnf(ctx, "Store does not support garbage collection roots. Outputs linked from %s may be deleted.",
+			strings.Join(links, ", "))
+		return nil
+	}
+	for _, link := range links {
+		absLink, err := filepath.Abs(link)
+		if err != nil {
+			return err
+		}
+		err = jsonrpc.Do(ctx, storeClient, zbstorerpc.AddRootMethod, nil, &zbstorerpc.AddRootRequest{
+			Link: absLink,
+		})
+		if err != nil {
+			return fmt.Errorf("register %s: %v", link, err)
+		}
+	}
+	return nil
+}
+
+// replaceSymlink creates a symlink at linkPath pointing to target,
+// replacing any symlink already at linkPath.
+// It refuses to replace anything other than a symlink.
+func replaceSymlink(linkPath, target string) error {
+	info, err := os.Lstat(linkPath)
+	switch {
+	case err == nil && info.Mode().Type() != fs.ModeSymlink:
+		return fmt.Errorf("create link %s: file exists and is not a symlink", linkPath)
+	case err == nil:
+		if err := os.Remove(linkPath); err != nil {
+			return fmt.Errorf("create link %s: %v", linkPath, err)
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return fmt.Errorf("create link %s: %v", linkPath, err)
+	}
+	if err := os.Symlink(target, linkPath); err != nil {
+		return fmt.Errorf("create link %s: %v", linkPath, err)
+	}
+	return nil
+}
+
+// logProvenance logs how each output in the build was obtained,
+// followed by a summary of how many outputs were obtained without building.
+func logProvenance(ctx context.Context, build *zbstorerpc.Build) {
+	counts := make(map[zbstorerpc.ProvenanceMethod]int)
+	total := 0
+	for _, result := range build.Results {
+		for _, output := range result.Outputs {
+			if !output.Path.Valid {
+				continue
+			}
+			log.Infof(ctx, "%s: %v", output.Path.X, output.Provenance)
+			total++
+			if output.Provenance != nil {
+				counts[output.Provenance.Method]++
+			}
+		}
+	}
+	if total == 0 {
+		return
+	}
+	hits := counts[zbstorerpc.ProvenanceReused] + counts[zbstorerpc.ProvenanceSubstituted]
+	log.Infof(ctx, "%d outputs: %d built, %d reused, %d substituted (%.0f%% cache hits)",
+		total,
+		counts[zbstorerpc.ProvenanceBuilt]+counts[zbstorerpc.ProvenanceRemoteBuilt],
+		counts[zbstorerpc.ProvenanceReused],
+		counts[zbstorerpc.ProvenanceSubstituted],
+		float64(hits)/float64(total)*100)
+}
+
+// logRebuildReasons logs why the store ran the builder
+// for each derivation in the build,
+// along with any inputs that changed since the derivation was last built.
+func logRebuildReasons(ctx context.Context, build *zbstorerpc.Build) {
+	for _, result := range build.Results {
+		if result.RebuildReason == nil {
+			continue
+		}
+		log.Infof(ctx, "Built %s: %v", result.DrvPath, result.RebuildReason)
+		for _, input := range result.RebuildReason.ChangedInputs {
+			log.Infof(ctx, "  %v: %s -> %s", input.Input, input.OldPath, input.NewPath)
+		}
+	}
+}
+
+// logUnsubstitutedDerivations logs the derivations in a failed substitute-only build
+// that would have needed to be built locally.
+func logUnsubstitutedDerivations(ctx context.Context, build *zbstorerpc.Build) {
+	for _, result := range build.Results {
+		if result.Status != zbstorerpc.BuildFail {
+			continue
+		}
+		if result.RebuildReason == nil {
+			log.Errorf(ctx, "Must build %s locally", result.DrvPath)
+			continue
+		}
+		log.Errorf(ctx, "Must build %s locally: %v", result.DrvPath, result.RebuildReason)
+		for _, input := range result.RebuildReason.ChangedInputs {
+			log.Errorf(ctx, "  %v: %s -> %s", input.Input, input.OldPath, input.NewPath)
+		}
+	}
+}
+
+// logPhaseTimings logs the time that each builder spent in each of its phases.
+func logPhaseTimings(ctx context.Context, build *zbstorerpc.Build) {
+	for _, result := range build.Results {
+		if len(result.Phases) == 0 {
+			continue
+		}
+		var total time.Duration
+		for _, phase := range result.Phases {
+			total += phase.Duration()
+		}
+		log.Infof(ctx, "Phases of %s (%v):", result.DrvPath, total.Round(time.Millisecond))
+		for _, phase := range result.Phases {
+			log.Infof(ctx, "  %s: %v", phase.Name, phase.Duration().Round(time.Millisecond))
+		}
+	}
+}
+
 // rpcStore is an implementation of [frontend.Store]
 // that communicates with a store over RPC.
 // It copies builder logs to stderr
@@ -385,10 +973,17 @@ type rpcStore struct {
 	zbstorerpc.Store
 	dir        zbstore.Directory
 	keepFailed bool
+	priority   int
 	reuse      *zbstorerpc.ReusePolicy
+
+	substituteOnly bool
+	offline        bool
 }
 
 func (store *rpcStore) Realize(ctx context.Context, want sets.Set[zbstore.OutputReference]) ([]*zbstorerpc.BuildResult, error) {
+	if err := requireRealizeCapabilities(ctx, store.Handler, store.substituteOnly, store.offline); err != nil {
+		return nil, err
+	}
 	var realizeResponse zbstorerpc.RealizeResponse
 	err := jsonrpc.Do(ctx, store.Handler, zbstorerpc.RealizeMethod, &realizeResponse, &zbstorerpc.RealizeRequest{
 		DrvPaths: slices.Collect(func(yield func(zbstore.Path) bool) {
@@ -398,15 +993,18 @@ func (store *rpcStore) Realize(ctx context.Context, want sets.Set[zbstore.Output
 				}
 			}
 		}),
-		KeepFailed: store.keepFailed,
-		Reuse:      store.reuse,
+		KeepFailed:     store.keepFailed,
+		Reuse:          store.reuse,
+		Priority:       store.priority,
+		SubstituteOnly: store.substituteOnly,
+		Offline:        store.offline,
 	})
 	if err != nil {
 		return nil, err
 	}
 	build, _, err := waitForBuild(ctx, store.Handler, realizeResponse.BuildID)
 	if err != nil {
-		return nil, err
+		return nil, substitutionError(store.substituteOnly, err)
 	}
 	return build.Results, nil
 }
@@ -440,6 +1038,8 @@ func waitForBuild(ctx context.Context, storeClient jsonrpc.Handler, buildID stri
 	}
 
 	visited := make(sets.Set[zbstore.Path])
+	// Handshake lazily so that builds without logs avoid the round trip.
+	var handshake *zbstorerpc.HandshakeResponse
 	ticker := time.NewTicker(250 * time.Millisecond)
 	defer ticker.Stop()
 	for {
@@ -475,7 +1075,20 @@ func waitForBuild(ctx context.Context, storeClient jsonrpc.Handler, buildID stri
 				log.Debugf(ctx, "Context canceled while reading logs for build %s: %v", buildID, err)
 				break
 			}
-			if err := copyLogToStderr(ctx, storeClient, buildID, result.DrvPath); err != nil {
+			if handshake == nil {
+				handshake, err = zbstorerpc.Handshake(ctx, storeClient)
+				if err != nil {
+					log.Debugf(ctx, "Assuming logs do not have timestamps: %v", err)
+					handshake = new(zbstorerpc.HandshakeResponse)
+				}
+			}
+			format := logFormat{
+				prefixed: handshake.Has(zbstorerpc.CapabilityLogTimestamps),
+				colorStderr: handshake.Has(zbstorerpc.CapabilityLogStreams) &&
+					os.Getenv("NO_COLOR") == "" &&
+					term.IsTerminal(int(os.Stderr.Fd())),
+			}
+			if err := copyLogToStderr(ctx, storeClient, buildID, result.DrvPath, format); err != nil {
 				log.Warnf(ctx, "Failed to read logs for %s in build %s: %v", result.DrvPath, buildID, err)
 			}
 		}
@@ -492,7 +1105,7 @@ func waitForBuild(ctx context.Context, storeClient jsonrpc.Handler, buildID stri
 		case zbstorerpc.BuildSuccess:
 			return buildResponse, buildRPCResponse.Result, nil
 		case zbstorerpc.BuildFail:
-			return buildResponse, buildRPCResponse.Result, fmt.Errorf("build %s failed", buildID)
+			return buildResponse, buildRPCResponse.Result, withExitCode(exitBuild, fmt.Errorf("build %s failed", buildID))
 		case zbstorerpc.BuildError:
 			return buildResponse, buildRPCResponse.Result, fmt.Errorf("build %s encountered an internal error", buildID)
 		default:
@@ -501,25 +1114,57 @@ func waitForBuild(ctx context.Context, storeClient jsonrpc.Handler, buildID stri
 	}
 }
 
-func copyLogToStderr(ctx context.Context, storeClient jsonrpc.Handler, buildID string, drvPath zbstore.Path) error {
+// logFormat describes how to display a builder log.
+type logFormat struct {
+	// prefixed is true if the store prefixes each line of the log
+	// (see [zbstorerpc.CapabilityLogTimestamps]).
+	prefixed bool
+	// colorStderr is true if lines that the builder wrote to standard error
+	// should be highlighted with terminal escape sequences.
+	colorStderr bool
+}
+
+// copyLogToStderr copies the builder log for the given derivation to stderr.
+func copyLogToStderr(ctx context.Context, storeClient jsonrpc.Handler, buildID string, drvPath zbstore.Path, format logFormat) error {
+	return copyLog(ctx, os.Stderr, storeClient, buildID, drvPath, format)
+}
+
+// copyLog copies the builder log for the given derivation to dst
+// with a header line naming the derivation.
+// Nothing is written if the log is empty.
+func copyLog(ctx context.Context, dst io.Writer, storeClient jsonrpc.Handler, buildID string, drvPath zbstore.Path, format logFormat) error {
 	off := int64(0)
+	// pending is the incomplete last line of the log read so far.
+	var pending []byte
+	var toWrite []byte
 	for {
 		payload, err := readLog(ctx, storeClient, &zbstorerpc.ReadLogRequest{
 			BuildID:    buildID,
 			DrvPath:    drvPath,
 			RangeStart: off,
+			Prefixed:   format.prefixed,
 		})
-		if len(payload) > 0 {
-			toWrite := payload
-			if off == 0 {
-				// Write header.
-				toWrite = nil
-				toWrite = append(toWrite, "--- "...)
-				toWrite = append(toWrite, drvPath...)
-				toWrite = append(toWrite, " ---\n"...)
-				toWrite = append(toWrite, payload...)
+		toWrite = toWrite[:0]
+		if off == 0 && len(payload) > 0 {
+			// Write header.
+			toWrite = append(toWrite, "--- "...)
+			toWrite = append(toWrite, drvPath...)
+			toWrite = append(toWrite, " ---\n"...)
+		}
+		if format.prefixed {
+			pending = append(pending, payload...)
+			n := len(pending)
+			if err == nil {
+				// Only format complete lines until the log ends.
+				n = bytes.LastIndexByte(pending, '\n') + 1
 			}
-			if _, err := os.Stderr.Write(toWrite); err != nil {
+			toWrite = format.appendLines(toWrite, pending[:n])
+			pending = append(pending[:0], pending[n:]...)
+		} else {
+			toWrite = append(toWrite, payload...)
+		}
+		if len(toWrite) > 0 {
+			if _, err := dst.Write(toWrite); err != nil {
 				return err
 			}
 		}
@@ -533,6 +1178,27 @@ func copyLogToStderr(ctx context.Context, storeClient jsonrpc.Handler, buildID s
 	}
 }
 
+// appendLines appends the lines of a prefixed builder log in src to dst
+// for display and returns the resulting slice.
+func (format logFormat) appendLines(dst, src []byte) []byte {
+	for line := range bytes.Lines(src) {
+		prefix, text, _ := zbstorerpc.CutLogPrefix(line)
+		if !format.colorStderr || prefix.Stream != zbstorerpc.LogStreamStderr {
+			dst = append(dst, text...)
+			continue
+		}
+		text, hasNewline := bytes.CutSuffix(text, []byte("\n"))
+		// Yellow foreground, then reset.
+		dst = append(dst, "\x1b[33m"...)
+		dst = append(dst, text...)
+		dst = append(dst, "\x1b[0m"...)
+		if hasNewline {
+			dst = append(dst, '\n')
+		}
+	}
+	return dst
+}
+
 func readLog(ctx context.Context, storeClient jsonrpc.Handler, req *zbstorerpc.ReadLogRequest) ([]byte, error) {
 	response := new(zbstorerpc.ReadLogResponse)
 	err := jsonrpc.Do(ctx, storeClient, zbstorerpc.ReadLogMethod, response, req)
@@ -549,6 +1215,45 @@ func readLog(ctx context.Context, storeClient jsonrpc.Handler, req *zbstorerpc.R
 	return payload, nil
 }
 
+// objectInfos queries the store for information about the given store objects.
+// If batch is true, then objectInfos sends the queries in a single round trip.
+// Otherwise, it sends an individual request for each store object,
+// as required for stores without [zbstorerpc.CapabilityBatch].
+// It returns an error if any of the store objects do not exist.
+func objectInfos(ctx context.Context, storeClient jsonrpc.Handler, batch bool, paths []zbstore.Path) ([]*zbstorerpc.ObjectInfo, error) {
+	if !batch {
+		// Hide any [jsonrpc.BatchHandler] implementation
+		// so that [jsonrpc.Batch] sends the requests individually.
+		storeClient = jsonrpc.HandlerFunc(storeClient.JSONRPC)
+	}
+	reqs := make([]*jsonrpc.Request, 0, len(paths))
+	for _, p := range paths {
+		params, err := jsonv2.Marshal(&zbstorerpc.InfoRequest{Path: p})
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", p, err)
+		}
+		reqs = append(reqs, &jsonrpc.Request{
+			Method: zbstorerpc.InfoMethod,
+			Params: params,
+		})
+	}
+	infos := make([]*zbstorerpc.ObjectInfo, 0, len(paths))
+	for i, result := range jsonrpc.Batch(ctx, storeClient, reqs) {
+		if result.Err != nil {
+			return nil, fmt.Errorf("%s: %v", paths[i], result.Err)
+		}
+		resp := new(zbstorerpc.InfoResponse)
+		if err := jsonv2.Unmarshal(result.Response.Result, resp); err != nil {
+			return nil, fmt.Errorf("%s: %v", paths[i], err)
+		}
+		if resp.Info == nil {
+			return nil, fmt.Errorf("%s: does not exist", paths[i])
+		}
+		infos = append(infos, resp.Info)
+	}
+	return infos, nil
+}
+
 // openInputFile opens a file for reading using [os.Open].
 // If name is "-", then it returns [os.Stdin].
 func openInputFile(name string) (fs.File, error) {
@@ -4,9 +4,18 @@
 package main
 
 import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
 	"testing"
 
 	"github.com/alecthomas/kong"
+	jsonv2 "github.com/go-json-experiment/json"
+	"zb.256lights.llc/pkg/internal/jsonrpc"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+	"zb.256lights.llc/pkg/zbstore"
+	"zombiezen.com/go/nix"
 )
 
 func TestKongTags(t *testing.T) {
@@ -14,3 +23,143 @@ func TestKongTags(t *testing.T) {
 		t.Error("kong.New:", err)
 	}
 }
+
+func TestReplaceSymlink(t *testing.T) {
+	dir := t.TempDir()
+	linkPath := filepath.Join(dir, "result")
+	for _, target := range []string{"/zb/store/first", "/zb/store/second"} {
+		if err := replaceSymlink(linkPath, target); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := os.Readlink(linkPath); err != nil {
+			t.Error(err)
+		} else if got != target {
+			t.Errorf("os.Readlink(%q) = %q; want %q", linkPath, got, target)
+		}
+	}
+
+	filePath := filepath.Join(dir, "file")
+	if err := os.WriteFile(filePath, []byte("Hello\n"), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	if err := replaceSymlink(filePath, "/zb/store/first"); err == nil {
+		t.Errorf("replaceSymlink(%q, ...) on regular file did not return an error", filePath)
+	}
+}
+
+func TestOutLinkPaths(t *testing.T) {
+	tests := []struct {
+		flags []string
+		n     int
+		want  []string
+		err   bool
+	}{
+		{flags: []string{"result"}, n: 1, want: []string{"result"}},
+		{flags: []string{"result"}, n: 2, want: []string{"result-1", "result-2"}},
+		{flags: []string{"foo", "bar"}, n: 2, want: []string{"foo", "bar"}},
+		{flags: []string{"foo", "bar"}, n: 3, err: true},
+	}
+	for _, test := range tests {
+		got, err := outLinkPaths(test.flags, test.n)
+		if err != nil {
+			if !test.err {
+				t.Errorf("outLinkPaths(%q, %d): %v", test.flags, test.n, err)
+			}
+			continue
+		}
+		if test.err {
+			t.Errorf("outLinkPaths(%q, %d) = %q, <nil>; want error", test.flags, test.n, got)
+			continue
+		}
+		if !slices.Equal(got, test.want) {
+			t.Errorf("outLinkPaths(%q, %d) = %q; want %q", test.flags, test.n, got, test.want)
+		}
+	}
+}
+
+func TestLogFormatAppendLines(t *testing.T) {
+	const src = "[0.000] store message\n" +
+		"[0.001 out] hello\n" +
+		"[0.002 err] oops\n" +
+		"unprefixed\n" +
+		"[0.003 err] partial"
+	tests := []struct {
+		format logFormat
+		want   string
+	}{
+		{
+			format: logFormat{prefixed: true},
+			want:   "store message\nhello\noops\nunprefixed\npartial",
+		},
+		{
+			format: logFormat{prefixed: true, colorStderr: true},
+			want:   "store message\nhello\n\x1b[33moops\x1b[0m\nunprefixed\n\x1b[33mpartial\x1b[0m",
+		},
+	}
+	for _, test := range tests {
+		if got := string(test.format.appendLines(nil, []byte(src))); got != test.want {
+			t.Errorf("%+v.appendLines(nil, %q) = %q; want %q", test.format, src, got, test.want)
+		}
+	}
+}
+
+// infoBatchHandler is a [jsonrpc.BatchHandler]
+// that reports every store object as existing
+// and counts the batches it receives.
+type infoBatchHandler struct {
+	batches int
+}
+
+func (h *infoBatchHandler) JSONRPC(ctx context.Context, req *jsonrpc.Request) (*jsonrpc.Response, error) {
+	var args zbstorerpc.InfoRequest
+	if err := jsonv2.Unmarshal(req.Params, &args); err != nil {
+		return nil, jsonrpc.Error(jsonrpc.InvalidParams, err)
+	}
+	hash := nix.NewHash(nix.SHA256, make([]byte, nix.SHA256.Size()))
+	result, err := jsonv2.Marshal(&zbstorerpc.InfoResponse{
+		Info: &zbstorerpc.ObjectInfo{
+			NARSize: 1,
+			NARHash: hash,
+			CA:      nix.FlatFileContentAddress(hash),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &jsonrpc.Response{Result: result}, nil
+}
+
+func (h *infoBatchHandler) JSONRPCBatch(ctx context.Context, reqs []*jsonrpc.Request) []jsonrpc.BatchResult {
+	h.batches++
+	results := make([]jsonrpc.BatchResult, len(reqs))
+	for i, req := range reqs {
+		results[i].Response, results[i].Err = h.JSONRPC(ctx, req)
+	}
+	return results
+}
+
+func TestObjectInfos(t *testing.T) {
+	ctx := context.Background()
+	paths := []zbstore.Path{
+		"/zb/store/s66mzxpvicwk07gjbjfw9izjfa797vsw-hello-2.12.1",
+		"/zb/store/ffffffffffffffffffffffffffffffff-bar",
+	}
+	for _, batch := range []bool{false, true} {
+		h := new(infoBatchHandler)
+		infos, err := objectInfos(ctx, h, batch, paths)
+		if err != nil {
+			t.Errorf("objectInfos(ctx, h, %t, paths): %v", batch, err)
+			continue
+		}
+		if len(infos) != len(paths) {
+			t.Errorf("objectInfos(ctx, h, %t, paths) returned %d infos; want %d", batch, len(infos), len(paths))
+		}
+		want := 0
+		if batch {
+			want = 1
+		}
+		if h.batches != want {
+			t.Errorf("objectInfos(ctx, h, %t, paths) sent %d batches; want %d", batch, h.batches, want)
+		}
+	}
+}
@@ -6,9 +6,15 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"iter"
 	"os"
 	"os/signal"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
 
 	"go4.org/xdgdir"
 	"golang.org/x/sys/unix"
@@ -23,6 +29,10 @@ func cacheDir() string {
 	return xdgdir.Cache.Path()
 }
 
+func dataDir() string {
+	return xdgdir.Data.Path()
+}
+
 // systemConfigDirs returns a sequence of configuration directory paths
 // in increasing order of preference (i.e. later entries should override earlier entries).
 func systemConfigDirs() iter.Seq[string] {
@@ -36,6 +46,52 @@ func systemConfigDirs() iter.Seq[string] {
 	}
 }
 
+// peakMemoryUsage returns the maximum resident set size of the process in bytes.
+func peakMemoryUsage() (int64, bool) {
+	var usage unix.Rusage
+	if err := unix.Getrusage(unix.RUSAGE_SELF, &usage); err != nil {
+		return 0, false
+	}
+	switch runtime.GOOS {
+	case "darwin", "ios":
+		return int64(usage.Maxrss), true
+	default:
+		// Other systems report kibibytes.
+		return int64(usage.Maxrss) * 1024, true
+	}
+}
+
 func ignoreSIGPIPE() {
 	signal.Ignore(unix.SIGPIPE)
 }
+
+// drainSignalChannel returns a channel that is closed
+// when the process receives a hangup signal.
+// The returned function stops listening for the signal.
+func drainSignalChannel() (<-chan struct{}, func()) {
+	ctx, stop := signal.NotifyContext(context.Background(), unix.SIGHUP)
+	return ctx.Done(), stop
+}
+
+// restartServer replaces the current process
+// with the zb executable run with the same arguments,
+// passing it the given listener.
+// restartServer only returns if it fails.
+func restartServer(listener *os.File) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("restart: %v", err)
+	}
+	fd := listener.Fd()
+	// Allow the file descriptor to be inherited.
+	if _, err := unix.FcntlInt(fd, unix.F_SETFD, 0); err != nil {
+		return fmt.Errorf("restart: %v", err)
+	}
+	env := slices.DeleteFunc(os.Environ(), func(kv string) bool {
+		return strings.HasPrefix(kv, listenFDEnvVar+"=")
+	})
+	env = append(env, listenFDEnvVar+"="+strconv.FormatUint(uint64(fd), 10))
+	err = unix.Exec(exe, os.Args, env)
+	runtime.KeepAlive(listener)
+	return fmt.Errorf("restart: %v", err)
+}
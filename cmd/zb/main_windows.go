@@ -4,6 +4,7 @@
 package main
 
 import (
+	"errors"
 	"iter"
 	"os"
 )
@@ -18,6 +19,14 @@ func cacheDir() string {
 	return dir
 }
 
+func dataDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return dir
+}
+
 // systemConfigDirs returns a sequence of configuration directory paths
 // in increasing order of preference (i.e. later entries should override earlier entries).
 func systemConfigDirs() iter.Seq[string] {
@@ -28,4 +37,23 @@ func systemConfigDirs() iter.Seq[string] {
 	}
 }
 
+// peakMemoryUsage is not supported on Windows.
+func peakMemoryUsage() (int64, bool) {
+	return 0, false
+}
+
 func ignoreSIGPIPE() {}
+
+// drainSignalChannel returns a channel that is closed
+// when the process receives a hangup signal.
+// Windows does not have hangup signals,
+// so the returned channel is never closed.
+func drainSignalChannel() (<-chan struct{}, func()) {
+	return nil, func() {}
+}
+
+// restartServer is not supported on Windows.
+func restartServer(listener *os.File) error {
+	listener.Close()
+	return errors.New("restart: not supported on Windows")
+}
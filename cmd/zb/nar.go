@@ -11,7 +11,6 @@ import (
 	"path/filepath"
 
 	"github.com/alecthomas/kong"
-	"zb.256lights.llc/pkg/bytebuffer"
 	"zb.256lights.llc/pkg/zbstore"
 	"zombiezen.com/go/log"
 	"zombiezen.com/go/nix/nar"
@@ -83,7 +82,7 @@ func runPackNARSelfRefs(ctx context.Context, dst io.ReadWriteSeeker, path string
 		var result caResult
 		result.ca, result.analysis, result.err = zbstore.SourceSHA256ContentAddress(pr, &zbstore.ContentAddressOptions{
 			Digest:     originalDigest,
-			CreateTemp: bytebuffer.TempFileCreator{Pattern: contentAddressTempFilePattern},
+			CreateTemp: contentAddressBufferCreator(),
 			Log:        func(msg string) { log.Debugf(ctx, "%s", msg) },
 		})
 		c <- result
@@ -0,0 +1,123 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"cmp"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// networkConfig is the configuration for outbound HTTP connections,
+// used for evaluation downloads, substituters, uploads, and builtin:fetchurl.
+// The zero value uses the same settings as [http.DefaultTransport].
+type networkConfig struct {
+	// HTTPProxy, HTTPSProxy, and NoProxy override
+	// the HTTP_PROXY, HTTPS_PROXY, and NO_PROXY environment variables, respectively.
+	// See [httpproxy.Config] for details.
+	HTTPProxy  string `json:"httpProxy,omitempty"`
+	HTTPSProxy string `json:"httpsProxy,omitempty"`
+	NoProxy    string `json:"noProxy,omitempty"`
+
+	// CABundle is the path to a file of PEM-encoded certificates
+	// to trust in addition to the system's certificate authorities.
+	// Relative paths are resolved relative to the configuration file.
+	CABundle string `json:"caBundle,omitempty"`
+	// TLSMinVersion is the minimum TLS version to accept: "1.2" or "1.3".
+	// If empty, then Go's default is used.
+	TLSMinVersion string `json:"tlsMinVersion,omitempty"`
+
+	// ConnectTimeout is the maximum amount of time to wait for a TCP connection.
+	ConnectTimeout time.Duration `json:"connectTimeout,omitzero,format:units"`
+	// TLSHandshakeTimeout is the maximum amount of time to wait for a TLS handshake.
+	TLSHandshakeTimeout time.Duration `json:"tlsHandshakeTimeout,omitzero,format:units"`
+	// ResponseHeaderTimeout is the maximum amount of time
+	// to wait for a server's response headers after sending a request.
+	// If zero, then there is no timeout.
+	ResponseHeaderTimeout time.Duration `json:"responseHeaderTimeout,omitzero,format:units"`
+}
+
+// Default timeouts copied from [http.DefaultTransport].
+const (
+	defaultConnectTimeout      = 30 * time.Second
+	defaultTLSHandshakeTimeout = 10 * time.Second
+)
+
+// newTransport returns a new [*http.Transport] that uses the configuration.
+func (nc *networkConfig) newTransport() (*http.Transport, error) {
+	tlsConfig, err := nc.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	proxyConfig := httpproxy.FromEnvironment()
+	if nc.HTTPProxy != "" {
+		proxyConfig.HTTPProxy = nc.HTTPProxy
+	}
+	if nc.HTTPSProxy != "" {
+		proxyConfig.HTTPSProxy = nc.HTTPSProxy
+	}
+	if nc.NoProxy != "" {
+		proxyConfig.NoProxy = nc.NoProxy
+	}
+	proxyFunc := proxyConfig.ProxyFunc()
+
+	return &http.Transport{
+		// Other settings copied from [http.DefaultTransport].
+		Proxy: func(req *http.Request) (*url.URL, error) {
+			return proxyFunc(req.URL)
+		},
+		DialContext: (&net.Dialer{
+			Timeout:   cmp.Or(nc.ConnectTimeout, defaultConnectTimeout),
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSClientConfig:       tlsConfig,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   cmp.Or(nc.TLSHandshakeTimeout, defaultTLSHandshakeTimeout),
+		ResponseHeaderTimeout: nc.ResponseHeaderTimeout,
+		ExpectContinueTimeout: 1 * time.Second,
+	}, nil
+}
+
+// tlsConfig returns the TLS client configuration
+// or nil if the configuration does not change any TLS settings.
+func (nc *networkConfig) tlsConfig() (*tls.Config, error) {
+	if nc.CABundle == "" && nc.TLSMinVersion == "" {
+		return nil, nil
+	}
+	config := new(tls.Config)
+	switch nc.TLSMinVersion {
+	case "":
+	case "1.2":
+		config.MinVersion = tls.VersionTLS12
+	case "1.3":
+		config.MinVersion = tls.VersionTLS13
+	default:
+		return nil, fmt.Errorf("network.tlsMinVersion: unsupported TLS version %q (must be \"1.2\" or \"1.3\")", nc.TLSMinVersion)
+	}
+	if nc.CABundle != "" {
+		pemData, err := os.ReadFile(nc.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("network.caBundle: %v", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pemData) {
+			return nil, fmt.Errorf("network.caBundle: %s does not contain any PEM certificates", nc.CABundle)
+		}
+		config.RootCAs = pool
+	}
+	return config, nil
+}
@@ -0,0 +1,135 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNetworkConfigProxy(t *testing.T) {
+	nc := &networkConfig{
+		HTTPProxy:  "http://proxy.example.com:3128",
+		HTTPSProxy: "http://secure-proxy.example.com:3128",
+		NoProxy:    "internal.example.com",
+	}
+	transport, err := nc.newTransport()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"http://example.com/foo", "http://proxy.example.com:3128"},
+		{"https://example.com/foo", "http://secure-proxy.example.com:3128"},
+		{"https://internal.example.com/foo", ""},
+	}
+	for _, test := range tests {
+		req, err := http.NewRequest(http.MethodGet, test.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		proxyURL, err := transport.Proxy(req)
+		if err != nil {
+			t.Errorf("Proxy(%q): %v", test.url, err)
+			continue
+		}
+		got := ""
+		if proxyURL != nil {
+			got = proxyURL.String()
+		}
+		if got != test.want {
+			t.Errorf("Proxy(%q) = %q; want %q", test.url, got, test.want)
+		}
+	}
+}
+
+func TestNetworkConfigCABundle(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Hello, World!\n"))
+	}))
+	defer srv.Close()
+
+	caBundle := filepath.Join(t.TempDir(), "ca.pem")
+	pemData := pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: srv.Certificate().Raw,
+	})
+	if err := os.WriteFile(caBundle, pemData, 0o666); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Trusted", func(t *testing.T) {
+		transport, err := (&networkConfig{CABundle: caBundle}).newTransport()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer transport.CloseIdleConnections()
+		resp, err := (&http.Client{Transport: transport}).Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	})
+
+	t.Run("Untrusted", func(t *testing.T) {
+		transport, err := new(networkConfig).newTransport()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer transport.CloseIdleConnections()
+		resp, err := (&http.Client{Transport: transport}).Get(srv.URL)
+		if err == nil {
+			resp.Body.Close()
+			t.Error("request succeeded without trusting the server's certificate")
+		}
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		_, err := (&networkConfig{CABundle: filepath.Join(t.TempDir(), "missing.pem")}).newTransport()
+		if err == nil {
+			t.Error("newTransport did not return an error")
+		}
+	})
+}
+
+func TestNetworkConfigTLSMinVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		want    uint16
+		wantErr bool
+	}{
+		{version: "", want: 0},
+		{version: "1.2", want: tls.VersionTLS12},
+		{version: "1.3", want: tls.VersionTLS13},
+		{version: "1.0", wantErr: true},
+	}
+	for _, test := range tests {
+		transport, err := (&networkConfig{TLSMinVersion: test.version}).newTransport()
+		if err != nil {
+			if !test.wantErr {
+				t.Errorf("newTransport() with tlsMinVersion %q: %v", test.version, err)
+			}
+			continue
+		}
+		if test.wantErr {
+			t.Errorf("newTransport() with tlsMinVersion %q did not return an error", test.version)
+			continue
+		}
+		var got uint16
+		if transport.TLSClientConfig != nil {
+			got = transport.TLSClientConfig.MinVersion
+		}
+		if got != test.want {
+			t.Errorf("newTransport() with tlsMinVersion %q: MinVersion = %#x; want %#x", test.version, got, test.want)
+		}
+	}
+}
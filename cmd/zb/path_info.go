@@ -0,0 +1,140 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/go-json-experiment/json/jsontext"
+	"zb.256lights.llc/pkg/internal/backend"
+	"zb.256lights.llc/pkg/internal/jsonrpc"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+	"zb.256lights.llc/pkg/zbstore"
+)
+
+type storePathInfoCommand struct {
+	Paths       []string `kong:"arg,name=path,completion-predictor=storepath"`
+	JSONFormat  bool     `kong:"name=json,help=Print path info as JSON."`
+	ClosureSize bool     `kong:"name=closure-size,help=Include the total NAR size of each object and its references."`
+	Signatures  bool     `kong:"name=sigs,help=Include the signatures of the realizations that produced each object."`
+}
+
+func (c *storePathInfoCommand) Signature() string {
+	return `kong:"help=Show everything the store has recorded about one or more store objects."`
+}
+
+func (c *storePathInfoCommand) Run(ctx context.Context, g *globalConfig) error {
+	storeClient := g.storeClient(nil)
+	defer storeClient.Close()
+
+	handshake, err := zbstorerpc.Handshake(ctx, storeClient)
+	if err != nil {
+		return err
+	}
+	if err := handshake.Require(zbstorerpc.CapabilityPathInfo, "path info"); err != nil {
+		return err
+	}
+
+	const errNotExist = "does not exist"
+
+	var buf []byte
+	for i, p := range c.Paths {
+		path, err := zbstore.ParsePath(p)
+		if err != nil {
+			return err
+		}
+
+		req := &zbstorerpc.PathInfoRequest{
+			Path:        path,
+			ClosureSize: c.ClosureSize,
+			Signatures:  c.Signatures,
+		}
+		if c.JSONFormat {
+			// Dump info response directly to preserve unknown fields.
+			var partialParsed struct {
+				Info jsontext.Value `json:"info"`
+			}
+			err = jsonrpc.Do(ctx, storeClient, zbstorerpc.PathInfoMethod, &partialParsed, req)
+			if err != nil {
+				return fmt.Errorf("%s: %v", path, err)
+			}
+			if string(partialParsed.Info) == "null" {
+				return fmt.Errorf("%s: %v", path, errNotExist)
+			}
+			if err := partialParsed.Info.Compact(); err != nil {
+				return fmt.Errorf("%s: %v", path, err)
+			}
+			jsonBytes := append(slices.Clip([]byte(partialParsed.Info)), '\n')
+			if _, err := os.Stdout.Write(jsonBytes); err != nil {
+				return err
+			}
+			continue
+		}
+
+		resp := new(zbstorerpc.PathInfoResponse)
+		err = jsonrpc.Do(ctx, storeClient, zbstorerpc.PathInfoMethod, resp, req)
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		if resp.Info == nil {
+			return fmt.Errorf("%s: %v", path, errNotExist)
+		}
+
+		buf = buf[:0]
+		if i > 0 {
+			// Blank line between entries.
+			buf = append(buf, '\n')
+		}
+		buf, err = appendPathInfoText(buf, path, resp.Info)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Stdout.Write(buf); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// appendPathInfoText appends a human-readable description of info to dst.
+// The fields in [zbstorerpc.ObjectInfo] are formatted like a .narinfo file
+// and the remaining fields follow in the same "Key: value" form.
+func appendPathInfoText(dst []byte, path zbstore.Path, info *zbstorerpc.PathInfo) ([]byte, error) {
+	dst, err := backend.NewObjectInfo(path, &info.ObjectInfo).AppendText(dst)
+	if err != nil {
+		return dst, err
+	}
+	if len(info.Derivers) > 0 {
+		dst = append(dst, "Derivers:"...)
+		for _, drvPath := range info.Derivers {
+			dst = append(dst, ' ')
+			dst = append(dst, drvPath.Base()...)
+		}
+		dst = append(dst, '\n')
+	}
+	if !info.RegistrationTime.IsZero() {
+		dst = append(dst, "RegistrationTime: "...)
+		dst = info.RegistrationTime.Local().AppendFormat(dst, time.RFC3339)
+		dst = append(dst, '\n')
+	}
+	if info.ClosureSize > 0 {
+		dst = append(dst, "ClosureSize: "...)
+		dst = strconv.AppendInt(dst, info.ClosureSize, 10)
+		dst = append(dst, '\n')
+	}
+	for _, sig := range info.Signatures {
+		dst = fmt.Appendf(dst, "Sig: %s:%s:%s\n",
+			sig.PublicKey.Format,
+			base64.StdEncoding.EncodeToString(sig.PublicKey.Data),
+			base64.StdEncoding.EncodeToString(sig.Signature))
+	}
+	return dst, nil
+}
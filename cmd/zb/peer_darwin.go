@@ -0,0 +1,45 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+	"zb.256lights.llc/pkg/internal/backend"
+)
+
+// peerCredentials returns the credentials of the process
+// on the other end of a Unix domain socket connection
+// or nil if they cannot be determined.
+func peerCredentials(conn net.Conn) *backend.PeerCredentials {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return nil
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return nil
+	}
+	var cred *unix.Xucred
+	var pid int
+	var credError error
+	err = rc.Control(func(fd uintptr) {
+		cred, credError = unix.GetsockoptXucred(int(fd), unix.SOL_LOCAL, unix.LOCAL_PEERCRED)
+		if credError != nil {
+			return
+		}
+		// The process ID is best-effort.
+		pid, _ = unix.GetsockoptInt(int(fd), unix.SOL_LOCAL, unix.LOCAL_PEERPID)
+	})
+	if err != nil || credError != nil || cred.Ngroups < 1 {
+		return nil
+	}
+	return &backend.PeerCredentials{
+		UID: int(cred.Uid),
+		GID: int(cred.Groups[0]),
+		PID: pid,
+	}
+}
@@ -0,0 +1,18 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+//go:build !linux && !darwin
+
+package main
+
+import (
+	"net"
+
+	"zb.256lights.llc/pkg/internal/backend"
+)
+
+// peerCredentials returns nil:
+// peer credentials are not supported on this platform.
+func peerCredentials(conn net.Conn) *backend.PeerCredentials {
+	return nil
+}
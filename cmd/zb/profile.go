@@ -0,0 +1,761 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	jsonv2 "github.com/go-json-experiment/json"
+	"github.com/go-json-experiment/json/jsontext"
+	"zb.256lights.llc/pkg/internal/fileurl"
+	"zb.256lights.llc/pkg/internal/frontend"
+	"zb.256lights.llc/pkg/internal/jsonrpc"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+	"zb.256lights.llc/pkg/sets"
+	"zb.256lights.llc/pkg/zbstore"
+	"zombiezen.com/go/log"
+)
+
+// profileMetadataDir is the name of the directory inside a profile generation
+// that holds zb's bookkeeping.
+const profileMetadataDir = ".zb-profile"
+
+type profileCommand struct {
+	Install  profileInstallCommand  `kong:"cmd"`
+	Upgrade  profileUpgradeCommand  `kong:"cmd"`
+	Remove   profileRemoveCommand   `kong:"cmd,aliases=rm"`
+	List     profileListCommand     `kong:"cmd,aliases=ls"`
+	Rollback profileRollbackCommand `kong:"cmd"`
+}
+
+func (profileCommand) Signature() string {
+	return `kong:"help=Manage user environments of installed packages."`
+}
+
+type profileFlags struct {
+	Profile string `kong:"short=p,type=path,placeholder=path,default=${default_profile},help=Profile to operate on. (Default: ${default})"`
+}
+
+func (f *profileFlags) profile() (*profile, error) {
+	if f.Profile == "" {
+		return nil, errors.New("no profile directory (use --profile)")
+	}
+	return &profile{path: f.Profile}, nil
+}
+
+// defaultProfilePath returns the path of the profile
+// used when --profile is not given.
+func defaultProfilePath() string {
+	dir := dataDir()
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, "zb", "profiles", "default")
+}
+
+type profileInstallCommand struct {
+	profileFlags `kong:"embed"`
+	evalOptions  `kong:"embed"`
+}
+
+func (c *profileInstallCommand) Signature() string {
+	return `kong:"help=Build derivations and add them to a profile."`
+}
+
+func (c *profileInstallCommand) Run(ctx context.Context, g *globalConfig) error {
+	p, err := c.profile()
+	if err != nil {
+		return err
+	}
+	m, err := p.currentManifest()
+	if err != nil {
+		return err
+	}
+
+	var sources []profileSource
+	if c.Expression {
+		sources = append(sources, profileSource{Expression: c.Args[0]})
+	} else {
+		for _, arg := range c.Args {
+			u, err := absoluteURL(arg)
+			if err != nil {
+				return err
+			}
+			sources = append(sources, profileSource{URL: u})
+		}
+	}
+	return withProfileStore(ctx, g, &c.evalEnvOptions, func(ps *profileStore) error {
+		newElements, err := ps.build(ctx, sources)
+		if err != nil {
+			return err
+		}
+		for _, elem := range newElements {
+			m.Elements = slices.DeleteFunc(m.Elements, func(old *profileElement) bool {
+				return old.Name == elem.Name
+			})
+			m.Elements = append(m.Elements, elem)
+		}
+		return p.commit(ctx, ps.client, m)
+	})
+}
+
+type profileUpgradeCommand struct {
+	profileFlags   `kong:"embed"`
+	evalEnvOptions `kong:"embed"`
+	Names          []string `kong:"name=name,arg,optional,help=Names of packages to upgrade. (Default: all)"`
+}
+
+func (c *profileUpgradeCommand) Signature() string {
+	return `kong:"help=Re-evaluate and rebuild packages in a profile."`
+}
+
+func (c *profileUpgradeCommand) Run(ctx context.Context, g *globalConfig) error {
+	p, err := c.profile()
+	if err != nil {
+		return err
+	}
+	m, err := p.currentManifest()
+	if err != nil {
+		return err
+	}
+	indices, err := m.indices(c.Names)
+	if err != nil {
+		return err
+	}
+	if len(indices) == 0 {
+		return nil
+	}
+	sources := make([]profileSource, 0, len(indices))
+	for _, i := range indices {
+		sources = append(sources, m.Elements[i].Source)
+	}
+
+	return withProfileStore(ctx, g, &c.evalEnvOptions, func(ps *profileStore) error {
+		newElements, err := ps.build(ctx, sources)
+		if err != nil {
+			return err
+		}
+		changed := false
+		for j, i := range indices {
+			old, elem := m.Elements[i], newElements[j]
+			if old.DrvPath == elem.DrvPath {
+				continue
+			}
+			log.Infof(ctx, "Upgrading %s (%s -> %s)", old.Name, old.DrvPath.Name(), elem.DrvPath.Name())
+			m.Elements[i] = elem
+			changed = true
+		}
+		if !changed {
+			log.Infof(ctx, "No packages to upgrade")
+			return nil
+		}
+		return p.commit(ctx, ps.client, m)
+	})
+}
+
+type profileRemoveCommand struct {
+	profileFlags `kong:"embed"`
+	Names        []string `kong:"name=name,arg,help=Names of packages to remove."`
+}
+
+func (c *profileRemoveCommand) Signature() string {
+	return `kong:"help=Remove packages from a profile."`
+}
+
+func (c *profileRemoveCommand) Run(ctx context.Context, g *globalConfig) error {
+	p, err := c.profile()
+	if err != nil {
+		return err
+	}
+	m, err := p.currentManifest()
+	if err != nil {
+		return err
+	}
+	indices, err := m.indices(c.Names)
+	if err != nil {
+		return err
+	}
+	remove := sets.New(indices...)
+	newManifest := new(profileManifest)
+	for i, elem := range m.Elements {
+		if !remove.Has(i) {
+			newManifest.Elements = append(newManifest.Elements, elem)
+		}
+	}
+
+	client := g.storeClient(nil)
+	defer client.Close()
+	return p.commit(ctx, client, newManifest)
+}
+
+type profileListCommand struct {
+	profileFlags `kong:"embed"`
+	Generations  bool `kong:"help=List generations of the profile instead of its packages."`
+	JSONFormat   bool `kong:"name=json,help=Print the list as JSON."`
+}
+
+func (c *profileListCommand) Signature() string {
+	return `kong:"help=List packages or generations in a profile."`
+}
+
+func (c *profileListCommand) Run(ctx context.Context, g *globalConfig) error {
+	p, err := c.profile()
+	if err != nil {
+		return err
+	}
+	if c.Generations {
+		return c.listGenerations(p)
+	}
+	m, err := p.currentManifest()
+	if err != nil {
+		return err
+	}
+	if c.JSONFormat {
+		data, err := jsonv2.Marshal(m.Elements, jsontext.Multiline(true))
+		if err != nil {
+			return err
+		}
+		data = append(data, '\n')
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	for _, elem := range m.Elements {
+		outputs := make([]string, 0, len(elem.Outputs))
+		for _, out := range elem.Outputs {
+			outputs = append(outputs, string(out))
+		}
+		if _, err := fmt.Printf("%s\t%s\n", elem.Name, strings.Join(outputs, " ")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *profileListCommand) listGenerations(p *profile) error {
+	type generationInfo struct {
+		Number  int       `json:"number"`
+		Created time.Time `json:"created"`
+		Current bool      `json:"current"`
+	}
+
+	gens, err := p.generations()
+	if err != nil {
+		return err
+	}
+	current, err := p.current()
+	if err != nil {
+		return err
+	}
+	infos := make([]generationInfo, 0, len(gens))
+	for _, n := range gens {
+		info, err := os.Lstat(p.generationPath(n))
+		if err != nil {
+			return err
+		}
+		infos = append(infos, generationInfo{
+			Number:  n,
+			Created: info.ModTime().UTC(),
+			Current: n == current,
+		})
+	}
+
+	if c.JSONFormat {
+		data, err := jsonv2.Marshal(infos, jsontext.Multiline(true))
+		if err != nil {
+			return err
+		}
+		data = append(data, '\n')
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	for _, info := range infos {
+		line := fmt.Sprintf("%4d   %s", info.Number, info.Created.Local().Format(time.DateTime))
+		if info.Current {
+			line += "   (current)"
+		}
+		if _, err := fmt.Println(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type profileRollbackCommand struct {
+	profileFlags `kong:"embed"`
+	To           int `kong:"placeholder=generation,help=Switch to the given generation instead of the previous one."`
+}
+
+func (c *profileRollbackCommand) Signature() string {
+	return `kong:"help=Switch a profile to a previous generation."`
+}
+
+func (c *profileRollbackCommand) Run(ctx context.Context, g *globalConfig) error {
+	p, err := c.profile()
+	if err != nil {
+		return err
+	}
+	gens, err := p.generations()
+	if err != nil {
+		return err
+	}
+	current, err := p.current()
+	if err != nil {
+		return err
+	}
+	target := c.To
+	if target == 0 {
+		i, _ := slices.BinarySearch(gens, current)
+		if i == 0 {
+			return fmt.Errorf("%s: no generation older than %d", p.path, current)
+		}
+		target = gens[i-1]
+	} else if _, found := slices.BinarySearch(gens, target); !found {
+		return fmt.Errorf("%s: no generation %d", p.path, target)
+	}
+	if err := p.switchTo(target); err != nil {
+		return err
+	}
+	log.Infof(ctx, "Switched from generation %d to %d", current, target)
+	return nil
+}
+
+// profileManifest is the list of packages installed in a profile generation.
+type profileManifest struct {
+	Elements []*profileElement `json:"elements"`
+}
+
+// profileElement is a single installed package in a [profileManifest].
+type profileElement struct {
+	Name    string         `json:"name"`
+	Source  profileSource  `json:"source"`
+	DrvPath zbstore.Path   `json:"drvPath"`
+	Outputs []zbstore.Path `json:"outputs"`
+}
+
+// profileSource is the expression that produced a [profileElement].
+// Exactly one of the fields is set.
+type profileSource struct {
+	URL        string `json:"url,omitzero"`
+	Expression string `json:"expression,omitzero"`
+}
+
+// indices returns the indices of the elements with the given names.
+// If names is empty, indices returns the indices of all elements.
+func (m *profileManifest) indices(names []string) ([]int, error) {
+	var result []int
+	if len(names) == 0 {
+		for i := range m.Elements {
+			result = append(result, i)
+		}
+		return result, nil
+	}
+	for _, name := range names {
+		i := slices.IndexFunc(m.Elements, func(elem *profileElement) bool {
+			return elem.Name == name
+		})
+		if i < 0 {
+			return nil, fmt.Errorf("%s is not installed", name)
+		}
+		result = append(result, i)
+	}
+	slices.Sort(result)
+	return slices.Compact(result), nil
+}
+
+// absoluteURL resolves local paths in a URL argument
+// so that it can be evaluated again from a different directory.
+func absoluteURL(s string) (string, error) {
+	u, err := frontend.ParseURL(s)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "" && u.Scheme != fileurl.Scheme {
+		return s, nil
+	}
+	path, err := frontend.URLToPath(u)
+	if err != nil {
+		return "", err
+	}
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	abs := fileurl.FromPath(path)
+	abs.Fragment = u.Fragment
+	abs.RawFragment = u.RawFragment
+	return abs.String(), nil
+}
+
+// profileStore is the evaluator and store connection
+// used to build packages for a profile.
+type profileStore struct {
+	opts   *evalEnvOptions
+	g      *globalConfig
+	client *jsonrpc.Client
+	eval   *frontend.Eval
+}
+
+func withProfileStore(ctx context.Context, g *globalConfig, opts *evalEnvOptions, f func(*profileStore) error) error {
+	httpClient, httpCloser, err := g.newHTTPClient()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		httpClient.CloseIdleConnections()
+		if err := httpCloser.Close(); err != nil {
+			log.Warnf(ctx, "%v", err)
+		}
+	}()
+	di := new(zbstorerpc.DeferredImporter)
+	storeClient := g.storeClient(&zbstorerpc.CodecOptions{
+		Importer: di,
+	})
+	defer storeClient.Close()
+	eval, err := opts.newEval(g, httpClient, storeClient, di)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := eval.Close(); err != nil {
+			log.Errorf(ctx, "%v", err)
+		}
+	}()
+	return f(&profileStore{
+		opts:   opts,
+		g:      g,
+		client: storeClient,
+		eval:   eval,
+	})
+}
+
+// build evaluates and builds each of the sources,
+// returning an element for each source in the same order.
+func (ps *profileStore) build(ctx context.Context, sources []profileSource) ([]*profileElement, error) {
+	elements := make([]*profileElement, 0, len(sources))
+	drvPaths := make([]zbstore.Path, 0, len(sources))
+	for _, src := range sources {
+		var result any
+		var err error
+		if src.Expression != "" {
+			result, err = ps.eval.Expression(ctx, src.Expression)
+		} else {
+			var results []any
+			results, err = ps.eval.URLs(ctx, []string{src.URL})
+			if err == nil {
+				result = results[0]
+			}
+		}
+		if err != nil {
+			return nil, evaluationError(err)
+		}
+		drv, _ := result.(*frontend.Derivation)
+		if drv == nil {
+			return nil, fmt.Errorf("%v is not a derivation", result)
+		}
+		elements = append(elements, &profileElement{
+			Name:    cmp.Or(drv.Env[sbomNameVar], drv.Name),
+			Source:  src,
+			DrvPath: drv.Path,
+		})
+		drvPaths = append(drvPaths, drv.Path)
+	}
+
+	if err := ps.opts.requireStoreSupport(ctx, ps.client); err != nil {
+		return nil, err
+	}
+	realizeResponse := new(zbstorerpc.RealizeResponse)
+	err := jsonrpc.Do(ctx, ps.client, zbstorerpc.RealizeMethod, realizeResponse, &zbstorerpc.RealizeRequest{
+		DrvPaths:       drvPaths,
+		KeepFailed:     ps.opts.KeepFailed,
+		Reuse:          ps.opts.reusePolicy(ps.g),
+		Priority:       ps.opts.Priority,
+		SubstituteOnly: ps.opts.SubstituteOnly,
+		Offline:        ps.opts.Offline,
+	})
+	if err != nil {
+		return nil, err
+	}
+	build, _, err := waitForBuild(ctx, ps.client, realizeResponse.BuildID)
+	if err != nil {
+		return nil, substitutionError(ps.opts.SubstituteOnly, err)
+	}
+	for _, elem := range elements {
+		result, err := build.ResultForPath(elem.DrvPath)
+		if err != nil {
+			return nil, err
+		}
+		for _, output := range result.Outputs {
+			if !output.Path.Valid {
+				return nil, fmt.Errorf("%v: not built", zbstore.OutputReference{DrvPath: elem.DrvPath, OutputName: output.Name})
+			}
+			elem.Outputs = append(elem.Outputs, output.Path.X)
+		}
+		slices.Sort(elem.Outputs)
+	}
+	return elements, nil
+}
+
+// A profile is a symlink to the current generation of a user environment.
+// Generations are directories next to the profile
+// named "<profile>-<n>-link" for a positive integer n.
+// Each generation is a symlink forest of the installed store objects.
+type profile struct {
+	path string
+}
+
+func (p *profile) generationPath(n int) string {
+	return fmt.Sprintf("%s-%d-link", p.path, n)
+}
+
+// generations returns the sorted list of generation numbers of the profile.
+func (p *profile) generations() ([]int, error) {
+	dir, base := filepath.Split(p.path)
+	entries, err := os.ReadDir(cmp.Or(dir, "."))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var gens []int
+	for _, ent := range entries {
+		rest, ok := strings.CutPrefix(ent.Name(), base+"-")
+		if !ok {
+			continue
+		}
+		rest, ok = strings.CutSuffix(rest, "-link")
+		if !ok {
+			continue
+		}
+		if n, err := strconv.Atoi(rest); err == nil && n > 0 {
+			gens = append(gens, n)
+		}
+	}
+	slices.Sort(gens)
+	return gens, nil
+}
+
+// current returns the generation number that the profile points to
+// or zero if the profile does not exist.
+func (p *profile) current() (int, error) {
+	target, err := os.Readlink(p.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	_, base := filepath.Split(p.path)
+	rest, ok := strings.CutPrefix(filepath.Base(target), base+"-")
+	if ok {
+		rest, ok = strings.CutSuffix(rest, "-link")
+	}
+	n, err := strconv.Atoi(rest)
+	if !ok || err != nil || n <= 0 {
+		return 0, fmt.Errorf("%s: points to %s, which is not a generation", p.path, target)
+	}
+	return n, nil
+}
+
+// currentManifest returns the manifest of the current generation.
+// It returns an empty manifest if the profile does not exist.
+func (p *profile) currentManifest() (*profileManifest, error) {
+	n, err := p.current()
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return new(profileManifest), nil
+	}
+	data, err := os.ReadFile(filepath.Join(p.generationPath(n), profileMetadataDir, "manifest.json"))
+	if err != nil {
+		return nil, err
+	}
+	m := new(profileManifest)
+	if err := jsonv2.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("read %s generation %d: %v", p.path, n, err)
+	}
+	return m, nil
+}
+
+// switchTo atomically points the profile at generation n.
+func (p *profile) switchTo(n int) error {
+	tempLink := fmt.Sprintf("%s.tmp-%d", p.path, os.Getpid())
+	if err := os.Symlink(filepath.Base(p.generationPath(n)), tempLink); err != nil {
+		return err
+	}
+	if err := os.Rename(tempLink, p.path); err != nil {
+		os.Remove(tempLink)
+		return err
+	}
+	return nil
+}
+
+// commit creates a new generation from m, registers its store objects as roots,
+// and switches the profile to it.
+func (p *profile) commit(ctx context.Context, storeClient jsonrpc.Handler, m *profileManifest) error {
+	handshake, err := zbstorerpc.Handshake(ctx, storeClient)
+	if err != nil {
+		return err
+	}
+	if err := handshake.Require(zbstorerpc.CapabilityAddRoot, "registering profile roots"); err != nil {
+		return err
+	}
+	gens, err := p.generations()
+	if err != nil {
+		return err
+	}
+	n := 1
+	if len(gens) > 0 {
+		n = gens[len(gens)-1] + 1
+	}
+	if err := os.MkdirAll(filepath.Dir(p.path), 0o777); err != nil {
+		return err
+	}
+	genPath := p.generationPath(n)
+	tempPath, err := os.MkdirTemp(filepath.Dir(p.path), filepath.Base(genPath)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tempPath)
+	if err := writeGeneration(ctx, tempPath, m); err != nil {
+		return fmt.Errorf("create %s: %v", genPath, err)
+	}
+	if err := os.Rename(tempPath, genPath); err != nil {
+		return err
+	}
+
+	rootsDir := filepath.Join(genPath, profileMetadataDir, "roots")
+	roots, err := os.ReadDir(rootsDir)
+	if err != nil {
+		return err
+	}
+	for _, ent := range roots {
+		err := jsonrpc.Do(ctx, storeClient, zbstorerpc.AddRootMethod, nil, &zbstorerpc.AddRootRequest{
+			Link: filepath.Join(rootsDir, ent.Name()),
+		})
+		if err != nil {
+			os.RemoveAll(genPath)
+			return fmt.Errorf("create %s: %v", genPath, err)
+		}
+	}
+
+	if err := p.switchTo(n); err != nil {
+		return err
+	}
+	log.Infof(ctx, "Created %s generation %d", p.path, n)
+	return nil
+}
+
+// writeGeneration populates dir with a symlink forest of the outputs in m,
+// the manifest, and a symlink to each output to be used as a root.
+func writeGeneration(ctx context.Context, dir string, m *profileManifest) error {
+	metaDir := filepath.Join(dir, profileMetadataDir)
+	rootsDir := filepath.Join(metaDir, "roots")
+	if err := os.MkdirAll(rootsDir, 0o777); err != nil {
+		return err
+	}
+	for _, elem := range m.Elements {
+		for _, output := range elem.Outputs {
+			if err := os.Symlink(string(output), filepath.Join(rootsDir, output.Base())); err != nil && !errors.Is(err, os.ErrExist) {
+				return err
+			}
+			info, err := os.Stat(string(output))
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() {
+				log.Warnf(ctx, "%s is not a directory; not linking into profile", output)
+				continue
+			}
+			if err := linkTree(dir, string(output)); err != nil {
+				return fmt.Errorf("%s: %v", elem.Name, err)
+			}
+		}
+	}
+
+	data, err := jsonv2.Marshal(m, jsontext.Multiline(true))
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	return os.WriteFile(filepath.Join(metaDir, "manifest.json"), data, 0o666)
+}
+
+// linkTree adds symlinks to the contents of the directory src into dst.
+// Directories that are provided by more than one source
+// are created as real directories containing links to each source's entries.
+// linkTree returns an error if two sources provide the same file.
+func linkTree(dst, src string) error {
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, ent := range entries {
+		srcPath := filepath.Join(src, ent.Name())
+		dstPath := filepath.Join(dst, ent.Name())
+		dstInfo, err := os.Lstat(dstPath)
+		if errors.Is(err, fs.ErrNotExist) {
+			if err := os.Symlink(srcPath, dstPath); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if !isDir(srcPath) {
+			return fmt.Errorf("%s conflicts with %s", srcPath, describeLink(dstPath))
+		}
+		if dstInfo.Mode().Type() == fs.ModeSymlink {
+			// Replace the link to another source's directory
+			// with a directory of links.
+			existing, err := os.Readlink(dstPath)
+			if err != nil {
+				return err
+			}
+			if !isDir(existing) {
+				return fmt.Errorf("%s conflicts with %s", srcPath, existing)
+			}
+			if err := os.Remove(dstPath); err != nil {
+				return err
+			}
+			if err := os.Mkdir(dstPath, 0o777); err != nil {
+				return err
+			}
+			if err := linkTree(dstPath, existing); err != nil {
+				return err
+			}
+		} else if !dstInfo.IsDir() {
+			return fmt.Errorf("%s conflicts with %s", srcPath, dstPath)
+		}
+		if err := linkTree(dstPath, srcPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+func describeLink(path string) string {
+	if target, err := os.Readlink(path); err == nil {
+		return target
+	}
+	return path
+}
@@ -0,0 +1,92 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestLinkTree(t *testing.T) {
+	dir := t.TempDir()
+	pkg1 := filepath.Join(dir, "pkg1")
+	pkg2 := filepath.Join(dir, "pkg2")
+	for _, name := range []string{
+		filepath.Join(pkg1, "bin", "hello"),
+		filepath.Join(pkg1, "share", "man", "hello.1"),
+		filepath.Join(pkg2, "bin", "goodbye"),
+	} {
+		if err := os.MkdirAll(filepath.Dir(name), 0o777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(filepath.Base(name)), 0o666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dst := filepath.Join(dir, "profile")
+	if err := os.Mkdir(dst, 0o777); err != nil {
+		t.Fatal(err)
+	}
+	if err := linkTree(dst, pkg1); err != nil {
+		t.Fatal(err)
+	}
+	if err := linkTree(dst, pkg2); err != nil {
+		t.Fatal(err)
+	}
+
+	wantLinks := map[string]string{
+		filepath.Join("bin", "hello"):   filepath.Join(pkg1, "bin", "hello"),
+		filepath.Join("bin", "goodbye"): filepath.Join(pkg2, "bin", "goodbye"),
+		"share":                         filepath.Join(pkg1, "share"),
+	}
+	for name, want := range wantLinks {
+		got, err := os.Readlink(filepath.Join(dst, name))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if got != want {
+			t.Errorf("%s -> %s; want %s", name, got, want)
+		}
+	}
+
+	if err := linkTree(dst, pkg1); err == nil {
+		t.Error("Linking the same package twice did not return a conflict error")
+	}
+}
+
+func TestProfileGenerations(t *testing.T) {
+	p := &profile{path: filepath.Join(t.TempDir(), "default")}
+	if n, err := p.current(); n != 0 || err != nil {
+		t.Errorf("current() on missing profile = %d, %v; want 0, <nil>", n, err)
+	}
+	for _, n := range []int{1, 2, 10} {
+		if err := os.Mkdir(p.generationPath(n), 0o777); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(p.path+"-foo-link", 0o777); err != nil {
+		t.Fatal(err)
+	}
+
+	gens, err := p.generations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{1, 2, 10}; !slices.Equal(gens, want) {
+		t.Errorf("generations() = %v; want %v", gens, want)
+	}
+
+	for _, n := range []int{10, 2} {
+		if err := p.switchTo(n); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := p.current(); got != n || err != nil {
+			t.Errorf("after switchTo(%d), current() = %d, %v; want %d, <nil>", n, got, err, n)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"golang.org/x/term"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+	"zombiezen.com/go/log"
+)
+
+// progressInterval is the minimum amount of time between progress line updates.
+const progressInterval = 100 * time.Millisecond
+
+// transferProgress displays the progress of a store object transfer.
+// If stderr is a terminal, transferProgress keeps a status line up to date.
+// Completed store objects are logged at debug level.
+type transferProgress struct {
+	ctx  context.Context
+	verb string
+	// w is the terminal to show the status line on
+	// or nil if no status line should be shown.
+	w          io.Writer
+	lastUpdate time.Time
+	shown      bool
+}
+
+// newTransferProgress returns a new [transferProgress]
+// that describes the transfer with the given verb (e.g. "Exporting").
+func newTransferProgress(ctx context.Context, verb string) *transferProgress {
+	tp := &transferProgress{
+		ctx:  ctx,
+		verb: verb,
+	}
+	if term.IsTerminal(int(os.Stderr.Fd())) {
+		tp.w = os.Stderr
+	}
+	return tp
+}
+
+// update is a callback for [zbstorerpc.NewProgressReceiver].
+func (tp *transferProgress) update(p zbstorerpc.ExportProgress) {
+	if p.Done() {
+		log.Debugf(tp.ctx, "%s %s done (%s)", tp.verb, p.StorePath, formatByteSize(p.Transferred))
+	}
+	if tp.w == nil {
+		return
+	}
+	now := time.Now()
+	if !p.Done() && tp.shown && now.Sub(tp.lastUpdate) < progressInterval {
+		return
+	}
+	tp.lastUpdate = now
+	tp.shown = true
+
+	line := fmt.Sprintf("%s store object %d", tp.verb, p.Index+1)
+	if p.Count >= 0 {
+		line += fmt.Sprintf("/%d", p.Count)
+	}
+	line += ": " + formatByteSize(p.Transferred)
+	if p.Total >= 0 {
+		line += " / " + formatByteSize(p.Total)
+	}
+	// Carriage return and erase to end of line.
+	fmt.Fprintf(tp.w, "\r\x1b[K%s", line)
+}
+
+// finish clears the status line, if one was shown.
+func (tp *transferProgress) finish() {
+	if tp.w != nil && tp.shown {
+		io.WriteString(tp.w, "\r\x1b[K")
+		tp.shown = false
+	}
+}
+
+// formatByteSize formats a number of bytes using binary prefixes.
+func formatByteSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit && exp < 4; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTP"[exp])
+}
@@ -0,0 +1,25 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import "testing"
+
+func TestFormatByteSize(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KiB"},
+		{1536, "1.5 KiB"},
+		{5 << 20, "5.0 MiB"},
+		{3 << 30, "3.0 GiB"},
+	}
+	for _, test := range tests {
+		if got := formatByteSize(test.n); got != test.want {
+			t.Errorf("formatByteSize(%d) = %q; want %q", test.n, got, test.want)
+		}
+	}
+}
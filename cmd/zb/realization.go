@@ -0,0 +1,394 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+
+	jsonv2 "github.com/go-json-experiment/json"
+	"github.com/go-json-experiment/json/jsontext"
+	"zb.256lights.llc/pkg/internal/backend"
+	"zb.256lights.llc/pkg/internal/jsonrpc"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+	"zb.256lights.llc/pkg/zbstore"
+	"zombiezen.com/go/log"
+	"zombiezen.com/go/nix"
+)
+
+type storeRealizationsCommand struct {
+	Arg        string `kong:"arg,name=drv|path|hash,completion-predictor=storepath,help=Store path (derivation or object) or derivation hash to look up."`
+	OutputName string `kong:"name=output,placeholder=name,help=Only show realizations of the given output."`
+	JSONFormat bool   `kong:"name=json,help=Print realizations as JSON."`
+}
+
+func (c *storeRealizationsCommand) Signature() string {
+	return `kong:"help=Show the realizations the store has recorded for a derivation or store object."`
+}
+
+func (c *storeRealizationsCommand) Run(ctx context.Context, g *globalConfig) error {
+	method, params, err := realizationsQuery(c.Arg, c.OutputName)
+	if err != nil {
+		return err
+	}
+
+	storeClient := g.storeClient(nil)
+	defer storeClient.Close()
+
+	handshake, err := zbstorerpc.Handshake(ctx, storeClient)
+	if err != nil {
+		return err
+	}
+	if err := handshake.Require(zbstorerpc.CapabilityRealizations, "realization queries"); err != nil {
+		return err
+	}
+	resp := new(zbstorerpc.RealizationsResponse)
+	if err := jsonrpc.Do(ctx, storeClient, method, resp, params); err != nil {
+		return fmt.Errorf("%s: %v", c.Arg, err)
+	}
+	if len(resp.Realizations) == 0 {
+		return fmt.Errorf("%s: no realizations", c.Arg)
+	}
+
+	var buf []byte
+	for i, m := range resp.Realizations {
+		if c.JSONFormat {
+			buf, err = jsonv2.Marshal(m)
+			if err != nil {
+				return err
+			}
+			buf = append(buf, '\n')
+		} else {
+			buf = buf[:0]
+			if i > 0 {
+				// Blank line between entries.
+				buf = append(buf, '\n')
+			}
+			buf = appendRealizationMapText(buf, m)
+		}
+		if _, err := os.Stdout.Write(buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// realizationsQuery returns the store method and parameters
+// that look up the realizations for arg,
+// which is either a store path or a derivation hash.
+// Derivation paths and hashes are looked up with [zbstorerpc.RealizationsMethod]
+// and other store paths are looked up with [zbstorerpc.RealizationsByPathMethod].
+func realizationsQuery(arg string, outputName string) (method string, params any, err error) {
+	if path, err := zbstore.ParsePath(arg); err == nil {
+		if !path.IsDerivation() {
+			if outputName != "" {
+				return "", nil, errors.New("--output cannot be used with a non-derivation store path")
+			}
+			return zbstorerpc.RealizationsByPathMethod, &zbstorerpc.RealizationsByPathRequest{Path: path}, nil
+		}
+		return zbstorerpc.RealizationsMethod, &zbstorerpc.RealizationsRequest{
+			DrvPath:    path,
+			OutputName: outputName,
+		}, nil
+	}
+	h, err := nix.ParseHash(arg)
+	if err != nil {
+		return "", nil, fmt.Errorf("%s is neither a store path nor a derivation hash", arg)
+	}
+	return zbstorerpc.RealizationsMethod, &zbstorerpc.RealizationsRequest{
+		DerivationHash: h,
+		OutputName:     outputName,
+	}, nil
+}
+
+// appendRealizationMapText appends a human-readable description of m to dst.
+// Each realization is listed on its own line,
+// followed by indented lines for its reference classes and signatures.
+func appendRealizationMapText(dst []byte, m *zbstore.RealizationMap) []byte {
+	for _, outputName := range slices.Sorted(maps.Keys(m.Realizations)) {
+		ref := zbstore.RealizationOutputReference{
+			DerivationHash: m.DerivationHash,
+			OutputName:     outputName,
+		}
+		for _, r := range m.Realizations[outputName] {
+			if r == nil {
+				continue
+			}
+			dst = fmt.Appendf(dst, "%v: %s\n", ref, r.OutputPath)
+			for _, rc := range r.ReferenceClasses {
+				dst = append(dst, "\treference "...)
+				dst = append(dst, rc.Path...)
+				if rc.Realization.Valid {
+					dst = fmt.Appendf(dst, " (%v)", rc.Realization.X)
+				}
+				dst = append(dst, '\n')
+			}
+			for _, sig := range r.Signatures {
+				dst = fmt.Appendf(dst, "\tsignature %s:%s\n", sig.PublicKey.Format, base64.StdEncoding.EncodeToString(sig.PublicKey.Data))
+			}
+		}
+	}
+	return dst
+}
+
+type storeSignCommand struct {
+	Args     []string `kong:"arg,optional,name=drv|path|hash,completion-predictor=storepath,help=Store paths (derivation or object) or derivation hashes whose realizations should be signed."`
+	All      bool     `kong:"help=Sign every realization in the store."`
+	KeyFiles []string `kong:"name=key,required,sep=none,placeholder=file,completion-predictor=file,help=Key file to sign realizations with (can be passed multiple times)"`
+}
+
+func (c *storeSignCommand) Signature() string {
+	return `kong:"help=Add signatures to realizations that the store has already recorded."`
+}
+
+func (c *storeSignCommand) Run(ctx context.Context, g *globalConfig) error {
+	if c.All == (len(c.Args) > 0) {
+		return errors.New("pass either --all or one or more arguments")
+	}
+	keyring, err := readKeyringFromFiles(c.KeyFiles)
+	if err != nil {
+		return err
+	}
+
+	storeClient := g.storeClient(nil)
+	defer storeClient.Close()
+
+	handshake, err := zbstorerpc.Handshake(ctx, storeClient)
+	if err != nil {
+		return err
+	}
+	if err := handshake.Require(zbstorerpc.CapabilityRealizations, "realization queries"); err != nil {
+		return err
+	}
+	if err := handshake.Require(zbstorerpc.CapabilityAddSignatures, "adding signatures"); err != nil {
+		return err
+	}
+
+	var realizations []*zbstore.RealizationMap
+	if c.All {
+		resp := new(zbstorerpc.RealizationsResponse)
+		err := jsonrpc.Do(ctx, storeClient, zbstorerpc.RealizationsMethod, resp, &zbstorerpc.RealizationsRequest{})
+		if err != nil {
+			return err
+		}
+		realizations = resp.Realizations
+	}
+	for _, arg := range c.Args {
+		method, params, err := realizationsQuery(arg, "")
+		if err != nil {
+			return err
+		}
+		resp := new(zbstorerpc.RealizationsResponse)
+		if err := jsonrpc.Do(ctx, storeClient, method, resp, params); err != nil {
+			return fmt.Errorf("%s: %v", arg, err)
+		}
+		if len(resp.Realizations) == 0 {
+			return fmt.Errorf("%s: no realizations", arg)
+		}
+		realizations = append(realizations, resp.Realizations...)
+	}
+
+	signed, err := signRealizations(keyring, realizations)
+	if err != nil {
+		return err
+	}
+	added := 0
+	for batch := range slices.Chunk(signed, signBatchSize) {
+		resp := new(zbstorerpc.AddSignaturesResponse)
+		err := jsonrpc.Do(ctx, storeClient, zbstorerpc.AddSignaturesMethod, resp, &zbstorerpc.AddSignaturesRequest{
+			Realizations: batch,
+		})
+		if err != nil {
+			return err
+		}
+		added += resp.Added
+	}
+	log.Infof(ctx, "Added %d signatures", added)
+	return nil
+}
+
+// signBatchSize is the maximum number of realization maps
+// that [*storeSignCommand] sends in a single request.
+const signBatchSize = 100
+
+// signRealizations signs each of the realizations with every key in keyring.
+// It returns maps that contain only the new signatures,
+// omitting realizations that are already signed by every key.
+func signRealizations(keyring *backend.Keyring, realizations []*zbstore.RealizationMap) ([]*zbstore.RealizationMap, error) {
+	var result []*zbstore.RealizationMap
+	for _, m := range realizations {
+		var signed *zbstore.RealizationMap
+		for ref, r := range m.All() {
+			sigs, err := keyring.Sign(ref, r)
+			if err != nil {
+				return nil, fmt.Errorf("sign %v (%s): %v", ref, r.OutputPath, err)
+			}
+			sigs = slices.DeleteFunc(sigs, func(sig *zbstore.RealizationSignature) bool {
+				return slices.ContainsFunc(r.Signatures, func(existing *zbstore.RealizationSignature) bool {
+					return existing.PublicKey.Equal(&sig.PublicKey)
+				})
+			})
+			if len(sigs) == 0 {
+				continue
+			}
+			if signed == nil {
+				signed = &zbstore.RealizationMap{
+					DerivationHash: m.DerivationHash,
+					Realizations:   make(map[string][]*zbstore.Realization),
+				}
+				result = append(result, signed)
+			}
+			signed.Realizations[ref.OutputName] = append(signed.Realizations[ref.OutputName], &zbstore.Realization{
+				OutputPath: r.OutputPath,
+				Signatures: sigs,
+			})
+		}
+	}
+	return result, nil
+}
+
+type storeTrustCommand struct {
+	storeDatabaseFlags `kong:"embed"`
+
+	Files      []string `kong:"arg,optional,name=file,type=existingfile,help=Trust files containing realizations as printed by zb store realizations --json. (Default: stdin)"`
+	PublicKeys []string `kong:"name=key,sep=none,placeholder=file,completion-predictor=file,help=Public key file (as printed by zb key show-public) to trust in addition to the configured trusted public keys (can be passed multiple times)"`
+}
+
+func (c *storeTrustCommand) Signature() string {
+	return `kong:"help=Record signed realizations from a trust file without building or downloading them."`
+}
+
+func (c *storeTrustCommand) Run(ctx context.Context, g *globalConfig) error {
+	trusted := slices.Clone(g.TrustedPublicKeys)
+	for _, path := range c.PublicKeys {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		k := new(zbstore.RealizationPublicKey)
+		if err := jsonv2.Unmarshal(data, k); err != nil {
+			return fmt.Errorf("read %s: %v", path, err)
+		}
+		trusted = append(trusted, k)
+	}
+	if len(trusted) == 0 {
+		return errors.New("no trusted public keys (pass --key or set trustedPublicKeys in the configuration)")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(c.DBPath), 0o755); err != nil {
+		return err
+	}
+	backendServer := backend.NewServer(g.Directory, c.DBPath, &backend.Options{
+		DatabasePoolSize:  1,
+		DisableSandbox:    true,
+		BuildLogRetention: -1,
+	})
+	defer backendServer.Close()
+
+	register := func(name string, r io.Reader) error {
+		dec := jsontext.NewDecoder(r)
+		n := 0
+		for {
+			m := new(zbstore.RealizationMap)
+			if err := jsonv2.UnmarshalDecode(dec, m); err == io.EOF {
+				break
+			} else if err != nil {
+				return fmt.Errorf("read %s: %v", name, err)
+			}
+			if err := backendServer.RegisterRealizations(ctx, m, trusted); err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+			for range m.All() {
+				n++
+			}
+		}
+		log.Infof(ctx, "Registered %d realizations from %s", n, name)
+		return nil
+	}
+	if len(c.Files) == 0 {
+		return register("stdin", os.Stdin)
+	}
+	for _, path := range c.Files {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		err = register(path, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type storePruneRealizationsCommand struct {
+	JSONFormat bool `kong:"name=json,help=Print the counts of removed rows as JSON."`
+}
+
+func (c *storePruneRealizationsCommand) Signature() string {
+	return `kong:"help=Delete realizations whose outputs are no longer in the store and merge duplicate realization data."`
+}
+
+func (c *storePruneRealizationsCommand) Run(ctx context.Context, g *globalConfig) error {
+	storeClient := g.storeClient(nil)
+	defer storeClient.Close()
+
+	handshake, err := zbstorerpc.Handshake(ctx, storeClient)
+	if err != nil {
+		return err
+	}
+	if err := handshake.Require(zbstorerpc.CapabilityPruneRealizations, "pruning realizations"); err != nil {
+		return err
+	}
+	resp := new(zbstorerpc.PruneRealizationsResponse)
+	err = jsonrpc.Do(ctx, storeClient, zbstorerpc.PruneRealizationsMethod, resp, &zbstorerpc.PruneRealizationsRequest{})
+	if err != nil {
+		return err
+	}
+	if c.JSONFormat {
+		buf, err := jsonv2.Marshal(resp)
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(append(buf, '\n'))
+		return err
+	}
+	log.Infof(ctx, "Deleted %d stale realizations", resp.DeletedRealizations)
+	log.Infof(ctx, "Merged %d duplicate reference classes and %d duplicate signatures", resp.MergedReferenceClasses, resp.MergedSignatures)
+	return nil
+}
+
+type storeClearSubstituterMissesCommand struct{}
+
+func (c *storeClearSubstituterMissesCommand) Signature() string {
+	return `kong:"help=Forget which derivations the substituter was found to not have, so the next build queries it again."`
+}
+
+func (c *storeClearSubstituterMissesCommand) Run(ctx context.Context, g *globalConfig) error {
+	storeClient := g.storeClient(nil)
+	defer storeClient.Close()
+
+	handshake, err := zbstorerpc.Handshake(ctx, storeClient)
+	if err != nil {
+		return err
+	}
+	if err := handshake.Require(zbstorerpc.CapabilityClearSubstituterMisses, "clearing substituter misses"); err != nil {
+		return err
+	}
+	resp := new(zbstorerpc.ClearSubstituterMissesResponse)
+	err = jsonrpc.Do(ctx, storeClient, zbstorerpc.ClearSubstituterMissesMethod, resp, &zbstorerpc.ClearSubstituterMissesRequest{})
+	if err != nil {
+		return err
+	}
+	log.Infof(ctx, "Cleared %d substituter misses", resp.Deleted)
+	return nil
+}
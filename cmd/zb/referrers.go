@@ -0,0 +1,70 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	jsonv2 "github.com/go-json-experiment/json"
+	"zb.256lights.llc/pkg/internal/jsonrpc"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+	"zb.256lights.llc/pkg/zbstore"
+)
+
+type storeReferrersCommand struct {
+	Path       string `kong:"arg,name=path,completion-predictor=storepath"`
+	Recursive  bool   `kong:"short=r,help=Also list objects that reference the path indirectly."`
+	JSONFormat bool   `kong:"name=json,help=Print referrers as JSON."`
+}
+
+func (c *storeReferrersCommand) Signature() string {
+	return `kong:"help=List the store objects and realizations that reference a store object."`
+}
+
+func (c *storeReferrersCommand) Run(ctx context.Context, g *globalConfig) error {
+	path, err := zbstore.ParsePath(c.Path)
+	if err != nil {
+		return err
+	}
+
+	storeClient := g.storeClient(nil)
+	defer storeClient.Close()
+
+	handshake, err := zbstorerpc.Handshake(ctx, storeClient)
+	if err != nil {
+		return err
+	}
+	if err := handshake.Require(zbstorerpc.CapabilityReferrers, "referrer queries"); err != nil {
+		return err
+	}
+	resp := new(zbstorerpc.ReferrersResponse)
+	err = jsonrpc.Do(ctx, storeClient, zbstorerpc.ReferrersMethod, resp, &zbstorerpc.ReferrersRequest{
+		Path:      path,
+		Recursive: c.Recursive,
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+
+	var buf []byte
+	if c.JSONFormat {
+		buf, err = jsonv2.Marshal(resp)
+		if err != nil {
+			return err
+		}
+		buf = append(buf, '\n')
+	} else {
+		for _, referrer := range resp.Referrers {
+			buf = append(buf, referrer...)
+			buf = append(buf, '\n')
+		}
+		for _, m := range resp.Realizations {
+			buf = appendRealizationMapText(buf, m)
+		}
+	}
+	_, err = os.Stdout.Write(buf)
+	return err
+}
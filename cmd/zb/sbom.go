@@ -0,0 +1,501 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	jsonv2 "github.com/go-json-experiment/json"
+	"github.com/go-json-experiment/json/jsontext"
+	"github.com/google/uuid"
+	"zb.256lights.llc/pkg/internal/frontend"
+	"zb.256lights.llc/pkg/internal/jsonrpc"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+	"zb.256lights.llc/pkg/sets"
+	"zb.256lights.llc/pkg/zbstore"
+	"zombiezen.com/go/log"
+	"zombiezen.com/go/nix"
+)
+
+// Derivation environment variables that are used for SBOM metadata by convention.
+// The fields of the derivation's meta table take precedence over these
+// for the derivations named on the command line.
+const (
+	sbomNameVar        = "pname"
+	sbomVersionVar     = "version"
+	sbomLicenseVar     = "license"
+	sbomDescriptionVar = "description"
+)
+
+type sbomCommand struct {
+	evalOptions `kong:"embed"`
+	Format      string `kong:"enum='spdx,cyclonedx',default=spdx,help=Format of the bill of materials: spdx or cyclonedx. (Default: ${default})"`
+	OutputPath  string `kong:"name=output,short=o,placeholder=file,help=File to write to. (Default: stdout)"`
+}
+
+func (c *sbomCommand) Signature() string {
+	return `kong:"help=Build derivations and print a software bill of materials for their runtime closure."`
+}
+
+func (c *sbomCommand) Run(ctx context.Context, g *globalConfig) error {
+	httpClient, httpCloser, err := g.newHTTPClient()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		httpClient.CloseIdleConnections()
+		if err := httpCloser.Close(); err != nil {
+			log.Warnf(ctx, "%v", err)
+		}
+	}()
+	di := new(zbstorerpc.DeferredImporter)
+	storeClient := g.storeClient(&zbstorerpc.CodecOptions{
+		Importer: di,
+	})
+	defer storeClient.Close()
+	eval, err := c.newEval(g, httpClient, storeClient, di)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := eval.Close(); err != nil {
+			log.Errorf(ctx, "%v", err)
+		}
+	}()
+
+	var results []any
+	if c.Expression {
+		results = make([]any, 1)
+		results[0], err = eval.Expression(ctx, c.Args[0])
+	} else {
+		results, err = eval.URLs(ctx, c.Args)
+	}
+	if err != nil {
+		return evaluationError(err)
+	}
+	if len(results) == 0 {
+		return fmt.Errorf("no evaluation results")
+	}
+	drvPaths := make([]zbstore.Path, 0, len(results))
+	metas := make(map[zbstore.Path]*frontend.Meta, len(results))
+	for _, result := range results {
+		drv, _ := result.(*frontend.Derivation)
+		if drv == nil {
+			return fmt.Errorf("%v is not a derivation", result)
+		}
+		drvPaths = append(drvPaths, drv.Path)
+		metas[drv.Path] = &drv.Meta
+	}
+
+	realizeResponse := new(zbstorerpc.RealizeResponse)
+	err = jsonrpc.Do(ctx, storeClient, zbstorerpc.RealizeMethod, realizeResponse, &zbstorerpc.RealizeRequest{
+		DrvPaths:   drvPaths,
+		KeepFailed: c.KeepFailed,
+		Reuse:      c.reusePolicy(g),
+	})
+	if err != nil {
+		return err
+	}
+	build, _, err := waitForBuild(ctx, storeClient, realizeResponse.BuildID)
+	if err != nil {
+		return err
+	}
+
+	bom, err := collectSBOM(ctx, storeClient, build, drvPaths, metas)
+	if err != nil {
+		return err
+	}
+	var data []byte
+	switch c.Format {
+	case "spdx":
+		data, err = bom.marshalSPDX()
+	case "cyclonedx":
+		data, err = bom.marshalCycloneDX()
+	default:
+		err = fmt.Errorf("unknown format %q", c.Format)
+	}
+	if err != nil {
+		return err
+	}
+
+	outputFile, err := openOutputFile(cmp.Or(c.OutputPath, "-"))
+	if err != nil {
+		return err
+	}
+	defer outputFile.Close()
+	if _, err := outputFile.Write(data); err != nil {
+		return err
+	}
+	return outputFile.Close()
+}
+
+// sbom is a format-independent software bill of materials.
+type sbom struct {
+	// roots is the list of store paths that the bill of materials describes.
+	roots []zbstore.Path
+	// components is the runtime closure of roots sorted by path.
+	components []*sbomComponent
+	created    time.Time
+}
+
+// sbomComponent is a single store object in an [sbom].
+type sbomComponent struct {
+	path    zbstore.Path
+	narHash nix.Hash
+	// name, version, license, description, and homepage are taken
+	// from the meta table or environment
+	// of the derivation that produced the store object, if known.
+	name        string
+	version     string
+	license     string
+	description string
+	homepage    string
+	// references is the set of other store objects that the store object references.
+	references []zbstore.Path
+}
+
+// collectSBOM gathers the runtime closure of the outputs of drvPaths in build.
+// metas maps derivation paths to the metadata from their meta tables, if known.
+func collectSBOM(ctx context.Context, storeClient jsonrpc.Handler, build *zbstorerpc.Build, drvPaths []zbstore.Path, metas map[zbstore.Path]*frontend.Meta) (*sbom, error) {
+	bom := &sbom{created: time.Now().UTC()}
+
+	// Map outputs back to the derivations that produced them.
+	producers := make(map[zbstore.Path]zbstore.Path)
+	for _, result := range build.Results {
+		for _, output := range result.Outputs {
+			if output.Path.Valid {
+				producers[output.Path.X] = result.DrvPath
+			}
+		}
+	}
+	for _, drvPath := range drvPaths {
+		result, err := build.ResultForPath(drvPath)
+		if err != nil {
+			return nil, err
+		}
+		for _, output := range result.Outputs {
+			if output.Path.Valid {
+				bom.roots = append(bom.roots, output.Path.X)
+			}
+		}
+	}
+
+	handshake, err := zbstorerpc.Handshake(ctx, storeClient)
+	if err != nil {
+		return nil, err
+	}
+	batch := handshake.Has(zbstorerpc.CapabilityBatch)
+	derivations := make(map[zbstore.Path]*zbstore.Derivation)
+	visited := sets.New(bom.roots...)
+	pending := slices.Clone(bom.roots)
+	for len(pending) > 0 {
+		infos, err := objectInfos(ctx, storeClient, batch, pending)
+		if err != nil {
+			return nil, err
+		}
+		var next []zbstore.Path
+		for i, path := range pending {
+			c := &sbomComponent{
+				path:    path,
+				narHash: infos[i].NARHash,
+				name:    path.Name(),
+			}
+			for _, ref := range infos[i].References {
+				if ref == path {
+					continue
+				}
+				c.references = append(c.references, ref)
+				if !visited.Has(ref) {
+					visited.Add(ref)
+					next = append(next, ref)
+				}
+			}
+			slices.Sort(c.references)
+			if drvPath, ok := producers[path]; ok {
+				drv := derivations[drvPath]
+				if drv == nil {
+					drv, err = readDerivationFile(drvPath)
+					if err != nil {
+						return nil, err
+					}
+					derivations[drvPath] = drv
+				}
+				c.name = cmp.Or(drv.Env[sbomNameVar], drv.Name)
+				c.version = drv.Env[sbomVersionVar]
+				c.license = drv.Env[sbomLicenseVar]
+				c.description = drv.Env[sbomDescriptionVar]
+				if meta := metas[drvPath]; meta != nil {
+					c.license = cmp.Or(meta.License, c.license)
+					c.description = cmp.Or(meta.Description, c.description)
+					c.homepage = meta.Homepage
+				}
+			}
+			bom.components = append(bom.components, c)
+		}
+		pending = next
+	}
+	slices.SortFunc(bom.components, func(c1, c2 *sbomComponent) int {
+		return cmp.Compare(c1.path, c2.path)
+	})
+	return bom, nil
+}
+
+func readDerivationFile(drvPath zbstore.Path) (*zbstore.Derivation, error) {
+	drvName, isDrv := drvPath.DerivationName()
+	if !isDrv {
+		return nil, fmt.Errorf("%s is not a derivation", drvPath)
+	}
+	drvBytes, err := os.ReadFile(string(drvPath))
+	if err != nil {
+		return nil, err
+	}
+	drv, err := zbstore.ParseDerivation(drvPath.Dir(), drvName, drvBytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %v", drvPath, err)
+	}
+	return drv, nil
+}
+
+// documentName returns a name for the bill of materials
+// based on its roots.
+func (bom *sbom) documentName() string {
+	names := make([]string, 0, len(bom.roots))
+	for _, root := range bom.roots {
+		names = append(names, root.Name())
+	}
+	return strings.Join(names, ", ")
+}
+
+// serialUUID returns a UUID that is stable for the same set of components.
+func (bom *sbom) serialUUID() uuid.UUID {
+	var data []byte
+	for _, root := range bom.roots {
+		data = append(data, root...)
+		data = append(data, 0)
+	}
+	for _, c := range bom.components {
+		data = append(data, c.path...)
+		data = append(data, 0)
+	}
+	return uuid.NewSHA1(uuid.NameSpaceURL, data)
+}
+
+func (bom *sbom) toolVersion() string {
+	return cmp.Or(zbVersion, "devel")
+}
+
+// marshalSPDX returns bom as an [SPDX 2.3] JSON document.
+//
+// [SPDX 2.3]: https://spdx.github.io/spdx-spec/v2.3/
+func (bom *sbom) marshalSPDX() ([]byte, error) {
+	type spdxChecksum struct {
+		Algorithm string `json:"algorithm"`
+		Value     string `json:"checksumValue"`
+	}
+	type spdxPackage struct {
+		ID               string         `json:"SPDXID"`
+		Name             string         `json:"name"`
+		Version          string         `json:"versionInfo,omitzero"`
+		FileName         string         `json:"packageFileName"`
+		Homepage         string         `json:"homepage,omitzero"`
+		Description      string         `json:"description,omitzero"`
+		DownloadLocation string         `json:"downloadLocation"`
+		FilesAnalyzed    bool           `json:"filesAnalyzed"`
+		LicenseConcluded string         `json:"licenseConcluded"`
+		LicenseDeclared  string         `json:"licenseDeclared"`
+		CopyrightText    string         `json:"copyrightText"`
+		Checksums        []spdxChecksum `json:"checksums,omitzero"`
+	}
+	type spdxRelationship struct {
+		Element        string `json:"spdxElementId"`
+		Type           string `json:"relationshipType"`
+		RelatedElement string `json:"relatedSpdxElement"`
+	}
+	type spdxCreationInfo struct {
+		Created  string   `json:"created"`
+		Creators []string `json:"creators"`
+	}
+	type spdxDocument struct {
+		Version           string             `json:"spdxVersion"`
+		DataLicense       string             `json:"dataLicense"`
+		ID                string             `json:"SPDXID"`
+		Name              string             `json:"name"`
+		DocumentNamespace string             `json:"documentNamespace"`
+		CreationInfo      spdxCreationInfo   `json:"creationInfo"`
+		Packages          []spdxPackage      `json:"packages"`
+		Relationships     []spdxRelationship `json:"relationships"`
+	}
+
+	const noAssertion = "NOASSERTION"
+	packageID := func(path zbstore.Path) string {
+		return "SPDXRef-Package-" + path.Digest()
+	}
+	doc := &spdxDocument{
+		Version:           "SPDX-2.3",
+		DataLicense:       "CC0-1.0",
+		ID:                "SPDXRef-DOCUMENT",
+		Name:              bom.documentName(),
+		DocumentNamespace: "https://zb.256lights.llc/spdx/" + bom.serialUUID().String(),
+		CreationInfo: spdxCreationInfo{
+			Created:  bom.created.Format(time.RFC3339),
+			Creators: []string{"Tool: zb-" + bom.toolVersion()},
+		},
+		Packages:      []spdxPackage{},
+		Relationships: []spdxRelationship{},
+	}
+	for _, root := range bom.roots {
+		doc.Relationships = append(doc.Relationships, spdxRelationship{
+			Element:        doc.ID,
+			Type:           "DESCRIBES",
+			RelatedElement: packageID(root),
+		})
+	}
+	for _, c := range bom.components {
+		pkg := spdxPackage{
+			ID:               packageID(c.path),
+			Name:             c.name,
+			Version:          c.version,
+			FileName:         string(c.path),
+			Homepage:         c.homepage,
+			Description:      c.description,
+			DownloadLocation: noAssertion,
+			LicenseConcluded: noAssertion,
+			LicenseDeclared:  cmp.Or(c.license, noAssertion),
+			CopyrightText:    noAssertion,
+		}
+		if c.narHash.Type() == nix.SHA256 {
+			pkg.Checksums = []spdxChecksum{{
+				Algorithm: "SHA256",
+				Value:     c.narHash.RawBase16(),
+			}}
+		}
+		doc.Packages = append(doc.Packages, pkg)
+		for _, ref := range c.references {
+			doc.Relationships = append(doc.Relationships, spdxRelationship{
+				Element:        pkg.ID,
+				Type:           "DEPENDS_ON",
+				RelatedElement: packageID(ref),
+			})
+		}
+	}
+	return marshalSBOM(doc)
+}
+
+// marshalCycloneDX returns bom as a [CycloneDX 1.5] JSON document.
+//
+// [CycloneDX 1.5]: https://cyclonedx.org/docs/1.5/json/
+func (bom *sbom) marshalCycloneDX() ([]byte, error) {
+	type cdxHash struct {
+		Algorithm string `json:"alg"`
+		Content   string `json:"content"`
+	}
+	type cdxLicense struct {
+		Expression string `json:"expression"`
+	}
+	type cdxExternalReference struct {
+		Type string `json:"type"`
+		URL  string `json:"url"`
+	}
+	type cdxComponent struct {
+		Type               string                 `json:"type"`
+		BOMRef             string                 `json:"bom-ref"`
+		Name               string                 `json:"name"`
+		Version            string                 `json:"version,omitzero"`
+		Description        string                 `json:"description,omitzero"`
+		Hashes             []cdxHash              `json:"hashes,omitzero"`
+		Licenses           []cdxLicense           `json:"licenses,omitzero"`
+		ExternalReferences []cdxExternalReference `json:"externalReferences,omitzero"`
+	}
+	type cdxDependency struct {
+		Ref       string   `json:"ref"`
+		DependsOn []string `json:"dependsOn"`
+	}
+	type cdxTools struct {
+		Components []cdxComponent `json:"components"`
+	}
+	type cdxMetadata struct {
+		Timestamp string   `json:"timestamp"`
+		Tools     cdxTools `json:"tools"`
+	}
+	type cdxDocument struct {
+		BOMFormat    string          `json:"bomFormat"`
+		SpecVersion  string          `json:"specVersion"`
+		SerialNumber string          `json:"serialNumber"`
+		Version      int             `json:"version"`
+		Metadata     cdxMetadata     `json:"metadata"`
+		Components   []cdxComponent  `json:"components"`
+		Dependencies []cdxDependency `json:"dependencies"`
+	}
+
+	roots := sets.New(bom.roots...)
+	doc := &cdxDocument{
+		BOMFormat:    "CycloneDX",
+		SpecVersion:  "1.5",
+		SerialNumber: bom.serialUUID().URN(),
+		Version:      1,
+		Metadata: cdxMetadata{
+			Timestamp: bom.created.Format(time.RFC3339),
+			Tools: cdxTools{
+				Components: []cdxComponent{{
+					Type:    "application",
+					BOMRef:  "zb",
+					Name:    "zb",
+					Version: bom.toolVersion(),
+				}},
+			},
+		},
+		Components:   []cdxComponent{},
+		Dependencies: []cdxDependency{},
+	}
+	for _, c := range bom.components {
+		comp := cdxComponent{
+			Type:        "library",
+			BOMRef:      string(c.path),
+			Name:        c.name,
+			Version:     c.version,
+			Description: c.description,
+		}
+		if roots.Has(c.path) {
+			comp.Type = "application"
+		}
+		if c.narHash.Type() == nix.SHA256 {
+			comp.Hashes = []cdxHash{{
+				Algorithm: "SHA-256",
+				Content:   c.narHash.RawBase16(),
+			}}
+		}
+		if c.license != "" {
+			comp.Licenses = []cdxLicense{{Expression: c.license}}
+		}
+		if c.homepage != "" {
+			comp.ExternalReferences = []cdxExternalReference{{Type: "website", URL: c.homepage}}
+		}
+		doc.Components = append(doc.Components, comp)
+
+		dep := cdxDependency{
+			Ref:       string(c.path),
+			DependsOn: make([]string, 0, len(c.references)),
+		}
+		for _, ref := range c.references {
+			dep.DependsOn = append(dep.DependsOn, string(ref))
+		}
+		doc.Dependencies = append(doc.Dependencies, dep)
+	}
+	return marshalSBOM(doc)
+}
+
+func marshalSBOM(doc any) ([]byte, error) {
+	data, err := jsonv2.Marshal(doc, jsontext.Multiline(true))
+	if err != nil {
+		return nil, err
+	}
+	data = append(data, '\n')
+	return data, nil
+}
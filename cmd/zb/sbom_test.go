@@ -0,0 +1,159 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	jsonv2 "github.com/go-json-experiment/json"
+	"github.com/google/go-cmp/cmp"
+	"zb.256lights.llc/pkg/zbstore"
+	"zombiezen.com/go/nix"
+)
+
+func testSBOM(t *testing.T) *sbom {
+	t.Helper()
+	narHash, err := nix.ParseHash("sha256-AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const (
+		appPath zbstore.Path = "/opt/zb/store/aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa-hello-1.0"
+		libPath zbstore.Path = "/opt/zb/store/bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb-libfoo-2.1"
+	)
+	return &sbom{
+		roots: []zbstore.Path{appPath},
+		components: []*sbomComponent{
+			{
+				path:       appPath,
+				narHash:    narHash,
+				name:       "hello",
+				version:    "1.0",
+				license:    "MIT",
+				homepage:   "https://example.com/hello",
+				references: []zbstore.Path{libPath},
+			},
+			{
+				path:    libPath,
+				narHash: narHash,
+				name:    "libfoo-2.1",
+			},
+		},
+		created: time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+func TestMarshalSPDX(t *testing.T) {
+	data, err := testSBOM(t).marshalSPDX()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		SPDXVersion string `json:"spdxVersion"`
+		Packages    []struct {
+			ID              string `json:"SPDXID"`
+			Name            string `json:"name"`
+			Version         string `json:"versionInfo"`
+			LicenseDeclared string `json:"licenseDeclared"`
+			Homepage        string `json:"homepage"`
+		} `json:"packages"`
+		Relationships []struct {
+			Element        string `json:"spdxElementId"`
+			Type           string `json:"relationshipType"`
+			RelatedElement string `json:"relatedSpdxElement"`
+		} `json:"relationships"`
+	}
+	if err := jsonv2.Unmarshal(data, &got, jsonv2.RejectUnknownMembers(false)); err != nil {
+		t.Fatalf("%v\n%s", err, data)
+	}
+	if got.SPDXVersion != "SPDX-2.3" {
+		t.Errorf("spdxVersion = %q; want %q", got.SPDXVersion, "SPDX-2.3")
+	}
+	wantPackages := []string{
+		"SPDXRef-Package-aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa hello 1.0 MIT https://example.com/hello",
+		"SPDXRef-Package-bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb libfoo-2.1  NOASSERTION ",
+	}
+	var gotPackages []string
+	for _, pkg := range got.Packages {
+		gotPackages = append(gotPackages, pkg.ID+" "+pkg.Name+" "+pkg.Version+" "+pkg.LicenseDeclared+" "+pkg.Homepage)
+	}
+	if diff := cmp.Diff(wantPackages, gotPackages); diff != "" {
+		t.Errorf("packages (-want +got):\n%s", diff)
+	}
+	wantRelationships := []string{
+		"SPDXRef-DOCUMENT DESCRIBES SPDXRef-Package-aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa",
+		"SPDXRef-Package-aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa DEPENDS_ON SPDXRef-Package-bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb",
+	}
+	var gotRelationships []string
+	for _, rel := range got.Relationships {
+		gotRelationships = append(gotRelationships, rel.Element+" "+rel.Type+" "+rel.RelatedElement)
+	}
+	if diff := cmp.Diff(wantRelationships, gotRelationships); diff != "" {
+		t.Errorf("relationships (-want +got):\n%s", diff)
+	}
+}
+
+func TestMarshalCycloneDX(t *testing.T) {
+	type component struct {
+		Type     string `json:"type"`
+		BOMRef   string `json:"bom-ref"`
+		Name     string `json:"name"`
+		Version  string `json:"version"`
+		Licenses []struct {
+			Expression string `json:"expression"`
+		} `json:"licenses"`
+	}
+	type dependency struct {
+		Ref       string   `json:"ref"`
+		DependsOn []string `json:"dependsOn"`
+	}
+	data, err := testSBOM(t).marshalCycloneDX()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		BOMFormat    string       `json:"bomFormat"`
+		Components   []component  `json:"components"`
+		Dependencies []dependency `json:"dependencies"`
+	}
+	if err := jsonv2.Unmarshal(data, &got, jsonv2.RejectUnknownMembers(false)); err != nil {
+		t.Fatalf("%v\n%s", err, data)
+	}
+	if got.BOMFormat != "CycloneDX" {
+		t.Errorf("bomFormat = %q; want %q", got.BOMFormat, "CycloneDX")
+	}
+	wantComponents := []component{
+		{
+			Type:    "application",
+			BOMRef:  "/opt/zb/store/aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa-hello-1.0",
+			Name:    "hello",
+			Version: "1.0",
+			Licenses: []struct {
+				Expression string `json:"expression"`
+			}{{Expression: "MIT"}},
+		},
+		{
+			Type:   "library",
+			BOMRef: "/opt/zb/store/bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb-libfoo-2.1",
+			Name:   "libfoo-2.1",
+		},
+	}
+	if diff := cmp.Diff(wantComponents, got.Components); diff != "" {
+		t.Errorf("components (-want +got):\n%s", diff)
+	}
+	wantDependencies := []dependency{
+		{
+			Ref:       "/opt/zb/store/aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa-hello-1.0",
+			DependsOn: []string{"/opt/zb/store/bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb-libfoo-2.1"},
+		},
+		{
+			Ref:       "/opt/zb/store/bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb-libfoo-2.1",
+			DependsOn: []string{},
+		},
+	}
+	if diff := cmp.Diff(wantDependencies, got.Dependencies); diff != "" {
+		t.Errorf("dependencies (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+
+	jsonv2 "github.com/go-json-experiment/json"
+	"github.com/go-json-experiment/json/jsontext"
+	"zb.256lights.llc/pkg/internal/frontend"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+	"zb.256lights.llc/pkg/zbstore"
+	"zombiezen.com/go/log"
+)
+
+type searchCommand struct {
+	Source     string `kong:"arg,placeholder=SOURCE,completion-predictor=installable,help=URL of a Lua file that evaluates to a table of derivations."`
+	Regex      string `kong:"arg,optional,placeholder=REGEX,help=Case-insensitive regular expression to match against attribute paths, names, and descriptions."`
+	Refresh    bool   `kong:"help=Evaluate the source even if it has been indexed before."`
+	JSONFormat bool   `kong:"name=json,help=Print the results as JSON."`
+
+	evalEnvOptions `kong:"embed"`
+}
+
+func (c *searchCommand) Signature() string {
+	return `kong:"help=Search the derivations in a package set."`
+}
+
+type searchResult struct {
+	AttrPath    string       `json:"attrPath"`
+	Name        string       `json:"name"`
+	Version     string       `json:"version,omitzero"`
+	Description string       `json:"description,omitzero"`
+	DrvPath     zbstore.Path `json:"drvPath"`
+}
+
+func (c *searchCommand) Run(ctx context.Context, g *globalConfig) error {
+	re, err := regexp.Compile("(?i)" + c.Regex)
+	if err != nil {
+		return err
+	}
+	source, err := absoluteURL(c.Source)
+	if err != nil {
+		return err
+	}
+
+	httpClient, httpCloser, err := g.newHTTPClient()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		httpClient.CloseIdleConnections()
+		if err := httpCloser.Close(); err != nil {
+			log.Warnf(ctx, "%v", err)
+		}
+	}()
+	di := new(zbstorerpc.DeferredImporter)
+	storeClient := g.storeClient(&zbstorerpc.CodecOptions{
+		Importer: di,
+	})
+	defer storeClient.Close()
+	eval, err := c.newEval(g, httpClient, storeClient, di)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := eval.Close(); err != nil {
+			log.Errorf(ctx, "%v", err)
+		}
+	}()
+
+	pkgs, err := eval.Packages(ctx, source, &frontend.PackagesOptions{
+		Refresh: c.Refresh,
+	})
+	if err != nil {
+		return evaluationError(err)
+	}
+	results := searchPackages(pkgs, re)
+
+	if c.JSONFormat {
+		data, err := jsonv2.Marshal(results, jsontext.Multiline(true))
+		if err != nil {
+			return err
+		}
+		data = append(data, '\n')
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	for _, r := range results {
+		line := r.AttrPath
+		if r.Version != "" {
+			line += " (" + r.Version + ")"
+		}
+		if r.Description != "" {
+			line += "\n  " + r.Description
+		}
+		if _, err := fmt.Println(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// searchPackages returns the packages whose attribute path, name, or description
+// match re.
+func searchPackages(pkgs []*frontend.Package, re *regexp.Regexp) []searchResult {
+	results := make([]searchResult, 0, len(pkgs))
+	for _, pkg := range pkgs {
+		if !re.MatchString(pkg.AttrPath) && !re.MatchString(pkg.Name) && !re.MatchString(pkg.Description) {
+			continue
+		}
+		results = append(results, searchResult{
+			AttrPath:    pkg.AttrPath,
+			Name:        pkg.Name,
+			Version:     pkg.Version,
+			Description: pkg.Description,
+			DrvPath:     pkg.DrvPath,
+		})
+	}
+	return results
+}
@@ -0,0 +1,40 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"regexp"
+	"slices"
+	"testing"
+
+	"zb.256lights.llc/pkg/internal/frontend"
+)
+
+func TestSearchPackages(t *testing.T) {
+	pkgs := []*frontend.Package{
+		{AttrPath: "hello", Name: "hello", Description: "Print a friendly greeting"},
+		{AttrPath: "tools/goodbye", Name: "goodbye", Description: "Print a farewell"},
+		{AttrPath: "gcc", Name: "gcc", Description: "GNU Compiler Collection"},
+	}
+	tests := []struct {
+		regex string
+		want  []string
+	}{
+		{regex: "", want: []string{"hello", "tools/goodbye", "gcc"}},
+		{regex: "^tools/", want: []string{"tools/goodbye"}},
+		{regex: "print", want: []string{"hello", "tools/goodbye"}},
+		{regex: "compiler", want: []string{"gcc"}},
+		{regex: "rust", want: []string{}},
+	}
+	for _, test := range tests {
+		results := searchPackages(pkgs, regexp.MustCompile("(?i)"+test.regex))
+		got := make([]string, 0, len(results))
+		for _, r := range results {
+			got = append(got, r.AttrPath)
+		}
+		if !slices.Equal(got, test.want) {
+			t.Errorf("searchPackages(pkgs, %q) = %q; want %q", test.regex, got, test.want)
+		}
+	}
+}
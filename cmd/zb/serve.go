@@ -16,10 +16,12 @@ import (
 	"path/filepath"
 	"runtime"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/coreos/go-systemd/v22/activation"
+	"github.com/coreos/go-systemd/v22/daemon"
 	"golang.org/x/sync/errgroup"
 	"zb.256lights.llc/pkg/bytebuffer"
 	"zb.256lights.llc/pkg/internal/backend"
@@ -39,27 +41,76 @@ import (
 
 const contentAddressTempFilePattern = "zb-ca-*"
 
+// contentAddressBufferCreator returns the [bytebuffer.Creator]
+// used for content address scans outside of the store server.
+func contentAddressBufferCreator() bytebuffer.Creator {
+	return bytebuffer.SpillCreator{
+		Threshold: contentAddressMemoryThreshold,
+		Spill:     bytebuffer.TempFileCreator{Pattern: contentAddressTempFilePattern},
+	}
+}
+
+// In-memory thresholds for temporary buffers.
+// Buffers that grow past their threshold are moved to temporary files.
+const (
+	// importMemoryThreshold applies to store objects received from clients.
+	// Most imports are small source files and derivations,
+	// but the occasional large import should not hold onto memory.
+	importMemoryThreshold = 4 << 20 // 4 MiB
+	// downloadMemoryThreshold applies to files downloaded during evaluation.
+	// Downloads are often source archives that are larger than this,
+	// so the threshold is kept small.
+	downloadMemoryThreshold = 256 << 10 // 256 KiB
+	// contentAddressMemoryThreshold applies to content address scans,
+	// which only buffer the regions of files that need rewriting.
+	contentAddressMemoryThreshold = 1 << 20 // 1 MiB
+)
+
 type serverConfig struct {
-	Download *storeConfig `json:"download"`
-	Upload   *storeConfig `json:"upload"`
+	Download *storeConfig  `json:"download"`
+	Upload   *storeConfig  `json:"upload"`
+	Network  networkConfig `json:"network,omitzero"`
 }
 
 type serveCommand struct {
 	storeDatabaseFlags `kong:"embed"`
 
 	BuildDir          string            `kong:"name=build-root,default=${temp_dir},help=Store build artifacts in this directory."`
+	BuildTmpfsSize    byteSize          `kong:"name=build-tmpfs-size,placeholder=size,help=Mount a tmpfs of at most this size (e.g. 8G) as the working directory of each build. Only supported on Linux when running as root."`
 	BuildUsersGroup   string            `kong:"default=${build_users_group},placeholder=${default_build_users_group},help=Run builds as users in the Unix group with the given name."`
+	BuildUIDRange     idRange           `kong:"name=build-uid-range,placeholder=first-last,help=Run builds as user IDs allocated from the given range when all users in the build users group are busy."`
 	LogDirectory      string            `kong:"default=${default_log_dir},help=Store logs in this directory."`
 	KeyFiles          []string          `kong:"name=signing-key,sep=none,placeholder=file,help=Key files for signing realizations (can be passed multiple times)"`
-	Sandbox           bool              `kong:"negatable,default=${supports_sandbox},help=Run builders in a restricted environment."`
+	BuilderID         string            `kong:"placeholder=uri,help=URI that identifies this server in build attestations."`
+	Sandbox           *bool             `kong:"negatable,help=Run builders in a restricted environment. (Default: ${supports_sandbox})"`
 	SandboxPaths      sandboxPathsFlags `kong:"embed"`
+	Determinism       determinismFlags  `kong:"embed"`
 	AllowKeepFailed   bool              `kong:"negatable,default=true,help=Allow user to skip cleanup of failed builds."`
+	Offline           bool              `kong:"help=Never use the network while building: skip substituters and uploads and fail derivations that fetch from the network."`
+	SystemFeatures    []string          `kong:"default=${default_system_features},placeholder=feature,help=Optional features this machine supports for derivations that set __requiredSystemFeatures. (Default: ${default})"`
 	CoresPerBuild     int               `kong:"default=${num_cpu},help=Hint to builders for number of concurrent jobs to run"`
+	MaxBuilds         int               `kong:"placeholder=n,help=Maximum number of builders to run at once, shared fairly among connections. (Default: unlimited)"`
+	MaxQueued         int               `kong:"name=max-queued-per-client,placeholder=n,help=Maximum number of derivations a single connection can have waiting to build. (Default: unlimited)"`
+	ClientWeights     map[string]int    `kong:"name=client-weight,placeholder=user=n,help=Give connections from a user (name or ID) n times the share of build slots of other connections (can be passed multiple times)."`
+	MaxClientPriority int               `kong:"placeholder=n,help=Highest build priority a connection can request. Higher priorities are lowered to this value. (Default: 0)"`
+	MaxOutputSize     byteSize          `kong:"placeholder=size,help=Fail builds whose outputs have a total NAR size larger than this (e.g. 100G). (Default: unlimited)"`
+	MaxOutputFiles    int64             `kong:"placeholder=n,help=Fail builds whose outputs contain more than this many files in total. (Default: unlimited)"`
+	PreBuildHook      string            `kong:"type=path,placeholder=program,help=Run a program before each build. A non-zero exit status fails the build."`
+	PostBuildHook     string            `kong:"type=path,placeholder=program,help=Run a program after each successful build."`
 	BuildLogRetention time.Duration     `kong:"default=168h,help=Delete finished build logs after this duration. (Default: ${default})"`
-	SystemdSocket     bool              `kong:"help=Use systemd socket activation"`
+	SystemdSocket     bool              `kong:"aliases=systemd,help=Use systemd socket activation"`
+	DrainTimeout      time.Duration     `kong:"default=1h,help=After a hangup signal: maximum time to wait for running builds before canceling them. (Default: ${default})"`
+	RestartOnHangup   bool              `kong:"name=restart-on-hangup,negatable,default=true,help=After draining on a hangup signal: restart the server and pass it the listening socket instead of exiting."`
+
+	SubstituterMissTTL time.Duration `kong:"name=substituter-miss-ttl,default=1h,help=After the substituter does not have the outputs of a derivation, skip asking it again for this long. Zero disables. (Default: ${default})"`
+
+	AuditLog           string   `kong:"type=path,placeholder=file,help=Append a JSON line to this file for each import or deletion or garbage collection or signing key use or configuration-affecting RPC."`
+	AuditLogMaxSize    byteSize `kong:"placeholder=size,help=Rotate the audit log when it would grow larger than this (e.g. 100M). (Default: unlimited)"`
+	AuditLogMaxBackups int      `kong:"default=5,placeholder=n,help=Number of rotated audit log files to keep. (Default: ${default})"`
 
 	WebListenAddress   string `kong:"name=ui,placeholder=[host]:port,help=Serve HTTP for web UI at the given address."`
 	AllowRemoteWeb     bool   `kong:"name=allow-remote-ui,help=Accept non-localhost connections for web UI."`
+	WebAPI             bool   `kong:"name=api,help=Serve read-only store API over HTTP and WebSocket under /api/ on the web UI address."`
 	TemplatesDirectory string `kong:"name=dev-templates,hidden,placeholder=dir,help=Directory to use for templates"`
 	StaticDirectory    string `kong:"name=dev-static,hidden,placeholder=dir,help=Directory to use for static assets"`
 }
@@ -72,11 +123,19 @@ func (c *serveCommand) Run(ctx context.Context, g *globalConfig) error {
 	if !g.Directory.IsNative() {
 		return fmt.Errorf("%s cannot be used on this system", g.Directory)
 	}
-	if c.Sandbox && !backend.CanSandbox() {
+	sandbox := backend.SystemSupportsSandbox()
+	if c.Sandbox != nil {
+		sandbox = *c.Sandbox
+	}
+	if sandbox && !backend.CanSandbox() {
 		if !backend.SystemSupportsSandbox() {
 			return fmt.Errorf("sandboxing requested but not supported on %v", system.Current())
 		}
-		return fmt.Errorf("sandboxing requested but unable to use (are you running with admin privileges?)")
+		if c.Sandbox != nil {
+			return fmt.Errorf("sandboxing requested but unable to use (are you running with admin privileges or are unprivileged user namespaces enabled?)")
+		}
+		log.Warnf(ctx, "Unable to sandbox builders without admin privileges (unprivileged user namespaces are not available). Builders will run without a sandbox.")
+		sandbox = false
 	}
 	keyring, err := readKeyringFromFiles(c.KeyFiles)
 	if err != nil {
@@ -86,14 +145,30 @@ func (c *serveCommand) Run(ctx context.Context, g *globalConfig) error {
 	if err != nil {
 		return err
 	}
+	var buildUserRange backend.BuildUserRange
+	if c.BuildUIDRange.count > 0 {
+		if storeDirGroupID == -1 {
+			return fmt.Errorf("--build-uid-range requires --build-users-group")
+		}
+		buildUserRange = backend.BuildUserRange{
+			Start: c.BuildUIDRange.first,
+			Count: c.BuildUIDRange.count,
+			GID:   storeDirGroupID,
+		}
+		log.Debugf(ctx, "Allocating build users from %v", buildUserRange)
+	}
 	if err := ensureStoreDirectory(string(g.Directory), storeDirGroupID); err != nil {
 		return err
 	}
 	if err := os.MkdirAll(filepath.Dir(g.StoreSocket), 0o755); err != nil {
 		return err
 	}
-	if err := os.MkdirAll(filepath.Dir(c.DBPath), 0o755); err != nil {
-		return err
+	if c.DBPath != backend.MemoryDatabase {
+		if err := os.MkdirAll(filepath.Dir(c.DBPath), 0o755); err != nil {
+			return err
+		}
+	} else {
+		log.Infof(ctx, "Keeping store database in memory: it will be discarded when the server exits")
 	}
 	// TODO(someday): Properly set permissions on the created database.
 
@@ -115,6 +190,15 @@ func (c *serveCommand) Run(ctx context.Context, g *globalConfig) error {
 	default:
 		return fmt.Errorf("unsupported type %q for upload store", g.Server.Upload.Type)
 	}
+	fetchCredentials, err := g.credentials()
+	if err != nil {
+		return err
+	}
+	fetchTransport, err := g.Server.Network.newTransport()
+	if err != nil {
+		return err
+	}
+	defer fetchTransport.CloseIdleConnections()
 
 	webHandler := new(webServer)
 	if c.TemplatesDirectory != "" {
@@ -136,29 +220,92 @@ func (c *serveCommand) Run(ctx context.Context, g *globalConfig) error {
 		webHandler.staticAssets = ui.StaticAssets()
 	}
 
+	var importBufferStats, contentAddressBufferStats bytebuffer.Stats
+	defer func() {
+		logBufferStats(ctx, "Import", importBufferStats.Snapshot())
+		logBufferStats(ctx, "Content address", contentAddressBufferStats.Snapshot())
+	}()
+
+	var auditLog *backend.AuditLog
+	if c.AuditLog != "" {
+		auditLog, err = backend.OpenAuditLog(c.AuditLog, &backend.AuditLogOptions{
+			MaxSize:    int64(c.AuditLogMaxSize),
+			MaxBackups: c.AuditLogMaxBackups,
+		})
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := auditLog.Close(); err != nil {
+				log.Errorf(ctx, "Closing audit log: %v", err)
+			}
+		}()
+	}
+
 	grp, grpCtx := errgroup.WithContext(ctx)
+	contentAddressBuffers := bytebuffer.SpillCreator{
+		Threshold: contentAddressMemoryThreshold,
+		Spill:     bytebuffer.TempFileCreator{Pattern: contentAddressTempFilePattern},
+		Stats:     &contentAddressBufferStats,
+	}
 	backendServer := backend.NewServer(g.Directory, c.DBPath, &backend.Options{
 		BuildDirectory:              c.BuildDir,
+		BuildTmpfsSize:              int64(c.BuildTmpfsSize),
 		LogDirectory:                c.LogDirectory,
-		ContentAddressBufferCreator: bytebuffer.TempFileCreator{Pattern: contentAddressTempFilePattern},
+		ContentAddressBufferCreator: contentAddressBuffers,
 		SandboxPaths:                c.SandboxPaths.toMap(),
-		DisableSandbox:              !c.Sandbox,
+		HashedSandboxPaths:          c.SandboxPaths.HashedPaths,
+		Determinism:                 c.Determinism.toOptions(),
+		DisableSandbox:              !sandbox,
 		BuildUsers:                  buildUsers,
+		BuildUserRange:              buildUserRange,
 		AllowKeepFailed:             c.AllowKeepFailed,
+		Offline:                     c.Offline,
+		SystemFeatures:              c.SystemFeatures,
 		CoresPerBuild:               c.CoresPerBuild,
+		MaxConcurrentBuilds:         c.MaxBuilds,
+		MaxQueuedPerClient:          c.MaxQueued,
+		MaxClientPriority:           c.MaxClientPriority,
+		MaxOutputSize:               int64(c.MaxOutputSize),
+		MaxOutputFiles:              c.MaxOutputFiles,
+		PreBuildHook:                c.PreBuildHook,
+		PostBuildHook:               c.PostBuildHook,
 		BuildLogRetention:           c.BuildLogRetention,
+		SubstituterMissTTL:          c.SubstituterMissTTL,
 		Keyring:                     keyring,
+		BuilderID:                   c.BuilderID,
 		Fallback:                    fallbackStore,
 		Upload:                      uploadHTTPStore,
+		FetchTransport:              fetchTransport,
+		FetchCredentials:            fetchCredentials,
+		Interceptors:                []jsonrpc.Interceptor{logRPC},
+		AuditLog:                    auditLog,
 	})
+	closeBackend := sync.OnceValue(backendServer.Close)
 	defer func() {
-		if err := backendServer.Close(); err != nil {
+		if err := closeBackend(); err != nil {
 			log.Errorf(ctx, "%v", err)
 		}
 	}()
 	webHandler.backend = backendServer
-
-	grp.Go(func() error { return c.listenRPC(grpCtx, backendServer, g) })
+	if c.WebAPI {
+		webHandler.api = newAPIServer(backendServer, webHandler.showLog)
+	}
+
+	importBuffers := bytebuffer.SpillCreator{
+		Threshold: importMemoryThreshold,
+		Spill: bytebuffer.TempFileCreator{
+			Pattern: "zb-serve-receive-*.nar",
+		},
+		Stats: &importBufferStats,
+	}
+	drain, stopDrainSignal := drainSignalChannel()
+	defer stopDrainSignal()
+	grp.Go(func() error { return c.listenRPC(grpCtx, drain, backendServer, importBuffers, g) })
+	grp.Go(func() error {
+		runWatchdog(grpCtx, backendServer)
+		return nil
+	})
 
 	if c.WebListenAddress != "" {
 		grp.Go(func() error {
@@ -203,19 +350,61 @@ func (c *serveCommand) Run(ctx context.Context, g *globalConfig) error {
 	}
 
 	waitError := grp.Wait()
+	if restart := (*restartError)(nil); errors.As(waitError, &restart) {
+		// The new process's launch check requires that this server has stopped.
+		if err := closeBackend(); err != nil {
+			return err
+		}
+		log.Infof(ctx, "Restarting...")
+		return restartServer(restart.listener)
+	}
 	if errors.Is(waitError, net.ErrClosed) {
 		waitError = nil
 	}
 	return waitError
 }
 
-func (c *serveCommand) listenRPC(ctx context.Context, server *backend.Server, g *globalConfig) error {
+// listenFDEnvVar is the name of the environment variable
+// that holds the file descriptor of the listening socket
+// passed to a restarted server.
+const listenFDEnvVar = "ZB_SERVE_LISTEN_FD"
+
+// restartError is returned from [*serveCommand.listenRPC]
+// when the server has drained and should be replaced by a new process.
+type restartError struct {
+	// listener is the listening socket to pass to the new process.
+	listener *os.File
+}
+
+func (e *restartError) Error() string {
+	return "server restart requested"
+}
+
+// listenRPC serves RPCs on the store socket until ctx.Done() is closed
+// or a value is received from drain.
+// When drained, listenRPC stops accepting new connections,
+// calls [*backend.Server.Drain],
+// and returns either a [*restartError] or [net.ErrClosed].
+func (c *serveCommand) listenRPC(ctx context.Context, drain <-chan struct{}, server *backend.Server, importBuffers bytebuffer.Creator, g *globalConfig) error {
+	clientWeights, err := clientWeightsByUID(c.ClientWeights)
+	if err != nil {
+		return err
+	}
 	if err := server.LaunchCheck(ctx); err != nil {
 		return err
 	}
 
 	var l net.Listener
-	if runtime.GOOS == "linux" && c.SystemdSocket {
+	removeSocket := false
+	switch {
+	case os.Getenv(listenFDEnvVar) != "":
+		var err error
+		l, err = inheritedListener()
+		if err != nil {
+			return err
+		}
+		removeSocket = !c.SystemdSocket
+	case runtime.GOOS == "linux" && c.SystemdSocket:
 		listeners, err := activation.Listeners()
 		if err != nil {
 			return err
@@ -224,24 +413,35 @@ func (c *serveCommand) listenRPC(ctx context.Context, server *backend.Server, g
 			return fmt.Errorf("systemd passed in %d sockets (want 1)", len(listeners))
 		}
 		l = listeners[0]
-	} else {
+	default:
 		if err := os.Remove(g.StoreSocket); err != nil && !errors.Is(err, os.ErrNotExist) {
 			return err
 		} else if err == nil {
 			log.Infof(ctx, "Cleaned up existing socket at %s", g.StoreSocket)
 		}
 
-		var err error
-		l, err = listenUnix(g.StoreSocket)
+		ul, err := listenUnix(g.StoreSocket)
 		if err != nil {
 			return err
 		}
-		defer func() {
+		// Removal is handled below so that the socket survives a restart.
+		ul.SetUnlinkOnClose(false)
+		l = ul
+		removeSocket = true
+	}
+
+	// stopped is closed after draining and handoff are set.
+	stopped := make(chan struct{})
+	var draining bool
+	var handoff *os.File
+	defer func() {
+		// Runs after all goroutines have stopped.
+		if removeSocket && handoff == nil {
 			if err := os.Remove(g.StoreSocket); err != nil && !errors.Is(err, os.ErrNotExist) {
 				log.Warnf(ctx, "Failed to clean up socket: %v", err)
 			}
-		}()
-	}
+		}
+	}()
 
 	openConns := make(sets.Set[net.Conn])
 	var openConnsMu sync.Mutex
@@ -251,28 +451,42 @@ func (c *serveCommand) listenRPC(ctx context.Context, server *backend.Server, g
 		cancel()
 		grp.Wait()
 	}()
+
+	// Once the context is Done or the server is drained, refuse new connections.
 	grp.Go(func() {
-		// Once the context is Done, refuse new connections and RPCs.
-		<-ctx.Done()
-		log.Infof(ctx, "Shutting down (signal received)...")
+		select {
+		case <-ctx.Done():
+			log.Infof(ctx, "Shutting down (signal received)...")
+			notifySystemd(ctx, daemon.SdNotifyStopping)
+		case <-drain:
+			log.Infof(ctx, "Draining (hangup signal received)...")
+			draining = true
+			if c.RestartOnHangup {
+				var err error
+				handoff, err = listenerFile(l)
+				if err != nil {
+					log.Errorf(ctx, "Unable to pass socket to new server (will exit after draining): %v", err)
+				}
+			}
+			if handoff != nil {
+				notifySystemd(ctx, daemon.SdNotifyReloading, "STATUS=Waiting for builds to finish before restarting")
+			} else {
+				notifySystemd(ctx, daemon.SdNotifyStopping, "STATUS=Waiting for builds to finish before exiting")
+			}
+		}
+		close(stopped)
 
 		if err := l.Close(); err != nil {
 			log.Errorf(ctx, "Closing Unix socket: %v", err)
 		}
-		openConnsMu.Lock()
-		for conn := range openConns.All() {
-			if err := closeRead(conn); err != nil {
-				log.Errorf(ctx, "Closing Unix socket: %v", err)
-			}
-		}
-		openConnsMu.Unlock()
 	})
 	log.Infof(ctx, "Listening on %s", g.StoreSocket)
+	notifySystemd(ctx, daemon.SdNotifyReady, "STATUS=Listening on "+g.StoreSocket)
 
-	for {
+	for connID := 1; ; connID++ {
 		conn, err := l.Accept()
 		if errors.Is(err, net.ErrClosed) {
-			return nil
+			break
 		}
 		if err != nil {
 			return err
@@ -282,15 +496,23 @@ func (c *serveCommand) listenRPC(ctx context.Context, server *backend.Server, g
 		openConnsMu.Unlock()
 
 		grp.Go(func() {
-			recv := server.NewNARReceiver(ctx, bytebuffer.TempFileCreator{
-				Pattern: "zb-serve-receive-*.nar",
-			})
+			client := &backend.ClientInfo{
+				Name: fmt.Sprintf("connection %d", connID),
+				Peer: peerCredentials(conn),
+			}
+			if client.Peer != nil {
+				client.Weight = clientWeights[client.Peer.UID]
+			}
+			clientCtx := backend.WithClient(ctx, client)
+			recv := server.NewNARReceiver(clientCtx, importBuffers)
 			defer recv.Cleanup(ctx)
 
 			codec := zbstorerpc.NewCodec(nopCloser{conn}, &zbstorerpc.CodecOptions{
 				Importer: zbstorerpc.NewReceiverImporter(recv),
+				Msgpack:  true,
 			})
-			jsonrpc.Serve(backend.WithExporter(ctx, codec), codec, server)
+			connCtx := backend.WithExporter(clientCtx, codec)
+			jsonrpc.Serve(connCtx, codec, server)
 			codec.Close()
 
 			openConnsMu.Lock()
@@ -302,6 +524,108 @@ func (c *serveCommand) listenRPC(ctx context.Context, server *backend.Server, g
 			}
 		})
 	}
+
+	<-stopped
+	if draining {
+		// Existing connections can continue to query the server
+		// and follow their builds while they finish.
+		drainCtx, cancelDrain := context.WithTimeout(ctx, c.DrainTimeout)
+		err := server.Drain(drainCtx)
+		cancelDrain()
+		if err != nil {
+			log.Warnf(ctx, "%v", err)
+		}
+	}
+
+	openConnsMu.Lock()
+	for conn := range openConns.All() {
+		if err := closeRead(conn); err != nil {
+			log.Errorf(ctx, "Closing Unix socket: %v", err)
+		}
+	}
+	openConnsMu.Unlock()
+
+	if handoff != nil {
+		return &restartError{listener: handoff}
+	}
+	if draining {
+		// Stop the rest of the server.
+		return net.ErrClosed
+	}
+	return nil
+}
+
+// notifySystemd sends the given state lines to the service manager
+// if the server was started by systemd with a notification socket.
+// Errors are logged.
+func notifySystemd(ctx context.Context, state ...string) {
+	if _, err := daemon.SdNotify(false, strings.Join(state, "\n")); err != nil {
+		log.Debugf(ctx, "Notify systemd: %v", err)
+	}
+}
+
+// runWatchdog sends keep-alive pings to systemd
+// while the server responds to requests
+// until ctx.Done() is closed.
+// If the systemd watchdog is not enabled for the process,
+// runWatchdog returns immediately.
+func runWatchdog(ctx context.Context, server *backend.Server) {
+	interval, err := daemon.SdWatchdogEnabled(false)
+	if err != nil {
+		log.Warnf(ctx, "Systemd watchdog: %v", err)
+		return
+	}
+	if interval == 0 {
+		return
+	}
+	log.Debugf(ctx, "Sending systemd watchdog pings every %v", interval/2)
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+	for {
+		// A request that makes it through the server's interceptors
+		// indicates the server is still healthy.
+		pingCtx, cancel := context.WithTimeout(ctx, interval/2)
+		err := jsonrpc.Do(pingCtx, server, zbstorerpc.NopMethod, nil, nil)
+		cancel()
+		if err != nil {
+			log.Warnf(ctx, "Skipping systemd watchdog ping: %v", err)
+		} else {
+			notifySystemd(ctx, daemon.SdNotifyWatchdog)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// inheritedListener returns the listener passed from a previous server process
+// in the environment variable named by [listenFDEnvVar].
+func inheritedListener() (net.Listener, error) {
+	s := os.Getenv(listenFDEnvVar)
+	os.Unsetenv(listenFDEnvVar)
+	fd, err := strconv.ParseUint(s, 10, 0)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", listenFDEnvVar, err)
+	}
+	f := os.NewFile(uintptr(fd), "listener")
+	defer f.Close()
+	l, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", listenFDEnvVar, err)
+	}
+	return l, nil
+}
+
+// listenerFile returns a duplicate of the listener's file descriptor.
+func listenerFile(l net.Listener) (*os.File, error) {
+	fl, ok := l.(interface{ File() (*os.File, error) })
+	if !ok {
+		return nil, fmt.Errorf("%T does not have a file descriptor", l)
+	}
+	return fl.File()
 }
 
 func ensureStoreDirectory(path string, gid int) error {
@@ -328,6 +652,30 @@ func ensureStoreDirectory(path string, gid int) error {
 	return nil
 }
 
+// clientWeightsByUID resolves the user names or IDs in the --client-weight flag
+// to a map of user ID to weight.
+func clientWeightsByUID(weights map[string]int) (map[int]int, error) {
+	result := make(map[int]int, len(weights))
+	for name, weight := range weights {
+		if weight <= 0 {
+			return nil, fmt.Errorf("--client-weight %s=%d: weight must be positive", name, weight)
+		}
+		uid, err := strconv.Atoi(name)
+		if err != nil {
+			u, err := user.Lookup(name)
+			if err != nil {
+				return nil, fmt.Errorf("--client-weight: %v", err)
+			}
+			uid, err = strconv.Atoi(u.Uid)
+			if err != nil {
+				return nil, fmt.Errorf("--client-weight: user %s: user id: %v", name, err)
+			}
+		}
+		result[uid] = weight
+	}
+	return result, nil
+}
+
 func buildUsersForGroup(ctx context.Context, name string) (gid int, buildUsers []backend.BuildUser, err error) {
 	if name == "" {
 		return -1, nil, nil
@@ -366,6 +714,7 @@ func buildUsersForGroup(ctx context.Context, name string) (gid int, buildUsers [
 type sandboxPathsFlags struct {
 	SandboxPaths       map[string]string `kong:"name=sandbox-path,type=pathmap,placeholder=path,help=Paths to allow in sandbox (can be passed multiple times)"`
 	ImplicitSystemDeps sets.Set[string]  `kong:"name=implicit-system-dep,placeholder=path,help=Paths to always mount in sandbox (can be passed multiple times)"`
+	HashedPaths        []string          `kong:"name=hashed-sandbox-path,type=path,sep=none,placeholder=path,help=Paths (or directories of paths) that derivations may mount in sandbox by declaring their NAR hash in __hashedSystemDeps (can be passed multiple times)"`
 }
 
 func (flags *sandboxPathsFlags) toMap() map[string]backend.SandboxPath {
@@ -381,6 +730,35 @@ func (flags *sandboxPathsFlags) toMap() map[string]backend.SandboxPath {
 	return result
 }
 
+type determinismFlags struct {
+	NormalizeEnvironment bool   `kong:"name=normalize-build-env,help=Set TZ, LANG, and SOURCE_DATE_EPOCH to fixed values for builders."`
+	SourceDateEpoch      int64  `kong:"default=315532800,placeholder=seconds,help=Unix timestamp to use for SOURCE_DATE_EPOCH and builder clocks. (Default: ${default})"`
+	FakeTimeLibrary      string `kong:"name=faketime-library,type=path,placeholder=path,help=Path to libfaketime shared library used to start builder clocks at SOURCE_DATE_EPOCH."`
+	EnforceUmask         bool   `kong:"name=build-umask,help=Start builders with a umask of 022."`
+}
+
+func (flags *determinismFlags) toOptions() backend.DeterminismOptions {
+	return backend.DeterminismOptions{
+		NormalizeEnvironment: flags.NormalizeEnvironment,
+		SourceDateEpoch:      time.Unix(flags.SourceDateEpoch, 0),
+		FakeTimeLibrary:      flags.FakeTimeLibrary,
+		EnforceUmask:         flags.EnforceUmask,
+	}
+}
+
+// logRPC is a [jsonrpc.Interceptor] that logs each request at debug level.
+func logRPC(ctx context.Context, req *jsonrpc.Request, next jsonrpc.Handler) (*jsonrpc.Response, error) {
+	client := backend.ClientFromContext(ctx)
+	start := time.Now()
+	resp, err := next.JSONRPC(ctx, req)
+	if err != nil {
+		log.Debugf(ctx, "%v: %s failed after %v: %v", client, req.Method, time.Since(start), err)
+	} else {
+		log.Debugf(ctx, "%v: %s finished in %v", client, req.Method, time.Since(start))
+	}
+	return resp, err
+}
+
 func listenUnix(path string) (*net.UnixListener, error) {
 	laddr := &net.UnixAddr{
 		Net:  "unix",
@@ -415,3 +793,12 @@ type nopCloser struct {
 func (nopCloser) Close() error {
 	return nil
 }
+
+// logBufferStats logs a summary of the buffers created by a [bytebuffer.SpillCreator].
+func logBufferStats(ctx context.Context, use string, stats bytebuffer.StatsSnapshot) {
+	if stats.Buffers == 0 {
+		return
+	}
+	log.Debugf(ctx, "%s buffers: %d created, %d spilled to disk (%s copied), peak memory %s",
+		use, stats.Buffers, stats.Spills, formatByteSize(stats.BytesSpilled), formatByteSize(stats.PeakMemory))
+}
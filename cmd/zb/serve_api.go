@@ -0,0 +1,225 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	jsonv2 "github.com/go-json-experiment/json"
+	"github.com/go-json-experiment/json/jsontext"
+	"github.com/gorilla/handlers"
+	"golang.org/x/net/websocket"
+	"zb.256lights.llc/pkg/internal/backend"
+	"zb.256lights.llc/pkg/internal/jsonrpc"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+	"zb.256lights.llc/pkg/zbstore"
+	"zombiezen.com/go/log"
+)
+
+// apiServer is an HTTP gateway to the store API.
+// It serves JSON-RPC over WebSocket at /api/rpc
+// and a small REST facade under /api/builds/
+// for clients like browser dashboards that cannot speak the store socket protocol.
+// Only methods that satisfy [zbstorerpc.IsReadOnlyMethod] are available.
+type apiServer struct {
+	store jsonrpc.Handler
+	mux   *http.ServeMux
+}
+
+// newAPIServer returns a new [apiServer] that sends requests to store.
+// If showLog is not nil, then it is used to serve build logs as text.
+// It should use the same query parameters as the REST facade.
+func newAPIServer(store jsonrpc.Handler, showLog http.HandlerFunc) *apiServer {
+	mux := http.NewServeMux()
+	srv := &apiServer{store: store, mux: mux}
+	mux.Handle("/api/rpc", websocket.Server{
+		Handshake: checkWebSocketOrigin,
+		Handler:   srv.serveRPC,
+	})
+	mux.Handle("/api/builds/{id}", handlers.MethodHandler{
+		http.MethodGet:  http.HandlerFunc(srv.getBuild),
+		http.MethodHead: http.HandlerFunc(srv.getBuild),
+	})
+	mux.Handle("/api/builds/{id}/result", handlers.MethodHandler{
+		http.MethodGet:  http.HandlerFunc(srv.getBuildResult),
+		http.MethodHead: http.HandlerFunc(srv.getBuildResult),
+	})
+	if showLog != nil {
+		mux.Handle("/api/builds/{id}/log", handlers.MethodHandler{
+			http.MethodGet:  showLog,
+			http.MethodHead: showLog,
+		})
+	}
+	return srv
+}
+
+func (srv *apiServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	srv.mux.ServeHTTP(w, r)
+}
+
+// serveRPC serves JSON-RPC requests on a WebSocket connection.
+// Each WebSocket message holds a single JSON-RPC message or batch.
+func (srv *apiServer) serveRPC(conn *websocket.Conn) {
+	r := conn.Request()
+	ctx := r.Context()
+	// Clear any deadlines that the HTTP server set for the initial request.
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		log.Debugf(ctx, "WebSocket: %v", err)
+	}
+	ctx = backend.WithClient(ctx, &backend.ClientInfo{
+		Name: "websocket " + r.RemoteAddr,
+	})
+	err := jsonrpc.Serve(ctx, webSocketCodec{conn}, readOnlyStoreHandler{srv.store})
+	log.Debugf(ctx, "WebSocket connection from %s closed: %v", r.RemoteAddr, err)
+}
+
+func (srv *apiServer) getBuild(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	build := new(zbstorerpc.Build)
+	err := jsonrpc.Do(ctx, srv.store, zbstorerpc.GetBuildMethod, build, &zbstorerpc.GetBuildRequest{
+		BuildID: r.PathValue("id"),
+	})
+	if err != nil {
+		writeAPIError(ctx, w, err)
+		return
+	}
+	if build.Status == zbstorerpc.BuildUnknown {
+		writeAPIError(ctx, w, errAPINotFound)
+		return
+	}
+	writeAPIResponse(ctx, w, r, build)
+}
+
+func (srv *apiServer) getBuildResult(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	drvPath, err := zbstore.ParsePath(r.FormValue("drvPath"))
+	if err != nil {
+		writeAPIError(ctx, w, jsonrpc.Error(jsonrpc.InvalidParams, fmt.Errorf("drvPath: %v", err)))
+		return
+	}
+	result := new(zbstorerpc.BuildResult)
+	err = jsonrpc.Do(ctx, srv.store, zbstorerpc.GetBuildResultMethod, result, &zbstorerpc.GetBuildResultRequest{
+		BuildID: r.PathValue("id"),
+		DrvPath: drvPath,
+	})
+	if err != nil {
+		writeAPIError(ctx, w, err)
+		return
+	}
+	if result.Status == zbstorerpc.BuildUnknown {
+		writeAPIError(ctx, w, errAPINotFound)
+		return
+	}
+	writeAPIResponse(ctx, w, r, result)
+}
+
+var errAPINotFound = errors.New("not found")
+
+// writeAPIResponse writes v as a JSON response body.
+func writeAPIResponse(ctx context.Context, w http.ResponseWriter, r *http.Request, v any) {
+	data, err := jsonv2.Marshal(v)
+	if err != nil {
+		writeAPIError(ctx, w, err)
+		return
+	}
+	data = append(data, '\n')
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		w.Write(data)
+	}
+}
+
+// writeAPIError writes err as a JSON object with an "error" member
+// and an HTTP status code that corresponds to the error.
+func writeAPIError(ctx context.Context, w http.ResponseWriter, err error) {
+	statusCode := http.StatusInternalServerError
+	message := "internal server error"
+	if errors.Is(err, errAPINotFound) {
+		statusCode = http.StatusNotFound
+		message = err.Error()
+	} else if code, ok := jsonrpc.CodeFromError(err); ok && code == jsonrpc.InvalidParams {
+		statusCode = http.StatusBadRequest
+		message = err.Error()
+	} else {
+		log.Errorf(ctx, "%v", err)
+	}
+	data, _ := jsonv2.Marshal(map[string]string{"error": message})
+	data = append(data, '\n')
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(data)
+}
+
+// checkWebSocketOrigin rejects WebSocket connections from web pages
+// that are not served by the same host.
+func checkWebSocketOrigin(config *websocket.Config, r *http.Request) error {
+	u, err := checkOrigin(r)
+	if err != nil {
+		return err
+	}
+	if u != nil {
+		config.Origin = u
+	}
+	return nil
+}
+
+// checkOrigin returns an error if r was sent by a web page
+// that is not served by the same host.
+// Requests without an Origin header (i.e. requests not made by a browser)
+// are permitted.
+// On success, checkOrigin returns the parsed Origin header
+// or nil if the request did not have one.
+func checkOrigin(r *http.Request) (*url.URL, error) {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return nil, fmt.Errorf("origin: %v", err)
+	}
+	if u.Host != r.Host {
+		return nil, fmt.Errorf("origin %s not permitted", origin)
+	}
+	return u, nil
+}
+
+// readOnlyStoreHandler is a [jsonrpc.Handler]
+// that only forwards requests for methods that satisfy [zbstorerpc.IsReadOnlyMethod].
+type readOnlyStoreHandler struct {
+	store jsonrpc.Handler
+}
+
+func (h readOnlyStoreHandler) JSONRPC(ctx context.Context, req *jsonrpc.Request) (*jsonrpc.Response, error) {
+	if !zbstorerpc.IsReadOnlyMethod(req.Method) {
+		return nil, jsonrpc.Error(jsonrpc.MethodNotFound, fmt.Errorf("method %s not available over HTTP", req.Method))
+	}
+	return h.store.JSONRPC(ctx, req)
+}
+
+// webSocketCodec is a [jsonrpc.ServerCodec]
+// that sends each JSON-RPC message as a WebSocket text message.
+type webSocketCodec struct {
+	conn *websocket.Conn
+}
+
+func (c webSocketCodec) ReadRequest() (jsontext.Value, error) {
+	var msg []byte
+	if err := websocket.Message.Receive(c.conn, &msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (c webSocketCodec) WriteResponse(response jsontext.Value) error {
+	return websocket.Message.Send(c.conn, string(response))
+}
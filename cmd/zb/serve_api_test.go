@@ -0,0 +1,129 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	jsonv2 "github.com/go-json-experiment/json"
+	"golang.org/x/net/websocket"
+	"zb.256lights.llc/pkg/internal/jsonrpc"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+)
+
+func TestAPIServer(t *testing.T) {
+	const buildID = "8d5a8b54-48d1-4b9b-8f5c-2b9f3c6f3a10"
+	store := jsonrpc.ServeMux{
+		zbstorerpc.GetBuildMethod: jsonrpc.HandlerFunc(func(ctx context.Context, req *jsonrpc.Request) (*jsonrpc.Response, error) {
+			var args zbstorerpc.GetBuildRequest
+			if err := jsonv2.Unmarshal(req.Params, &args); err != nil {
+				return nil, jsonrpc.Error(jsonrpc.InvalidParams, err)
+			}
+			build := &zbstorerpc.Build{
+				ID:      args.BuildID,
+				Status:  zbstorerpc.BuildUnknown,
+				Results: []*zbstorerpc.BuildResult{},
+			}
+			if args.BuildID == buildID {
+				build.Status = zbstorerpc.BuildSuccess
+			}
+			return marshalTestResponse(build)
+		}),
+		zbstorerpc.RealizeMethod: jsonrpc.HandlerFunc(func(ctx context.Context, req *jsonrpc.Request) (*jsonrpc.Response, error) {
+			t.Error("realize called through API gateway")
+			return nil, nil
+		}),
+	}
+	srv := httptest.NewServer(newAPIServer(store, nil))
+	defer srv.Close()
+
+	t.Run("GetBuild", func(t *testing.T) {
+		resp, err := http.Get(srv.URL + "/api/builds/" + buildID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("status = %s; want 200", resp.Status)
+		}
+		got := new(zbstorerpc.Build)
+		if err := jsonv2.UnmarshalRead(resp.Body, got); err != nil {
+			t.Fatal(err)
+		}
+		if got.ID != buildID || got.Status != zbstorerpc.BuildSuccess {
+			t.Errorf("build = {ID: %q, Status: %q}; want {ID: %q, Status: %q}", got.ID, got.Status, buildID, zbstorerpc.BuildSuccess)
+		}
+	})
+
+	t.Run("GetUnknownBuild", func(t *testing.T) {
+		resp, err := http.Get(srv.URL + "/api/builds/bork")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("status = %s; want 404", resp.Status)
+		}
+	})
+
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http") + "/api/rpc"
+
+	t.Run("RPC", func(t *testing.T) {
+		conn, err := websocket.Dial(wsURL, "", srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		requests := []string{
+			`{"jsonrpc": "2.0", "method": "zb.getBuild", "params": {"buildID": "` + buildID + `"}, "id": 1}`,
+			`{"jsonrpc": "2.0", "method": "zb.realize", "params": {}, "id": 2}`,
+		}
+		wantCodes := []jsonrpc.ErrorCode{0, jsonrpc.MethodNotFound}
+		for i, req := range requests {
+			if err := websocket.Message.Send(conn, req); err != nil {
+				t.Fatal(err)
+			}
+			var msg []byte
+			if err := websocket.Message.Receive(conn, &msg); err != nil {
+				t.Fatal(err)
+			}
+			var resp struct {
+				Error *struct {
+					Code jsonrpc.ErrorCode `json:"code"`
+				} `json:"error"`
+			}
+			if err := jsonv2.Unmarshal(msg, &resp); err != nil {
+				t.Fatal(err)
+			}
+			var gotCode jsonrpc.ErrorCode
+			if resp.Error != nil {
+				gotCode = resp.Error.Code
+			}
+			if gotCode != wantCodes[i] {
+				t.Errorf("response to %s = %s; want error code %d", req, msg, wantCodes[i])
+			}
+		}
+	})
+
+	t.Run("CrossOrigin", func(t *testing.T) {
+		conn, err := websocket.Dial(wsURL, "", "https://example.com")
+		if err == nil {
+			conn.Close()
+			t.Error("Dial from another origin succeeded")
+		}
+	})
+}
+
+func marshalTestResponse(v any) (*jsonrpc.Response, error) {
+	data, err := jsonv2.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return &jsonrpc.Response{Result: data}, nil
+}
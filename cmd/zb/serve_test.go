@@ -0,0 +1,31 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestClientWeightsByUID(t *testing.T) {
+	got, err := clientWeightsByUID(map[string]int{"1000": 2, "1001": 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[int]int{1000: 2, 1001: 3}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("clientWeightsByUID(...) (-want +got):\n%s", diff)
+	}
+
+	for _, weights := range []map[string]int{
+		{"1000": 0},
+		{"1000": -1},
+		{"no-such-user-for-zb-tests": 1},
+	} {
+		if got, err := clientWeightsByUID(weights); err == nil {
+			t.Errorf("clientWeightsByUID(%v) = %v, <nil>; want error", weights, got)
+		}
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"io/fs"
 	"net/http"
 	"net/url"
+	"slices"
 	"strconv"
 	"strings"
 	"unicode/utf8"
@@ -21,6 +22,7 @@ import (
 	"zb.256lights.llc/pkg/internal/xhttp"
 	"zb.256lights.llc/pkg/internal/xnet"
 	"zb.256lights.llc/pkg/internal/zbstorerpc"
+	"zb.256lights.llc/pkg/sets"
 	"zb.256lights.llc/pkg/zbstore"
 	"zombiezen.com/go/bass/action"
 	"zombiezen.com/go/log"
@@ -30,6 +32,8 @@ type webServer struct {
 	backend       *backend.Server
 	templateFiles fs.FS
 	staticAssets  fs.FS
+	// api is the store API gateway or nil if the gateway is disabled.
+	api *apiServer
 }
 
 func (srv *webServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -54,6 +58,9 @@ func (srv *webServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		http.MethodGet:  cfg.NewHandler(srv.showBuild),
 		http.MethodHead: cfg.NewHandler(srv.showBuild),
 	})
+	mux.Handle("/build/{id}/cancel", handlers.MethodHandler{
+		http.MethodPost: cfg.NewHandler(srv.cancelBuild),
+	})
 
 	mux.Handle("/build/{id}/result", handlers.MethodHandler{
 		http.MethodGet:  cfg.NewHandler(srv.showResult),
@@ -64,12 +71,17 @@ func (srv *webServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		http.MethodHead: http.HandlerFunc(srv.showLog),
 	})
 
+	if srv.api != nil {
+		mux.Handle("/api/", srv.api)
+	}
+
 	mux.ServeHTTP(w, r)
 }
 
 func (srv *webServer) home(ctx context.Context, r *http.Request) (*action.Response, error) {
 	var data struct {
 		Query        string
+		ActiveBuilds []*zbstorerpc.Build
 		RecentBuilds []*zbstorerpc.Build
 	}
 	buildIDs, err := srv.backend.RecentBuildIDs(ctx, 25)
@@ -100,6 +112,11 @@ func (srv *webServer) home(ctx context.Context, r *http.Request) (*action.Respon
 	if err := grp.Wait(); err != nil {
 		return nil, err
 	}
+	for _, b := range data.RecentBuilds {
+		if b.Status == zbstorerpc.BuildActive {
+			data.ActiveBuilds = append(data.ActiveBuilds, b)
+		}
+	}
 
 	return &action.Response{
 		HTMLTemplate: "index.html",
@@ -117,28 +134,79 @@ func (srv *webServer) listBuilds(ctx context.Context, r *http.Request) (*action.
 }
 
 func (srv *webServer) showBuild(ctx context.Context, r *http.Request) (*action.Response, error) {
-	data := new(zbstorerpc.Build)
-	data.ID = r.PathValue("id")
-	err := jsonrpc.Do(ctx, srv.backend, zbstorerpc.GetBuildMethod, data, &zbstorerpc.GetBuildRequest{
-		BuildID: data.ID,
+	build := new(zbstorerpc.Build)
+	build.ID = r.PathValue("id")
+	err := jsonrpc.Do(ctx, srv.backend, zbstorerpc.GetBuildMethod, build, &zbstorerpc.GetBuildRequest{
+		BuildID: build.ID,
 	})
 	switch {
 	case err != nil:
 		return nil, err
-	case data.Status == zbstorerpc.BuildUnknown:
+	case build.Status == zbstorerpc.BuildUnknown:
 		return &action.Response{
 			StatusCode:   http.StatusNotFound,
 			HTMLTemplate: "build404.html",
-			TemplateData: data,
+			TemplateData: build,
 		}, nil
 	default:
 		return &action.Response{
 			HTMLTemplate: "build.html",
-			TemplateData: data,
+			TemplateData: &buildPageData{
+				Build:        build,
+				Dependencies: buildDependencies(ctx, build),
+			},
 		}, nil
 	}
 }
 
+type buildPageData struct {
+	*zbstorerpc.Build
+	// Dependencies maps each derivation in the build
+	// to the derivations in the same build that it uses as inputs.
+	Dependencies map[zbstore.Path][]zbstore.Path
+}
+
+// buildDependencies returns the edges of the derivation graph for a build.
+// Derivations that cannot be read are logged and treated as having no dependencies.
+func buildDependencies(ctx context.Context, build *zbstorerpc.Build) map[zbstore.Path][]zbstore.Path {
+	inBuild := make(sets.Set[zbstore.Path], len(build.Results))
+	for _, result := range build.Results {
+		inBuild.Add(result.DrvPath)
+	}
+	deps := make(map[zbstore.Path][]zbstore.Path)
+	for _, result := range build.Results {
+		drv, err := readDerivationFile(result.DrvPath)
+		if err != nil {
+			log.Debugf(ctx, "Read dependencies for build %s: %v", build.ID, err)
+			continue
+		}
+		for input := range drv.InputDerivations {
+			if inBuild.Has(input) {
+				deps[result.DrvPath] = append(deps[result.DrvPath], input)
+			}
+		}
+		slices.Sort(deps[result.DrvPath])
+	}
+	return deps
+}
+
+// cancelBuild cancels an active build and redirects back to the build's page.
+func (srv *webServer) cancelBuild(ctx context.Context, r *http.Request) (*action.Response, error) {
+	if _, err := checkOrigin(r); err != nil {
+		return nil, action.WithStatusCode(http.StatusForbidden, err)
+	}
+	buildID := r.PathValue("id")
+	err := jsonrpc.Notify(ctx, srv.backend, zbstorerpc.CancelBuildMethod, &zbstorerpc.CancelBuildNotification{
+		BuildID: buildID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &action.Response{
+		SeeOther: "/build/" + url.PathEscape(buildID) + "/",
+	}, nil
+}
+
 func (srv *webServer) showResult(ctx context.Context, r *http.Request) (*action.Response, error) {
 	var data struct {
 		BuildID string
@@ -0,0 +1,82 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"zb.256lights.llc/pkg/internal/backendtest"
+	"zb.256lights.llc/pkg/internal/testcontext"
+	"zb.256lights.llc/pkg/internal/ui"
+)
+
+func TestWebServer(t *testing.T) {
+	ctx := testcontext.New(t)
+	storeDir := backendtest.NewStoreDirectory(t)
+	backendServer, _, err := backendtest.NewServer(ctx, t, storeDir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(&webServer{
+		backend:       backendServer,
+		templateFiles: ui.TemplateFiles(),
+		staticAssets:  ui.StaticAssets(),
+	})
+	defer srv.Close()
+	client := srv.Client()
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	t.Run("Home", func(t *testing.T) {
+		resp, err := client.Get(srv.URL + "/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("status = %s; want 200", resp.Status)
+		}
+	})
+
+	const buildID = "8d5a8b54-48d1-4b9b-8f5c-2b9f3c6f3a10"
+	cancelURL := srv.URL + "/build/" + buildID + "/cancel"
+
+	t.Run("Cancel", func(t *testing.T) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, cancelURL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Origin", srv.URL)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusSeeOther {
+			t.Fatalf("status = %s; want 303", resp.Status)
+		}
+		if got, want := resp.Header.Get("Location"), "/build/"+buildID+"/"; got != want {
+			t.Errorf("Location = %q; want %q", got, want)
+		}
+	})
+
+	t.Run("CancelCrossOrigin", func(t *testing.T) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, cancelURL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Origin", "https://example.com")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("status = %s; want 403", resp.Status)
+		}
+	})
+}
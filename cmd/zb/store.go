@@ -18,7 +18,6 @@ import (
 
 	"github.com/go-json-experiment/json/jsontext"
 	"golang.org/x/term"
-	"zb.256lights.llc/pkg/bytebuffer"
 	"zb.256lights.llc/pkg/internal/backend"
 	"zb.256lights.llc/pkg/internal/jsonrpc"
 	"zb.256lights.llc/pkg/internal/xio"
@@ -29,11 +28,20 @@ import (
 )
 
 type storeDatabaseFlags struct {
-	DBPath string `kong:"name=db,default=${default_store_db},help=Path to store database file."`
+	DBPath string `kong:"name=db,default=${default_store_db},help=Path to store database file. zb serve also accepts memory:// to keep the database in memory."`
 }
 
 type storeCommand struct {
-	Object storeObjectCommand `kong:"cmd"`
+	Object       storeObjectCommand       `kong:"cmd"`
+	PathInfo     storePathInfoCommand     `kong:"cmd"`
+	Attestation  storeAttestationCommand  `kong:"cmd"`
+	Realizations storeRealizationsCommand `kong:"cmd"`
+	Referrers    storeReferrersCommand    `kong:"cmd"`
+	Sign         storeSignCommand         `kong:"cmd"`
+	Trust        storeTrustCommand        `kong:"cmd"`
+
+	PruneRealizations      storePruneRealizationsCommand      `kong:"cmd"`
+	ClearSubstituterMisses storeClearSubstituterMissesCommand `kong:"cmd"`
 }
 
 func (storeCommand) Signature() string {
@@ -127,7 +135,7 @@ func (c *storeObjectInfoCommand) Run(ctx context.Context, g *globalConfig) error
 }
 
 type storeObjectExportCommand struct {
-	Paths             []string `kong:"arg,name=path"`
+	Paths             []string `kong:"arg,name=path,completion-predictor=storepath"`
 	IncludeReferences bool     `kong:"name=references,negatable,help=Include referenced store objects (default ${default}),default=true"`
 	OutputPath        string   `kong:"name=output,short=o,placeholder=file,help=Output file"`
 }
@@ -148,8 +156,11 @@ func (c *storeObjectExportCommand) Run(ctx context.Context, g *globalConfig) err
 	closer := xio.CloseOnce(output)
 	defer closer.Close()
 
+	progress := newTransferProgress(ctx, "Exporting")
+	defer progress.finish()
 	toOutput := zbstorerpc.ImportFunc(func(header jsonrpc.Header, body io.Reader) error {
-		return zbstore.ReceiveExport(nopReceiver{}, io.TeeReader(body, output))
+		recv := zbstorerpc.NewProgressReceiver(nopReceiver{}, header, progress.update)
+		return zbstore.ReceiveExport(recv, io.TeeReader(body, output))
 	})
 	storeClient := g.storeClient(&zbstorerpc.CodecOptions{
 		Importer: toOutput,
@@ -173,6 +184,7 @@ func (c *storeObjectExportCommand) Run(ctx context.Context, g *globalConfig) err
 
 	// The export message is sent before the RPC response, so if we received the response,
 	// the export is complete.
+	progress.finish()
 	if err := closer.Close(); err != nil {
 		return err
 	}
@@ -204,7 +216,9 @@ func (c *storeObjectImportCommand) Run(ctx context.Context, g *globalConfig) err
 		log.Infof(ctx, "Waiting for data on stdin...")
 	}
 
-	storePaths, err := catExports(ctx, storeClient, inputPaths)
+	progress := newTransferProgress(ctx, "Importing")
+	storePaths, err := catExports(ctx, storeClient, inputPaths, progress.update)
+	progress.finish()
 	if err != nil {
 		return err
 	}
@@ -230,7 +244,8 @@ func (c *storeObjectImportCommand) Run(ctx context.Context, g *globalConfig) err
 
 // catExports concatenates the exports from the given files into a single export
 // and sends it to the store connected via the given client.
-func catExports(ctx context.Context, client *jsonrpc.Client, exportFiles []string) ([]zbstore.Path, error) {
+// progress is called as each store object is sent.
+func catExports(ctx context.Context, client *jsonrpc.Client, exportFiles []string, progress func(zbstorerpc.ExportProgress)) ([]zbstore.Path, error) {
 	// If there are no files, then no-op.
 	if len(exportFiles) == 0 {
 		return nil, nil
@@ -256,7 +271,7 @@ func catExports(ctx context.Context, client *jsonrpc.Client, exportFiles []strin
 		ch := make(chan []zbstore.Path)
 		go func() {
 			rec := &exportPathRecorder{ctx: ctx}
-			if err := zbstore.ReceiveExport(rec, pr); err != nil {
+			if err := zbstore.ReceiveExport(zbstorerpc.NewProgressReceiver(rec, nil, progress), pr); err != nil {
 				log.Warnf(ctx, "Invalid store export format in %s: %v", inputFileName(exportFiles[0]), err)
 			}
 			// If we encountered a parse error, still consume the rest of the stream.
@@ -285,12 +300,17 @@ func catExports(ctx context.Context, client *jsonrpc.Client, exportFiles []strin
 	// Copy each NAR inside each export file.
 	var storePaths []zbstore.Path
 	exporter := zbstore.NewExportWriter(pw)
+	recv := &passthroughReceiver{exporter: exporter}
+	progressRecv := zbstorerpc.NewProgressReceiver(recv, nil, progress)
 	for _, path := range exportFiles {
 		var err error
-		storePaths, err = copyToExporter(ctx, storePaths, exporter, path)
+		storePaths, err = copyToExporter(ctx, storePaths, progressRecv, path)
 		if err != nil {
 			return storePaths, err
 		}
+		if recv.err != nil {
+			return storePaths, fmt.Errorf("copying %s: %v", inputFileName(path), recv.err)
+		}
 	}
 	if err := exporter.Close(); err != nil {
 		return storePaths, err
@@ -303,32 +323,28 @@ func catExports(ctx context.Context, client *jsonrpc.Client, exportFiles []strin
 }
 
 // copyToExporter reads the file at path in the `nix-store --export` format
-// and copies each NAR file to the exporter.
+// and copies each NAR file to dst.
 // It appends each of the store paths encountered to storePaths.
-func copyToExporter(ctx context.Context, storePaths []zbstore.Path, exporter *zbstore.ExportWriter, path string) ([]zbstore.Path, error) {
+func copyToExporter(ctx context.Context, storePaths []zbstore.Path, dst zbstore.NARReceiver, path string) ([]zbstore.Path, error) {
 	f, err := openInputFile(path)
 	if err != nil {
 		return nil, err
 	}
 	defer f.Close()
 
-	recv := &passthroughReceiver{exporter: exporter}
 	rec := &exportPathRecorder{
 		ctx:     ctx,
 		paths:   storePaths,
-		wrapped: recv,
+		wrapped: dst,
 	}
 	if err := zbstore.ReceiveExport(rec, f); err != nil {
 		return rec.paths, fmt.Errorf("copying %s: %v", inputFileName(path), err)
 	}
-	if recv.err != nil {
-		return rec.paths, fmt.Errorf("copying %s: %v", inputFileName(path), recv.err)
-	}
 	return rec.paths, nil
 }
 
 // passthroughReceiver copies NAR files to an exporter.
-// It is a helper for [copyToExporter].
+// It is a helper for [catExports].
 type passthroughReceiver struct {
 	exporter *zbstore.ExportWriter
 	err      error
@@ -398,7 +414,7 @@ func (rec *exportPathRecorder) ReceiveNAR(trailer *zbstore.ExportTrailer) {
 type storeObjectDeleteCommand struct {
 	storeDatabaseFlags `kong:"embed"`
 
-	Paths     []zbstore.Path `kong:"arg,name=path,type=nativeStorePath,required,help=Store object paths."`
+	Paths     []zbstore.Path `kong:"arg,name=path,type=nativeStorePath,required,completion-predictor=storepath,help=Store object paths."`
 	Recursive bool           `kong:"short=r,help=Delete objects that depend on the paths."`
 }
 
@@ -456,7 +472,7 @@ func (c *storeObjectRegisterCommand) Run(ctx context.Context, g *globalConfig) e
 		DatabasePoolSize:            1,
 		DisableSandbox:              true,
 		BuildLogRetention:           -1,
-		ContentAddressBufferCreator: bytebuffer.TempFileCreator{Pattern: contentAddressTempFilePattern},
+		ContentAddressBufferCreator: contentAddressBufferCreator(),
 	})
 	defer backendServer.Close()
 
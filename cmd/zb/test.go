@@ -0,0 +1,210 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	jsonv2 "github.com/go-json-experiment/json"
+	"github.com/go-json-experiment/json/jsontext"
+	"zb.256lights.llc/pkg/internal/frontend"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+	"zombiezen.com/go/log"
+)
+
+type testCommand struct {
+	Paths  []string `kong:"name=PATH,arg,optional,completion-predictor=file,help=Test files or directories to search for *_test.lua files. (Default: .)"`
+	Format string   `kong:"enum='tap,json',default=tap,help=Format of the test results: tap or json. (Default: ${default})"`
+
+	evalEnvOptions `kong:"embed"`
+}
+
+func (c *testCommand) Signature() string {
+	return `kong:"help=Run the tests in Lua test files."`
+}
+
+func (c *testCommand) Run(ctx context.Context, g *globalConfig) error {
+	paths := c.Paths
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	files, err := findTestFiles(paths)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no %s files found", frontend.TestFileSuffix)
+	}
+
+	httpClient, httpCloser, err := g.newHTTPClient()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		httpClient.CloseIdleConnections()
+		if err := httpCloser.Close(); err != nil {
+			log.Warnf(ctx, "%v", err)
+		}
+	}()
+	di := new(zbstorerpc.DeferredImporter)
+	storeClient := g.storeClient(&zbstorerpc.CodecOptions{
+		Importer: di,
+	})
+	defer storeClient.Close()
+	eval, err := c.newEval(g, httpClient, storeClient, di)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := eval.Close(); err != nil {
+			log.Errorf(ctx, "%v", err)
+		}
+	}()
+
+	var records []*testRecord
+	if c.Format == "tap" {
+		fmt.Println("TAP version 13")
+	}
+	for _, file := range files {
+		results, err := eval.RunTestFile(ctx, file)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		newRecords := make([]*testRecord, 0, len(results)+1)
+		for _, result := range results {
+			newRecords = append(newRecords, newTestRecord(file, result, g.ShowTrace))
+		}
+		if err != nil {
+			// The file could not be loaded or failed outside of a test.
+			newRecords = append(newRecords, newTestRecord(file, &frontend.TestResult{Error: err}, g.ShowTrace))
+		}
+		if c.Format == "tap" {
+			for i, r := range newRecords {
+				writeTAPResult(os.Stdout, len(records)+i+1, r)
+			}
+		}
+		records = append(records, newRecords...)
+	}
+
+	failed := 0
+	for _, r := range records {
+		if !r.Passed {
+			failed++
+		}
+	}
+	switch c.Format {
+	case "tap":
+		fmt.Printf("1..%d\n", len(records))
+	case "json":
+		data, err := jsonv2.Marshal(records, jsontext.Multiline(true))
+		if err != nil {
+			return err
+		}
+		data = append(data, '\n')
+		if _, err := os.Stdout.Write(data); err != nil {
+			return err
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d tests failed", failed, len(records))
+	}
+	return nil
+}
+
+// testRecord is the result of a single test in zb test output.
+type testRecord struct {
+	File string `json:"file"`
+	// Name is the name of the test
+	// or empty if the record represents an error running the file.
+	Name    string  `json:"name,omitempty"`
+	Passed  bool    `json:"passed"`
+	Error   string  `json:"error,omitempty"`
+	Seconds float64 `json:"seconds"`
+}
+
+func newTestRecord(file string, result *frontend.TestResult, showTrace bool) *testRecord {
+	r := &testRecord{
+		File:    file,
+		Name:    result.Name,
+		Passed:  result.Error == nil,
+		Seconds: result.Duration.Seconds(),
+	}
+	if result.Error != nil {
+		r.Error = result.Error.Error()
+		if !showTrace {
+			r.Error = trimTraceback(r.Error)
+		}
+	}
+	return r
+}
+
+// writeTAPResult writes a test point in the [Test Anything Protocol] format to w.
+// Failures include the error message in a YAML block.
+//
+// [Test Anything Protocol]: https://testanything.org/tap-version-13-specification.html
+func writeTAPResult(w io.Writer, n int, r *testRecord) {
+	description := r.File
+	if r.Name != "" {
+		description += ": " + r.Name
+	}
+	// "#" starts a directive in TAP.
+	description = strings.ReplaceAll(description, "#", `\#`)
+	if r.Passed {
+		fmt.Fprintf(w, "ok %d - %s\n", n, description)
+		return
+	}
+	fmt.Fprintf(w, "not ok %d - %s\n", n, description)
+	io.WriteString(w, "  ---\n  message: |\n")
+	for line := range strings.Lines(r.Error) {
+		io.WriteString(w, "    ")
+		io.WriteString(w, line)
+	}
+	if !strings.HasSuffix(r.Error, "\n") {
+		io.WriteString(w, "\n")
+	}
+	io.WriteString(w, "  ...\n")
+}
+
+// findTestFiles returns the Lua test files named by paths.
+// Directories are searched recursively for files ending in [frontend.TestFileSuffix],
+// skipping directories whose names start with a dot.
+// Other paths are returned as-is.
+func findTestFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, root := range paths {
+		info, err := os.Stat(root)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, root)
+			continue
+		}
+		err = filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if entry.IsDir() {
+				if path != root && strings.HasPrefix(entry.Name(), ".") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if strings.HasSuffix(entry.Name(), frontend.TestFileSuffix) {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
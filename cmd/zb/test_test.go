@@ -0,0 +1,89 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFindTestFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"a_test.lua",
+		"b.lua",
+		filepath.Join("sub", "c_test.lua"),
+		filepath.Join(".git", "d_test.lua"),
+		"explicit.lua",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0o666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := findTestFiles([]string{dir, filepath.Join(dir, "explicit.lua")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		filepath.Join(dir, "a_test.lua"),
+		filepath.Join(dir, "sub", "c_test.lua"),
+		filepath.Join(dir, "explicit.lua"),
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("findTestFiles(...) (-want +got):\n%s", diff)
+	}
+}
+
+func TestWriteTAPResult(t *testing.T) {
+	tests := []struct {
+		n      int
+		record *testRecord
+		want   string
+	}{
+		{
+			n:      1,
+			record: &testRecord{File: "foo_test.lua", Name: "works", Passed: true},
+			want:   "ok 1 - foo_test.lua: works\n",
+		},
+		{
+			n: 2,
+			record: &testRecord{
+				File:  "foo_test.lua",
+				Name:  "issue #12",
+				Error: "foo_test.lua:5: assertion failed!\nmore detail",
+			},
+			want: "not ok 2 - foo_test.lua: issue \\#12\n" +
+				"  ---\n" +
+				"  message: |\n" +
+				"    foo_test.lua:5: assertion failed!\n" +
+				"    more detail\n" +
+				"  ...\n",
+		},
+		{
+			n:      3,
+			record: &testRecord{File: "bar_test.lua", Error: "bar_test.lua:1: boom"},
+			want: "not ok 3 - bar_test.lua\n" +
+				"  ---\n" +
+				"  message: |\n" +
+				"    bar_test.lua:1: boom\n" +
+				"  ...\n",
+		},
+	}
+	for _, test := range tests {
+		sb := new(strings.Builder)
+		writeTAPResult(sb, test.n, test.record)
+		if diff := cmp.Diff(test.want, sb.String()); diff != "" {
+			t.Errorf("writeTAPResult(w, %d, %+v) (-want +got):\n%s", test.n, test.record, diff)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package backend
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	jsonv2 "github.com/go-json-experiment/json"
+	"zb.256lights.llc/pkg/internal/jsonrpc"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+	"zb.256lights.llc/pkg/sets"
+	"zb.256lights.llc/pkg/zbstore"
+	"zombiezen.com/go/log"
+	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+// defaultBuilderID is the default value of [Options.BuilderID].
+const defaultBuilderID = "https://zb.256lights.llc/builders/local"
+
+// attestationRun is the information about a builder run
+// that is recorded in attestations.
+type attestationRun struct {
+	state        *derivationBuildState
+	dependencies []*zbstore.ResourceDescriptor
+	startedOn    time.Time
+	finishedOn   time.Time
+}
+
+// newAttestationRun gathers the information needed to attest to the outputs
+// of a builder run for the derivation in state.
+// inputs is the set of store objects that were available to the builder.
+func (b *builder) newAttestationRun(conn *sqlite.Conn, state *derivationBuildState, inputs *sets.Sorted[zbstore.Path], startedOn, finishedOn time.Time) (*attestationRun, error) {
+	run := &attestationRun{
+		state:        state,
+		dependencies: make([]*zbstore.ResourceDescriptor, 0, 1+inputs.Len()),
+		startedOn:    startedOn,
+		finishedOn:   finishedOn,
+	}
+	addDependency := func(path zbstore.Path) error {
+		info, err := pathInfo(conn, path)
+		if err != nil {
+			return err
+		}
+		run.dependencies = append(run.dependencies, zbstore.NewObjectResourceDescriptor(path, info.NARHash))
+		return nil
+	}
+	if err := addDependency(state.drvPath); err != nil {
+		return nil, fmt.Errorf("attest %s: %v", state.drvPath, err)
+	}
+	for input := range inputs.Values() {
+		if err := addDependency(input); err != nil {
+			return nil, fmt.Errorf("attest %s: %v", state.drvPath, err)
+		}
+	}
+	return run, nil
+}
+
+// attest returns a signed [SLSA provenance] attestation
+// for the built output described by info.
+//
+// [SLSA provenance]: https://slsa.dev/spec/v1.0/provenance
+func (b *builder) attest(run *attestationRun, outputName string, info *ObjectInfo) (*zbstore.AttestationEnvelope, error) {
+	env, err := zbstore.NewAttestationEnvelope(&zbstore.AttestationStatement{
+		Type: zbstore.InTotoStatementType,
+		Subject: []*zbstore.ResourceDescriptor{
+			zbstore.NewObjectResourceDescriptor(info.StorePath, info.NARHash),
+		},
+		PredicateType: zbstore.SLSAProvenancePredicateType,
+		Predicate: &zbstore.SLSAProvenance{
+			BuildDefinition: zbstore.SLSABuildDefinition{
+				BuildType: zbstore.SLSABuildType,
+				ExternalParameters: &zbstore.SLSAExternalParameters{
+					DerivationPath: run.state.drvPath,
+					DerivationHash: run.state.derivationHash,
+					OutputName:     outputName,
+				},
+				InternalParameters: &zbstore.SLSAInternalParameters{
+					System:    run.state.derivation.System,
+					Sandboxed: b.server.sandbox,
+				},
+				ResolvedDependencies: run.dependencies,
+			},
+			RunDetails: zbstore.SLSARunDetails{
+				Builder: zbstore.SLSABuilder{ID: b.server.builderID},
+				Metadata: &zbstore.SLSABuildMetadata{
+					InvocationID: b.id.String(),
+					StartedOn:    run.startedOn.UTC(),
+					FinishedOn:   run.finishedOn.UTC(),
+				},
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("attest %v: %v", zbstore.OutputReference{DrvPath: run.state.drvPath, OutputName: outputName}, err)
+	}
+	b.server.keyring.SignAttestation(env)
+	return env, nil
+}
+
+// attestation is an [*zbstore.AttestationEnvelope]
+// for a single realization.
+type attestation struct {
+	ref        zbstore.RealizationOutputReference
+	outputPath zbstore.Path
+	envelope   *zbstore.AttestationEnvelope
+}
+
+// recordAttestations saves the given attestations to the database.
+// The realizations must have already been recorded.
+func recordAttestations(conn *sqlite.Conn, attestations []attestation) (err error) {
+	if len(attestations) == 0 {
+		return nil
+	}
+	defer sqlitex.Save(conn)(&err)
+
+	stmt, err := sqlitex.PrepareTransientFS(conn, sqlFiles(), "attestations/insert.sql")
+	if err != nil {
+		return fmt.Errorf("record attestations: %v", err)
+	}
+	defer stmt.Finalize()
+
+	for _, a := range attestations {
+		envelopeJSON, err := marshalJSONString(a.envelope)
+		if err != nil {
+			return fmt.Errorf("record attestation for %v: %v", a.ref, err)
+		}
+		stmt.SetText(":drv_hash_algorithm", a.ref.DerivationHash.Type().String())
+		stmt.SetBytes(":drv_hash_bits", a.ref.DerivationHash.Bytes(nil))
+		stmt.SetText(":output_name", a.ref.OutputName)
+		stmt.SetText(":output_path", string(a.outputPath))
+		stmt.SetText(":envelope", envelopeJSON)
+		if _, err := stmt.Step(); err != nil {
+			return fmt.Errorf("record attestation for %v: %v", a.ref, err)
+		}
+		if err := stmt.Reset(); err != nil {
+			return fmt.Errorf("record attestation for %v: %v", a.ref, err)
+		}
+	}
+	return nil
+}
+
+// findAttestations returns the attestations recorded for the store object at path.
+func findAttestations(conn *sqlite.Conn, path zbstore.Path) ([]*zbstore.AttestationEnvelope, error) {
+	var result []*zbstore.AttestationEnvelope
+	err := sqlitex.ExecuteTransientFS(conn, sqlFiles(), "attestations/find.sql", &sqlitex.ExecOptions{
+		Named: map[string]any{":path": string(path)},
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			env := new(zbstore.AttestationEnvelope)
+			if err := unmarshalJSONString(stmt.GetText("envelope"), env); err != nil {
+				return err
+			}
+			result = append(result, env)
+			return nil
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("find attestations for %s: %v", path, err)
+	}
+	return result, nil
+}
+
+func (s *Server) attestations(ctx context.Context, req *jsonrpc.Request) (*jsonrpc.Response, error) {
+	var args zbstorerpc.AttestationsRequest
+	if err := jsonv2.Unmarshal(req.Params, &args); err != nil {
+		return nil, jsonrpc.Error(jsonrpc.InvalidParams, err)
+	}
+	resp := &zbstorerpc.AttestationsResponse{
+		Attestations: []*zbstore.AttestationEnvelope{},
+	}
+	if args.Path.Dir() != s.dir {
+		return marshalResponse(resp)
+	}
+
+	conn, err := s.db.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer s.db.Put(conn)
+
+	log.Debugf(ctx, "Looking up attestations for %s...", args.Path)
+	envs, err := findAttestations(conn, args.Path)
+	if err != nil {
+		return nil, err
+	}
+	resp.Attestations = append(resp.Attestations, envs...)
+	return marshalResponse(resp)
+}
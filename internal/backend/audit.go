@@ -0,0 +1,272 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+
+	jsonv2 "github.com/go-json-experiment/json"
+	"github.com/go-json-experiment/json/jsontext"
+	"zb.256lights.llc/pkg/internal/jsonrpc"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+	"zb.256lights.llc/pkg/sets"
+	"zb.256lights.llc/pkg/zbstore"
+	"zombiezen.com/go/log"
+)
+
+// AuditAction is the kind of operation recorded in an [AuditEvent].
+type AuditAction string
+
+// Audit actions.
+const (
+	// AuditImport is recorded when a store object is imported.
+	AuditImport AuditAction = "import"
+	// AuditDelete is recorded when store objects are deleted.
+	AuditDelete AuditAction = "delete"
+	// AuditPruneRealizations is recorded when stale realizations are garbage collected.
+	AuditPruneRealizations AuditAction = "pruneRealizations"
+	// AuditDeleteBuilds is recorded when old builds and their logs are garbage collected.
+	AuditDeleteBuilds AuditAction = "deleteBuilds"
+	// AuditSign is recorded when the server's keys sign the realizations
+	// and attestations of built store objects.
+	AuditSign AuditAction = "sign"
+	// AuditRPC is recorded when a client calls a method
+	// that changes the server's configuration or bookkeeping.
+	AuditRPC AuditAction = "rpc"
+)
+
+// auditedMethods is the set of RPC methods recorded with [AuditRPC].
+var auditedMethods = sets.New(
+	zbstorerpc.AddRootMethod,
+	zbstorerpc.AddSignaturesMethod,
+	zbstorerpc.CancelBuildMethod,
+	zbstorerpc.ClearSubstituterMissesMethod,
+	zbstorerpc.PruneRealizationsMethod,
+)
+
+// AuditEvent is a single entry in an [AuditLog].
+type AuditEvent struct {
+	Time   time.Time   `json:"time"`
+	Action AuditAction `json:"action"`
+	// Client is the name of the client that caused the event
+	// (see [ClientInfo]).
+	// It is empty for events that the server initiated on its own,
+	// like periodic garbage collection.
+	Client string `json:"client,omitempty"`
+	// Peer is the identity of the process on the other end of the client's connection,
+	// if known.
+	Peer *PeerCredentials `json:"peer,omitempty"`
+
+	// Method and Params are the request for an [AuditRPC] event.
+	Method string         `json:"method,omitempty"`
+	Params jsontext.Value `json:"params,omitempty"`
+
+	// Paths is the list of store objects that the operation affected.
+	Paths []zbstore.Path `json:"paths,omitempty"`
+	// Keys is the list of public keys whose private keys were used.
+	Keys []*zbstore.RealizationPublicKey `json:"keys,omitempty"`
+	// Count is the number of records that the operation affected
+	// for operations that affect many records at once.
+	Count int64 `json:"count,omitempty"`
+	// Error is the error message if the operation failed.
+	Error string `json:"error,omitempty"`
+}
+
+// PeerCredentials identifies the process on the other end of a local connection.
+type PeerCredentials struct {
+	UID int `json:"uid"`
+	GID int `json:"gid"`
+	// PID is the process ID or zero if unknown.
+	PID int `json:"pid,omitzero"`
+}
+
+// AuditLogOptions is the set of optional parameters to [OpenAuditLog].
+type AuditLogOptions struct {
+	// MaxSize is the size in bytes that the log file may grow to
+	// before it is rotated.
+	// If non-positive, then the log file is never rotated.
+	MaxSize int64
+	// MaxBackups is the number of rotated log files to keep.
+	// Rotated log files are named by appending ".1", ".2", and so on to the log file's path,
+	// with ".1" being the most recent.
+	// If non-positive, then rotated log files are deleted immediately.
+	MaxBackups int
+}
+
+// An AuditLog is an append-only file of [AuditEvent] values
+// written as JSON, one per line.
+// Methods on AuditLog are safe to call from multiple goroutines.
+type AuditLog struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// OpenAuditLog opens the audit log file at the given path for appending,
+// creating it if necessary.
+// If opts is nil, it is treated the same as new(AuditLogOptions).
+func OpenAuditLog(path string, opts *AuditLogOptions) (*AuditLog, error) {
+	if opts == nil {
+		opts = new(AuditLogOptions)
+	}
+	l := &AuditLog{
+		path:       path,
+		maxSize:    opts.MaxSize,
+		maxBackups: opts.MaxBackups,
+	}
+	if err := l.open(); err != nil {
+		return nil, fmt.Errorf("open audit log: %v", err)
+	}
+	return l, nil
+}
+
+func (l *AuditLog) open() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.f = f
+	l.size = info.Size()
+	return nil
+}
+
+// Record appends the event to the log,
+// rotating the log file first if the event would make it exceed its maximum size.
+func (l *AuditLog) Record(event *AuditEvent) error {
+	line, err := jsonv2.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("record %s audit event: %v", event.Action, err)
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return fmt.Errorf("record %s audit event: %w", event.Action, fs.ErrClosed)
+	}
+	if l.maxSize > 0 && l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return fmt.Errorf("record %s audit event: rotate: %v", event.Action, err)
+		}
+	}
+	n, err := l.f.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("record %s audit event: %v", event.Action, err)
+	}
+	return nil
+}
+
+// rotate closes the current log file,
+// shifts it and the existing backups up by one,
+// and opens a new, empty log file.
+// l.mu must be held.
+func (l *AuditLog) rotate() error {
+	closeError := l.f.Close()
+	l.f = nil
+	if closeError != nil {
+		return closeError
+	}
+
+	if l.maxBackups <= 0 {
+		if err := os.Remove(l.path); err != nil {
+			return err
+		}
+		return l.open()
+	}
+	if err := os.Remove(l.backupPath(l.maxBackups)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for i := l.maxBackups - 1; i >= 1; i-- {
+		if err := os.Rename(l.backupPath(i), l.backupPath(i+1)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	if err := os.Rename(l.path, l.backupPath(1)); err != nil {
+		return err
+	}
+	return l.open()
+}
+
+func (l *AuditLog) backupPath(i int) string {
+	return fmt.Sprintf("%s.%d", l.path, i)
+}
+
+// Close closes the log file.
+func (l *AuditLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	err := l.f.Close()
+	l.f = nil
+	return err
+}
+
+// audit records the event to the server's audit log, if it has one.
+// If the event does not have a client,
+// then the client from ctx (see [ClientFromContext]) is used.
+// Failures to write the event are logged.
+func (s *Server) audit(ctx context.Context, event *AuditEvent) {
+	if s.auditLog == nil {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	if event.Client == "" && event.Peer == nil {
+		event.setClient(ClientFromContext(ctx))
+	}
+	if err := s.auditLog.Record(event); err != nil {
+		log.Errorf(ctx, "%v", err)
+	}
+}
+
+func (event *AuditEvent) setClient(client *ClientInfo) {
+	if client == nil {
+		return
+	}
+	event.Client = client.Name
+	event.Peer = client.Peer
+}
+
+// setError sets event.Error to the message of err if err is not nil.
+func (event *AuditEvent) setError(err error) {
+	if err != nil {
+		event.Error = err.Error()
+	}
+}
+
+// interceptAudit is a [jsonrpc.Interceptor]
+// that records requests for the methods in [auditedMethods] to the audit log.
+func (s *Server) interceptAudit(ctx context.Context, req *jsonrpc.Request, next jsonrpc.Handler) (*jsonrpc.Response, error) {
+	if s.auditLog == nil || !auditedMethods.Has(req.Method) {
+		return next.JSONRPC(ctx, req)
+	}
+	resp, err := next.JSONRPC(ctx, req)
+	event := &AuditEvent{
+		Action: AuditRPC,
+		Method: req.Method,
+		Params: req.Params,
+	}
+	event.setError(err)
+	s.audit(ctx, event)
+	return resp, err
+}
@@ -0,0 +1,170 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package backend_test
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	jsonv2 "github.com/go-json-experiment/json"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	. "zb.256lights.llc/pkg/internal/backend"
+	"zb.256lights.llc/pkg/internal/backendtest"
+	"zb.256lights.llc/pkg/internal/jsonrpc"
+	"zb.256lights.llc/pkg/internal/storetest"
+	"zb.256lights.llc/pkg/internal/testcontext"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+	"zb.256lights.llc/pkg/sets"
+	"zb.256lights.llc/pkg/zbstore"
+)
+
+func TestAuditLog(t *testing.T) {
+	t.Run("Server", func(t *testing.T) {
+		ctx := testcontext.New(t)
+		dir := backendtest.NewStoreDirectory(t)
+		auditLogPath := filepath.Join(t.TempDir(), "audit.log")
+		auditLog, err := OpenAuditLog(auditLogPath, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			if err := auditLog.Close(); err != nil {
+				t.Error(err)
+			}
+		})
+		srv, client, err := backendtest.NewServer(ctx, t, dir, &backendtest.Options{
+			TempDir: t.TempDir(),
+			Options: Options{
+				AuditLog: auditLog,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		exportBuffer := new(bytes.Buffer)
+		exporter := zbstore.NewExportWriter(exportBuffer)
+		inputFilePath, _, err := storetest.ExportSourceFile(exporter, []byte("Hello, World!\n"), storetest.SourceExportOptions{
+			Name:      "hello.txt",
+			Directory: dir,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := exporter.Close(); err != nil {
+			t.Fatal(err)
+		}
+		codec, releaseCodec, err := storeCodec(ctx, client)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = codec.Export(nil, exportBuffer)
+		releaseCodec()
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = jsonrpc.Do(ctx, client, zbstorerpc.PruneRealizationsMethod, nil, &zbstorerpc.PruneRealizationsRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := srv.Delete(ctx, sets.New(inputFilePath)); err != nil {
+			t.Fatal(err)
+		}
+
+		got := readAuditLog(t, auditLogPath)
+		want := []*AuditEvent{
+			{
+				Action: AuditImport,
+				Paths:  []zbstore.Path{inputFilePath},
+			},
+			{
+				Action: AuditPruneRealizations,
+			},
+			{
+				Action: AuditRPC,
+				Method: zbstorerpc.PruneRealizationsMethod,
+			},
+			{
+				Action: AuditDelete,
+				Paths:  []zbstore.Path{inputFilePath},
+			},
+		}
+		diff := cmp.Diff(
+			want, got,
+			cmpopts.IgnoreFields(AuditEvent{}, "Time", "Params"),
+			cmpopts.EquateEmpty(),
+		)
+		if diff != "" {
+			t.Errorf("audit log (-want +got):\n%s", diff)
+		}
+		for i, event := range got {
+			if event.Time.IsZero() {
+				t.Errorf("event[%d].Time is zero", i)
+			}
+		}
+	})
+
+	t.Run("Rotate", func(t *testing.T) {
+		auditLogPath := filepath.Join(t.TempDir(), "audit.log")
+		event := &AuditEvent{Action: AuditRPC, Method: zbstorerpc.AddRootMethod}
+		line, err := jsonv2.Marshal(event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		auditLog, err := OpenAuditLog(auditLogPath, &AuditLogOptions{
+			// Two events fit in each file.
+			MaxSize:    int64(len(line)+1) * 2,
+			MaxBackups: 2,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for range 7 {
+			if err := auditLog.Record(event); err != nil {
+				t.Error(err)
+			}
+		}
+		if err := auditLog.Close(); err != nil {
+			t.Error(err)
+		}
+
+		for _, tc := range []struct {
+			path string
+			want int
+		}{
+			{auditLogPath, 1},
+			{auditLogPath + ".1", 2},
+			{auditLogPath + ".2", 2},
+		} {
+			if got := len(readAuditLog(t, tc.path)); got != tc.want {
+				t.Errorf("%s has %d events; want %d", filepath.Base(tc.path), got, tc.want)
+			}
+		}
+		if _, err := os.Lstat(auditLogPath + ".3"); err == nil {
+			t.Errorf("%s.3 exists", filepath.Base(auditLogPath))
+		}
+	})
+}
+
+func readAuditLog(tb testing.TB, path string) []*AuditEvent {
+	tb.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	var events []*AuditEvent
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		event := new(AuditEvent)
+		if err := jsonv2.Unmarshal(scanner.Bytes(), event); err != nil {
+			tb.Fatalf("%s: %v", filepath.Base(path), err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
@@ -5,12 +5,14 @@
 package backend
 
 import (
+	"cmp"
 	"context"
 	"errors"
 	"fmt"
 	"io"
 	"iter"
 	"maps"
+	"net/http"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -22,8 +24,10 @@ import (
 	"github.com/go-json-experiment/json/jsontext"
 	"github.com/google/uuid"
 	"zb.256lights.llc/pkg/bytebuffer"
+	"zb.256lights.llc/pkg/internal/credentials"
 	"zb.256lights.llc/pkg/internal/jsonrpc"
 	"zb.256lights.llc/pkg/internal/multierror"
+	"zb.256lights.llc/pkg/internal/osutil"
 	"zb.256lights.llc/pkg/internal/xiter"
 	"zb.256lights.llc/pkg/internal/xslices"
 	"zb.256lights.llc/pkg/internal/xtime"
@@ -59,6 +63,14 @@ type Options struct {
 	// BuildDirectory is where realizations' working directories will be placed.
 	// If empty, defaults to [os.TempDir].
 	BuildDirectory string
+	// If BuildTmpfsSize is positive, then each build's working directory
+	// is backed by a tmpfs of at most BuildTmpfsSize bytes
+	// mounted inside BuildDirectory
+	// so that building does not contend for disk with the store.
+	// Derivations can opt out by setting __buildTmpfs to "0".
+	// tmpfs mounts are only supported on Linux when running as root;
+	// in other cases, the working directory is placed on disk.
+	BuildTmpfsSize int64
 	// LogDirectory is where builder logs will be stored.
 	// If empty, defaults to a directory called "log" in the same directory as the database.
 	LogDirectory string
@@ -74,12 +86,26 @@ type Options struct {
 	// the server will upload the object and realizations.
 	Upload *zbstorehttp.Store
 
+	// FetchTransport is used to send the requests made by builtin:fetchurl.
+	// If nil, then [http.DefaultTransport] is used.
+	FetchTransport http.RoundTripper
+	// FetchCredentials is used to authenticate the requests made by builtin:fetchurl.
+	// Credentials are added to requests as they are sent
+	// and never appear in the derivation or the build log.
+	// If nil, then requests are sent without credentials.
+	FetchCredentials credentials.Provider
+
 	// DatabasePoolSize is the maximum permitted number of concurrent connections to the database.
 	// If less than 1, a reasonable default is used.
 	DatabasePoolSize int
 
 	// If AllowKeepFailed is true, then the KeepFailed field in [zbstore.RealizeRequest] will be respected.
 	AllowKeepFailed bool
+	// If Offline is true, then every build is performed
+	// as if [zbstorerpc.RealizeRequest.Offline] was set:
+	// the Fallback and Upload stores are not used
+	// and derivations that require network access fail.
+	Offline bool
 
 	// If DisableSandbox is true, then builders are always run without the sandbox.
 	// Otherwise, sandboxing is used whenever possible.
@@ -88,16 +114,86 @@ type Options struct {
 	// to paths on the host machine.
 	// These paths will be made available to sandboxed builders.
 	SandboxPaths map[string]SandboxPath
+	// HashedSandboxPaths is the list of paths on the host machine
+	// that a derivation may request with the __hashedSystemDeps environment variable.
+	// A derivation may request a listed path or any path inside a listed directory.
+	// Each requested path is made available to sandboxed builders at the same path,
+	// but only if its NAR hash matches the hash that the derivation declares.
+	HashedSandboxPaths []string
+
+	// SystemFeatures is the set of optional features this machine supports
+	// (e.g. "kvm" or "big-parallel").
+	// Derivations that require features not in this list
+	// via the __requiredSystemFeatures environment variable fail to build.
+	// Clients can query the list with [zbstorerpc.HandshakeMethod].
+	// See [DefaultSystemFeatures] for a reasonable default.
+	SystemFeatures []string
 
 	// CoresPerBuild is a hint from the user to builders
 	// on the number of concurrent jobs to perform.
 	// If non-positive, then the number of cores detected on the machine is used.
 	CoresPerBuild int
 
+	// Determinism is the set of measures the server takes
+	// to reduce sources of nondeterminism in builders.
+	Determinism DeterminismOptions
+
+	// MaxConcurrentBuilds is the maximum number of builders that will run at once.
+	// When builders are waiting for a slot,
+	// slots are handed out to clients (see [WithClient])
+	// in weighted round-robin order (see [ClientInfo.Weight]).
+	// If non-positive, then the number of concurrent builders is only limited by BuildUsers and BuildUserRange.
+	MaxConcurrentBuilds int
+	// MaxOutputSize is the maximum total NAR size in bytes
+	// of the outputs produced by a single builder run.
+	// Builds whose outputs exceed the limit fail.
+	// If non-positive, then output size is not limited.
+	MaxOutputSize int64
+	// MaxOutputFiles is the maximum total number of filesystem objects
+	// (files, directories, and symlinks)
+	// in the outputs produced by a single builder run.
+	// Builds whose outputs exceed the limit fail.
+	// If non-positive, then the number of files is not limited.
+	MaxOutputFiles int64
+	// MaxQueuedPerClient is the maximum number of derivations
+	// a single client may have waiting for a build slot.
+	// Derivations beyond this limit fail to build.
+	// If non-positive, then there is no limit.
+	MaxQueuedPerClient int
+	// MaxClientPriority is the highest build priority a client may request
+	// (see [zbstorerpc.RealizeRequest.Priority]).
+	// Requests with a higher priority are scheduled as if they had requested MaxClientPriority.
+	// The zero value only permits clients to lower the priority of their builds.
+	MaxClientPriority int
+
+	// PreBuildHook is the path to a program that is run outside the sandbox
+	// before each builder starts.
+	// The program receives the derivation's store path in the DRV_PATH environment variable
+	// and the store directory in the ZB_STORE environment variable.
+	// Its output is written to the build log.
+	// If the program exits with a non-zero status, then the build fails without running the builder.
+	PreBuildHook string
+	// PostBuildHook is the path to a program that is run outside the sandbox
+	// after each successful build.
+	// In addition to the variables given to PreBuildHook,
+	// the program receives the space-separated store paths of the derivation's outputs
+	// in the OUT_PATHS environment variable.
+	// Its output is appended to the build log.
+	// Failures of the program are logged, but do not cause the build to fail.
+	PostBuildHook string
+
 	// BuildUsers is the set of user IDs to use for builds on non-Windows systems.
 	// If empty, then builds will use the current process's privileges.
 	// [NewServer] will panic if multiple entries have the same user ID.
 	BuildUsers []BuildUser
+	// BuildUserRange is a range of user IDs that are allocated to builds
+	// when all of the BuildUsers are in use.
+	// No two running builds share a user ID,
+	// so builds can't see each other's files
+	// even when there are more concurrent builds than BuildUsers.
+	// The user IDs should not belong to any account on the system.
+	// [NewServer] will panic if the range includes any of the BuildUsers.
+	BuildUserRange BuildUserRange
 
 	// BuildContext optionally specifies a function that detaches the context for a build.
 	// If BuildContext is nil, the default is [context.Background].
@@ -107,9 +203,32 @@ type Options struct {
 	// If non-positive, then build logs will be not be automatically deleted.
 	BuildLogRetention time.Duration
 
+	// SubstituterMissTTL is the length of time that the server remembers
+	// that the Fallback store has no realizations for a derivation.
+	// During that time, realizing the derivation again
+	// does not query the Fallback store.
+	// If non-positive, then the Fallback store is always queried.
+	SubstituterMissTTL time.Duration
+
 	// Keyring is a set of keys that will be used to sign realizations
 	// that this server realizes.
+	// If Keyring is not empty, then the server also records a signed
+	// SLSA provenance attestation for each output it builds.
 	Keyring *Keyring
+	// BuilderID is the URI that identifies this server in attestations.
+	// If empty, then a generic URI for local zb builders is used.
+	BuilderID string
+
+	// Interceptors wrap every request that the server handles,
+	// in the order given to [jsonrpc.Intercept].
+	// The client that made the request is available from [ClientFromContext].
+	Interceptors []jsonrpc.Interceptor
+
+	// If AuditLog is not nil, then the server records privileged operations to it:
+	// imports, deletions, garbage collection, uses of the Keyring,
+	// and requests that change the server's bookkeeping.
+	// The server does not close AuditLog.
+	AuditLog *AuditLog
 }
 
 // A SandboxPath is the set of options for SandboxPaths in [Options].
@@ -134,14 +253,60 @@ func (user BuildUser) String() string {
 	return fmt.Sprintf("%d:%d", user.UID, user.GID)
 }
 
+// BuildUserRange is a contiguous range of Unix user IDs
+// that are allocated to builds on demand.
+// The zero value is an empty range.
+type BuildUserRange struct {
+	// Start is the first user ID in the range.
+	Start int
+	// Count is the number of user IDs in the range.
+	Count int
+	// GID is the primary group ID for all users in the range.
+	GID int
+}
+
+// Contains reports whether uid is in the range.
+func (r BuildUserRange) Contains(uid int) bool {
+	return r.Start <= uid && uid < r.Start+r.Count
+}
+
+func (r BuildUserRange) String() string {
+	if r.Count <= 0 {
+		return "<empty>"
+	}
+	return fmt.Sprintf("%d-%d:%d", r.Start, r.Start+r.Count-1, r.GID)
+}
+
 // SystemSupportsSandbox reports whether the host operating system supports sandboxing.
 func SystemSupportsSandbox() bool {
 	return runtime.GOOS == "linux"
 }
 
 // CanSandbox reports whether the current execution environment supports sandboxing.
+// Without root privileges, sandboxing requires unprivileged user namespaces,
+// which some kernels disable.
+// Programs that run a sandboxed [Server] without root privileges
+// must call [RunSandboxHelper] at the start of main.
 func CanSandbox() bool {
-	return SystemSupportsSandbox() && os.Geteuid() == 0
+	return SystemSupportsSandbox() && (os.Geteuid() == 0 || canSandboxRootless())
+}
+
+// sandboxHelperArg0 is the value of os.Args[0]
+// that a [Server] uses to start a sandbox helper.
+const sandboxHelperArg0 = "zb-sandbox-helper"
+
+// RunSandboxHelper checks whether the current process is a sandbox helper
+// started by a [Server] running without root privileges.
+// If it is, RunSandboxHelper runs the builder in the sandbox
+// and returns the builder's exit code.
+// Servers start the helper by re-running the current executable,
+// so programs that run a sandboxed Server without root privileges
+// must call RunSandboxHelper at the start of main.
+func RunSandboxHelper() (exitCode int, isHelper bool) {
+	if len(os.Args) == 0 || os.Args[0] != sandboxHelperArg0 {
+		return 0, false
+	}
+	return runSandboxHelper(), true
 }
 
 // Server is a local store.
@@ -150,31 +315,61 @@ type Server struct {
 	dir             zbstore.Directory
 	realDir         string
 	buildDir        string
+	buildTmpfsSize  int64
+	outputQuota     outputQuota
 	logDir          string
+	removeLogDir    bool
 	caCreateTemp    bytebuffer.Creator
 	db              *sqlitemigration.Pool
 	allowKeepFailed bool
+	offline         bool
 	buildContext    func(context.Context, string) context.Context
 	keyring         *Keyring
+	builderID       string
+	auditLog        *AuditLog
 	fallback        Store
+	fallbackName    string
+	prefetcher      *prefetchStore
 	upload          *zbstorehttp.Store
+	// substituterMissTTL is the value of [Options.SubstituterMissTTL]
+	// or zero if there is no fallback store.
+	substituterMissTTL time.Duration
+
+	// caseInsensitive reports whether realDir is on a case-insensitive filesystem.
+	caseInsensitive func() bool
 
 	sandbox      bool
 	sandboxPaths map[string]SandboxPath
+	hashedPaths  []string
+	determinism  DeterminismOptions
+
+	systemFeatures sets.Set[string]
+
+	preBuildHook  string
+	postBuildHook string
+
+	fetchTransport   http.RoundTripper
+	fetchCredentials credentials.Provider
 
 	backgroundContext context.Context
 	cancelBackground  context.CancelFunc
 	background        sync.WaitGroup
 
+	// handler is the request handler wrapped in interceptors.
+	handler jsonrpc.Handler
+
 	coresPerBuild int
 
-	writing  mutexMap[zbstore.Path] // store objects being written
-	building mutexMap[zbstore.Path] // derivations being built
-	users    *userSet
+	writing   mutexMap[zbstore.Path] // store objects being written
+	building  mutexMap[zbstore.Path] // derivations being built
+	users     *userSet
+	scheduler *buildScheduler
 
 	activeBuildsMu sync.Mutex
 	activeBuilds   map[uuid.UUID]context.CancelFunc
 	draining       bool
+	// runningBuilds has an entry for each build in activeBuilds.
+	runningBuilds sync.WaitGroup
 
 	// launchCheckDone is closed after launchCheckError is set.
 	launchCheckDone chan struct{}
@@ -183,13 +378,30 @@ type Server struct {
 	launchCheckError error
 }
 
+// MemoryDatabase is a database path that directs [NewServer]
+// to keep the store's metadata in memory instead of in a file.
+// The database is discarded when the server is closed.
+// Store objects are still written to the store directory,
+// so this is best paired with a temporary store directory.
+const MemoryDatabase = "memory://"
+
 // NewServer returns a new [Server] for the given store directory and database path.
+// If dbPath is [MemoryDatabase], then the server uses a private in-memory database.
 // Callers are responsible for calling [Server.Close] on the returned server.
 func NewServer(dir zbstore.Directory, dbPath string, opts *Options) *Server {
 	if opts == nil {
 		opts = new(Options)
 	}
-	users, err := newUserSet(opts.BuildUsers)
+	dbFlags := sqlite.OpenCreate | sqlite.OpenReadWrite
+	inMemory := dbPath == MemoryDatabase
+	if inMemory {
+		// The memdb VFS shares a database among connections
+		// that open the same name starting with a slash
+		// and frees it once the last connection closes.
+		dbPath = "file:/zb-" + uuid.NewString() + "?vfs=memdb"
+		dbFlags |= sqlite.OpenURI
+	}
+	users, err := newUserSet(opts.BuildUsers, opts.BuildUserRange)
 	if err != nil {
 		panic(err)
 	}
@@ -197,21 +409,37 @@ func NewServer(dir zbstore.Directory, dbPath string, opts *Options) *Server {
 		dir:             dir,
 		realDir:         opts.RealStoreDirectory,
 		buildDir:        opts.BuildDirectory,
+		buildTmpfsSize:  opts.BuildTmpfsSize,
 		logDir:          opts.LogDirectory,
 		caCreateTemp:    opts.ContentAddressBufferCreator,
 		allowKeepFailed: opts.AllowKeepFailed,
+		offline:         opts.Offline,
 		sandbox:         !opts.DisableSandbox && CanSandbox(),
 		sandboxPaths:    maps.Clone(opts.SandboxPaths),
+		hashedPaths:     slices.Clone(opts.HashedSandboxPaths),
+		determinism:     opts.Determinism,
+		preBuildHook:    opts.PreBuildHook,
+		postBuildHook:   opts.PostBuildHook,
 		coresPerBuild:   opts.CoresPerBuild,
 		users:           users,
+		scheduler:       newBuildScheduler(opts.MaxConcurrentBuilds, opts.MaxQueuedPerClient, opts.MaxClientPriority),
 		activeBuilds:    make(map[uuid.UUID]context.CancelFunc),
 		buildContext:    opts.BuildContext,
 		keyring:         opts.Keyring.Clone(),
+		auditLog:        opts.AuditLog,
+		builderID:       cmp.Or(opts.BuilderID, defaultBuilderID),
 		fallback:        opts.Fallback,
 		upload:          opts.Upload,
+		systemFeatures:  sets.New(opts.SystemFeatures...),
+		outputQuota: outputQuota{
+			maxSize:  opts.MaxOutputSize,
+			maxFiles: opts.MaxOutputFiles,
+		},
+		fetchTransport:   opts.FetchTransport,
+		fetchCredentials: opts.FetchCredentials,
 
 		db: sqlitemigration.NewPool(dbPath, loadSchema(), sqlitemigration.Options{
-			Flags:       sqlite.OpenCreate | sqlite.OpenReadWrite,
+			Flags:       dbFlags,
 			PrepareConn: prepareConn,
 			PoolSize:    opts.DatabasePoolSize,
 			OnStartMigrate: func() {
@@ -235,10 +463,23 @@ func NewServer(dir zbstore.Directory, dbPath string, opts *Options) *Server {
 	if srv.realDir == "" {
 		srv.realDir = string(srv.dir)
 	}
+	srv.caseInsensitive = sync.OnceValue(func() bool {
+		ctx := context.Background()
+		caseInsensitive, err := isCaseInsensitiveDir(srv.realDir)
+		if err != nil {
+			// Assume the platform's default filesystem behavior.
+			caseInsensitive = runtime.GOOS == "darwin" || runtime.GOOS == "windows"
+			log.Debugf(ctx, "Unable to determine whether %s is case-insensitive (assuming %t): %v", srv.realDir, caseInsensitive, err)
+		}
+		return caseInsensitive
+	})
 	if srv.buildDir == "" {
 		srv.buildDir = os.TempDir()
 	}
-	if srv.logDir == "" {
+	if srv.logDir == "" && inMemory {
+		srv.logDir = filepath.Join(os.TempDir(), "zb-log-"+uuid.NewString())
+		srv.removeLogDir = true
+	} else if srv.logDir == "" {
 		srv.logDir = filepath.Join(filepath.Dir(dbPath), "log")
 	}
 	if srv.caCreateTemp == nil {
@@ -252,6 +493,15 @@ func NewServer(dir zbstore.Directory, dbPath string, opts *Options) *Server {
 	if srv.fallback == nil {
 		srv.fallback = zbstore.Null{}
 	}
+	if _, isNull := srv.fallback.(zbstore.Null); !isNull {
+		srv.substituterMissTTL = opts.SubstituterMissTTL
+	}
+	srv.fallbackName = describeStore(srv.fallback)
+	if canPrefetch(srv.fallback) && !srv.offline {
+		srv.prefetcher = newPrefetchStore(srv.fallback)
+		srv.fallback = srv.prefetcher
+	}
+	srv.handler = jsonrpc.Intercept(srv.mux(), append(slices.Clone(opts.Interceptors), srv.interceptAudit, srv.interceptLaunchCheck)...)
 	srv.backgroundContext, srv.cancelBackground = context.WithCancel(context.Background())
 
 	srv.background.Go(func() {
@@ -261,6 +511,14 @@ func NewServer(dir zbstore.Directory, dbPath string, opts *Options) *Server {
 	srv.background.Go(func() {
 		srv.writeHeartbeat(srv.backgroundContext)
 	})
+	srv.background.Go(func() {
+		srv.pruneRealizationsPeriodically(srv.backgroundContext)
+	})
+	if srv.prefetcher != nil {
+		srv.background.Go(func() {
+			srv.prefetchLoop(srv.backgroundContext)
+		})
+	}
 	if opts.BuildLogRetention > 0 {
 		srv.background.Go(func() {
 			srv.gcLogs(srv.backgroundContext, opts.BuildLogRetention)
@@ -281,39 +539,150 @@ func (s *Server) Close() error {
 
 	s.background.Wait()
 
-	return s.db.Close()
+	err := s.db.Close()
+	if s.removeLogDir {
+		if rmErr := os.RemoveAll(s.logDir); err == nil {
+			err = rmErr
+		}
+	}
+	return err
+}
+
+// Drain stops the server from starting new builds
+// and waits for the builds in progress to finish.
+// If ctx.Done() is closed before the builds finish,
+// then Drain cancels them and returns ctx.Err()
+// once they have stopped.
+// After the builds have stopped,
+// Drain checkpoints the database
+// so that the database file is self-contained
+// (e.g. for another process to take over the store).
+// The server continues to respond to other requests
+// until [Server.Close] is called.
+func (s *Server) Drain(ctx context.Context) error {
+	s.activeBuildsMu.Lock()
+	s.draining = true
+	n := len(s.activeBuilds)
+	s.activeBuildsMu.Unlock()
+	if n > 0 {
+		log.Infof(ctx, "Waiting for %d builds to finish...", n)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.runningBuilds.Wait()
+		close(done)
+	}()
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+		s.activeBuildsMu.Lock()
+		log.Warnf(ctx, "Canceling %d builds...", len(s.activeBuilds))
+		for _, cancel := range s.activeBuilds {
+			cancel()
+		}
+		s.activeBuildsMu.Unlock()
+		<-done
+	}
+
+	checkpointErr := func() error {
+		ctx, cancel := xcontext.KeepAlive(ctx, 30*time.Second)
+		defer cancel()
+		conn, err := s.db.Get(ctx)
+		if err != nil {
+			return err
+		}
+		defer s.db.Put(conn)
+		return sqlitex.ExecuteTransient(conn, "PRAGMA wal_checkpoint(TRUNCATE);", nil)
+	}()
+	if checkpointErr != nil {
+		return fmt.Errorf("drain: checkpoint database: %v", checkpointErr)
+	}
+	if err != nil {
+		return fmt.Errorf("drain: %w", err)
+	}
+	return nil
 }
 
 // JSONRPC implements the [jsonrpc.Handler] interface
 // and serves the [zbstorerpc] API.
 func (s *Server) JSONRPC(ctx context.Context, req *jsonrpc.Request) (*jsonrpc.Response, error) {
+	return s.handler.JSONRPC(ctx, req)
+}
+
+// interceptLaunchCheck is a [jsonrpc.Interceptor]
+// that fails requests until [Server.LaunchCheck] succeeds.
+func (s *Server) interceptLaunchCheck(ctx context.Context, req *jsonrpc.Request, next jsonrpc.Handler) (*jsonrpc.Response, error) {
 	if err := s.LaunchCheck(ctx); err != nil {
 		return nil, err
 	}
+	return next.JSONRPC(ctx, req)
+}
 
+// mux returns the handler for the [zbstorerpc] methods.
+func (s *Server) mux() jsonrpc.ServeMux {
 	return jsonrpc.ServeMux{
-		zbstorerpc.ExistsMethod:         jsonrpc.HandlerFunc(s.exists),
-		zbstorerpc.InfoMethod:           jsonrpc.HandlerFunc(s.info),
-		zbstorerpc.ExportMethod:         jsonrpc.HandlerFunc(s.export),
-		zbstorerpc.ExpandMethod:         jsonrpc.HandlerFunc(s.expand),
-		zbstorerpc.RealizeMethod:        jsonrpc.HandlerFunc(s.realize),
-		zbstorerpc.GetBuildMethod:       jsonrpc.HandlerFunc(s.getBuild),
-		zbstorerpc.GetBuildResultMethod: jsonrpc.HandlerFunc(s.getBuildResult),
-		zbstorerpc.CancelBuildMethod:    jsonrpc.HandlerFunc(s.cancelBuild),
-		zbstorerpc.ReadLogMethod:        jsonrpc.HandlerFunc(s.readLog),
+		zbstorerpc.HandshakeMethod:          jsonrpc.HandlerFunc(s.handshake),
+		zbstorerpc.ExistsMethod:             jsonrpc.HandlerFunc(s.exists),
+		zbstorerpc.InfoMethod:               jsonrpc.HandlerFunc(s.info),
+		zbstorerpc.PathInfoMethod:           jsonrpc.HandlerFunc(s.pathInfoDetails),
+		zbstorerpc.ExportMethod:             jsonrpc.HandlerFunc(s.export),
+		zbstorerpc.ExpandMethod:             jsonrpc.HandlerFunc(s.expand),
+		zbstorerpc.RealizeMethod:            jsonrpc.HandlerFunc(s.realize),
+		zbstorerpc.GetBuildMethod:           jsonrpc.HandlerFunc(s.getBuild),
+		zbstorerpc.GetBuildResultMethod:     jsonrpc.HandlerFunc(s.getBuildResult),
+		zbstorerpc.CancelBuildMethod:        jsonrpc.HandlerFunc(s.cancelBuild),
+		zbstorerpc.PrefetchMethod:           jsonrpc.HandlerFunc(s.prefetch),
+		zbstorerpc.ReadLogMethod:            jsonrpc.HandlerFunc(s.readLog),
+		zbstorerpc.AttestationsMethod:       jsonrpc.HandlerFunc(s.attestations),
+		zbstorerpc.AddRootMethod:            jsonrpc.HandlerFunc(s.addRoot),
+		zbstorerpc.RealizationsMethod:       jsonrpc.HandlerFunc(s.realizations),
+		zbstorerpc.RealizationsByPathMethod: jsonrpc.HandlerFunc(s.realizationsByPath),
+		zbstorerpc.ReferrersMethod:          jsonrpc.HandlerFunc(s.referrers),
+		zbstorerpc.AddSignaturesMethod:      jsonrpc.HandlerFunc(s.addSignatures),
+		zbstorerpc.PruneRealizationsMethod:  jsonrpc.HandlerFunc(s.pruneRealizations),
+
+		zbstorerpc.ClearSubstituterMissesMethod: jsonrpc.HandlerFunc(s.clearSubstituterMisses),
 
 		zbstorerpc.NopMethod: jsonrpc.HandlerFunc(func(ctx context.Context, req *jsonrpc.Request) (*jsonrpc.Response, error) {
 			return &jsonrpc.Response{
 				Result: jsontext.Value("null"),
 			}, nil
 		}),
-	}.JSONRPC(ctx, req)
+	}
 }
 
 func (s *Server) realPath(path zbstore.Path) string {
 	return filepath.Join(s.realDir, path.Base())
 }
 
+func (s *Server) handshake(ctx context.Context, req *jsonrpc.Request) (*jsonrpc.Response, error) {
+	var args zbstorerpc.HandshakeRequest
+	if err := jsonv2.Unmarshal(req.Params, &args); err != nil {
+		return nil, jsonrpc.Error(jsonrpc.InvalidParams, err)
+	}
+	log.Debugf(ctx, "Client speaks protocol version %d with capabilities %q", args.ProtocolVersion, args.Capabilities)
+	return marshalResponse(&zbstorerpc.HandshakeResponse{
+		ProtocolVersion: zbstorerpc.ProtocolVersion,
+		Capabilities:    serverCapabilities(),
+		SystemFeatures:  slices.Sorted(s.systemFeatures.All()),
+	})
+}
+
+// serverCapabilities returns the capabilities that the server advertises
+// in its handshake response.
+func serverCapabilities() []zbstorerpc.Capability {
+	caps := zbstorerpc.Capabilities()
+	if !canTraceExec() {
+		caps = slices.DeleteFunc(caps, func(c zbstorerpc.Capability) bool {
+			return c == zbstorerpc.CapabilityExecTrace || c == zbstorerpc.CapabilityInputUsage
+		})
+	}
+	return caps
+}
+
 func (s *Server) exists(ctx context.Context, req *jsonrpc.Request) (*jsonrpc.Response, error) {
 	var args zbstorerpc.ExistsRequest
 	if err := jsonv2.Unmarshal(req.Params, &args); err != nil {
@@ -539,7 +908,17 @@ func (s *Server) readLog(ctx context.Context, req *jsonrpc.Request) (*jsonrpc.Re
 		return nil, newNotFoundError()
 	}
 
-	f, openError := os.Open(builderLogPath(s.logDir, buildID, args.DrvPath))
+	logPath := builderLogPath(s.logDir, buildID, args.DrvPath)
+	switch {
+	case args.Trace:
+		logPath = builderTracePath(s.logDir, buildID, args.DrvPath)
+	case args.Prefixed:
+		prefixedLogPath := prefixedBuilderLogPath(s.logDir, buildID, args.DrvPath)
+		if _, err := os.Lstat(prefixedLogPath); err == nil {
+			logPath = prefixedLogPath
+		}
+	}
+	f, openError := os.Open(logPath)
 	if errors.Is(openError, os.ErrNotExist) {
 		conn, err := s.db.Get(ctx)
 		if err != nil {
@@ -790,6 +1169,15 @@ func (s *Server) delete(ctx context.Context, paths sets.Set[zbstore.Path], recur
 		// Reverse topological sort.
 		allPaths = make([]zbstore.Path, 0, paths.Len()+reverseDeps.Len())
 		allPaths = slices.AppendSeq(allPaths, xiter.Chain(paths.All(), reverseDeps.All()))
+		roots, err := s.liveRoots(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, path := range allPaths {
+			if links := roots[path]; len(links) > 0 {
+				return fmt.Errorf("%s is in use by root %s", path, links[0])
+			}
+		}
 		references := make(map[zbstore.Path]sets.Sorted[zbstore.Path], len(allPaths))
 		for _, path := range allPaths {
 			var err error
@@ -857,11 +1245,19 @@ func (s *Server) delete(ctx context.Context, paths sets.Set[zbstore.Path], recur
 	if err != nil {
 		return err
 	}
+	event := &AuditEvent{
+		Action: AuditDelete,
+		Paths:  allPaths,
+	}
+	defer func() {
+		event.setError(err)
+		s.audit(ctx, event)
+	}()
 
 	ok := true
 	for _, path := range allPaths {
 		log.Debugf(ctx, "Deleting store object %s...", path)
-		if err := os.RemoveAll(s.realPath(path)); err != nil {
+		if err := osutil.ForceRemoveAll(s.realPath(path)); err != nil {
 			log.Errorf(ctx, "Failed to delete %s: %v", path, err)
 			ok = false
 		}
@@ -876,9 +1272,13 @@ func (s *Server) delete(ctx context.Context, paths sets.Set[zbstore.Path], recur
 // that are not present in the store directory
 // from the fallback store.
 // The [equivalenceClass] values are used in error messages.
+// copyFromFallback returns the set of store objects that it imported,
+// even if it returns an error.
 // If any of the store objects could not be downloaded, then copyFromFallback will return an error.
 // If copyFromFallback returns an error, it will always be a [copyFromFallbackError].
-func (s *Server) copyFromFallback(ctx context.Context, conn *sqlite.Conn, paths iter.Seq[pathAndEquivalenceClass]) (err error) {
+// If offline is true, then copyFromFallback returns an error
+// instead of downloading any store objects.
+func (s *Server) copyFromFallback(ctx context.Context, conn *sqlite.Conn, paths iter.Seq[pathAndEquivalenceClass], offline bool) (imported sets.Set[zbstore.Path], err error) {
 	defer func() {
 		if err != nil {
 			err = copyFromFallbackError{err}
@@ -905,7 +1305,7 @@ func (s *Server) copyFromFallback(ctx context.Context, conn *sqlite.Conn, paths
 		log.Debugf(ctx, "Waiting for lock on %s (output of %v)...", pe.path, pe.equivalenceClass)
 		unlockInput, err := s.writing.lock(ctx, pe.path)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		_, err = os.Lstat(s.realPath(pe.path))
 		unlockInput()
@@ -922,7 +1322,11 @@ func (s *Server) copyFromFallback(ctx context.Context, conn *sqlite.Conn, paths
 	}
 
 	if len(storePathsToDownload) == 0 {
-		return nil
+		return nil, nil
+	}
+	if offline {
+		return nil, fmt.Errorf("%s not present and cannot be downloaded: %w",
+			joinStrings(slices.Sorted(maps.Keys(storePathsToDownload)), ", "), errOffline)
 	}
 
 	pr, pw := io.Pipe()
@@ -942,12 +1346,13 @@ func (s *Server) copyFromFallback(ctx context.Context, conn *sqlite.Conn, paths
 	pr.CloseWithError(receiveError)
 	exportError := <-exportFinished
 	if exportError != nil {
-		return fmt.Errorf("failed to copy from fallback store: %v", exportError)
+		return nil, fmt.Errorf("failed to copy from fallback store: %v", exportError)
 	}
 	if receiveError != nil {
-		return fmt.Errorf("failed to copy from fallback store: %v", receiveError)
+		return nil, fmt.Errorf("failed to copy from fallback store: %v", receiveError)
 	}
 
+	imported = make(sets.Set[zbstore.Path])
 	var ec multierror.Collector
 	for path, eqClassesForPath := range storePathsToDownload {
 		if !pathRecorder.paths.Has(path) {
@@ -964,7 +1369,7 @@ func (s *Server) copyFromFallback(ctx context.Context, conn *sqlite.Conn, paths
 		log.Debugf(ctx, "Waiting for lock on %s (%v)...", path, eqClassesForPath)
 		unlockInput, err := s.writing.lock(ctx, path)
 		if err != nil {
-			return err
+			return imported, err
 		}
 		_, err = os.Lstat(s.realPath(path))
 		unlockInput()
@@ -977,10 +1382,12 @@ func (s *Server) copyFromFallback(ctx context.Context, conn *sqlite.Conn, paths
 				}
 			}
 			ec.Add(err)
+			continue
 		}
+		imported.Add(path)
 	}
 
-	return ec.Error()
+	return imported, ec.Error()
 }
 
 // uploadClosure uploads the store objects in the batch
@@ -1173,6 +1580,10 @@ func (s *Server) gcLogs(ctx context.Context, window time.Duration) {
 			log.Warnf(ctx, "Failed to clean up build logs: %v", err)
 		} else if n > 0 {
 			log.Infof(ctx, "Deleted %d build logs older than %v", n, cutoff.Truncate(time.Millisecond).UTC())
+			s.audit(ctx, &AuditEvent{
+				Action: AuditDeleteBuilds,
+				Count:  n,
+			})
 		} else {
 			log.Debugf(ctx, "No build logs to clean up.")
 		}
@@ -1311,6 +1722,21 @@ func (s *Server) optimizeDatabase(ctx context.Context) {
 	}
 }
 
+// describeStore returns a human-readable identifier for store
+// suitable for [zbstorerpc.OutputProvenance].
+// It returns the empty string if there is no reasonable description.
+func describeStore(store Store) string {
+	switch store := store.(type) {
+	case *zbstorehttp.Store:
+		if store.URL != nil {
+			return store.URL.Redacted()
+		}
+	case fmt.Stringer:
+		return store.String()
+	}
+	return ""
+}
+
 func parseBuildID(id string) (_ uuid.UUID, ok bool) {
 	u, err := uuid.Parse(id)
 	if err != nil || id != u.String() {
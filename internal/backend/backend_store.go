@@ -120,9 +120,14 @@ func validateOutputs(drv *zbstore.Derivation) error {
 				return fmt.Errorf("fixed-output derivations can only have a single output")
 			}
 		case outputType.IsFloating():
-			if t, ok := outputType.HashType(); !ok || t != nix.SHA256 || !outputType.IsRecursiveFile() {
-				return fmt.Errorf("floating output %s must use %v and be recursive (uses %v and recursive=%t)",
-					outputName, nix.SHA256, t, outputType.IsRecursiveFile())
+			t, ok := outputType.HashType()
+			if !ok {
+				return fmt.Errorf("floating output %s does not have a hash algorithm", outputName)
+			}
+			// Flat file outputs may use any hash algorithm
+			// so that they can match externally published hashes.
+			if (outputType.IsRecursiveFile() || outputType.IsText()) && t != nix.SHA256 {
+				return fmt.Errorf("floating output %s must use %v (uses %v)", outputName, nix.SHA256, t)
 			}
 		default:
 			return fmt.Errorf("output %s is neither fixed nor floating", outputName)
@@ -530,10 +535,11 @@ func insertObject(ctx context.Context, conn *sqlite.Conn, info *ObjectInfo) (err
 	}
 	err = sqlitex.ExecuteTransientFS(conn, sqlFiles(), "insert_object.sql", &sqlitex.ExecOptions{
 		Named: map[string]any{
-			":path":     string(info.StorePath),
-			":nar_size": info.NARSize,
-			":nar_hash": info.NARHash.SRI(),
-			":ca":       info.CA.String(),
+			":path":          string(info.StorePath),
+			":nar_size":      info.NARSize,
+			":nar_hash":      info.NARHash.SRI(),
+			":ca":            info.CA.String(),
+			":registered_at": time.Now().UnixMilli(),
 		},
 	})
 	if sqlite.ErrCode(err) == sqlite.ResultConstraintRowID {
@@ -729,7 +735,7 @@ func recordExpandResult(conn *sqlite.Conn, buildID uuid.UUID, result *zbstorerpc
 	return nil
 }
 
-func insertBuildResult(conn *sqlite.Conn, buildID uuid.UUID, drvPath zbstore.Path, drvHash nix.Hash, t time.Time) (buildResultID int64, err error) {
+func insertBuildResult(conn *sqlite.Conn, buildID uuid.UUID, drvPath zbstore.Path, drvHash nix.Hash, t time.Time, scheduleSeq int) (buildResultID int64, err error) {
 	defer sqlitex.Save(conn)(&err)
 	if err := upsertPath(conn, drvPath); err != nil {
 		return -1, fmt.Errorf("record build result for %s in %v: %v", drvPath, buildID, err)
@@ -744,6 +750,7 @@ func insertBuildResult(conn *sqlite.Conn, buildID uuid.UUID, drvPath zbstore.Pat
 			":drv_hash_algorithm": drvHash.Type().String(),
 			":drv_hash_bits":      drvHash.Bytes(nil),
 			":timestamp_millis":   t.UnixMilli(),
+			":schedule_seq":       scheduleSeq,
 		},
 		ResultFunc: func(stmt *sqlite.Stmt) error {
 			buildResultID = stmt.ColumnInt64(0)
@@ -767,6 +774,16 @@ func findBuildResults(dst []*zbstorerpc.BuildResult, conn *sqlite.Conn, logDir s
 		return dst, fmt.Errorf("list build results for %v: %v", buildID, err)
 	}
 	defer signatureStmt.Finalize()
+	phaseStmt, err := sqlitex.PrepareTransientFS(conn, sqlFiles(), "build/result_phases.sql")
+	if err != nil {
+		return dst, fmt.Errorf("list build results for %v: %v", buildID, err)
+	}
+	defer phaseStmt.Finalize()
+	inputStmt, err := sqlitex.PrepareTransientFS(conn, sqlFiles(), "build/result_inputs.sql")
+	if err != nil {
+		return dst, fmt.Errorf("list build results for %v: %v", buildID, err)
+	}
+	defer inputStmt.Finalize()
 	initDstLen := len(dst)
 	err = sqlitex.ExecuteTransientFS(conn, sqlFiles(), "build/results.sql", &sqlitex.ExecOptions{
 		Named: map[string]any{
@@ -799,6 +816,32 @@ func findBuildResults(dst []*zbstorerpc.BuildResult, conn *sqlite.Conn, logDir s
 					Status:  zbstorerpc.BuildStatus(stmt.GetText("status")),
 					Outputs: []*zbstorerpc.RealizeOutput{},
 				}
+				if rawReason := stmt.GetText("rebuild_reason"); rawReason != "" {
+					curr.RebuildReason = new(zbstorerpc.RebuildReason)
+					if err := unmarshalJSONString(rawReason, curr.RebuildReason); err != nil {
+						return fmt.Errorf("rebuild reason for %s: %v", drvPath, err)
+					}
+				}
+				if stmt.ColumnType(stmt.ColumnIndex("schedule_seq")) != sqlite.TypeNull {
+					curr.Schedule = &zbstorerpc.BuildSchedule{
+						Seq:   int(stmt.GetInt64("schedule_seq")),
+						Built: stmt.ColumnType(stmt.ColumnIndex("builder_started_at")) != sqlite.TypeNull,
+					}
+					if stmt.ColumnType(stmt.ColumnIndex("build_uid")) != sqlite.TypeNull {
+						curr.Schedule.BuildUser = BuildUser{
+							UID: int(stmt.GetInt64("build_uid")),
+							GID: int(stmt.GetInt64("build_gid")),
+						}.String()
+					}
+				}
+				curr.Phases, err = phasesForBuildResult(phaseStmt, buildID, drvPath)
+				if err != nil {
+					return fmt.Errorf("%s: %v", drvPath, err)
+				}
+				curr.InputUsage, err = inputUsageForBuildResult(inputStmt, buildID, drvPath)
+				if err != nil {
+					return fmt.Errorf("%s: %v", drvPath, err)
+				}
 				if logDir != "" {
 					logInfo, err := os.Stat(builderLogPath(logDir, buildID, drvPath))
 					if err != nil && !errors.Is(err, os.ErrNotExist) {
@@ -821,6 +864,12 @@ func findBuildResults(dst []*zbstorerpc.BuildResult, conn *sqlite.Conn, logDir s
 						return fmt.Errorf("output %s: %v", outputName, err)
 					}
 					newOutput.Path = zbstorerpc.NonNull(p)
+					if method := stmt.GetText("provenance"); method != "" {
+						newOutput.Provenance = &zbstorerpc.OutputProvenance{
+							Method: zbstorerpc.ProvenanceMethod(method),
+							Store:  stmt.GetText("provenance_store"),
+						}
+					}
 
 					newOutput.Signatures, err = signaturesForRealization(signatureStmt, buildID, drvPath, outputName, p)
 					if err != nil {
@@ -882,11 +931,82 @@ func signaturesForRealization(stmt *sqlite.Stmt, buildID uuid.UUID, drvPath zbst
 	return result, nil
 }
 
-func recordBuilderStart(conn *sqlite.Conn, buildResultID int64, t time.Time) error {
+// inputUsageForBuildResult returns the recorded inputs for the given build result
+// or nil if the build did not record inputs.
+func inputUsageForBuildResult(stmt *sqlite.Stmt, buildID uuid.UUID, drvPath zbstore.Path) (*zbstorerpc.InputUsage, error) {
+	var result *zbstorerpc.InputUsage
+	stmt.SetText(":build_id", buildID.String())
+	stmt.SetText(":drv_path", string(drvPath))
+
+	for {
+		hasRow, err := stmt.Step()
+		if err != nil {
+			_ = stmt.Reset()
+			return nil, fmt.Errorf("inputs: %v", err)
+		}
+		if !hasRow {
+			break
+		}
+		p, err := zbstore.ParsePath(stmt.GetText("input_path"))
+		if err != nil {
+			_ = stmt.Reset()
+			return nil, fmt.Errorf("inputs: %v", err)
+		}
+		if result == nil {
+			result = &zbstorerpc.InputUsage{
+				Used:   []zbstore.Path{},
+				Unused: []zbstore.Path{},
+			}
+		}
+		if stmt.GetBool("used") {
+			result.Used = append(result.Used, p)
+		} else {
+			result.Unused = append(result.Unused, p)
+		}
+	}
+	if err := stmt.Reset(); err != nil {
+		return result, fmt.Errorf("inputs: %v", err)
+	}
+	return result, nil
+}
+
+func phasesForBuildResult(stmt *sqlite.Stmt, buildID uuid.UUID, drvPath zbstore.Path) ([]*zbstorerpc.BuildPhase, error) {
+	var result []*zbstorerpc.BuildPhase
+	stmt.SetText(":build_id", buildID.String())
+	stmt.SetText(":drv_path", string(drvPath))
+
+	for {
+		hasRow, err := stmt.Step()
+		if err != nil {
+			_ = stmt.Reset()
+			return nil, fmt.Errorf("phases: %v", err)
+		}
+		if !hasRow {
+			break
+		}
+		result = append(result, &zbstorerpc.BuildPhase{
+			Name:      stmt.GetText("name"),
+			StartedAt: time.UnixMilli(stmt.GetInt64("started_at")),
+			EndedAt:   time.UnixMilli(stmt.GetInt64("ended_at")),
+		})
+	}
+	if err := stmt.Reset(); err != nil {
+		return result, fmt.Errorf("phases: %v", err)
+	}
+	return result, nil
+}
+
+func recordBuilderStart(conn *sqlite.Conn, buildResultID int64, t time.Time, buildUser *BuildUser) error {
+	var uid, gid any
+	if buildUser != nil {
+		uid, gid = buildUser.UID, buildUser.GID
+	}
 	err := sqlitex.ExecuteTransientFS(conn, sqlFiles(), "build/set_builder_start.sql", &sqlitex.ExecOptions{
 		Named: map[string]any{
 			":id":               buildResultID,
 			":timestamp_millis": t.UnixMilli(),
+			":build_uid":        uid,
+			":build_gid":        gid,
 		},
 	})
 	if err != nil {
@@ -895,9 +1015,99 @@ func recordBuilderStart(conn *sqlite.Conn, buildResultID int64, t time.Time) err
 	return nil
 }
 
+// scheduleDecision is a derivation's scheduling decisions
+// read by [findBuildSchedule].
+type scheduleDecision struct {
+	seq       int
+	built     bool
+	buildUser *BuildUser
+}
+
+// findBuildSchedule returns the scheduling decisions recorded
+// for the build with the given ID.
+func findBuildSchedule(conn *sqlite.Conn, buildID uuid.UUID) (map[zbstore.Path]*scheduleDecision, error) {
+	schedule := make(map[zbstore.Path]*scheduleDecision)
+	err := sqlitex.ExecuteTransientFS(conn, sqlFiles(), "build/schedule.sql", &sqlitex.ExecOptions{
+		Named: map[string]any{
+			":build_id": buildID.String(),
+		},
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			drvPath, err := zbstore.ParsePath(stmt.GetText("drv_path"))
+			if err != nil {
+				return err
+			}
+			d := &scheduleDecision{
+				seq:   int(stmt.GetInt64("schedule_seq")),
+				built: stmt.GetBool("built"),
+			}
+			if stmt.ColumnType(stmt.ColumnIndex("build_uid")) != sqlite.TypeNull {
+				d.buildUser = &BuildUser{
+					UID: int(stmt.GetInt64("build_uid")),
+					GID: int(stmt.GetInt64("build_gid")),
+				}
+			}
+			schedule[drvPath] = d
+			return nil
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("read schedule of build %v: %v", buildID, err)
+	}
+	return schedule, nil
+}
+
+func recordRebuildReason(conn *sqlite.Conn, buildResultID int64, reason *zbstorerpc.RebuildReason) error {
+	rawReason, err := marshalJSONString(reason)
+	if err != nil {
+		return fmt.Errorf("record rebuild reason: %v", err)
+	}
+	err = sqlitex.ExecuteTransientFS(conn, sqlFiles(), "build/set_rebuild_reason.sql", &sqlitex.ExecOptions{
+		Named: map[string]any{
+			":id":             buildResultID,
+			":rebuild_reason": rawReason,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("record rebuild reason: %v", err)
+	}
+	return nil
+}
+
+// findPreviousBuild returns the ID of the most recent successful build
+// that ran the builder for the derivation at drvPath
+// with a derivation hash other than drvHash.
+// Such a build usually realized the derivation's inputs itself,
+// so its results are likely to include the input derivations' outputs.
+// If there is no such build, findPreviousBuild returns the nil UUID.
+func findPreviousBuild(conn *sqlite.Conn, drvPath zbstore.Path, drvHash nix.Hash) (uuid.UUID, error) {
+	var buildID uuid.UUID
+	err := sqlitex.ExecuteTransientFS(conn, sqlFiles(), "build/previous_result.sql", &sqlitex.ExecOptions{
+		Named: map[string]any{
+			":drv_path":           string(drvPath),
+			":drv_hash_algorithm": drvHash.Type().String(),
+			":drv_hash_bits":      drvHash.Bytes(nil),
+		},
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			var err error
+			buildID, err = uuid.Parse(stmt.GetText("build_id"))
+			return err
+		},
+	})
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("find previous build of %s: %v", drvPath, err)
+	}
+	return buildID, nil
+}
+
+// buildResultOutput is the data recorded for a single output in a build result.
+type buildResultOutput struct {
+	path       zbstore.Path
+	provenance *zbstorerpc.OutputProvenance
+}
+
 // setBuildResultOutputs sets the outputs for the build result with the given ID.
 // If a path is empty, then the output's path will be null.
-func setBuildResultOutputs(conn *sqlite.Conn, buildResultID int64, outputs iter.Seq2[string, zbstore.Path]) (err error) {
+func setBuildResultOutputs(conn *sqlite.Conn, buildResultID int64, outputs iter.Seq2[string, buildResultOutput]) (err error) {
 	defer sqlitex.Save(conn)(&err)
 
 	err = sqlitex.ExecuteTransientFS(conn, sqlFiles(), "build/clear_outputs.sql", &sqlitex.ExecOptions{
@@ -916,7 +1126,8 @@ func setBuildResultOutputs(conn *sqlite.Conn, buildResultID int64, outputs iter.
 	defer stmt.Finalize()
 
 	stmt.SetInt64(":id", buildResultID)
-	for outputName, outputPath := range outputs {
+	for outputName, output := range outputs {
+		outputPath := output.path
 		if outputPath != "" {
 			if err := upsertPath(conn, outputPath); err != nil {
 				return fmt.Errorf("record build result %s -> %s: %v", outputName, outputPath, err)
@@ -925,6 +1136,13 @@ func setBuildResultOutputs(conn *sqlite.Conn, buildResultID int64, outputs iter.
 
 		stmt.SetText(":output_name", outputName)
 		stmt.SetText(":output_path", string(outputPath))
+		if output.provenance != nil {
+			stmt.SetText(":provenance", string(output.provenance.Method))
+			stmt.SetText(":provenance_store", output.provenance.Store)
+		} else {
+			stmt.SetText(":provenance", "")
+			stmt.SetText(":provenance_store", "")
+		}
 		var execErrors [2]error
 		_, execErrors[0] = stmt.Step()
 		execErrors[1] = stmt.Reset()
@@ -951,6 +1169,85 @@ func recordBuilderEnd(conn *sqlite.Conn, buildResultID int64, t time.Time) error
 	return nil
 }
 
+// setBuildResultPhases replaces the phases for the build result with the given ID.
+func setBuildResultPhases(conn *sqlite.Conn, buildResultID int64, phases []*zbstorerpc.BuildPhase) (err error) {
+	defer sqlitex.Save(conn)(&err)
+
+	err = sqlitex.ExecuteTransientFS(conn, sqlFiles(), "build/clear_phases.sql", &sqlitex.ExecOptions{
+		Named: map[string]any{
+			":id": buildResultID,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("record build phases: %v", err)
+	}
+
+	stmt, err := sqlitex.PrepareTransientFS(conn, sqlFiles(), "build/insert_phase.sql")
+	if err != nil {
+		return fmt.Errorf("record build phases: %v", err)
+	}
+	defer stmt.Finalize()
+
+	stmt.SetInt64(":id", buildResultID)
+	for i, phase := range phases {
+		stmt.SetInt64(":seq", int64(i))
+		stmt.SetText(":name", phase.Name)
+		stmt.SetInt64(":started_at_millis", phase.StartedAt.UnixMilli())
+		stmt.SetInt64(":ended_at_millis", phase.EndedAt.UnixMilli())
+		var execErrors [2]error
+		_, execErrors[0] = stmt.Step()
+		execErrors[1] = stmt.Reset()
+		for _, err := range execErrors {
+			if err != nil {
+				return fmt.Errorf("record build phase %q: %v", phase.Name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// setBuildResultInputs records the inputs of the build result with the given ID
+// and whether the builder opened them.
+func setBuildResultInputs(conn *sqlite.Conn, buildResultID int64, usage *zbstorerpc.InputUsage) (err error) {
+	defer sqlitex.Save(conn)(&err)
+
+	stmt, err := sqlitex.PrepareTransientFS(conn, sqlFiles(), "build/insert_input.sql")
+	if err != nil {
+		return fmt.Errorf("record build inputs: %v", err)
+	}
+	defer stmt.Finalize()
+
+	stmt.SetInt64(":id", buildResultID)
+	insert := func(p zbstore.Path, used bool) error {
+		if err := upsertPath(conn, p); err != nil {
+			return fmt.Errorf("record build input %s: %v", p, err)
+		}
+		stmt.SetText(":input_path", string(p))
+		stmt.SetBool(":used", used)
+		var execErrors [2]error
+		_, execErrors[0] = stmt.Step()
+		execErrors[1] = stmt.Reset()
+		for _, err := range execErrors {
+			if err != nil {
+				return fmt.Errorf("record build input %s: %v", p, err)
+			}
+		}
+		return nil
+	}
+	for _, p := range usage.Used {
+		if err := insert(p, true); err != nil {
+			return err
+		}
+	}
+	for _, p := range usage.Unused {
+		if err := insert(p, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 type buildFinalResults struct {
 	buildID uuid.UUID
 	drvPath zbstore.Path
@@ -1012,37 +1309,52 @@ func builderLogPath(dir string, buildID uuid.UUID, drvPath zbstore.Path) string
 	return filepath.Join(dir, buildIDString[:4], buildIDString, name)
 }
 
-// createBuilderLog creates a new builder log file for writing.
-// If the log file already exists, createBuilderLog returns an error.
-func createBuilderLog(dir string, buildID uuid.UUID, drvPath zbstore.Path) (*os.File, error) {
+// prefixedBuilderLogPath returns the filesystem path for the build log with the given identifiers
+// whose lines start with a [zbstorerpc.LogPrefix].
+// Builds recorded by older versions of the store do not have such a log.
+func prefixedBuilderLogPath(dir string, buildID uuid.UUID, drvPath zbstore.Path) string {
+	return strings.TrimSuffix(builderLogPath(dir, buildID, drvPath), ".txt") + ".prefixed.txt"
+}
+
+// createBuilderLog creates new builder log files for writing:
+// one whose lines start with a [zbstorerpc.LogPrefix] and one without prefixes.
+// If either log file already exists, createBuilderLog returns an error.
+func createBuilderLog(dir string, buildID uuid.UUID, drvPath zbstore.Path) (prefixed, plain *os.File, err error) {
 	path := builderLogPath(dir, buildID, drvPath)
 	if err := os.MkdirAll(filepath.Dir(path), 0o777); err != nil {
-		return nil, fmt.Errorf("create log for %s in build %s: %v", drvPath.Base(), buildID, err)
+		return nil, nil, fmt.Errorf("create log for %s in build %s: %v", drvPath.Base(), buildID, err)
+	}
+	plain, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o666)
+	if err != nil {
+		return nil, nil, fmt.Errorf("create log for %s in build %s: %v", drvPath.Base(), buildID, err)
 	}
-	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o666)
+	prefixed, err = os.OpenFile(prefixedBuilderLogPath(dir, buildID, drvPath), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o666)
 	if err != nil {
-		return nil, fmt.Errorf("create log for %s in build %s: %v", drvPath.Base(), buildID, err)
+		plain.Close()
+		return nil, nil, fmt.Errorf("create log for %s in build %s: %v", drvPath.Base(), buildID, err)
 	}
-	return f, nil
+	return prefixed, plain, nil
 }
 
-// appendToBuilderLog writes data to the end of the builder log file.
+// appendToBuilderLog writes data to the end of the builder log files.
 func appendToBuilderLog(dir string, buildID uuid.UUID, drvPath zbstore.Path, data []byte) error {
 	path := builderLogPath(dir, buildID, drvPath)
 	if err := os.MkdirAll(filepath.Dir(path), 0o777); err != nil {
 		return fmt.Errorf("create log for %s in build %s: %v", drvPath.Base(), buildID, err)
 	}
-	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o666)
-	if err != nil {
-		return err
-	}
-	var logErrors [2]error
-	_, logErrors[0] = f.Write(data)
-	logErrors[1] = f.Close()
-	for _, err := range logErrors {
+	for _, path := range []string{path, prefixedBuilderLogPath(dir, buildID, drvPath)} {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o666)
 		if err != nil {
 			return err
 		}
+		var logErrors [2]error
+		_, logErrors[0] = f.Write(data)
+		logErrors[1] = f.Close()
+		for _, err := range logErrors {
+			if err != nil {
+				return err
+			}
+		}
 	}
 	return nil
 }
@@ -1115,11 +1427,16 @@ func prepareConn(conn *sqlite.Conn) error {
 }
 
 //go:embed sql/*.sql
+//go:embed sql/attestations/*.sql
 //go:embed sql/build/*.sql
 //go:embed sql/delete/*.sql
+//go:embed sql/path_info/*.sql
 //go:embed sql/realizations/*.sql
+//go:embed sql/referrers/*.sql
+//go:embed sql/roots/*.sql
 //go:embed sql/running_server/*.sql
 //go:embed sql/schema/*.sql
+//go:embed sql/substituter_misses/*.sql
 var rawSQLFiles embed.FS
 
 func sqlFiles() fs.FS {
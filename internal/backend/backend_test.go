@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"runtime"
 	"slices"
 	"testing"
 
@@ -369,6 +370,64 @@ func TestDelete(t *testing.T) {
 	}
 }
 
+func TestDeleteRoot(t *testing.T) {
+	ctx := testcontext.New(t)
+	dir := zbstore.DefaultDirectory()
+	exportBuffer := new(bytes.Buffer)
+	exporter := zbstore.NewExportWriter(exportBuffer)
+	storePath, _, err := storetest.ExportFlatFile(exporter, dir, "hello.txt", []byte("Hello, World!\n"), nix.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exporter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	server, client, err := backendtest.NewServer(ctx, t, dir, &backendtest.Options{
+		TempDir: t.TempDir(),
+		Options: Options{
+			RealStoreDirectory: t.TempDir(),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	codec, releaseCodec, err := storeCodec(ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = codec.Export(nil, bytes.NewReader(exportBuffer.Bytes()))
+	releaseCodec()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	link := filepath.Join(t.TempDir(), "result")
+	if err := os.Symlink(string(storePath), link); err != nil {
+		t.Fatal(err)
+	}
+	err = jsonrpc.Do(ctx, client, zbstorerpc.AddRootMethod, nil, &zbstorerpc.AddRootRequest{
+		Link: link,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := server.Delete(ctx, sets.New(storePath)); err == nil {
+		t.Fatal("Delete of rooted object did not return an error")
+	} else {
+		t.Log("delete error:", err)
+	}
+
+	// Once the link is gone, the object is no longer protected.
+	if err := os.Remove(link); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Delete(ctx, sets.New(storePath)); err != nil {
+		t.Error("Delete after removing root:", err)
+	}
+}
+
 // wantObjectInfo builds the expected [*zbstore.ObjectInfo]
 // for the given data, content address, and references.
 // It uses got.NARHash to determine the hashing algorithm to check against.
@@ -415,7 +474,43 @@ func storeCodec(ctx context.Context, client *jsonrpc.Client) (codec *zbstorerpc.
 	return codec, release, nil
 }
 
+func TestHandshake(t *testing.T) {
+	ctx := testcontext.New(t)
+	dir := backendtest.NewStoreDirectory(t)
+	_, client, err := backendtest.NewServer(ctx, t, dir, &backendtest.Options{
+		TempDir: t.TempDir(),
+		Options: Options{
+			SystemFeatures: []string{"kvm", "big-parallel"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := zbstorerpc.Handshake(ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.ProtocolVersion != zbstorerpc.ProtocolVersion {
+		t.Errorf("ProtocolVersion = %d; want %d", resp.ProtocolVersion, zbstorerpc.ProtocolVersion)
+	}
+	for _, c := range zbstorerpc.Capabilities() {
+		if (c == zbstorerpc.CapabilityExecTrace || c == zbstorerpc.CapabilityInputUsage) && runtime.GOOS != "linux" {
+			continue
+		}
+		if !resp.Has(c) {
+			t.Errorf("Capabilities = %q; missing %q", resp.Capabilities, c)
+		}
+	}
+	if want := []string{"big-parallel", "kvm"}; !slices.Equal(resp.SystemFeatures, want) {
+		t.Errorf("SystemFeatures = %q; want %q", resp.SystemFeatures, want)
+	}
+}
+
 func TestMain(m *testing.M) {
+	if exitCode, isHelper := RunSandboxHelper(); isHelper {
+		os.Exit(exitCode)
+	}
 	testlog.Main(nil)
 	os.Exit(m.Run())
 }
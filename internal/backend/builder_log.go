@@ -0,0 +1,201 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package backend
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"time"
+
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+)
+
+// builderLogWriter writes lines to a builder log,
+// prefixing each line with the time elapsed since the writer was created
+// and the stream the line was written to
+// (as formatted by [zbstorerpc.AppendLogPrefix]).
+// The same lines are written without prefixes to a second, plain log
+// for clients that do not understand the prefixes
+// (see [zbstorerpc.ReadLogRequest.Prefixed]).
+// builderLogWriter also records the phase markers in the lines.
+//
+// Lines are buffered per stream so that lines from different streams do not interleave.
+// Writes to the builderLogWriter itself use [zbstorerpc.LogStreamUnspecified];
+// use [*builderLogWriter.Stream] to obtain writers for the builder's output streams.
+// It is safe to write to a builderLogWriter and its streams from multiple goroutines.
+type builderLogWriter struct {
+	w     io.Writer
+	plain io.Writer
+	start time.Time
+	now   func() time.Time
+
+	mu       sync.Mutex
+	buf      []byte
+	plainBuf []byte
+	// midLine is true if the last byte written to w was not a newline.
+	midLine bool
+	// pending is the incomplete last line written to each stream.
+	pending map[zbstorerpc.LogStream]*pendingLogLine
+	phases  []builderPhase
+}
+
+// pendingLogLine is an incomplete line in a [builderLogWriter].
+type pendingLogLine struct {
+	start time.Duration
+	data  []byte
+}
+
+// builderPhase is a phase recorded by a [builderLogWriter].
+// Times are relative to the creation of the writer.
+type builderPhase struct {
+	name  string
+	start time.Duration
+}
+
+// maxPendingLogLine is the maximum number of bytes
+// that a [builderLogWriter] buffers for an incomplete line.
+// Longer lines are split.
+const maxPendingLogLine = 64 << 10
+
+func newBuilderLogWriter(w, plain io.Writer, start time.Time) *builderLogWriter {
+	return &builderLogWriter{
+		w:       w,
+		plain:   plain,
+		start:   start,
+		now:     time.Now,
+		pending: make(map[zbstorerpc.LogStream]*pendingLogLine),
+	}
+}
+
+// Stream returns a writer that writes to the log
+// with lines attributed to the given stream.
+func (lw *builderLogWriter) Stream(stream zbstorerpc.LogStream) io.Writer {
+	return builderLogStream{lw, stream}
+}
+
+type builderLogStream struct {
+	lw     *builderLogWriter
+	stream zbstorerpc.LogStream
+}
+
+func (s builderLogStream) Write(p []byte) (int, error) {
+	return s.lw.write(s.stream, p)
+}
+
+// Write writes p to the log
+// with lines attributed to [zbstorerpc.LogStreamUnspecified].
+func (lw *builderLogWriter) Write(p []byte) (int, error) {
+	return lw.write(zbstorerpc.LogStreamUnspecified, p)
+}
+
+func (lw *builderLogWriter) write(stream zbstorerpc.LogStream, p []byte) (int, error) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+
+	line := lw.pending[stream]
+	if line == nil {
+		line = new(pendingLogLine)
+		lw.pending[stream] = line
+	}
+	lw.buf = lw.buf[:0]
+	lw.plainBuf = lw.plainBuf[:0]
+	for rest := p; len(rest) > 0; {
+		if len(line.data) == 0 {
+			line.start = lw.now().Sub(lw.start)
+		}
+		chunk := rest
+		if i := bytes.IndexByte(rest, '\n'); i >= 0 {
+			chunk = rest[:i+1]
+		}
+		rest = rest[len(chunk):]
+		line.data = append(line.data, chunk...)
+		if chunk[len(chunk)-1] == '\n' || len(line.data) >= maxPendingLogLine {
+			lw.appendLine(stream, line)
+		}
+	}
+
+	if err := lw.flushBuffer(); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Flush writes any incomplete lines to the log.
+func (lw *builderLogWriter) Flush() error {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+
+	lw.buf = lw.buf[:0]
+	lw.plainBuf = lw.plainBuf[:0]
+	for _, stream := range []zbstorerpc.LogStream{
+		zbstorerpc.LogStreamUnspecified,
+		zbstorerpc.LogStreamStdout,
+		zbstorerpc.LogStreamStderr,
+	} {
+		if line := lw.pending[stream]; line != nil && len(line.data) > 0 {
+			lw.appendLine(stream, line)
+		}
+	}
+	return lw.flushBuffer()
+}
+
+// appendLine appends the given line to lw.buf and lw.plainBuf and clears it.
+// The caller must hold lw.mu.
+func (lw *builderLogWriter) appendLine(stream zbstorerpc.LogStream, line *pendingLogLine) {
+	if lw.midLine {
+		// Every line in the log must start with a prefix.
+		lw.buf = append(lw.buf, '\n')
+	}
+	lw.buf = zbstorerpc.AppendLogPrefix(lw.buf, zbstorerpc.LogPrefix{
+		Elapsed: line.start,
+		Stream:  stream,
+	})
+	lw.buf = append(lw.buf, line.data...)
+	lw.plainBuf = append(lw.plainBuf, line.data...)
+	lw.midLine = line.data[len(line.data)-1] != '\n'
+	if name, ok := zbstorerpc.ParsePhaseMarker(line.data); ok {
+		lw.phases = append(lw.phases, builderPhase{
+			name:  name,
+			start: line.start,
+		})
+	}
+	line.data = line.data[:0]
+}
+
+// flushBuffer writes lw.buf and lw.plainBuf to the underlying writers.
+// The caller must hold lw.mu.
+func (lw *builderLogWriter) flushBuffer() error {
+	if len(lw.buf) == 0 {
+		return nil
+	}
+	if _, err := lw.w.Write(lw.buf); err != nil {
+		return err
+	}
+	_, err := lw.plain.Write(lw.plainBuf)
+	return err
+}
+
+// Phases returns the phases recorded by the writer
+// as absolute times, with the last phase ending at end.
+func (lw *builderLogWriter) Phases(end time.Time) []*zbstorerpc.BuildPhase {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+
+	if len(lw.phases) == 0 {
+		return nil
+	}
+	result := make([]*zbstorerpc.BuildPhase, len(lw.phases))
+	for i, phase := range lw.phases {
+		result[i] = &zbstorerpc.BuildPhase{
+			Name:      phase.name,
+			StartedAt: lw.start.Add(phase.start),
+			EndedAt:   end,
+		}
+		if i > 0 {
+			result[i-1].EndedAt = result[i].StartedAt
+		}
+	}
+	return result
+}
@@ -0,0 +1,93 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package backend
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+)
+
+func TestBuilderLogWriter(t *testing.T) {
+	start := time.Date(2026, time.January, 2, 3, 4, 5, 0, time.UTC)
+	now := start
+	buf := new(strings.Builder)
+	plainBuf := new(strings.Builder)
+	w := newBuilderLogWriter(buf, plainBuf, start)
+	w.now = func() time.Time { return now }
+	stdout := w.Stream(zbstorerpc.LogStreamStdout)
+	stderr := w.Stream(zbstorerpc.LogStreamStderr)
+
+	writes := []struct {
+		at   time.Duration
+		w    io.Writer
+		data string
+	}{
+		{0, w, "pre-build hook\n"},
+		{0, stdout, "hello"},
+		{5 * time.Millisecond, stderr, "warning: "},
+		{10 * time.Millisecond, stdout, " world\n@zb-pha"},
+		{15 * time.Millisecond, stderr, "careful\n"},
+		{20 * time.Millisecond, stdout, "se configure\n"},
+		{1500 * time.Millisecond, stdout, "checking...\nok\n"},
+		{2 * time.Second, stdout, "@zb-phase install\n"},
+		{2500 * time.Millisecond, stdout, "no newline"},
+		{2600 * time.Millisecond, stderr, "also no newline"},
+	}
+	for _, write := range writes {
+		now = start.Add(write.at)
+		if n, err := write.w.Write([]byte(write.data)); n != len(write.data) || err != nil {
+			t.Errorf("Write(%q) = %d, %v; want %d, <nil>", write.data, n, err, len(write.data))
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Error("Flush:", err)
+	}
+
+	const wantLog = "[0.000] pre-build hook\n" +
+		"[0.000 out] hello world\n" +
+		"[0.005 err] warning: careful\n" +
+		"[0.010 out] @zb-phase configure\n" +
+		"[1.500 out] checking...\n" +
+		"[1.500 out] ok\n" +
+		"[2.000 out] @zb-phase install\n" +
+		"[2.500 out] no newline\n" +
+		"[2.600 err] also no newline"
+	if got := buf.String(); got != wantLog {
+		t.Errorf("log:\n%s\nwant:\n%s", got, wantLog)
+	}
+	const wantPlainLog = "pre-build hook\n" +
+		"hello world\n" +
+		"warning: careful\n" +
+		"@zb-phase configure\n" +
+		"checking...\n" +
+		"ok\n" +
+		"@zb-phase install\n" +
+		"no newline" +
+		"also no newline"
+	if got := plainBuf.String(); got != wantPlainLog {
+		t.Errorf("plain log:\n%s\nwant:\n%s", got, wantPlainLog)
+	}
+
+	end := start.Add(3 * time.Second)
+	wantPhases := []*zbstorerpc.BuildPhase{
+		{
+			Name:      "configure",
+			StartedAt: start.Add(10 * time.Millisecond),
+			EndedAt:   start.Add(2 * time.Second),
+		},
+		{
+			Name:      "install",
+			StartedAt: start.Add(2 * time.Second),
+			EndedAt:   end,
+		},
+	}
+	if diff := cmp.Diff(wantPhases, w.Phases(end)); diff != "" {
+		t.Errorf("phases (-want +got):\n%s", diff)
+	}
+}
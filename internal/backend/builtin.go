@@ -14,14 +14,18 @@ import (
 	"fmt"
 	"io"
 	"io/fs"
+	"maps"
 	"net/http"
 	"os"
 	slashpath "path"
 	"path/filepath"
+	"runtime/debug"
 	"strings"
 
+	"zb.256lights.llc/pkg/internal/credentials"
 	"zb.256lights.llc/pkg/internal/osutil"
 	"zb.256lights.llc/pkg/internal/useragent"
+	"zb.256lights.llc/pkg/zbbuiltin"
 	"zb.256lights.llc/pkg/zbstore"
 	"zombiezen.com/go/log"
 )
@@ -36,23 +40,80 @@ const (
 func runBuiltin(ctx context.Context, invocation *builderInvocation) error {
 	switch invocation.derivation.Builder {
 	case builtinBuilderPrefix + "fetchurl":
-		if err := fetchURL(ctx, invocation.derivation, invocation.realStoreDir); err != nil {
-			fmt.Fprintf(invocation.logWriter, "%s: %v\n", invocation.derivation.Builder, err)
+		if err := fetchURL(ctx, invocation.derivation, invocation.realStoreDir, invocation.fetchTransport, invocation.fetchCredentials); err != nil {
+			fmt.Fprintf(invocation.stderr, "%s: %v\n", invocation.derivation.Builder, err)
 			return builderFailure{fmt.Errorf("%s failed", invocation.derivation.Builder)}
 		}
 		return nil
 	case builtinBuilderPrefix + "extract":
-		if err := extract(ctx, invocation.derivation, invocation.realStoreDir); err != nil {
-			fmt.Fprintf(invocation.logWriter, "%s: %v\n", invocation.derivation.Builder, err)
+		if err := extract(ctx, invocation.derivation, invocation.realStoreDir, invocation.caseInsensitive); err != nil {
+			fmt.Fprintf(invocation.stderr, "%s: %v\n", invocation.derivation.Builder, err)
+			return builderFailure{fmt.Errorf("%s failed", invocation.derivation.Builder)}
+		}
+		return nil
+	case builtinBuilderPrefix + "compose":
+		if err := composeTree(invocation.derivation, invocation.realStoreDir); err != nil {
+			fmt.Fprintf(invocation.stderr, "%s: %v\n", invocation.derivation.Builder, err)
+			return builderFailure{fmt.Errorf("%s failed", invocation.derivation.Builder)}
+		}
+		return nil
+	case builtinBuilderPrefix + "patch":
+		if err := applyPatches(invocation.derivation, invocation.realStoreDir); err != nil {
+			fmt.Fprintf(invocation.stderr, "%s: %v\n", invocation.derivation.Builder, err)
+			return builderFailure{fmt.Errorf("%s failed", invocation.derivation.Builder)}
+		}
+		return nil
+	case builtinBuilderPrefix + "substitute":
+		if err := substitute(invocation.derivation, invocation.realStoreDir); err != nil {
+			fmt.Fprintf(invocation.stderr, "%s: %v\n", invocation.derivation.Builder, err)
 			return builderFailure{fmt.Errorf("%s failed", invocation.derivation.Builder)}
 		}
 		return nil
 	default:
-		return builderFailure{fmt.Errorf("builtin %q not found", invocation.derivation.Builder)}
+		name, ok := strings.CutPrefix(invocation.derivation.Builder, builtinBuilderPrefix)
+		var f zbbuiltin.Func
+		if ok {
+			f = zbbuiltin.Lookup(name)
+		}
+		if f == nil {
+			return builderFailure{fmt.Errorf("builtin %q not found", invocation.derivation.Builder)}
+		}
+		if err := runRegisteredBuiltin(ctx, f, invocation); err != nil {
+			fmt.Fprintf(invocation.stderr, "%s: %v\n", invocation.derivation.Builder, err)
+			return builderFailure{fmt.Errorf("%s failed", invocation.derivation.Builder)}
+		}
+		return nil
 	}
 }
 
-func fetchURL(ctx context.Context, drv *zbstore.Derivation, realStoreDir string) error {
+// runRegisteredBuiltin calls a builder registered with [zbbuiltin.Register].
+// Panics in the builder are returned as errors
+// so that they fail the build instead of stopping the server.
+func runRegisteredBuiltin(ctx context.Context, f zbbuiltin.Func, invocation *builderInvocation) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			log.Errorf(ctx, "Builder %s panicked: %v\n%s", invocation.derivation.Builder, v, debug.Stack())
+			err = fmt.Errorf("panic: %v", v)
+		}
+	}()
+	return f(ctx, &zbbuiltin.Invocation{
+		Derivation:         invocation.derivation,
+		DerivationPath:     invocation.derivationPath,
+		OutputPaths:        maps.Clone(invocation.outputPaths),
+		RealStoreDirectory: invocation.realStoreDir,
+		BuildDirectory:     invocation.buildDir,
+		Stdout:             invocation.stdout,
+		Stderr:             invocation.stderr,
+		Cores:              invocation.cores,
+	})
+}
+
+// fetchURL downloads the derivation's url to its output using transport.
+// If transport is nil, then [http.DefaultTransport] is used.
+// If creds is not nil, it is consulted for credentials for the URL.
+// Credentials are only set on the outgoing request
+// so that they never appear in the derivation or in the build log.
+func fetchURL(ctx context.Context, drv *zbstore.Derivation, realStoreDir string, transport http.RoundTripper, creds credentials.Provider) error {
 	href := drv.Env["url"]
 	if href == "" {
 		return fmt.Errorf("missing url environment variable")
@@ -73,13 +134,22 @@ func fetchURL(ctx context.Context, drv *zbstore.Derivation, realStoreDir string)
 	}
 	req.Header.Set("User-Agent", useragent.String)
 	req.Header.Set("Accept", "*/*")
-	resp, err := http.DefaultClient.Do(req)
+	if _, hasPassword := req.URL.User.Password(); !hasPassword && creds != nil {
+		c, err := creds.Credentials(ctx, req.URL)
+		if err != nil {
+			return fmt.Errorf("find credentials for %s: %v", req.URL.Redacted(), err)
+		}
+		if c != nil {
+			c.SetAuthorization(req)
+		}
+	}
+	resp, err := (&http.Client{Transport: transport}).Do(req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
-		return fmt.Errorf("%s returned HTTP %s", href, resp.Status)
+		return fmt.Errorf("%s returned HTTP %s", req.URL.Redacted(), resp.Status)
 	}
 	perm := os.FileMode(0o644)
 	if executable {
@@ -100,7 +170,7 @@ func fetchURL(ctx context.Context, drv *zbstore.Derivation, realStoreDir string)
 	return nil
 }
 
-func extract(ctx context.Context, drv *zbstore.Derivation, realStoreDir string) error {
+func extract(ctx context.Context, drv *zbstore.Derivation, realStoreDir string, caseInsensitive bool) error {
 	src := strings.ReplaceAll(drv.Env["src"], string(drv.Dir), realStoreDir)
 	if !filepath.IsAbs(src) {
 		return fmt.Errorf("source %s is not absolute", src)
@@ -132,15 +202,16 @@ func extract(ctx context.Context, drv *zbstore.Derivation, realStoreDir string)
 	}
 	header = header[:n]
 
+	hazards := newCaseHazardChecker(caseInsensitive)
 	switch {
 	case hasTarMagic(header):
 		r := io.MultiReader(bytes.NewReader(header), archiveFile)
-		if err := extractTar(outputPath, r, stripFirstComponent); err != nil {
+		if err := extractTar(outputPath, r, stripFirstComponent, hazards); err != nil {
 			return fmt.Errorf("extract %s: %v", src, err)
 		}
 	case hasBzip2Magic(header):
 		r := bzip2.NewReader(io.MultiReader(bytes.NewReader(header), archiveFile))
-		if err := extractTar(outputPath, r, stripFirstComponent); err != nil {
+		if err := extractTar(outputPath, r, stripFirstComponent, hazards); err != nil {
 			return fmt.Errorf("extract %s: %v", src, err)
 		}
 	case hasGzipMagic(header):
@@ -148,7 +219,7 @@ func extract(ctx context.Context, drv *zbstore.Derivation, realStoreDir string)
 		if err != nil {
 			return fmt.Errorf("extract %s: %v", src, err)
 		}
-		if err := extractTar(outputPath, r, stripFirstComponent); err != nil {
+		if err := extractTar(outputPath, r, stripFirstComponent, hazards); err != nil {
 			return fmt.Errorf("extract %s: %v", src, err)
 		}
 	case hasZipMagic(header):
@@ -156,7 +227,7 @@ func extract(ctx context.Context, drv *zbstore.Derivation, realStoreDir string)
 		if err != nil {
 			return fmt.Errorf("read %s: %v", src, err)
 		}
-		if err := extractZip(outputPath, archiveFile, size, stripFirstComponent); err != nil {
+		if err := extractZip(outputPath, archiveFile, size, stripFirstComponent, hazards); err != nil {
 			return fmt.Errorf("extract %s: %v", src, err)
 		}
 	case hasXZMagic(header):
@@ -173,7 +244,8 @@ func extract(ctx context.Context, drv *zbstore.Derivation, realStoreDir string)
 // then extractTar assumes that the archive contains a single top-level file or directory
 // and extracts that to dst.
 // Otherwise, extractTar creates a new directory at dst and extracts the archive's contents into it.
-func extractTar(dst string, src io.Reader, stripFirstComponent bool) error {
+// Every extracted path (relative to dst) is passed to hazards before it is created.
+func extractTar(dst string, src io.Reader, stripFirstComponent bool, hazards caseHazardChecker) error {
 	if !stripFirstComponent {
 		if err := os.Mkdir(dst, 0o777); err != nil {
 			return err
@@ -233,7 +305,11 @@ func extractTar(dst string, src io.Reader, stripFirstComponent bool) error {
 		if !hasPrefix {
 			return errors.New("zip archive contains multiple top-level files")
 		}
-		subdst, err := filepath.Localize(slashpath.Clean(name))
+		name = slashpath.Clean(name)
+		if err := hazards.check(name); err != nil {
+			return err
+		}
+		subdst, err := filepath.Localize(name)
 		if err != nil {
 			return err
 		}
@@ -300,7 +376,8 @@ func extractTarFile(root *os.Root, dst string, r *tar.Reader, hdr *tar.Header) e
 // then extractZip assumes that the archive contains a single top-level file or directory
 // and extracts that to dst.
 // Otherwise, extractZip creates a new directory at dst and extracts the archive's contents into it.
-func extractZip(dst string, src io.ReaderAt, srcSize int64, stripFirstComponent bool) error {
+// Every extracted path (relative to dst) is passed to hazards before it is created.
+func extractZip(dst string, src io.ReaderAt, srcSize int64, stripFirstComponent bool, hazards caseHazardChecker) error {
 	r, err := zip.NewReader(src, srcSize)
 	if err != nil {
 		return err
@@ -351,6 +428,9 @@ func extractZip(dst string, src io.ReaderAt, srcSize int64, stripFirstComponent
 		if top != "." {
 			relpath = path[len(top)+len("/"):]
 		}
+		if err := hazards.check(relpath); err != nil {
+			return err
+		}
 		subdst, err := filepath.Localize(relpath)
 		if err != nil {
 			return err
@@ -0,0 +1,201 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"zb.256lights.llc/pkg/zbstore"
+)
+
+// composeTree creates a directory at the derivation's output
+// from the entries described by the derivation's arguments.
+// The arguments are a sequence of:
+//
+//   - "--file" followed by a slash-separated path and the file's contents.
+//   - "--executable" followed by a slash-separated path and the file's contents.
+//   - "--symlink" followed by a slash-separated path and the link's target.
+//   - "--copy" followed by a slash-separated path and a store path
+//     (possibly with a subpath) to copy there.
+//   - "--join" followed by a store path of a directory.
+//     Every file and symlink in the directory
+//     is symlinked at the same path in the output.
+//
+// Parent directories are created as needed.
+// It is an error for two entries to have the same path
+// unless the ignoreCollisions variable is set,
+// in which case the first entry wins.
+func composeTree(drv *zbstore.Derivation, realStoreDir string) error {
+	outputPath := drv.Env[zbstore.DefaultDerivationOutputName]
+	if outputPath == "" {
+		return fmt.Errorf("missing %s environment variable", zbstore.DefaultDerivationOutputName)
+	}
+	outputPath = strings.ReplaceAll(outputPath, string(drv.Dir), realStoreDir)
+	c := &treeComposer{
+		dir:              drv.Dir,
+		outputPath:       outputPath,
+		ignoreCollisions: drv.Env["ignoreCollisions"] != "",
+	}
+
+	var err error
+	c.storeRoot, err = os.OpenRoot(realStoreDir)
+	if err != nil {
+		return err
+	}
+	defer c.storeRoot.Close()
+	if err := os.Mkdir(outputPath, 0o777); err != nil {
+		return err
+	}
+	c.root, err = os.OpenRoot(outputPath)
+	if err != nil {
+		return err
+	}
+	defer c.root.Close()
+
+	for args := drv.Args; len(args) > 0; {
+		switch args[0] {
+		case "--file", "--executable", "--symlink", "--copy":
+			if len(args) < 3 {
+				return fmt.Errorf("%s requires two arguments", args[0])
+			}
+			if err := c.add(args[0], args[1], args[2]); err != nil {
+				return err
+			}
+			args = args[3:]
+		case "--join":
+			if len(args) < 2 {
+				return fmt.Errorf("%s requires a store path", args[0])
+			}
+			if err := c.join(args[1]); err != nil {
+				return fmt.Errorf("join %s: %v", args[1], err)
+			}
+			args = args[2:]
+		default:
+			return fmt.Errorf("unknown argument %q", args[0])
+		}
+	}
+	return nil
+}
+
+// treeComposer holds the state of a [composeTree] call.
+type treeComposer struct {
+	dir        zbstore.Directory
+	outputPath string
+	// root is the output directory.
+	root *os.Root
+	// storeRoot is the real store directory.
+	// Sources are opened through storeRoot
+	// so that symlinks in store objects cannot refer to files outside the store.
+	storeRoot        *os.Root
+	ignoreCollisions bool
+}
+
+// add creates a single entry in the output.
+func (c *treeComposer) add(kind, name, value string) error {
+	localName, err := c.prepare(name)
+	if err != nil || localName == "" {
+		return err
+	}
+	switch kind {
+	case "--file":
+		err = c.root.WriteFile(localName, []byte(value), 0o666)
+	case "--executable":
+		err = c.root.WriteFile(localName, []byte(value), 0o777)
+	case "--symlink":
+		err = c.root.Symlink(value, localName)
+	case "--copy":
+		var src string
+		src, err = storeRelativePath(c.dir, value)
+		if err == nil {
+			err = copyStoreSource(filepath.Join(c.outputPath, localName), c.storeRoot, src)
+		}
+	default:
+		err = fmt.Errorf("unknown entry type %s", kind)
+	}
+	if err != nil {
+		return fmt.Errorf("%s %s: %v", kind, name, err)
+	}
+	return nil
+}
+
+// join symlinks every file and symlink in the store directory src
+// into the output at the same relative path.
+func (c *treeComposer) join(src string) error {
+	localSrc, err := storeRelativePath(c.dir, src)
+	if err != nil {
+		return err
+	}
+	info, err := c.storeRoot.Stat(localSrc)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return errors.New("not a directory")
+	}
+	fsys, err := fs.Sub(c.storeRoot.FS(), filepath.ToSlash(localSrc))
+	if err != nil {
+		return err
+	}
+	return fs.WalkDir(fsys, ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || name == "." {
+			return err
+		}
+		rel := filepath.FromSlash(name)
+		if entry.IsDir() {
+			if err := c.root.MkdirAll(rel, 0o777); err != nil {
+				if info, statErr := c.root.Lstat(rel); statErr == nil && !info.IsDir() {
+					if err := c.collision(name); err != nil {
+						return err
+					}
+					return fs.SkipDir
+				}
+				return err
+			}
+			return nil
+		}
+		localName, err := c.prepare(name)
+		if err != nil || localName == "" {
+			return err
+		}
+		// Point at the store path as the builder sees it, not the real store directory.
+		return c.root.Symlink(src+"/"+name, localName)
+	})
+}
+
+// prepare validates name, creates its parent directories,
+// and checks that nothing exists at name yet.
+// If something exists at name and collisions are ignored,
+// then prepare returns the empty string.
+func (c *treeComposer) prepare(name string) (localName string, err error) {
+	localName, err = filepath.Localize(name)
+	if err != nil {
+		return "", fmt.Errorf("invalid path %q", name)
+	}
+	if dir := filepath.Dir(localName); dir != "." {
+		if err := c.root.MkdirAll(dir, 0o777); err != nil {
+			return "", fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	if _, err := c.root.Lstat(localName); err == nil {
+		if err := c.collision(name); err != nil {
+			return "", err
+		}
+		return "", nil
+	}
+	return localName, nil
+}
+
+// collision returns an error about two entries with the given name
+// unless collisions are ignored.
+func (c *treeComposer) collision(name string) error {
+	if c.ignoreCollisions {
+		return nil
+	}
+	return fmt.Errorf("%s: more than one entry has this path", name)
+}
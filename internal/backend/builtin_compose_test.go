@@ -0,0 +1,233 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package backend
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"zb.256lights.llc/pkg/zbstore"
+)
+
+func TestComposeTree(t *testing.T) {
+	const (
+		pkgA = "ffffffffffffffffffffffffffffffff-a"
+		pkgB = "gggggggggggggggggggggggggggggggg-b"
+	)
+	storeFiles := fstest.MapFS{
+		pkgA + "/bin/a":           {Data: []byte("#!/bin/sh\n"), Mode: 0o755},
+		pkgA + "/share/doc/a.txt": {Data: []byte("A\n")},
+		pkgB + "/bin/b":           {Data: []byte("#!/bin/sh\n"), Mode: 0o755},
+		pkgB + "/share/doc/b.txt": {Data: []byte("B\n")},
+		pkgB + "/share/doc/a.txt": {Data: []byte("Not A\n")},
+	}
+
+	tests := []struct {
+		name             string
+		args             []string
+		ignoreCollisions bool
+		want             fstest.MapFS
+		wantLinks        map[string]string
+		wantErr          bool
+	}{
+		{
+			name: "Entries",
+			args: []string{
+				"--file", "etc/motd", "Hello\n",
+				"--executable", "bin/hello", "#!/bin/sh\necho hello\n",
+				"--symlink", "lib", "../lib",
+				"--copy", "docs", "{store}/" + pkgA + "/share/doc",
+			},
+			want: fstest.MapFS{
+				"etc/motd":   {Data: []byte("Hello\n")},
+				"bin/hello":  {Data: []byte("#!/bin/sh\necho hello\n"), Mode: 0o755},
+				"lib":        {Mode: os.ModeSymlink},
+				"docs/a.txt": {Data: []byte("A\n")},
+			},
+			wantLinks: map[string]string{
+				"lib": "../lib",
+			},
+		},
+		{
+			name: "Join",
+			args: []string{
+				"--join", "{store}/" + pkgA,
+				"--join", "{store}/" + pkgB + "/bin",
+			},
+			want: fstest.MapFS{
+				"bin/a":           {Mode: os.ModeSymlink},
+				"share/doc/a.txt": {Mode: os.ModeSymlink},
+				"b":               {Mode: os.ModeSymlink},
+			},
+			wantLinks: map[string]string{
+				"bin/a":           "{store}/" + pkgA + "/bin/a",
+				"share/doc/a.txt": "{store}/" + pkgA + "/share/doc/a.txt",
+				"b":               "{store}/" + pkgB + "/bin/b",
+			},
+		},
+		{
+			name: "Collision",
+			args: []string{
+				"--join", "{store}/" + pkgA,
+				"--join", "{store}/" + pkgB,
+			},
+			wantErr: true,
+		},
+		{
+			name: "IgnoreCollisions",
+			args: []string{
+				"--symlink", "share", "elsewhere",
+				"--join", "{store}/" + pkgA,
+				"--join", "{store}/" + pkgB,
+			},
+			ignoreCollisions: true,
+			want: fstest.MapFS{
+				"share": {Mode: os.ModeSymlink},
+				"bin/a": {Mode: os.ModeSymlink},
+				"bin/b": {Mode: os.ModeSymlink},
+			},
+			wantLinks: map[string]string{
+				"share": "elsewhere",
+				"bin/a": "{store}/" + pkgA + "/bin/a",
+				"bin/b": "{store}/" + pkgB + "/bin/b",
+			},
+		},
+		{
+			name:    "OutsideOutput",
+			args:    []string{"--file", "../escape.txt", "boo\n"},
+			wantErr: true,
+		},
+		{
+			name:    "JoinFile",
+			args:    []string{"--join", "{store}/" + pkgA + "/bin/a"},
+			wantErr: true,
+		},
+		{
+			name:    "BadArgs",
+			args:    []string{"--symlink", "lib"},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			realStoreDir := t.TempDir()
+			if err := os.CopyFS(realStoreDir, storeFiles); err != nil {
+				t.Fatal(err)
+			}
+			// The builder sees a different store directory than the real one
+			// to verify that symlinks use the builder's view.
+			storeDir := zbstore.Directory(filepath.Join(string(filepath.Separator), "zb", "store"))
+			replaceStore := func(s string) string {
+				return strings.ReplaceAll(s, "{store}", string(storeDir))
+			}
+			out := filepath.Join(realStoreDir, "hhhhhhhhhhhhhhhhhhhhhhhhhhhhhhhh-out")
+			drv := &zbstore.Derivation{
+				Dir:     storeDir,
+				Builder: builtinBuilderPrefix + "compose",
+				System:  builtinSystem,
+				Env: map[string]string{
+					"out": filepath.Join(string(storeDir), filepath.Base(out)),
+				},
+			}
+			for _, arg := range test.args {
+				drv.Args = append(drv.Args, replaceStore(arg))
+			}
+			if test.ignoreCollisions {
+				drv.Env["ignoreCollisions"] = "1"
+			}
+
+			err := composeTree(drv, realStoreDir)
+			if test.wantErr {
+				if err == nil {
+					t.Error("composeTree did not return an error")
+				} else {
+					t.Log("composeTree:", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("composeTree:", err)
+			}
+			if diff := diffFS(t, withRoot(test.want), os.DirFS(out)); diff != "" {
+				t.Errorf("output (-want +got):\n%s", diff)
+			}
+			for name, want := range test.wantLinks {
+				got, err := os.Readlink(filepath.Join(out, filepath.FromSlash(name)))
+				if err != nil {
+					t.Error(err)
+					continue
+				}
+				if want = replaceStore(want); got != want {
+					t.Errorf("%s -> %s; want %s", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestComposeTreeOutsideStore(t *testing.T) {
+	hostDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(hostDir, "shadow"), []byte("hunter2\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	const pkg = "ffffffffffffffffffffffffffffffff-foo"
+
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{
+			name: "CopyThroughSymlink",
+			args: []string{"--copy", "x", "{store}/" + pkg + "/link/shadow"},
+		},
+		{
+			name: "JoinThroughSymlink",
+			args: []string{"--join", "{store}/" + pkg + "/link"},
+		},
+		{
+			name: "CopyOutsideStore",
+			args: []string{"--copy", "x", filepath.Join(hostDir, "shadow")},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			realStoreDir := t.TempDir()
+			if err := os.Mkdir(filepath.Join(realStoreDir, pkg), 0o777); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Symlink(hostDir, filepath.Join(realStoreDir, pkg, "link")); err != nil {
+				t.Fatal(err)
+			}
+			storeDir := zbstore.Directory(filepath.Join(string(filepath.Separator), "zb", "store"))
+			out := filepath.Join(realStoreDir, "hhhhhhhhhhhhhhhhhhhhhhhhhhhhhhhh-out")
+			drv := &zbstore.Derivation{
+				Dir:     storeDir,
+				Builder: builtinBuilderPrefix + "compose",
+				System:  builtinSystem,
+				Env: map[string]string{
+					"out": filepath.Join(string(storeDir), filepath.Base(out)),
+				},
+			}
+			for _, arg := range test.args {
+				drv.Args = append(drv.Args, strings.ReplaceAll(arg, "{store}", string(storeDir)))
+			}
+
+			if err := composeTree(drv, realStoreDir); err == nil {
+				t.Error("composeTree did not return an error")
+			} else {
+				t.Log("composeTree:", err)
+			}
+			if _, err := os.Lstat(filepath.Join(out, "x")); err == nil {
+				t.Error("composeTree created x")
+			}
+			if _, err := os.Lstat(filepath.Join(out, "shadow")); err == nil {
+				t.Error("composeTree created shadow")
+			}
+		})
+	}
+}
@@ -0,0 +1,714 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package backend
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	slashpath "path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"zb.256lights.llc/pkg/zbstore"
+)
+
+// applyPatches copies the derivation's src to its output
+// and then applies each unified diff listed in the space-separated patches variable in order.
+// The strip variable is the number of leading path components
+// to remove from file names in the patches (like patch -p).
+// It defaults to 1.
+func applyPatches(drv *zbstore.Derivation, realStoreDir string) error {
+	src, outputPath, err := builtinSourceAndOutput(drv, realStoreDir)
+	if err != nil {
+		return err
+	}
+	storeRoot, err := os.OpenRoot(realStoreDir)
+	if err != nil {
+		return err
+	}
+	defer storeRoot.Close()
+	strip := 1
+	if s := drv.Env["strip"]; s != "" {
+		strip, err = strconv.Atoi(s)
+		if err != nil || strip < 0 {
+			return fmt.Errorf("invalid strip %q", s)
+		}
+	}
+	var patchFiles []string
+	for p := range strings.FieldsSeq(drv.Env["patches"]) {
+		localPath, err := storeRelativePath(drv.Dir, p)
+		if err != nil {
+			return fmt.Errorf("patch: %v", err)
+		}
+		patchFiles = append(patchFiles, localPath)
+	}
+
+	if err := copyStoreSource(outputPath, storeRoot, src); err != nil {
+		return err
+	}
+	info, err := checkBuiltinOutput(outputPath)
+	if err != nil {
+		return err
+	}
+	for _, patchFile := range patchFiles {
+		data, err := storeRoot.ReadFile(patchFile)
+		if err != nil {
+			return err
+		}
+		filePatches, err := parseUnifiedDiff(data, strip)
+		if err != nil {
+			return fmt.Errorf("%s: %v", patchFile, err)
+		}
+		if len(filePatches) == 0 {
+			return fmt.Errorf("%s: no changes found", patchFile)
+		}
+		if !info.IsDir() {
+			for _, fp := range filePatches {
+				if fp.created || fp.deleted {
+					return fmt.Errorf("%s: cannot create or delete files in single-file source", patchFile)
+				}
+				if err := fp.applyToFile(outputPath); err != nil {
+					return fmt.Errorf("%s: %s: %v", patchFile, fp.name, err)
+				}
+			}
+			continue
+		}
+		if err := applyFilePatches(outputPath, filePatches); err != nil {
+			return fmt.Errorf("%s: %v", patchFile, err)
+		}
+	}
+	return nil
+}
+
+// substitute copies the derivation's src to its output
+// and then replaces literal strings in it as directed by the derivation's arguments.
+// The arguments are a sequence of:
+//
+//   - "--file" followed by a slash-separated path relative to src
+//     to add to the list of files to perform replacements in.
+//     Required if src is a directory.
+//   - "--replace" followed by the string to search for
+//     and the string to replace it with.
+//     Every occurrence in every file is replaced.
+//     It is an error if a file does not contain the search string.
+//
+// Replacements are performed in the order given.
+func substitute(drv *zbstore.Derivation, realStoreDir string) error {
+	src, outputPath, err := builtinSourceAndOutput(drv, realStoreDir)
+	if err != nil {
+		return err
+	}
+	var files []string
+	var replacements [][2]string
+	for args := drv.Args; len(args) > 0; {
+		switch args[0] {
+		case "--file":
+			if len(args) < 2 {
+				return fmt.Errorf("%s requires a path", args[0])
+			}
+			files = append(files, args[1])
+			args = args[2:]
+		case "--replace":
+			if len(args) < 3 {
+				return fmt.Errorf("%s requires two arguments", args[0])
+			}
+			if args[1] == "" {
+				return fmt.Errorf("%s: search string is empty", args[0])
+			}
+			replacements = append(replacements, [2]string{args[1], args[2]})
+			args = args[3:]
+		default:
+			return fmt.Errorf("unknown argument %q", args[0])
+		}
+	}
+	if len(replacements) == 0 {
+		return errors.New("no replacements given")
+	}
+
+	storeRoot, err := os.OpenRoot(realStoreDir)
+	if err != nil {
+		return err
+	}
+	defer storeRoot.Close()
+	if err := copyStoreSource(outputPath, storeRoot, src); err != nil {
+		return err
+	}
+	info, err := checkBuiltinOutput(outputPath)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		if len(files) > 0 {
+			return errors.New("--file given for single-file source")
+		}
+		return substituteFile(nil, outputPath, replacements)
+	}
+	if len(files) == 0 {
+		return errors.New("source is a directory but no files given")
+	}
+	root, err := os.OpenRoot(outputPath)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+	for _, name := range files {
+		localName, err := filepath.Localize(name)
+		if err != nil {
+			return fmt.Errorf("file %q: %v", name, err)
+		}
+		if err := substituteFile(root, localName, replacements); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// substituteFile performs replacements on the file at path.
+// If root is not nil, then path is relative to root.
+func substituteFile(root *os.Root, path string, replacements [][2]string) error {
+	var data []byte
+	var err error
+	if root == nil {
+		data, err = os.ReadFile(path)
+	} else {
+		data, err = root.ReadFile(path)
+	}
+	if err != nil {
+		return err
+	}
+	s := string(data)
+	for _, r := range replacements {
+		if !strings.Contains(s, r[0]) {
+			return fmt.Errorf("%s: %q not found", filepath.ToSlash(path), r[0])
+		}
+		s = strings.ReplaceAll(s, r[0], r[1])
+	}
+	if root == nil {
+		return os.WriteFile(path, []byte(s), 0o666)
+	}
+	return root.WriteFile(path, []byte(s), 0o666)
+}
+
+// builtinSourceAndOutput returns the derivation's src variable
+// as a path relative to the store directory (see [storeRelativePath])
+// and the path of the derivation's out variable in the real store directory.
+// The builtins run outside the sandbox,
+// so src must be inside the store.
+func builtinSourceAndOutput(drv *zbstore.Derivation, realStoreDir string) (src, outputPath string, err error) {
+	if drv.Env["src"] == "" {
+		return "", "", errors.New("missing src environment variable")
+	}
+	src, err = storeRelativePath(drv.Dir, drv.Env["src"])
+	if err != nil {
+		return "", "", fmt.Errorf("source: %v", err)
+	}
+	outputPath = drv.Env[zbstore.DefaultDerivationOutputName]
+	if outputPath == "" {
+		return "", "", fmt.Errorf("missing %s environment variable", zbstore.DefaultDerivationOutputName)
+	}
+	outputPath = strings.ReplaceAll(outputPath, string(drv.Dir), realStoreDir)
+	return src, outputPath, nil
+}
+
+// checkBuiltinOutput returns information about the copied source at outputPath
+// or an error if it is neither a directory nor a regular file.
+// Single-file sources are modified with functions that follow symlinks,
+// so a symlink must never be modified in place.
+func checkBuiltinOutput(outputPath string) (fs.FileInfo, error) {
+	info, err := os.Lstat(outputPath)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() && !info.Mode().IsRegular() {
+		return nil, errors.New("source is not a regular file or directory")
+	}
+	return info, nil
+}
+
+// storeRelativePath converts a store path (possibly with a subpath)
+// to a path relative to the store directory
+// suitable for opening with an [*os.Root] for the real store directory.
+// It returns an error if p is not inside dir.
+func storeRelativePath(dir zbstore.Directory, p string) (string, error) {
+	storePath, sub, err := dir.ParsePath(p)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(storePath.Base(), filepath.FromSlash(sub)), nil
+}
+
+// copyStoreSource copies the file, directory, or symlink
+// at the given path in storeRoot to dst.
+// Copied files are writable so that they can be modified in place.
+// Symlinks are never followed outside storeRoot.
+func copyStoreSource(dst string, storeRoot *os.Root, name string) error {
+	info, err := storeRoot.Lstat(name)
+	if err != nil {
+		return err
+	}
+	switch info.Mode().Type() {
+	case fs.ModeDir:
+		fsys, err := fs.Sub(storeRoot.FS(), filepath.ToSlash(name))
+		if err != nil {
+			return err
+		}
+		return os.CopyFS(dst, fsys)
+	case fs.ModeSymlink:
+		target, err := storeRoot.Readlink(name)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(target, dst)
+	case 0:
+		return extractFile(nil, dst, info.Mode(), func() (io.ReadCloser, error) {
+			return storeRoot.Open(name)
+		})
+	default:
+		return fmt.Errorf("copy %s: unsupported file type %v", name, info.Mode().Type())
+	}
+}
+
+// filePatch is the set of changes to a single file in a unified diff.
+type filePatch struct {
+	// name is the slash-separated path of the file to change
+	// after stripping leading components.
+	name    string
+	created bool
+	deleted bool
+	// mode is the file's new permission bits,
+	// or zero if the patch does not change them.
+	mode  fs.FileMode
+	hunks []*hunk
+}
+
+// hunk is a contiguous change in a [filePatch].
+type hunk struct {
+	// oldStart is the 1-based line number in the original file
+	// where the hunk starts.
+	oldStart int
+	// oldLines and newLines are the lines that the hunk replaces
+	// and the lines to replace them with, respectively.
+	// Each line includes its trailing newline (if any).
+	oldLines []string
+	newLines []string
+}
+
+// parseUnifiedDiff parses the file changes in a unified diff
+// like those produced by diff -u or git diff.
+// Text outside of file changes (like commit messages) is ignored.
+func parseUnifiedDiff(data []byte, strip int) ([]*filePatch, error) {
+	var lines []string
+	s := bufio.NewScanner(bytes.NewReader(data))
+	s.Buffer(nil, len(data)+1)
+	s.Split(scanLinesWithEOL)
+	for s.Scan() {
+		lines = append(lines, s.Text())
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	var patches []*filePatch
+	// gitName and gitMode are from the extended header lines
+	// that git writes before the ---/+++ lines.
+	var gitName string
+	var gitMode fs.FileMode
+	var gitCreated, gitDeleted bool
+	flushGit := func() error {
+		if gitName == "" || gitMode == 0 && !gitDeleted {
+			gitName = ""
+			return nil
+		}
+		// Mode change, empty file creation, or empty file deletion
+		// without any content changes.
+		name, err := stripPatchPath(gitName, strip)
+		if err != nil {
+			return err
+		}
+		patches = append(patches, &filePatch{
+			name:    name,
+			created: gitCreated,
+			deleted: gitDeleted,
+			mode:    gitMode,
+		})
+		gitName, gitMode = "", 0
+		return nil
+	}
+
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimRight(lines[i], "\r\n")
+		lineno := i + 1
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			if err := flushGit(); err != nil {
+				return nil, fmt.Errorf("line %d: %v", lineno, err)
+			}
+			gitName = gitDiffName(strings.TrimPrefix(line, "diff --git "))
+			gitMode, gitCreated, gitDeleted = 0, false, false
+		case strings.HasPrefix(line, "new file mode "):
+			gitCreated = true
+			gitMode = parseGitMode(strings.TrimPrefix(line, "new file mode "))
+		case strings.HasPrefix(line, "deleted file mode "):
+			gitDeleted = true
+		case strings.HasPrefix(line, "new mode "):
+			gitMode = parseGitMode(strings.TrimPrefix(line, "new mode "))
+		case strings.HasPrefix(line, "rename from "), strings.HasPrefix(line, "copy from "):
+			return nil, fmt.Errorf("line %d: renames and copies not supported", lineno)
+		case strings.HasPrefix(line, "GIT binary patch"), strings.HasPrefix(line, "Binary files "):
+			return nil, fmt.Errorf("line %d: binary patches not supported", lineno)
+		case strings.HasPrefix(line, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ "):
+			oldName, err := parsePatchFileName(strings.TrimPrefix(line, "--- "))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", lineno, err)
+			}
+			newName, err := parsePatchFileName(strings.TrimRight(strings.TrimPrefix(lines[i+1], "+++ "), "\r\n"))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", lineno+1, err)
+			}
+			i++
+			fp := &filePatch{
+				created: oldName == devNull || gitCreated,
+				deleted: newName == devNull || gitDeleted,
+				mode:    gitMode,
+			}
+			name := newName
+			if fp.deleted {
+				name = oldName
+			}
+			if fp.created && fp.deleted {
+				return nil, fmt.Errorf("line %d: both file names are %s", lineno, devNull)
+			}
+			fp.name, err = stripPatchPath(name, strip)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", lineno, err)
+			}
+			gitName, gitMode, gitCreated, gitDeleted = "", 0, false, false
+
+			for i+1 < len(lines) && strings.HasPrefix(lines[i+1], "@@ ") {
+				i++
+				h, n, err := parseHunk(lines[i:])
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %v", i+1, err)
+				}
+				fp.hunks = append(fp.hunks, h)
+				i += n - 1
+			}
+			patches = append(patches, fp)
+		}
+	}
+	if err := flushGit(); err != nil {
+		return nil, err
+	}
+	return patches, nil
+}
+
+const devNull = "/dev/null"
+
+// parseHunk parses a hunk starting with its "@@" line
+// and returns the number of lines consumed.
+func parseHunk(lines []string) (_ *hunk, n int, err error) {
+	header := strings.TrimRight(lines[0], "\r\n")
+	rest, ok := strings.CutPrefix(header, "@@ -")
+	if !ok {
+		return nil, 0, fmt.Errorf("invalid hunk header %q", header)
+	}
+	oldRange, rest, ok := strings.Cut(rest, " +")
+	if !ok {
+		return nil, 0, fmt.Errorf("invalid hunk header %q", header)
+	}
+	newRange, _, ok := strings.Cut(rest, " @@")
+	if !ok {
+		return nil, 0, fmt.Errorf("invalid hunk header %q", header)
+	}
+	h := new(hunk)
+	var oldCount, newCount int
+	h.oldStart, oldCount, err = parseHunkRange(oldRange)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid hunk header %q: %v", header, err)
+	}
+	_, newCount, err = parseHunkRange(newRange)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid hunk header %q: %v", header, err)
+	}
+
+	n = 1
+	// last points to the slice that the previous line was added to
+	// so that a "\ No newline at end of file" marker can modify it.
+	var last []*[]string
+	for len(h.oldLines) < oldCount || len(h.newLines) < newCount || n < len(lines) && strings.HasPrefix(lines[n], `\`) {
+		if n >= len(lines) {
+			return nil, 0, errors.New("hunk is truncated")
+		}
+		line := lines[n]
+		n++
+		if line == "\n" || line == "\r\n" {
+			// Some editors strip the space from empty context lines.
+			line = " " + line
+		}
+		switch line[0] {
+		case ' ':
+			h.oldLines = append(h.oldLines, line[1:])
+			h.newLines = append(h.newLines, line[1:])
+			last = []*[]string{&h.oldLines, &h.newLines}
+		case '-':
+			h.oldLines = append(h.oldLines, line[1:])
+			last = []*[]string{&h.oldLines}
+		case '+':
+			h.newLines = append(h.newLines, line[1:])
+			last = []*[]string{&h.newLines}
+		case '\\':
+			for _, l := range last {
+				i := len(*l) - 1
+				(*l)[i] = strings.TrimSuffix((*l)[i], "\n")
+			}
+			last = nil
+		default:
+			return nil, 0, fmt.Errorf("unexpected line in hunk: %q", strings.TrimRight(line, "\r\n"))
+		}
+	}
+	if len(h.oldLines) != oldCount || len(h.newLines) != newCount {
+		return nil, 0, errors.New("hunk line counts do not match header")
+	}
+	return h, n, nil
+}
+
+// parseHunkRange parses a range in a hunk header like "12,3" or "12".
+func parseHunkRange(s string) (start, count int, err error) {
+	startString, countString, hasCount := strings.Cut(s, ",")
+	start, err = strconv.Atoi(startString)
+	if err != nil || start < 0 {
+		return 0, 0, fmt.Errorf("invalid range %q", s)
+	}
+	if !hasCount {
+		return start, 1, nil
+	}
+	count, err = strconv.Atoi(countString)
+	if err != nil || count < 0 {
+		return 0, 0, fmt.Errorf("invalid range %q", s)
+	}
+	return start, count, nil
+}
+
+// parsePatchFileName parses the file name from a "---" or "+++" line,
+// removing any trailing timestamp.
+func parsePatchFileName(s string) (string, error) {
+	if strings.HasPrefix(s, `"`) {
+		end := strings.LastIndexByte(s, '"')
+		name, err := strconv.Unquote(s[:end+1])
+		if err != nil {
+			return "", fmt.Errorf("invalid file name %s", s)
+		}
+		return name, nil
+	}
+	name, _, _ := strings.Cut(s, "\t")
+	name = strings.TrimRight(name, " ")
+	if name == "" {
+		return "", errors.New("missing file name")
+	}
+	return name, nil
+}
+
+// gitDiffName returns the file name from the arguments of a "diff --git" line
+// if both names are the same.
+// Otherwise, it returns the empty string.
+func gitDiffName(s string) string {
+	// The line is "a/NAME b/NAME", so NAME is half of the remaining length.
+	if len(s) < len("a/ b/")+2 || (len(s)-1)%2 != 0 {
+		return ""
+	}
+	half := (len(s) - 1) / 2
+	a, b := s[:half], s[half+1:]
+	if s[half] != ' ' || a[strings.IndexByte(a, '/')+1:] != b[strings.IndexByte(b, '/')+1:] {
+		return ""
+	}
+	return b
+}
+
+// parseGitMode parses an octal file mode from a git extended header line
+// and returns its permission bits.
+func parseGitMode(s string) fs.FileMode {
+	mode, err := strconv.ParseUint(strings.TrimSpace(s), 8, 32)
+	if err != nil || mode&0o170000 != 0o100000 {
+		// Only regular files are supported.
+		return 0
+	}
+	return fs.FileMode(mode) & fs.ModePerm
+}
+
+// stripPatchPath removes the first n slash-separated components from name.
+func stripPatchPath(name string, n int) (string, error) {
+	rest := name
+	for range n {
+		var ok bool
+		_, rest, ok = strings.Cut(rest, "/")
+		if !ok {
+			return "", fmt.Errorf("cannot strip %d components from %s", n, name)
+		}
+		rest = strings.TrimLeft(rest, "/")
+	}
+	rest = slashpath.Clean(rest)
+	if !filepath.IsLocal(filepath.FromSlash(rest)) {
+		return "", fmt.Errorf("%s is not a relative path inside the source", name)
+	}
+	return rest, nil
+}
+
+// applyFilePatches applies the changes to the directory at dir.
+func applyFilePatches(dir string, filePatches []*filePatch) error {
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+	for _, fp := range filePatches {
+		if err := fp.apply(root); err != nil {
+			return fmt.Errorf("%s: %v", fp.name, err)
+		}
+	}
+	return nil
+}
+
+func (fp *filePatch) apply(root *os.Root) error {
+	name, err := filepath.Localize(fp.name)
+	if err != nil {
+		return err
+	}
+	var content string
+	if fp.created {
+		if _, err := root.Lstat(name); err == nil {
+			return errors.New("file to create already exists")
+		}
+		if err := root.MkdirAll(filepath.Dir(name), 0o777); err != nil {
+			return err
+		}
+	} else {
+		data, err := root.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		content = string(data)
+	}
+	newContent, err := fp.applyHunks(content)
+	if err != nil {
+		return err
+	}
+	if fp.deleted {
+		if newContent != "" {
+			return errors.New("file to delete has unexpected content")
+		}
+		return root.Remove(name)
+	}
+	perm := fp.mode
+	if perm == 0 {
+		perm = 0o666
+	}
+	if err := root.WriteFile(name, []byte(newContent), perm); err != nil {
+		return err
+	}
+	if fp.mode != 0 {
+		return root.Chmod(name, fp.mode)
+	}
+	return nil
+}
+
+// applyToFile applies the changes to the regular file at path.
+func (fp *filePatch) applyToFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	newContent, err := fp.applyHunks(string(data))
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, []byte(newContent), 0o666); err != nil {
+		return err
+	}
+	if fp.mode != 0 {
+		return os.Chmod(path, fp.mode)
+	}
+	return nil
+}
+
+// applyHunks applies fp's hunks to content.
+// Like patch(1), each hunk may apply at an offset from the line it names
+// as long as its context matches exactly.
+func (fp *filePatch) applyHunks(content string) (string, error) {
+	lines := strings.SplitAfter(content, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	var result []string
+	// pos is the index in lines of the first line not yet copied to result.
+	pos := 0
+	offset := 0
+	for i, h := range fp.hunks {
+		want := h.oldStart - 1 + offset
+		if len(h.oldLines) == 0 {
+			// Pure insertion: the start line is the line before the insertion.
+			want++
+		}
+		at := findHunk(lines, h.oldLines, pos, want)
+		if at < 0 {
+			return "", fmt.Errorf("hunk #%d (line %d) does not apply", i+1, h.oldStart)
+		}
+		offset = at - (h.oldStart - 1)
+		if len(h.oldLines) == 0 {
+			offset--
+		}
+		result = append(result, lines[pos:at]...)
+		result = append(result, h.newLines...)
+		pos = at + len(h.oldLines)
+	}
+	result = append(result, lines[pos:]...)
+	return strings.Join(result, ""), nil
+}
+
+// findHunk returns the index of the first line of old in lines
+// closest to want that is at or after min,
+// or -1 if old does not appear in lines at or after min.
+func findHunk(lines, old []string, min, want int) int {
+	want = max(want, min)
+	matches := func(at int) bool {
+		if at < min || at+len(old) > len(lines) {
+			return false
+		}
+		for i, l := range old {
+			if lines[at+i] != l {
+				return false
+			}
+		}
+		return true
+	}
+	for d := 0; want-d >= min || want+d <= len(lines); d++ {
+		if matches(want - d) {
+			return want - d
+		}
+		if d > 0 && matches(want+d) {
+			return want + d
+		}
+	}
+	return -1
+}
+
+// scanLinesWithEOL is a [bufio.SplitFunc] like [bufio.ScanLines]
+// that keeps the line endings.
+func scanLinesWithEOL(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		return i + 1, data[:i+1], nil
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
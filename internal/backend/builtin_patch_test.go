@@ -0,0 +1,572 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package backend
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"zb.256lights.llc/pkg/zbstore"
+)
+
+func TestApplyPatches(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     fstest.MapFS
+		patches []string
+		strip   string
+		want    fstest.MapFS
+		wantErr bool
+	}{
+		{
+			name: "Modify",
+			src: fstest.MapFS{
+				"a.txt": {Data: []byte("one\ntwo\nthree\n")},
+				"b.txt": {Data: []byte("unchanged\n")},
+			},
+			patches: []string{
+				"--- a/a.txt\t2026-01-01 00:00:00\n" +
+					"+++ b/a.txt\t2026-01-01 00:00:00\n" +
+					"@@ -1,3 +1,3 @@\n" +
+					" one\n" +
+					"-two\n" +
+					"+TWO\n" +
+					" three\n",
+			},
+			want: fstest.MapFS{
+				"a.txt": {Data: []byte("one\nTWO\nthree\n")},
+				"b.txt": {Data: []byte("unchanged\n")},
+			},
+		},
+		{
+			name: "Offset",
+			src: fstest.MapFS{
+				"a.txt": {Data: []byte("zero\nzero\none\ntwo\nthree\nfour\n")},
+			},
+			patches: []string{
+				"Commit message that should be ignored.\n" +
+					"\n" +
+					"--- a/a.txt\n" +
+					"+++ b/a.txt\n" +
+					"@@ -1,2 +1,2 @@\n" +
+					" one\n" +
+					"-two\n" +
+					"+2\n" +
+					"@@ -4 +4,2 @@\n" +
+					" four\n" +
+					"+five\n",
+			},
+			want: fstest.MapFS{
+				"a.txt": {Data: []byte("zero\nzero\none\n2\nthree\nfour\nfive\n")},
+			},
+		},
+		{
+			name: "Sequence",
+			src: fstest.MapFS{
+				"a.txt": {Data: []byte("one\n")},
+			},
+			patches: []string{
+				"--- a/a.txt\n" +
+					"+++ b/a.txt\n" +
+					"@@ -1 +1 @@\n" +
+					"-one\n" +
+					"+two\n",
+				"--- a/a.txt\n" +
+					"+++ b/a.txt\n" +
+					"@@ -1 +1 @@\n" +
+					"-two\n" +
+					"+three\n",
+			},
+			want: fstest.MapFS{
+				"a.txt": {Data: []byte("three\n")},
+			},
+		},
+		{
+			name: "GitCreateDeleteAndMode",
+			src: fstest.MapFS{
+				"old.txt":       {Data: []byte("bye\n")},
+				"script.sh":     {Data: []byte("#!/bin/sh\n")},
+				"empty-old.txt": {Data: []byte{}},
+			},
+			patches: []string{
+				"diff --git a/dir/new.txt b/dir/new.txt\n" +
+					"new file mode 100644\n" +
+					"index 0000000..ce01362\n" +
+					"--- /dev/null\n" +
+					"+++ b/dir/new.txt\n" +
+					"@@ -0,0 +1 @@\n" +
+					"+hello\n" +
+					"diff --git a/old.txt b/old.txt\n" +
+					"deleted file mode 100644\n" +
+					"index b023018..0000000\n" +
+					"--- a/old.txt\n" +
+					"+++ /dev/null\n" +
+					"@@ -1 +0,0 @@\n" +
+					"-bye\n" +
+					"diff --git a/script.sh b/script.sh\n" +
+					"old mode 100644\n" +
+					"new mode 100755\n" +
+					"diff --git a/empty-old.txt b/empty-old.txt\n" +
+					"deleted file mode 100644\n" +
+					"index e69de29..0000000\n",
+			},
+			want: fstest.MapFS{
+				"dir/new.txt": {Data: []byte("hello\n")},
+				"script.sh":   {Data: []byte("#!/bin/sh\n"), Mode: 0o755},
+			},
+		},
+		{
+			name: "NoNewlineAtEnd",
+			src: fstest.MapFS{
+				"a.txt": {Data: []byte("one\ntwo")},
+			},
+			patches: []string{
+				"--- a/a.txt\n" +
+					"+++ b/a.txt\n" +
+					"@@ -1,2 +1,2 @@\n" +
+					" one\n" +
+					"-two\n" +
+					"\\ No newline at end of file\n" +
+					"+two\n",
+			},
+			want: fstest.MapFS{
+				"a.txt": {Data: []byte("one\ntwo\n")},
+			},
+		},
+		{
+			name: "StripZero",
+			src: fstest.MapFS{
+				"a.txt": {Data: []byte("one\n")},
+			},
+			strip: "0",
+			patches: []string{
+				"--- a.txt\n" +
+					"+++ a.txt\n" +
+					"@@ -1 +1 @@\n" +
+					"-one\n" +
+					"+two\n",
+			},
+			want: fstest.MapFS{
+				"a.txt": {Data: []byte("two\n")},
+			},
+		},
+		{
+			name: "DoesNotApply",
+			src: fstest.MapFS{
+				"a.txt": {Data: []byte("one\n")},
+			},
+			patches: []string{
+				"--- a/a.txt\n" +
+					"+++ b/a.txt\n" +
+					"@@ -1 +1 @@\n" +
+					"-uno\n" +
+					"+two\n",
+			},
+			wantErr: true,
+		},
+		{
+			name: "OutsideSource",
+			src: fstest.MapFS{
+				"a.txt": {Data: []byte("one\n")},
+			},
+			patches: []string{
+				"--- /dev/null\n" +
+					"+++ b/../escape.txt\n" +
+					"@@ -0,0 +1 @@\n" +
+					"+boo\n",
+			},
+			wantErr: true,
+		},
+		{
+			name: "Empty",
+			src: fstest.MapFS{
+				"a.txt": {Data: []byte("one\n")},
+			},
+			patches: []string{"Just some text.\n"},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			src := filepath.Join(dir, testStoreObjectName("src", 0))
+			if err := os.CopyFS(src, test.src); err != nil {
+				t.Fatal(err)
+			}
+			var patchPaths []string
+			for i, p := range test.patches {
+				patchPath := filepath.Join(dir, testStoreObjectName("fix.patch", i+1))
+				if err := os.WriteFile(patchPath, []byte(p), 0o666); err != nil {
+					t.Fatal(err)
+				}
+				patchPaths = append(patchPaths, patchPath)
+			}
+			out := filepath.Join(dir, testStoreObjectName("out", 9))
+			drv := &zbstore.Derivation{
+				Dir:     zbstore.Directory(dir),
+				Builder: builtinBuilderPrefix + "patch",
+				System:  builtinSystem,
+				Env: map[string]string{
+					"src":     src,
+					"out":     out,
+					"patches": strings.Join(patchPaths, " "),
+					"strip":   test.strip,
+				},
+			}
+
+			err := applyPatches(drv, dir)
+			if test.wantErr {
+				if err == nil {
+					t.Error("applyPatches did not return an error")
+				} else {
+					t.Log("applyPatches:", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("applyPatches:", err)
+			}
+			if diff := diffFS(t, withRoot(test.want), os.DirFS(out)); diff != "" {
+				t.Errorf("output (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestApplyPatchesSingleFile(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, testStoreObjectName("script.sh", 0))
+	if err := os.WriteFile(src, []byte("#!/bin/sh\necho hello\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	patchPath := filepath.Join(dir, testStoreObjectName("fix.patch", 1))
+	const patch = "--- a/script.sh\n" +
+		"+++ b/script.sh\n" +
+		"@@ -2 +2 @@\n" +
+		"-echo hello\n" +
+		"+echo goodbye\n"
+	if err := os.WriteFile(patchPath, []byte(patch), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, testStoreObjectName("out", 9))
+	drv := &zbstore.Derivation{
+		Dir:     zbstore.Directory(dir),
+		Builder: builtinBuilderPrefix + "patch",
+		System:  builtinSystem,
+		Env: map[string]string{
+			"src":     src,
+			"out":     out,
+			"patches": patchPath,
+		},
+	}
+	if err := applyPatches(drv, dir); err != nil {
+		t.Fatal("applyPatches:", err)
+	}
+	got, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "#!/bin/sh\necho goodbye\n"; string(got) != want {
+		t.Errorf("output = %q; want %q", got, want)
+	}
+	if info, err := os.Stat(out); err != nil {
+		t.Error(err)
+	} else if info.Mode()&0o111 == 0 {
+		t.Errorf("output mode = %v; want executable", info.Mode())
+	}
+}
+
+func TestSubstitute(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     fstest.MapFS
+		args    []string
+		want    fstest.MapFS
+		wantErr bool
+	}{
+		{
+			name: "NotFound",
+			src: fstest.MapFS{
+				"Makefile":   {Data: []byte("PREFIX = @prefix@\nCC = @cc@\n")},
+				"bin/run.sh": {Data: []byte("#!@sh@\nexec @prefix@/bin/app\n"), Mode: 0o755},
+				"README":     {Data: []byte("Install to @prefix@.\n")},
+			},
+			args: []string{
+				"--file", "Makefile",
+				"--file", "bin/run.sh",
+				"--replace", "@prefix@", "/opt/app",
+				"--replace", "/opt/app/bin", "/opt/app/libexec",
+			},
+			wantErr: true,
+		},
+		{
+			name: "DirectoryReplaceAll",
+			src: fstest.MapFS{
+				"Makefile":   {Data: []byte("PREFIX = @prefix@\nBIN = @prefix@/bin\n")},
+				"bin/run.sh": {Data: []byte("#!/bin/sh\nexec @prefix@/bin/app\n"), Mode: 0o755},
+				"README":     {Data: []byte("Install to @prefix@.\n")},
+			},
+			args: []string{
+				"--file", "Makefile",
+				"--file", "bin/run.sh",
+				"--replace", "@prefix@", "/opt/app",
+			},
+			want: fstest.MapFS{
+				"Makefile":   {Data: []byte("PREFIX = /opt/app\nBIN = /opt/app/bin\n")},
+				"bin/run.sh": {Data: []byte("#!/bin/sh\nexec /opt/app/bin/app\n"), Mode: 0o755},
+				"README":     {Data: []byte("Install to @prefix@.\n")},
+			},
+		},
+		{
+			name: "MissingFiles",
+			src: fstest.MapFS{
+				"Makefile": {Data: []byte("PREFIX = @prefix@\n")},
+			},
+			args:    []string{"--replace", "@prefix@", "/opt/app"},
+			wantErr: true,
+		},
+		{
+			name: "OutsideSource",
+			src: fstest.MapFS{
+				"Makefile": {Data: []byte("PREFIX = @prefix@\n")},
+			},
+			args:    []string{"--file", "../Makefile", "--replace", "@prefix@", "/opt/app"},
+			wantErr: true,
+		},
+		{
+			name: "BadArgs",
+			src: fstest.MapFS{
+				"Makefile": {Data: []byte("PREFIX = @prefix@\n")},
+			},
+			args:    []string{"--file", "Makefile", "--replace", "@prefix@"},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			src := filepath.Join(dir, testStoreObjectName("src", 0))
+			if err := os.CopyFS(src, test.src); err != nil {
+				t.Fatal(err)
+			}
+			out := filepath.Join(dir, testStoreObjectName("out", 9))
+			drv := &zbstore.Derivation{
+				Dir:     zbstore.Directory(dir),
+				Builder: builtinBuilderPrefix + "substitute",
+				System:  builtinSystem,
+				Args:    test.args,
+				Env: map[string]string{
+					"src": src,
+					"out": out,
+				},
+			}
+
+			err := substitute(drv, dir)
+			if test.wantErr {
+				if err == nil {
+					t.Error("substitute did not return an error")
+				} else {
+					t.Log("substitute:", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("substitute:", err)
+			}
+			if diff := diffFS(t, withRoot(test.want), os.DirFS(out)); diff != "" {
+				t.Errorf("output (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestPatchBuiltinsOutsideStore(t *testing.T) {
+	// A directory outside the store containing a file that must not be copied.
+	hostDir := t.TempDir()
+	secret := filepath.Join(hostDir, "secret")
+	if err := os.WriteFile(secret, []byte("hunter2\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	const patch = "--- a/secret\n" +
+		"+++ b/secret\n" +
+		"@@ -1 +1 @@\n" +
+		"-hunter2\n" +
+		"+*******\n"
+
+	tests := []struct {
+		name    string
+		builtin func(drv *zbstore.Derivation, realStoreDir string) error
+		env     func(dir string) map[string]string
+		args    []string
+	}{
+		{
+			name:    "PatchSource",
+			builtin: applyPatches,
+			env: func(dir string) map[string]string {
+				return map[string]string{
+					"src":     secret,
+					"patches": filepath.Join(dir, testStoreObjectName("fix.patch", 1)),
+				}
+			},
+		},
+		{
+			name:    "PatchSourceSymlink",
+			builtin: applyPatches,
+			env: func(dir string) map[string]string {
+				return map[string]string{
+					"src":     filepath.Join(dir, testStoreObjectName("link", 2), "secret"),
+					"patches": filepath.Join(dir, testStoreObjectName("fix.patch", 1)),
+				}
+			},
+		},
+		{
+			name:    "PatchFile",
+			builtin: applyPatches,
+			env: func(dir string) map[string]string {
+				return map[string]string{
+					"src":     filepath.Join(dir, testStoreObjectName("src", 0)),
+					"patches": filepath.Join(hostDir, "fix.patch"),
+				}
+			},
+		},
+		{
+			name:    "SubstituteSource",
+			builtin: substitute,
+			env: func(dir string) map[string]string {
+				return map[string]string{"src": secret}
+			},
+			args: []string{"--replace", "hunter2", "*******"},
+		},
+		{
+			name:    "SubstituteSourceSymlink",
+			builtin: substitute,
+			env: func(dir string) map[string]string {
+				return map[string]string{"src": filepath.Join(dir, testStoreObjectName("link", 2), "secret")}
+			},
+			args: []string{"--replace", "hunter2", "*******"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, testStoreObjectName("src", 0)), []byte("hunter2\n"), 0o666); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(dir, testStoreObjectName("fix.patch", 1)), []byte(patch), 0o666); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(hostDir, "fix.patch"), []byte(patch), 0o666); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Symlink(hostDir, filepath.Join(dir, testStoreObjectName("link", 2))); err != nil {
+				t.Fatal(err)
+			}
+			out := filepath.Join(dir, testStoreObjectName("out", 9))
+			env := test.env(dir)
+			env["out"] = out
+			drv := &zbstore.Derivation{
+				Dir:    zbstore.Directory(dir),
+				System: builtinSystem,
+				Args:   test.args,
+				Env:    env,
+			}
+
+			if err := test.builtin(drv, dir); err == nil {
+				t.Error("builtin did not return an error")
+			} else {
+				t.Log("builtin:", err)
+			}
+			if got, err := os.ReadFile(out); err == nil && strings.Contains(string(got), "*") {
+				t.Errorf("output = %q; want no output", got)
+			}
+		})
+	}
+}
+
+func TestPatchBuiltinsSymlinkSource(t *testing.T) {
+	hostFile := filepath.Join(t.TempDir(), "config")
+	const hostContent = "hunter2\n"
+	const patch = "--- a/config\n" +
+		"+++ b/config\n" +
+		"@@ -1 +1 @@\n" +
+		"-hunter2\n" +
+		"+*******\n"
+
+	tests := []struct {
+		name    string
+		builtin func(drv *zbstore.Derivation, realStoreDir string) error
+		patches bool
+		args    []string
+	}{
+		{name: "Patch", builtin: applyPatches, patches: true},
+		{name: "Substitute", builtin: substitute, args: []string{"--replace", "hunter2", "*******"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := os.WriteFile(hostFile, []byte(hostContent), 0o666); err != nil {
+				t.Fatal(err)
+			}
+			dir := t.TempDir()
+			// The symlink is inside the store, so it is copied to the output as-is.
+			src := filepath.Join(dir, testStoreObjectName("config", 0))
+			if err := os.Symlink(hostFile, src); err != nil {
+				t.Fatal(err)
+			}
+			env := map[string]string{
+				"src": src,
+				"out": filepath.Join(dir, testStoreObjectName("out", 9)),
+			}
+			if test.patches {
+				patchPath := filepath.Join(dir, testStoreObjectName("fix.patch", 1))
+				if err := os.WriteFile(patchPath, []byte(patch), 0o666); err != nil {
+					t.Fatal(err)
+				}
+				env["patches"] = patchPath
+			}
+			drv := &zbstore.Derivation{
+				Dir:    zbstore.Directory(dir),
+				System: builtinSystem,
+				Args:   test.args,
+				Env:    env,
+			}
+
+			if err := test.builtin(drv, dir); err == nil {
+				t.Error("builtin did not return an error")
+			} else {
+				t.Log("builtin:", err)
+			}
+			if got, err := os.ReadFile(hostFile); err != nil {
+				t.Error(err)
+			} else if string(got) != hostContent {
+				t.Errorf("symlink target content = %q; want %q", got, hostContent)
+			}
+		})
+	}
+}
+
+// testStoreObjectName returns a store object name
+// with a made-up digest derived from i.
+func testStoreObjectName(name string, i int) string {
+	return strings.Repeat(string(rune('0'+i)), 32) + "-" + name
+}
+
+// withRoot returns a copy of fsys with entries for "." and any implicit parent directories,
+// so that it can be compared with a directory on disk using [diffFS].
+func withRoot(fsys fstest.MapFS) fstest.MapFS {
+	result := make(fstest.MapFS, len(fsys)+1)
+	result["."] = &fstest.MapFile{Mode: os.ModeDir}
+	for name, f := range fsys {
+		result[name] = f
+		for dir := filepath.ToSlash(filepath.Dir(name)); dir != "."; dir = filepath.ToSlash(filepath.Dir(dir)) {
+			result[dir] = &fstest.MapFile{Mode: os.ModeDir}
+		}
+	}
+	return result
+}
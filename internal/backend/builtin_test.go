@@ -222,7 +222,7 @@ func TestExtractTar(t *testing.T) {
 
 			t.Run("Default", func(t *testing.T) {
 				dir := t.TempDir()
-				err := extractTar(filepath.Join(dir, test.dst), bytes.NewReader(buf.Bytes()), false)
+				err := extractTar(filepath.Join(dir, test.dst), bytes.NewReader(buf.Bytes()), false, newCaseHazardChecker(true))
 				if err != nil {
 					t.Error("extractTar:", err)
 				}
@@ -233,7 +233,7 @@ func TestExtractTar(t *testing.T) {
 
 			t.Run("StripFirstComponent", func(t *testing.T) {
 				dir := t.TempDir()
-				err := extractTar(filepath.Join(dir, test.dst), bytes.NewReader(buf.Bytes()), true)
+				err := extractTar(filepath.Join(dir, test.dst), bytes.NewReader(buf.Bytes()), true, newCaseHazardChecker(true))
 				if test.wantStripped == nil {
 					if err == nil {
 						t.Error("extractTar did not return an error")
@@ -377,7 +377,7 @@ func TestExtractZip(t *testing.T) {
 
 			t.Run("Default", func(t *testing.T) {
 				dir := t.TempDir()
-				err := extractZip(filepath.Join(dir, test.dst), bytes.NewReader(buf.Bytes()), int64(buf.Len()), false)
+				err := extractZip(filepath.Join(dir, test.dst), bytes.NewReader(buf.Bytes()), int64(buf.Len()), false, newCaseHazardChecker(true))
 				if err != nil {
 					t.Error("extractZip:", err)
 				}
@@ -388,7 +388,7 @@ func TestExtractZip(t *testing.T) {
 
 			t.Run("StripFirstComponent", func(t *testing.T) {
 				dir := t.TempDir()
-				err := extractZip(filepath.Join(dir, test.dst), bytes.NewReader(buf.Bytes()), int64(buf.Len()), true)
+				err := extractZip(filepath.Join(dir, test.dst), bytes.NewReader(buf.Bytes()), int64(buf.Len()), true, newCaseHazardChecker(true))
 				if test.wantStripped == nil {
 					if err == nil {
 						t.Error("extractTar did not return an error")
@@ -0,0 +1,94 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// A caseHazardChecker detects paths that would collide
+// on a case-insensitive filesystem.
+// Extracting such paths onto a case-insensitive filesystem
+// would silently merge distinct files,
+// so a caseHazardChecker is used to reject them instead.
+// The nil caseHazardChecker accepts all paths.
+type caseHazardChecker map[string]string
+
+// newCaseHazardChecker returns a new [caseHazardChecker]
+// if caseInsensitive is true or nil otherwise.
+func newCaseHazardChecker(caseInsensitive bool) caseHazardChecker {
+	if !caseInsensitive {
+		return nil
+	}
+	return make(caseHazardChecker)
+}
+
+// check records the slash-separated path and its parent directories
+// and returns an error if a previously checked path
+// differs from any of them only in case.
+func (c caseHazardChecker) check(path string) error {
+	if c == nil {
+		return nil
+	}
+	for i := range len(path) {
+		if path[i] == '/' {
+			if err := c.checkOne(path[:i]); err != nil {
+				return err
+			}
+		}
+	}
+	return c.checkOne(path)
+}
+
+func (c caseHazardChecker) checkOne(path string) error {
+	key := foldCase(path)
+	if prev, ok := c[key]; ok && prev != path {
+		return fmt.Errorf("%s and %s differ only in case, which the store's filesystem does not distinguish", prev, path)
+	}
+	c[key] = path
+	return nil
+}
+
+// foldCase returns a string such that
+// foldCase(s) == foldCase(t) if and only if [strings.EqualFold](s, t).
+func foldCase(s string) string {
+	return strings.Map(func(r rune) rune {
+		// SimpleFold iterates through the equivalent runes in ascending order,
+		// so the smallest is the one that wraps around.
+		smallest := r
+		for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+			smallest = min(smallest, f)
+		}
+		return smallest
+	}, s)
+}
+
+// isCaseInsensitiveDir reports whether the filesystem at dir
+// treats file names that differ only in case as the same file.
+func isCaseInsensitiveDir(dir string) (bool, error) {
+	f, err := os.CreateTemp(dir, ".zb-case-check-*")
+	if err != nil {
+		return false, err
+	}
+	name := f.Name()
+	defer os.Remove(name)
+	info, err := f.Stat()
+	f.Close()
+	if err != nil {
+		return false, err
+	}
+	upperInfo, err := os.Lstat(filepath.Join(dir, strings.ToUpper(filepath.Base(name))))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return os.SameFile(info, upperInfo), nil
+}
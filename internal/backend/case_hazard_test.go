@@ -0,0 +1,115 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package backend
+
+import (
+	"bytes"
+	"io/fs"
+	"path/filepath"
+	"testing"
+
+	"zombiezen.com/go/nix/nar"
+)
+
+func TestCaseHazardChecker(t *testing.T) {
+	tests := []struct {
+		name    string
+		paths   []string
+		wantErr bool
+	}{
+		{
+			name:  "Distinct",
+			paths: []string{"a.txt", "b.txt", "dir/a.txt"},
+		},
+		{
+			name:  "ExactDuplicate",
+			paths: []string{"a.txt", "a.txt"},
+		},
+		{
+			name:    "File",
+			paths:   []string{"Makefile", "makefile"},
+			wantErr: true,
+		},
+		{
+			name:    "Directory",
+			paths:   []string{"include/Foo", "INCLUDE/Foo"},
+			wantErr: true,
+		},
+		{
+			name:    "ParentDirectory",
+			paths:   []string{"Foo/a", "foo/b"},
+			wantErr: true,
+		},
+		{
+			name:    "FileAndDirectory",
+			paths:   []string{"foo", "FOO/bar"},
+			wantErr: true,
+		},
+		{
+			name:  "SameParentDirectory",
+			paths: []string{"dir", "dir/a", "dir/sub/b"},
+		},
+		{
+			name:    "Unicode",
+			paths:   []string{"straße", "STRAẞE"},
+			wantErr: true,
+		},
+		{
+			name:    "KelvinSign",
+			paths:   []string{"k", "K"},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hazards := newCaseHazardChecker(true)
+			var err error
+			for _, p := range test.paths {
+				if err = hazards.check(p); err != nil {
+					break
+				}
+			}
+			if err != nil && !test.wantErr {
+				t.Errorf("check(...) = %v", err)
+			} else if err == nil && test.wantErr {
+				t.Errorf("checking %q did not return an error", test.paths)
+			}
+
+			var noHazards caseHazardChecker
+			for _, p := range test.paths {
+				if err := noHazards.check(p); err != nil {
+					t.Errorf("nil checker: check(%q) = %v", p, err)
+				}
+			}
+		})
+	}
+}
+
+func TestExtractNARCaseHazard(t *testing.T) {
+	buf := new(bytes.Buffer)
+	nw := nar.NewWriter(buf)
+	if err := nw.WriteHeader(&nar.Header{Mode: fs.ModeDir | 0o755}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"README", "readme"} {
+		if err := nw.WriteHeader(&nar.Header{Path: name, Mode: 0o644, Size: 1}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := nw.Write([]byte("x")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := nw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := filepath.Join(t.TempDir(), "out")
+	err := extractNAR(dst, bytes.NewReader(buf.Bytes()), newCaseHazardChecker(true))
+	if err == nil {
+		t.Error("extractNAR did not return an error")
+	} else {
+		t.Log("extractNAR returned:", err)
+	}
+}
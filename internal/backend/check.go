@@ -0,0 +1,294 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package backend
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"zb.256lights.llc/pkg/internal/osutil"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+	"zb.256lights.llc/pkg/sets"
+	"zb.256lights.llc/pkg/zbstore"
+	"zombiezen.com/go/log"
+	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+// checkSuffix is the suffix appended to a store object's real path
+// to store a rebuilt output that differs from the existing realization.
+const checkSuffix = ".check"
+
+// checkRealizations runs the builder for the derivation in state
+// and verifies that the new outputs are identical
+// to the realizations that [*builder.reuseRealizations] found.
+// Outputs are compared by content address,
+// so self references do not cause spurious differences.
+// If any output differs, checkRealizations writes the differing files to the build log
+// and returns a builder failure.
+//
+// checkRealizations must only be called after reuseRealizations succeeded for state.
+// It finalizes the build result again to record the outcome of the check.
+func (b *builder) checkRealizations(ctx context.Context, conn *sqlite.Conn, state *derivationBuildState, keepFailed bool) (err error) {
+	drvPath := state.drvPath
+	for _, outputType := range state.derivation.Outputs {
+		if _, ok := outputType.FixedCA(); ok {
+			log.Infof(ctx, "Not checking %s: fixed outputs are verified by their content address", drvPath)
+			return nil
+		}
+	}
+	log.Infof(ctx, "Checking %s...", drvPath)
+	b.recordRebuildReason(ctx, conn, state, &zbstorerpc.RebuildReason{Kind: zbstorerpc.RebuildCheck})
+
+	defer func() {
+		endFn, txError := sqlitex.ImmediateTransaction(conn)
+		if txError != nil {
+			log.Warnf(ctx, "For build %s: %v", drvPath, txError)
+			return
+		}
+		finalizeError := finalizeBuildResult(ctx, conn, b.server.logDir, &buildFinalResults{
+			buildID: b.id,
+			drvPath: drvPath,
+			id:      state.buildResultID,
+			endTime: time.Now(),
+			error:   err,
+		})
+		endFn(&finalizeError)
+		if finalizeError != nil {
+			log.Warnf(ctx, "For build %s: %v", drvPath, finalizeError)
+		}
+	}()
+
+	runner, err := b.prepareRunner(ctx, state)
+	if err != nil {
+		return err
+	}
+	buildUser, release, err := b.reserveBuilder(ctx, drvPath)
+	if err != nil {
+		return err
+	}
+	defer release()
+	tempOutPaths, err := b.runBuilder(ctx, conn, drvPath, state.buildResultID, keepFailed, buildUser, runner)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for _, outPath := range tempOutPaths {
+			if err := osutil.ForceRemoveAll(b.server.realPath(outPath)); err != nil {
+				log.Warnf(ctx, "Cleanup failure: %v", err)
+			}
+		}
+	}()
+
+	inputs, err := b.inputs(conn, drvPath)
+	if err != nil {
+		return err
+	}
+	inputPaths := sets.CollectSorted(maps.Keys(inputs))
+	var mismatched []string
+	for _, outputName := range slices.Sorted(maps.Keys(tempOutPaths)) {
+		ref := zbstore.OutputReference{
+			DrvPath:    drvPath,
+			OutputName: outputName,
+		}
+		existingPath, ok := b.lookup(ref)
+		if !ok {
+			log.Debugf(ctx, "Not checking %v: no existing realization", ref)
+			continue
+		}
+		same, err := b.checkOutput(ctx, ref, tempOutPaths[outputName], existingPath, inputPaths, keepFailed)
+		if err != nil {
+			return fmt.Errorf("check %s: %v", drvPath, err)
+		}
+		if !same {
+			mismatched = append(mismatched, "$"+outputName)
+		}
+	}
+	if len(mismatched) > 0 {
+		return builderFailure{fmt.Errorf("nondeterministic build: %s differ from existing realizations", strings.Join(mismatched, ", "))}
+	}
+
+	log.Infof(ctx, "Checked %s: outputs are identical to existing realizations", drvPath)
+	return nil
+}
+
+// checkOutput compares the store object at buildPath
+// that was produced by rebuilding the given output
+// with the store object at existingPath.
+// If they differ, checkOutput writes a report to the builder log
+// and reports false.
+// The caller is responsible for removing buildPath.
+func (b *builder) checkOutput(ctx context.Context, ref zbstore.OutputReference, buildPath, existingPath zbstore.Path, inputs *sets.Sorted[zbstore.Path], keepFailed bool) (same bool, err error) {
+	drv := b.derivations[ref.DrvPath]
+	if drv == nil {
+		return false, fmt.Errorf("output %s: unknown derivation", ref.OutputName)
+	}
+	realBuildPath := b.server.realPath(buildPath)
+	scan, err := scanFloatingOutput(ctx, realBuildPath, buildPath.Digest(), drv.Outputs[ref.OutputName], inputs, b.server.caCreateTemp)
+	if err != nil {
+		return false, fmt.Errorf("output %s: %v", ref.OutputName, err)
+	}
+	rebuiltPath, err := zbstore.FixedCAOutputPath(buildPath.Dir(), buildPath.Name(), scan.ca, scan.refs)
+	if err != nil {
+		return false, fmt.Errorf("output %s: %v", ref.OutputName, err)
+	}
+	if rebuiltPath == existingPath {
+		log.Debugf(ctx, "Rebuilt %v is identical to %s", ref, existingPath)
+		return true, nil
+	}
+	log.Debugf(ctx, "Rebuilt %v as %s, but existing realization is %s", ref, rebuiltPath, existingPath)
+
+	// Point self references at the existing store object
+	// so that they don't show up as differences.
+	if err := rewriteSelfReferences(realBuildPath, existingPath.Digest(), scan.analysis); err != nil {
+		return false, fmt.Errorf("output %s: %v", ref.OutputName, err)
+	}
+	realExistingPath := b.server.realPath(existingPath)
+	checkPath := realExistingPath + checkSuffix
+	if err := osutil.ForceRemoveAll(checkPath); err != nil {
+		return false, fmt.Errorf("output %s: %v", ref.OutputName, err)
+	}
+	if err := os.Rename(realBuildPath, checkPath); err != nil {
+		return false, fmt.Errorf("output %s: %v", ref.OutputName, err)
+	}
+	kept := keepFailed && b.server.allowKeepFailed
+	if !kept {
+		defer func() {
+			if err := osutil.ForceRemoveAll(checkPath); err != nil {
+				log.Warnf(ctx, "Cleanup failure: %v", err)
+			}
+		}()
+	}
+
+	diffs, err := diffFilesystemObjects(realExistingPath, checkPath)
+	if err != nil {
+		return false, fmt.Errorf("output %s: %v", ref.OutputName, err)
+	}
+	var buf []byte
+	buf = fmt.Appendf(buf, "*** Output $%s differs from %s (rebuilt as %s)\n", ref.OutputName, existingPath, rebuiltPath)
+	if len(diffs) == 0 {
+		buf = append(buf, "No files differ, but references do.\n"...)
+	}
+	for _, d := range diffs {
+		buf = append(buf, d...)
+		buf = append(buf, '\n')
+	}
+	if kept {
+		buf = append(buf, "Rebuilt output available at "...)
+		buf = append(buf, checkPath...)
+		buf = append(buf, '\n')
+	}
+	if err := appendToBuilderLog(b.server.logDir, b.id, ref.DrvPath, buf); err != nil {
+		log.Warnf(ctx, "Failed to write check results to log: %v", err)
+	}
+	return false, nil
+}
+
+// fileSummary is the information about a single file
+// that [diffFilesystemObjects] compares.
+type fileSummary struct {
+	typ        fs.FileMode
+	executable bool
+	size       int64
+	hash       [sha256.Size]byte
+	target     string
+}
+
+// diffFilesystemObjects returns a description of each file that differs
+// between the filesystem objects at oldPath and newPath
+// in lexical order of their paths.
+// Like NARs, only file types, executable bits, contents, and symlink targets are compared.
+func diffFilesystemObjects(oldPath, newPath string) ([]string, error) {
+	oldFiles, err := summarizeFiles(oldPath)
+	if err != nil {
+		return nil, err
+	}
+	newFiles, err := summarizeFiles(newPath)
+	if err != nil {
+		return nil, err
+	}
+
+	names := sets.CollectSorted(maps.Keys(oldFiles))
+	names.AddSeq(maps.Keys(newFiles))
+	var diffs []string
+	for _, name := range names.All() {
+		oldFile, inOld := oldFiles[name]
+		newFile, inNew := newFiles[name]
+		switch {
+		case !inNew:
+			diffs = append(diffs, "removed: "+name)
+		case !inOld:
+			diffs = append(diffs, "added: "+name)
+		case oldFile.typ != newFile.typ:
+			diffs = append(diffs, fmt.Sprintf("type changed: %s (%v -> %v)", name, oldFile.typ, newFile.typ))
+		case oldFile.target != newFile.target:
+			diffs = append(diffs, fmt.Sprintf("symlink changed: %s (%q -> %q)", name, oldFile.target, newFile.target))
+		case oldFile.executable != newFile.executable:
+			diffs = append(diffs, fmt.Sprintf("executable changed: %s (%t -> %t)", name, oldFile.executable, newFile.executable))
+		case oldFile.hash != newFile.hash:
+			diffs = append(diffs, fmt.Sprintf("contents changed: %s (%d bytes -> %d bytes)", name, oldFile.size, newFile.size))
+		}
+	}
+	return diffs, nil
+}
+
+// summarizeFiles returns a map of slash-separated paths relative to root
+// to the summary of the file at that path.
+// The root itself is named ".".
+func summarizeFiles(root string) (map[string]fileSummary, error) {
+	files := make(map[string]fileSummary)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		summary := fileSummary{typ: info.Mode().Type()}
+		switch summary.typ {
+		case 0:
+			summary.executable = info.Mode()&0o100 != 0
+			summary.size = info.Size()
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			h := sha256.New()
+			_, err = io.Copy(h, f)
+			f.Close()
+			if err != nil {
+				return fmt.Errorf("read %s: %v", path, err)
+			}
+			h.Sum(summary.hash[:0])
+		case fs.ModeDir:
+		case fs.ModeSymlink:
+			summary.target, err = os.Readlink(path)
+			if err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("%s: unsupported file type", path)
+		}
+		files[filepath.ToSlash(name)] = summary
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
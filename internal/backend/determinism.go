@@ -0,0 +1,128 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package backend
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"zb.256lights.llc/pkg/internal/xmaps"
+)
+
+// defaultSourceDateEpoch is the default value of [DeterminismOptions.SourceDateEpoch].
+// It is the earliest timestamp that can be stored in a ZIP file.
+var defaultSourceDateEpoch = time.Date(1980, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// DeterminismOptions is the set of options for Determinism in [Options].
+// The zero value does not alter the builder's environment.
+// A derivation can opt out of these measures
+// by setting its __deterministic environment variable to "0",
+// or opt out of individual measures
+// by setting it to a space-separated list of measure names,
+// each prefixed with a "-" (e.g. "-faketime -umask").
+// The measure names are "env" for NormalizeEnvironment,
+// "faketime" for FakeTimeLibrary, and "umask" for EnforceUmask.
+//
+// Sandboxed builders always receive a private /tmp directory,
+// regardless of these options.
+type DeterminismOptions struct {
+	// If NormalizeEnvironment is true,
+	// then builders will have TZ, LANG, and SOURCE_DATE_EPOCH set to fixed values
+	// unless the derivation sets them explicitly.
+	NormalizeEnvironment bool
+	// SourceDateEpoch is the time used for SOURCE_DATE_EPOCH
+	// and as the starting time for builders' clocks.
+	// If zero, then 1980-01-01T00:00:00Z is used.
+	SourceDateEpoch time.Time
+	// FakeTimeLibrary is the path to a libfaketime shared library on the host.
+	// If not empty, then builders' clocks will start at SourceDateEpoch
+	// by preloading the library into the builder.
+	// The library will be made available in the sandbox.
+	FakeTimeLibrary string
+	// If EnforceUmask is true, then builders start with a umask of 022
+	// regardless of the server process's umask.
+	EnforceUmask bool
+}
+
+// IsZero reports whether opts has no effect on builders.
+func (opts *DeterminismOptions) IsZero() bool {
+	return opts == nil || !opts.NormalizeEnvironment && opts.FakeTimeLibrary == "" && !opts.EnforceUmask
+}
+
+// forDerivation returns the measures to take for a derivation
+// whose __deterministic environment variable has the given value
+// (see [DeterminismOptions] for the syntax),
+// or nil if the builder's environment should not be altered.
+func (opts *DeterminismOptions) forDerivation(value string) (*DeterminismOptions, error) {
+	if value == "0" || opts.IsZero() {
+		return nil, nil
+	}
+	if value == "" || value == "1" {
+		return opts, nil
+	}
+	result := new(*opts)
+	for word := range strings.FieldsSeq(value) {
+		switch word {
+		case "-env":
+			result.NormalizeEnvironment = false
+		case "-faketime":
+			result.FakeTimeLibrary = ""
+		case "-umask":
+			result.EnforceUmask = false
+		default:
+			return nil, fmt.Errorf("%s: unknown option %q (must be \"0\", \"1\", or a list of \"-env\", \"-faketime\", or \"-umask\")",
+				deterministicVar, word)
+		}
+	}
+	if result.IsZero() {
+		return nil, nil
+	}
+	return result, nil
+}
+
+func (opts *DeterminismOptions) sourceDateEpoch() time.Time {
+	if opts.SourceDateEpoch.IsZero() {
+		return defaultSourceDateEpoch
+	}
+	return opts.SourceDateEpoch
+}
+
+// fillEnv sets the environment variables in m that opts calls for.
+// Variables that are already set in m are not changed.
+// It is safe to call fillEnv on a nil pointer.
+func (opts *DeterminismOptions) fillEnv(m map[string]string) {
+	if opts == nil {
+		return
+	}
+	if opts.NormalizeEnvironment {
+		xmaps.SetDefault(m, "TZ", "UTC")
+		xmaps.SetDefault(m, "LANG", "C.UTF-8")
+		xmaps.SetDefault(m, "SOURCE_DATE_EPOCH", strconv.FormatInt(opts.sourceDateEpoch().Unix(), 10))
+	}
+	if opts.FakeTimeLibrary != "" {
+		if preload := m["LD_PRELOAD"]; preload != "" {
+			m["LD_PRELOAD"] = opts.FakeTimeLibrary + " " + preload
+		} else {
+			m["LD_PRELOAD"] = opts.FakeTimeLibrary
+		}
+		xmaps.SetDefault(m, "FAKETIME", "@"+opts.sourceDateEpoch().UTC().Format(time.DateTime))
+	}
+}
+
+// fakeTimeLibrary returns opts.FakeTimeLibrary.
+// It is safe to call fakeTimeLibrary on a nil pointer.
+func (opts *DeterminismOptions) fakeTimeLibrary() string {
+	if opts == nil {
+		return ""
+	}
+	return opts.FakeTimeLibrary
+}
+
+// umask reports whether builders should run with an enforced umask.
+// It is safe to call umask on a nil pointer.
+func (opts *DeterminismOptions) umask() bool {
+	return opts != nil && opts.EnforceUmask
+}
@@ -0,0 +1,61 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package backend
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDeterminismForDerivation(t *testing.T) {
+	all := &DeterminismOptions{
+		NormalizeEnvironment: true,
+		FakeTimeLibrary:      "/lib/libfaketime.so",
+		EnforceUmask:         true,
+	}
+	tests := []struct {
+		opts    *DeterminismOptions
+		value   string
+		want    *DeterminismOptions
+		wantErr bool
+	}{
+		{opts: all, value: "", want: all},
+		{opts: all, value: "1", want: all},
+		{opts: all, value: "0", want: nil},
+		{opts: new(DeterminismOptions), value: "", want: nil},
+		{
+			opts:  all,
+			value: "-faketime -umask",
+			want:  &DeterminismOptions{NormalizeEnvironment: true},
+		},
+		{
+			opts:  all,
+			value: "-env",
+			want: &DeterminismOptions{
+				FakeTimeLibrary: "/lib/libfaketime.so",
+				EnforceUmask:    true,
+			},
+		},
+		{opts: all, value: "-env -faketime -umask", want: nil},
+		{opts: all, value: "-network", wantErr: true},
+		{opts: all, value: "umask", wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := test.opts.forDerivation(test.value)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("forDerivation(%q) = %+v, <nil>; want error", test.value, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("forDerivation(%q): %v", test.value, err)
+			continue
+		}
+		if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("forDerivation(%q) (-want +got):\n%s", test.value, diff)
+		}
+	}
+}
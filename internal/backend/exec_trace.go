@@ -0,0 +1,178 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package backend
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	jsonv2 "github.com/go-json-experiment/json"
+	"github.com/google/uuid"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+	"zb.256lights.llc/pkg/sets"
+	"zb.256lights.llc/pkg/zbstore"
+)
+
+// traceOptions is the set of things that [runTracedCommand] records.
+type traceOptions struct {
+	// exec is where to write the exec trace
+	// as described in [execTracer].
+	// If nil, then executed programs are not recorded.
+	exec io.Writer
+	// openFile is called with the absolute path (as seen by the builder)
+	// of each file that the builder successfully opens or executes.
+	// It is called once for the path the builder asked for
+	// and once for the file's path after resolving symlinks,
+	// so it may be called more than once per file.
+	// If nil, then opened files are not recorded.
+	openFile func(path string)
+}
+
+// storeObjectRecorder returns a function suitable for [traceOptions.openFile]
+// that adds the store object in dir that contains each path to set.
+// Paths outside dir are ignored.
+func storeObjectRecorder(dir zbstore.Directory, set sets.Set[zbstore.Path]) func(path string) {
+	return func(path string) {
+		if p, _, err := dir.ParsePath(path); err == nil {
+			set.Add(p)
+		}
+	}
+}
+
+// execTracer records the programs that a traced builder executes
+// as [zbstorerpc.ExecTraceEvent] JSON objects, one per line.
+// Events are written when the program ends.
+// If w is nil, then events are discarded.
+type execTracer struct {
+	w   io.Writer
+	now func() time.Time
+	// running is the map of process IDs
+	// to the programs they are currently running.
+	running map[int]*zbstorerpc.ExecTraceEvent
+	// err is the first error encountered while writing to w.
+	err error
+}
+
+func newExecTracer(w io.Writer) *execTracer {
+	return &execTracer{
+		w:       w,
+		now:     time.Now,
+		running: make(map[int]*zbstorerpc.ExecTraceEvent),
+	}
+}
+
+// exec records that the process with the given ID
+// started running the given program.
+// Any program the process was previously running is ended.
+func (t *execTracer) exec(pid int, argv []string, dir string) {
+	now := t.now()
+	t.end(pid, now, zbstorerpc.Nullable[int]{})
+	t.running[pid] = &zbstorerpc.ExecTraceEvent{
+		PID:       pid,
+		Argv:      argv,
+		Dir:       dir,
+		StartedAt: now,
+	}
+}
+
+// exit records that the process with the given ID exited with the given status.
+func (t *execTracer) exit(pid int, status syscall.WaitStatus) {
+	code := status.ExitStatus()
+	if status.Signaled() {
+		code = 128 + int(status.Signal())
+	}
+	t.end(pid, t.now(), zbstorerpc.NonNull(code))
+}
+
+// finish ends all running programs without exit codes
+// and returns the first error encountered while writing events.
+func (t *execTracer) finish() error {
+	now := t.now()
+	for pid := range t.running {
+		t.end(pid, now, zbstorerpc.Nullable[int]{})
+	}
+	return t.err
+}
+
+func (t *execTracer) end(pid int, now time.Time, exitCode zbstorerpc.Nullable[int]) {
+	ev := t.running[pid]
+	if ev == nil {
+		return
+	}
+	delete(t.running, pid)
+	ev.EndedAt = now
+	ev.ExitCode = exitCode
+	if t.w == nil || t.err != nil {
+		return
+	}
+	line, err := jsonv2.Marshal(ev)
+	if err != nil {
+		t.err = fmt.Errorf("exec trace: %v", err)
+		return
+	}
+	line = append(line, '\n')
+	if _, err := t.w.Write(line); err != nil {
+		t.err = fmt.Errorf("exec trace: %v", err)
+	}
+}
+
+// tracedExitError is the error returned by [runTracedCommand]
+// when the builder exits unsuccessfully.
+// Like [*exec.ExitError], it has ExitCode and Sys methods.
+type tracedExitError struct {
+	status syscall.WaitStatus
+}
+
+func (e tracedExitError) Error() string {
+	if e.status.Signaled() {
+		return "signal: " + e.status.Signal().String()
+	}
+	return fmt.Sprintf("exit status %d", e.status.ExitStatus())
+}
+
+// ExitCode returns the exit code of the builder
+// or -1 if the builder was terminated by a signal.
+func (e tracedExitError) ExitCode() int {
+	return e.status.ExitStatus()
+}
+
+// Sys returns the builder's [syscall.WaitStatus].
+func (e tracedExitError) Sys() any {
+	return e.status
+}
+
+// trimRoot returns dir as seen by a process whose root directory is root.
+func trimRoot(dir, root string) string {
+	if root == "" || root == string(filepath.Separator) {
+		return dir
+	}
+	if dir == root {
+		return string(filepath.Separator)
+	}
+	if rest, ok := strings.CutPrefix(dir, root); ok && strings.HasPrefix(rest, string(filepath.Separator)) {
+		return rest
+	}
+	return dir
+}
+
+// builderTracePath returns the path of the exec trace
+// for the given derivation in the given build.
+func builderTracePath(dir string, buildID uuid.UUID, drvPath zbstore.Path) string {
+	return strings.TrimSuffix(builderLogPath(dir, buildID, drvPath), ".txt") + ".exec.jsonl"
+}
+
+// createBuilderTrace creates a new exec trace file for writing.
+// It must be called after [createBuilderLog].
+func createBuilderTrace(dir string, buildID uuid.UUID, drvPath zbstore.Path) (*os.File, error) {
+	f, err := os.OpenFile(builderTracePath(dir, buildID, drvPath), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o666)
+	if err != nil {
+		return nil, fmt.Errorf("create exec trace for %s in build %s: %v", drvPath.Base(), buildID, err)
+	}
+	return f, nil
+}
@@ -0,0 +1,388 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package backend
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// canTraceExec reports whether [runTracedCommand] is supported on this platform.
+func canTraceExec() bool {
+	return true
+}
+
+// runTracedCommand is like [runCommand],
+// but it also records what c and its descendants do
+// as described by opts using ptrace(2).
+// Any descendants still running when c exits are killed.
+func runTracedCommand(c *exec.Cmd, enforceUmask bool, opts *traceOptions) error {
+	errc := make(chan error, 1)
+	go func() {
+		// All ptrace requests must come from the thread that started the process.
+		// The thread is intentionally never unlocked
+		// so that it exits along with the goroutine,
+		// which kills any stray tracees (see PTRACE_O_EXITKILL).
+		runtime.LockOSThread()
+		errc <- traceCommand(c, enforceUmask, opts)
+	}()
+	return <-errc
+}
+
+const execTraceOptions = unix.PTRACE_O_TRACEEXEC |
+	unix.PTRACE_O_TRACEFORK |
+	unix.PTRACE_O_TRACEVFORK |
+	unix.PTRACE_O_TRACECLONE |
+	unix.PTRACE_O_EXITKILL
+
+// syscallStopSignal is the signal reported for syscall stops
+// when PTRACE_O_TRACESYSGOOD is set.
+const syscallStopSignal = unix.SIGTRAP | 0x80
+
+func traceCommand(c *exec.Cmd, enforceUmask bool, opts *traceOptions) error {
+	if c.SysProcAttr == nil {
+		c.SysProcAttr = new(syscall.SysProcAttr)
+	}
+	c.SysProcAttr.Ptrace = true
+	// The tracer's thread is never unlocked (see [runTracedCommand]),
+	// so it can start the builder with its own umask.
+	if enforceUmask {
+		if err := setThreadUmask(0o022); err != nil {
+			return err
+		}
+	}
+	if err := c.Start(); err != nil {
+		return err
+	}
+	// The reaping below races with the os/exec package's own wait,
+	// so ignore its error.
+	// It still waits for any I/O goroutines to finish.
+	defer c.Wait()
+
+	tracer := newExecTracer(opts.exec)
+	ptraceOptions := execTraceOptions
+	resume := unix.PtraceCont
+	var files *fileTracer
+	if opts.openFile != nil {
+		// Stop at every system call so that file opens can be observed.
+		ptraceOptions |= unix.PTRACE_O_TRACESYSGOOD
+		resume = unix.PtraceSyscall
+		files = &fileTracer{
+			openFile: opts.openFile,
+			pending:  make(map[int]pendingOpen),
+		}
+	}
+
+	mainPID := c.Process.Pid
+	var ws unix.WaitStatus
+	if _, err := wait4Retry(mainPID, &ws); err != nil {
+		return fmt.Errorf("trace %s: %v", c.Path, err)
+	}
+	if !ws.Stopped() {
+		// Process exited before reaching the initial exec stop.
+		return waitStatusError(ws)
+	}
+	if err := unix.PtraceSetOptions(mainPID, ptraceOptions); err != nil {
+		unix.Kill(mainPID, unix.SIGKILL)
+		return fmt.Errorf("trace %s: %v", c.Path, err)
+	}
+	tracer.exec(mainPID, readProcArgv(mainPID), readProcDir(mainPID))
+	files.exec(mainPID)
+	resume(mainPID, 0)
+
+	// alive is the set of traced processes that have not exited.
+	alive := map[int]struct{}{mainPID: {}}
+	var mainStatus unix.WaitStatus
+	mainExited := false
+	for len(alive) > 0 {
+		if mainExited {
+			// Kill any descendants left behind
+			// so that they don't hold on to the builder's output.
+			for pid := range alive {
+				unix.Kill(pid, unix.SIGKILL)
+			}
+		}
+		pid, err := wait4Retry(-1, &ws)
+		if err != nil {
+			// ECHILD: nothing left to wait for.
+			break
+		}
+		switch {
+		case ws.Exited() || ws.Signaled():
+			delete(alive, pid)
+			tracer.exit(pid, syscall.WaitStatus(ws))
+			files.exit(pid)
+			if pid == mainPID {
+				mainStatus = ws
+				mainExited = true
+			}
+		case ws.Stopped():
+			sig := ws.StopSignal()
+			if _, seen := alive[pid]; !seen {
+				// First stop of a newly created process.
+				alive[pid] = struct{}{}
+				if sig == unix.SIGSTOP {
+					resume(pid, 0)
+					continue
+				}
+			}
+			switch {
+			case sig == syscallStopSignal:
+				files.syscallStop(pid)
+				resume(pid, 0)
+			case sig == unix.SIGTRAP && ws.TrapCause() == unix.PTRACE_EVENT_EXEC:
+				tracer.exec(pid, readProcArgv(pid), readProcDir(pid))
+				files.exec(pid)
+				resume(pid, 0)
+			case sig == unix.SIGTRAP && ws.TrapCause() > 0:
+				// Fork, vfork, or clone event.
+				// The new process is traced automatically.
+				resume(pid, 0)
+			default:
+				// Deliver the signal to the tracee.
+				resume(pid, int(sig))
+			}
+		}
+	}
+	traceErr := tracer.finish()
+	if !mainExited {
+		return fmt.Errorf("trace %s: lost track of process", c.Path)
+	}
+	if err := waitStatusError(mainStatus); err != nil {
+		return err
+	}
+	return traceErr
+}
+
+func wait4Retry(pid int, ws *unix.WaitStatus) (int, error) {
+	for {
+		wpid, err := unix.Wait4(pid, ws, unix.WALL, nil)
+		if !errors.Is(err, unix.EINTR) {
+			return wpid, err
+		}
+	}
+}
+
+func waitStatusError(ws unix.WaitStatus) error {
+	if ws.Exited() && ws.ExitStatus() == 0 {
+		return nil
+	}
+	return tracedExitError{syscall.WaitStatus(ws)}
+}
+
+// fileTracer records the files that traced processes open.
+// Methods on a nil fileTracer do nothing.
+type fileTracer struct {
+	openFile func(path string)
+	// pending is the map of process IDs
+	// to the path that the process's current system call is opening.
+	pending map[int]pendingOpen
+}
+
+// pendingOpen is a path that a process is opening
+// as seen by the tracer.
+type pendingOpen struct {
+	path      string
+	returnsFD bool
+}
+
+// pathSyscall describes the arguments of a system call that opens a path.
+type pathSyscall struct {
+	// dirFDArg is the index of the argument that has the directory file descriptor
+	// that relative paths are resolved against,
+	// or -1 if relative paths are resolved against the working directory.
+	dirFDArg int
+	// pathArg is the index of the argument that has the path.
+	pathArg int
+	// returnsFD is true if the system call returns a file descriptor for the path.
+	returnsFD bool
+}
+
+// pathSyscalls is the set of system calls that [fileTracer] records.
+// Architectures that have older system calls add them to this map.
+var pathSyscalls = map[uint64]pathSyscall{
+	unix.SYS_OPENAT:   {dirFDArg: 0, pathArg: 1, returnsFD: true},
+	unix.SYS_OPENAT2:  {dirFDArg: 0, pathArg: 1, returnsFD: true},
+	unix.SYS_EXECVE:   {dirFDArg: -1, pathArg: 0},
+	unix.SYS_EXECVEAT: {dirFDArg: 0, pathArg: 1},
+}
+
+// ptraceSyscallInfo is struct ptrace_syscall_info from <linux/ptrace.h>.
+type ptraceSyscallInfo struct {
+	op                 uint8
+	_                  [3]uint8
+	arch               uint32
+	instructionPointer uint64
+	stackPointer       uint64
+	// data is the union of the entry, exit, and seccomp fields.
+	// On entry, data[0] is the system call number
+	// and data[1:7] are its arguments.
+	// On exit, data[0] is the return value
+	// and the low byte of data[1] is non-zero if the return value is an error.
+	data [8]uint64
+}
+
+func ptraceGetSyscallInfo(pid int, info *ptraceSyscallInfo) error {
+	_, _, errno := unix.Syscall6(
+		unix.SYS_PTRACE,
+		unix.PTRACE_GET_SYSCALL_INFO,
+		uintptr(pid),
+		unsafe.Sizeof(*info),
+		uintptr(unsafe.Pointer(info)),
+		0, 0,
+	)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// syscallStop handles a syscall-enter-stop or syscall-exit-stop.
+func (ft *fileTracer) syscallStop(pid int) {
+	if ft == nil {
+		return
+	}
+	var info ptraceSyscallInfo
+	if err := ptraceGetSyscallInfo(pid, &info); err != nil {
+		delete(ft.pending, pid)
+		return
+	}
+	switch info.op {
+	case unix.PTRACE_SYSCALL_INFO_ENTRY:
+		delete(ft.pending, pid)
+		sc, ok := pathSyscalls[info.data[0]]
+		if !ok {
+			return
+		}
+		args := info.data[1:7]
+		path, err := readProcString(pid, uintptr(args[sc.pathArg]))
+		if err != nil || path == "" {
+			return
+		}
+		// Paths read from /proc are relative to the tracer's root directory,
+		// so convert path to be relative to the tracer's root too.
+		var dir string
+		switch {
+		case filepath.IsAbs(path):
+			dir, err = os.Readlink(procPath(pid, "root"))
+		case sc.dirFDArg < 0 || int32(args[sc.dirFDArg]) == unix.AT_FDCWD:
+			dir, err = os.Readlink(procPath(pid, "cwd"))
+		default:
+			dir, err = os.Readlink(procPath(pid, "fd", strconv.Itoa(int(int32(args[sc.dirFDArg])))))
+		}
+		if err != nil {
+			return
+		}
+		ft.pending[pid] = pendingOpen{
+			path:      filepath.Join(dir, path),
+			returnsFD: sc.returnsFD,
+		}
+	case unix.PTRACE_SYSCALL_INFO_EXIT:
+		open, ok := ft.pending[pid]
+		if !ok {
+			return
+		}
+		delete(ft.pending, pid)
+		if isError := uint8(info.data[1]) != 0; isError {
+			return
+		}
+		root, _ := os.Readlink(procPath(pid, "root"))
+		ft.openFile(trimRoot(open.path, root))
+		if open.returnsFD {
+			fd := int64(info.data[0])
+			if opened, err := os.Readlink(procPath(pid, "fd", strconv.FormatInt(fd, 10))); err == nil && filepath.IsAbs(opened) {
+				ft.openFile(trimRoot(opened, root))
+			}
+		}
+	}
+}
+
+// exec records the program that the given process started executing.
+func (ft *fileTracer) exec(pid int) {
+	if ft == nil {
+		return
+	}
+	exe, err := os.Readlink(procPath(pid, "exe"))
+	if err != nil {
+		return
+	}
+	root, _ := os.Readlink(procPath(pid, "root"))
+	ft.openFile(trimRoot(exe, root))
+}
+
+// exit discards any state for the given process.
+func (ft *fileTracer) exit(pid int) {
+	if ft == nil {
+		return
+	}
+	delete(ft.pending, pid)
+}
+
+func procPath(pid int, elem ...string) string {
+	return "/proc/" + strconv.Itoa(pid) + "/" + strings.Join(elem, "/")
+}
+
+// readProcString reads a NUL-terminated string
+// from the given address in a stopped tracee's memory.
+func readProcString(pid int, addr uintptr) (string, error) {
+	f, err := os.Open(procPath(pid, "mem"))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	pageSize := uintptr(os.Getpagesize())
+	var buf []byte
+	for len(buf) < unix.PathMax {
+		// Don't read past the end of the page,
+		// since the next page may not be mapped.
+		n := int(pageSize - addr%pageSize)
+		start := len(buf)
+		buf = append(buf, make([]byte, n)...)
+		n, err := f.ReadAt(buf[start:], int64(addr))
+		buf = buf[:start+n]
+		if i := bytes.IndexByte(buf[start:], 0); i >= 0 {
+			return string(buf[:start+i]), nil
+		}
+		if err != nil {
+			return "", err
+		}
+		addr += uintptr(n)
+	}
+	return "", fmt.Errorf("read string from process %d: too long", pid)
+}
+
+// readProcArgv returns the argument list of the given process.
+func readProcArgv(pid int) []string {
+	data, err := os.ReadFile(procPath(pid, "cmdline"))
+	if err != nil || len(data) == 0 {
+		return nil
+	}
+	data = bytes.TrimSuffix(data, []byte{0})
+	return strings.Split(string(data), "\x00")
+}
+
+// readProcDir returns the working directory of the given process
+// relative to its root directory.
+func readProcDir(pid int) string {
+	dir, err := os.Readlink(procPath(pid, "cwd"))
+	if err != nil {
+		return ""
+	}
+	root, err := os.Readlink(procPath(pid, "root"))
+	if err != nil {
+		return dir
+	}
+	return trimRoot(dir, root)
+}
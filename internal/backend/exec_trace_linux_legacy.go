@@ -0,0 +1,14 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+//go:build linux && (amd64 || 386)
+
+package backend
+
+import "golang.org/x/sys/unix"
+
+func init() {
+	// These architectures still have the system calls that predate openat(2).
+	pathSyscalls[unix.SYS_OPEN] = pathSyscall{dirFDArg: -1, pathArg: 0, returnsFD: true}
+	pathSyscalls[unix.SYS_CREAT] = pathSyscall{dirFDArg: -1, pathArg: 0, returnsFD: true}
+}
@@ -0,0 +1,22 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+//go:build !linux
+
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+)
+
+// canTraceExec reports whether [runTracedCommand] is supported on this platform.
+func canTraceExec() bool {
+	return false
+}
+
+// runTracedCommand returns an error on this platform.
+func runTracedCommand(c *exec.Cmd, enforceUmask bool, opts *traceOptions) error {
+	return fmt.Errorf("trace %s: %w", c.Path, errors.ErrUnsupported)
+}
@@ -0,0 +1,139 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package backend
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"unique"
+
+	"github.com/google/uuid"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+	"zombiezen.com/go/log"
+	"zombiezen.com/go/sqlite"
+)
+
+// explainRebuild determines why none of the existing realizations
+// could be used for the derivation in state.
+// It should only be called after [*builder.reuseRealizations]
+// returned an error for which [isRealizationPlanningError] reports true.
+func (b *builder) explainRebuild(ctx context.Context, conn *sqlite.Conn, state *derivationBuildState) (*zbstorerpc.RebuildReason, error) {
+	rollback, err := readonlySavepoint(conn)
+	if err != nil {
+		return nil, fmt.Errorf("explain rebuild of %s: %v", state.drvPath, err)
+	}
+	defer rollback()
+
+	outputNames := make([]string, 0, state.outputNames.Len())
+	for outputName := range state.outputNames.All() {
+		outputNames = append(outputNames, outputName.Value())
+	}
+	slices.Sort(outputNames)
+
+	anyReuse := &zbstorerpc.ReusePolicy{All: true}
+	for _, outputName := range outputNames {
+		eqClass := equivalenceClass{
+			drvHashKey: state.derivationHashKey,
+			outputName: unique.Make(outputName),
+		}
+		present, absent, err := findPossibleRealizations(ctx, conn, eqClass, anyReuse)
+		if err != nil {
+			return nil, fmt.Errorf("explain rebuild of %s: %v", state.drvPath, err)
+		}
+		if len(present) == 0 && len(absent) == 0 {
+			return b.explainMissingRealizations(conn, state, outputName)
+		}
+		if b.reusePolicy.IsZero() {
+			return &zbstorerpc.RebuildReason{
+				Kind:       zbstorerpc.RebuildReusePolicy,
+				OutputName: outputName,
+			}, nil
+		}
+		if !b.reusePolicy.All {
+			present, absent, err = findPossibleRealizations(ctx, conn, eqClass, b.reusePolicy)
+			if err != nil {
+				return nil, fmt.Errorf("explain rebuild of %s: %v", state.drvPath, err)
+			}
+			if len(present) == 0 && len(absent) == 0 {
+				return &zbstorerpc.RebuildReason{
+					Kind:       zbstorerpc.RebuildMissingSignature,
+					OutputName: outputName,
+				}, nil
+			}
+		}
+		if len(present) == 0 {
+			return &zbstorerpc.RebuildReason{
+				Kind:       zbstorerpc.RebuildUnavailable,
+				OutputName: outputName,
+			}, nil
+		}
+	}
+
+	// Every output has a permitted realization present in the store,
+	// so the planner must have rejected them.
+	reason := &zbstorerpc.RebuildReason{Kind: zbstorerpc.RebuildConflictingClosure}
+	if len(outputNames) > 0 {
+		reason.OutputName = outputNames[0]
+	}
+	return reason, nil
+}
+
+// explainMissingRealizations returns the reason for rebuilding the derivation in state
+// when there are no realizations for outputName.
+// If a previous build realized the same derivation with different inputs,
+// then the reason lists the inputs that changed.
+func (b *builder) explainMissingRealizations(conn *sqlite.Conn, state *derivationBuildState, outputName string) (*zbstorerpc.RebuildReason, error) {
+	prevBuildID, err := findPreviousBuild(conn, state.drvPath, state.derivationHash)
+	if err != nil {
+		return nil, fmt.Errorf("explain rebuild of %s: %v", state.drvPath, err)
+	}
+	if prevBuildID == uuid.Nil {
+		return &zbstorerpc.RebuildReason{
+			Kind:       zbstorerpc.RebuildNoRealizations,
+			OutputName: outputName,
+		}, nil
+	}
+
+	reason := &zbstorerpc.RebuildReason{
+		Kind:            zbstorerpc.RebuildInputsChanged,
+		OutputName:      outputName,
+		PreviousBuildID: prevBuildID.String(),
+	}
+	var prevResults []*zbstorerpc.BuildResult
+	for ref := range state.derivation.InputDerivationOutputs() {
+		newPath, ok := b.lookup(ref)
+		if !ok {
+			continue
+		}
+		// The .drv file includes the paths of its input derivations,
+		// so a previous build of the same derivation path
+		// must have realized the same input derivations.
+		prevResults, err = findBuildResults(prevResults[:0], conn, "", prevBuildID, ref.DrvPath)
+		if err != nil {
+			return nil, fmt.Errorf("explain rebuild of %s: %v", state.drvPath, err)
+		}
+		oldPath, err := zbstorerpc.FindRealizeOutput(slices.Values(prevResults), ref)
+		if err != nil || !oldPath.Valid || oldPath.X == newPath {
+			continue
+		}
+		reason.ChangedInputs = append(reason.ChangedInputs, &zbstorerpc.ChangedInput{
+			Input:   ref,
+			OldPath: oldPath.X,
+			NewPath: newPath,
+		})
+	}
+	return reason, nil
+}
+
+// recordRebuildReason stores the reason for running the builder
+// in the build result for the derivation in state.
+// Failures are logged rather than returned
+// because the reason is informational.
+func (b *builder) recordRebuildReason(ctx context.Context, conn *sqlite.Conn, state *derivationBuildState, reason *zbstorerpc.RebuildReason) {
+	log.Debugf(ctx, "Rebuilding %s: %v", state.drvPath, reason)
+	if err := recordRebuildReason(conn, state.buildResultID, reason); err != nil {
+		log.Warnf(ctx, "For %s: %v", state.drvPath, err)
+	}
+}
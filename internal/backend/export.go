@@ -42,9 +42,17 @@ func exporterFromContext(ctx context.Context) Exporter {
 // Export exports the store objects according to the request
 // in `nix-store --export` format to dst.
 func (s *Server) Export(ctx context.Context, dst io.Writer, req *zbstorerpc.ExportRequest) error {
-	e := zbstore.NewExportWriter(dst)
+	manifest, err := s.exportManifest(ctx, req)
+	if err != nil {
+		return err
+	}
+	return s.writeExport(dst, req, manifest)
+}
 
-	var manifest []*zbstore.ExportTrailer
+// exportManifest returns information about the store objects to export for req
+// in the order they should be sent.
+func (s *Server) exportManifest(ctx context.Context, req *zbstorerpc.ExportRequest) ([]*ObjectInfo, error) {
+	var manifest []*ObjectInfo
 	var err error
 	if req.ExcludeReferences {
 		manifest, err = s.fetchInfoForExport(ctx, req.Paths)
@@ -52,21 +60,26 @@ func (s *Server) Export(ctx context.Context, dst io.Writer, req *zbstorerpc.Expo
 		manifest, err = s.findExportClosure(ctx, req.Paths)
 	}
 	if err != nil {
-		return fmt.Errorf("export %s: %v", joinStrings(req.Paths, ", "), err)
+		return nil, fmt.Errorf("export %s: %v", joinStrings(req.Paths, ", "), err)
 	}
+	return manifest, nil
+}
 
+// writeExport writes the store objects in manifest
+// in `nix-store --export` format to dst.
+func (s *Server) writeExport(dst io.Writer, req *zbstorerpc.ExportRequest, manifest []*ObjectInfo) error {
+	e := zbstore.NewExportWriter(dst)
 	for _, object := range manifest {
 		if err := nar.DumpPath(e, s.realPath(object.StorePath)); err != nil {
 			return fmt.Errorf("export %s: %v", object.StorePath, err)
 		}
-		if err := e.Trailer(object); err != nil {
+		if err := e.Trailer(object.ToExportTrailer()); err != nil {
 			return fmt.Errorf("export %s: %v", object.StorePath, err)
 		}
 	}
 	if err := e.Close(); err != nil {
 		return fmt.Errorf("export %s: %v", joinStrings(req.Paths, ", "), err)
 	}
-
 	return nil
 }
 
@@ -80,25 +93,36 @@ func (s *Server) export(ctx context.Context, req *jsonrpc.Request) (*jsonrpc.Res
 		return nil, jsonrpc.Error(jsonrpc.InvalidParams, err)
 	}
 
-	var header jsonrpc.Header
+	header := make(jsonrpc.Header)
 	if idJSON := req.Extra[zbstorerpc.ExportIDExtraFieldName]; len(idJSON) > 0 {
 		var id string
 		if err := jsonv2.Unmarshal(idJSON, &id); err != nil {
 			return nil, jsonrpc.Error(jsonrpc.InvalidParams, fmt.Errorf("%s: %v", zbstorerpc.ExportIDExtraFieldName, err))
 		}
-		header = make(jsonrpc.Header)
 		header.Set(zbstorerpc.ExportIDHeaderName, id)
 	}
 
+	manifest, err := s.exportManifest(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	if len(manifest) <= zbstorerpc.MaxExportNARSizes {
+		sizes := make([]int64, len(manifest))
+		for i, object := range manifest {
+			sizes[i] = object.NARSize
+		}
+		header.Set(zbstorerpc.ExportNARSizesHeaderName, zbstorerpc.FormatExportNARSizes(sizes))
+	}
+
 	pr, pw := io.Pipe()
 	done := make(chan struct{})
 	go func() {
-		close(done)
-		pw.CloseWithError(s.Export(ctx, pw, args))
+		defer close(done)
+		pw.CloseWithError(s.writeExport(pw, args, manifest))
 	}()
 	defer func() {
-		<-done
 		pr.Close()
+		<-done
 	}()
 
 	if err := conn.Export(header, pr); err != nil {
@@ -107,8 +131,8 @@ func (s *Server) export(ctx context.Context, req *jsonrpc.Request) (*jsonrpc.Res
 	return nil, nil
 }
 
-// fetchInfoForExport generates export trailers for the given paths.
-func (s *Server) fetchInfoForExport(ctx context.Context, paths []zbstore.Path) ([]*zbstore.ExportTrailer, error) {
+// fetchInfoForExport returns information about the given paths.
+func (s *Server) fetchInfoForExport(ctx context.Context, paths []zbstore.Path) ([]*ObjectInfo, error) {
 	if len(paths) == 0 {
 		return nil, nil
 	}
@@ -125,23 +149,23 @@ func (s *Server) fetchInfoForExport(ctx context.Context, paths []zbstore.Path) (
 	}
 	defer rollback()
 
-	var result []*zbstore.ExportTrailer
+	var result []*ObjectInfo
 	for _, path := range paths {
 		info, err := pathInfo(conn, path)
 		if err != nil {
 			return nil, err
 		}
-		result = append(result, info.ToExportTrailer())
+		result = append(result, info)
 	}
 	return result, nil
 }
 
-// findExportClosure returns a list of export trailers
-// for all the store objects that are transitively referenced by the given paths.
+// findExportClosure returns information about
+// all the store objects that are transitively referenced by the given paths.
 // The list is in topological order,
 // so each store object in the list will only reference itself
 // or store objects that come before it in the list.
-func (s *Server) findExportClosure(ctx context.Context, paths []zbstore.Path) ([]*zbstore.ExportTrailer, error) {
+func (s *Server) findExportClosure(ctx context.Context, paths []zbstore.Path) ([]*ObjectInfo, error) {
 	if len(paths) == 0 {
 		return nil, nil
 	}
@@ -158,10 +182,10 @@ func (s *Server) findExportClosure(ctx context.Context, paths []zbstore.Path) ([
 	}
 	defer rollback()
 
-	var result []*zbstore.ExportTrailer
-	hasPath := func(s []*zbstore.ExportTrailer, path zbstore.Path) bool {
-		return slices.ContainsFunc(s, func(t *zbstore.ExportTrailer) bool {
-			return t.StorePath == path
+	var result []*ObjectInfo
+	hasPath := func(s []*ObjectInfo, path zbstore.Path) bool {
+		return slices.ContainsFunc(s, func(info *ObjectInfo) bool {
+			return info.StorePath == path
 		})
 	}
 	for _, path := range paths {
@@ -175,7 +199,7 @@ func (s *Server) findExportClosure(ctx context.Context, paths []zbstore.Path) ([
 			if infoError != nil {
 				return false
 			}
-			result = append(result, info.ToExportTrailer())
+			result = append(result, info)
 			return true
 		})
 		if infoError != nil {
@@ -189,8 +213,8 @@ func (s *Server) findExportClosure(ctx context.Context, paths []zbstore.Path) ([
 	// Topologically sort new closure.
 	err = sortByReferences(
 		result,
-		func(t *zbstore.ExportTrailer) zbstore.Path { return t.StorePath },
-		func(t *zbstore.ExportTrailer) sets.Sorted[zbstore.Path] { return t.References },
+		func(info *ObjectInfo) zbstore.Path { return info.StorePath },
+		func(info *ObjectInfo) sets.Sorted[zbstore.Path] { return info.References },
 		false,
 	)
 	if err != nil {
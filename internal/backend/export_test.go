@@ -6,6 +6,7 @@ package backend_test
 import (
 	"bytes"
 	stdcmp "cmp"
+	"io"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -143,10 +144,15 @@ func TestExport(t *testing.T) {
 				}
 
 				receiver := new(spyNARReceiver)
+				var gotSizes []int64
+				importer := zbstorerpc.ImportFunc(func(header jsonrpc.Header, body io.Reader) error {
+					gotSizes = zbstorerpc.ParseExportNARSizes(header)
+					return zbstore.ReceiveExport(receiver, body)
+				})
 				_, client, err := backendtest.NewServer(ctx, t, dir, &backendtest.Options{
 					TempDir: t.TempDir(),
 					ClientOptions: zbstorerpc.CodecOptions{
-						Importer: zbstorerpc.NewReceiverImporter(receiver),
+						Importer: importer,
 					},
 				})
 				if err != nil {
@@ -204,6 +210,13 @@ func TestExport(t *testing.T) {
 				if diff != "" {
 					t.Errorf("export (-want +got):\n%s", diff)
 				}
+				wantSizes := make([]int64, len(want))
+				for i, rec := range want {
+					wantSizes[i] = int64(len(rec.nar))
+				}
+				if diff := cmp.Diff(wantSizes, gotSizes, cmpopts.EquateEmpty()); diff != "" {
+					t.Errorf("%s header (-want +got):\n%s", zbstorerpc.ExportNARSizesHeaderName, diff)
+				}
 			})
 
 			for _, mapped := range [...]bool{false, true} {
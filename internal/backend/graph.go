@@ -168,6 +168,12 @@ func addToMultiMap[K comparable, V comparable, M ~map[K]sets.Set[V]](m M, k K, v
 type dependencyOrderIterator struct {
 	graph *dependencyGraph
 
+	// order is an optional map of derivation paths to positions.
+	// If a derivation path is in order,
+	// next returns it before any derivations with a later position
+	// and before any derivations not in order.
+	order map[zbstore.Path]int
+
 	mu       sync.Mutex
 	stack    []zbstore.Path
 	finished map[zbstore.Path]bool
@@ -289,13 +295,28 @@ func (it *dependencyOrderIterator) next(ctx context.Context) (zbstore.Path, erro
 			return "", ctx.Err()
 		}
 	}
-	p := xslices.Last(it.stack)
-	it.stack = xslices.Pop(it.stack, 1)
+	i := it.nextIndex()
+	p := it.stack[i]
+	it.stack = slices.Delete(it.stack, i, i+1)
 	it.pending++
 	it.mu.Unlock()
 	return p, nil
 }
 
+// nextIndex returns the index in it.stack of the path that next should return.
+// it.mu must be held and it.stack must not be empty.
+func (it *dependencyOrderIterator) nextIndex() int {
+	best := len(it.stack) - 1
+	bestPos, bestOrdered := it.order[it.stack[best]]
+	for i := best - 1; i >= 0; i-- {
+		pos, ordered := it.order[it.stack[i]]
+		if ordered && (!bestOrdered || pos < bestPos) {
+			best, bestPos, bestOrdered = i, pos, true
+		}
+	}
+	return best
+}
+
 var errEndIteration = errors.New("end iteration")
 
 // finish marks the derivation with the given path as having finished processing,
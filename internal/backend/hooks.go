@@ -0,0 +1,86 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package backend
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"slices"
+
+	"zb.256lights.llc/pkg/internal/xmaps"
+	"zb.256lights.llc/pkg/zbstore"
+	"zombiezen.com/go/log"
+)
+
+// runPreBuildHook runs the server's pre-build hook (if any)
+// for the derivation at drvPath,
+// sending the hook's output to logWriter.
+// If the hook exits unsuccessfully,
+// then runPreBuildHook returns a [builderFailure].
+func (s *Server) runPreBuildHook(ctx context.Context, drvPath zbstore.Path, logWriter io.Writer) error {
+	if s.preBuildHook == "" {
+		return nil
+	}
+	log.Debugf(ctx, "Running pre-build hook for %s...", drvPath)
+	err := runHook(ctx, s.preBuildHook, map[string]string{
+		"DRV_PATH": string(drvPath),
+		"ZB_STORE": string(s.dir),
+	}, logWriter)
+	if err != nil {
+		return builderFailure{fmt.Errorf("pre-build hook: %v", err)}
+	}
+	return nil
+}
+
+// runPostBuildHook runs the server's post-build hook (if any)
+// for the derivation at drvPath that produced the given output paths.
+// The hook's output is appended to the builder log.
+// Failures are logged, but do not affect the build.
+func (b *builder) runPostBuildHook(ctx context.Context, drvPath zbstore.Path, outPaths []zbstore.Path) {
+	if b.server.postBuildHook == "" {
+		return
+	}
+	log.Debugf(ctx, "Running post-build hook for %s...", drvPath)
+	outPaths = slices.Clone(outPaths)
+	slices.Sort(outPaths)
+	output := new(bytes.Buffer)
+	err := runHook(ctx, b.server.postBuildHook, map[string]string{
+		"DRV_PATH":  string(drvPath),
+		"OUT_PATHS": joinStrings(outPaths, " "),
+		"ZB_STORE":  string(b.server.dir),
+	}, output)
+	if err != nil {
+		log.Warnf(ctx, "Post-build hook for %s: %v", drvPath, err)
+		output.WriteString("*** Post-build hook failed: ")
+		output.WriteString(err.Error())
+		output.WriteString("\n")
+	}
+	if output.Len() > 0 {
+		if err := appendToBuilderLog(b.server.logDir, b.id, drvPath, output.Bytes()); err != nil {
+			log.Warnf(ctx, "Failed to write post-build hook output to log: %v", err)
+		}
+	}
+}
+
+// runHook runs the hook program at path
+// with the server's environment plus the given variables.
+// The program's stdout and stderr are sent to w.
+func runHook(ctx context.Context, path string, env map[string]string, w io.Writer) error {
+	c := exec.CommandContext(ctx, path)
+	setCancelFunc(c)
+	c.Env = os.Environ()
+	for k, v := range xmaps.Sorted(env) {
+		c.Env = append(c.Env, k+"="+v)
+	}
+	c.Stdout = w
+	c.Stderr = w
+	if err := c.Run(); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
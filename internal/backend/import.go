@@ -37,6 +37,9 @@ type NARReceiver struct {
 	realDir string
 	dbPool  connectionGetter
 	writing *mutexMap[zbstore.Path]
+	audit   func(context.Context, *AuditEvent)
+
+	caseInsensitive func() bool
 
 	tmpFileCreator bytebuffer.Creator
 	tmpFile        bytebuffer.ReadWriteSeekCloser
@@ -62,13 +65,15 @@ func (s *Server) newNARReceiver(ctx context.Context, bufCreator bytebuffer.Creat
 	}
 
 	return &NARReceiver{
-		ctx:            ctx,
-		dir:            s.dir,
-		realDir:        s.realDir,
-		dbPool:         getter,
-		writing:        &s.writing,
-		tmpFileCreator: bufCreator,
-		hasher:         *nix.NewHasher(nix.SHA256),
+		ctx:             ctx,
+		dir:             s.dir,
+		realDir:         s.realDir,
+		dbPool:          getter,
+		writing:         &s.writing,
+		audit:           s.audit,
+		caseInsensitive: s.caseInsensitive,
+		tmpFileCreator:  bufCreator,
+		hasher:          *nix.NewHasher(nix.SHA256),
 	}
 }
 
@@ -140,7 +145,8 @@ func (r *NARReceiver) ReceiveNAR(trailer *zbstore.ExportTrailer) {
 	}
 
 	log.Debugf(ctx, "Extracting %s.nar to %s...", trailer.StorePath, realPath)
-	if err := extractNAR(realPath, io.LimitReader(r.tmpFile, r.size)); err != nil {
+	hazards := newCaseHazardChecker(r.caseInsensitive != nil && r.caseInsensitive())
+	if err := extractNAR(realPath, io.LimitReader(r.tmpFile, r.size), hazards); err != nil {
 		log.Warnf(ctx, "Import of %s failed: %v", trailer.StorePath, err)
 		if err := os.RemoveAll(realPath); err != nil {
 			log.Errorf(ctx, "Failed to clean up partial import of %s: %v", trailer.StorePath, err)
@@ -184,6 +190,10 @@ func (r *NARReceiver) ReceiveNAR(trailer *zbstore.ExportTrailer) {
 	freeze(ctx, realPath)
 
 	log.Infof(ctx, "Imported %s", trailer.StorePath)
+	r.audit(ctx, &AuditEvent{
+		Action: AuditImport,
+		Paths:  []zbstore.Path{trailer.StorePath},
+	})
 }
 
 // verifyContentAddress validates that the content matches the given content address.
@@ -258,16 +268,32 @@ func (obj *fakeObject) WriteNAR(ctx context.Context, w io.Writer) error {
 var errMultipleReads = errors.New("object cannot be read more than once")
 
 // extractNAR extracts a NAR file to the local filesystem at the given path.
-func extractNAR(dst string, r io.Reader) error {
+// Every path in the NAR is passed to hazards before it is created.
+func extractNAR(dst string, r io.Reader, hazards caseHazardChecker) error {
 	nr := nar.NewReader(r)
+	// Symlinks are created after all other files
+	// because Windows needs to know whether the target is a directory
+	// when creating a symlink.
+	type symlink struct {
+		oldname, newname string
+	}
+	var symlinks []symlink
 	for {
 		hdr, err := nr.Next()
 		if err == io.EOF {
+			for _, link := range symlinks {
+				if err := os.Symlink(link.oldname, link.newname); err != nil {
+					return err
+				}
+			}
 			return nil
 		}
 		if err != nil {
 			return err
 		}
+		if err := hazards.check(hdr.Path); err != nil {
+			return err
+		}
 		p := filepath.Join(dst, filepath.FromSlash(hdr.Path))
 		switch typ := hdr.Mode.Type(); typ {
 		case 0:
@@ -292,9 +318,10 @@ func extractNAR(dst string, r io.Reader) error {
 				return err
 			}
 		case fs.ModeSymlink:
-			if err := os.Symlink(hdr.LinkTarget, p); err != nil {
-				return err
-			}
+			symlinks = append(symlinks, symlink{
+				oldname: hdr.LinkTarget,
+				newname: p,
+			})
 		default:
 			return fmt.Errorf("unhandled type %v", typ)
 		}
@@ -320,9 +347,20 @@ func truncateIfPossible(f io.ReadWriteSeeker, size int64) error {
 	return t.Truncate(size)
 }
 
-// freeze calls [osutil.Freeze]
+// freeze calls [osutil.StripXattrs] and [osutil.Freeze]
 // and logs any errors instead of causing them to stop the operation.
+// NAR files do not record extended attributes,
+// so stripping them ensures that a store object has the same content
+// regardless of whether it was built locally or imported.
 func freeze(ctx context.Context, path string) {
+	log.Debugf(ctx, "Removing extended attributes from %s...", path)
+	osutil.StripXattrs(path, func(err error) error {
+		// Extended attributes are not part of the store object's content,
+		// so any left over do not affect its hash.
+		log.Warnf(ctx, "%v", err)
+		return nil
+	})
+
 	log.Debugf(ctx, "Marking %s read-only...", path)
 	osutil.Freeze(path, time.Unix(0, 0), func(err error) error {
 		// Log errors, but don't abort the chmod attempt.
@@ -55,3 +55,33 @@ func (k *Keyring) Sign(ref zbstore.RealizationOutputReference, r *zbstore.Realiz
 	}
 	return result, ec.Error()
 }
+
+// SignAttestation adds signatures to the envelope using all the private keys in the keyring.
+func (k *Keyring) SignAttestation(env *zbstore.AttestationEnvelope) {
+	if k == nil {
+		return
+	}
+	for _, key := range k.Ed25519 {
+		env.SignEd25519(key)
+	}
+}
+
+// PublicKeys returns the public keys of the keys in the keyring.
+func (k *Keyring) PublicKeys() []*zbstore.RealizationPublicKey {
+	if k == nil {
+		return nil
+	}
+	result := make([]*zbstore.RealizationPublicKey, 0, len(k.Ed25519))
+	for _, key := range k.Ed25519 {
+		result = append(result, &zbstore.RealizationPublicKey{
+			Format: zbstore.Ed25519SignatureFormat,
+			Data:   key.Public().(ed25519.PublicKey),
+		})
+	}
+	return result
+}
+
+// IsEmpty reports whether the keyring has no keys.
+func (k *Keyring) IsEmpty() bool {
+	return k == nil || len(k.Ed25519) == 0
+}
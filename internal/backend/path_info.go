@@ -0,0 +1,118 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	jsonv2 "github.com/go-json-experiment/json"
+	"zb.256lights.llc/pkg/internal/jsonrpc"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+	"zb.256lights.llc/pkg/zbstore"
+	"zombiezen.com/go/log"
+	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+func (s *Server) pathInfoDetails(ctx context.Context, req *jsonrpc.Request) (*jsonrpc.Response, error) {
+	var args zbstorerpc.PathInfoRequest
+	if err := jsonv2.Unmarshal(req.Params, &args); err != nil {
+		return nil, jsonrpc.Error(jsonrpc.InvalidParams, err)
+	}
+	if args.Path.Dir() != s.dir {
+		return marshalResponse(&zbstorerpc.PathInfoResponse{})
+	}
+
+	conn, err := s.db.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer s.db.Put(conn)
+
+	log.Debugf(ctx, "Looking up detailed path info for %s...", args.Path)
+	info, err := detailedPathInfo(conn, &args)
+	if errors.Is(err, zbstore.ErrNotFound) {
+		return marshalResponse(&zbstorerpc.PathInfoResponse{})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return marshalResponse(&zbstorerpc.PathInfoResponse{
+		Info: info,
+	})
+}
+
+// detailedPathInfo returns the information in the store database
+// about the store object named by args.Path.
+// If the store object does not exist,
+// detailedPathInfo returns an error that unwraps to [zbstore.ErrNotFound].
+func detailedPathInfo(conn *sqlite.Conn, args *zbstorerpc.PathInfoRequest) (_ *zbstorerpc.PathInfo, err error) {
+	defer sqlitex.Save(conn)(&err)
+
+	objectInfo, err := pathInfo(conn, args.Path)
+	if err != nil {
+		return nil, err
+	}
+	info := &zbstorerpc.PathInfo{
+		ObjectInfo: *objectInfo.ToRPC(),
+	}
+
+	err = sqlitex.ExecuteTransientFS(conn, sqlFiles(), "path_info/registered_at.sql", &sqlitex.ExecOptions{
+		Named: map[string]any{":path": string(args.Path)},
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			if stmt.ColumnType(stmt.ColumnIndex("registered_at")) != sqlite.TypeNull {
+				info.RegistrationTime = time.UnixMilli(stmt.GetInt64("registered_at")).UTC()
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("path info for %s: registration time: %v", args.Path, err)
+	}
+
+	err = sqlitex.ExecuteTransientFS(conn, sqlFiles(), "path_info/derivers.sql", &sqlitex.ExecOptions{
+		Named: map[string]any{":path": string(args.Path)},
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			drvPath, err := zbstore.ParsePath(stmt.GetText("drv_path"))
+			if err != nil {
+				return err
+			}
+			info.Derivers = append(info.Derivers, drvPath)
+			return nil
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("path info for %s: derivers: %v", args.Path, err)
+	}
+
+	if args.ClosureSize {
+		err = sqlitex.ExecuteTransientFS(conn, sqlFiles(), "path_info/closure_size.sql", &sqlitex.ExecOptions{
+			Named: map[string]any{":path": string(args.Path)},
+			ResultFunc: func(stmt *sqlite.Stmt) error {
+				info.ClosureSize = stmt.GetInt64("closure_size")
+				return nil
+			},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("path info for %s: closure size: %v", args.Path, err)
+		}
+	}
+
+	if args.Signatures {
+		realizations, err := findRealizations(conn, realizationFilter{outputPath: args.Path})
+		if err != nil {
+			return nil, fmt.Errorf("path info for %s: %v", args.Path, err)
+		}
+		for _, m := range realizations {
+			for _, r := range m.All() {
+				info.Signatures = append(info.Signatures, r.Signatures...)
+			}
+		}
+	}
+
+	return info, nil
+}
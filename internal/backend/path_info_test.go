@@ -0,0 +1,201 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package backend_test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	. "zb.256lights.llc/pkg/internal/backend"
+	"zb.256lights.llc/pkg/internal/backendtest"
+	"zb.256lights.llc/pkg/internal/jsonrpc"
+	"zb.256lights.llc/pkg/internal/storetest"
+	"zb.256lights.llc/pkg/internal/system"
+	"zb.256lights.llc/pkg/internal/testcontext"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+	"zb.256lights.llc/pkg/sets"
+	"zb.256lights.llc/pkg/zbstore"
+	"zombiezen.com/go/nix"
+)
+
+func TestPathInfo(t *testing.T) {
+	ctx := testcontext.New(t)
+	dir := backendtest.NewStoreDirectory(t)
+
+	testKey := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{0x42}, ed25519.SeedSize))
+
+	const inputContent = "Hello, World!\n"
+	exportBuffer := new(bytes.Buffer)
+	exporter := zbstore.NewExportWriter(exportBuffer)
+	inputFilePath, _, err := storetest.ExportSourceFile(exporter, []byte(inputContent), storetest.SourceExportOptions{
+		Name:      "hello.txt",
+		Directory: dir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const outputName = "hello2.txt"
+	drvContent := &zbstore.Derivation{
+		Name:   outputName,
+		Dir:    dir,
+		System: system.Current().String(),
+		Env: map[string]string{
+			"in":  string(inputFilePath),
+			"out": zbstore.HashPlaceholder("out"),
+		},
+		InputSources: *sets.NewSorted(
+			inputFilePath,
+		),
+		Outputs: map[string]*zbstore.DerivationOutputType{
+			zbstore.DefaultDerivationOutputName: zbstore.RecursiveFileFloatingCAOutput(nix.SHA256),
+		},
+	}
+	drvContent.Builder, drvContent.Args = catcatBuilder()
+	drvPath, _, err := storetest.ExportDerivation(exporter, drvContent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exporter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	drvHash, err := drvContent.SHA256RealizationHash(func(ref zbstore.OutputReference) (zbstore.Path, bool) {
+		return "", false
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, client, err := backendtest.NewServer(ctx, t, dir, &backendtest.Options{
+		TempDir: t.TempDir(),
+		Options: Options{
+			Keyring: &Keyring{
+				Ed25519: []ed25519.PrivateKey{testKey},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	startTime := time.Now().Truncate(time.Millisecond)
+	codec, releaseCodec, err := storeCodec(ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = codec.Export(nil, exportBuffer)
+	releaseCodec()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	realizeResponse := new(zbstorerpc.RealizeResponse)
+	err = jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, realizeResponse, &zbstorerpc.RealizeRequest{
+		DrvPaths: []zbstore.Path{drvPath},
+	})
+	if err != nil {
+		t.Fatal("RPC error:", err)
+	}
+	if _, err := backendtest.WaitForSuccessfulBuild(ctx, client, realizeResponse.BuildID); err != nil {
+		gotLog, _ := backendtest.ReadLog(ctx, client, realizeResponse.BuildID, drvPath)
+		t.Fatalf("build drv: %v\nlog:\n%s", err, gotLog)
+	}
+	endTime := time.Now()
+
+	outputPath, err := singleFileOutputPath(dir, outputName, []byte(inputContent+inputContent), zbstore.References{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := zbstore.SignRealizationWithEd25519(zbstore.RealizationOutputReference{
+		DerivationHash: drvHash,
+		OutputName:     zbstore.DefaultDerivationOutputName,
+	}, &zbstore.Realization{OutputPath: outputPath}, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pathInfo := func(t *testing.T, req *zbstorerpc.PathInfoRequest) *zbstorerpc.PathInfo {
+		t.Helper()
+		resp := new(zbstorerpc.PathInfoResponse)
+		if err := jsonrpc.Do(ctx, client, zbstorerpc.PathInfoMethod, resp, req); err != nil {
+			t.Fatal(err)
+		}
+		return resp.Info
+	}
+	infoResponse := func(t *testing.T, path zbstore.Path) *zbstorerpc.ObjectInfo {
+		t.Helper()
+		resp := new(zbstorerpc.InfoResponse)
+		if err := jsonrpc.Do(ctx, client, zbstorerpc.InfoMethod, resp, &zbstorerpc.InfoRequest{Path: path}); err != nil {
+			t.Fatal(err)
+		}
+		return resp.Info
+	}
+
+	t.Run("Output", func(t *testing.T) {
+		got := pathInfo(t, &zbstorerpc.PathInfoRequest{
+			Path:        outputPath,
+			ClosureSize: true,
+			Signatures:  true,
+		})
+		if got == nil {
+			t.Fatalf("%s(%s) = null", zbstorerpc.PathInfoMethod, outputPath)
+		}
+		if got.RegistrationTime.Before(startTime) || got.RegistrationTime.After(endTime) {
+			t.Errorf("registration time = %v; want between %v and %v", got.RegistrationTime, startTime, endTime)
+		}
+		want := &zbstorerpc.PathInfo{
+			ObjectInfo:       *infoResponse(t, outputPath),
+			Derivers:         []zbstore.Path{drvPath},
+			RegistrationTime: got.RegistrationTime,
+			Signatures:       []*zbstore.RealizationSignature{sig},
+		}
+		want.ClosureSize = want.NARSize
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("%s(%s) (-want +got):\n%s", zbstorerpc.PathInfoMethod, outputPath, diff)
+		}
+	})
+
+	t.Run("Derivation", func(t *testing.T) {
+		got := pathInfo(t, &zbstorerpc.PathInfoRequest{
+			Path:        drvPath,
+			ClosureSize: true,
+			Signatures:  true,
+		})
+		if got == nil {
+			t.Fatalf("%s(%s) = null", zbstorerpc.PathInfoMethod, drvPath)
+		}
+		want := &zbstorerpc.PathInfo{
+			ObjectInfo:       *infoResponse(t, drvPath),
+			RegistrationTime: got.RegistrationTime,
+			ClosureSize:      infoResponse(t, drvPath).NARSize + infoResponse(t, inputFilePath).NARSize,
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("%s(%s) (-want +got):\n%s", zbstorerpc.PathInfoMethod, drvPath, diff)
+		}
+	})
+
+	t.Run("OptionalFieldsOmitted", func(t *testing.T) {
+		got := pathInfo(t, &zbstorerpc.PathInfoRequest{Path: outputPath})
+		if got == nil {
+			t.Fatalf("%s(%s) = null", zbstorerpc.PathInfoMethod, outputPath)
+		}
+		if got.ClosureSize != 0 {
+			t.Errorf("closure size = %d; want 0 (not requested)", got.ClosureSize)
+		}
+		if len(got.Signatures) > 0 {
+			t.Errorf("signatures = %v; want none (not requested)", got.Signatures)
+		}
+	})
+
+	t.Run("DoesNotExist", func(t *testing.T) {
+		bogusPath, err := dir.Object("00000000000000000000000000000000-bogus.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := pathInfo(t, &zbstorerpc.PathInfoRequest{Path: bogusPath}); got != nil {
+			t.Errorf("%s(%s) = %+v; want null", zbstorerpc.PathInfoMethod, bogusPath, got)
+		}
+	})
+}
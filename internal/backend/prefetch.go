@@ -0,0 +1,295 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package backend
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	jsonv2 "github.com/go-json-experiment/json"
+	"github.com/google/uuid"
+	"zb.256lights.llc/pkg/internal/jsonrpc"
+	"zb.256lights.llc/pkg/internal/xtime"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+	"zb.256lights.llc/pkg/sets"
+	"zb.256lights.llc/pkg/zbstore"
+	"zombiezen.com/go/log"
+	"zombiezen.com/go/nix"
+)
+
+// prefetchTTL is the amount of time that the results of a prefetch
+// are used before the fallback store is queried again.
+const prefetchTTL = 5 * time.Minute
+
+// prefetchBatchDelay is the amount of time that the prefetcher waits
+// after receiving a [zbstorerpc.PrefetchMethod] notification
+// so that derivations imported together are prefetched together.
+const prefetchBatchDelay = 100 * time.Millisecond
+
+type prefetchContextKey struct{}
+
+// isPrefetch reports whether ctx was created by [Server.prefetchLoop].
+func isPrefetch(ctx context.Context) bool {
+	return ctx.Value(prefetchContextKey{}) != nil
+}
+
+// prefetchStore is a [Store] that remembers the lookups made while prefetching
+// so that the next equivalent lookup made by a build
+// does not need to wait on the underlying store.
+// Each remembered result is used at most once.
+type prefetchStore struct {
+	Store
+
+	// wake receives a value when pending is non-empty.
+	wake chan struct{}
+
+	mu           sync.Mutex
+	pending      sets.Set[zbstore.Path]
+	realizations map[hashKey]prefetched[zbstore.RealizationMap]
+	objects      map[zbstore.Path]prefetched[zbstore.Object]
+}
+
+// canPrefetch reports whether store looks up objects one at a time.
+// Stores that export or look up objects in bulk
+// (like [zbstore.Null]) are not wrapped in a [prefetchStore]
+// so that their bulk operations continue to be used.
+func canPrefetch(store Store) bool {
+	switch store.(type) {
+	case zbstore.Exporter, zbstore.BatchStore:
+		return false
+	default:
+		return true
+	}
+}
+
+type prefetched[T any] struct {
+	value   T
+	fetched time.Time
+}
+
+func newPrefetchStore(store Store) *prefetchStore {
+	return &prefetchStore{
+		Store:        store,
+		wake:         make(chan struct{}, 1),
+		pending:      make(sets.Set[zbstore.Path]),
+		realizations: make(map[hashKey]prefetched[zbstore.RealizationMap]),
+		objects:      make(map[zbstore.Path]prefetched[zbstore.Object]),
+	}
+}
+
+// enqueue adds the given derivations to the set of derivations to prefetch.
+func (ps *prefetchStore) enqueue(drvPaths []zbstore.Path) {
+	ps.mu.Lock()
+	ps.pending.Add(drvPaths...)
+	ps.mu.Unlock()
+
+	select {
+	case ps.wake <- struct{}{}:
+	default:
+	}
+}
+
+// takePending removes and returns the derivations to prefetch.
+func (ps *prefetchStore) takePending() []zbstore.Path {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	drvPaths := slices.Sorted(ps.pending.All())
+	ps.pending.Clear()
+	return drvPaths
+}
+
+// prune removes remembered results that have expired.
+func (ps *prefetchStore) prune(now time.Time) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	for k, v := range ps.realizations {
+		if now.Sub(v.fetched) >= prefetchTTL {
+			delete(ps.realizations, k)
+		}
+	}
+	for k, v := range ps.objects {
+		if now.Sub(v.fetched) >= prefetchTTL {
+			delete(ps.objects, k)
+		}
+	}
+}
+
+func (ps *prefetchStore) FetchRealizations(ctx context.Context, derivationHash nix.Hash) (zbstore.RealizationMap, error) {
+	k := makeHashKey(derivationHash)
+	if !isPrefetch(ctx) {
+		if r, ok := takePrefetched(&ps.mu, ps.realizations, k, time.Now()); ok {
+			log.Debugf(ctx, "Using prefetched realizations for %v", derivationHash)
+			return r, nil
+		}
+		return ps.Store.FetchRealizations(ctx, derivationHash)
+	}
+
+	// Derivations are often prefetched again as part of a later batch.
+	if r, ok := peekPrefetched(&ps.mu, ps.realizations, k, time.Now()); ok {
+		return r, nil
+	}
+	realizations, err := ps.Store.FetchRealizations(ctx, derivationHash)
+	if err != nil {
+		return realizations, err
+	}
+	ps.mu.Lock()
+	ps.realizations[k] = prefetched[zbstore.RealizationMap]{realizations, time.Now()}
+	ps.mu.Unlock()
+	return realizations, nil
+}
+
+func (ps *prefetchStore) Object(ctx context.Context, path zbstore.Path) (zbstore.Object, error) {
+	if !isPrefetch(ctx) {
+		if obj, ok := takePrefetched(&ps.mu, ps.objects, path, time.Now()); ok {
+			log.Debugf(ctx, "Using prefetched information for %s", path)
+			return obj, nil
+		}
+		return ps.Store.Object(ctx, path)
+	}
+
+	if obj, ok := peekPrefetched(&ps.mu, ps.objects, path, time.Now()); ok {
+		return obj, nil
+	}
+	obj, err := ps.Store.Object(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	ps.mu.Lock()
+	ps.objects[path] = prefetched[zbstore.Object]{obj, time.Now()}
+	ps.mu.Unlock()
+	return obj, nil
+}
+
+// takePrefetched removes the entry for k from m
+// and returns its value if it has not expired.
+func takePrefetched[K comparable, V any](mu *sync.Mutex, m map[K]prefetched[V], k K, now time.Time) (_ V, ok bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	entry, ok := m[k]
+	if !ok {
+		return entry.value, false
+	}
+	delete(m, k)
+	if now.Sub(entry.fetched) >= prefetchTTL {
+		var zero V
+		return zero, false
+	}
+	return entry.value, true
+}
+
+// peekPrefetched returns the value of the entry for k in m
+// if it has not expired.
+func peekPrefetched[K comparable, V any](mu *sync.Mutex, m map[K]prefetched[V], k K, now time.Time) (_ V, ok bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	entry, ok := m[k]
+	if !ok || now.Sub(entry.fetched) >= prefetchTTL {
+		var zero V
+		return zero, false
+	}
+	return entry.value, true
+}
+
+func (s *Server) prefetch(ctx context.Context, req *jsonrpc.Request) (*jsonrpc.Response, error) {
+	var args zbstorerpc.PrefetchNotification
+	if err := jsonv2.Unmarshal(req.Params, &args); err != nil {
+		return nil, jsonrpc.Error(jsonrpc.InvalidParams, err)
+	}
+	if s.prefetcher == nil {
+		return nil, nil
+	}
+	var drvPaths []zbstore.Path
+	for _, arg := range args.DrvPaths {
+		drvPath, subPath, err := s.dir.ParsePath(string(arg))
+		if err != nil || subPath != "" {
+			continue
+		}
+		if _, isDrv := drvPath.DerivationName(); !isDrv {
+			continue
+		}
+		drvPaths = append(drvPaths, drvPath)
+	}
+	if len(drvPaths) > 0 {
+		s.prefetcher.enqueue(drvPaths)
+	}
+	return nil, nil
+}
+
+// prefetchLoop prefetches the derivations requested by [Server.prefetch]
+// until ctx is canceled.
+func (s *Server) prefetchLoop(ctx context.Context) {
+	ctx = context.WithValue(ctx, prefetchContextKey{}, true)
+	for {
+		select {
+		case <-s.prefetcher.wake:
+		case <-ctx.Done():
+			return
+		}
+		if err := xtime.Sleep(ctx, prefetchBatchDelay); err != nil {
+			return
+		}
+		s.prefetcher.prune(time.Now())
+		drvPaths := s.prefetcher.takePending()
+		if len(drvPaths) == 0 {
+			continue
+		}
+		if err := s.prefetchDerivations(ctx, drvPaths); err != nil {
+			log.Debugf(ctx, "Prefetch: %v", err)
+		}
+	}
+}
+
+// prefetchDerivations queries the fallback store
+// for realizations of the given derivations' outputs
+// and the information of the store objects that realizing them would download.
+func (s *Server) prefetchDerivations(ctx context.Context, drvPaths []zbstore.Path) error {
+	log.Debugf(ctx, "Prefetching %s", joinStrings(drvPaths, ", "))
+	drvCache, err := s.readDerivationClosure(ctx, drvPaths)
+	if err != nil {
+		return err
+	}
+	want := make(sets.Set[zbstore.OutputReference])
+	for _, drvPath := range drvPaths {
+		for outputName := range drvCache[drvPath].Outputs {
+			want.Add(zbstore.OutputReference{
+				DrvPath:    drvPath,
+				OutputName: outputName,
+			})
+		}
+	}
+	graph, err := analyze(drvCache, want)
+	if err != nil {
+		return err
+	}
+	// The client's reuse policy is applied when the derivations are realized.
+	// Until then, look up every realization the fallback store has.
+	b := s.newBuilder(uuid.Nil, drvCache, &zbstorerpc.ReusePolicy{All: true})
+	if err := b.gatherRealizations(ctx, graph); err != nil {
+		return err
+	}
+
+	visited := make(sets.Set[zbstore.Path])
+	for _, r := range b.realizations {
+		for path := range r.closure {
+			if visited.Has(path) {
+				continue
+			}
+			visited.Add(path)
+			if _, err := os.Lstat(s.realPath(path)); err == nil {
+				continue
+			}
+			if _, err := s.prefetcher.Object(ctx, path); err != nil {
+				log.Debugf(ctx, "Prefetch %s: %v", path, err)
+			}
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("prefetch: %w", err)
+	}
+	return nil
+}
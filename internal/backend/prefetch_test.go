@@ -0,0 +1,108 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package backend
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+	"testing"
+
+	"zb.256lights.llc/pkg/internal/storetest"
+	"zb.256lights.llc/pkg/zbstore"
+	"zombiezen.com/go/nix"
+)
+
+func TestPrefetchStore(t *testing.T) {
+	ctx := t.Context()
+	prefetchCtx := context.WithValue(ctx, prefetchContextKey{}, true)
+	store := new(countingStore)
+	ps := newPrefetchStore(store)
+	drvHash := nix.NewHash(nix.SHA256, make([]byte, nix.SHA256.Size()))
+	const path = zbstore.Path("/zb/store/ffffffffffffffffffffffffffffffff-hello")
+
+	// Prefetching the same derivation twice only queries the store once.
+	for range 2 {
+		if _, err := ps.FetchRealizations(prefetchCtx, drvHash); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ps.Object(prefetchCtx, path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := store.fetches.Load(); got != 1 {
+		t.Errorf("after prefetch, store received %d realization queries; want 1", got)
+	}
+	if got := store.objects.Load(); got != 1 {
+		t.Errorf("after prefetch, store received %d object queries; want 1", got)
+	}
+
+	// The first build lookup uses the prefetched result.
+	if _, err := ps.FetchRealizations(ctx, drvHash); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ps.Object(ctx, path); err != nil {
+		t.Fatal(err)
+	}
+	if got := store.fetches.Load(); got != 1 {
+		t.Errorf("after first build lookup, store received %d realization queries; want 1", got)
+	}
+	if got := store.objects.Load(); got != 1 {
+		t.Errorf("after first build lookup, store received %d object queries; want 1", got)
+	}
+
+	// Later lookups go to the store.
+	if _, err := ps.FetchRealizations(ctx, drvHash); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ps.Object(ctx, path); err != nil {
+		t.Fatal(err)
+	}
+	if got := store.fetches.Load(); got != 2 {
+		t.Errorf("after second build lookup, store received %d realization queries; want 2", got)
+	}
+	if got := store.objects.Load(); got != 2 {
+		t.Errorf("after second build lookup, store received %d object queries; want 2", got)
+	}
+}
+
+func TestCanPrefetch(t *testing.T) {
+	if canPrefetch(zbstore.Null{}) {
+		t.Error("canPrefetch(zbstore.Null{}) = true; want false")
+	}
+	if canPrefetch(new(storetest.Store)) {
+		t.Error("canPrefetch(new(storetest.Store)) = true; want false")
+	}
+	if !canPrefetch(new(countingStore)) {
+		t.Error("canPrefetch(new(countingStore)) = false; want true")
+	}
+}
+
+// countingStore is a [Store] that counts the number of lookups it receives.
+type countingStore struct {
+	fetches atomic.Int32
+	objects atomic.Int32
+}
+
+func (s *countingStore) FetchRealizations(ctx context.Context, derivationHash nix.Hash) (zbstore.RealizationMap, error) {
+	s.fetches.Add(1)
+	return zbstore.RealizationMap{DerivationHash: derivationHash}, nil
+}
+
+func (s *countingStore) Object(ctx context.Context, path zbstore.Path) (zbstore.Object, error) {
+	s.objects.Add(1)
+	return countingObject{path}, nil
+}
+
+type countingObject struct {
+	path zbstore.Path
+}
+
+func (obj countingObject) WriteNAR(ctx context.Context, dst io.Writer) error {
+	return nil
+}
+
+func (obj countingObject) Trailer() *zbstore.ExportTrailer {
+	return &zbstore.ExportTrailer{StorePath: obj.path}
+}
@@ -0,0 +1,92 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"path/filepath"
+	"slices"
+
+	"zombiezen.com/go/nix/nar"
+)
+
+// outputQuota is a limit on the total size of the outputs of a single builder run.
+// Non-positive limits are not enforced.
+// The zero value does not limit outputs.
+type outputQuota struct {
+	// maxSize is the maximum total NAR size in bytes.
+	maxSize int64
+	// maxFiles is the maximum total number of filesystem objects.
+	maxFiles int64
+}
+
+// errQuotaExceeded is returned by a [quotaWriter] or a walk function
+// to stop early once a limit has been exceeded.
+var errQuotaExceeded = errors.New("quota exceeded")
+
+// check returns a [builderFailure] if the filesystem objects
+// at the given output paths exceed the quota in total.
+// realPaths is a map of output names to local filesystem paths.
+// Checking stops as soon as a limit is exceeded,
+// so check does not read the entirety of an oversized output.
+func (q outputQuota) check(realPaths map[string]string) error {
+	if q.maxSize <= 0 && q.maxFiles <= 0 {
+		return nil
+	}
+	var files int64
+	size := &quotaWriter{limit: q.maxSize}
+	for _, outputName := range slices.Sorted(maps.Keys(realPaths)) {
+		path := realPaths[outputName]
+		if q.maxFiles > 0 {
+			err := filepath.WalkDir(path, func(path string, d fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				files++
+				if files > q.maxFiles {
+					return errQuotaExceeded
+				}
+				return nil
+			})
+			if errors.Is(err, errQuotaExceeded) {
+				return builderFailure{fmt.Errorf("output $%s: outputs contain more than %d files (server quota)", outputName, q.maxFiles)}
+			}
+			if err != nil {
+				return builderFailure{fmt.Errorf("output $%s: %v", outputName, err)}
+			}
+		}
+		if q.maxSize > 0 {
+			err := nar.DumpPath(size, path)
+			if size.exceeded() {
+				return builderFailure{fmt.Errorf("output $%s: outputs are larger than %d bytes (server quota)", outputName, q.maxSize)}
+			}
+			if err != nil {
+				return builderFailure{fmt.Errorf("output $%s: %v", outputName, err)}
+			}
+		}
+	}
+	return nil
+}
+
+// quotaWriter is an [io.Writer] that counts the bytes written to it
+// and returns an error once more than limit bytes have been written.
+type quotaWriter struct {
+	n     int64
+	limit int64
+}
+
+func (w *quotaWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	if w.exceeded() {
+		return 0, errQuotaExceeded
+	}
+	return len(p), nil
+}
+
+func (w *quotaWriter) exceeded() bool {
+	return w.n > w.limit
+}
@@ -0,0 +1,70 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package backend
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"zb.256lights.llc/pkg/internal/xio"
+	"zombiezen.com/go/nix/nar"
+)
+
+func TestOutputQuota(t *testing.T) {
+	dir := t.TempDir()
+	outDir := filepath.Join(dir, "out")
+	if err := os.MkdirAll(filepath.Join(outDir, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.txt", "sub/b.txt"} {
+		if err := os.WriteFile(filepath.Join(outDir, filepath.FromSlash(name)), []byte("Hello, World!\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	docFile := filepath.Join(dir, "doc")
+	if err := os.WriteFile(docFile, bytes.Repeat([]byte("x"), 1024), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	paths := map[string]string{
+		"out": outDir,
+		"doc": docFile,
+	}
+
+	var totalSize int64
+	for _, path := range paths {
+		wc := new(xio.WriteCounter)
+		if err := nar.DumpPath(wc, path); err != nil {
+			t.Fatal(err)
+		}
+		totalSize += int64(*wc)
+	}
+	const totalFiles = 5
+
+	tests := []struct {
+		name    string
+		quota   outputQuota
+		wantErr bool
+	}{
+		{name: "Unlimited"},
+		{name: "AtLimit", quota: outputQuota{maxSize: totalSize, maxFiles: totalFiles}},
+		{name: "TooLarge", quota: outputQuota{maxSize: totalSize - 1}, wantErr: true},
+		{name: "TooManyFiles", quota: outputQuota{maxFiles: totalFiles - 1}, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.quota.check(paths)
+			if err != nil && !test.wantErr {
+				t.Errorf("check(...) = %v", err)
+			} else if test.wantErr {
+				if err == nil {
+					t.Error("check(...) did not return an error")
+				} else if !isBuilderFailure(err) {
+					t.Errorf("check(...) = %v; want builder failure", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,539 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	jsonv2 "github.com/go-json-experiment/json"
+	"zb.256lights.llc/pkg/internal/jsonrpc"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+	"zb.256lights.llc/pkg/zbstore"
+	"zombiezen.com/go/log"
+	"zombiezen.com/go/nix"
+	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+// realizationFilter is the set of criteria for [findRealizations].
+// Zero fields match any realization.
+type realizationFilter struct {
+	derivationHash nix.Hash
+	outputName     string
+	outputPath     zbstore.Path
+}
+
+// findRealizations returns the realizations in the database that match filter,
+// grouped by derivation hash and sorted by derivation hash.
+// The returned realizations include their reference classes and signatures.
+func findRealizations(conn *sqlite.Conn, filter realizationFilter) (_ []*zbstore.RealizationMap, err error) {
+	defer sqlitex.Save(conn)(&err)
+
+	type row struct {
+		ref         zbstore.RealizationOutputReference
+		realization *zbstore.Realization
+	}
+	var rows []row
+	named := map[string]any{
+		":drv_hash_algorithm": "",
+		":drv_hash_bits":      []byte{},
+		":output_name":        filter.outputName,
+		":output_path":        string(filter.outputPath),
+	}
+	if !filter.derivationHash.IsZero() {
+		named[":drv_hash_algorithm"] = filter.derivationHash.Type().String()
+		named[":drv_hash_bits"] = filter.derivationHash.Bytes(nil)
+	}
+	err = sqlitex.ExecuteTransientFS(conn, sqlFiles(), "realizations/list.sql", &sqlitex.ExecOptions{
+		Named: named,
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			drvHash, err := unmarshalHash(stmt.GetText("drv_hash_algorithm"), readBlob(stmt, "drv_hash_bits"))
+			if err != nil {
+				return err
+			}
+			outputPath, err := zbstore.ParsePath(stmt.GetText("output_path"))
+			if err != nil {
+				return err
+			}
+			rows = append(rows, row{
+				ref: zbstore.RealizationOutputReference{
+					DerivationHash: drvHash,
+					OutputName:     stmt.GetText("output_name"),
+				},
+				realization: &zbstore.Realization{OutputPath: outputPath},
+			})
+			return nil
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("find realizations: %v", err)
+	}
+
+	var result []*zbstore.RealizationMap
+	for _, r := range rows {
+		r.realization.ReferenceClasses, err = findReferenceClasses(conn, r.ref, r.realization.OutputPath)
+		if err != nil {
+			return nil, err
+		}
+		r.realization.Signatures, err = findRealizationSignatures(conn, r.ref, r.realization.OutputPath)
+		if err != nil {
+			return nil, err
+		}
+
+		var curr *zbstore.RealizationMap
+		if len(result) > 0 && result[len(result)-1].DerivationHash.Equal(r.ref.DerivationHash) {
+			curr = result[len(result)-1]
+		} else {
+			curr = &zbstore.RealizationMap{
+				DerivationHash: r.ref.DerivationHash,
+				Realizations:   make(map[string][]*zbstore.Realization),
+			}
+			result = append(result, curr)
+		}
+		curr.Realizations[r.ref.OutputName] = append(curr.Realizations[r.ref.OutputName], r.realization)
+	}
+	return result, nil
+}
+
+// findReferenceClasses returns the reference classes
+// recorded for the realization of ref at outputPath.
+func findReferenceClasses(conn *sqlite.Conn, ref zbstore.RealizationOutputReference, outputPath zbstore.Path) ([]*zbstore.ReferenceClass, error) {
+	result := []*zbstore.ReferenceClass{}
+	err := sqlitex.ExecuteTransientFS(conn, sqlFiles(), "realizations/reference_classes.sql", &sqlitex.ExecOptions{
+		Named: map[string]any{
+			":drv_hash_algorithm": ref.DerivationHash.Type().String(),
+			":drv_hash_bits":      ref.DerivationHash.Bytes(nil),
+			":output_name":        ref.OutputName,
+			":output_path":        string(outputPath),
+		},
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			path, err := zbstore.ParsePath(stmt.GetText("path"))
+			if err != nil {
+				return err
+			}
+			rc := &zbstore.ReferenceClass{Path: path}
+			if algo := stmt.GetText("drv_hash_algorithm"); algo != "" {
+				drvHash, err := unmarshalHash(algo, readBlob(stmt, "drv_hash_bits"))
+				if err != nil {
+					return fmt.Errorf("reference %s: %v", path, err)
+				}
+				rc.Realization = zbstore.NonNull(zbstore.RealizationOutputReference{
+					DerivationHash: drvHash,
+					OutputName:     stmt.GetText("output_name"),
+				})
+			}
+			result = append(result, rc)
+			return nil
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reference classes for %v (%s): %v", ref, outputPath, err)
+	}
+	return result, nil
+}
+
+// findRealizationSignatures returns the signatures
+// recorded for the realization of ref at outputPath.
+func findRealizationSignatures(conn *sqlite.Conn, ref zbstore.RealizationOutputReference, outputPath zbstore.Path) ([]*zbstore.RealizationSignature, error) {
+	var result []*zbstore.RealizationSignature
+	err := sqlitex.ExecuteTransientFS(conn, sqlFiles(), "realizations/signatures.sql", &sqlitex.ExecOptions{
+		Named: map[string]any{
+			":drv_hash_algorithm": ref.DerivationHash.Type().String(),
+			":drv_hash_bits":      ref.DerivationHash.Bytes(nil),
+			":output_name":        ref.OutputName,
+			":output_path":        string(outputPath),
+		},
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			result = append(result, &zbstore.RealizationSignature{
+				PublicKey: zbstore.RealizationPublicKey{
+					Format: zbstore.RealizationSignatureFormat(stmt.GetText("format")),
+					Data:   readBlob(stmt, "public_key"),
+				},
+				Signature: readBlob(stmt, "signature"),
+			})
+			return nil
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("signatures for %v (%s): %v", ref, outputPath, err)
+	}
+	return result, nil
+}
+
+// findBuiltDerivationHashes returns the derivation hashes
+// that builds of the derivation at drvPath have recorded.
+func findBuiltDerivationHashes(conn *sqlite.Conn, drvPath zbstore.Path) ([]nix.Hash, error) {
+	var result []nix.Hash
+	err := sqlitex.ExecuteTransientFS(conn, sqlFiles(), "build/drv_hashes.sql", &sqlitex.ExecOptions{
+		Named: map[string]any{
+			":drv_path": string(drvPath),
+		},
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			h, err := unmarshalHash(stmt.GetText("drv_hash_algorithm"), readBlob(stmt, "drv_hash_bits"))
+			if err != nil {
+				return err
+			}
+			result = append(result, h)
+			return nil
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("find derivation hashes for %s: %v", drvPath, err)
+	}
+	return result, nil
+}
+
+// readBlob returns a copy of the blob in the given column.
+func readBlob(stmt *sqlite.Stmt, col string) []byte {
+	buf := make([]byte, stmt.GetLen(col))
+	stmt.GetBytes(col, buf)
+	return buf
+}
+
+func (s *Server) realizations(ctx context.Context, req *jsonrpc.Request) (*jsonrpc.Response, error) {
+	var args zbstorerpc.RealizationsRequest
+	if err := jsonv2.Unmarshal(req.Params, &args); err != nil {
+		return nil, jsonrpc.Error(jsonrpc.InvalidParams, err)
+	}
+	if !args.DerivationHash.IsZero() && args.DrvPath != "" {
+		return nil, jsonrpc.Error(jsonrpc.InvalidParams, errors.New("derivationHash and drvPath are mutually exclusive"))
+	}
+	resp := &zbstorerpc.RealizationsResponse{
+		Realizations: []*zbstore.RealizationMap{},
+	}
+	if args.DrvPath != "" && args.DrvPath.Dir() != s.dir {
+		return marshalResponse(resp)
+	}
+
+	conn, err := s.db.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer s.db.Put(conn)
+
+	drvHashes := []nix.Hash{args.DerivationHash}
+	switch {
+	case args.DrvPath != "":
+		log.Debugf(ctx, "Looking up realizations for %s...", args.DrvPath)
+		drvHashes, err = findBuiltDerivationHashes(conn, args.DrvPath)
+		if err != nil {
+			return nil, err
+		}
+	case !args.DerivationHash.IsZero():
+		log.Debugf(ctx, "Looking up realizations for %v...", args.DerivationHash)
+	default:
+		log.Debugf(ctx, "Listing all realizations...")
+	}
+	for _, h := range drvHashes {
+		maps, err := findRealizations(conn, realizationFilter{
+			derivationHash: h,
+			outputName:     args.OutputName,
+		})
+		if err != nil {
+			return nil, err
+		}
+		resp.Realizations = append(resp.Realizations, maps...)
+	}
+	return marshalResponse(resp)
+}
+
+func (s *Server) realizationsByPath(ctx context.Context, req *jsonrpc.Request) (*jsonrpc.Response, error) {
+	var args zbstorerpc.RealizationsByPathRequest
+	if err := jsonv2.Unmarshal(req.Params, &args); err != nil {
+		return nil, jsonrpc.Error(jsonrpc.InvalidParams, err)
+	}
+	resp := &zbstorerpc.RealizationsResponse{
+		Realizations: []*zbstore.RealizationMap{},
+	}
+	if args.Path.Dir() != s.dir {
+		return marshalResponse(resp)
+	}
+
+	conn, err := s.db.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer s.db.Put(conn)
+
+	log.Debugf(ctx, "Looking up realizations that produced %s...", args.Path)
+	maps, err := findRealizations(conn, realizationFilter{outputPath: args.Path})
+	if err != nil {
+		return nil, err
+	}
+	resp.Realizations = append(resp.Realizations, maps...)
+	return marshalResponse(resp)
+}
+
+func (s *Server) addSignatures(ctx context.Context, req *jsonrpc.Request) (*jsonrpc.Response, error) {
+	var args zbstorerpc.AddSignaturesRequest
+	if err := jsonv2.Unmarshal(req.Params, &args); err != nil {
+		return nil, jsonrpc.Error(jsonrpc.InvalidParams, err)
+	}
+
+	conn, err := s.db.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer s.db.Put(conn)
+
+	resp := new(zbstorerpc.AddSignaturesResponse)
+	err = func() (err error) {
+		endFn, err := sqlitex.ImmediateTransaction(conn)
+		if err != nil {
+			return err
+		}
+		defer endFn(&err)
+
+		for _, m := range args.Realizations {
+			if m == nil {
+				continue
+			}
+			newRealizations := zbstore.RealizationMap{
+				DerivationHash: m.DerivationHash,
+				Realizations:   make(map[string][]*zbstore.Realization),
+			}
+			for ref, r := range m.All() {
+				stored, err := findRealizations(conn, realizationFilter{
+					derivationHash: ref.DerivationHash,
+					outputName:     ref.OutputName,
+					outputPath:     r.OutputPath,
+				})
+				if err != nil {
+					return err
+				}
+				if len(stored) == 0 {
+					return jsonrpc.Error(jsonrpc.InvalidParams, fmt.Errorf("add signatures: %v (%s): no such realization", ref, r.OutputPath))
+				}
+				storedRealization := stored[0].Realizations[ref.OutputName][0]
+				newSignatures, err := newRealizationSignatures(ref, storedRealization, r.Signatures)
+				if err != nil {
+					return jsonrpc.Error(jsonrpc.InvalidParams, fmt.Errorf("add signatures: %v (%s): %v", ref, r.OutputPath, err))
+				}
+				if len(newSignatures) == 0 {
+					continue
+				}
+				log.Debugf(ctx, "Adding %d signature(s) to %v (%s)", len(newSignatures), ref, r.OutputPath)
+				// The reference classes are already recorded,
+				// so only pass along the signatures.
+				newRealizations.Realizations[ref.OutputName] = append(newRealizations.Realizations[ref.OutputName], &zbstore.Realization{
+					OutputPath: storedRealization.OutputPath,
+					Signatures: newSignatures,
+				})
+				resp.Added += len(newSignatures)
+			}
+			if err := recordRealizations(conn, newRealizations.All()); err != nil {
+				return fmt.Errorf("add signatures: %v", err)
+			}
+		}
+		return nil
+	}()
+	if err != nil {
+		return nil, err
+	}
+	return marshalResponse(resp)
+}
+
+// newRealizationSignatures verifies each of the signatures for the stored realization r
+// and returns the ones that are not already recorded in r.
+func newRealizationSignatures(ref zbstore.RealizationOutputReference, r *zbstore.Realization, signatures []*zbstore.RealizationSignature) ([]*zbstore.RealizationSignature, error) {
+	var result []*zbstore.RealizationSignature
+	for _, sig := range signatures {
+		if sig == nil {
+			continue
+		}
+		if err := zbstore.VerifyRealizationSignature(ref, r, sig); err != nil {
+			return nil, err
+		}
+		isNew := !slices.ContainsFunc(r.Signatures, func(existing *zbstore.RealizationSignature) bool {
+			return existing.PublicKey.Equal(&sig.PublicKey)
+		}) && !slices.ContainsFunc(result, func(added *zbstore.RealizationSignature) bool {
+			return added.PublicKey.Equal(&sig.PublicKey)
+		})
+		if isNew {
+			result = append(result, sig)
+		}
+	}
+	return result, nil
+}
+
+// RegisterRealizations records the realizations in m
+// without building or downloading anything,
+// so that later builds can reuse them.
+// This is intended for bootstrapping a store from store objects obtained out-of-band,
+// like a vendor-provided toolchain.
+// Every realization must have at least one signature from one of the trusted keys,
+// and all of its signatures must be valid.
+// If any realization fails these checks,
+// then RegisterRealizations returns an error and records none of them.
+func (s *Server) RegisterRealizations(ctx context.Context, m *zbstore.RealizationMap, trusted []*zbstore.RealizationPublicKey) (err error) {
+	for ref, r := range m.All() {
+		if _, subPath, err := s.dir.ParsePath(string(r.OutputPath)); err != nil {
+			return fmt.Errorf("register %v: %v", ref, err)
+		} else if subPath != "" {
+			return fmt.Errorf("register %v: %s is not a store object", ref, r.OutputPath)
+		}
+		isTrusted := false
+		for _, sig := range r.Signatures {
+			if sig == nil {
+				continue
+			}
+			if err := zbstore.VerifyRealizationSignature(ref, r, sig); err != nil {
+				return fmt.Errorf("register %v (%s): %v", ref, r.OutputPath, err)
+			}
+			isTrusted = isTrusted || slices.ContainsFunc(trusted, func(k *zbstore.RealizationPublicKey) bool {
+				return k.Equal(&sig.PublicKey)
+			})
+		}
+		if !isTrusted {
+			return fmt.Errorf("register %v (%s): no signature from a trusted key", ref, r.OutputPath)
+		}
+	}
+
+	conn, err := s.db.Get(ctx)
+	if err != nil {
+		return err
+	}
+	defer s.db.Put(conn)
+
+	endFn, err := sqlitex.ImmediateTransaction(conn)
+	if err != nil {
+		return fmt.Errorf("register realizations for %v: %v", m.DerivationHash, err)
+	}
+	defer endFn(&err)
+	log.Debugf(ctx, "Registering realizations for %v", m.DerivationHash)
+	if err := recordRealizations(conn, m.All()); err != nil {
+		return fmt.Errorf("register realizations for %v: %v", m.DerivationHash, err)
+	}
+	return nil
+}
+
+// errBuildsActive is returned by [*Server.pruneStaleRealizations]
+// when builds are in progress.
+var errBuildsActive = errors.New("builds are in progress")
+
+// pruneRealizationsInterval is the time between automatic runs of [pruneRealizations].
+const pruneRealizationsInterval = 24 * time.Hour
+
+func (s *Server) pruneRealizations(ctx context.Context, req *jsonrpc.Request) (*jsonrpc.Response, error) {
+	var args zbstorerpc.PruneRealizationsRequest
+	if err := jsonv2.Unmarshal(req.Params, &args); err != nil {
+		return nil, jsonrpc.Error(jsonrpc.InvalidParams, err)
+	}
+
+	conn, err := s.db.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer s.db.Put(conn)
+
+	resp, err := s.pruneStaleRealizations(ctx, conn)
+	if err != nil {
+		return nil, fmt.Errorf("prune realizations: %w", err)
+	}
+	return marshalResponse(resp)
+}
+
+// pruneRealizationsPeriodically calls [*Server.pruneStaleRealizations]
+// every [pruneRealizationsInterval] until ctx is done.
+func (s *Server) pruneRealizationsPeriodically(ctx context.Context) {
+	ticker := time.NewTicker(pruneRealizationsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		conn, err := s.db.Get(ctx)
+		if err != nil {
+			// Likely means context was canceled.
+			log.Debugf(ctx, "Exiting realization pruning due to: %v", err)
+			return
+		}
+		if s.substituterMissTTL > 0 {
+			if n, err := deleteExpiredSubstituterMisses(conn, time.Now().Add(-s.substituterMissTTL)); err != nil {
+				log.Warnf(ctx, "%v", err)
+			} else if n > 0 {
+				log.Debugf(ctx, "Deleted %d expired substituter misses", n)
+			}
+		}
+		resp, err := s.pruneStaleRealizations(ctx, conn)
+		s.db.Put(conn)
+		switch {
+		case errors.Is(err, errBuildsActive):
+			log.Debugf(ctx, "Skipping realization pruning: %v", err)
+		case err != nil:
+			log.Warnf(ctx, "Failed to prune realizations: %v", err)
+		case resp.DeletedRealizations > 0 || resp.MergedReferenceClasses > 0 || resp.MergedSignatures > 0:
+			log.Infof(ctx, "Pruned realizations: deleted %d stale realizations, merged %d duplicate reference classes and %d duplicate signatures",
+				resp.DeletedRealizations, resp.MergedReferenceClasses, resp.MergedSignatures)
+		}
+	}
+}
+
+// pruneStaleRealizations calls [pruneRealizations]
+// if there are no active builds.
+// New builds cannot start until pruneStaleRealizations returns.
+func (s *Server) pruneStaleRealizations(ctx context.Context, conn *sqlite.Conn) (*zbstorerpc.PruneRealizationsResponse, error) {
+	// A build may record realizations that refer to realizations
+	// whose outputs have not been downloaded yet,
+	// so pruning must not run concurrently with builds.
+	// conn must be acquired before locking activeBuildsMu
+	// because new builds acquire a connection before registering themselves.
+	s.activeBuildsMu.Lock()
+	defer s.activeBuildsMu.Unlock()
+	if len(s.activeBuilds) > 0 {
+		return nil, errBuildsActive
+	}
+	log.Debugf(ctx, "Pruning realizations...")
+	resp, err := pruneRealizations(conn)
+	if err != nil {
+		return nil, err
+	}
+	s.audit(ctx, &AuditEvent{
+		Action: AuditPruneRealizations,
+		Count:  resp.DeletedRealizations,
+	})
+	return resp, nil
+}
+
+// pruneRealizations deletes realizations whose outputs are not present in the store
+// and are not referenced by other realizations,
+// then removes duplicate reference classes and signatures.
+func pruneRealizations(conn *sqlite.Conn) (_ *zbstorerpc.PruneRealizationsResponse, err error) {
+	endFn, err := sqlitex.ImmediateTransaction(conn)
+	if err != nil {
+		return nil, err
+	}
+	defer endFn(&err)
+
+	resp := new(zbstorerpc.PruneRealizationsResponse)
+	for {
+		// Deleting a realization can make the realizations it referenced unreferenced,
+		// so repeat until we reach a fixed point.
+		if err := sqlitex.ExecuteTransientFS(conn, sqlFiles(), "realizations/delete_stale.sql", nil); err != nil {
+			return nil, fmt.Errorf("delete stale realizations: %v", err)
+		}
+		n := conn.Changes()
+		if n == 0 {
+			break
+		}
+		resp.DeletedRealizations += int64(n)
+	}
+	if err := sqlitex.ExecuteTransientFS(conn, sqlFiles(), "realizations/delete_duplicate_reference_classes.sql", nil); err != nil {
+		return nil, fmt.Errorf("merge duplicate reference classes: %v", err)
+	}
+	resp.MergedReferenceClasses = int64(conn.Changes())
+	if err := sqlitex.ExecuteTransientFS(conn, sqlFiles(), "realizations/delete_duplicate_signatures.sql", nil); err != nil {
+		return nil, fmt.Errorf("merge duplicate signatures: %v", err)
+	}
+	resp.MergedSignatures = int64(conn.Changes())
+	return resp, nil
+}
@@ -0,0 +1,609 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package backend_test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	. "zb.256lights.llc/pkg/internal/backend"
+	"zb.256lights.llc/pkg/internal/backendtest"
+	"zb.256lights.llc/pkg/internal/jsonrpc"
+	"zb.256lights.llc/pkg/internal/storetest"
+	"zb.256lights.llc/pkg/internal/system"
+	"zb.256lights.llc/pkg/internal/testcontext"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+	"zb.256lights.llc/pkg/sets"
+	"zb.256lights.llc/pkg/zbstore"
+	"zombiezen.com/go/nix"
+)
+
+func TestRealizations(t *testing.T) {
+	ctx := testcontext.New(t)
+	dir := backendtest.NewStoreDirectory(t)
+
+	testKey := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{0x42}, ed25519.SeedSize))
+
+	const inputContent = "Hello, World!\n"
+	exportBuffer := new(bytes.Buffer)
+	exporter := zbstore.NewExportWriter(exportBuffer)
+	inputFilePath, _, err := storetest.ExportSourceFile(exporter, []byte(inputContent), storetest.SourceExportOptions{
+		Name:      "hello.txt",
+		Directory: dir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const outputName = "hello2.txt"
+	drvContent := &zbstore.Derivation{
+		Name:   outputName,
+		Dir:    dir,
+		System: system.Current().String(),
+		Env: map[string]string{
+			"in":  string(inputFilePath),
+			"out": zbstore.HashPlaceholder("out"),
+		},
+		InputSources: *sets.NewSorted(
+			inputFilePath,
+		),
+		Outputs: map[string]*zbstore.DerivationOutputType{
+			zbstore.DefaultDerivationOutputName: zbstore.RecursiveFileFloatingCAOutput(nix.SHA256),
+		},
+	}
+	drvContent.Builder, drvContent.Args = catcatBuilder()
+	drvPath, _, err := storetest.ExportDerivation(exporter, drvContent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exporter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	drvHash, err := drvContent.SHA256RealizationHash(func(ref zbstore.OutputReference) (zbstore.Path, bool) {
+		return "", false
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, client, err := backendtest.NewServer(ctx, t, dir, &backendtest.Options{
+		TempDir: t.TempDir(),
+		Options: Options{
+			Keyring: &Keyring{
+				Ed25519: []ed25519.PrivateKey{testKey},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	codec, releaseCodec, err := storeCodec(ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = codec.Export(nil, exportBuffer)
+	releaseCodec()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	realizeResponse := new(zbstorerpc.RealizeResponse)
+	err = jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, realizeResponse, &zbstorerpc.RealizeRequest{
+		DrvPaths: []zbstore.Path{drvPath},
+	})
+	if err != nil {
+		t.Fatal("RPC error:", err)
+	}
+	if _, err := backendtest.WaitForSuccessfulBuild(ctx, client, realizeResponse.BuildID); err != nil {
+		gotLog, _ := backendtest.ReadLog(ctx, client, realizeResponse.BuildID, drvPath)
+		t.Fatalf("build drv: %v\nlog:\n%s", err, gotLog)
+	}
+
+	outputPath, err := singleFileOutputPath(dir, outputName, []byte(inputContent+inputContent), zbstore.References{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	realization := &zbstore.Realization{
+		OutputPath: outputPath,
+	}
+	sig, err := zbstore.SignRealizationWithEd25519(zbstore.RealizationOutputReference{
+		DerivationHash: drvHash,
+		OutputName:     zbstore.DefaultDerivationOutputName,
+	}, realization, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	realization.Signatures = []*zbstore.RealizationSignature{sig}
+	want := []*zbstore.RealizationMap{{
+		DerivationHash: drvHash,
+		Realizations: map[string][]*zbstore.Realization{
+			zbstore.DefaultDerivationOutputName: {realization},
+		},
+	}}
+
+	unbuiltDrvPath, err := dir.Object("00000000000000000000000000000000-bogus.drv")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		params any
+		want   []*zbstore.RealizationMap
+	}{
+		{
+			name:   "DerivationHash",
+			method: zbstorerpc.RealizationsMethod,
+			params: &zbstorerpc.RealizationsRequest{DerivationHash: drvHash},
+			want:   want,
+		},
+		{
+			name:   "DrvPath",
+			method: zbstorerpc.RealizationsMethod,
+			params: &zbstorerpc.RealizationsRequest{DrvPath: drvPath},
+			want:   want,
+		},
+		{
+			name:   "OutputName",
+			method: zbstorerpc.RealizationsMethod,
+			params: &zbstorerpc.RealizationsRequest{
+				DrvPath:    drvPath,
+				OutputName: zbstore.DefaultDerivationOutputName,
+			},
+			want: want,
+		},
+		{
+			name:   "MissingOutputName",
+			method: zbstorerpc.RealizationsMethod,
+			params: &zbstorerpc.RealizationsRequest{
+				DrvPath:    drvPath,
+				OutputName: "bogus",
+			},
+			want: nil,
+		},
+		{
+			name:   "UnbuiltDrvPath",
+			method: zbstorerpc.RealizationsMethod,
+			params: &zbstorerpc.RealizationsRequest{DrvPath: unbuiltDrvPath},
+			want:   nil,
+		},
+		{
+			name:   "OutputPath",
+			method: zbstorerpc.RealizationsByPathMethod,
+			params: &zbstorerpc.RealizationsByPathRequest{Path: outputPath},
+			want:   want,
+		},
+		{
+			name:   "SourcePath",
+			method: zbstorerpc.RealizationsByPathMethod,
+			params: &zbstorerpc.RealizationsByPathRequest{Path: inputFilePath},
+			want:   nil,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := new(zbstorerpc.RealizationsResponse)
+			if err := jsonrpc.Do(ctx, client, test.method, got, test.params); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.want, got.Realizations, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("realizations (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestAddSignatures(t *testing.T) {
+	ctx := testcontext.New(t)
+	dir := backendtest.NewStoreDirectory(t)
+
+	const inputContent = "Hello, World!\n"
+	exportBuffer := new(bytes.Buffer)
+	exporter := zbstore.NewExportWriter(exportBuffer)
+	inputFilePath, _, err := storetest.ExportSourceFile(exporter, []byte(inputContent), storetest.SourceExportOptions{
+		Name:      "hello.txt",
+		Directory: dir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	drvContent := &zbstore.Derivation{
+		Name:   "hello2.txt",
+		Dir:    dir,
+		System: system.Current().String(),
+		Env: map[string]string{
+			"in":  string(inputFilePath),
+			"out": zbstore.HashPlaceholder("out"),
+		},
+		InputSources: *sets.NewSorted(
+			inputFilePath,
+		),
+		Outputs: map[string]*zbstore.DerivationOutputType{
+			zbstore.DefaultDerivationOutputName: zbstore.RecursiveFileFloatingCAOutput(nix.SHA256),
+		},
+	}
+	drvContent.Builder, drvContent.Args = catcatBuilder()
+	drvPath, _, err := storetest.ExportDerivation(exporter, drvContent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exporter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Build without any signing keys.
+	_, client, err := backendtest.NewServer(ctx, t, dir, &backendtest.Options{
+		TempDir: t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	codec, releaseCodec, err := storeCodec(ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = codec.Export(nil, exportBuffer)
+	releaseCodec()
+	if err != nil {
+		t.Fatal(err)
+	}
+	realizeResponse := new(zbstorerpc.RealizeResponse)
+	err = jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, realizeResponse, &zbstorerpc.RealizeRequest{
+		DrvPaths: []zbstore.Path{drvPath},
+	})
+	if err != nil {
+		t.Fatal("RPC error:", err)
+	}
+	if _, err := backendtest.WaitForSuccessfulBuild(ctx, client, realizeResponse.BuildID); err != nil {
+		gotLog, _ := backendtest.ReadLog(ctx, client, realizeResponse.BuildID, drvPath)
+		t.Fatalf("build drv: %v\nlog:\n%s", err, gotLog)
+	}
+
+	realizationsResponse := new(zbstorerpc.RealizationsResponse)
+	err = jsonrpc.Do(ctx, client, zbstorerpc.RealizationsMethod, realizationsResponse, &zbstorerpc.RealizationsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(realizationsResponse.Realizations) != 1 {
+		t.Fatalf("store has %d realization maps; want 1", len(realizationsResponse.Realizations))
+	}
+	unsigned := realizationsResponse.Realizations[0]
+	ref := zbstore.RealizationOutputReference{
+		DerivationHash: unsigned.DerivationHash,
+		OutputName:     zbstore.DefaultDerivationOutputName,
+	}
+	realization := unsigned.Realizations[zbstore.DefaultDerivationOutputName][0]
+	if len(realization.Signatures) > 0 {
+		t.Fatalf("realization has %d signatures before signing; want 0", len(realization.Signatures))
+	}
+
+	testKey := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{0x42}, ed25519.SeedSize))
+	sig, err := zbstore.SignRealizationWithEd25519(ref, realization, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signedMap := func(sig *zbstore.RealizationSignature) []*zbstore.RealizationMap {
+		return []*zbstore.RealizationMap{{
+			DerivationHash: ref.DerivationHash,
+			Realizations: map[string][]*zbstore.Realization{
+				ref.OutputName: {{
+					OutputPath: realization.OutputPath,
+					Signatures: []*zbstore.RealizationSignature{sig},
+				}},
+			},
+		}}
+	}
+
+	t.Run("BadSignature", func(t *testing.T) {
+		badSig := sig.Clone()
+		badSig.Signature[0] ^= 0xff
+		err := jsonrpc.Do(ctx, client, zbstorerpc.AddSignaturesMethod, nil, &zbstorerpc.AddSignaturesRequest{
+			Realizations: signedMap(badSig),
+		})
+		if err == nil {
+			t.Error("adding bad signature succeeded")
+		}
+	})
+
+	for i, want := range []int{1, 0} {
+		resp := new(zbstorerpc.AddSignaturesResponse)
+		err = jsonrpc.Do(ctx, client, zbstorerpc.AddSignaturesMethod, resp, &zbstorerpc.AddSignaturesRequest{
+			Realizations: signedMap(sig),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Added != want {
+			t.Errorf("call #%d added %d signatures; want %d", i+1, resp.Added, want)
+		}
+	}
+
+	realizationsResponse = new(zbstorerpc.RealizationsResponse)
+	err = jsonrpc.Do(ctx, client, zbstorerpc.RealizationsByPathMethod, realizationsResponse, &zbstorerpc.RealizationsByPathRequest{
+		Path: realization.OutputPath,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := signedMap(sig)
+	want[0].Realizations[ref.OutputName][0].ReferenceClasses = realization.ReferenceClasses
+	if diff := cmp.Diff(want, realizationsResponse.Realizations, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("realizations after signing (-want +got):\n%s", diff)
+	}
+}
+
+func TestRegisterRealizations(t *testing.T) {
+	ctx := testcontext.New(t)
+	dir := backendtest.NewStoreDirectory(t)
+
+	// Pretend that the source file is a vendor-provided toolchain
+	// that was imported out-of-band.
+	const toolchainContent = "Hello, World!\n"
+	exportBuffer := new(bytes.Buffer)
+	exporter := zbstore.NewExportWriter(exportBuffer)
+	toolchainPath, _, err := storetest.ExportSourceFile(exporter, []byte(toolchainContent), storetest.SourceExportOptions{
+		Name:      "toolchain.txt",
+		Directory: dir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	drvContent := &zbstore.Derivation{
+		Name:   "toolchain.txt",
+		Dir:    dir,
+		System: system.Current().String(),
+		Env: map[string]string{
+			"out": zbstore.HashPlaceholder("out"),
+		},
+		Outputs: map[string]*zbstore.DerivationOutputType{
+			zbstore.DefaultDerivationOutputName: zbstore.RecursiveFileFloatingCAOutput(nix.SHA256),
+		},
+	}
+	// The builder must never run.
+	drvContent.Builder, drvContent.Args = shPath, []string{"-c", "exit 1"}
+	drvPath, _, err := storetest.ExportDerivation(exporter, drvContent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exporter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	drvHash, err := drvContent.SHA256RealizationHash(func(ref zbstore.OutputReference) (zbstore.Path, bool) {
+		return "", false
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv, client, err := backendtest.NewServer(ctx, t, dir, &backendtest.Options{
+		TempDir: t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	codec, releaseCodec, err := storeCodec(ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = codec.Export(nil, exportBuffer)
+	releaseCodec()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vendorKey := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{0x42}, ed25519.SeedSize))
+	vendorPublicKey := &zbstore.RealizationPublicKey{
+		Format: zbstore.Ed25519SignatureFormat,
+		Data:   vendorKey.Public().(ed25519.PublicKey),
+	}
+	otherKey := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{0x43}, ed25519.SeedSize))
+	otherPublicKey := &zbstore.RealizationPublicKey{
+		Format: zbstore.Ed25519SignatureFormat,
+		Data:   otherKey.Public().(ed25519.PublicKey),
+	}
+	ref := zbstore.RealizationOutputReference{
+		DerivationHash: drvHash,
+		OutputName:     zbstore.DefaultDerivationOutputName,
+	}
+	realization := &zbstore.Realization{OutputPath: toolchainPath}
+	sig, err := zbstore.SignRealizationWithEd25519(ref, realization, vendorKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trustFile := func(sig *zbstore.RealizationSignature) *zbstore.RealizationMap {
+		return &zbstore.RealizationMap{
+			DerivationHash: ref.DerivationHash,
+			Realizations: map[string][]*zbstore.Realization{
+				ref.OutputName: {{
+					OutputPath: realization.OutputPath,
+					Signatures: []*zbstore.RealizationSignature{sig},
+				}},
+			},
+		}
+	}
+
+	t.Run("Untrusted", func(t *testing.T) {
+		err := srv.RegisterRealizations(ctx, trustFile(sig), []*zbstore.RealizationPublicKey{otherPublicKey})
+		if err == nil {
+			t.Error("registering realization signed by an untrusted key succeeded")
+		}
+	})
+	t.Run("BadSignature", func(t *testing.T) {
+		badSig := sig.Clone()
+		badSig.Signature[0] ^= 0xff
+		err := srv.RegisterRealizations(ctx, trustFile(badSig), []*zbstore.RealizationPublicKey{vendorPublicKey})
+		if err == nil {
+			t.Error("registering realization with a bad signature succeeded")
+		}
+	})
+
+	if err := srv.RegisterRealizations(ctx, trustFile(sig), []*zbstore.RealizationPublicKey{vendorPublicKey}); err != nil {
+		t.Fatal("RegisterRealizations:", err)
+	}
+
+	realizeResponse := new(zbstorerpc.RealizeResponse)
+	err = jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, realizeResponse, &zbstorerpc.RealizeRequest{
+		DrvPaths: []zbstore.Path{drvPath},
+		Reuse: &zbstorerpc.ReusePolicy{
+			PublicKeys: []*zbstore.RealizationPublicKey{vendorPublicKey},
+		},
+	})
+	if err != nil {
+		t.Fatal("RPC error:", err)
+	}
+	got, err := backendtest.WaitForSuccessfulBuild(ctx, client, realizeResponse.BuildID)
+	if err != nil {
+		gotLog, _ := backendtest.ReadLog(ctx, client, realizeResponse.BuildID, drvPath)
+		t.Fatalf("build drv: %v\nlog:\n%s", err, gotLog)
+	}
+	checkSingleFileOutput(t, drvPath, toolchainPath, []byte(toolchainContent), got)
+}
+
+func TestPruneRealizations(t *testing.T) {
+	ctx := testcontext.New(t)
+	dir := backendtest.NewStoreDirectory(t)
+
+	const inputContent = "Hello, World!\n"
+	exportBuffer := new(bytes.Buffer)
+	exporter := zbstore.NewExportWriter(exportBuffer)
+	inputFilePath, _, err := storetest.ExportSourceFile(exporter, []byte(inputContent), storetest.SourceExportOptions{
+		Name:      "hello.txt",
+		Directory: dir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	drvContent := &zbstore.Derivation{
+		Name:   "hello2.txt",
+		Dir:    dir,
+		System: system.Current().String(),
+		Env: map[string]string{
+			"in":  string(inputFilePath),
+			"out": zbstore.HashPlaceholder("out"),
+		},
+		InputSources: *sets.NewSorted(
+			inputFilePath,
+		),
+		Outputs: map[string]*zbstore.DerivationOutputType{
+			zbstore.DefaultDerivationOutputName: zbstore.RecursiveFileFloatingCAOutput(nix.SHA256),
+		},
+	}
+	drvContent.Builder, drvContent.Args = catcatBuilder()
+	drvPath, _, err := storetest.ExportDerivation(exporter, drvContent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exporter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	srv, client, err := backendtest.NewServer(ctx, t, dir, &backendtest.Options{
+		TempDir: t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	codec, releaseCodec, err := storeCodec(ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = codec.Export(nil, exportBuffer)
+	releaseCodec()
+	if err != nil {
+		t.Fatal(err)
+	}
+	realizeResponse := new(zbstorerpc.RealizeResponse)
+	err = jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, realizeResponse, &zbstorerpc.RealizeRequest{
+		DrvPaths: []zbstore.Path{drvPath},
+	})
+	if err != nil {
+		t.Fatal("RPC error:", err)
+	}
+	if _, err := backendtest.WaitForSuccessfulBuild(ctx, client, realizeResponse.BuildID); err != nil {
+		gotLog, _ := backendtest.ReadLog(ctx, client, realizeResponse.BuildID, drvPath)
+		t.Fatalf("build drv: %v\nlog:\n%s", err, gotLog)
+	}
+	realizationsResponse := new(zbstorerpc.RealizationsResponse)
+	err = jsonrpc.Do(ctx, client, zbstorerpc.RealizationsMethod, realizationsResponse, &zbstorerpc.RealizationsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(realizationsResponse.Realizations) != 1 {
+		t.Fatalf("store has %d realization maps; want 1", len(realizationsResponse.Realizations))
+	}
+	built := realizationsResponse.Realizations[0]
+	builtRef := zbstore.RealizationOutputReference{
+		DerivationHash: built.DerivationHash,
+		OutputName:     zbstore.DefaultDerivationOutputName,
+	}
+	builtRealization := built.Realizations[builtRef.OutputName][0]
+
+	// Record a realization of an object that is not in the store
+	// and sign the built realization twice.
+	testKey := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{0x42}, ed25519.SeedSize))
+	testPublicKey := &zbstore.RealizationPublicKey{
+		Format: zbstore.Ed25519SignatureFormat,
+		Data:   testKey.Public().(ed25519.PublicKey),
+	}
+	gonePath, err := dir.Object("ffffffffffffffffffffffffffffffff-gone.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	goneRef := zbstore.RealizationOutputReference{
+		DerivationHash: nix.NewHash(nix.SHA256, make([]byte, nix.SHA256.Size())),
+		OutputName:     zbstore.DefaultDerivationOutputName,
+	}
+	goneRealization := &zbstore.Realization{OutputPath: gonePath}
+	goneSig, err := zbstore.SignRealizationWithEd25519(goneRef, goneRealization, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	goneRealization.Signatures = []*zbstore.RealizationSignature{goneSig}
+	err = srv.RegisterRealizations(ctx, &zbstore.RealizationMap{
+		DerivationHash: goneRef.DerivationHash,
+		Realizations: map[string][]*zbstore.Realization{
+			goneRef.OutputName: {goneRealization},
+		},
+	}, []*zbstore.RealizationPublicKey{testPublicKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	builtSig, err := zbstore.SignRealizationWithEd25519(builtRef, builtRealization, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	builtRealization.Signatures = []*zbstore.RealizationSignature{builtSig}
+	for range 2 {
+		err := srv.RegisterRealizations(ctx, built, []*zbstore.RealizationPublicKey{testPublicKey})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pruneResponse := new(zbstorerpc.PruneRealizationsResponse)
+	err = jsonrpc.Do(ctx, client, zbstorerpc.PruneRealizationsMethod, pruneResponse, &zbstorerpc.PruneRealizationsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantPrune := &zbstorerpc.PruneRealizationsResponse{
+		DeletedRealizations: 1,
+		MergedSignatures:    1,
+	}
+	if diff := cmp.Diff(wantPrune, pruneResponse); diff != "" {
+		t.Errorf("prune response (-want +got):\n%s", diff)
+	}
+
+	realizationsResponse = new(zbstorerpc.RealizationsResponse)
+	err = jsonrpc.Do(ctx, client, zbstorerpc.RealizationsMethod, realizationsResponse, &zbstorerpc.RealizationsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*zbstore.RealizationMap{built}
+	if diff := cmp.Diff(want, realizationsResponse.Realizations, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("realizations after prune (-want +got):\n%s", diff)
+	}
+}
@@ -12,12 +12,12 @@ import (
 	"io"
 	"iter"
 	"maps"
+	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
 	"slices"
-	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -26,8 +26,10 @@ import (
 	jsonv2 "github.com/go-json-experiment/json"
 	"github.com/google/uuid"
 	"zb.256lights.llc/pkg/bytebuffer"
+	"zb.256lights.llc/pkg/internal/credentials"
 	"zb.256lights.llc/pkg/internal/detect"
 	"zb.256lights.llc/pkg/internal/jsonrpc"
+	"zb.256lights.llc/pkg/internal/osutil"
 	"zb.256lights.llc/pkg/internal/storepath"
 	"zb.256lights.llc/pkg/internal/system"
 	"zb.256lights.llc/pkg/internal/xio"
@@ -46,10 +48,21 @@ import (
 
 // Special environment variable names.
 const (
-	buildSystemDepsVar = "__buildSystemDeps"
-	networkVar         = "__network"
+	buildSystemDepsVar  = "__buildSystemDeps"
+	hashedSystemDepsVar = "__hashedSystemDeps"
+	networkVar          = "__network"
+	deterministicVar    = "__deterministic"
+	buildTmpfsVar       = "__buildTmpfs"
+
+	requiredSystemFeaturesVar = "__requiredSystemFeatures"
 )
 
+// usesNetwork reports whether the derivation's builder is given network access.
+func usesNetwork(drv *zbstore.Derivation) bool {
+	return drv.Outputs[zbstore.DefaultDerivationOutputName].IsFixed() ||
+		drv.Env[networkVar] == "1"
+}
+
 func (s *Server) realize(ctx context.Context, req *jsonrpc.Request) (_ *jsonrpc.Response, err error) {
 	// Validate request.
 	var args zbstorerpc.RealizeRequest
@@ -73,6 +86,23 @@ func (s *Server) realize(ctx context.Context, req *jsonrpc.Request) (_ *jsonrpc.
 		}
 		drvPaths = append(drvPaths, drvPath)
 	}
+	if args.Check && args.SubstituteOnly {
+		return nil, jsonrpc.Error(jsonrpc.InvalidParams, fmt.Errorf("check and substituteOnly are mutually exclusive"))
+	}
+	if (args.TraceExec || args.TraceInputs) && !canTraceExec() {
+		return nil, jsonrpc.Error(jsonrpc.InvalidParams, fmt.Errorf("builder tracing not supported on %s", runtime.GOOS))
+	}
+	var replayBuildID uuid.UUID
+	if args.ReplaySchedule != "" {
+		if args.SubstituteOnly {
+			return nil, jsonrpc.Error(jsonrpc.InvalidParams, fmt.Errorf("replaySchedule and substituteOnly are mutually exclusive"))
+		}
+		var err error
+		replayBuildID, err = uuid.Parse(args.ReplaySchedule)
+		if err != nil {
+			return nil, jsonrpc.Error(jsonrpc.InvalidParams, fmt.Errorf("replay schedule: %v", err))
+		}
+	}
 	buildID, err := uuid.NewV7()
 	if err != nil {
 		return nil, err
@@ -91,6 +121,19 @@ func (s *Server) realize(ctx context.Context, req *jsonrpc.Request) (_ *jsonrpc.
 	}
 	defer s.db.Put(conn)
 
+	var replay map[zbstore.Path]*scheduleDecision
+	if replayBuildID != uuid.Nil {
+		replay, err = findBuildSchedule(conn, replayBuildID)
+		if err != nil {
+			return nil, err
+		}
+		if len(replay) == 0 {
+			return nil, jsonrpc.Error(jsonrpc.InvalidParams, fmt.Errorf("build %v has no recorded schedule", replayBuildID))
+		}
+		log.Infof(ctx, "Build %v will replay the schedule of build %v", buildID, replayBuildID)
+	}
+
+	client := ClientFromContext(ctx)
 	buildCtx, cancelBuild, err := s.registerBuildID(ctx, conn, buildID)
 	if err != nil {
 		return nil, fmt.Errorf("build %s: %v", drvPathList, err)
@@ -109,6 +152,18 @@ func (s *Server) realize(ctx context.Context, req *jsonrpc.Request) (_ *jsonrpc.
 			}
 		}
 		b := s.newBuilder(buildID, drvCache, args.Reuse)
+		b.client = client
+		b.priority = args.Priority
+		b.substituteOnly = args.SubstituteOnly
+		b.offline = b.offline || args.Offline
+		b.traceExec = args.TraceExec
+		b.traceInputs = args.TraceInputs
+		if args.Check {
+			b.check = sets.Collect(slices.Values(drvPaths))
+		}
+		if replay != nil {
+			b.replaySchedule(replay)
+		}
 		realizeError := b.realize(buildCtx, wantOutputs, args.KeepFailed)
 		if realizeError != nil && !errors.Is(realizeError, errUnfinishedRealization) {
 			log.Errorf(buildCtx, "Realize internal error: %v", realizeError)
@@ -259,6 +314,7 @@ func (s *Server) registerBuildID(parent context.Context, conn *sqlite.Conn, buil
 	draining := s.draining
 	if !draining {
 		s.activeBuilds[buildID] = cancel
+		s.runningBuilds.Add(1)
 	}
 	s.activeBuildsMu.Unlock()
 
@@ -273,17 +329,46 @@ func (s *Server) registerBuildID(parent context.Context, conn *sqlite.Conn, buil
 		delete(s.activeBuilds, buildID)
 		s.activeBuildsMu.Unlock()
 		cancel()
+		s.runningBuilds.Done()
 	}, nil
 }
 
 type builder struct {
 	id     uuid.UUID
 	server *Server
+	// client is the client that requested the build.
+	client *ClientInfo
+	// priority is the build's scheduling priority relative to other builds.
+	priority int
+	// substituteOnly is true if the builder must not run any builders.
+	// See [zbstorerpc.RealizeRequest.SubstituteOnly].
+	substituteOnly bool
+	// offline is true if the builder must not use the network.
+	// See [zbstorerpc.RealizeRequest.Offline].
+	offline bool
+	// traceExec is true if builders should record the programs they execute.
+	// See [zbstorerpc.RealizeRequest.TraceExec].
+	traceExec bool
+	// traceInputs is true if builders should record which inputs they open.
+	// See [zbstorerpc.RealizeRequest.TraceInputs].
+	traceInputs bool
 
 	reusePolicy  *zbstorerpc.ReusePolicy
 	derivations  map[zbstore.Path]*zbstore.Derivation
 	drvHashes    map[zbstore.Path]nix.Hash
 	realizations map[equivalenceClass]cachedRealization
+
+	// substituted is the set of store objects
+	// that were copied from the fallback store during this build.
+	substituted sets.Set[zbstore.Path]
+	// check is the set of derivations whose builders should be run
+	// even if they have existing realizations.
+	// See [*builder.checkRealizations].
+	check sets.Set[zbstore.Path]
+	// replay is the set of scheduling decisions from a previous build
+	// that this build follows.
+	// See [*builder.replaySchedule].
+	replay map[zbstore.Path]*scheduleDecision
 }
 
 type cachedRealization struct {
@@ -305,10 +390,42 @@ func (s *Server) newBuilder(id uuid.UUID, derivations map[zbstore.Path]*zbstore.
 		id:          id,
 		derivations: derivations,
 
+		offline:      s.offline,
 		reusePolicy:  reuse,
 		drvHashes:    make(map[zbstore.Path]nix.Hash),
 		realizations: make(map[equivalenceClass]cachedRealization),
+		substituted:  make(sets.Set[zbstore.Path]),
+	}
+}
+
+// replaySchedule makes the builder follow the scheduling decisions
+// that a previous build made.
+// Derivations whose builders ran in the previous build
+// have their builders run again, as if they were being checked.
+func (b *builder) replaySchedule(schedule map[zbstore.Path]*scheduleDecision) {
+	b.replay = schedule
+	for drvPath, d := range schedule {
+		if d.built {
+			if b.check == nil {
+				b.check = make(sets.Set[zbstore.Path])
+			}
+			b.check.Add(drvPath)
+		}
+	}
+}
+
+// provenance returns the provenance of a reused realization's store object.
+func (b *builder) provenance(path zbstore.Path) *zbstorerpc.OutputProvenance {
+	if path == "" {
+		return nil
+	}
+	if b.substituted.Has(path) {
+		return &zbstorerpc.OutputProvenance{
+			Method: zbstorerpc.ProvenanceSubstituted,
+			Store:  b.server.fallbackName,
+		}
 	}
+	return &zbstorerpc.OutputProvenance{Method: zbstorerpc.ProvenanceReused}
 }
 
 func (b *builder) toEquivalenceClass(ref zbstore.OutputReference) (_ derivationPathAndEquivalenceClass, ok bool) {
@@ -337,6 +454,15 @@ func (b *builder) lookup(ref zbstore.OutputReference) (_ zbstore.Path, ok bool)
 
 var errUnfinishedRealization = errors.New("realization did not complete")
 
+// errSubstituteOnly is wrapped by the error returned from [*builder.do]
+// when a derivation needs to be built
+// but the build only permits substitution.
+var errSubstituteOnly = errors.New("only substitution was allowed")
+
+// errOffline is wrapped by errors returned when a build
+// would need to use the network but the build is offline.
+var errOffline = errors.New("network access is disabled (offline mode)")
+
 func (b *builder) realize(ctx context.Context, want sets.Set[zbstore.OutputReference], keepFailed bool) error {
 	log.Debugf(ctx, "Will realize %v...", want)
 
@@ -360,9 +486,19 @@ func (b *builder) realize(ctx context.Context, want sets.Set[zbstore.OutputRefer
 		}
 	}()
 	it := newDependencyOrderIterator(graph, buildRoots.All())
-	for {
+	if b.replay != nil {
+		it.order = make(map[zbstore.Path]int, len(b.replay))
+		for drvPath, d := range b.replay {
+			it.order[drvPath] = d.seq
+		}
+	}
+	unfinished := false
+	for scheduleSeq := 1; ; scheduleSeq++ {
 		curr, err := it.next(ctx)
 		if err == errEndIteration {
+			if unfinished {
+				return errUnfinishedRealization
+			}
 			return nil
 		}
 		if err != nil {
@@ -389,7 +525,18 @@ func (b *builder) realize(ctx context.Context, want sets.Set[zbstore.OutputRefer
 		drvLocks[curr] = unlock
 		log.Debugf(ctx, "Acquired build lock on %s", curr)
 		graphNode := graph.nodes[curr]
-		if err := b.do(ctx, curr, graphNode.usedOutputs, keepFailed); err != nil {
+		err = b.do(ctx, curr, scheduleSeq, graphNode.usedOutputs, keepFailed)
+		if errors.Is(err, errSubstituteOnly) {
+			// Keep going so that the build reports
+			// every derivation that would need to be built,
+			// not just the first one we encounter.
+			drvLocks[curr]()
+			delete(drvLocks, curr)
+			unfinished = true
+			it.finish(curr, false)
+			continue
+		}
+		if err != nil {
 			// b.do already records the build failure,
 			// so we don't need to report the same error at the build level.
 			if !isBuilderFailure(err) {
@@ -419,6 +566,11 @@ func (b *builder) expand(drvPath zbstore.Path, drv *zbstore.Derivation, temporar
 		maps.All(inputRewrites),
 	))
 	expandedDrv := drv.ReplaceStrings(r)
+	determinism, err := b.determinism(drv)
+	if err != nil {
+		return nil, fmt.Errorf("expand %s: %v", drvPath, err)
+	}
+	determinism.fillEnv(expandedDrv.Env)
 	fillBaseEnv(expandedDrv.Env, drv.Dir, temporaryDirectory, b.server.coresPerBuild)
 	return expandedDrv, nil
 }
@@ -498,9 +650,27 @@ func (b *builder) gatherRealizationsForDerivation(ctx context.Context, curr zbst
 		// we have a non-hermetic step.
 		return p.error
 	case errors.Is(p.error, errRealizationNotFound):
+		// Realizations recorded by a previous fallback store lookup
+		// (or by zb store trust)
+		// can be used for planning without their store objects being present:
+		// obtainBuildRoots only downloads the store objects that the build references.
+		// If our reuse policy trusts such a set,
+		// then we don't need to ask the fallback store again.
+		// Only realizations signed by a key in the reuse policy are trusted here:
+		// a policy that reuses all realizations would accept any recorded realization,
+		// including ones whose store objects neither we nor the fallback store have.
+		recorded := b.newPlanner()
+		recorded.reusePolicy = &zbstorerpc.ReusePolicy{PublicKeys: b.reusePolicy.PublicKeys}
+		recorded.planSeq(ctx, conn, wantEqClasses)
+		if len(b.reusePolicy.PublicKeys) > 0 && recorded.error == nil {
+			log.Debugf(ctx, "Using recorded realizations for %s without fetching store objects", curr)
+			recorded.commit()
+			return nil
+		}
+
 		// It's possible the fallback store has more realizations.
 		// Fetch and record those.
-		realizations := b.fetchRealizationsFromFallback(ctx, drvHash)
+		realizations := b.fetchRealizationsFromFallback(ctx, conn, drvHash)
 		if realizations.IsEmpty() {
 			return fmt.Errorf("realize %s: %w", curr, errRealizationNotFound)
 		}
@@ -620,7 +790,7 @@ func (b *builder) obtainBuildRootsForDerivation(ctx context.Context, graph *depe
 		}
 
 		{
-			err := b.server.copyFromFallback(ctx, conn, func(yield func(pathAndEquivalenceClass) bool) {
+			imported, err := b.server.copyFromFallback(ctx, conn, func(yield func(pathAndEquivalenceClass) bool) {
 				for path, eqClassesForPath := range paths {
 					for eqClass := range eqClassesForPath.All() {
 						if !yield(pathAndEquivalenceClass{path, eqClass}) {
@@ -628,7 +798,8 @@ func (b *builder) obtainBuildRootsForDerivation(ctx context.Context, graph *depe
 						}
 					}
 				}
-			})
+			}, b.offline)
+			b.substituted.AddSeq(imported.All())
 			if err == nil {
 				log.Debugf(ctx, "Adding build root %s", curr)
 				roots.Add(curr)
@@ -674,6 +845,7 @@ func (b *builder) ignoreRealizations(graph *dependencyGraph, roots sets.Set[zbst
 // derivationBuildState holds information used throughout a call to [*builder.do].
 type derivationBuildState struct {
 	startTime         time.Time
+	scheduleSeq       int
 	drvPath           zbstore.Path
 	outputNames       sets.Set[unique.Handle[string]]
 	derivation        *zbstore.Derivation
@@ -685,12 +857,14 @@ type derivationBuildState struct {
 
 // do ensures that a single derivation has realizations for the given set of outputs,
 // either by reusing existing realizations or by building it.
+// scheduleSeq is the derivation's position in the order that the build processes derivations.
 // b.drvHashes must have a non-zero value for drvPath before calling do
 // (which implies the caller realized all of the derivation's inputs)
 // or else do returns an error.
-func (b *builder) do(ctx context.Context, drvPath zbstore.Path, outputNames sets.Set[unique.Handle[string]], keepFailed bool) (err error) {
+func (b *builder) do(ctx context.Context, drvPath zbstore.Path, scheduleSeq int, outputNames sets.Set[unique.Handle[string]], keepFailed bool) (err error) {
 	state := &derivationBuildState{
 		startTime:      time.Now(),
+		scheduleSeq:    scheduleSeq,
 		drvPath:        drvPath,
 		outputNames:    outputNames,
 		derivation:     b.derivations[drvPath],
@@ -719,8 +893,15 @@ func (b *builder) do(ctx context.Context, drvPath zbstore.Path, outputNames sets
 	}
 	defer b.server.db.Put(conn)
 
-	if err := b.reuseRealizations(ctx, conn, state); err == nil || !isRealizationPlanningError(err) {
+	switch err := b.reuseRealizations(ctx, conn, state); {
+	case err == nil && b.check.Has(drvPath):
+		return b.checkRealizations(ctx, conn, state, keepFailed)
+	case err == nil || !isRealizationPlanningError(err):
 		return err
+	case b.check.Has(drvPath):
+		log.Infof(ctx, "No existing realizations of %s to check; building normally", drvPath)
+	case b.replay[drvPath] != nil && !b.replay[drvPath].built:
+		log.Warnf(ctx, "Replayed schedule reused existing realizations of %s, but none are available; building", drvPath)
 	}
 
 	defer func() {
@@ -772,7 +953,7 @@ func (b *builder) do(ctx context.Context, drvPath zbstore.Path, outputNames sets
 					},
 				},
 			}
-			if err := b.recordRealizations(ctx, conn, state.buildResultID, outputs); err != nil {
+			if err := b.recordRealizations(ctx, conn, state.buildResultID, outputs, false); err != nil {
 				return fmt.Errorf("build %s: %v", drvPath, err)
 			}
 			return nil
@@ -784,64 +965,48 @@ func (b *builder) do(ctx context.Context, drvPath zbstore.Path, outputNames sets
 		// TODO(someday): b.copyFromFallbackAndFinalizeBuildResult
 	}
 
-	// Verify that builder can run.
-	if !canBuildLocally(state.derivation) {
-		return fmt.Errorf("build %s: a %s system is required, but host is a %v system",
-			drvPath, state.derivation.System, system.Current())
-	}
-	buildSystemDeps := state.derivation.Env[buildSystemDepsVar]
-	if hasPlaceholders(state.derivation, buildSystemDeps) {
-		return fmt.Errorf("build %s: %s contains placeholders", drvPath, buildSystemDeps)
+	if reason, err := b.explainRebuild(ctx, conn, state); err != nil {
+		log.Warnf(ctx, "%v", err)
+	} else {
+		b.recordRebuildReason(ctx, conn, state, reason)
 	}
-	for dep := range strings.FieldsSeq(buildSystemDeps) {
-		if !xmaps.HasKey(b.server.sandboxPaths, dep) {
-			return fmt.Errorf("build %s: system dependency %s not allowed", drvPath, buildSystemDeps)
-		}
+
+	if b.substituteOnly {
+		log.Infof(ctx, "Not building %s: no substitute available", drvPath)
+		return builderFailure{fmt.Errorf("build %s: no substitute available: %w", drvPath, errSubstituteOnly)}
 	}
-	for _, input := range state.derivation.InputSources.All() {
-		log.Debugf(ctx, "Waiting for lock on %s (input to %s)...", input, drvPath)
-		unlockInput, err := b.server.writing.lock(ctx, input)
-		if err != nil {
-			return fmt.Errorf("build %s: wait for %s: %w", drvPath, input, err)
+	if b.offline && usesNetwork(state.derivation) {
+		err := fmt.Errorf("build %s: %w", drvPath, errOffline)
+		if u := state.derivation.Env["url"]; u != "" {
+			err = fmt.Errorf("build %s: fetch %s: %w", drvPath, u, errOffline)
 		}
-		_, err = os.Lstat(b.server.realPath(input))
-		unlockInput()
-		log.Debugf(ctx, "%s exists=%t (input to %s)", input, err == nil, drvPath)
-		if err != nil {
-			return fmt.Errorf("build %s: input %s not present (%v)", drvPath, input, err)
+		log.Infof(ctx, "%v", err)
+		if logError := appendToBuilderLog(b.server.logDir, b.id, drvPath, []byte(err.Error()+"\n")); logError != nil {
+			log.Warnf(ctx, "Failed to write offline error to log: %v", logError)
 		}
+		return builderFailure{err}
 	}
-	buildUser, err := b.server.users.acquire(ctx)
+
+	runner, err := b.prepareRunner(ctx, state)
 	if err != nil {
-		return fmt.Errorf("build %s: %v", drvPath, err)
-	}
-	if buildUser != nil {
-		log.Debugf(ctx, "Using build user %v", buildUser)
+		return err
 	}
-	defer b.server.users.release(buildUser)
-
-	// Arrange for builder to run.
-	var runner runnerFunc
-	switch {
-	case state.derivation.System == builtinSystem:
-		log.Debugf(ctx, "Runner for %s is builtin", drvPath)
-		runner = runBuiltin
-	case b.server.sandbox:
-		log.Debugf(ctx, "Runner for %s is sandbox", drvPath)
-		runner = runSandboxed
-	default:
-		log.Debugf(ctx, "Runner for %s is unsandboxed", drvPath)
-		runner = runSubprocess
+	buildUser, release, err := b.reserveBuilder(ctx, drvPath)
+	if err != nil {
+		return err
 	}
+	defer release()
+	builderStart := time.Now()
 	tempOutPaths, err := b.runBuilder(ctx, conn, drvPath, state.buildResultID, keepFailed, buildUser, runner)
 	if err != nil {
 		return err
 	}
+	builderEnd := time.Now()
 	defer func() {
 		if err != nil {
 			for _, outPath := range tempOutPaths {
 				realOutPath := b.server.realPath(outPath)
-				if err := os.RemoveAll(realOutPath); err != nil {
+				if err := osutil.ForceRemoveAll(realOutPath); err != nil {
 					log.Warnf(ctx, "Cleanup failure: %v", err)
 				}
 			}
@@ -854,11 +1019,19 @@ func (b *builder) do(ctx context.Context, drvPath zbstore.Path, outputNames sets
 		return err
 	}
 	inputPaths := sets.CollectSorted(maps.Keys(inputs))
+	var attestRun *attestationRun
+	if !b.server.keyring.IsEmpty() {
+		attestRun, err = b.newAttestationRun(conn, state, inputPaths, builderStart, builderEnd)
+		if err != nil {
+			return err
+		}
+	}
 	outputs := zbstore.RealizationMap{
 		DerivationHash: state.derivationHash,
 		Realizations:   make(map[string][]*zbstore.Realization),
 	}
 	objectsToUpload := make([]*ObjectInfo, 0, len(tempOutPaths))
+	var attestations []attestation
 	for outputName, tempOutputPath := range tempOutPaths {
 		ref := zbstore.OutputReference{
 			DrvPath:    drvPath,
@@ -902,9 +1075,24 @@ func (b *builder) do(ctx context.Context, drvPath zbstore.Path, outputNames sets
 			log.Warnf(ctx, "Signing built realization: %v", err)
 		}
 		outputs.Realizations[outputName] = []*zbstore.Realization{r}
+
+		if attestRun != nil {
+			env, err := b.attest(attestRun, outputName, info)
+			if err != nil {
+				return fmt.Errorf("build %s: %v", drvPath, err)
+			}
+			attestations = append(attestations, attestation{
+				ref: zbstore.RealizationOutputReference{
+					DerivationHash: state.derivationHash,
+					OutputName:     outputName,
+				},
+				outputPath: info.StorePath,
+				envelope:   env,
+			})
+		}
 	}
 
-	if b.server.upload != nil {
+	if b.server.upload != nil && !b.offline {
 		srv := b.server
 		srv.background.Go(func() {
 			srv.uploadClosure(srv.backgroundContext, slices.Values(objectsToUpload))
@@ -912,20 +1100,119 @@ func (b *builder) do(ctx context.Context, drvPath zbstore.Path, outputNames sets
 	}
 
 	// Record realizations.
-	if err := b.recordRealizations(ctx, conn, state.buildResultID, outputs); err != nil {
+	if err := b.recordRealizations(ctx, conn, state.buildResultID, outputs, true); err != nil {
+		return fmt.Errorf("build %s: %v", drvPath, err)
+	}
+	if err := recordAttestations(conn, attestations); err != nil {
 		return fmt.Errorf("build %s: %v", drvPath, err)
 	}
 
-	log.Infof(ctx, "Built %s: %s", drvPath, formatOutputPaths(maps.Collect(func(yield func(string, zbstore.Path) bool) {
+	builtPaths := maps.Collect(func(yield func(string, zbstore.Path) bool) {
 		for ref, r := range outputs.All() {
 			if !yield(ref.OutputName, r.OutputPath) {
 				return
 			}
 		}
-	})))
+	})
+	log.Infof(ctx, "Built %s: %s", drvPath, formatOutputPaths(builtPaths))
+	if !b.server.keyring.IsEmpty() {
+		event := &AuditEvent{
+			Action: AuditSign,
+			Paths:  slices.Sorted(maps.Values(builtPaths)),
+			Keys:   b.server.keyring.PublicKeys(),
+		}
+		event.setClient(b.client)
+		b.server.audit(ctx, event)
+	}
+	b.runPostBuildHook(ctx, drvPath, slices.Collect(maps.Values(builtPaths)))
 	return nil
 }
 
+// prepareRunner verifies that the builder for the derivation in state can run
+// and returns the [runnerFunc] that should be used to run it.
+func (b *builder) prepareRunner(ctx context.Context, state *derivationBuildState) (runnerFunc, error) {
+	drvPath := state.drvPath
+	if !canBuildLocally(state.derivation) {
+		return nil, fmt.Errorf("build %s: a %s system is required, but host is a %v system",
+			drvPath, state.derivation.System, system.Current())
+	}
+	if missing := missingSystemFeatures(state.derivation.Env[requiredSystemFeaturesVar], b.server.systemFeatures); len(missing) > 0 {
+		return nil, fmt.Errorf("build %s: requires system features %s, which this store does not support",
+			drvPath, strings.Join(missing, ", "))
+	}
+	buildSystemDeps := state.derivation.Env[buildSystemDepsVar]
+	if hasPlaceholders(state.derivation, buildSystemDeps) {
+		return nil, fmt.Errorf("build %s: %s contains placeholders", drvPath, buildSystemDeps)
+	}
+	for dep := range strings.FieldsSeq(buildSystemDeps) {
+		if !xmaps.HasKey(b.server.sandboxPaths, dep) {
+			return nil, fmt.Errorf("build %s: system dependency %s not allowed", drvPath, buildSystemDeps)
+		}
+	}
+	hashedSystemDeps := state.derivation.Env[hashedSystemDepsVar]
+	if hasPlaceholders(state.derivation, hashedSystemDeps) {
+		return nil, fmt.Errorf("build %s: %s contains placeholders", drvPath, hashedSystemDepsVar)
+	}
+	hashedPaths, err := b.server.verifyHashedSystemDeps(ctx, hashedSystemDeps)
+	if err != nil {
+		return nil, fmt.Errorf("build %s: %v", drvPath, err)
+	}
+	for _, input := range state.derivation.InputSources.All() {
+		log.Debugf(ctx, "Waiting for lock on %s (input to %s)...", input, drvPath)
+		unlockInput, err := b.server.writing.lock(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("build %s: wait for %s: %w", drvPath, input, err)
+		}
+		_, err = os.Lstat(b.server.realPath(input))
+		unlockInput()
+		log.Debugf(ctx, "%s exists=%t (input to %s)", input, err == nil, drvPath)
+		if err != nil {
+			return nil, fmt.Errorf("build %s: input %s not present (%v)", drvPath, input, err)
+		}
+	}
+
+	var runner runnerFunc
+	switch {
+	case state.derivation.System == builtinSystem:
+		log.Debugf(ctx, "Runner for %s is builtin", drvPath)
+		runner = runBuiltin
+	case b.server.sandbox:
+		log.Debugf(ctx, "Runner for %s is sandbox", drvPath)
+		runner = runSandboxed
+	default:
+		log.Debugf(ctx, "Runner for %s is unsandboxed", drvPath)
+		runner = runSubprocess
+	}
+	return withSandboxPaths(runner, hashedPaths), nil
+}
+
+// reserveBuilder waits for a build slot and a build user
+// to run the builder for the derivation at drvPath.
+// On success, the caller must call release after the builder has finished.
+func (b *builder) reserveBuilder(ctx context.Context, drvPath zbstore.Path) (buildUser *BuildUser, release func(), err error) {
+	log.Debugf(ctx, "Waiting for build slot for %s (requested by %v)...", drvPath, b.client)
+	releaseSlot, err := b.server.scheduler.acquire(ctx, b.client, b.priority)
+	if err != nil {
+		return nil, nil, fmt.Errorf("build %s: %w", drvPath, err)
+	}
+	if d := b.replay[drvPath]; d != nil && d.buildUser != nil {
+		buildUser, err = b.server.users.acquireUser(ctx, *d.buildUser)
+	} else {
+		buildUser, err = b.server.users.acquire(ctx)
+	}
+	if err != nil {
+		releaseSlot()
+		return nil, nil, fmt.Errorf("build %s: %v", drvPath, err)
+	}
+	if buildUser != nil {
+		log.Debugf(ctx, "Using build user %v", buildUser)
+	}
+	return buildUser, func() {
+		b.server.users.release(buildUser)
+		releaseSlot()
+	}, nil
+}
+
 // reuseRealizations creates a new build result for the derivation being built in state,
 // then attempts to reuse available realizations (locally and in the fallback store)
 // to satisfy the build request.
@@ -947,7 +1234,7 @@ func (b *builder) reuseRealizations(ctx context.Context, conn *sqlite.Conn, stat
 		}
 		defer endFn(&err)
 
-		state.buildResultID, err = insertBuildResult(conn, b.id, state.drvPath, state.derivationHash, state.startTime)
+		state.buildResultID, err = insertBuildResult(conn, b.id, state.drvPath, state.derivationHash, state.startTime, state.scheduleSeq)
 		if err != nil {
 			return err
 		}
@@ -961,7 +1248,7 @@ func (b *builder) reuseRealizations(ctx context.Context, conn *sqlite.Conn, stat
 		// we want to set outputs for this build results during this transaction.
 		// If the search did not pull up anything, we set a default of nulls for all requested outputs.
 		if !p.isAvailableLocally() {
-			err := setBuildResultOutputs(conn, state.buildResultID, buildResultOutputsFromPlanner(state, nil))
+			err := setBuildResultOutputs(conn, state.buildResultID, b.buildResultOutputsFromPlanner(state, nil))
 			if err != nil {
 				// If setting the outputs failed, then it's probably an I/O error of some sort.
 				// Finalizing the build result probably won't succeed,
@@ -996,7 +1283,7 @@ func (b *builder) reuseRealizations(ctx context.Context, conn *sqlite.Conn, stat
 		}
 	}
 
-	newRealizations := b.fetchRealizationsFromFallback(ctx, state.derivationHash)
+	newRealizations := b.fetchRealizationsFromFallback(ctx, conn, state.derivationHash)
 	if newRealizations.IsEmpty() {
 		return fmt.Errorf("build %s: %w", state.drvPath, errRealizationNotFound)
 	}
@@ -1069,7 +1356,7 @@ func (b *builder) planRealizationsAndFinalizeBuildResult(ctx context.Context, co
 
 	switch {
 	case p.isAvailableLocally():
-		outputs := buildResultOutputsFromPlanner(state, p)
+		outputs := b.buildResultOutputsFromPlanner(state, p)
 		if err := setBuildResultOutputs(conn, state.buildResultID, outputs); err != nil {
 			return nil, err
 		}
@@ -1105,14 +1392,15 @@ func (b *builder) planRealizationsAndFinalizeBuildResult(ctx context.Context, co
 // Callers can use [isCopyFromFallbackError] to determine whether any error returned from this function
 // indicates a failure to copy the absent store objects from the fallback store.
 func (b *builder) copyFromFallbackAndFinalizeBuildResult(ctx context.Context, conn *sqlite.Conn, state *derivationBuildState, p *realizationPlanner) error {
-	err := b.server.copyFromFallback(ctx, conn, func(yield func(pathAndEquivalenceClass) bool) {
+	imported, err := b.server.copyFromFallback(ctx, conn, func(yield func(pathAndEquivalenceClass) bool) {
 		for eqClass := range p.absent.All() {
 			r := p.planned[eqClass] // Always present: p.absent is a set of keys in p.planned.
 			if !yield(pathAndEquivalenceClass{r.path, eqClass}) {
 				return
 			}
 		}
-	})
+	}, b.offline)
+	b.substituted.AddSeq(imported.All())
 	if err != nil {
 		return err
 	}
@@ -1124,7 +1412,7 @@ func (b *builder) copyFromFallbackAndFinalizeBuildResult(ctx context.Context, co
 	}
 	defer endFn(&err)
 
-	outputs := buildResultOutputsFromPlanner(state, p)
+	outputs := b.buildResultOutputsFromPlanner(state, p)
 	if err := setBuildResultOutputs(conn, state.buildResultID, outputs); err != nil {
 		return err
 	}
@@ -1136,31 +1424,58 @@ func (b *builder) copyFromFallbackAndFinalizeBuildResult(ctx context.Context, co
 	})
 }
 
-func buildResultOutputsFromPlanner(state *derivationBuildState, p *realizationPlanner) iter.Seq2[string, zbstore.Path] {
-	return func(yield func(string, zbstore.Path) bool) {
+func (b *builder) buildResultOutputsFromPlanner(state *derivationBuildState, p *realizationPlanner) iter.Seq2[string, buildResultOutput] {
+	return func(yield func(string, buildResultOutput) bool) {
 		for outputName := range state.outputNames.All() {
 			eqClass := equivalenceClass{
 				drvHashKey: state.derivationHashKey,
 				outputName: outputName,
 			}
 			r, _ := p.get(eqClass)
-			if !yield(outputName.Value(), r.path) {
+			output := buildResultOutput{
+				path:       r.path,
+				provenance: b.provenance(r.path),
+			}
+			if !yield(outputName.Value(), output) {
 				return
 			}
 		}
 	}
 }
 
-func (b *builder) fetchRealizationsFromFallback(ctx context.Context, drvHash nix.Hash) zbstore.RealizationMap {
+func (b *builder) fetchRealizationsFromFallback(ctx context.Context, conn *sqlite.Conn, drvHash nix.Hash) zbstore.RealizationMap {
 	if b.reusePolicy.IsZero() {
 		// If our reuse policy won't permit any realizations, there's no point.
 		log.Debugf(ctx, "Skipping fallback store for %v (build does not allow reuse)", drvHash)
 		return zbstore.RealizationMap{DerivationHash: drvHash}
 	}
+	if b.offline {
+		log.Debugf(ctx, "Skipping fallback store for %v (build is offline)", drvHash)
+		return zbstore.RealizationMap{DerivationHash: drvHash}
+	}
+	missTTL := b.server.substituterMissTTL
+	if missTTL > 0 {
+		missed, err := hasSubstituterMiss(conn, b.server.fallbackName, drvHash, time.Now().Add(-missTTL))
+		if err != nil {
+			log.Warnf(ctx, "%v", err)
+		} else if missed {
+			log.Debugf(ctx, "Skipping fallback store for %v (no realizations found within the last %v)", drvHash, missTTL)
+			return zbstore.RealizationMap{DerivationHash: drvHash}
+		}
+	}
 	log.Debugf(ctx, "Fetching realizations for %v from fallback store...", drvHash)
 	realizations, err := b.server.fallback.FetchRealizations(ctx, drvHash)
-	if err != nil {
+	switch {
+	case err != nil:
 		log.Warnf(ctx, "Failed to fetch realizations: %v", err)
+	case missTTL > 0 && realizations.IsEmpty():
+		if err := recordSubstituterMiss(conn, b.server.fallbackName, drvHash, time.Now()); err != nil {
+			log.Warnf(ctx, "%v", err)
+		}
+	case missTTL > 0:
+		if err := deleteSubstituterMiss(conn, b.server.fallbackName, drvHash); err != nil {
+			log.Warnf(ctx, "%v", err)
+		}
 	}
 	if log.IsEnabled(log.Debug) {
 		for outputName, realizationList := range realizations.Realizations {
@@ -1250,10 +1565,24 @@ type builderInvocation struct {
 
 	// realStoreDir is the directory where the store is located in the local filesystem.
 	realStoreDir string
+	// caseInsensitive is true if realStoreDir is on a case-insensitive filesystem.
+	caseInsensitive bool
 	// buildDir is the temporary directory created for this build.
 	buildDir string
-	// logWriter is where all builder output should be sent.
-	logWriter io.Writer
+	// stdout and stderr are where the builder's standard output and standard error
+	// should be sent, respectively.
+	stdout io.Writer
+	stderr io.Writer
+	// trace is where runners that execute subprocesses
+	// should record the programs that the builder executes
+	// using [runTracedCommand].
+	// If nil, then the builder's programs are not recorded.
+	trace *os.File
+	// openedObjects is the set that runners that execute subprocesses
+	// should add the store objects that the builder opens to
+	// using [runTracedCommand].
+	// If nil, then the builder's opened files are not recorded.
+	openedObjects sets.Set[zbstore.Path]
 	// lookup returns the store path for the given derivation output.
 	// lookup should return paths for the inputs to the derivation the runner is building
 	// at least.
@@ -1271,6 +1600,18 @@ type builderInvocation struct {
 	// to paths on the host machine.
 	// For sandboxed runners, these paths will be made available inside the sandbox.
 	sandboxPaths map[string]string
+	// determinism is the set of measures the runner should take
+	// to reduce nondeterminism in the builder.
+	// If nil, then the builder's environment is not altered.
+	determinism *DeterminismOptions
+	// fetchTransport is used by builtin builders
+	// to send network requests.
+	// If nil, then [http.DefaultTransport] is used.
+	fetchTransport http.RoundTripper
+	// fetchCredentials is used by builtin builders
+	// to authenticate network requests.
+	// It may be nil.
+	fetchCredentials credentials.Provider
 }
 
 // builderLogInterval is the maximum time between flushes of the builder log.
@@ -1302,10 +1643,22 @@ func (b *builder) runBuilder(ctx context.Context, conn *sqlite.Conn, drvPath zbs
 	if err != nil {
 		return nil, fmt.Errorf("build %s: %v", drvPath, err)
 	}
+	removeBuildDir := osutil.ForceRemoveAll
+	// A build directory kept after a failure must outlive the build,
+	// so it is not backed by a tmpfs that would need to stay mounted.
+	keepBuildDir := keepFailed && b.server.allowKeepFailed
+	if b.server.buildTmpfsSize > 0 && drv.Env[buildTmpfsVar] != "0" && !keepBuildDir {
+		if err := mountBuildTmpfs(buildDir, b.server.buildTmpfsSize); err != nil {
+			log.Debugf(ctx, "Building %s on disk: %v", drvPath, err)
+		} else {
+			log.Debugf(ctx, "Mounted tmpfs at %s for %s", buildDir, drvPath)
+			removeBuildDir = osutil.UnmountAndRemoveAll
+		}
+	}
 	startedRun := false
 	defer func() {
 		if err != nil && startedRun && keepFailed {
-			if b.server.allowKeepFailed {
+			if keepBuildDir {
 				log.Infof(ctx, "Build of %s failed and user requested build directory %s be kept", drvPath, buildDir)
 				if runtime.GOOS != "windows" {
 					if err := os.Chmod(buildDir, 0o755); err != nil {
@@ -1316,7 +1669,7 @@ func (b *builder) runBuilder(ctx context.Context, conn *sqlite.Conn, drvPath zbs
 			}
 			log.Debugf(ctx, "Build of %s failed and user requested build directory be kept, but server policy is to discard.", drvPath)
 		}
-		if err := os.RemoveAll(buildDir); err != nil {
+		if err := removeBuildDir(buildDir); err != nil {
 			log.Warnf(ctx, "Failed to clean up %s: %v", buildDir, err)
 		}
 	}()
@@ -1325,7 +1678,7 @@ func (b *builder) runBuilder(ctx context.Context, conn *sqlite.Conn, drvPath zbs
 			return nil, fmt.Errorf("build %s: %v", drvPath, err)
 		}
 	}
-	logFile, err := createBuilderLog(b.server.logDir, b.id, drvPath)
+	logFile, plainLogFile, err := createBuilderLog(b.server.logDir, b.id, drvPath)
 	if err != nil {
 		return nil, fmt.Errorf("build %s: %v", drvPath, err)
 	}
@@ -1333,40 +1686,87 @@ func (b *builder) runBuilder(ctx context.Context, conn *sqlite.Conn, drvPath zbs
 		if err := logFile.Close(); err != nil {
 			log.Warnf(ctx, "Closing build log for %s: %v", drvPath, err)
 		}
+		if err := plainLogFile.Close(); err != nil {
+			log.Warnf(ctx, "Closing build log for %s: %v", drvPath, err)
+		}
 	}()
+	var traceFile *os.File
+	if b.traceExec {
+		traceFile, err = createBuilderTrace(b.server.logDir, b.id, drvPath)
+		if err != nil {
+			return nil, fmt.Errorf("build %s: %v", drvPath, err)
+		}
+		defer func() {
+			if err := traceFile.Close(); err != nil {
+				log.Warnf(ctx, "Closing exec trace for %s: %v", drvPath, err)
+			}
+		}()
+	}
 
 	r := newReplacer(xiter.Chain2(
 		outputPathRewrites(outPaths),
 		maps.All(inputRewrites),
 	))
 	expandedDrv := drv.ReplaceStrings(r)
+	sandboxPaths := filterSandboxPaths(b.server.sandboxPaths, drv.Env[buildSystemDepsVar])
+	determinism, err := b.determinism(drv)
+	if err != nil {
+		return nil, fmt.Errorf("build %s: %v", drvPath, err)
+	}
+	if lib := determinism.fakeTimeLibrary(); lib != "" {
+		if sandboxPaths == nil {
+			sandboxPaths = make(map[string]string)
+		}
+		sandboxPaths[lib] = lib
+	}
+
+	var openedObjects sets.Set[zbstore.Path]
+	if b.traceInputs && drv.System != builtinSystem {
+		openedObjects = make(sets.Set[zbstore.Path])
+	}
 
 	log.Debugf(ctx, "Starting builder for %s...", drvPath)
-	if err := recordBuilderStart(conn, buildResultID, time.Now()); err != nil {
+	builderStartTime := time.Now()
+	if err := recordBuilderStart(conn, buildResultID, builderStartTime, buildUser); err != nil {
 		log.Warnf(ctx, "For %s: %v", drvPath, err)
 	}
-	startedRun = true
-	builderError := f(ctx, &builderInvocation{
-		derivation:     expandedDrv,
-		derivationPath: drvPath,
-		outputPaths:    outPaths,
-
-		realStoreDir: b.server.realDir,
-		buildDir:     buildDir,
-		logWriter:    logFile,
-		user:         buildUser,
-		sandboxPaths: filterSandboxPaths(b.server.sandboxPaths, drv.Env[buildSystemDepsVar]),
-		cores:        b.server.coresPerBuild,
-
-		lookup: b.lookup,
-		closure: func(path zbstore.Path, yield func(zbstore.Path) bool) error {
-			pe := pathAndEquivalenceClass{path: path}
-			return closurePaths(conn, pe, func(pe pathAndEquivalenceClass) bool {
-				return yield(pe.path)
-			})
-		},
-	})
+	logWriter := newBuilderLogWriter(logFile, plainLogFile, builderStartTime)
+	builderError := b.server.runPreBuildHook(ctx, drvPath, logWriter)
+	if builderError == nil {
+		startedRun = true
+		builderError = f(ctx, &builderInvocation{
+			derivation:     expandedDrv,
+			derivationPath: drvPath,
+			outputPaths:    outPaths,
+
+			realStoreDir:    b.server.realDir,
+			caseInsensitive: b.server.caseInsensitive(),
+			buildDir:        buildDir,
+			stdout:          logWriter.Stream(zbstorerpc.LogStreamStdout),
+			stderr:          logWriter.Stream(zbstorerpc.LogStreamStderr),
+			trace:           traceFile,
+			openedObjects:   openedObjects,
+			user:            buildUser,
+			sandboxPaths:    sandboxPaths,
+			cores:           b.server.coresPerBuild,
+			determinism:     determinism,
+
+			fetchTransport:   b.server.fetchTransport,
+			fetchCredentials: b.server.fetchCredentials,
+
+			lookup: b.lookup,
+			closure: func(path zbstore.Path, yield func(zbstore.Path) bool) error {
+				pe := pathAndEquivalenceClass{path: path}
+				return closurePaths(conn, pe, func(pe pathAndEquivalenceClass) bool {
+					return yield(pe.path)
+				})
+			},
+		})
+	}
 	builderEndTime := time.Now()
+	if err := logWriter.Flush(); err != nil {
+		log.Warnf(ctx, "Writing build log for %s: %v", drvPath, err)
+	}
 
 	if builderError == nil {
 		// Verify that builder produced all outputs.
@@ -1381,6 +1781,32 @@ func (b *builder) runBuilder(ctx context.Context, conn *sqlite.Conn, drvPath zbs
 			}
 		}
 	}
+	if builderError == nil {
+		realOutPaths := make(map[string]string, len(outPaths))
+		for outputName, outputPath := range outPaths {
+			realOutPaths[outputName] = b.server.realPath(outputPath)
+		}
+		builderError = b.server.outputQuota.check(realOutPaths)
+	}
+
+	if openedObjects != nil && startedRun {
+		usage := derivationInputUsage(drv, inputRewrites, openedObjects)
+		if err := setBuildResultInputs(conn, buildResultID, usage); err != nil {
+			log.Warnf(ctx, "For %s: %v", drvPath, err)
+		}
+		if builderError == nil && len(usage.Unused) > 0 {
+			var buf []byte
+			buf = append(buf, "*** Builder did not open these inputs (they may be unnecessary):\n"...)
+			for _, p := range usage.Unused {
+				buf = append(buf, "  "...)
+				buf = append(buf, p...)
+				buf = append(buf, "\n"...)
+			}
+			if _, err := logWriter.Write(buf); err != nil {
+				log.Debugf(ctx, "While writing unused inputs: %v", err)
+			}
+		}
+	}
 
 	if builderError != nil {
 		log.Debugf(ctx, "Builder for %s has failed: %v", drvPath, builderError)
@@ -1397,7 +1823,7 @@ func (b *builder) runBuilder(ctx context.Context, conn *sqlite.Conn, drvPath zbs
 			buf = append(buf, buildDir...)
 			buf = append(buf, "\n"...)
 		}
-		if _, err := logFile.Write(buf); err != nil {
+		if _, err := logWriter.Write(buf); err != nil {
 			log.Debugf(ctx, "While writing failed build directory info: %v", err)
 		}
 	}
@@ -1405,9 +1831,12 @@ func (b *builder) runBuilder(ctx context.Context, conn *sqlite.Conn, drvPath zbs
 	if err := recordBuilderEnd(conn, buildResultID, builderEndTime); err != nil {
 		log.Warnf(ctx, "For %s: %v", drvPath, err)
 	}
+	if err := setBuildResultPhases(conn, buildResultID, logWriter.Phases(builderEndTime)); err != nil {
+		log.Warnf(ctx, "For %s: %v", drvPath, err)
+	}
 	if builderError != nil {
 		for outName, outPath := range outPaths {
-			if err := os.RemoveAll(string(outPath)); err != nil {
+			if err := osutil.ForceRemoveAll(string(outPath)); err != nil {
 				ref := zbstore.OutputReference{
 					DrvPath:    drvPath,
 					OutputName: outName,
@@ -1422,6 +1851,13 @@ func (b *builder) runBuilder(ctx context.Context, conn *sqlite.Conn, drvPath zbs
 	return outPaths, nil
 }
 
+// determinism returns the measures to take to reduce nondeterminism
+// when running the builder for drv,
+// or nil if the builder's environment should not be altered.
+func (b *builder) determinism(drv *zbstore.Derivation) (*DeterminismOptions, error) {
+	return b.server.determinism.forDerivation(drv.Env[deterministicVar])
+}
+
 // runSubprocess runs a builder by running a subprocess.
 // It satisfies the [runnerFunc] signature.
 func runSubprocess(ctx context.Context, invocation *builderInvocation) error {
@@ -1432,22 +1868,50 @@ func runSubprocess(ctx context.Context, invocation *builderInvocation) error {
 	c := exec.CommandContext(ctx, invocation.derivation.Builder, invocation.derivation.Args...)
 	setCancelFunc(c)
 	env := maps.Clone(invocation.derivation.Env)
+	invocation.determinism.fillEnv(env)
 	fillBaseEnv(env, invocation.derivation.Dir, invocation.buildDir, invocation.cores)
 	for k, v := range xmaps.Sorted(env) {
 		c.Env = append(c.Env, k+"="+v)
 	}
 	c.Dir = invocation.buildDir
-	c.Stdout = invocation.logWriter
-	c.Stderr = invocation.logWriter
+	c.Stdout = invocation.stdout
+	c.Stderr = invocation.stderr
 	c.SysProcAttr = sysProcAttrForUser(invocation.user)
 
-	if err := c.Run(); err != nil {
+	if err := invocation.run(c); err != nil {
 		return builderFailure{err}
 	}
 
 	return nil
 }
 
+// run starts c and waits for it to complete,
+// tracing it if invocation.trace or invocation.openedObjects is set.
+func (invocation *builderInvocation) run(c *exec.Cmd) error {
+	opts := invocation.traceOptions()
+	if opts == nil {
+		return runCommand(c, invocation.determinism.umask())
+	}
+	return runTracedCommand(c, invocation.determinism.umask(), opts)
+}
+
+// traceOptions returns the options to pass to [runTracedCommand]
+// or nil if the builder should not be traced.
+func (invocation *builderInvocation) traceOptions() *traceOptions {
+	if invocation.trace == nil && invocation.openedObjects == nil {
+		return nil
+	}
+	opts := new(traceOptions)
+	if invocation.trace != nil {
+		// Avoid storing a nil *os.File in the interface.
+		opts.exec = invocation.trace
+	}
+	if invocation.openedObjects != nil {
+		opts.openFile = storeObjectRecorder(invocation.derivation.Dir, invocation.openedObjects)
+	}
+	return opts
+}
+
 // outputPathRewrites returns an iterator of mappings of output placeholders
 // to store paths in outputMap.
 func outputPathRewrites(outputMap map[string]zbstore.Path) iter.Seq2[string, zbstore.Path] {
@@ -1477,6 +1941,28 @@ func derivationInputRewrites(drv *zbstore.Derivation, realization func(ref zbsto
 	return result, nil
 }
 
+// derivationInputUsage sorts the inputs of drv
+// into those that are in opened and those that are not.
+// inputRewrites is the result of [derivationInputRewrites] for drv.
+func derivationInputUsage(drv *zbstore.Derivation, inputRewrites map[string]zbstore.Path, opened sets.Set[zbstore.Path]) *zbstorerpc.InputUsage {
+	inputs := drv.InputSources.Clone()
+	for _, p := range inputRewrites {
+		inputs.Add(p)
+	}
+	usage := &zbstorerpc.InputUsage{
+		Used:   []zbstore.Path{},
+		Unused: []zbstore.Path{},
+	}
+	for p := range inputs.Values() {
+		if opened.Has(p) {
+			usage.Used = append(usage.Used, p)
+		} else {
+			usage.Unused = append(usage.Unused, p)
+		}
+	}
+	return usage
+}
+
 // hasPlaceholders reports whether s contains any placeholders
 // that would be substituted when evaluated for drv.
 func hasPlaceholders(drv *zbstore.Derivation, s string) bool {
@@ -1556,7 +2042,7 @@ func (b *builder) postprocess(ctx context.Context, conn *sqlite.Conn, output zbs
 			return nil, fmt.Errorf("post-process %v: unexpected write lock", output)
 		}
 		// outputType has presumably been validated with [validateOutputs].
-		info, err = b.postprocessFloatingOutput(ctx, conn, buildPath, inputs)
+		info, err = b.postprocessFloatingOutput(ctx, conn, buildPath, outputType, inputs)
 	}
 	return info, err
 }
@@ -1611,10 +2097,10 @@ func (b *builder) postprocessFixedOutput(ctx context.Context, conn *sqlite.Conn,
 	return info, nil
 }
 
-func (b *builder) postprocessFloatingOutput(ctx context.Context, conn *sqlite.Conn, buildPath zbstore.Path, inputs *sets.Sorted[zbstore.Path]) (*ObjectInfo, error) {
+func (b *builder) postprocessFloatingOutput(ctx context.Context, conn *sqlite.Conn, buildPath zbstore.Path, outputType *zbstore.DerivationOutputType, inputs *sets.Sorted[zbstore.Path]) (*ObjectInfo, error) {
 	log.Debugf(ctx, "Processing floating output %s...", buildPath)
 	realBuildPath := b.server.realPath(buildPath)
-	scan, err := scanFloatingOutput(ctx, realBuildPath, buildPath.Digest(), inputs, b.server.caCreateTemp)
+	scan, err := scanFloatingOutput(ctx, realBuildPath, buildPath.Digest(), outputType, inputs, b.server.caCreateTemp)
 	if err != nil {
 		return nil, fmt.Errorf("post-process %s: %v", buildPath, err)
 	}
@@ -1647,7 +2133,7 @@ func (b *builder) postprocessFloatingOutput(ctx context.Context, conn *sqlite.Co
 		return nil, fmt.Errorf("post-process %s: %v", buildPath, err)
 	} else if err == nil {
 		log.Debugf(ctx, "%s is the same output as %s (reusing)", buildPath, finalPath)
-		if err := os.RemoveAll(realBuildPath); err != nil {
+		if err := osutil.ForceRemoveAll(realBuildPath); err != nil {
 			log.Warnf(ctx, "Cleanup failure: %v", err)
 		}
 		return info, nil
@@ -1693,9 +2179,10 @@ type outputScanResults struct {
 
 // scanFloatingOutput gathers information about a newly built filesystem object.
 // The digest is used to detect self references.
+// outputType determines how the object is content-addressed.
 // closure is the transitive closure of store objects the derivation depends on,
 // which form the superset of all non-self-references that the scan can detect.
-func scanFloatingOutput(ctx context.Context, path string, digest string, closure *sets.Sorted[zbstore.Path], createTemp bytebuffer.Creator) (*outputScanResults, error) {
+func scanFloatingOutput(ctx context.Context, path string, digest string, outputType *zbstore.DerivationOutputType, closure *sets.Sorted[zbstore.Path], createTemp bytebuffer.Creator) (*outputScanResults, error) {
 	log.Debugf(ctx, "Scanning for references in %s. Possible: %s", path, closure)
 	wc := new(xio.WriteCounter)
 	h := nix.NewHasher(nix.SHA256)
@@ -1721,11 +2208,19 @@ func scanFloatingOutput(ctx context.Context, path string, digest string, closure
 		<-done
 	}()
 
-	ca, analysis, err := zbstore.SourceSHA256ContentAddress(pr, &zbstore.ContentAddressOptions{
-		Digest:     digest,
-		CreateTemp: createTemp,
-		Log:        func(msg string) { log.Debugf(ctx, "%s", msg) },
-	})
+	var ca zbstore.ContentAddress
+	var analysis *zbstore.SelfReferenceAnalysis
+	var err error
+	if outputType.IsRecursiveFile() {
+		ca, analysis, err = zbstore.SourceSHA256ContentAddress(pr, &zbstore.ContentAddressOptions{
+			Digest:     digest,
+			CreateTemp: createTemp,
+			Log:        func(msg string) { log.Debugf(ctx, "%s", msg) },
+		})
+	} else {
+		ca, err = singleFileContentAddress(pr, digest, outputType)
+		analysis = new(zbstore.SelfReferenceAnalysis)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -1734,18 +2229,25 @@ func scanFloatingOutput(ctx context.Context, path string, digest string, closure
 		Self: analysis.HasSelfReferences(),
 	}
 	digestsFound := refFinder.Found()
-	for _, digest := range digestsFound.All() {
+	if digestsFound.Len() > 0 {
 		// Since all store paths have the same prefix followed by digest,
-		// we can use binary search on a sorted set of store paths to find the corresponding digest.
-		i, ok := sort.Find(closure.Len(), func(i int) int {
-			return strings.Compare(digest, closure.At(i).Digest())
-		})
-		if !ok {
-			return nil, fmt.Errorf("scan internal error: could not find digest %q in inputs", digest)
+		// we can use a prefix search on a sorted set of store paths to find the corresponding digest.
+		dir := closure.At(0).Dir()
+		for _, digest := range digestsFound.All() {
+			found := false
+			for _, input := range sets.WithPrefix(closure, dir.Join(digest+"-")) {
+				refs.Others.Add(input)
+				found = true
+			}
+			if !found {
+				return nil, fmt.Errorf("scan internal error: could not find digest %q in inputs", digest)
+			}
 		}
-		refs.Others.Add(closure.At(i))
 	}
 	log.Debugf(ctx, "Found references in %s (self=%t): %s", path, refs.Self, &refs.Others)
+	if !outputType.IsRecursiveFile() && !outputType.IsText() && refs.Others.Len() > 0 {
+		return nil, fmt.Errorf("flat file output cannot reference other store objects (found %s)", &refs.Others)
+	}
 
 	result := &outputScanResults{
 		ca:       ca,
@@ -1759,6 +2261,47 @@ func scanFloatingOutput(ctx context.Context, path string, digest string, closure
 	return result, nil
 }
 
+// singleFileContentAddress computes the flat file or text content address
+// of a NAR containing a single non-executable file.
+// Such objects cannot reference themselves,
+// so singleFileContentAddress returns an error if the file contains digest.
+func singleFileContentAddress(narContent io.Reader, digest string, outputType *zbstore.DerivationOutputType) (zbstore.ContentAddress, error) {
+	hashType, ok := outputType.HashType()
+	if !ok {
+		return zbstore.ContentAddress{}, fmt.Errorf("output type has no hash algorithm")
+	}
+	nr := nar.NewReader(narContent)
+	hdr, err := nr.Next()
+	if err != nil {
+		return zbstore.ContentAddress{}, err
+	}
+	if !hdr.Mode.IsRegular() {
+		return zbstore.ContentAddress{}, fmt.Errorf("output must be a single file")
+	}
+	if hdr.Mode&0o111 != 0 {
+		return zbstore.ContentAddress{}, fmt.Errorf("output must not be executable")
+	}
+	h := nix.NewHasher(hashType)
+	selfFinder := detect.NewRefFinder(func(yield func(string) bool) {
+		yield(digest)
+	})
+	if _, err := io.Copy(io.MultiWriter(h, selfFinder), nr); err != nil {
+		return zbstore.ContentAddress{}, err
+	}
+	if _, err := nr.Next(); err == nil {
+		return zbstore.ContentAddress{}, fmt.Errorf("output must be a single file")
+	} else if err != io.EOF {
+		return zbstore.ContentAddress{}, err
+	}
+	if selfFinder.Found().Len() > 0 {
+		return zbstore.ContentAddress{}, fmt.Errorf("output must not reference itself")
+	}
+	if outputType.IsText() {
+		return nix.TextContentAddress(h.SumHash()), nil
+	}
+	return nix.FlatFileContentAddress(h.SumHash()), nil
+}
+
 // finalizeFloatingOutput moves a store object on the local filesystem to its final location,
 // rewriting any self references as needed.
 // The last path element of each path must be a valid store path name,
@@ -1776,21 +2319,28 @@ func finalizeFloatingOutput(dir zbstore.Directory, buildPath, finalPath string,
 	if fakeBuildPath.Name() != fakeFinalPath.Name() {
 		return fmt.Errorf("move %s to %s: object names do not match", buildPath, finalPath)
 	}
+	if err := rewriteSelfReferences(buildPath, fakeFinalPath.Digest(), analysis); err != nil {
+		return fmt.Errorf("move %s to %s: %v", buildPath, finalPath, err)
+	}
+	return os.Rename(buildPath, finalPath)
+}
 
+// rewriteSelfReferences replaces the self references identified by analysis
+// in the filesystem object at path with newDigest.
+func rewriteSelfReferences(path string, newDigest string, analysis *zbstore.SelfReferenceAnalysis) error {
 	for i := range analysis.Paths {
 		hdr := &analysis.Paths[i]
 		err := rewriteAtPath(
-			filepath.Join(buildPath, filepath.FromSlash(hdr.Path)),
+			filepath.Join(path, filepath.FromSlash(hdr.Path)),
 			hdr.ContentOffset,
-			fakeFinalPath.Digest(),
+			newDigest,
 			analysis.RewritesInRange(hdr.ContentOffset, hdr.ContentOffset+hdr.Size),
 		)
 		if err != nil {
-			return fmt.Errorf("move %s to %s: %v", buildPath, finalPath, err)
+			return err
 		}
 	}
-
-	return os.Rename(buildPath, finalPath)
+	return nil
 }
 
 func rewriteAtPath(path string, baseOffset int64, newDigest string, rewriters []zbstore.Rewriter) error {
@@ -1845,7 +2395,8 @@ func rewriteAtPath(path string, baseOffset int64, newDigest string, rewriters []
 // recordRealizations calls [recordRealizations] and [recordBuildOutputs] in a transaction
 // and on success, saves the realizations into b.realizations.
 // The outputs must exist in the store.
-func (b *builder) recordRealizations(ctx context.Context, conn *sqlite.Conn, buildResultID int64, outputs zbstore.RealizationMap) (err error) {
+// built indicates whether the outputs were produced by running the derivation's builder.
+func (b *builder) recordRealizations(ctx context.Context, conn *sqlite.Conn, buildResultID int64, outputs zbstore.RealizationMap, built bool) (err error) {
 	if outputs.IsEmpty() {
 		return nil
 	}
@@ -1858,7 +2409,7 @@ func (b *builder) recordRealizations(ctx context.Context, conn *sqlite.Conn, bui
 		log.Debugf(ctx, "Recording realizations for %v: %s", outputs.DerivationHash, formatOutputPaths(outputPaths))
 	}
 
-	if b.server.upload != nil {
+	if b.server.upload != nil && !b.offline {
 		srv := b.server
 		srv.background.Go(func() {
 			srv.uploadRealizations(ctx, outputs)
@@ -1873,9 +2424,15 @@ func (b *builder) recordRealizations(ctx context.Context, conn *sqlite.Conn, bui
 	if err := recordRealizations(conn, outputs.All()); err != nil {
 		return err
 	}
-	buildOutputs := func(yield func(string, zbstore.Path) bool) {
+	buildOutputs := func(yield func(string, buildResultOutput) bool) {
 		for ref, r := range outputs.All() {
-			if !yield(ref.OutputName, r.OutputPath) {
+			output := buildResultOutput{path: r.OutputPath}
+			if built {
+				output.provenance = &zbstorerpc.OutputProvenance{Method: zbstorerpc.ProvenanceBuilt}
+			} else {
+				output.provenance = b.provenance(r.OutputPath)
+			}
+			if !yield(ref.OutputName, output) {
 				return
 			}
 		}
@@ -5,6 +5,7 @@ package backend
 
 import (
 	"context"
+	"errors"
 	"fmt"
 )
 
@@ -15,3 +16,15 @@ func defaultSystemCertFile() (string, error) {
 func runSandboxed(ctx context.Context, invocation *builderInvocation) error {
 	return fmt.Errorf("TODO(someday)")
 }
+
+func mountBuildTmpfs(dir string, size int64) error {
+	return fmt.Errorf("mount tmpfs at %s: %w", dir, errors.ErrUnsupported)
+}
+
+func canSandboxRootless() bool {
+	return false
+}
+
+func runSandboxHelper() int {
+	return 1
+}
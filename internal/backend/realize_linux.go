@@ -77,7 +77,13 @@ func runSandboxed(ctx context.Context, invocation *builderInvocation) error {
 	defer func() {
 		// The chroot is expected to contain bind mounts,
 		// so we carefully unmount as we remove the directory.
-		if err := osutil.UnmountAndRemoveAll(chrootDir); err != nil {
+		// The rootless sandbox's mounts are removed
+		// when the sandbox helper's mount namespace is destroyed.
+		removeAll := osutil.UnmountAndRemoveAll
+		if os.Geteuid() != 0 {
+			removeAll = os.RemoveAll
+		}
+		if err := removeAll(chrootDir); err != nil {
 			log.Errorf(ctx, "Failed to clean up: %v", err)
 		}
 	}()
@@ -94,9 +100,8 @@ func runSandboxed(ctx context.Context, invocation *builderInvocation) error {
 		builderUID: os.Geteuid(),
 		builderGID: os.Getegid(),
 
-		network: invocation.derivation.Outputs[zbstore.DefaultDerivationOutputName].IsFixed() ||
-			invocation.derivation.Env[networkVar] == "1",
-		caFile: caFile,
+		network: usesNetwork(invocation.derivation),
+		caFile:  caFile,
 		// TODO(maybe): This seems high to me.
 		shmSize: "50%",
 	}
@@ -104,28 +109,36 @@ func runSandboxed(ctx context.Context, invocation *builderInvocation) error {
 		opts.builderUID = invocation.user.UID
 		opts.builderGID = invocation.user.GID
 	}
-	if err := setupSandboxFilesystem(ctx, chrootDir, opts); err != nil {
-		return err
-	}
 
 	c := exec.CommandContext(ctx, invocation.derivation.Builder, invocation.derivation.Args...)
 	setCancelFunc(c)
 	env := maps.Clone(invocation.derivation.Env)
+	invocation.determinism.fillEnv(env)
 	fillBaseEnv(env, invocation.derivation.Dir, workDir, invocation.cores)
 	for k, v := range xmaps.Sorted(env) {
 		c.Env = append(c.Env, k+"="+v)
 	}
 	c.Dir = workDir
-	c.Stdout = invocation.logWriter
-	c.Stderr = invocation.logWriter
-	c.SysProcAttr = sysProcAttrForUser(invocation.user)
-	if c.SysProcAttr == nil {
-		c.SysProcAttr = new(syscall.SysProcAttr)
-	}
-	c.SysProcAttr.Chroot = chrootDir
+	c.Stdout = invocation.stdout
+	c.Stderr = invocation.stderr
 
-	if err := c.Run(); err != nil {
-		return builderFailure{err}
+	if os.Geteuid() != 0 {
+		// Without root privileges, mounts can only be created in a user namespace.
+		if err := runRootlessSandbox(ctx, c, chrootDir, opts, invocation); err != nil {
+			return err
+		}
+	} else {
+		if err := setupSandboxFilesystem(ctx, chrootDir, opts); err != nil {
+			return err
+		}
+		c.SysProcAttr = sysProcAttrForUser(invocation.user)
+		if c.SysProcAttr == nil {
+			c.SysProcAttr = new(syscall.SysProcAttr)
+		}
+		c.SysProcAttr.Chroot = chrootDir
+		if err := invocation.run(c); err != nil {
+			return builderFailure{err}
+		}
 	}
 
 	for outputName, outputPath := range invocation.outputPaths {
@@ -149,6 +162,20 @@ func runSandboxed(ctx context.Context, invocation *builderInvocation) error {
 	return nil
 }
 
+// mountBuildTmpfs mounts a tmpfs of at most size bytes at the directory dir.
+// The directory can be unmounted with [osutil.UnmountAndRemoveAll].
+func mountBuildTmpfs(dir string, size int64) error {
+	mountOpts := fmt.Sprintf("size=%d,mode=0700", size)
+	if err := unix.Mount("tmpfs", dir, "tmpfs", unix.MS_NOSUID|unix.MS_NODEV, mountOpts); err != nil {
+		return &os.PathError{
+			Op:   "mount tmpfs",
+			Path: dir,
+			Err:  err,
+		}
+	}
+	return nil
+}
+
 type linuxSandboxOptions struct {
 	storeDir     zbstore.Directory
 	realStoreDir string
@@ -161,6 +188,12 @@ type linuxSandboxOptions struct {
 
 	builderUID int
 	builderGID int
+	// rootless is true if the sandbox is being created
+	// inside an unprivileged user namespace (see [runRootlessSandbox]).
+	// The builder's IDs are not mapped in the namespace,
+	// so files are left owned by the namespace's root user,
+	// which is the builder's user on the host.
+	rootless bool
 
 	network bool
 	caFile  string
@@ -219,8 +252,10 @@ func setupSandboxFilesystem(ctx context.Context, dir string, opts *linuxSandboxO
 			}
 		}
 	}
-	if err := os.Chmod(etcDir, 0o555); err != nil {
-		return err
+	if !opts.rootless {
+		if err := os.Chmod(etcDir, 0o555); err != nil {
+			return err
+		}
 	}
 
 	devDir := filepath.Join(dir, "dev")
@@ -315,8 +350,10 @@ func setupSandboxFilesystem(ctx context.Context, dir string, opts *linuxSandboxO
 	if err := osutil.MkdirPerm(storeDir, 0o775|os.ModeSticky); err != nil {
 		return err
 	}
-	if err := os.Chown(storeDir, opts.builderUID, opts.builderGID); err != nil {
-		return err
+	if !opts.rootless {
+		if err := os.Chown(storeDir, opts.builderUID, opts.builderGID); err != nil {
+			return err
+		}
 	}
 	// Bind-mount input paths.
 	for input := range opts.inputs {
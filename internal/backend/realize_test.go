@@ -5,24 +5,32 @@ package backend_test
 
 import (
 	"bytes"
+	"context"
 	"crypto/ed25519"
 	"fmt"
+	"maps"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"reflect"
 	"runtime"
 	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"testing/synctest"
 	"time"
 
+	jsonv2 "github.com/go-json-experiment/json"
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 	. "zb.256lights.llc/pkg/internal/backend"
 	"zb.256lights.llc/pkg/internal/backendtest"
+	"zb.256lights.llc/pkg/internal/credentials"
 	"zb.256lights.llc/pkg/internal/fileurl"
 	"zb.256lights.llc/pkg/internal/jsonrpc"
 	"zb.256lights.llc/pkg/internal/storetest"
@@ -31,6 +39,7 @@ import (
 	"zb.256lights.llc/pkg/internal/zbstorehttp"
 	"zb.256lights.llc/pkg/internal/zbstorerpc"
 	"zb.256lights.llc/pkg/sets"
+	"zb.256lights.llc/pkg/zbbuiltin"
 	"zb.256lights.llc/pkg/zbstore"
 	"zombiezen.com/go/log/testlog"
 	"zombiezen.com/go/nix"
@@ -125,6 +134,9 @@ func TestRealizeSingleDerivation(t *testing.T) {
 		t.Fatal(err)
 	}
 	checkSingleFileOutput(t, drvPath, wantOutputPath, []byte(wantOutputContent), got)
+	checkOutputProvenance(t, got, drvPath, zbstore.DefaultDerivationOutputName, &zbstorerpc.OutputProvenance{
+		Method: zbstorerpc.ProvenanceBuilt,
+	})
 }
 
 func TestRealizeReuse(t *testing.T) {
@@ -222,9 +234,12 @@ func TestRealizeReuse(t *testing.T) {
 		t.Fatal(err)
 	}
 	checkSingleFileOutput(t, drvPath, wantOutputPath, []byte(wantOutputContent), got)
+	checkOutputProvenance(t, got, drvPath, zbstore.DefaultDerivationOutputName, &zbstorerpc.OutputProvenance{
+		Method: zbstorerpc.ProvenanceReused,
+	})
 }
 
-func TestRealizeDisableReuse(t *testing.T) {
+func TestRealizeSubstituteOnly(t *testing.T) {
 	ctx := testcontext.New(t)
 	dir := backendtest.NewStoreDirectory(t)
 
@@ -279,39 +294,64 @@ func TestRealizeDisableReuse(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	// Without an existing realization, the build must fail without running the builder.
 	realize1Response := new(zbstorerpc.RealizeResponse)
 	err = jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, realize1Response, &zbstorerpc.RealizeRequest{
-		DrvPaths: []zbstore.Path{drvPath},
+		DrvPaths:       []zbstore.Path{drvPath},
+		Reuse:          &zbstorerpc.ReusePolicy{All: true},
+		SubstituteOnly: true,
 	})
 	if err != nil {
 		t.Fatal("first RPC error:", err)
 	}
-	if _, err := backendtest.WaitForSuccessfulBuild(ctx, client, realize1Response.BuildID); err != nil {
-		gotLog, _ := backendtest.ReadLog(ctx, client, realize1Response.BuildID, drvPath)
-		t.Fatalf("first build failed: %v\nlog:\n%s", err, gotLog)
+	got, err := backendtest.WaitForBuild(ctx, client, realize1Response.BuildID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != zbstorerpc.BuildFail {
+		t.Errorf("first build status = %q; want %q", got.Status, zbstorerpc.BuildFail)
+	}
+	if result, err := got.ResultForPath(drvPath); err != nil {
+		t.Error(err)
+	} else {
+		if result.Status != zbstorerpc.BuildFail {
+			t.Errorf("first build result status = %q; want %q", result.Status, zbstorerpc.BuildFail)
+		}
+		if result.RebuildReason == nil {
+			t.Error("first build result has no rebuild reason")
+		}
+		if output, err := result.OutputForName(zbstore.DefaultDerivationOutputName); err == nil && output.Path.Valid {
+			t.Errorf("first build produced %s", output.Path.X)
+		}
 	}
 
 	realize2Response := new(zbstorerpc.RealizeResponse)
 	err = jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, realize2Response, &zbstorerpc.RealizeRequest{
 		DrvPaths: []zbstore.Path{drvPath},
-		Reuse:    nil,
+		Reuse:    &zbstorerpc.ReusePolicy{All: true},
 	})
 	if err != nil {
 		t.Fatal("second RPC error:", err)
 	}
-	got, err := backendtest.WaitForSuccessfulBuild(ctx, client, realize2Response.BuildID)
-	if err != nil {
-		t.Error("second build failed:", err)
+	if _, err := backendtest.WaitForSuccessfulBuild(ctx, client, realize2Response.BuildID); err != nil {
+		gotLog, _ := backendtest.ReadLog(ctx, client, realize2Response.BuildID, drvPath)
+		t.Fatalf("second build failed: %v\nlog:\n%s", err, gotLog)
 	}
 
-	gotLog, err := backendtest.ReadLog(ctx, client, realize2Response.BuildID, drvPath)
+	// Once realized, substitute-only builds can reuse the realization.
+	realize3Response := new(zbstorerpc.RealizeResponse)
+	err = jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, realize3Response, &zbstorerpc.RealizeRequest{
+		DrvPaths:       []zbstore.Path{drvPath},
+		Reuse:          &zbstorerpc.ReusePolicy{All: true},
+		SubstituteOnly: true,
+	})
 	if err != nil {
-		t.Error("accessing second build's logs:", err)
+		t.Fatal("third RPC error:", err)
 	}
-	if want := "catcat\n"; string(gotLog) != want {
-		t.Errorf("build log:\n%s\n(want %q)", gotLog, want)
+	got, err = backendtest.WaitForSuccessfulBuild(ctx, client, realize3Response.BuildID)
+	if err != nil {
+		t.Fatal("third build failed:", err)
 	}
-
 	const wantOutputContent = "Hello, World!\nHello, World!\n"
 	wantOutputPath, err := singleFileOutputPath(dir, wantOutputName, []byte(wantOutputContent), zbstore.References{})
 	if err != nil {
@@ -320,7 +360,7 @@ func TestRealizeDisableReuse(t *testing.T) {
 	checkSingleFileOutput(t, drvPath, wantOutputPath, []byte(wantOutputContent), got)
 }
 
-func TestRealizeMultiStep(t *testing.T) {
+func TestRealizeSubstituterMiss(t *testing.T) {
 	ctx := testcontext.New(t)
 	dir := backendtest.NewStoreDirectory(t)
 
@@ -334,7 +374,7 @@ func TestRealizeMultiStep(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	drv1Content := &zbstore.Derivation{
+	drvContent := &zbstore.Derivation{
 		Name:   "hello2.txt",
 		Dir:    dir,
 		System: system.Current().String(),
@@ -349,32 +389,8 @@ func TestRealizeMultiStep(t *testing.T) {
 			zbstore.DefaultDerivationOutputName: zbstore.RecursiveFileFloatingCAOutput(nix.SHA256),
 		},
 	}
-	drv1Content.Builder, drv1Content.Args = catcatBuilder()
-	drv1Path, _, err := storetest.ExportDerivation(exporter, drv1Content)
-	if err != nil {
-		t.Fatal(err)
-	}
-	const wantOutputName = "hello4.txt"
-	drv2Content := &zbstore.Derivation{
-		Name:   "hello4.txt",
-		Dir:    dir,
-		System: system.Current().String(),
-		Env: map[string]string{
-			"in": zbstore.UnknownCAOutputPlaceholder(zbstore.OutputReference{
-				DrvPath:    drv1Path,
-				OutputName: zbstore.DefaultDerivationOutputName,
-			}),
-			"out": zbstore.HashPlaceholder("out"),
-		},
-		InputDerivations: map[zbstore.Path]*sets.Sorted[string]{
-			drv1Path: sets.NewSorted(zbstore.DefaultDerivationOutputName),
-		},
-		Outputs: map[string]*zbstore.DerivationOutputType{
-			zbstore.DefaultDerivationOutputName: zbstore.RecursiveFileFloatingCAOutput(nix.SHA256),
-		},
-	}
-	drv2Content.Builder, drv2Content.Args = catcatBuilder()
-	drv2Path, _, err := storetest.ExportDerivation(exporter, drv2Content)
+	drvContent.Builder, drvContent.Args = catcatBuilder()
+	drvPath, _, err := storetest.ExportDerivation(exporter, drvContent)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -382,8 +398,13 @@ func TestRealizeMultiStep(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	fallback := new(countingFallback)
 	_, client, err := backendtest.NewServer(ctx, t, dir, &backendtest.Options{
 		TempDir: t.TempDir(),
+		Options: Options{
+			Fallback:           fallback,
+			SubstituterMissTTL: time.Hour,
+		},
 	})
 	if err != nil {
 		t.Fatal(err)
@@ -398,29 +419,66 @@ func TestRealizeMultiStep(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	realizeResponse := new(zbstorerpc.RealizeResponse)
-	err = jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, realizeResponse, &zbstorerpc.RealizeRequest{
-		DrvPaths: []zbstore.Path{drv2Path},
-	})
-	if err != nil {
-		t.Fatal(err)
+	substitute := func(t *testing.T) {
+		t.Helper()
+		realizeResponse := new(zbstorerpc.RealizeResponse)
+		err := jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, realizeResponse, &zbstorerpc.RealizeRequest{
+			DrvPaths:       []zbstore.Path{drvPath},
+			Reuse:          &zbstorerpc.ReusePolicy{All: true},
+			SubstituteOnly: true,
+		})
+		if err != nil {
+			t.Fatal("RPC error:", err)
+		}
+		got, err := backendtest.WaitForBuild(ctx, client, realizeResponse.BuildID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Status != zbstorerpc.BuildFail {
+			t.Errorf("build status = %q; want %q", got.Status, zbstorerpc.BuildFail)
+		}
 	}
-	got, err := backendtest.WaitForSuccessfulBuild(ctx, client, realizeResponse.BuildID)
-	if err != nil {
-		gotLog1, _ := backendtest.ReadLog(ctx, client, realizeResponse.BuildID, drv1Path)
-		gotLog2, _ := backendtest.ReadLog(ctx, client, realizeResponse.BuildID, drv2Path)
-		t.Fatalf("build failed: %v\ndrv1 log:\n%s\ndrv2 log:\n%s", err, gotLog1, gotLog2)
+
+	substitute(t)
+	firstFetches := fallback.fetches.Load()
+	if firstFetches == 0 {
+		t.Fatal("first build did not query fallback store")
 	}
 
-	wantOutputContent := strings.Repeat(inputContent, 4)
-	wantOutputPath, err := singleFileOutputPath(dir, wantOutputName, []byte(wantOutputContent), zbstore.References{})
+	// The miss is remembered, so the fallback store is not asked again.
+	substitute(t)
+	if got := fallback.fetches.Load(); got != firstFetches {
+		t.Errorf("second build queried fallback store %d times; want 0", got-firstFetches)
+	}
+
+	clearResponse := new(zbstorerpc.ClearSubstituterMissesResponse)
+	err = jsonrpc.Do(ctx, client, zbstorerpc.ClearSubstituterMissesMethod, clearResponse, &zbstorerpc.ClearSubstituterMissesRequest{})
 	if err != nil {
 		t.Fatal(err)
 	}
-	checkSingleFileOutput(t, drv2Path, wantOutputPath, []byte(wantOutputContent), got)
+	if clearResponse.Deleted != 1 {
+		t.Errorf("%s deleted %d misses; want 1", zbstorerpc.ClearSubstituterMissesMethod, clearResponse.Deleted)
+	}
+
+	substitute(t)
+	if got := fallback.fetches.Load(); got == firstFetches {
+		t.Error("build after clearing misses did not query fallback store")
+	}
 }
 
-func TestRealizeReferenceToDep(t *testing.T) {
+// countingFallback is an empty fallback store
+// that counts the number of realization lookups it receives.
+type countingFallback struct {
+	storetest.Store
+	fetches atomic.Int32
+}
+
+func (f *countingFallback) FetchRealizations(ctx context.Context, derivationHash nix.Hash) (zbstore.RealizationMap, error) {
+	f.fetches.Add(1)
+	return f.Store.FetchRealizations(ctx, derivationHash)
+}
+
+func TestRealizeCheck(t *testing.T) {
 	ctx := testcontext.New(t)
 	dir := backendtest.NewStoreDirectory(t)
 
@@ -434,9 +492,9 @@ func TestRealizeReferenceToDep(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	const drv1OutputName = "hello2.txt"
-	drv1Content := &zbstore.Derivation{
-		Name:   drv1OutputName,
+	const wantOutputName = "hello2.txt"
+	drvContent := &zbstore.Derivation{
+		Name:   wantOutputName,
 		Dir:    dir,
 		System: system.Current().String(),
 		Env: map[string]string{
@@ -450,44 +508,8 @@ func TestRealizeReferenceToDep(t *testing.T) {
 			zbstore.DefaultDerivationOutputName: zbstore.RecursiveFileFloatingCAOutput(nix.SHA256),
 		},
 	}
-	drv1Content.Builder, drv1Content.Args = catcatBuilder()
-	drv1Path, _, err := storetest.ExportDerivation(exporter, drv1Content)
-	if err != nil {
-		t.Fatal(err)
-	}
-	const wantOutputName = "hello-ref.txt"
-	drv2Content := &zbstore.Derivation{
-		Name:   wantOutputName,
-		Dir:    dir,
-		System: system.Current().String(),
-		Env: map[string]string{
-			"in": zbstore.UnknownCAOutputPlaceholder(zbstore.OutputReference{
-				DrvPath:    drv1Path,
-				OutputName: zbstore.DefaultDerivationOutputName,
-			}),
-			"out": zbstore.HashPlaceholder("out"),
-		},
-		InputDerivations: map[zbstore.Path]*sets.Sorted[string]{
-			drv1Path: sets.NewSorted(zbstore.DefaultDerivationOutputName),
-		},
-		Outputs: map[string]*zbstore.DerivationOutputType{
-			zbstore.DefaultDerivationOutputName: zbstore.RecursiveFileFloatingCAOutput(nix.SHA256),
-		},
-	}
-	if runtime.GOOS == "windows" {
-		drv2Content.Builder = powershellPath
-		drv2Content.Args = []string{
-			"-Command",
-			"(${env:in} + \"`n\")" + ` | Out-File -NoNewline -Encoding ascii -FilePath ${env:out}`,
-		}
-	} else {
-		drv2Content.Builder = shPath
-		drv2Content.Args = []string{
-			"-c",
-			`echo "$in" > "$out"`,
-		}
-	}
-	drv2Path, _, err := storetest.ExportDerivation(exporter, drv2Content)
+	drvContent.Builder, drvContent.Args = catcatBuilder()
+	drvPath, _, err := storetest.ExportDerivation(exporter, drvContent)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -511,98 +533,72 @@ func TestRealizeReferenceToDep(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	realizeResponse := new(zbstorerpc.RealizeResponse)
-	err = jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, realizeResponse, &zbstorerpc.RealizeRequest{
-		DrvPaths: []zbstore.Path{drv2Path},
+	realize1Response := new(zbstorerpc.RealizeResponse)
+	err = jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, realize1Response, &zbstorerpc.RealizeRequest{
+		DrvPaths: []zbstore.Path{drvPath},
+		Reuse:    &zbstorerpc.ReusePolicy{All: true},
 	})
 	if err != nil {
-		t.Fatal(err)
-	}
-	got, err := backendtest.WaitForSuccessfulBuild(ctx, client, realizeResponse.BuildID)
-	if err != nil {
-		gotLog1, _ := backendtest.ReadLog(ctx, client, realizeResponse.BuildID, drv1Path)
-		gotLog2, _ := backendtest.ReadLog(ctx, client, realizeResponse.BuildID, drv2Path)
-		t.Fatalf("build failed: %v\ndrv1 log:\n%s\ndrv2 log:\n%s", err, gotLog1, gotLog2)
+		t.Fatal("first RPC error:", err)
 	}
-
-	drv1OutputContent := strings.Repeat(inputContent, 2)
-	drv1OutputPath, err := singleFileOutputPath(dir, drv1OutputName, []byte(drv1OutputContent), zbstore.References{})
-	if err != nil {
-		t.Fatal(err)
+	if _, err := backendtest.WaitForSuccessfulBuild(ctx, client, realize1Response.BuildID); err != nil {
+		gotLog, _ := backendtest.ReadLog(ctx, client, realize1Response.BuildID, drvPath)
+		t.Fatalf("first build failed: %v\nlog:\n%s", err, gotLog)
 	}
 
-	wantOutputContent := append([]byte(drv1OutputPath), '\n')
-	wantOutputPath, err := singleFileOutputPath(dir, wantOutputName, wantOutputContent, zbstore.References{
-		Others: *sets.NewSorted(
-			drv1OutputPath,
-		),
+	realize2Response := new(zbstorerpc.RealizeResponse)
+	err = jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, realize2Response, &zbstorerpc.RealizeRequest{
+		DrvPaths: []zbstore.Path{drvPath},
+		Reuse:    &zbstorerpc.ReusePolicy{All: true},
+		Check:    true,
 	})
 	if err != nil {
-		t.Fatal(err)
+		t.Fatal("second RPC error:", err)
 	}
-	checkSingleFileOutput(t, drv2Path, wantOutputPath, wantOutputContent, got)
-
-	var info zbstorerpc.InfoResponse
-	err = jsonrpc.Do(ctx, client, zbstorerpc.InfoMethod, &info, &zbstorerpc.InfoRequest{
-		Path: wantOutputPath,
-	})
+	got, err := backendtest.WaitForSuccessfulBuild(ctx, client, realize2Response.BuildID)
 	if err != nil {
+		gotLog, _ := backendtest.ReadLog(ctx, client, realize2Response.BuildID, drvPath)
+		t.Fatalf("check build failed: %v\nlog:\n%s", err, gotLog)
+	}
+	if gotLog, err := backendtest.ReadLog(ctx, client, realize2Response.BuildID, drvPath); err != nil {
 		t.Error(err)
-	} else {
-		buf := new(bytes.Buffer)
-		if err := storetest.SingleFileNAR(buf, wantOutputContent); err != nil {
-			t.Fatal(err)
-		}
-		narData := buf.Bytes()
-		ca, _, err := zbstore.SourceSHA256ContentAddress(bytes.NewReader(narData), nil)
-		if err != nil {
-			t.Fatal(err)
-		}
+	} else if want := "catcat\n"; string(gotLog) != want {
+		t.Errorf("check build log:\n%s\n(want %q)", gotLog, want)
+	}
 
-		want := wantObjectInfo(info.Info, narData, ca, sets.NewSorted(
-			drv1OutputPath,
-		))
-		if diff := cmp.Diff(want, info.Info); diff != "" {
-			t.Errorf("info (-want +got):\n%s", diff)
-		}
+	const wantOutputContent = "Hello, World!\nHello, World!\n"
+	wantOutputPath, err := singleFileOutputPath(dir, wantOutputName, []byte(wantOutputContent), zbstore.References{})
+	if err != nil {
+		t.Fatal(err)
 	}
+	checkSingleFileOutput(t, drvPath, wantOutputPath, []byte(wantOutputContent), got)
 }
 
-func TestRealizeInputReference(t *testing.T) {
+func TestRealizeCheckNondeterministic(t *testing.T) {
 	ctx := testcontext.New(t)
 	dir := backendtest.NewStoreDirectory(t)
 
-	const inputContent = "Hello, World!\n"
 	exportBuffer := new(bytes.Buffer)
 	exporter := zbstore.NewExportWriter(exportBuffer)
-	inputFilePath, _, err := storetest.ExportSourceFile(exporter, []byte(inputContent), storetest.SourceExportOptions{
-		Name:      "hello.txt",
-		Directory: dir,
-	})
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	const wantOutputName = "hello-path.txt"
+	// Create a derivation that produces different output each time it runs.
 	drvContent := &zbstore.Derivation{
-		Name:   wantOutputName,
+		Name:   "counter.txt",
 		Dir:    dir,
 		System: system.Current().String(),
 		Env: map[string]string{
-			"in":  string(inputFilePath),
-			"out": zbstore.HashPlaceholder("out"),
+			"counter": filepath.Join(t.TempDir(), "counter.txt"),
+			"out":     zbstore.HashPlaceholder("out"),
 		},
-		InputSources: *sets.NewSorted(inputFilePath),
 		Outputs: map[string]*zbstore.DerivationOutputType{
 			zbstore.DefaultDerivationOutputName: zbstore.RecursiveFileFloatingCAOutput(nix.SHA256),
 		},
 	}
 	if runtime.GOOS == "windows" {
 		drvContent.Builder = powershellPath
-		drvContent.Args = []string{"-Command", "\"${env:in}`n\" | Out-File -NoNewline -Encoding ascii -FilePath ${env:out}"}
+		drvContent.Args = []string{"-Command", "Add-Content -Path ${env:counter} -Value x ; Copy-Item ${env:counter} ${env:out}"}
 	} else {
 		drvContent.Builder = shPath
-		drvContent.Args = []string{"-c", `echo "$in" > "$out"`}
+		drvContent.Args = []string{"-c", `echo x >> $counter ; while read line; do echo "$line"; done < $counter > $out`}
 	}
 	drvPath, _, err := storetest.ExportDerivation(exporter, drvContent)
 	if err != nil {
@@ -628,57 +624,125 @@ func TestRealizeInputReference(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	realizeResponse := new(zbstorerpc.RealizeResponse)
-	err = jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, realizeResponse, &zbstorerpc.RealizeRequest{
+	realize1Response := new(zbstorerpc.RealizeResponse)
+	err = jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, realize1Response, &zbstorerpc.RealizeRequest{
 		DrvPaths: []zbstore.Path{drvPath},
+		Reuse:    &zbstorerpc.ReusePolicy{All: true},
 	})
 	if err != nil {
-		t.Fatal("RPC error:", err)
+		t.Fatal("first RPC error:", err)
 	}
-	if realizeResponse.BuildID == "" {
-		t.Fatal("no build ID returned")
+	first, err := backendtest.WaitForSuccessfulBuild(ctx, client, realize1Response.BuildID)
+	if err != nil {
+		gotLog, _ := backendtest.ReadLog(ctx, client, realize1Response.BuildID, drvPath)
+		t.Fatalf("first build failed: %v\nlog:\n%s", err, gotLog)
 	}
-	got, err := backendtest.WaitForSuccessfulBuild(ctx, client, realizeResponse.BuildID)
+	firstResult, err := first.ResultForPath(drvPath)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	wantOutputContent := inputFilePath + "\n"
-	wantOutputPath, err := singleFileOutputPath(dir, wantOutputName, []byte(wantOutputContent), zbstore.References{
-		Others: *sets.NewSorted(inputFilePath),
+	realize2Response := new(zbstorerpc.RealizeResponse)
+	err = jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, realize2Response, &zbstorerpc.RealizeRequest{
+		DrvPaths: []zbstore.Path{drvPath},
+		Reuse:    &zbstorerpc.ReusePolicy{All: true},
+		Check:    true,
 	})
+	if err != nil {
+		t.Fatal("second RPC error:", err)
+	}
+	got, err := backendtest.WaitForBuild(ctx, client, realize2Response.BuildID)
 	if err != nil {
 		t.Fatal(err)
 	}
-	checkSingleFileOutput(t, drvPath, wantOutputPath, []byte(wantOutputContent), got)
-}
+	if got.Status != zbstorerpc.BuildFail {
+		t.Errorf("check build status = %q; want %q", got.Status, zbstorerpc.BuildFail)
+	}
+	gotResult, err := got.ResultForPath(drvPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(firstResult.Outputs, gotResult.Outputs, buildResultOption); diff != "" {
+		t.Errorf("check build outputs (-first +check):\n%s", diff)
+	}
 
-func TestRealizeSelfReference(t *testing.T) {
-	ctx := testcontext.New(t)
-	dir := backendtest.NewStoreDirectory(t)
+	if gotLog, err := backendtest.ReadLog(ctx, client, realize2Response.BuildID, drvPath); err != nil {
+		t.Error(err)
+	} else if want := "contents changed: ."; !bytes.Contains(gotLog, []byte(want)) {
+		t.Errorf("Log does not contain phrase %q. Full output:\n%s", want, gotLog)
+	}
+
+	// Ensure that the check didn't leave files in the store.
+	storeListing, err := os.ReadDir(string(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ent := range storeListing {
+		if strings.HasSuffix(ent.Name(), ".check") {
+			t.Errorf("check output %s left in store", ent.Name())
+		}
+	}
+}
+
+func TestRealizeReplaySchedule(t *testing.T) {
+	ctx := testcontext.New(t)
+	dir := backendtest.NewStoreDirectory(t)
 
 	exportBuffer := new(bytes.Buffer)
 	exporter := zbstore.NewExportWriter(exportBuffer)
-	const wantOutputName = "self.txt"
-	drvContent := &zbstore.Derivation{
-		Name:   wantOutputName,
+	inputFilePath, _, err := storetest.ExportSourceFile(exporter, []byte("Hello, World!\n"), storetest.SourceExportOptions{
+		Name:      "hello.txt",
+		Directory: dir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Create several independent derivations
+	// so that the order they are processed in is not fixed
+	// and a final derivation that depends on all of them and fails.
+	finalDrvContent := &zbstore.Derivation{
+		Name:   "final.txt",
 		Dir:    dir,
 		System: system.Current().String(),
 		Env: map[string]string{
 			"out": zbstore.HashPlaceholder("out"),
 		},
+		InputDerivations: make(map[zbstore.Path]*sets.Sorted[string]),
 		Outputs: map[string]*zbstore.DerivationOutputType{
 			zbstore.DefaultDerivationOutputName: zbstore.RecursiveFileFloatingCAOutput(nix.SHA256),
 		},
 	}
 	if runtime.GOOS == "windows" {
-		drvContent.Builder = powershellPath
-		drvContent.Args = []string{"-Command", "\"${env:out}`n\" | Out-File -NoNewline -Encoding ascii -FilePath ${env:out}"}
+		finalDrvContent.Builder = powershellPath
+		finalDrvContent.Args = []string{"-Command", "exit 1"}
 	} else {
-		drvContent.Builder = shPath
-		drvContent.Args = []string{"-c", `echo "$out" > "$out"`}
+		finalDrvContent.Builder = shPath
+		finalDrvContent.Args = []string{"-c", "exit 1"}
+	}
+	for i := range 5 {
+		drvContent := &zbstore.Derivation{
+			Name:   fmt.Sprintf("hello%d.txt", i),
+			Dir:    dir,
+			System: system.Current().String(),
+			Env: map[string]string{
+				"in":  string(inputFilePath),
+				"out": zbstore.HashPlaceholder("out"),
+			},
+			InputSources: *sets.NewSorted(
+				inputFilePath,
+			),
+			Outputs: map[string]*zbstore.DerivationOutputType{
+				zbstore.DefaultDerivationOutputName: zbstore.RecursiveFileFloatingCAOutput(nix.SHA256),
+			},
+		}
+		drvContent.Builder, drvContent.Args = catcatBuilder()
+		drvPath, _, err := storetest.ExportDerivation(exporter, drvContent)
+		if err != nil {
+			t.Fatal(err)
+		}
+		finalDrvContent.InputDerivations[drvPath] = sets.NewSorted(zbstore.DefaultDerivationOutputName)
 	}
-	drvPath, _, err := storetest.ExportDerivation(exporter, drvContent)
+	finalDrvPath, _, err := storetest.ExportDerivation(exporter, finalDrvContent)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -702,109 +766,121 @@ func TestRealizeSelfReference(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	realizeResponse := new(zbstorerpc.RealizeResponse)
-	err = jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, realizeResponse, &zbstorerpc.RealizeRequest{
-		DrvPaths: []zbstore.Path{drvPath},
+	// scheduledOrder returns the derivation paths of the build's results
+	// in the order that the build processed them.
+	scheduledOrder := func(build *zbstorerpc.Build) []zbstore.Path {
+		t.Helper()
+		results := slices.Clone(build.Results)
+		for _, result := range results {
+			if result.Schedule == nil {
+				t.Fatalf("build %s result for %s has no schedule", build.ID, result.DrvPath)
+			}
+			if !result.Schedule.Built {
+				t.Errorf("build %s did not run builder for %s", build.ID, result.DrvPath)
+			}
+		}
+		slices.SortFunc(results, func(a, b *zbstorerpc.BuildResult) int {
+			return a.Schedule.Seq - b.Schedule.Seq
+		})
+		paths := make([]zbstore.Path, 0, len(results))
+		for i, result := range results {
+			if result.Schedule.Seq != i+1 {
+				t.Errorf("build %s result for %s has sequence number %d; want %d", build.ID, result.DrvPath, result.Schedule.Seq, i+1)
+			}
+			paths = append(paths, result.DrvPath)
+		}
+		return paths
+	}
+
+	realize1Response := new(zbstorerpc.RealizeResponse)
+	err = jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, realize1Response, &zbstorerpc.RealizeRequest{
+		DrvPaths: []zbstore.Path{finalDrvPath},
+		Reuse:    &zbstorerpc.ReusePolicy{All: true},
 	})
 	if err != nil {
-		t.Fatal("RPC error:", err)
-	}
-	if realizeResponse.BuildID == "" {
-		t.Fatal("no build ID returned")
+		t.Fatal("first RPC error:", err)
 	}
-	got, err := backendtest.WaitForSuccessfulBuild(ctx, client, realizeResponse.BuildID)
+	first, err := backendtest.WaitForBuild(ctx, client, realize1Response.BuildID)
 	if err != nil {
 		t.Fatal(err)
 	}
-
-	const fakeDigest = "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
-	contentModuloHash := new(bytes.Buffer)
-	if err := storetest.SingleFileNAR(contentModuloHash, []byte(dir.Join(fakeDigest+"-"+wantOutputName)+"\n")); err != nil {
-		t.Fatal(err)
+	if first.Status != zbstorerpc.BuildFail {
+		t.Fatalf("first build status = %q; want %q", first.Status, zbstorerpc.BuildFail)
 	}
-	ca, _, err := zbstore.SourceSHA256ContentAddress(contentModuloHash, &zbstore.ContentAddressOptions{
-		Digest: fakeDigest,
-	})
-	if err != nil {
-		t.Fatal(err)
+	firstOrder := scheduledOrder(first)
+	if len(firstOrder) != 6 || firstOrder[len(firstOrder)-1] != finalDrvPath {
+		t.Fatalf("first build order = %q; want 6 derivations ending in %s", firstOrder, finalDrvPath)
 	}
-	wantOutputPath, err := zbstore.FixedCAOutputPath(dir, wantOutputName, ca, zbstore.References{Self: true})
-	if err != nil {
-		t.Fatal(err)
+
+	for range 3 {
+		realize2Response := new(zbstorerpc.RealizeResponse)
+		err = jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, realize2Response, &zbstorerpc.RealizeRequest{
+			DrvPaths:       []zbstore.Path{finalDrvPath},
+			Reuse:          &zbstorerpc.ReusePolicy{All: true},
+			ReplaySchedule: realize1Response.BuildID,
+		})
+		if err != nil {
+			t.Fatal("replay RPC error:", err)
+		}
+		replayed, err := backendtest.WaitForBuild(ctx, client, realize2Response.BuildID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if replayed.Status != zbstorerpc.BuildFail {
+			t.Errorf("replayed build status = %q; want %q", replayed.Status, zbstorerpc.BuildFail)
+		}
+		if diff := cmp.Diff(firstOrder, scheduledOrder(replayed)); diff != "" {
+			t.Errorf("replayed build order (-want +got):\n%s", diff)
+		}
 	}
-	wantOutputContent := dir.Join(wantOutputPath.Digest()+"-"+wantOutputName) + "\n"
 
-	checkSingleFileOutput(t, drvPath, wantOutputPath, []byte(wantOutputContent), got)
+	err = jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, nil, &zbstorerpc.RealizeRequest{
+		DrvPaths:       []zbstore.Path{finalDrvPath},
+		ReplaySchedule: "01234567-89ab-cdef-0123-456789abcdef",
+	})
+	if code, _ := jsonrpc.CodeFromError(err); code != jsonrpc.InvalidParams {
+		t.Errorf("replaying unknown build: error = %v; want code %d", err, jsonrpc.InvalidParams)
+	}
 }
 
-func TestRealizeFixed(t *testing.T) {
+func TestRealizeDisableReuse(t *testing.T) {
 	ctx := testcontext.New(t)
 	dir := backendtest.NewStoreDirectory(t)
 
+	const inputContent = "Hello, World!\n"
 	exportBuffer := new(bytes.Buffer)
 	exporter := zbstore.NewExportWriter(exportBuffer)
-	const wantOutputName = "hello.txt"
-	const wantOutputContent = "Hello, World!\n"
-	wantOutputCA := nix.FlatFileContentAddress(mustParseHash(t, "sha256:c98c24b677eff44860afea6f493bbaec5bb1c4cbb209c6fc2bbb47f66ff2ad31"))
-	drv1Content := &zbstore.Derivation{
-		Name:   wantOutputName,
-		Dir:    dir,
-		System: system.Current().String(),
-		Env: map[string]string{
-			"out": zbstore.HashPlaceholder("out"),
-		},
-		Outputs: map[string]*zbstore.DerivationOutputType{
-			zbstore.DefaultDerivationOutputName: zbstore.FixedCAOutput(wantOutputCA),
-		},
-	}
-	if runtime.GOOS == "windows" {
-		drv1Content.Builder = powershellPath
-		drv1Content.Args = []string{
-			"-Command",
-			"\"Hello, World!`n\" | Out-File -NoNewline -Encoding ascii -FilePath ${env:out}",
-		}
-	} else {
-		drv1Content.Builder = shPath
-		drv1Content.Args = []string{
-			"-c",
-			`echo 'Hello, World!' > $out`,
-		}
-	}
-	drv1Path, _, err := storetest.ExportDerivation(exporter, drv1Content)
+	inputFilePath, _, err := storetest.ExportSourceFile(exporter, []byte(inputContent), storetest.SourceExportOptions{
+		Name:      "hello.txt",
+		Directory: dir,
+	})
 	if err != nil {
 		t.Fatal(err)
 	}
-	// Create a second derivation with the same output hash
-	// but a totally failing builder.
-	drv2Content := &zbstore.Derivation{
+	const wantOutputName = "hello2.txt"
+	drvContent := &zbstore.Derivation{
 		Name:   wantOutputName,
 		Dir:    dir,
 		System: system.Current().String(),
 		Env: map[string]string{
+			"in":  string(inputFilePath),
 			"out": zbstore.HashPlaceholder("out"),
 		},
+		InputSources: *sets.NewSorted(
+			inputFilePath,
+		),
 		Outputs: map[string]*zbstore.DerivationOutputType{
-			zbstore.DefaultDerivationOutputName: zbstore.FixedCAOutput(wantOutputCA),
+			zbstore.DefaultDerivationOutputName: zbstore.RecursiveFileFloatingCAOutput(nix.SHA256),
 		},
 	}
-	if runtime.GOOS == "windows" {
-		drv2Content.Builder = powershellPath
-		drv2Content.Args = []string{"-Command", "exit 1"}
-	} else {
-		drv2Content.Builder = shPath
-		drv2Content.Args = []string{"-c", "exit 1"}
-	}
-	drv2Path, _, err := storetest.ExportDerivation(exporter, drv2Content)
+	drvContent.Builder, drvContent.Args = catcatBuilder()
+	drvPath, _, err := storetest.ExportDerivation(exporter, drvContent)
 	if err != nil {
 		t.Fatal(err)
 	}
 	if err := exporter.Close(); err != nil {
 		t.Fatal(err)
 	}
-	wantOutputPath, err := zbstore.FixedCAOutputPath(dir, wantOutputName, wantOutputCA, zbstore.References{})
-	if err != nil {
-		t.Fatal(err)
-	}
 
 	_, client, err := backendtest.NewServer(ctx, t, dir, &backendtest.Options{
 		TempDir: t.TempDir(),
@@ -824,61 +900,96 @@ func TestRealizeFixed(t *testing.T) {
 
 	realize1Response := new(zbstorerpc.RealizeResponse)
 	err = jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, realize1Response, &zbstorerpc.RealizeRequest{
-		DrvPaths: []zbstore.Path{drv1Path},
+		DrvPaths: []zbstore.Path{drvPath},
 	})
 	if err != nil {
-		t.Fatal("build drv1:", err)
+		t.Fatal("first RPC error:", err)
 	}
-	got1, err := backendtest.WaitForSuccessfulBuild(ctx, client, realize1Response.BuildID)
-	if err != nil {
-		gotLog, _ := backendtest.ReadLog(ctx, client, realize1Response.BuildID, drv1Path)
-		t.Fatalf("build drv1: %v\nlog:\n%s", err, gotLog)
+	if _, err := backendtest.WaitForSuccessfulBuild(ctx, client, realize1Response.BuildID); err != nil {
+		gotLog, _ := backendtest.ReadLog(ctx, client, realize1Response.BuildID, drvPath)
+		t.Fatalf("first build failed: %v\nlog:\n%s", err, gotLog)
 	}
-	checkSingleFileOutput(t, drv1Path, wantOutputPath, []byte(wantOutputContent), got1)
 
-	// Now let's build the second derivation to see whether the output gets reused.
 	realize2Response := new(zbstorerpc.RealizeResponse)
 	err = jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, realize2Response, &zbstorerpc.RealizeRequest{
-		DrvPaths: []zbstore.Path{drv2Path},
+		DrvPaths: []zbstore.Path{drvPath},
+		Reuse:    nil,
 	})
 	if err != nil {
-		t.Fatal("build drv2:", err)
+		t.Fatal("second RPC error:", err)
 	}
-	got2, err := backendtest.WaitForSuccessfulBuild(ctx, client, realize2Response.BuildID)
+	got, err := backendtest.WaitForSuccessfulBuild(ctx, client, realize2Response.BuildID)
 	if err != nil {
-		gotLog, _ := backendtest.ReadLog(ctx, client, realize2Response.BuildID, drv2Path)
-		t.Fatalf("build drv2: %v\nlog:\n%s", err, gotLog)
+		t.Error("second build failed:", err)
 	}
-	checkSingleFileOutput(t, drv2Path, wantOutputPath, []byte(wantOutputContent), got2)
+
+	gotLog, err := backendtest.ReadLog(ctx, client, realize2Response.BuildID, drvPath)
+	if err != nil {
+		t.Error("accessing second build's logs:", err)
+	}
+	if want := "catcat\n"; string(gotLog) != want {
+		t.Errorf("build log:\n%s\n(want %q)", gotLog, want)
+	}
+
+	const wantOutputContent = "Hello, World!\nHello, World!\n"
+	wantOutputPath, err := singleFileOutputPath(dir, wantOutputName, []byte(wantOutputContent), zbstore.References{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSingleFileOutput(t, drvPath, wantOutputPath, []byte(wantOutputContent), got)
 }
 
-func TestRealizeFailure(t *testing.T) {
+func TestRealizeRebuildReason(t *testing.T) {
 	ctx := testcontext.New(t)
 	dir := backendtest.NewStoreDirectory(t)
 
 	exportBuffer := new(bytes.Buffer)
 	exporter := zbstore.NewExportWriter(exportBuffer)
-	const drvName = "hello.txt"
-	// Create a derivation that fails after creating its output.
-	drvContent := &zbstore.Derivation{
-		Name:   drvName,
+	// Create an input derivation that produces different output each time it runs.
+	drv1Content := &zbstore.Derivation{
+		Name:   "counter.txt",
 		Dir:    dir,
 		System: system.Current().String(),
 		Env: map[string]string{
-			"out": zbstore.HashPlaceholder("out"),
+			"counter": filepath.Join(t.TempDir(), "counter.txt"),
+			"out":     zbstore.HashPlaceholder("out"),
 		},
 		Outputs: map[string]*zbstore.DerivationOutputType{
 			zbstore.DefaultDerivationOutputName: zbstore.RecursiveFileFloatingCAOutput(nix.SHA256),
 		},
 	}
 	if runtime.GOOS == "windows" {
-		drvContent.Builder = powershellPath
-		drvContent.Args = []string{"-Command", "New-Item ${env:out} -type file ; exit 1"}
+		drv1Content.Builder = powershellPath
+		drv1Content.Args = []string{"-Command", "Add-Content -Path ${env:counter} -Value x ; Copy-Item ${env:counter} ${env:out}"}
 	} else {
-		drvContent.Builder = shPath
-		drvContent.Args = []string{"-c", "echo > $out ; exit 1"}
+		drv1Content.Builder = shPath
+		drv1Content.Args = []string{"-c", `echo x >> $counter ; while read line; do echo "$line"; done < $counter > $out`}
 	}
-	drvPath, _, err := storetest.ExportDerivation(exporter, drvContent)
+	drv1Path, _, err := storetest.ExportDerivation(exporter, drv1Content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := zbstore.OutputReference{
+		DrvPath:    drv1Path,
+		OutputName: zbstore.DefaultDerivationOutputName,
+	}
+	drv2Content := &zbstore.Derivation{
+		Name:   "counter2.txt",
+		Dir:    dir,
+		System: system.Current().String(),
+		Env: map[string]string{
+			"in":  zbstore.UnknownCAOutputPlaceholder(input),
+			"out": zbstore.HashPlaceholder("out"),
+		},
+		InputDerivations: map[zbstore.Path]*sets.Sorted[string]{
+			drv1Path: sets.NewSorted(zbstore.DefaultDerivationOutputName),
+		},
+		Outputs: map[string]*zbstore.DerivationOutputType{
+			zbstore.DefaultDerivationOutputName: zbstore.RecursiveFileFloatingCAOutput(nix.SHA256),
+		},
+	}
+	drv2Content.Builder, drv2Content.Args = catcatBuilder()
+	drv2Path, _, err := storetest.ExportDerivation(exporter, drv2Content)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -902,95 +1013,265 @@ func TestRealizeFailure(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	realizeResponse := new(zbstorerpc.RealizeResponse)
-	err = jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, realizeResponse, &zbstorerpc.RealizeRequest{
-		DrvPaths: []zbstore.Path{drvPath},
-	})
-	if err != nil {
-		t.Fatal("build drv:", err)
+	realize := func(reuse *zbstorerpc.ReusePolicy) *zbstorerpc.Build {
+		t.Helper()
+		realizeResponse := new(zbstorerpc.RealizeResponse)
+		err := jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, realizeResponse, &zbstorerpc.RealizeRequest{
+			DrvPaths: []zbstore.Path{drv2Path},
+			Reuse:    reuse,
+		})
+		if err != nil {
+			t.Fatal("RPC error:", err)
+		}
+		build, err := backendtest.WaitForSuccessfulBuild(ctx, client, realizeResponse.BuildID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return build
 	}
-	got, err := backendtest.WaitForBuild(ctx, client, realizeResponse.BuildID)
+	rebuildReason := func(build *zbstorerpc.Build, drvPath zbstore.Path) *zbstorerpc.RebuildReason {
+		t.Helper()
+		result, err := build.ResultForPath(drvPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result.RebuildReason
+	}
+
+	first := realize(&zbstorerpc.ReusePolicy{All: true})
+	want := &zbstorerpc.RebuildReason{
+		Kind:       zbstorerpc.RebuildNoRealizations,
+		OutputName: zbstore.DefaultDerivationOutputName,
+	}
+	if diff := cmp.Diff(want, rebuildReason(first, drv2Path)); diff != "" {
+		t.Errorf("first build %s rebuild reason (-want +got):\n%s", drv2Path, diff)
+	}
+	firstInputPath, err := first.FindRealizeOutput(input)
 	if err != nil {
-		t.Fatal("build drv:", err)
+		t.Fatal(err)
 	}
-	want := &zbstorerpc.Build{
-		ID:     realizeResponse.BuildID,
-		Status: zbstorerpc.BuildFail,
-		Results: []*zbstorerpc.BuildResult{
-			{
-				DrvPath: drvPath,
-				Status:  zbstorerpc.BuildFail,
-				Outputs: []*zbstorerpc.RealizeOutput{
-					{
-						Name: zbstore.DefaultDerivationOutputName,
-					},
-				},
-			},
-		},
+
+	second := realize(&zbstorerpc.ReusePolicy{All: true})
+	if got := rebuildReason(second, drv2Path); got != nil {
+		t.Errorf("second build %s rebuild reason = %v; want <nil>", drv2Path, got)
 	}
-	diff := cmp.Diff(
-		want, got,
-		cmp.FilterPath(
-			func(p cmp.Path) bool {
-				return isFieldAnyOf[zbstorerpc.Build](p, "StartedAt", "EndedAt")
-			},
-			cmp.Ignore(),
+
+	// Disabling reuse rebuilds the input,
+	// which produces a different output because of the counter.
+	third := realize(nil)
+	want = &zbstorerpc.RebuildReason{
+		Kind:       zbstorerpc.RebuildReusePolicy,
+		OutputName: zbstore.DefaultDerivationOutputName,
+	}
+	if diff := cmp.Diff(want, rebuildReason(third, drv1Path)); diff != "" {
+		t.Errorf("third build %s rebuild reason (-want +got):\n%s", drv1Path, diff)
+	}
+	thirdInputPath, err := third.FindRealizeOutput(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !firstInputPath.Valid || !thirdInputPath.Valid || firstInputPath.X == thirdInputPath.X {
+		t.Fatalf("input realized to %v and %v; want different paths", firstInputPath, thirdInputPath)
+	}
+	want = &zbstorerpc.RebuildReason{
+		Kind:            zbstorerpc.RebuildInputsChanged,
+		OutputName:      zbstore.DefaultDerivationOutputName,
+		PreviousBuildID: first.ID,
+		ChangedInputs: []*zbstorerpc.ChangedInput{{
+			Input:   input,
+			OldPath: firstInputPath.X,
+			NewPath: thirdInputPath.X,
+		}},
+	}
+	if diff := cmp.Diff(want, rebuildReason(third, drv2Path)); diff != "" {
+		t.Errorf("third build %s rebuild reason (-want +got):\n%s", drv2Path, diff)
+	}
+
+	// Only trusting an unrelated key cannot reuse the unsigned realizations.
+	otherKey := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	fourth := realize(&zbstorerpc.ReusePolicy{
+		PublicKeys: []*zbstore.RealizationPublicKey{{
+			Format: zbstore.Ed25519SignatureFormat,
+			Data:   otherKey.Public().(ed25519.PublicKey),
+		}},
+	})
+	want = &zbstorerpc.RebuildReason{
+		Kind:       zbstorerpc.RebuildMissingSignature,
+		OutputName: zbstore.DefaultDerivationOutputName,
+	}
+	if diff := cmp.Diff(want, rebuildReason(fourth, drv1Path)); diff != "" {
+		t.Errorf("fourth build %s rebuild reason (-want +got):\n%s", drv1Path, diff)
+	}
+}
+
+func TestRealizeMultiStep(t *testing.T) {
+	ctx := testcontext.New(t)
+	dir := backendtest.NewStoreDirectory(t)
+
+	const inputContent = "Hello, World!\n"
+	exportBuffer := new(bytes.Buffer)
+	exporter := zbstore.NewExportWriter(exportBuffer)
+	inputFilePath, _, err := storetest.ExportSourceFile(exporter, []byte(inputContent), storetest.SourceExportOptions{
+		Name:      "hello.txt",
+		Directory: dir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	drv1Content := &zbstore.Derivation{
+		Name:   "hello2.txt",
+		Dir:    dir,
+		System: system.Current().String(),
+		Env: map[string]string{
+			"in":  string(inputFilePath),
+			"out": zbstore.HashPlaceholder("out"),
+		},
+		InputSources: *sets.NewSorted(
+			inputFilePath,
 		),
-		buildResultOption,
-		ignoreDrvHashOption,
-	)
-	if diff != "" {
-		t.Errorf("build (-want +got):\n%s", diff)
+		Outputs: map[string]*zbstore.DerivationOutputType{
+			zbstore.DefaultDerivationOutputName: zbstore.RecursiveFileFloatingCAOutput(nix.SHA256),
+		},
 	}
-	if !got.EndedAt.Valid {
-		t.Error("build.endedAt = null")
+	drv1Content.Builder, drv1Content.Args = catcatBuilder()
+	drv1Path, _, err := storetest.ExportDerivation(exporter, drv1Content)
+	if err != nil {
+		t.Fatal(err)
 	}
-	// Ensure that the build didn't leave files in the store.
-	storeListing, err := os.ReadDir(string(dir))
+	const wantOutputName = "hello4.txt"
+	drv2Content := &zbstore.Derivation{
+		Name:   "hello4.txt",
+		Dir:    dir,
+		System: system.Current().String(),
+		Env: map[string]string{
+			"in": zbstore.UnknownCAOutputPlaceholder(zbstore.OutputReference{
+				DrvPath:    drv1Path,
+				OutputName: zbstore.DefaultDerivationOutputName,
+			}),
+			"out": zbstore.HashPlaceholder("out"),
+		},
+		InputDerivations: map[zbstore.Path]*sets.Sorted[string]{
+			drv1Path: sets.NewSorted(zbstore.DefaultDerivationOutputName),
+		},
+		Outputs: map[string]*zbstore.DerivationOutputType{
+			zbstore.DefaultDerivationOutputName: zbstore.RecursiveFileFloatingCAOutput(nix.SHA256),
+		},
+	}
+	drv2Content.Builder, drv2Content.Args = catcatBuilder()
+	drv2Path, _, err := storetest.ExportDerivation(exporter, drv2Content)
 	if err != nil {
 		t.Fatal(err)
 	}
-	for _, ent := range storeListing {
-		name := ent.Name()
-		if name != drvPath.Base() {
-			t.Errorf("unknown object %s left in store", name)
-		}
+	if err := exporter.Close(); err != nil {
+		t.Fatal(err)
 	}
 
-	if gotLog, err := backendtest.ReadLog(ctx, client, realizeResponse.BuildID, drvPath); err != nil {
-		t.Error(err)
-	} else if want := "exit status"; !bytes.Contains(gotLog, []byte(want)) {
-		t.Errorf("Log does not contain phrase %q. Full output:\n%s", want, gotLog)
+	_, client, err := backendtest.NewServer(ctx, t, dir, &backendtest.Options{
+		TempDir: t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
 	}
+	codec, releaseCodec, err := storeCodec(ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = codec.Export(nil, exportBuffer)
+	releaseCodec()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	realizeResponse := new(zbstorerpc.RealizeResponse)
+	err = jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, realizeResponse, &zbstorerpc.RealizeRequest{
+		DrvPaths: []zbstore.Path{drv2Path},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := backendtest.WaitForSuccessfulBuild(ctx, client, realizeResponse.BuildID)
+	if err != nil {
+		gotLog1, _ := backendtest.ReadLog(ctx, client, realizeResponse.BuildID, drv1Path)
+		gotLog2, _ := backendtest.ReadLog(ctx, client, realizeResponse.BuildID, drv2Path)
+		t.Fatalf("build failed: %v\ndrv1 log:\n%s\ndrv2 log:\n%s", err, gotLog1, gotLog2)
+	}
+
+	wantOutputContent := strings.Repeat(inputContent, 4)
+	wantOutputPath, err := singleFileOutputPath(dir, wantOutputName, []byte(wantOutputContent), zbstore.References{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSingleFileOutput(t, drv2Path, wantOutputPath, []byte(wantOutputContent), got)
 }
 
-func TestRealizeNoOutput(t *testing.T) {
+func TestRealizeReferenceToDep(t *testing.T) {
 	ctx := testcontext.New(t)
 	dir := backendtest.NewStoreDirectory(t)
 
+	const inputContent = "Hello, World!\n"
 	exportBuffer := new(bytes.Buffer)
 	exporter := zbstore.NewExportWriter(exportBuffer)
-	const drvName = "hello.txt"
-	// Create a derivation that no-ops.
-	drvContent := &zbstore.Derivation{
-		Name:   drvName,
+	inputFilePath, _, err := storetest.ExportSourceFile(exporter, []byte(inputContent), storetest.SourceExportOptions{
+		Name:      "hello.txt",
+		Directory: dir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const drv1OutputName = "hello2.txt"
+	drv1Content := &zbstore.Derivation{
+		Name:   drv1OutputName,
+		Dir:    dir,
+		System: system.Current().String(),
+		Env: map[string]string{
+			"in":  string(inputFilePath),
+			"out": zbstore.HashPlaceholder("out"),
+		},
+		InputSources: *sets.NewSorted(
+			inputFilePath,
+		),
+		Outputs: map[string]*zbstore.DerivationOutputType{
+			zbstore.DefaultDerivationOutputName: zbstore.RecursiveFileFloatingCAOutput(nix.SHA256),
+		},
+	}
+	drv1Content.Builder, drv1Content.Args = catcatBuilder()
+	drv1Path, _, err := storetest.ExportDerivation(exporter, drv1Content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const wantOutputName = "hello-ref.txt"
+	drv2Content := &zbstore.Derivation{
+		Name:   wantOutputName,
 		Dir:    dir,
 		System: system.Current().String(),
 		Env: map[string]string{
+			"in": zbstore.UnknownCAOutputPlaceholder(zbstore.OutputReference{
+				DrvPath:    drv1Path,
+				OutputName: zbstore.DefaultDerivationOutputName,
+			}),
 			"out": zbstore.HashPlaceholder("out"),
 		},
+		InputDerivations: map[zbstore.Path]*sets.Sorted[string]{
+			drv1Path: sets.NewSorted(zbstore.DefaultDerivationOutputName),
+		},
 		Outputs: map[string]*zbstore.DerivationOutputType{
 			zbstore.DefaultDerivationOutputName: zbstore.RecursiveFileFloatingCAOutput(nix.SHA256),
 		},
 	}
 	if runtime.GOOS == "windows" {
-		drvContent.Builder = powershellPath
-		drvContent.Args = []string{"-Command", "exit"}
+		drv2Content.Builder = powershellPath
+		drv2Content.Args = []string{
+			"-Command",
+			"(${env:in} + \"`n\")" + ` | Out-File -NoNewline -Encoding ascii -FilePath ${env:out}`,
+		}
 	} else {
-		drvContent.Builder = shPath
-		drvContent.Args = []string{"-c", "true"}
+		drv2Content.Builder = shPath
+		drv2Content.Args = []string{
+			"-c",
+			`echo "$in" > "$out"`,
+		}
 	}
-	drvPath, _, err := storetest.ExportDerivation(exporter, drvContent)
+	drv2Path, _, err := storetest.ExportDerivation(exporter, drv2Content)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1014,6 +1295,1956 @@ func TestRealizeNoOutput(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	realizeResponse := new(zbstorerpc.RealizeResponse)
+	err = jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, realizeResponse, &zbstorerpc.RealizeRequest{
+		DrvPaths: []zbstore.Path{drv2Path},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := backendtest.WaitForSuccessfulBuild(ctx, client, realizeResponse.BuildID)
+	if err != nil {
+		gotLog1, _ := backendtest.ReadLog(ctx, client, realizeResponse.BuildID, drv1Path)
+		gotLog2, _ := backendtest.ReadLog(ctx, client, realizeResponse.BuildID, drv2Path)
+		t.Fatalf("build failed: %v\ndrv1 log:\n%s\ndrv2 log:\n%s", err, gotLog1, gotLog2)
+	}
+
+	drv1OutputContent := strings.Repeat(inputContent, 2)
+	drv1OutputPath, err := singleFileOutputPath(dir, drv1OutputName, []byte(drv1OutputContent), zbstore.References{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantOutputContent := append([]byte(drv1OutputPath), '\n')
+	wantOutputPath, err := singleFileOutputPath(dir, wantOutputName, wantOutputContent, zbstore.References{
+		Others: *sets.NewSorted(
+			drv1OutputPath,
+		),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSingleFileOutput(t, drv2Path, wantOutputPath, wantOutputContent, got)
+
+	var info zbstorerpc.InfoResponse
+	err = jsonrpc.Do(ctx, client, zbstorerpc.InfoMethod, &info, &zbstorerpc.InfoRequest{
+		Path: wantOutputPath,
+	})
+	if err != nil {
+		t.Error(err)
+	} else {
+		buf := new(bytes.Buffer)
+		if err := storetest.SingleFileNAR(buf, wantOutputContent); err != nil {
+			t.Fatal(err)
+		}
+		narData := buf.Bytes()
+		ca, _, err := zbstore.SourceSHA256ContentAddress(bytes.NewReader(narData), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := wantObjectInfo(info.Info, narData, ca, sets.NewSorted(
+			drv1OutputPath,
+		))
+		if diff := cmp.Diff(want, info.Info); diff != "" {
+			t.Errorf("info (-want +got):\n%s", diff)
+		}
+	}
+}
+
+func TestRealizeInputReference(t *testing.T) {
+	ctx := testcontext.New(t)
+	dir := backendtest.NewStoreDirectory(t)
+
+	const inputContent = "Hello, World!\n"
+	exportBuffer := new(bytes.Buffer)
+	exporter := zbstore.NewExportWriter(exportBuffer)
+	inputFilePath, _, err := storetest.ExportSourceFile(exporter, []byte(inputContent), storetest.SourceExportOptions{
+		Name:      "hello.txt",
+		Directory: dir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const wantOutputName = "hello-path.txt"
+	drvContent := &zbstore.Derivation{
+		Name:   wantOutputName,
+		Dir:    dir,
+		System: system.Current().String(),
+		Env: map[string]string{
+			"in":  string(inputFilePath),
+			"out": zbstore.HashPlaceholder("out"),
+		},
+		InputSources: *sets.NewSorted(inputFilePath),
+		Outputs: map[string]*zbstore.DerivationOutputType{
+			zbstore.DefaultDerivationOutputName: zbstore.RecursiveFileFloatingCAOutput(nix.SHA256),
+		},
+	}
+	if runtime.GOOS == "windows" {
+		drvContent.Builder = powershellPath
+		drvContent.Args = []string{"-Command", "\"${env:in}`n\" | Out-File -NoNewline -Encoding ascii -FilePath ${env:out}"}
+	} else {
+		drvContent.Builder = shPath
+		drvContent.Args = []string{"-c", `echo "$in" > "$out"`}
+	}
+	drvPath, _, err := storetest.ExportDerivation(exporter, drvContent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exporter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, client, err := backendtest.NewServer(ctx, t, dir, &backendtest.Options{
+		TempDir: t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	codec, releaseCodec, err := storeCodec(ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = codec.Export(nil, exportBuffer)
+	releaseCodec()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	realizeResponse := new(zbstorerpc.RealizeResponse)
+	err = jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, realizeResponse, &zbstorerpc.RealizeRequest{
+		DrvPaths: []zbstore.Path{drvPath},
+	})
+	if err != nil {
+		t.Fatal("RPC error:", err)
+	}
+	if realizeResponse.BuildID == "" {
+		t.Fatal("no build ID returned")
+	}
+	got, err := backendtest.WaitForSuccessfulBuild(ctx, client, realizeResponse.BuildID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantOutputContent := inputFilePath + "\n"
+	wantOutputPath, err := singleFileOutputPath(dir, wantOutputName, []byte(wantOutputContent), zbstore.References{
+		Others: *sets.NewSorted(inputFilePath),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSingleFileOutput(t, drvPath, wantOutputPath, []byte(wantOutputContent), got)
+}
+
+func TestRealizeSelfReference(t *testing.T) {
+	ctx := testcontext.New(t)
+	dir := backendtest.NewStoreDirectory(t)
+
+	exportBuffer := new(bytes.Buffer)
+	exporter := zbstore.NewExportWriter(exportBuffer)
+	const wantOutputName = "self.txt"
+	drvContent := &zbstore.Derivation{
+		Name:   wantOutputName,
+		Dir:    dir,
+		System: system.Current().String(),
+		Env: map[string]string{
+			"out": zbstore.HashPlaceholder("out"),
+		},
+		Outputs: map[string]*zbstore.DerivationOutputType{
+			zbstore.DefaultDerivationOutputName: zbstore.RecursiveFileFloatingCAOutput(nix.SHA256),
+		},
+	}
+	if runtime.GOOS == "windows" {
+		drvContent.Builder = powershellPath
+		drvContent.Args = []string{"-Command", "\"${env:out}`n\" | Out-File -NoNewline -Encoding ascii -FilePath ${env:out}"}
+	} else {
+		drvContent.Builder = shPath
+		drvContent.Args = []string{"-c", `echo "$out" > "$out"`}
+	}
+	drvPath, _, err := storetest.ExportDerivation(exporter, drvContent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exporter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, client, err := backendtest.NewServer(ctx, t, dir, &backendtest.Options{
+		TempDir: t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	codec, releaseCodec, err := storeCodec(ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = codec.Export(nil, exportBuffer)
+	releaseCodec()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	realizeResponse := new(zbstorerpc.RealizeResponse)
+	err = jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, realizeResponse, &zbstorerpc.RealizeRequest{
+		DrvPaths: []zbstore.Path{drvPath},
+	})
+	if err != nil {
+		t.Fatal("RPC error:", err)
+	}
+	if realizeResponse.BuildID == "" {
+		t.Fatal("no build ID returned")
+	}
+	got, err := backendtest.WaitForSuccessfulBuild(ctx, client, realizeResponse.BuildID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const fakeDigest = "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+	contentModuloHash := new(bytes.Buffer)
+	if err := storetest.SingleFileNAR(contentModuloHash, []byte(dir.Join(fakeDigest+"-"+wantOutputName)+"\n")); err != nil {
+		t.Fatal(err)
+	}
+	ca, _, err := zbstore.SourceSHA256ContentAddress(contentModuloHash, &zbstore.ContentAddressOptions{
+		Digest: fakeDigest,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantOutputPath, err := zbstore.FixedCAOutputPath(dir, wantOutputName, ca, zbstore.References{Self: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantOutputContent := dir.Join(wantOutputPath.Digest()+"-"+wantOutputName) + "\n"
+
+	checkSingleFileOutput(t, drvPath, wantOutputPath, []byte(wantOutputContent), got)
+}
+
+func TestRealizeFloatingSingleFile(t *testing.T) {
+	tests := []struct {
+		name       string
+		outputType *zbstore.DerivationOutputType
+		useInput   bool
+		makeCA     func(h nix.Hash) zbstore.ContentAddress
+	}{
+		{
+			name:       "Flat",
+			outputType: zbstore.FlatFileFloatingCAOutput(nix.SHA512),
+			makeCA:     nix.FlatFileContentAddress,
+		},
+		{
+			name:       "Text",
+			outputType: zbstore.TextFloatingCAOutput(nix.SHA256),
+			useInput:   true,
+			makeCA:     nix.TextContentAddress,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := testcontext.New(t)
+			dir := backendtest.NewStoreDirectory(t)
+
+			exportBuffer := new(bytes.Buffer)
+			exporter := zbstore.NewExportWriter(exportBuffer)
+			inputFilePath, _, err := storetest.ExportSourceFile(exporter, []byte("Hello, World!\n"), storetest.SourceExportOptions{
+				Name:      "hello.txt",
+				Directory: dir,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			const wantOutputName = "single.txt"
+			in := "Hello, World!"
+			var wantRefs zbstore.References
+			if test.useInput {
+				in = string(inputFilePath)
+				wantRefs.Others.Add(inputFilePath)
+			}
+			drvContent := &zbstore.Derivation{
+				Name:   wantOutputName,
+				Dir:    dir,
+				System: system.Current().String(),
+				Env: map[string]string{
+					"in":  in,
+					"out": zbstore.HashPlaceholder("out"),
+				},
+				InputSources: *sets.NewSorted(inputFilePath),
+				Outputs: map[string]*zbstore.DerivationOutputType{
+					zbstore.DefaultDerivationOutputName: test.outputType,
+				},
+			}
+			if runtime.GOOS == "windows" {
+				drvContent.Builder = powershellPath
+				drvContent.Args = []string{"-Command", "\"${env:in}`n\" | Out-File -NoNewline -Encoding ascii -FilePath ${env:out}"}
+			} else {
+				drvContent.Builder = shPath
+				drvContent.Args = []string{"-c", `echo "$in" > "$out"`}
+			}
+			drvPath, _, err := storetest.ExportDerivation(exporter, drvContent)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := exporter.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			_, client, err := backendtest.NewServer(ctx, t, dir, &backendtest.Options{
+				TempDir: t.TempDir(),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			codec, releaseCodec, err := storeCodec(ctx, client)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = codec.Export(nil, exportBuffer)
+			releaseCodec()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			realizeResponse := new(zbstorerpc.RealizeResponse)
+			err = jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, realizeResponse, &zbstorerpc.RealizeRequest{
+				DrvPaths: []zbstore.Path{drvPath},
+			})
+			if err != nil {
+				t.Fatal("RPC error:", err)
+			}
+			if realizeResponse.BuildID == "" {
+				t.Fatal("no build ID returned")
+			}
+			got, err := backendtest.WaitForSuccessfulBuild(ctx, client, realizeResponse.BuildID)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			wantOutputContent := in + "\n"
+			hashType, _ := test.outputType.HashType()
+			h := nix.NewHasher(hashType)
+			h.WriteString(wantOutputContent)
+			wantOutputPath, err := zbstore.FixedCAOutputPath(dir, wantOutputName, test.makeCA(h.SumHash()), wantRefs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkSingleFileOutput(t, drvPath, wantOutputPath, []byte(wantOutputContent), got)
+		})
+	}
+}
+
+func TestRealizeMultipleOutputs(t *testing.T) {
+	ctx := testcontext.New(t)
+	dir := backendtest.NewStoreDirectory(t)
+
+	exportBuffer := new(bytes.Buffer)
+	exporter := zbstore.NewExportWriter(exportBuffer)
+	const drvName = "multi.txt"
+	drvContent := &zbstore.Derivation{
+		Name:   drvName,
+		Dir:    dir,
+		System: system.Current().String(),
+		Env: map[string]string{
+			"out": zbstore.HashPlaceholder("out"),
+			"dev": zbstore.HashPlaceholder("dev"),
+		},
+		Outputs: map[string]*zbstore.DerivationOutputType{
+			"out": zbstore.RecursiveFileFloatingCAOutput(nix.SHA256),
+			"dev": zbstore.RecursiveFileFloatingCAOutput(nix.SHA256),
+		},
+	}
+	if runtime.GOOS == "windows" {
+		drvContent.Builder = powershellPath
+		drvContent.Args = []string{"-Command", "\"out`n\" | Out-File -NoNewline -Encoding ascii -FilePath ${env:out} ; \"dev`n\" | Out-File -NoNewline -Encoding ascii -FilePath ${env:dev}"}
+	} else {
+		drvContent.Builder = shPath
+		drvContent.Args = []string{"-c", `echo out > "$out" && echo dev > "$dev"`}
+	}
+	drvPath, _, err := storetest.ExportDerivation(exporter, drvContent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exporter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, client, err := backendtest.NewServer(ctx, t, dir, &backendtest.Options{
+		TempDir: t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	codec, releaseCodec, err := storeCodec(ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = codec.Export(nil, exportBuffer)
+	releaseCodec()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	realizeResponse := new(zbstorerpc.RealizeResponse)
+	err = jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, realizeResponse, &zbstorerpc.RealizeRequest{
+		DrvPaths: []zbstore.Path{drvPath},
+	})
+	if err != nil {
+		t.Fatal("RPC error:", err)
+	}
+	got, err := backendtest.WaitForSuccessfulBuild(ctx, client, realizeResponse.BuildID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := got.ResultForPath(drvPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, outputName := range []string{"out", "dev"} {
+		wantContent := outputName + "\n"
+		wantName := drvName
+		if outputName != zbstore.DefaultDerivationOutputName {
+			wantName += "-" + outputName
+		}
+		wantPath, err := singleFileOutputPath(dir, wantName, []byte(wantContent), zbstore.References{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		output, err := result.OutputForName(outputName)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if !output.Path.Valid || output.Path.X != wantPath {
+			t.Errorf("output %s path = %v; want %s", outputName, output.Path, wantPath)
+			continue
+		}
+		if content, err := os.ReadFile(string(wantPath)); err != nil {
+			t.Error(err)
+		} else if string(content) != wantContent {
+			t.Errorf("output %s content = %q; want %q", outputName, content, wantContent)
+		}
+	}
+}
+
+func TestRealizePhases(t *testing.T) {
+	ctx := testcontext.New(t)
+	dir := backendtest.NewStoreDirectory(t)
+
+	exportBuffer := new(bytes.Buffer)
+	exporter := zbstore.NewExportWriter(exportBuffer)
+	drvContent := &zbstore.Derivation{
+		Name:   "phases.txt",
+		Dir:    dir,
+		System: system.Current().String(),
+		Env: map[string]string{
+			"out": zbstore.HashPlaceholder("out"),
+		},
+		Outputs: map[string]*zbstore.DerivationOutputType{
+			zbstore.DefaultDerivationOutputName: zbstore.RecursiveFileFloatingCAOutput(nix.SHA256),
+		},
+	}
+	if runtime.GOOS == "windows" {
+		drvContent.Builder = powershellPath
+		drvContent.Args = []string{"-Command", "Write-Output '@zb-phase configure' ; [Console]::Error.WriteLine('configuring') ; Write-Output '@zb-phase install' ; \"done`n\" | Out-File -NoNewline -Encoding ascii -FilePath ${env:out}"}
+	} else {
+		drvContent.Builder = shPath
+		drvContent.Args = []string{"-c", `echo '@zb-phase configure' && echo configuring >&2 && echo '@zb-phase install' && echo done > "$out"`}
+	}
+	drvPath, _, err := storetest.ExportDerivation(exporter, drvContent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exporter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, client, err := backendtest.NewServer(ctx, t, dir, &backendtest.Options{
+		TempDir: t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	codec, releaseCodec, err := storeCodec(ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = codec.Export(nil, exportBuffer)
+	releaseCodec()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	realizeResponse := new(zbstorerpc.RealizeResponse)
+	err = jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, realizeResponse, &zbstorerpc.RealizeRequest{
+		DrvPaths: []zbstore.Path{drvPath},
+	})
+	if err != nil {
+		t.Fatal("RPC error:", err)
+	}
+	got, err := backendtest.WaitForSuccessfulBuild(ctx, client, realizeResponse.BuildID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := got.ResultForPath(drvPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gotNames []string
+	for i, phase := range result.Phases {
+		gotNames = append(gotNames, phase.Name)
+		if phase.Duration() < 0 {
+			t.Errorf("phase %q duration = %v; want >=0", phase.Name, phase.Duration())
+		}
+		if i > 0 && !result.Phases[i-1].EndedAt.Equal(phase.StartedAt) {
+			t.Errorf("phase %q ended at %v; want %v (start of phase %q)",
+				result.Phases[i-1].Name, result.Phases[i-1].EndedAt, phase.StartedAt, phase.Name)
+		}
+	}
+	if want := []string{"configure", "install"}; !slices.Equal(gotNames, want) {
+		t.Errorf("phases = %q; want %q", gotNames, want)
+	}
+
+	// Verify that the prefixed log has a timestamp on every line.
+	logResponse := new(zbstorerpc.ReadLogResponse)
+	err = jsonrpc.Do(ctx, client, zbstorerpc.ReadLogMethod, logResponse, &zbstorerpc.ReadLogRequest{
+		BuildID:  realizeResponse.BuildID,
+		DrvPath:  drvPath,
+		Prefixed: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawLog, err := logResponse.Payload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Standard output and standard error are read concurrently,
+	// so lines from different streams may be in any order.
+	gotStreams := make(map[string]zbstorerpc.LogStream)
+	for line := range bytes.Lines(rawLog) {
+		prefix, text, ok := zbstorerpc.CutLogPrefix(line)
+		if !ok {
+			t.Errorf("log line %q does not have a prefix", line)
+			continue
+		}
+		gotStreams[strings.TrimRight(string(text), "\r\n")] = prefix.Stream
+	}
+	wantStreams := map[string]zbstorerpc.LogStream{
+		"@zb-phase configure": zbstorerpc.LogStreamStdout,
+		"configuring":         zbstorerpc.LogStreamStderr,
+		"@zb-phase install":   zbstorerpc.LogStreamStdout,
+	}
+	if diff := cmp.Diff(wantStreams, gotStreams); diff != "" {
+		t.Errorf("log line streams (-want +got):\n%s", diff)
+	}
+}
+
+func TestRealizeTraceExec(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Exec tracing is only supported on Linux")
+	}
+	ctx := testcontext.New(t)
+	dir := backendtest.NewStoreDirectory(t)
+
+	exportBuffer := new(bytes.Buffer)
+	exporter := zbstore.NewExportWriter(exportBuffer)
+	drvContent := &zbstore.Derivation{
+		Name:    "traced.txt",
+		Dir:     dir,
+		System:  system.Current().String(),
+		Builder: shPath,
+		Args:    []string{"-c", `"$sh" -c 'exit 3'; echo done > "$out"`},
+		Env: map[string]string{
+			"out": zbstore.HashPlaceholder("out"),
+			"sh":  shPath,
+		},
+		Outputs: map[string]*zbstore.DerivationOutputType{
+			zbstore.DefaultDerivationOutputName: zbstore.RecursiveFileFloatingCAOutput(nix.SHA256),
+		},
+	}
+	drvPath, _, err := storetest.ExportDerivation(exporter, drvContent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exporter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, client, err := backendtest.NewServer(ctx, t, dir, &backendtest.Options{
+		TempDir: t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	codec, releaseCodec, err := storeCodec(ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = codec.Export(nil, exportBuffer)
+	releaseCodec()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	realizeResponse := new(zbstorerpc.RealizeResponse)
+	err = jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, realizeResponse, &zbstorerpc.RealizeRequest{
+		DrvPaths:  []zbstore.Path{drvPath},
+		TraceExec: true,
+	})
+	if err != nil {
+		t.Fatal("RPC error:", err)
+	}
+	if _, err := backendtest.WaitForSuccessfulBuild(ctx, client, realizeResponse.BuildID); err != nil {
+		t.Fatal(err)
+	}
+
+	logResponse := new(zbstorerpc.ReadLogResponse)
+	err = jsonrpc.Do(ctx, client, zbstorerpc.ReadLogMethod, logResponse, &zbstorerpc.ReadLogRequest{
+		BuildID: realizeResponse.BuildID,
+		DrvPath: drvPath,
+		Trace:   true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawTrace, err := logResponse.Payload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	type execSummary struct {
+		Argv     []string
+		ExitCode zbstorerpc.Nullable[int]
+	}
+	var got []execSummary
+	for line := range bytes.Lines(rawTrace) {
+		ev := new(zbstorerpc.ExecTraceEvent)
+		if err := jsonv2.Unmarshal(line, ev); err != nil {
+			t.Errorf("trace line %q: %v", line, err)
+			continue
+		}
+		if ev.Dir == "" {
+			t.Errorf("%q has empty dir", ev.Argv)
+		}
+		if ev.Duration() < 0 {
+			t.Errorf("%q duration = %v; want >=0", ev.Argv, ev.Duration())
+		}
+		got = append(got, execSummary{ev.Argv, ev.ExitCode})
+	}
+	want := []execSummary{
+		{
+			Argv:     []string{shPath, "-c", "exit 3"},
+			ExitCode: zbstorerpc.NonNull(3),
+		},
+		{
+			Argv:     append([]string{shPath}, drvContent.Args...),
+			ExitCode: zbstorerpc.NonNull(0),
+		},
+	}
+	// The builder's own event is written when it exits,
+	// so it comes after the events of its children.
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("exec trace (-want +got):\n%s", diff)
+	}
+}
+
+func TestRealizeTraceInputs(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Input tracing is only supported on Linux")
+	}
+	ctx := testcontext.New(t)
+	dir := backendtest.NewStoreDirectory(t)
+
+	exportBuffer := new(bytes.Buffer)
+	exporter := zbstore.NewExportWriter(exportBuffer)
+	usedPath, _, err := storetest.ExportSourceFile(exporter, []byte("Hello, World!\n"), storetest.SourceExportOptions{
+		Name:      "used.txt",
+		Directory: dir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	unusedPath, _, err := storetest.ExportSourceFile(exporter, []byte("Goodbye, World!\n"), storetest.SourceExportOptions{
+		Name:      "unused.txt",
+		Directory: dir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	drvContent := &zbstore.Derivation{
+		Name:    "traced.txt",
+		Dir:     dir,
+		System:  system.Current().String(),
+		Builder: shPath,
+		Args:    []string{"-c", `read line < "$used"; echo "$line" > "$out"`},
+		Env: map[string]string{
+			"used":   string(usedPath),
+			"unused": string(unusedPath),
+			"out":    zbstore.HashPlaceholder("out"),
+		},
+		InputSources: *sets.NewSorted(
+			usedPath,
+			unusedPath,
+		),
+		Outputs: map[string]*zbstore.DerivationOutputType{
+			zbstore.DefaultDerivationOutputName: zbstore.RecursiveFileFloatingCAOutput(nix.SHA256),
+		},
+	}
+	drvPath, _, err := storetest.ExportDerivation(exporter, drvContent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exporter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, client, err := backendtest.NewServer(ctx, t, dir, &backendtest.Options{
+		TempDir: t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	codec, releaseCodec, err := storeCodec(ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = codec.Export(nil, exportBuffer)
+	releaseCodec()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	realizeResponse := new(zbstorerpc.RealizeResponse)
+	err = jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, realizeResponse, &zbstorerpc.RealizeRequest{
+		DrvPaths:    []zbstore.Path{drvPath},
+		TraceInputs: true,
+	})
+	if err != nil {
+		t.Fatal("RPC error:", err)
+	}
+	build, err := backendtest.WaitForSuccessfulBuild(ctx, client, realizeResponse.BuildID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := build.ResultForPath(drvPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &zbstorerpc.InputUsage{
+		Used:   []zbstore.Path{usedPath},
+		Unused: []zbstore.Path{unusedPath},
+	}
+	if diff := cmp.Diff(want, result.InputUsage); diff != "" {
+		t.Errorf("input usage (-want +got):\n%s", diff)
+	}
+
+	if gotLog, err := backendtest.ReadLog(ctx, client, realizeResponse.BuildID, drvPath); err != nil {
+		t.Error(err)
+	} else if !bytes.Contains(gotLog, []byte(unusedPath)) || bytes.Contains(gotLog, []byte(usedPath)) {
+		t.Errorf("build log:\n%s\n(want mention of %s only)", gotLog, unusedPath)
+	}
+}
+
+func TestRealizeRootlessSandbox(t *testing.T) {
+	if runtime.GOOS != "linux" || os.Geteuid() == 0 {
+		t.Skip("Rootless sandbox is only used on Linux without root privileges")
+	}
+	if !CanSandbox() {
+		t.Skip("Unprivileged user namespaces not available")
+	}
+	ctx := testcontext.New(t)
+	dir := backendtest.NewStoreDirectory(t)
+	hostFile := filepath.Join(t.TempDir(), "secret.txt")
+	if err := os.WriteFile(hostFile, []byte("secret\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	exportBuffer := new(bytes.Buffer)
+	exporter := zbstore.NewExportWriter(exportBuffer)
+	drvContent := &zbstore.Derivation{
+		Name:    "sandboxed.txt",
+		Dir:     dir,
+		System:  system.Current().String(),
+		Builder: shPath,
+		Args:    []string{"-c", `{ id -u; if test -e "$hostFile"; then echo visible; else echo hidden; fi; } > "$out"`},
+		Env: map[string]string{
+			"hostFile": hostFile,
+			"out":      zbstore.HashPlaceholder("out"),
+			"PATH":     "/bin:/usr/bin",
+		},
+		Outputs: map[string]*zbstore.DerivationOutputType{
+			zbstore.DefaultDerivationOutputName: zbstore.RecursiveFileFloatingCAOutput(nix.SHA256),
+		},
+	}
+	drvPath, _, err := storetest.ExportDerivation(exporter, drvContent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exporter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := &backendtest.Options{
+		TempDir: t.TempDir(),
+		Sandbox: true,
+	}
+	opts.SandboxPaths = make(map[string]SandboxPath)
+	for _, p := range []string{"/bin", "/lib", "/lib64", "/usr"} {
+		if _, err := os.Lstat(p); err == nil {
+			opts.SandboxPaths[p] = SandboxPath{AlwaysPresent: true}
+		}
+	}
+	_, client, err := backendtest.NewServer(ctx, t, dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	codec, releaseCodec, err := storeCodec(ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = codec.Export(nil, exportBuffer)
+	releaseCodec()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	realizeResponse := new(zbstorerpc.RealizeResponse)
+	err = jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, realizeResponse, &zbstorerpc.RealizeRequest{
+		DrvPaths: []zbstore.Path{drvPath},
+	})
+	if err != nil {
+		t.Fatal("RPC error:", err)
+	}
+	got, err := backendtest.WaitForSuccessfulBuild(ctx, client, realizeResponse.BuildID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantOutputContent := fmt.Sprintf("%d\nhidden\n", os.Geteuid())
+	wantOutputPath, err := singleFileOutputPath(dir, drvContent.Name, []byte(wantOutputContent), zbstore.References{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSingleFileOutput(t, drvPath, wantOutputPath, []byte(wantOutputContent), got)
+}
+
+func TestRealizeHashedSystemDeps(t *testing.T) {
+	const depContent = "Hello, World!\n"
+	depDir := t.TempDir()
+	depPath := filepath.Join(depDir, "dep.txt")
+	if err := os.WriteFile(depPath, []byte(depContent), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	hasher := nix.NewHasher(nix.SHA256)
+	if err := storetest.SingleFileNAR(hasher, []byte(depContent)); err != nil {
+		t.Fatal(err)
+	}
+	depHash := hasher.SumHash()
+	wrongHash := nix.NewHash(nix.SHA256, make([]byte, nix.SHA256.Size()))
+
+	tests := []struct {
+		name        string
+		allowed     []string
+		dep         string
+		wantSuccess bool
+	}{
+		{
+			name:        "Match",
+			allowed:     []string{depDir},
+			dep:         depPath + "=" + depHash.SRI(),
+			wantSuccess: true,
+		},
+		{
+			name:    "WrongHash",
+			allowed: []string{depDir},
+			dep:     depPath + "=" + wrongHash.SRI(),
+		},
+		{
+			name:    "NotAllowed",
+			allowed: []string{filepath.Join(depDir, "other")},
+			dep:     depPath + "=" + depHash.SRI(),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := testcontext.New(t)
+			dir := backendtest.NewStoreDirectory(t)
+
+			exportBuffer := new(bytes.Buffer)
+			exporter := zbstore.NewExportWriter(exportBuffer)
+			drvContent := &zbstore.Derivation{
+				Name:   "dep-copy.txt",
+				Dir:    dir,
+				System: system.Current().String(),
+				Env: map[string]string{
+					"dep":                depPath,
+					"out":                zbstore.HashPlaceholder("out"),
+					"__hashedSystemDeps": test.dep,
+				},
+				Outputs: map[string]*zbstore.DerivationOutputType{
+					zbstore.DefaultDerivationOutputName: zbstore.RecursiveFileFloatingCAOutput(nix.SHA256),
+				},
+			}
+			if runtime.GOOS == "windows" {
+				drvContent.Builder = powershellPath
+				drvContent.Args = []string{"-Command", "Copy-Item -Path ${env:dep} -Destination ${env:out}"}
+			} else {
+				drvContent.Builder = shPath
+				drvContent.Args = []string{"-c", `while read line; do echo "$line"; done < "$dep" > "$out"`}
+			}
+			drvPath, _, err := storetest.ExportDerivation(exporter, drvContent)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := exporter.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			opts := &backendtest.Options{
+				TempDir: t.TempDir(),
+			}
+			opts.HashedSandboxPaths = test.allowed
+			_, client, err := backendtest.NewServer(ctx, t, dir, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			codec, releaseCodec, err := storeCodec(ctx, client)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = codec.Export(nil, exportBuffer)
+			releaseCodec()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			realizeResponse := new(zbstorerpc.RealizeResponse)
+			err = jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, realizeResponse, &zbstorerpc.RealizeRequest{
+				DrvPaths: []zbstore.Path{drvPath},
+			})
+			if err != nil {
+				t.Fatal("RPC error:", err)
+			}
+			got, err := backendtest.WaitForBuild(ctx, client, realizeResponse.BuildID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !test.wantSuccess {
+				if got.Status == zbstorerpc.BuildSuccess {
+					t.Errorf("build status = %q; want failure", got.Status)
+				}
+				return
+			}
+			wantOutputPath, err := singleFileOutputPath(dir, drvContent.Name, []byte(depContent), zbstore.References{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkSingleFileOutput(t, drvPath, wantOutputPath, []byte(depContent), got)
+		})
+	}
+}
+
+func TestRealizeFixed(t *testing.T) {
+	ctx := testcontext.New(t)
+	dir := backendtest.NewStoreDirectory(t)
+
+	exportBuffer := new(bytes.Buffer)
+	exporter := zbstore.NewExportWriter(exportBuffer)
+	const wantOutputName = "hello.txt"
+	const wantOutputContent = "Hello, World!\n"
+	wantOutputCA := nix.FlatFileContentAddress(mustParseHash(t, "sha256:c98c24b677eff44860afea6f493bbaec5bb1c4cbb209c6fc2bbb47f66ff2ad31"))
+	drv1Content := &zbstore.Derivation{
+		Name:   wantOutputName,
+		Dir:    dir,
+		System: system.Current().String(),
+		Env: map[string]string{
+			"out": zbstore.HashPlaceholder("out"),
+		},
+		Outputs: map[string]*zbstore.DerivationOutputType{
+			zbstore.DefaultDerivationOutputName: zbstore.FixedCAOutput(wantOutputCA),
+		},
+	}
+	if runtime.GOOS == "windows" {
+		drv1Content.Builder = powershellPath
+		drv1Content.Args = []string{
+			"-Command",
+			"\"Hello, World!`n\" | Out-File -NoNewline -Encoding ascii -FilePath ${env:out}",
+		}
+	} else {
+		drv1Content.Builder = shPath
+		drv1Content.Args = []string{
+			"-c",
+			`echo 'Hello, World!' > $out`,
+		}
+	}
+	drv1Path, _, err := storetest.ExportDerivation(exporter, drv1Content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Create a second derivation with the same output hash
+	// but a totally failing builder.
+	drv2Content := &zbstore.Derivation{
+		Name:   wantOutputName,
+		Dir:    dir,
+		System: system.Current().String(),
+		Env: map[string]string{
+			"out": zbstore.HashPlaceholder("out"),
+		},
+		Outputs: map[string]*zbstore.DerivationOutputType{
+			zbstore.DefaultDerivationOutputName: zbstore.FixedCAOutput(wantOutputCA),
+		},
+	}
+	if runtime.GOOS == "windows" {
+		drv2Content.Builder = powershellPath
+		drv2Content.Args = []string{"-Command", "exit 1"}
+	} else {
+		drv2Content.Builder = shPath
+		drv2Content.Args = []string{"-c", "exit 1"}
+	}
+	drv2Path, _, err := storetest.ExportDerivation(exporter, drv2Content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exporter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	wantOutputPath, err := zbstore.FixedCAOutputPath(dir, wantOutputName, wantOutputCA, zbstore.References{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, client, err := backendtest.NewServer(ctx, t, dir, &backendtest.Options{
+		TempDir: t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	codec, releaseCodec, err := storeCodec(ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = codec.Export(nil, exportBuffer)
+	releaseCodec()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	realize1Response := new(zbstorerpc.RealizeResponse)
+	err = jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, realize1Response, &zbstorerpc.RealizeRequest{
+		DrvPaths: []zbstore.Path{drv1Path},
+	})
+	if err != nil {
+		t.Fatal("build drv1:", err)
+	}
+	got1, err := backendtest.WaitForSuccessfulBuild(ctx, client, realize1Response.BuildID)
+	if err != nil {
+		gotLog, _ := backendtest.ReadLog(ctx, client, realize1Response.BuildID, drv1Path)
+		t.Fatalf("build drv1: %v\nlog:\n%s", err, gotLog)
+	}
+	checkSingleFileOutput(t, drv1Path, wantOutputPath, []byte(wantOutputContent), got1)
+
+	// Now let's build the second derivation to see whether the output gets reused.
+	realize2Response := new(zbstorerpc.RealizeResponse)
+	err = jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, realize2Response, &zbstorerpc.RealizeRequest{
+		DrvPaths: []zbstore.Path{drv2Path},
+	})
+	if err != nil {
+		t.Fatal("build drv2:", err)
+	}
+	got2, err := backendtest.WaitForSuccessfulBuild(ctx, client, realize2Response.BuildID)
+	if err != nil {
+		gotLog, _ := backendtest.ReadLog(ctx, client, realize2Response.BuildID, drv2Path)
+		t.Fatalf("build drv2: %v\nlog:\n%s", err, gotLog)
+	}
+	checkSingleFileOutput(t, drv2Path, wantOutputPath, []byte(wantOutputContent), got2)
+}
+
+func TestRealizeFailure(t *testing.T) {
+	ctx := testcontext.New(t)
+	dir := backendtest.NewStoreDirectory(t)
+
+	exportBuffer := new(bytes.Buffer)
+	exporter := zbstore.NewExportWriter(exportBuffer)
+	const drvName = "hello.txt"
+	// Create a derivation that fails after creating its output.
+	drvContent := &zbstore.Derivation{
+		Name:   drvName,
+		Dir:    dir,
+		System: system.Current().String(),
+		Env: map[string]string{
+			"out": zbstore.HashPlaceholder("out"),
+		},
+		Outputs: map[string]*zbstore.DerivationOutputType{
+			zbstore.DefaultDerivationOutputName: zbstore.RecursiveFileFloatingCAOutput(nix.SHA256),
+		},
+	}
+	if runtime.GOOS == "windows" {
+		drvContent.Builder = powershellPath
+		drvContent.Args = []string{"-Command", "New-Item ${env:out} -type file ; exit 1"}
+	} else {
+		drvContent.Builder = shPath
+		drvContent.Args = []string{"-c", "echo > $out ; exit 1"}
+	}
+	drvPath, _, err := storetest.ExportDerivation(exporter, drvContent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exporter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, client, err := backendtest.NewServer(ctx, t, dir, &backendtest.Options{
+		TempDir: t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	codec, releaseCodec, err := storeCodec(ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = codec.Export(nil, exportBuffer)
+	releaseCodec()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	realizeResponse := new(zbstorerpc.RealizeResponse)
+	err = jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, realizeResponse, &zbstorerpc.RealizeRequest{
+		DrvPaths: []zbstore.Path{drvPath},
+	})
+	if err != nil {
+		t.Fatal("build drv:", err)
+	}
+	got, err := backendtest.WaitForBuild(ctx, client, realizeResponse.BuildID)
+	if err != nil {
+		t.Fatal("build drv:", err)
+	}
+	want := &zbstorerpc.Build{
+		ID:     realizeResponse.BuildID,
+		Status: zbstorerpc.BuildFail,
+		Results: []*zbstorerpc.BuildResult{
+			{
+				DrvPath: drvPath,
+				Status:  zbstorerpc.BuildFail,
+				Outputs: []*zbstorerpc.RealizeOutput{
+					{
+						Name: zbstore.DefaultDerivationOutputName,
+					},
+				},
+			},
+		},
+	}
+	diff := cmp.Diff(
+		want, got,
+		cmp.FilterPath(
+			func(p cmp.Path) bool {
+				return isFieldAnyOf[zbstorerpc.Build](p, "StartedAt", "EndedAt")
+			},
+			cmp.Ignore(),
+		),
+		buildResultOption,
+		ignoreDrvHashOption,
+	)
+	if diff != "" {
+		t.Errorf("build (-want +got):\n%s", diff)
+	}
+	if !got.EndedAt.Valid {
+		t.Error("build.endedAt = null")
+	}
+	// Ensure that the build didn't leave files in the store.
+	storeListing, err := os.ReadDir(string(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ent := range storeListing {
+		name := ent.Name()
+		if name != drvPath.Base() {
+			t.Errorf("unknown object %s left in store", name)
+		}
+	}
+
+	if gotLog, err := backendtest.ReadLog(ctx, client, realizeResponse.BuildID, drvPath); err != nil {
+		t.Error(err)
+	} else if want := "exit status"; !bytes.Contains(gotLog, []byte(want)) {
+		t.Errorf("Log does not contain phrase %q. Full output:\n%s", want, gotLog)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	sleepPath, err := exec.LookPath("sleep")
+	if err != nil {
+		t.Skip("sleep not available:", err)
+	}
+
+	tests := []struct {
+		name        string
+		script      string
+		timeout     time.Duration
+		wantDrainOK bool
+	}{
+		{
+			name:        "Finish",
+			script:      sleepPath + " 1 && echo done > $out",
+			timeout:     time.Minute,
+			wantDrainOK: true,
+		},
+		{
+			name:    "Timeout",
+			script:  "exec " + sleepPath + " 60",
+			timeout: 100 * time.Millisecond,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := testcontext.New(t)
+			dir := backendtest.NewStoreDirectory(t)
+
+			exportBuffer := new(bytes.Buffer)
+			exporter := zbstore.NewExportWriter(exportBuffer)
+			drvPath, _, err := storetest.ExportDerivation(exporter, &zbstore.Derivation{
+				Name:    "slow.txt",
+				Dir:     dir,
+				System:  system.Current().String(),
+				Builder: shPath,
+				Args:    []string{"-c", test.script},
+				Env: map[string]string{
+					"out": zbstore.HashPlaceholder("out"),
+				},
+				Outputs: map[string]*zbstore.DerivationOutputType{
+					zbstore.DefaultDerivationOutputName: zbstore.RecursiveFileFloatingCAOutput(nix.SHA256),
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := exporter.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			srv, client, err := backendtest.NewServer(ctx, t, dir, &backendtest.Options{
+				TempDir: t.TempDir(),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			codec, releaseCodec, err := storeCodec(ctx, client)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = codec.Export(nil, exportBuffer)
+			releaseCodec()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			realizeResponse := new(zbstorerpc.RealizeResponse)
+			err = jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, realizeResponse, &zbstorerpc.RealizeRequest{
+				DrvPaths: []zbstore.Path{drvPath},
+			})
+			if err != nil {
+				t.Fatal("build drv:", err)
+			}
+
+			drainCtx, cancel := context.WithTimeout(ctx, test.timeout)
+			err = srv.Drain(drainCtx)
+			cancel()
+			if err != nil && test.wantDrainOK {
+				t.Error("Drain:", err)
+			} else if err == nil && !test.wantDrainOK {
+				t.Error("Drain did not return an error")
+			}
+
+			got, err := backendtest.WaitForBuild(ctx, client, realizeResponse.BuildID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.wantDrainOK && got.Status != zbstorerpc.BuildSuccess {
+				t.Errorf("build status = %q; want %q", got.Status, zbstorerpc.BuildSuccess)
+			}
+
+			err = jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, new(zbstorerpc.RealizeResponse), &zbstorerpc.RealizeRequest{
+				DrvPaths: []zbstore.Path{drvPath},
+			})
+			if err == nil {
+				t.Error("realize after drain did not return an error")
+			}
+		})
+	}
+}
+
+func TestRealizeNoOutput(t *testing.T) {
+	ctx := testcontext.New(t)
+	dir := backendtest.NewStoreDirectory(t)
+
+	exportBuffer := new(bytes.Buffer)
+	exporter := zbstore.NewExportWriter(exportBuffer)
+	const drvName = "hello.txt"
+	// Create a derivation that no-ops.
+	drvContent := &zbstore.Derivation{
+		Name:   drvName,
+		Dir:    dir,
+		System: system.Current().String(),
+		Env: map[string]string{
+			"out": zbstore.HashPlaceholder("out"),
+		},
+		Outputs: map[string]*zbstore.DerivationOutputType{
+			zbstore.DefaultDerivationOutputName: zbstore.RecursiveFileFloatingCAOutput(nix.SHA256),
+		},
+	}
+	if runtime.GOOS == "windows" {
+		drvContent.Builder = powershellPath
+		drvContent.Args = []string{"-Command", "exit"}
+	} else {
+		drvContent.Builder = shPath
+		drvContent.Args = []string{"-c", "true"}
+	}
+	drvPath, _, err := storetest.ExportDerivation(exporter, drvContent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exporter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, client, err := backendtest.NewServer(ctx, t, dir, &backendtest.Options{
+		TempDir: t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	codec, releaseCodec, err := storeCodec(ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = codec.Export(nil, exportBuffer)
+	releaseCodec()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	realizeResponse := new(zbstorerpc.RealizeResponse)
+	err = jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, realizeResponse, &zbstorerpc.RealizeRequest{
+		DrvPaths: []zbstore.Path{drvPath},
+	})
+	if err != nil {
+		t.Fatal("build drv:", err)
+	}
+	got, err := backendtest.WaitForBuild(ctx, client, realizeResponse.BuildID)
+	if err != nil {
+		t.Fatal("build drv:", err)
+	}
+	want := &zbstorerpc.Build{
+		ID:     realizeResponse.BuildID,
+		Status: zbstorerpc.BuildFail,
+		Results: []*zbstorerpc.BuildResult{
+			{
+				DrvPath: drvPath,
+				Status:  zbstorerpc.BuildFail,
+				Outputs: []*zbstorerpc.RealizeOutput{
+					{
+						Name: zbstore.DefaultDerivationOutputName,
+					},
+				},
+			},
+		},
+	}
+	diff := cmp.Diff(
+		want, got,
+		cmp.FilterPath(
+			func(p cmp.Path) bool {
+				return isFieldAnyOf[zbstorerpc.Build](p, "StartedAt", "EndedAt")
+			},
+			cmp.Ignore(),
+		),
+		buildResultOption,
+		ignoreDrvHashOption,
+	)
+	if diff != "" {
+		t.Errorf("build (-want +got):\n%s", diff)
+	}
+	if !got.EndedAt.Valid {
+		t.Error("build.endedAt = null")
+	}
+	// Ensure that the build didn't leave files in the store.
+	storeListing, err := os.ReadDir(string(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ent := range storeListing {
+		name := ent.Name()
+		if name != drvPath.Base() {
+			t.Errorf("unknown object %s left in store", name)
+		}
+	}
+
+	if gotLog, err := backendtest.ReadLog(ctx, client, realizeResponse.BuildID, drvPath); err != nil {
+		t.Error(err)
+	} else if want := "failed to produce output $out"; !bytes.Contains(gotLog, []byte(want)) {
+		t.Errorf("Log does not contain phrase %q. Full output:\n%s", want, gotLog)
+	}
+}
+
+func TestRealizeCores(t *testing.T) {
+	tests := []int{1, 2}
+	for _, n := range tests {
+		t.Run(fmt.Sprintf("N%d", n), func(t *testing.T) {
+			ctx := testcontext.New(t)
+			dir := backendtest.NewStoreDirectory(t)
+
+			exportBuffer := new(bytes.Buffer)
+			exporter := zbstore.NewExportWriter(exportBuffer)
+			const drvName = "cores.txt"
+			// Create a derivation that fails after creating its output.
+			drvContent := &zbstore.Derivation{
+				Name:   drvName,
+				Dir:    dir,
+				System: system.Current().String(),
+				Env: map[string]string{
+					"out": zbstore.HashPlaceholder("out"),
+				},
+				Outputs: map[string]*zbstore.DerivationOutputType{
+					zbstore.DefaultDerivationOutputName: zbstore.RecursiveFileFloatingCAOutput(nix.SHA256),
+				},
+			}
+			if runtime.GOOS == "windows" {
+				drvContent.Builder = powershellPath
+				drvContent.Args = []string{"-Command", "\"${env:ZB_BUILD_CORES}`n\" | Out-File -NoNewline -Encoding ascii -FilePath ${env:out}"}
+			} else {
+				drvContent.Builder = shPath
+				drvContent.Args = []string{"-c", `echo "$ZB_BUILD_CORES" > "$out"`}
+			}
+			drvPath, _, err := storetest.ExportDerivation(exporter, drvContent)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := exporter.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			_, client, err := backendtest.NewServer(ctx, t, dir, &backendtest.Options{
+				TempDir: t.TempDir(),
+				Options: Options{
+					CoresPerBuild: n,
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			codec, releaseCodec, err := storeCodec(ctx, client)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = codec.Export(nil, exportBuffer)
+			releaseCodec()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			realizeResponse := new(zbstorerpc.RealizeResponse)
+			err = jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, realizeResponse, &zbstorerpc.RealizeRequest{
+				DrvPaths: []zbstore.Path{drvPath},
+			})
+			if err != nil {
+				t.Fatal("build drv:", err)
+			}
+			got, err := backendtest.WaitForSuccessfulBuild(ctx, client, realizeResponse.BuildID)
+			if err != nil {
+				gotLog, _ := backendtest.ReadLog(ctx, client, realizeResponse.BuildID, drvPath)
+				t.Fatalf("build drv: %v\nlog:\n%s", err, gotLog)
+			}
+			wantOutputContent := fmt.Sprintf("%d\n", n)
+			wantOutputPath, err := singleFileOutputPath(dir, drvName, []byte(wantOutputContent), zbstore.References{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkSingleFileOutput(t, drvPath, wantOutputPath, []byte(wantOutputContent), got)
+		})
+	}
+}
+
+func TestRealizeDeterminism(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Test uses POSIX shell features")
+	}
+
+	tests := []struct {
+		name      string
+		env       map[string]string
+		want      string
+		wantUmask string
+	}{
+		{
+			name:      "Default",
+			want:      "UTC C.UTF-8 1000000000\n",
+			wantUmask: "0022\n",
+		},
+		{
+			name:      "Override",
+			env:       map[string]string{"TZ": "America/Los_Angeles"},
+			want:      "America/Los_Angeles C.UTF-8 1000000000\n",
+			wantUmask: "0022\n",
+		},
+		{
+			name: "OptOut",
+			env:  map[string]string{"__deterministic": "0"},
+			want: "  \n",
+		},
+		{
+			name:      "OptOutEnv",
+			env:       map[string]string{"__deterministic": "-env"},
+			want:      "  \n",
+			wantUmask: "0022\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := testcontext.New(t)
+			dir := backendtest.NewStoreDirectory(t)
+
+			exportBuffer := new(bytes.Buffer)
+			exporter := zbstore.NewExportWriter(exportBuffer)
+			drvContent := &zbstore.Derivation{
+				Name:   "env.txt",
+				Dir:    dir,
+				System: system.Current().String(),
+				Env: map[string]string{
+					"out": zbstore.HashPlaceholder("out"),
+				},
+				Builder: shPath,
+				Args:    []string{"-c", `umask >&2 ; echo "$TZ $LANG $SOURCE_DATE_EPOCH" > $out`},
+				Outputs: map[string]*zbstore.DerivationOutputType{
+					zbstore.DefaultDerivationOutputName: zbstore.RecursiveFileFloatingCAOutput(nix.SHA256),
+				},
+			}
+			maps.Copy(drvContent.Env, test.env)
+			drvPath, _, err := storetest.ExportDerivation(exporter, drvContent)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := exporter.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			_, client, err := backendtest.NewServer(ctx, t, dir, &backendtest.Options{
+				TempDir: t.TempDir(),
+				Options: Options{
+					Determinism: DeterminismOptions{
+						NormalizeEnvironment: true,
+						SourceDateEpoch:      time.Unix(1_000_000_000, 0),
+						EnforceUmask:         true,
+					},
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			codec, releaseCodec, err := storeCodec(ctx, client)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = codec.Export(nil, exportBuffer)
+			releaseCodec()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			realizeResponse := new(zbstorerpc.RealizeResponse)
+			err = jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, realizeResponse, &zbstorerpc.RealizeRequest{
+				DrvPaths: []zbstore.Path{drvPath},
+			})
+			if err != nil {
+				t.Fatal("RPC error:", err)
+			}
+			got, err := backendtest.WaitForSuccessfulBuild(ctx, client, realizeResponse.BuildID)
+			gotLog, logErr := backendtest.ReadLog(ctx, client, realizeResponse.BuildID, drvPath)
+			if err != nil {
+				t.Fatalf("%v\nlog:\n%s", err, gotLog)
+			}
+			if logErr != nil {
+				t.Error(logErr)
+			} else if test.wantUmask != "" && string(gotLog) != test.wantUmask {
+				t.Errorf("umask = %q; want %q", gotLog, test.wantUmask)
+			}
+			wantOutputPath, err := singleFileOutputPath(dir, drvContent.Name, []byte(test.want), zbstore.References{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkSingleFileOutput(t, drvPath, wantOutputPath, []byte(test.want), got)
+		})
+	}
+}
+
+func TestRealizeBuildHooks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Test uses POSIX shell scripts as hooks")
+	}
+
+	newDerivation := func(t *testing.T, dir zbstore.Directory) (zbstore.Path, *bytes.Buffer) {
+		exportBuffer := new(bytes.Buffer)
+		exporter := zbstore.NewExportWriter(exportBuffer)
+		drvPath, _, err := storetest.ExportDerivation(exporter, &zbstore.Derivation{
+			Name:   "hello.txt",
+			Dir:    dir,
+			System: system.Current().String(),
+			Env: map[string]string{
+				"out": zbstore.HashPlaceholder("out"),
+			},
+			Builder: shPath,
+			Args:    []string{"-c", `echo "Hello, World!" > $out`},
+			Outputs: map[string]*zbstore.DerivationOutputType{
+				zbstore.DefaultDerivationOutputName: zbstore.RecursiveFileFloatingCAOutput(nix.SHA256),
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := exporter.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return drvPath, exportBuffer
+	}
+	writeHook := func(t *testing.T, script string) string {
+		path := filepath.Join(t.TempDir(), "hook.sh")
+		if err := os.WriteFile(path, []byte("#!/bin/sh\n"+script), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	t.Run("PostBuild", func(t *testing.T) {
+		ctx := testcontext.New(t)
+		dir := backendtest.NewStoreDirectory(t)
+		drvPath, exportBuffer := newDerivation(t, dir)
+		hookOutput := filepath.Join(t.TempDir(), "hook-output.txt")
+		hook := writeHook(t, `echo "Uploading $OUT_PATHS"`+"\n"+
+			`printf '%s\n%s\n' "$DRV_PATH" "$OUT_PATHS" > '`+hookOutput+"'\n")
+
+		_, client, err := backendtest.NewServer(ctx, t, dir, &backendtest.Options{
+			TempDir: t.TempDir(),
+			Options: Options{
+				PostBuildHook: hook,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		codec, releaseCodec, err := storeCodec(ctx, client)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = codec.Export(nil, exportBuffer)
+		releaseCodec()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		realizeResponse := new(zbstorerpc.RealizeResponse)
+		err = jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, realizeResponse, &zbstorerpc.RealizeRequest{
+			DrvPaths: []zbstore.Path{drvPath},
+		})
+		if err != nil {
+			t.Fatal("RPC error:", err)
+		}
+		got, err := backendtest.WaitForSuccessfulBuild(ctx, client, realizeResponse.BuildID)
+		gotLog, logErr := backendtest.ReadLog(ctx, client, realizeResponse.BuildID, drvPath)
+		if err != nil {
+			t.Fatalf("%v\nlog:\n%s", err, gotLog)
+		}
+		outPath, err := got.FindRealizeOutput(zbstore.OutputReference{
+			DrvPath:    drvPath,
+			OutputName: zbstore.DefaultDerivationOutputName,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		gotHookOutput, err := os.ReadFile(hookOutput)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := string(drvPath) + "\n" + string(outPath.X) + "\n"; string(gotHookOutput) != want {
+			t.Errorf("hook received:\n%s\nwant:\n%s", gotHookOutput, want)
+		}
+		if logErr != nil {
+			t.Error(logErr)
+		} else if want := "Uploading " + string(outPath.X) + "\n"; !bytes.Contains(gotLog, []byte(want)) {
+			t.Errorf("Log does not contain phrase %q. Full output:\n%s", want, gotLog)
+		}
+	})
+
+	t.Run("PreBuildVeto", func(t *testing.T) {
+		ctx := testcontext.New(t)
+		dir := backendtest.NewStoreDirectory(t)
+		drvPath, exportBuffer := newDerivation(t, dir)
+		hook := writeHook(t, `echo "Refusing to build $DRV_PATH"`+"\nexit 1\n")
+
+		_, client, err := backendtest.NewServer(ctx, t, dir, &backendtest.Options{
+			TempDir: t.TempDir(),
+			Options: Options{
+				PreBuildHook: hook,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		codec, releaseCodec, err := storeCodec(ctx, client)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = codec.Export(nil, exportBuffer)
+		releaseCodec()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		realizeResponse := new(zbstorerpc.RealizeResponse)
+		err = jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, realizeResponse, &zbstorerpc.RealizeRequest{
+			DrvPaths: []zbstore.Path{drvPath},
+		})
+		if err != nil {
+			t.Fatal("RPC error:", err)
+		}
+		got, err := backendtest.WaitForBuild(ctx, client, realizeResponse.BuildID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Status != zbstorerpc.BuildFail {
+			t.Errorf("build status = %q; want %q", got.Status, zbstorerpc.BuildFail)
+		}
+		if gotLog, err := backendtest.ReadLog(ctx, client, realizeResponse.BuildID, drvPath); err != nil {
+			t.Error(err)
+		} else if want := "Refusing to build " + string(drvPath) + "\n"; !bytes.Contains(gotLog, []byte(want)) {
+			t.Errorf("Log does not contain phrase %q. Full output:\n%s", want, gotLog)
+		}
+	})
+}
+
+func TestRealizeFetchURL(t *testing.T) {
+	ctx := testcontext.New(t)
+	dir := backendtest.NewStoreDirectory(t)
+
+	const fileContent = "Hello, World!\n"
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hello.txt", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "hello.txt", time.Time{}, strings.NewReader(fileContent))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	exportBuffer := new(bytes.Buffer)
+	exporter := zbstore.NewExportWriter(exportBuffer)
+	const wantOutputName = "hello.txt"
+	wantOutputCA := nix.FlatFileContentAddress(mustParseHash(t, "sha256:c98c24b677eff44860afea6f493bbaec5bb1c4cbb209c6fc2bbb47f66ff2ad31"))
+	drvContent := &zbstore.Derivation{
+		Name:    wantOutputName,
+		Dir:     dir,
+		Builder: "builtin:fetchurl",
+		System:  "builtin",
+		Env: map[string]string{
+			"url": string(srv.URL + "/hello.txt"),
+			"out": zbstore.HashPlaceholder("out"),
+		},
+		Outputs: map[string]*zbstore.DerivationOutputType{
+			zbstore.DefaultDerivationOutputName: zbstore.FixedCAOutput(wantOutputCA),
+		},
+	}
+	drvPath, _, err := storetest.ExportDerivation(exporter, drvContent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exporter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, client, err := backendtest.NewServer(ctx, t, dir, &backendtest.Options{
+		TempDir: t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	codec, releaseCodec, err := storeCodec(ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = codec.Export(nil, exportBuffer)
+	releaseCodec()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	realizeResponse := new(zbstorerpc.RealizeResponse)
+	err = jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, realizeResponse, &zbstorerpc.RealizeRequest{
+		DrvPaths: []zbstore.Path{drvPath},
+	})
+	if err != nil {
+		t.Fatal("build drv:", err)
+	}
+	got, err := backendtest.WaitForSuccessfulBuild(ctx, client, realizeResponse.BuildID)
+	if err != nil {
+		gotLog, _ := backendtest.ReadLog(ctx, client, realizeResponse.BuildID, drvPath)
+		t.Fatalf("build drv: %v\nlog:\n%s", err, gotLog)
+	}
+
+	wantOutputPath, err := zbstore.FixedCAOutputPath(dir, wantOutputName, wantOutputCA, zbstore.References{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSingleFileOutput(t, drvPath, wantOutputPath, []byte(fileContent), got)
+}
+
+func TestRealizeFetchURLOffline(t *testing.T) {
+	ctx := testcontext.New(t)
+	dir := backendtest.NewStoreDirectory(t)
+
+	var requested atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested.Store(true)
+		http.ServeContent(w, r, "hello.txt", time.Time{}, strings.NewReader("Hello, World!\n"))
+	}))
+	defer srv.Close()
+
+	exportBuffer := new(bytes.Buffer)
+	exporter := zbstore.NewExportWriter(exportBuffer)
+	url := srv.URL + "/hello.txt"
+	drvContent := &zbstore.Derivation{
+		Name:    "hello.txt",
+		Dir:     dir,
+		Builder: "builtin:fetchurl",
+		System:  "builtin",
+		Env: map[string]string{
+			"url": url,
+			"out": zbstore.HashPlaceholder("out"),
+		},
+		Outputs: map[string]*zbstore.DerivationOutputType{
+			zbstore.DefaultDerivationOutputName: zbstore.FixedCAOutput(
+				nix.FlatFileContentAddress(mustParseHash(t, "sha256:c98c24b677eff44860afea6f493bbaec5bb1c4cbb209c6fc2bbb47f66ff2ad31")),
+			),
+		},
+	}
+	drvPath, _, err := storetest.ExportDerivation(exporter, drvContent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exporter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, client, err := backendtest.NewServer(ctx, t, dir, &backendtest.Options{
+		TempDir: t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	codec, releaseCodec, err := storeCodec(ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = codec.Export(nil, exportBuffer)
+	releaseCodec()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	realizeResponse := new(zbstorerpc.RealizeResponse)
+	err = jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, realizeResponse, &zbstorerpc.RealizeRequest{
+		DrvPaths: []zbstore.Path{drvPath},
+		Offline:  true,
+	})
+	if err != nil {
+		t.Fatal("build drv:", err)
+	}
+	got, err := backendtest.WaitForBuild(ctx, client, realizeResponse.BuildID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != zbstorerpc.BuildFail {
+		t.Errorf("build status = %q; want %q", got.Status, zbstorerpc.BuildFail)
+	}
+	if requested.Load() {
+		t.Error("server received a request")
+	}
+	gotLog, err := backendtest.ReadLog(ctx, client, realizeResponse.BuildID, drvPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(gotLog, []byte(url)) {
+		t.Errorf("log does not mention %s:\n%s", url, gotLog)
+	}
+}
+
+func TestRealizeFetchURLCredentials(t *testing.T) {
+	ctx := testcontext.New(t)
+	dir := backendtest.NewStoreDirectory(t)
+
+	const fileContent = "Hello, World!\n"
+	const token = "s3cret"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got, want := r.Header.Get("Authorization"), "Bearer "+token; got != want {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		http.ServeContent(w, r, "hello.txt", time.Time{}, strings.NewReader(fileContent))
+	}))
+	defer srv.Close()
+	srvURL, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exportBuffer := new(bytes.Buffer)
+	exporter := zbstore.NewExportWriter(exportBuffer)
+	const wantOutputName = "hello.txt"
+	wantOutputCA := nix.FlatFileContentAddress(mustParseHash(t, "sha256:c98c24b677eff44860afea6f493bbaec5bb1c4cbb209c6fc2bbb47f66ff2ad31"))
+	drvContent := &zbstore.Derivation{
+		Name:    wantOutputName,
+		Dir:     dir,
+		Builder: "builtin:fetchurl",
+		System:  "builtin",
+		Env: map[string]string{
+			"url": srv.URL + "/hello.txt",
+			"out": zbstore.HashPlaceholder("out"),
+		},
+		Outputs: map[string]*zbstore.DerivationOutputType{
+			zbstore.DefaultDerivationOutputName: zbstore.FixedCAOutput(wantOutputCA),
+		},
+	}
+	drvPath, _, err := storetest.ExportDerivation(exporter, drvContent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exporter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, client, err := backendtest.NewServer(ctx, t, dir, &backendtest.Options{
+		TempDir: t.TempDir(),
+		Options: Options{
+			FetchCredentials: credentials.Environment{
+				Getenv: func(key string) string {
+					if key == credentials.TokenEnvVar(srvURL.Host) {
+						return token
+					}
+					return ""
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	codec, releaseCodec, err := storeCodec(ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = codec.Export(nil, exportBuffer)
+	releaseCodec()
+	if err != nil {
+		t.Fatal(err)
+	}
+
 	realizeResponse := new(zbstorerpc.RealizeResponse)
 	err = jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, realizeResponse, &zbstorerpc.RealizeRequest{
 		DrvPaths: []zbstore.Path{drvPath},
@@ -1021,91 +3252,78 @@ func TestRealizeNoOutput(t *testing.T) {
 	if err != nil {
 		t.Fatal("build drv:", err)
 	}
-	got, err := backendtest.WaitForBuild(ctx, client, realizeResponse.BuildID)
+	got, err := backendtest.WaitForSuccessfulBuild(ctx, client, realizeResponse.BuildID)
+	gotLog, _ := backendtest.ReadLog(ctx, client, realizeResponse.BuildID, drvPath)
 	if err != nil {
-		t.Fatal("build drv:", err)
-	}
-	want := &zbstorerpc.Build{
-		ID:     realizeResponse.BuildID,
-		Status: zbstorerpc.BuildFail,
-		Results: []*zbstorerpc.BuildResult{
-			{
-				DrvPath: drvPath,
-				Status:  zbstorerpc.BuildFail,
-				Outputs: []*zbstorerpc.RealizeOutput{
-					{
-						Name: zbstore.DefaultDerivationOutputName,
-					},
-				},
-			},
-		},
-	}
-	diff := cmp.Diff(
-		want, got,
-		cmp.FilterPath(
-			func(p cmp.Path) bool {
-				return isFieldAnyOf[zbstorerpc.Build](p, "StartedAt", "EndedAt")
-			},
-			cmp.Ignore(),
-		),
-		buildResultOption,
-		ignoreDrvHashOption,
-	)
-	if diff != "" {
-		t.Errorf("build (-want +got):\n%s", diff)
+		t.Fatalf("build drv: %v\nlog:\n%s", err, gotLog)
 	}
-	if !got.EndedAt.Valid {
-		t.Error("build.endedAt = null")
+	if bytes.Contains(gotLog, []byte(token)) {
+		t.Errorf("log contains token:\n%s", gotLog)
 	}
-	// Ensure that the build didn't leave files in the store.
-	storeListing, err := os.ReadDir(string(dir))
+
+	wantOutputPath, err := zbstore.FixedCAOutputPath(dir, wantOutputName, wantOutputCA, zbstore.References{})
 	if err != nil {
 		t.Fatal(err)
 	}
-	for _, ent := range storeListing {
-		name := ent.Name()
-		if name != drvPath.Base() {
-			t.Errorf("unknown object %s left in store", name)
-		}
-	}
-
-	if gotLog, err := backendtest.ReadLog(ctx, client, realizeResponse.BuildID, drvPath); err != nil {
-		t.Error(err)
-	} else if want := "failed to produce output $out"; !bytes.Contains(gotLog, []byte(want)) {
-		t.Errorf("Log does not contain phrase %q. Full output:\n%s", want, gotLog)
-	}
+	checkSingleFileOutput(t, drvPath, wantOutputPath, []byte(fileContent), got)
 }
 
-func TestRealizeCores(t *testing.T) {
-	tests := []int{1, 2}
-	for _, n := range tests {
-		t.Run(fmt.Sprintf("N%d", n), func(t *testing.T) {
+// registerTestBuiltins registers the builtin builders used in tests.
+var registerTestBuiltins = sync.OnceFunc(func() {
+	zbbuiltin.Register("backend-test-write", func(ctx context.Context, inv *zbbuiltin.Invocation) error {
+		fmt.Fprintf(inv.Stdout, "Writing %s\n", inv.DerivationPath.Base())
+		out := inv.RealPath(string(inv.OutputPaths[zbstore.DefaultDerivationOutputName]))
+		return os.WriteFile(out, []byte(inv.Derivation.Env["content"]), 0o444)
+	})
+	zbbuiltin.Register("backend-test-panic", func(ctx context.Context, inv *zbbuiltin.Invocation) error {
+		panic("bork")
+	})
+})
+
+func TestRealizeRegisteredBuiltin(t *testing.T) {
+	registerTestBuiltins()
+
+	tests := []struct {
+		builder string
+		wantLog string
+		fail    bool
+	}{
+		{
+			builder: "builtin:backend-test-write",
+			wantLog: "Writing ",
+		},
+		{
+			builder: "builtin:backend-test-panic",
+			wantLog: "panic: bork",
+			fail:    true,
+		},
+		{
+			builder: "builtin:backend-test-missing",
+			fail:    true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(strings.TrimPrefix(test.builder, "builtin:"), func(t *testing.T) {
 			ctx := testcontext.New(t)
 			dir := backendtest.NewStoreDirectory(t)
 
 			exportBuffer := new(bytes.Buffer)
 			exporter := zbstore.NewExportWriter(exportBuffer)
-			const drvName = "cores.txt"
-			// Create a derivation that fails after creating its output.
-			drvContent := &zbstore.Derivation{
-				Name:   drvName,
-				Dir:    dir,
-				System: system.Current().String(),
+			const wantOutputName = "hello.txt"
+			const fileContent = "Hello, World!\n"
+			drvPath, _, err := storetest.ExportDerivation(exporter, &zbstore.Derivation{
+				Name:    wantOutputName,
+				Dir:     dir,
+				Builder: test.builder,
+				System:  "builtin",
 				Env: map[string]string{
-					"out": zbstore.HashPlaceholder("out"),
+					"content": fileContent,
+					"out":     zbstore.HashPlaceholder("out"),
 				},
 				Outputs: map[string]*zbstore.DerivationOutputType{
 					zbstore.DefaultDerivationOutputName: zbstore.RecursiveFileFloatingCAOutput(nix.SHA256),
 				},
-			}
-			if runtime.GOOS == "windows" {
-				drvContent.Builder = powershellPath
-				drvContent.Args = []string{"-Command", "\"${env:ZB_BUILD_CORES}`n\" | Out-File -NoNewline -Encoding ascii -FilePath ${env:out}"}
-			} else {
-				drvContent.Builder = shPath
-				drvContent.Args = []string{"-c", `echo "$ZB_BUILD_CORES" > "$out"`}
-			}
-			drvPath, _, err := storetest.ExportDerivation(exporter, drvContent)
+			})
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -1115,9 +3333,6 @@ func TestRealizeCores(t *testing.T) {
 
 			_, client, err := backendtest.NewServer(ctx, t, dir, &backendtest.Options{
 				TempDir: t.TempDir(),
-				Options: Options{
-					CoresPerBuild: n,
-				},
 			})
 			if err != nil {
 				t.Fatal(err)
@@ -1139,50 +3354,165 @@ func TestRealizeCores(t *testing.T) {
 			if err != nil {
 				t.Fatal("build drv:", err)
 			}
-			got, err := backendtest.WaitForSuccessfulBuild(ctx, client, realizeResponse.BuildID)
+			got, err := backendtest.WaitForBuild(ctx, client, realizeResponse.BuildID)
 			if err != nil {
-				gotLog, _ := backendtest.ReadLog(ctx, client, realizeResponse.BuildID, drvPath)
-				t.Fatalf("build drv: %v\nlog:\n%s", err, gotLog)
+				t.Fatal("build drv:", err)
 			}
-			wantOutputContent := fmt.Sprintf("%d\n", n)
-			wantOutputPath, err := singleFileOutputPath(dir, drvName, []byte(wantOutputContent), zbstore.References{})
+			gotLog, err := backendtest.ReadLog(ctx, client, realizeResponse.BuildID, drvPath)
+			if err != nil {
+				t.Error(err)
+			} else if !bytes.Contains(gotLog, []byte(test.wantLog)) {
+				t.Errorf("Log does not contain phrase %q. Full output:\n%s", test.wantLog, gotLog)
+			}
+
+			if test.fail {
+				if got.Status != zbstorerpc.BuildFail {
+					t.Errorf("build status = %q; want %q", got.Status, zbstorerpc.BuildFail)
+				}
+				return
+			}
+			if got.Status != zbstorerpc.BuildSuccess {
+				t.Fatalf("build status = %q; want %q", got.Status, zbstorerpc.BuildSuccess)
+			}
+			wantOutputPath, err := singleFileOutputPath(dir, wantOutputName, []byte(fileContent), zbstore.References{})
 			if err != nil {
 				t.Fatal(err)
 			}
-			checkSingleFileOutput(t, drvPath, wantOutputPath, []byte(wantOutputContent), got)
+			checkSingleFileOutput(t, drvPath, wantOutputPath, []byte(fileContent), got)
 		})
 	}
 }
 
-func TestRealizeFetchURL(t *testing.T) {
+func TestRealizeBuildTmpfs(t *testing.T) {
+	if runtime.GOOS != "linux" || os.Geteuid() != 0 {
+		t.Skip("tmpfs build directories require root on Linux")
+	}
 	ctx := testcontext.New(t)
 	dir := backendtest.NewStoreDirectory(t)
 
-	const fileContent = "Hello, World!\n"
-	mux := http.NewServeMux()
-	mux.HandleFunc("/hello.txt", func(w http.ResponseWriter, r *http.Request) {
-		http.ServeContent(w, r, "hello.txt", time.Time{}, strings.NewReader(fileContent))
+	// The builder reports the filesystem type of its working directory.
+	const script = `fs=disk
+wd=$(pwd -P)
+while read -r id parent dev root mnt opts rest; do
+	if [ "$mnt" = "$wd" ]; then
+		case "$rest" in
+			*"- tmpfs "*) fs=tmpfs ;;
+			*) fs=disk ;;
+		esac
+	fi
+done < /proc/self/mountinfo
+echo "$fs" > "$out"
+`
+	exportBuffer := new(bytes.Buffer)
+	exporter := zbstore.NewExportWriter(exportBuffer)
+	tests := []struct {
+		name        string
+		env         map[string]string
+		wantContent string
+	}{
+		{name: "Default", wantContent: "tmpfs\n"},
+		{name: "OptOut", env: map[string]string{"__buildTmpfs": "0"}, wantContent: "disk\n"},
+	}
+	drvPaths := make([]zbstore.Path, len(tests))
+	for i, test := range tests {
+		drvContent := &zbstore.Derivation{
+			Name:    "fs-" + strings.ToLower(test.name) + ".txt",
+			Dir:     dir,
+			System:  system.Current().String(),
+			Builder: shPath,
+			Args:    []string{"-c", script},
+			Env: map[string]string{
+				"out": zbstore.HashPlaceholder("out"),
+			},
+			Outputs: map[string]*zbstore.DerivationOutputType{
+				zbstore.DefaultDerivationOutputName: zbstore.RecursiveFileFloatingCAOutput(nix.SHA256),
+			},
+		}
+		maps.Copy(drvContent.Env, test.env)
+		var err error
+		drvPaths[i], _, err = storetest.ExportDerivation(exporter, drvContent)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := exporter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, client, err := backendtest.NewServer(ctx, t, dir, &backendtest.Options{
+		TempDir: t.TempDir(),
+		Options: Options{
+			BuildTmpfsSize: 16 << 20,
+		},
 	})
-	srv := httptest.NewServer(mux)
-	defer srv.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	codec, releaseCodec, err := storeCodec(ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = codec.Export(nil, exportBuffer)
+	releaseCodec()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			drvPath := drvPaths[i]
+			realizeResponse := new(zbstorerpc.RealizeResponse)
+			err := jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, realizeResponse, &zbstorerpc.RealizeRequest{
+				DrvPaths: []zbstore.Path{drvPath},
+			})
+			if err != nil {
+				t.Fatal("RPC error:", err)
+			}
+			got, err := backendtest.WaitForSuccessfulBuild(ctx, client, realizeResponse.BuildID)
+			if err != nil {
+				gotLog, _ := backendtest.ReadLog(ctx, client, realizeResponse.BuildID, drvPath)
+				t.Fatalf("build failed: %v\nlog:\n%s", err, gotLog)
+			}
+			name, _ := drvPath.DerivationName()
+			wantOutputPath, err := singleFileOutputPath(dir, name, []byte(test.wantContent), zbstore.References{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkSingleFileOutput(t, drvPath, wantOutputPath, []byte(test.wantContent), got)
+		})
+	}
+}
+
+func TestRealizeOutputQuota(t *testing.T) {
+	ctx := testcontext.New(t)
+	dir := backendtest.NewStoreDirectory(t)
 
+	const inputContent = "Hello, World!\n"
 	exportBuffer := new(bytes.Buffer)
 	exporter := zbstore.NewExportWriter(exportBuffer)
-	const wantOutputName = "hello.txt"
-	wantOutputCA := nix.FlatFileContentAddress(mustParseHash(t, "sha256:c98c24b677eff44860afea6f493bbaec5bb1c4cbb209c6fc2bbb47f66ff2ad31"))
+	inputFilePath, _, err := storetest.ExportSourceFile(exporter, []byte(inputContent), storetest.SourceExportOptions{
+		Name:      "hello.txt",
+		Directory: dir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
 	drvContent := &zbstore.Derivation{
-		Name:    wantOutputName,
-		Dir:     dir,
-		Builder: "builtin:fetchurl",
-		System:  "builtin",
+		Name:   "hello2.txt",
+		Dir:    dir,
+		System: system.Current().String(),
 		Env: map[string]string{
-			"url": string(srv.URL + "/hello.txt"),
+			"in":  string(inputFilePath),
 			"out": zbstore.HashPlaceholder("out"),
 		},
+		InputSources: *sets.NewSorted(
+			inputFilePath,
+		),
 		Outputs: map[string]*zbstore.DerivationOutputType{
-			zbstore.DefaultDerivationOutputName: zbstore.FixedCAOutput(wantOutputCA),
+			zbstore.DefaultDerivationOutputName: zbstore.RecursiveFileFloatingCAOutput(nix.SHA256),
 		},
 	}
+	drvContent.Builder, drvContent.Args = catcatBuilder()
 	drvPath, _, err := storetest.ExportDerivation(exporter, drvContent)
 	if err != nil {
 		t.Fatal(err)
@@ -1193,6 +3523,10 @@ func TestRealizeFetchURL(t *testing.T) {
 
 	_, client, err := backendtest.NewServer(ctx, t, dir, &backendtest.Options{
 		TempDir: t.TempDir(),
+		Options: Options{
+			// Smaller than the NAR of the output.
+			MaxOutputSize: 64,
+		},
 	})
 	if err != nil {
 		t.Fatal(err)
@@ -1212,19 +3546,120 @@ func TestRealizeFetchURL(t *testing.T) {
 		DrvPaths: []zbstore.Path{drvPath},
 	})
 	if err != nil {
-		t.Fatal("build drv:", err)
+		t.Fatal("RPC error:", err)
 	}
-	got, err := backendtest.WaitForSuccessfulBuild(ctx, client, realizeResponse.BuildID)
+	got, err := backendtest.WaitForBuild(ctx, client, realizeResponse.BuildID)
 	if err != nil {
-		gotLog, _ := backendtest.ReadLog(ctx, client, realizeResponse.BuildID, drvPath)
-		t.Fatalf("build drv: %v\nlog:\n%s", err, gotLog)
+		t.Fatal(err)
+	}
+	if got.Status != zbstorerpc.BuildFail {
+		t.Errorf("build status = %q; want %q", got.Status, zbstorerpc.BuildFail)
+	}
+	if result, err := got.ResultForPath(drvPath); err != nil {
+		t.Error(err)
+	} else if output, err := result.OutputForName(zbstore.DefaultDerivationOutputName); err == nil && output.Path.Valid {
+		t.Errorf("build produced %s", output.Path.X)
+	}
+	if gotLog, err := backendtest.ReadLog(ctx, client, realizeResponse.BuildID, drvPath); err != nil {
+		t.Error(err)
+	} else if !bytes.Contains(gotLog, []byte("quota")) {
+		t.Errorf("build log does not mention quota:\n%s", gotLog)
 	}
+}
 
-	wantOutputPath, err := zbstore.FixedCAOutputPath(dir, wantOutputName, wantOutputCA, zbstore.References{})
-	if err != nil {
-		t.Fatal(err)
+func TestRealizeRequiredSystemFeatures(t *testing.T) {
+	tests := []struct {
+		name           string
+		systemFeatures []string
+		wantStatus     zbstorerpc.BuildStatus
+	}{
+		{
+			name:           "Supported",
+			systemFeatures: []string{"benchmark", "kvm"},
+			wantStatus:     zbstorerpc.BuildSuccess,
+		},
+		{
+			name:           "Missing",
+			systemFeatures: []string{"benchmark"},
+			wantStatus:     zbstorerpc.BuildError,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := testcontext.New(t)
+			dir := backendtest.NewStoreDirectory(t)
+
+			const inputContent = "Hello, World!\n"
+			exportBuffer := new(bytes.Buffer)
+			exporter := zbstore.NewExportWriter(exportBuffer)
+			inputFilePath, _, err := storetest.ExportSourceFile(exporter, []byte(inputContent), storetest.SourceExportOptions{
+				Name:      "hello.txt",
+				Directory: dir,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			drvContent := &zbstore.Derivation{
+				Name:   "hello2.txt",
+				Dir:    dir,
+				System: system.Current().String(),
+				Env: map[string]string{
+					"in":                       string(inputFilePath),
+					"out":                      zbstore.HashPlaceholder("out"),
+					"__requiredSystemFeatures": "kvm",
+				},
+				InputSources: *sets.NewSorted(
+					inputFilePath,
+				),
+				Outputs: map[string]*zbstore.DerivationOutputType{
+					zbstore.DefaultDerivationOutputName: zbstore.RecursiveFileFloatingCAOutput(nix.SHA256),
+				},
+			}
+			drvContent.Builder, drvContent.Args = catcatBuilder()
+			drvPath, _, err := storetest.ExportDerivation(exporter, drvContent)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := exporter.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			_, client, err := backendtest.NewServer(ctx, t, dir, &backendtest.Options{
+				TempDir: t.TempDir(),
+				Options: Options{
+					SystemFeatures: test.systemFeatures,
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			codec, releaseCodec, err := storeCodec(ctx, client)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = codec.Export(nil, exportBuffer)
+			releaseCodec()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			realizeResponse := new(zbstorerpc.RealizeResponse)
+			err = jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, realizeResponse, &zbstorerpc.RealizeRequest{
+				DrvPaths: []zbstore.Path{drvPath},
+			})
+			if err != nil {
+				t.Fatal("RPC error:", err)
+			}
+			got, err := backendtest.WaitForBuild(ctx, client, realizeResponse.BuildID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Status != test.wantStatus {
+				gotLog, _ := backendtest.ReadLog(ctx, client, realizeResponse.BuildID, drvPath)
+				t.Errorf("build status = %q; want %q\nlog:\n%s", got.Status, test.wantStatus, gotLog)
+			}
+		})
 	}
-	checkSingleFileOutput(t, drvPath, wantOutputPath, []byte(fileContent), got)
 }
 
 func TestRealizeSignature(t *testing.T) {
@@ -1367,6 +3802,146 @@ func TestRealizeSignature(t *testing.T) {
 	}
 }
 
+func TestRealizeAttestation(t *testing.T) {
+	ctx := testcontext.New(t)
+	dir := backendtest.NewStoreDirectory(t)
+	testKey := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+
+	const inputContent = "Hello, World!\n"
+	exportBuffer := new(bytes.Buffer)
+	exporter := zbstore.NewExportWriter(exportBuffer)
+	inputFilePath, _, err := storetest.ExportSourceFile(exporter, []byte(inputContent), storetest.SourceExportOptions{
+		Name:      "hello.txt",
+		Directory: dir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	drvContent := &zbstore.Derivation{
+		Name:   "hello2.txt",
+		Dir:    dir,
+		System: system.Current().String(),
+		Env: map[string]string{
+			"in":  string(inputFilePath),
+			"out": zbstore.HashPlaceholder("out"),
+		},
+		InputSources: *sets.NewSorted(
+			inputFilePath,
+		),
+		Outputs: map[string]*zbstore.DerivationOutputType{
+			zbstore.DefaultDerivationOutputName: zbstore.RecursiveFileFloatingCAOutput(nix.SHA256),
+		},
+	}
+	drvContent.Builder, drvContent.Args = catcatBuilder()
+	drvPath, _, err := storetest.ExportDerivation(exporter, drvContent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exporter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	const builderID = "https://example.com/builder"
+	_, client, err := backendtest.NewServer(ctx, t, dir, &backendtest.Options{
+		TempDir: t.TempDir(),
+		Options: Options{
+			Keyring: &Keyring{
+				Ed25519: []ed25519.PrivateKey{testKey},
+			},
+			BuilderID: builderID,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	codec, releaseCodec, err := storeCodec(ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = codec.Export(nil, exportBuffer)
+	releaseCodec()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	realizeResponse := new(zbstorerpc.RealizeResponse)
+	err = jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, realizeResponse, &zbstorerpc.RealizeRequest{
+		DrvPaths: []zbstore.Path{drvPath},
+	})
+	if err != nil {
+		t.Fatal("RPC error:", err)
+	}
+	got, err := backendtest.WaitForSuccessfulBuild(ctx, client, realizeResponse.BuildID)
+	if err != nil {
+		gotLog, _ := backendtest.ReadLog(ctx, client, realizeResponse.BuildID, drvPath)
+		t.Fatalf("build drv: %v\nlog:\n%s", err, gotLog)
+	}
+	outPath, err := got.FindRealizeOutput(zbstore.OutputReference{
+		DrvPath:    drvPath,
+		OutputName: zbstore.DefaultDerivationOutputName,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	infoResponse := new(zbstorerpc.InfoResponse)
+	err = jsonrpc.Do(ctx, client, zbstorerpc.InfoMethod, infoResponse, &zbstorerpc.InfoRequest{
+		Path: outPath.X,
+	})
+	if err != nil {
+		t.Fatal("RPC error:", err)
+	}
+	if infoResponse.Info == nil {
+		t.Fatalf("%s does not exist", outPath.X)
+	}
+
+	attestationsResponse := new(zbstorerpc.AttestationsResponse)
+	err = jsonrpc.Do(ctx, client, zbstorerpc.AttestationsMethod, attestationsResponse, &zbstorerpc.AttestationsRequest{
+		Path: outPath.X,
+	})
+	if err != nil {
+		t.Fatal("RPC error:", err)
+	}
+	if len(attestationsResponse.Attestations) != 1 {
+		t.Fatalf("got %d attestations; want 1", len(attestationsResponse.Attestations))
+	}
+	env := attestationsResponse.Attestations[0]
+	signers, err := env.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantSigners := []*zbstore.RealizationPublicKey{{
+		Format: zbstore.Ed25519SignatureFormat,
+		Data:   testKey.Public().(ed25519.PublicKey),
+	}}
+	if diff := cmp.Diff(wantSigners, signers); diff != "" {
+		t.Errorf("signers (-want +got):\n%s", diff)
+	}
+	stmt, err := env.Statement()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stmt.Subject) != 1 || stmt.Subject[0].Name != string(outPath.X) || !stmt.Subject[0].MatchesNARHash(infoResponse.Info.NARHash) {
+		t.Errorf("subject = %+v; want %s with NAR hash %v", stmt.Subject, outPath.X, infoResponse.Info.NARHash)
+	}
+	if got, want := stmt.PredicateType, zbstore.SLSAProvenancePredicateType; got != want {
+		t.Errorf("predicateType = %q; want %q", got, want)
+	}
+	if got := stmt.Predicate.BuildDefinition.ExternalParameters.DerivationPath; got != drvPath {
+		t.Errorf("externalParameters.derivationPath = %s; want %s", got, drvPath)
+	}
+	if got := stmt.Predicate.RunDetails.Builder.ID; got != builderID {
+		t.Errorf("runDetails.builder.id = %q; want %q", got, builderID)
+	}
+	var gotDeps []string
+	for _, dep := range stmt.Predicate.BuildDefinition.ResolvedDependencies {
+		gotDeps = append(gotDeps, dep.Name)
+	}
+	wantDeps := []string{string(drvPath), string(inputFilePath)}
+	if diff := cmp.Diff(wantDeps, gotDeps); diff != "" {
+		t.Errorf("resolved dependencies (-want +got):\n%s", diff)
+	}
+}
+
 func TestRealizeSingleDerivationFallback(t *testing.T) {
 	ctx := testcontext.New(t)
 	dir := backendtest.NewStoreDirectory(t)
@@ -1471,6 +4046,9 @@ func TestRealizeSingleDerivationFallback(t *testing.T) {
 	}
 
 	checkSingleFileOutput(t, drvPath, wantOutputPath, []byte(wantOutputContent), got)
+	checkOutputProvenance(t, got, drvPath, zbstore.DefaultDerivationOutputName, &zbstorerpc.OutputProvenance{
+		Method: zbstorerpc.ProvenanceSubstituted,
+	})
 }
 
 func TestRealizeWithImproperlyNamedFallback(t *testing.T) {
@@ -2037,6 +4615,193 @@ func TestRealizeMultiStepFallbackMissingObject(t *testing.T) {
 	checkSingleFileOutput(t, drv2Path, wantOutputPath2, []byte(wantOutputContent2), got)
 }
 
+// TestRealizeMultiStepFallbackRecorded tests that a second build of drv2 depending on drv1
+// uses the realization recorded from the fallback store for drv1
+// without querying the fallback store again,
+// even though drv1's output store object was never downloaded.
+func TestRealizeMultiStepFallbackRecorded(t *testing.T) {
+	ctx := testcontext.New(t)
+	dir := backendtest.NewStoreDirectory(t)
+
+	const inputContent = "Hello, World!\n"
+	localExportBuffer := new(bytes.Buffer)
+	exporter := zbstore.NewExportWriter(localExportBuffer)
+	inputFilePath, _, err := storetest.ExportSourceFile(exporter, []byte(inputContent), storetest.SourceExportOptions{
+		Name:      "hello.txt",
+		Directory: dir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	drv1Content := &zbstore.Derivation{
+		Name:    "hello2.txt",
+		Dir:     dir,
+		Builder: "false", // Prevent from running.
+		System:  system.Current().String(),
+		Env: map[string]string{
+			"in":  string(inputFilePath),
+			"out": zbstore.HashPlaceholder("out"),
+		},
+		InputSources: *sets.NewSorted(
+			inputFilePath,
+		),
+		Outputs: map[string]*zbstore.DerivationOutputType{
+			zbstore.DefaultDerivationOutputName: zbstore.RecursiveFileFloatingCAOutput(nix.SHA256),
+		},
+	}
+	drv1Path, _, err := storetest.ExportDerivation(exporter, drv1Content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	drv2Content := &zbstore.Derivation{
+		Name:    "hello4.txt",
+		Dir:     dir,
+		Builder: "false", // Prevent from running.
+		System:  system.Current().String(),
+		Env: map[string]string{
+			"in": zbstore.UnknownCAOutputPlaceholder(zbstore.OutputReference{
+				DrvPath:    drv1Path,
+				OutputName: zbstore.DefaultDerivationOutputName,
+			}),
+			"out": zbstore.HashPlaceholder("out"),
+		},
+		InputDerivations: map[zbstore.Path]*sets.Sorted[string]{
+			drv1Path: sets.NewSorted(zbstore.DefaultDerivationOutputName),
+		},
+		Outputs: map[string]*zbstore.DerivationOutputType{
+			zbstore.DefaultDerivationOutputName: zbstore.RecursiveFileFloatingCAOutput(nix.SHA256),
+		},
+	}
+	drv2Path, _, err := storetest.ExportDerivation(exporter, drv2Content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exporter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	fallbackExportBuffer := new(bytes.Buffer)
+	exporter = zbstore.NewExportWriter(fallbackExportBuffer)
+	const wantOutputContent1 = inputContent + inputContent
+	// The intermediate store object is deliberately absent from the fallback store.
+	wantOutputPath1, err := singleFileOutputPath(dir, drv1Content.Name, []byte(wantOutputContent1), zbstore.References{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const wantOutputContent2 = wantOutputContent1 + wantOutputContent1
+	wantOutputPath2, _, err := storetest.ExportSourceFile(exporter, []byte(wantOutputContent2), storetest.SourceExportOptions{
+		Name:      drv2Content.Name,
+		Directory: dir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exporter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	fallback := new(countingFallback)
+	if err := fallback.StoreImport(ctx, fallbackExportBuffer); err != nil {
+		t.Fatal(err)
+	}
+	drv1Hash, err := drv1Content.SHA256RealizationHash(func(ref zbstore.OutputReference) (zbstore.Path, bool) {
+		return "", false
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	drv2Hash, err := drv2Content.SHA256RealizationHash(func(ref zbstore.OutputReference) (zbstore.Path, bool) {
+		switch {
+		case ref.DrvPath == drv1Path && ref.OutputName == zbstore.DefaultDerivationOutputName:
+			return wantOutputPath1, true
+		}
+		return "", false
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testKey := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	var trustedKey *zbstore.RealizationPublicKey
+	for _, r := range []struct {
+		drvHash nix.Hash
+		path    zbstore.Path
+	}{
+		{drv1Hash, wantOutputPath1},
+		{drv2Hash, wantOutputPath2},
+	} {
+		ref := zbstore.RealizationOutputReference{
+			DerivationHash: r.drvHash,
+			OutputName:     zbstore.DefaultDerivationOutputName,
+		}
+		realization := &zbstore.Realization{OutputPath: r.path}
+		sig, err := zbstore.SignRealizationWithEd25519(ref, realization, testKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		realization.Signatures = append(realization.Signatures, sig)
+		trustedKey = &sig.PublicKey
+		fallback.AddRealization(ref, realization)
+	}
+
+	_, client, err := backendtest.NewServer(ctx, t, dir, &backendtest.Options{
+		TempDir: t.TempDir(),
+		Options: Options{
+			Fallback: fallback,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	codec, releaseCodec, err := storeCodec(ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = codec.Export(nil, localExportBuffer)
+	releaseCodec()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trustKey := &zbstorerpc.ReusePolicy{PublicKeys: []*zbstore.RealizationPublicKey{trustedKey}}
+	realize := func(t *testing.T, reuse *zbstorerpc.ReusePolicy, offline bool) {
+		t.Helper()
+		realizeResponse := new(zbstorerpc.RealizeResponse)
+		err := jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, realizeResponse, &zbstorerpc.RealizeRequest{
+			DrvPaths: []zbstore.Path{drv2Path},
+			Reuse:    reuse,
+			Offline:  offline,
+		})
+		if err != nil {
+			t.Fatal("RPC error:", err)
+		}
+		got, err := backendtest.WaitForSuccessfulBuild(ctx, client, realizeResponse.BuildID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkSingleFileOutput(t, drv2Path, wantOutputPath2, []byte(wantOutputContent2), got)
+	}
+
+	realize(t, trustKey, false)
+	firstFetches := fallback.fetches.Load()
+	if firstFetches == 0 {
+		t.Fatal("first build did not query fallback store")
+	}
+
+	realize(t, trustKey, false)
+	if got := fallback.fetches.Load(); got != firstFetches {
+		t.Errorf("second build queried fallback store %d times; want 0", got-firstFetches)
+	}
+
+	// The recorded realization for drv1 is enough to plan without the network.
+	realize(t, trustKey, true)
+
+	// Reusing all realizations does not trust recorded realizations for planning,
+	// so the fallback store is asked again.
+	realize(t, &zbstorerpc.ReusePolicy{All: true}, false)
+	if got := fallback.fetches.Load(); got == firstFetches {
+		t.Error("build reusing all realizations did not query fallback store")
+	}
+}
+
 func TestRealizeUpload(t *testing.T) {
 	ctx := testcontext.New(t)
 	dir := backendtest.NewStoreDirectory(t)
@@ -2201,9 +4966,12 @@ func TestRealizeUpload(t *testing.T) {
 
 var buildResultOption = cmp.Options{
 	cmp.FilterPath(func(p cmp.Path) bool {
-		return isFieldAnyOf[zbstorerpc.BuildResult](p, "LogSize")
+		return isFieldAnyOf[zbstorerpc.BuildResult](p, "LogSize", "RebuildReason", "Schedule")
 	}, cmp.Ignore()),
 	cmp.FilterPath(isRealizeOutputSignaturesField, cmpopts.EquateEmpty()),
+	cmp.FilterPath(func(p cmp.Path) bool {
+		return isFieldAnyOf[zbstorerpc.RealizeOutput](p, "Provenance")
+	}, cmp.Ignore()),
 }
 
 var ignoreDrvHashOption = cmp.FilterPath(func(p cmp.Path) bool {
@@ -2277,6 +5045,26 @@ func checkSingleFileOutput(tb testing.TB, drvPath, wantOutputPath zbstore.Path,
 	}
 }
 
+// checkOutputProvenance verifies that the named output of drvPath in resp
+// has the given provenance.
+func checkOutputProvenance(tb testing.TB, resp *zbstorerpc.Build, drvPath zbstore.Path, outputName string, want *zbstorerpc.OutputProvenance) {
+	tb.Helper()
+
+	result, err := resp.ResultForPath(drvPath)
+	if err != nil {
+		tb.Error(err)
+		return
+	}
+	output, err := result.OutputForName(outputName)
+	if err != nil {
+		tb.Errorf("%s: %v", drvPath, err)
+		return
+	}
+	if diff := cmp.Diff(want, output.Provenance); diff != "" {
+		tb.Errorf("%s output %s provenance (-want +got):\n%s", drvPath, outputName, diff)
+	}
+}
+
 // catcatBuilder returns a builder that writes $in twice to $out
 // with no dependencies other than the system shell.
 // As a side-effect, it echoes "catcat" to its log to signal its execution.
@@ -41,6 +41,16 @@ func sysProcAttrForUser(user *BuildUser) *syscall.SysProcAttr {
 	}
 }
 
+// runCommand starts c and waits for it to complete.
+// If enforceUmask is true, then c starts with a umask of 022
+// without changing the umask of the server process (see [startCommand]).
+func runCommand(c *exec.Cmd, enforceUmask bool) error {
+	if err := startCommand(c, enforceUmask); err != nil {
+		return err
+	}
+	return c.Wait()
+}
+
 func setCancelFunc(c *exec.Cmd) {
 	c.Cancel = func() error {
 		return c.Process.Signal(unix.SIGTERM)
@@ -5,6 +5,7 @@ package backend
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os/exec"
 	"strconv"
@@ -29,6 +30,12 @@ func sysProcAttrForUser(user *BuildUser) *syscall.SysProcAttr {
 	return nil
 }
 
+// runCommand starts c and waits for it to complete.
+// Windows does not have a umask, so enforceUmask is ignored.
+func runCommand(c *exec.Cmd, enforceUmask bool) error {
+	return c.Run()
+}
+
 func setCancelFunc(c *exec.Cmd) {
 	// Default behavior of exec.CommandContext is fine, no-op.
 }
@@ -40,3 +47,15 @@ func defaultSystemCertFile() (string, error) {
 func runSandboxed(ctx context.Context, invocation *builderInvocation) error {
 	return fmt.Errorf("TODO(someday)")
 }
+
+func mountBuildTmpfs(dir string, size int64) error {
+	return fmt.Errorf("mount tmpfs at %s: %w", dir, errors.ErrUnsupported)
+}
+
+func canSandboxRootless() bool {
+	return false
+}
+
+func runSandboxHelper() int {
+	return 1
+}
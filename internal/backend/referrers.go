@@ -0,0 +1,104 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package backend
+
+import (
+	"context"
+	"fmt"
+
+	jsonv2 "github.com/go-json-experiment/json"
+	"zb.256lights.llc/pkg/internal/jsonrpc"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+	"zb.256lights.llc/pkg/zbstore"
+	"zombiezen.com/go/log"
+	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+func (s *Server) referrers(ctx context.Context, req *jsonrpc.Request) (*jsonrpc.Response, error) {
+	var args zbstorerpc.ReferrersRequest
+	if err := jsonv2.Unmarshal(req.Params, &args); err != nil {
+		return nil, jsonrpc.Error(jsonrpc.InvalidParams, err)
+	}
+	resp := &zbstorerpc.ReferrersResponse{
+		Referrers:    []zbstore.Path{},
+		Realizations: []*zbstore.RealizationMap{},
+	}
+	if args.Path.Dir() != s.dir {
+		return marshalResponse(resp)
+	}
+
+	conn, err := s.db.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer s.db.Put(conn)
+
+	log.Debugf(ctx, "Looking up referrers of %s (recursive=%t)...", args.Path, args.Recursive)
+	if err := findReferrers(conn, resp, args.Path, args.Recursive); err != nil {
+		return nil, err
+	}
+	return marshalResponse(resp)
+}
+
+// findReferrers appends the store objects and realizations
+// that reference path to resp.
+func findReferrers(conn *sqlite.Conn, resp *zbstorerpc.ReferrersResponse, path zbstore.Path, recursive bool) (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("find referrers of %s: %v", path, err)
+		}
+	}()
+	defer sqlitex.Save(conn)(&err)
+
+	named := map[string]any{
+		":path":      string(path),
+		":recursive": recursive,
+	}
+	err = sqlitex.ExecuteTransientFS(conn, sqlFiles(), "referrers/objects.sql", &sqlitex.ExecOptions{
+		Named: named,
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			referrer, err := zbstore.ParsePath(stmt.GetText("path"))
+			if err != nil {
+				return err
+			}
+			resp.Referrers = append(resp.Referrers, referrer)
+			return nil
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	var curr *zbstore.RealizationMap
+	err = sqlitex.ExecuteTransientFS(conn, sqlFiles(), "referrers/realizations.sql", &sqlitex.ExecOptions{
+		Named: named,
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			drvHash, err := unmarshalHash(stmt.GetText("drv_hash_algorithm"), readBlob(stmt, "drv_hash_bits"))
+			if err != nil {
+				return err
+			}
+			outputPath, err := zbstore.ParsePath(stmt.GetText("output_path"))
+			if err != nil {
+				return err
+			}
+			if curr == nil || !curr.DerivationHash.Equal(drvHash) {
+				curr = &zbstore.RealizationMap{
+					DerivationHash: drvHash,
+					Realizations:   make(map[string][]*zbstore.Realization),
+				}
+				resp.Realizations = append(resp.Realizations, curr)
+			}
+			outputName := stmt.GetText("output_name")
+			curr.Realizations[outputName] = append(curr.Realizations[outputName], &zbstore.Realization{
+				OutputPath: outputPath,
+			})
+			return nil
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package backend_test
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"zb.256lights.llc/pkg/internal/backendtest"
+	"zb.256lights.llc/pkg/internal/jsonrpc"
+	"zb.256lights.llc/pkg/internal/storetest"
+	"zb.256lights.llc/pkg/internal/system"
+	"zb.256lights.llc/pkg/internal/testcontext"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+	"zb.256lights.llc/pkg/sets"
+	"zb.256lights.llc/pkg/zbstore"
+	"zombiezen.com/go/nix"
+)
+
+func TestReferrers(t *testing.T) {
+	ctx := testcontext.New(t)
+	dir := backendtest.NewStoreDirectory(t)
+
+	exportBuffer := new(bytes.Buffer)
+	exporter := zbstore.NewExportWriter(exportBuffer)
+	basePath, _, err := storetest.ExportSourceFile(exporter, []byte("Hello, World!\n"), storetest.SourceExportOptions{
+		Name:      "hello.txt",
+		Directory: dir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrapperContent := string(basePath) + "\n"
+	wrapperPath, _, err := storetest.ExportSourceFile(exporter, []byte(wrapperContent), storetest.SourceExportOptions{
+		Name:      "wrapper.txt",
+		Directory: dir,
+		References: zbstore.References{
+			Others: *sets.NewSorted(basePath),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const outputName = "wrapper2.txt"
+	drvContent := &zbstore.Derivation{
+		Name:   outputName,
+		Dir:    dir,
+		System: system.Current().String(),
+		Env: map[string]string{
+			"in":  string(wrapperPath),
+			"out": zbstore.HashPlaceholder("out"),
+		},
+		InputSources: *sets.NewSorted(
+			wrapperPath,
+		),
+		Outputs: map[string]*zbstore.DerivationOutputType{
+			zbstore.DefaultDerivationOutputName: zbstore.RecursiveFileFloatingCAOutput(nix.SHA256),
+		},
+	}
+	drvContent.Builder, drvContent.Args = catcatBuilder()
+	drvPath, _, err := storetest.ExportDerivation(exporter, drvContent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exporter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	drvHash, err := drvContent.SHA256RealizationHash(func(ref zbstore.OutputReference) (zbstore.Path, bool) {
+		return "", false
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, client, err := backendtest.NewServer(ctx, t, dir, &backendtest.Options{
+		TempDir: t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	codec, releaseCodec, err := storeCodec(ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = codec.Export(nil, exportBuffer)
+	releaseCodec()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	realizeResponse := new(zbstorerpc.RealizeResponse)
+	err = jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, realizeResponse, &zbstorerpc.RealizeRequest{
+		DrvPaths: []zbstore.Path{drvPath},
+	})
+	if err != nil {
+		t.Fatal("RPC error:", err)
+	}
+	if _, err := backendtest.WaitForSuccessfulBuild(ctx, client, realizeResponse.BuildID); err != nil {
+		gotLog, _ := backendtest.ReadLog(ctx, client, realizeResponse.BuildID, drvPath)
+		t.Fatalf("build drv: %v\nlog:\n%s", err, gotLog)
+	}
+
+	outputPath, err := singleFileOutputPath(dir, outputName, []byte(wrapperContent+wrapperContent), zbstore.References{
+		Others: *sets.NewSorted(basePath),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	outputRealizations := []*zbstore.RealizationMap{{
+		DerivationHash: drvHash,
+		Realizations: map[string][]*zbstore.Realization{
+			zbstore.DefaultDerivationOutputName: {{OutputPath: outputPath}},
+		},
+	}}
+
+	tests := []struct {
+		name string
+		req  *zbstorerpc.ReferrersRequest
+		want *zbstorerpc.ReferrersResponse
+	}{
+		{
+			name: "Direct",
+			req:  &zbstorerpc.ReferrersRequest{Path: basePath},
+			want: &zbstorerpc.ReferrersResponse{
+				Referrers:    slices.Sorted(slices.Values([]zbstore.Path{wrapperPath, outputPath})),
+				Realizations: outputRealizations,
+			},
+		},
+		{
+			name: "Recursive",
+			req:  &zbstorerpc.ReferrersRequest{Path: basePath, Recursive: true},
+			want: &zbstorerpc.ReferrersResponse{
+				Referrers:    slices.Sorted(slices.Values([]zbstore.Path{wrapperPath, outputPath, drvPath})),
+				Realizations: outputRealizations,
+			},
+		},
+		{
+			name: "DerivationInput",
+			req:  &zbstorerpc.ReferrersRequest{Path: wrapperPath},
+			want: &zbstorerpc.ReferrersResponse{
+				Referrers:    []zbstore.Path{drvPath},
+				Realizations: []*zbstore.RealizationMap{},
+			},
+		},
+		{
+			name: "NoReferrers",
+			req:  &zbstorerpc.ReferrersRequest{Path: outputPath, Recursive: true},
+			want: &zbstorerpc.ReferrersResponse{
+				Referrers:    []zbstore.Path{},
+				Realizations: []*zbstore.RealizationMap{},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := new(zbstorerpc.ReferrersResponse)
+			if err := jsonrpc.Do(ctx, client, zbstorerpc.ReferrersMethod, got, test.req); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.want, got, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("%s(%+v) (-want +got):\n%s", zbstorerpc.ReferrersMethod, test.req, diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	jsonv2 "github.com/go-json-experiment/json"
+	"zb.256lights.llc/pkg/internal/jsonrpc"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+	"zb.256lights.llc/pkg/zbstore"
+	"zombiezen.com/go/log"
+	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+func (s *Server) addRoot(ctx context.Context, req *jsonrpc.Request) (*jsonrpc.Response, error) {
+	var args zbstorerpc.AddRootRequest
+	if err := jsonv2.Unmarshal(req.Params, &args); err != nil {
+		return nil, jsonrpc.Error(jsonrpc.InvalidParams, err)
+	}
+	if !filepath.IsAbs(args.Link) {
+		return nil, jsonrpc.Error(jsonrpc.InvalidParams, fmt.Errorf("add root %s: not an absolute path", args.Link))
+	}
+	link := filepath.Clean(args.Link)
+	target, err := s.readRoot(link)
+	if err != nil {
+		return nil, jsonrpc.Error(jsonrpc.InvalidParams, fmt.Errorf("add root: %v", err))
+	}
+
+	conn, err := s.db.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer s.db.Put(conn)
+
+	log.Debugf(ctx, "Registering %s (-> %s) as a root", link, target)
+	err = sqlitex.ExecuteTransientFS(conn, sqlFiles(), "roots/insert.sql", &sqlitex.ExecOptions{
+		Named: map[string]any{":link": link},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("add root %s: %v", link, err)
+	}
+	return nil, nil
+}
+
+// readRoot returns the store object that the symlink at link points to.
+func (s *Server) readRoot(link string) (zbstore.Path, error) {
+	target, err := os.Readlink(link)
+	if err != nil {
+		return "", err
+	}
+	storePath, _, err := s.dir.ParsePath(target)
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", link, err)
+	}
+	return storePath, nil
+}
+
+// liveRoots returns the store objects pointed to by the registered roots,
+// mapped to the links that point to them.
+// Roots whose links no longer exist or no longer point into the store
+// are unregistered.
+func (s *Server) liveRoots(ctx context.Context, conn *sqlite.Conn) (_ map[zbstore.Path][]string, err error) {
+	defer sqlitex.Save(conn)(&err)
+
+	var stale []string
+	roots := make(map[zbstore.Path][]string)
+	err = sqlitex.ExecuteTransientFS(conn, sqlFiles(), "roots/list.sql", &sqlitex.ExecOptions{
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			link := stmt.GetText("link")
+			target, err := s.readRoot(link)
+			if errors.Is(err, os.ErrNotExist) || (err != nil && !errors.Is(err, os.ErrPermission)) {
+				stale = append(stale, link)
+				return nil
+			}
+			if err != nil {
+				// Be conservative: we can't tell whether the root is still live,
+				// so leave it registered.
+				log.Warnf(ctx, "Checking root: %v", err)
+				return nil
+			}
+			roots[target] = append(roots[target], link)
+			return nil
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list roots: %v", err)
+	}
+
+	for _, link := range stale {
+		log.Debugf(ctx, "Removing stale root %s", link)
+		err := sqlitex.ExecuteTransientFS(conn, sqlFiles(), "roots/delete.sql", &sqlitex.ExecOptions{
+			Named: map[string]any{":link": link},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("remove root %s: %v", link, err)
+		}
+	}
+	return roots, nil
+}
@@ -0,0 +1,376 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package backend
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+
+	jsonv2 "github.com/go-json-experiment/json"
+	"golang.org/x/sys/unix"
+	"zb.256lights.llc/pkg/sets"
+	"zb.256lights.llc/pkg/zbstore"
+	"zombiezen.com/go/log"
+)
+
+// A rootless sandbox runs the builder without root privileges
+// by using unprivileged user namespaces.
+// The server can't create the sandbox's mounts itself,
+// so it starts a copy of its own executable (the sandbox helper)
+// as root inside new user, mount, and PID namespaces.
+// The helper sets up the sandbox filesystem as in [setupSandboxFilesystem]
+// and then runs the builder in a nested user namespace
+// that maps the builder's user and group IDs to the helper's root user.
+// From the host's point of view, the helper and the builder
+// both run as the server's user.
+//
+// The server sends a [rootlessSandboxRequest] as JSON
+// to the helper on file descriptor 3.
+// If the helper fails to set up the sandbox,
+// it writes an error message to file descriptor 4.
+// Otherwise, it closes file descriptor 4 and exits with the builder's exit status.
+// If the request has TraceExec or TraceInputs set,
+// the helper traces the builder with [runTracedCommand].
+// The helper writes the exec trace to file descriptor 5
+// and the store objects that the builder opened to file descriptor 6,
+// one per line.
+
+// rootlessSandboxRequest is the set of parameters
+// that the server sends to the sandbox helper.
+type rootlessSandboxRequest struct {
+	// Probe is true if the helper should only check
+	// whether it can create mounts in its namespace.
+	Probe bool `json:"probe,omitempty"`
+
+	Root         string            `json:"root,omitempty"`
+	StoreDir     zbstore.Directory `json:"storeDir,omitzero"`
+	RealStoreDir string            `json:"realStoreDir,omitempty"`
+	Inputs       []zbstore.Path    `json:"inputs,omitempty"`
+	WorkDir      string            `json:"workDir,omitempty"`
+	RealWorkDir  string            `json:"realWorkDir,omitempty"`
+	Extra        map[string]string `json:"extra,omitempty"`
+	UID          int               `json:"uid"`
+	GID          int               `json:"gid"`
+	Network      bool              `json:"network,omitempty"`
+	CAFile       string            `json:"caFile,omitempty"`
+	SHMSize      string            `json:"shmSize,omitempty"`
+
+	Builder      string   `json:"builder,omitempty"`
+	Args         []string `json:"args,omitempty"`
+	Env          []string `json:"env,omitempty"`
+	Dir          string   `json:"dir,omitempty"`
+	EnforceUmask bool     `json:"enforceUmask,omitempty"`
+	TraceExec    bool     `json:"traceExec,omitempty"`
+	TraceInputs  bool     `json:"traceInputs,omitempty"`
+}
+
+func (req *rootlessSandboxRequest) sandboxOptions() *linuxSandboxOptions {
+	return &linuxSandboxOptions{
+		storeDir:     req.StoreDir,
+		realStoreDir: req.RealStoreDir,
+		inputs:       sets.New(req.Inputs...),
+		workDir:      req.WorkDir,
+		realWorkDir:  req.RealWorkDir,
+		extra:        req.Extra,
+		builderUID:   req.UID,
+		builderGID:   req.GID,
+		rootless:     true,
+		network:      req.Network,
+		caFile:       req.CAFile,
+		shmSize:      req.SHMSize,
+	}
+}
+
+// runRootlessSandbox runs c in a sandbox rooted at dir
+// using the sandbox helper.
+// c must not have been started and c.SysProcAttr must be nil.
+// The builder's user and group IDs inside the sandbox
+// are the same as the server's.
+// The builder is traced as requested by invocation.
+func runRootlessSandbox(ctx context.Context, c *exec.Cmd, dir string, opts *linuxSandboxOptions, invocation *builderInvocation) error {
+	req := &rootlessSandboxRequest{
+		Root:         dir,
+		StoreDir:     opts.storeDir,
+		RealStoreDir: opts.realStoreDir,
+		Inputs:       slices.Collect(opts.inputs.All()),
+		WorkDir:      opts.workDir,
+		RealWorkDir:  opts.realWorkDir,
+		Extra:        opts.extra,
+		UID:          os.Geteuid(),
+		GID:          os.Getegid(),
+		Network:      opts.network,
+		CAFile:       opts.caFile,
+		SHMSize:      opts.shmSize,
+
+		Builder:      c.Path,
+		Args:         c.Args[1:],
+		Env:          c.Env,
+		Dir:          c.Dir,
+		EnforceUmask: invocation.determinism.umask(),
+		TraceExec:    invocation.trace != nil,
+		TraceInputs:  invocation.openedObjects != nil,
+	}
+	return runSandboxHelperProcess(ctx, req, c.Stdout, c.Stderr, invocation)
+}
+
+// rootlessSandboxSupport probes whether the sandbox helper can run.
+var rootlessSandboxSupport = sync.OnceValue(func() error {
+	ctx := context.Background()
+	err := runSandboxHelperProcess(ctx, &rootlessSandboxRequest{Probe: true}, nil, nil, nil)
+	if err != nil {
+		log.Debugf(ctx, "Rootless sandbox unavailable: %v", err)
+	}
+	return err
+})
+
+func canSandboxRootless() bool {
+	return rootlessSandboxSupport() == nil
+}
+
+// runSandboxHelperProcess starts the sandbox helper, sends it req,
+// and waits for it to exit.
+// If the builder fails, then runSandboxHelperProcess returns a [builderFailure].
+// invocation must be non-nil if req.TraceExec or req.TraceInputs is true.
+func runSandboxHelperProcess(ctx context.Context, req *rootlessSandboxRequest, stdout, stderr io.Writer, invocation *builderInvocation) error {
+	reqData, err := jsonv2.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("sandbox helper: %v", err)
+	}
+	requestReader, requestWriter, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("sandbox helper: %v", err)
+	}
+	defer requestWriter.Close()
+	statusReader, statusWriter, err := os.Pipe()
+	if err != nil {
+		requestReader.Close()
+		return fmt.Errorf("sandbox helper: %v", err)
+	}
+	defer statusReader.Close()
+
+	helper := exec.CommandContext(ctx, "/proc/self/exe")
+	helper.Args = []string{sandboxHelperArg0}
+	helper.Env = []string{}
+	helper.Stdout = stdout
+	helper.Stderr = stderr
+	helper.ExtraFiles = []*os.File{requestReader, statusWriter}
+	var openedReader, openedWriter *os.File
+	if req.TraceExec || req.TraceInputs {
+		if req.TraceInputs {
+			openedReader, openedWriter, err = os.Pipe()
+			if err != nil {
+				requestReader.Close()
+				statusWriter.Close()
+				return fmt.Errorf("sandbox helper: %v", err)
+			}
+			defer openedReader.Close()
+		}
+		helper.ExtraFiles = append(helper.ExtraFiles, invocation.trace, openedWriter)
+	}
+	helper.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags: unix.CLONE_NEWUSER | unix.CLONE_NEWNS | unix.CLONE_NEWPID,
+		UidMappings: []syscall.SysProcIDMap{
+			{ContainerID: 0, HostID: os.Geteuid(), Size: 1},
+		},
+		GidMappings: []syscall.SysProcIDMap{
+			{ContainerID: 0, HostID: os.Getegid(), Size: 1},
+		},
+		Pdeathsig: unix.SIGKILL,
+	}
+	setCancelFunc(helper)
+	err = helper.Start()
+	requestReader.Close()
+	statusWriter.Close()
+	if openedWriter != nil {
+		openedWriter.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("sandbox helper: %v", err)
+	}
+	openedDone := make(chan struct{})
+	if openedReader != nil {
+		go func() {
+			defer close(openedDone)
+			readOpenedObjects(invocation.openedObjects, req.StoreDir, openedReader)
+		}()
+	} else {
+		close(openedDone)
+	}
+
+	// If the helper exits early, it will report why on the status pipe,
+	// so write errors are not interesting.
+	requestWriter.Write(reqData)
+	requestWriter.Close()
+	status, _ := io.ReadAll(statusReader)
+	waitErr := helper.Wait()
+	<-openedDone
+	if msg := strings.TrimSpace(string(status)); msg != "" {
+		return fmt.Errorf("sandbox helper: %s", msg)
+	}
+	if waitErr != nil {
+		if req.Probe {
+			return fmt.Errorf("sandbox helper: %v", waitErr)
+		}
+		return builderFailure{waitErr}
+	}
+	return nil
+}
+
+// runSandboxHelper is the main function of the sandbox helper.
+// It returns the process's exit code.
+func runSandboxHelper() int {
+	requestFile := os.NewFile(3, "sandbox request")
+	statusFile := os.NewFile(4, "sandbox status")
+	req, err := prepareRootlessSandbox(requestFile)
+	requestFile.Close()
+	if err != nil {
+		fmt.Fprintln(statusFile, err)
+		return 1
+	}
+	statusFile.Close()
+	if req.Probe {
+		return 0
+	}
+
+	c := exec.Command(req.Builder, req.Args...)
+	c.Env = req.Env
+	c.Dir = req.Dir
+	c.Stdout = os.Stdout
+	c.Stderr = os.Stderr
+	// The nested user namespace and mount namespace
+	// permit the builder to chroot and switch to its user
+	// without having any privileges in the helper's namespaces.
+	c.SysProcAttr = &syscall.SysProcAttr{
+		Chroot:     req.Root,
+		Cloneflags: unix.CLONE_NEWUSER | unix.CLONE_NEWNS,
+		UidMappings: []syscall.SysProcIDMap{
+			{ContainerID: req.UID, HostID: 0, Size: 1},
+		},
+		GidMappings: []syscall.SysProcIDMap{
+			{ContainerID: req.GID, HostID: 0, Size: 1},
+		},
+		Credential: &syscall.Credential{
+			Uid:         uint32(req.UID),
+			Gid:         uint32(req.GID),
+			NoSetGroups: true,
+		},
+	}
+
+	// The helper is the init process of its PID namespace,
+	// so it only receives signals that it handles.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, unix.SIGTERM, unix.SIGINT)
+	go func() {
+		for sig := range signals {
+			if c.Process != nil {
+				c.Process.Signal(sig)
+			}
+		}
+	}()
+
+	if req.TraceExec || req.TraceInputs {
+		err = runSandboxHelperTraced(c, req)
+	} else {
+		err = runCommand(c, req.EnforceUmask)
+	}
+	var exitErr interface {
+		error
+		ExitCode() int
+		Sys() any
+	}
+	if errors.As(err, &exitErr) {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			return 128 + int(status.Signal())
+		}
+		return exitErr.ExitCode()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", req.Builder, err)
+		return 127
+	}
+	return 0
+}
+
+// runSandboxHelperTraced runs the builder in the sandbox helper
+// with [runTracedCommand],
+// sending the results back to the server on the file descriptors
+// described in [rootlessSandboxRequest].
+func runSandboxHelperTraced(c *exec.Cmd, req *rootlessSandboxRequest) error {
+	opts := new(traceOptions)
+	if req.TraceExec {
+		// Keep the builder from inheriting the trace file.
+		unix.CloseOnExec(5)
+		traceFile := os.NewFile(5, "exec trace")
+		defer traceFile.Close()
+		opts.exec = traceFile
+	}
+	var opened sets.Set[zbstore.Path]
+	if req.TraceInputs {
+		unix.CloseOnExec(6)
+		opened = make(sets.Set[zbstore.Path])
+		opts.openFile = storeObjectRecorder(req.StoreDir, opened)
+	}
+	err := runTracedCommand(c, req.EnforceUmask, opts)
+	if req.TraceInputs {
+		openedFile := os.NewFile(6, "opened objects")
+		w := bufio.NewWriter(openedFile)
+		for p := range opened.All() {
+			w.WriteString(string(p))
+			w.WriteString("\n")
+		}
+		w.Flush()
+		openedFile.Close()
+	}
+	return err
+}
+
+// readOpenedObjects adds the store objects
+// that the sandbox helper wrote to r to dst.
+func readOpenedObjects(dst sets.Set[zbstore.Path], dir zbstore.Directory, r io.Reader) {
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		if p, sub, err := dir.ParsePath(s.Text()); err == nil && sub == "" {
+			dst.Add(p)
+		}
+	}
+}
+
+// prepareRootlessSandbox reads a [rootlessSandboxRequest] from r
+// and sets up the sandbox in the helper's namespaces.
+func prepareRootlessSandbox(r io.Reader) (*rootlessSandboxRequest, error) {
+	req := new(rootlessSandboxRequest)
+	if err := jsonv2.UnmarshalRead(r, req); err != nil {
+		return nil, fmt.Errorf("read request: %v", err)
+	}
+
+	// Prevent mounts from propagating back to the host.
+	if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+		return nil, fmt.Errorf("make mounts private: %v", err)
+	}
+	// The helper needs a /proc for its PID namespace
+	// to set up the builder's user namespace.
+	// This is also the most restricted operation that the sandbox needs,
+	// so it serves as the probe.
+	if err := unix.Mount("none", "/proc", "proc", 0, ""); err != nil {
+		return nil, fmt.Errorf("mount proc: %v", err)
+	}
+	if req.Probe {
+		return req, nil
+	}
+
+	if err := setupSandboxFilesystem(context.Background(), req.Root, req.sandboxOptions()); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
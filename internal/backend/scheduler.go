@@ -0,0 +1,229 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package backend
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+)
+
+// ClientInfo identifies a connection to a [Server]
+// for the purposes of build scheduling and logging.
+// Builds are associated with the client whose request started them.
+type ClientInfo struct {
+	// Name is a human-readable identifier for the client used in log messages.
+	Name string
+	// Weight is the client's share of build slots relative to other clients.
+	// If non-positive, then 1 is used.
+	Weight int
+	// Peer is the identity of the process on the other end of the client's connection
+	// recorded in the audit log (see [Options.AuditLog]).
+	// It is nil if unknown.
+	Peer *PeerCredentials
+}
+
+func (client *ClientInfo) String() string {
+	if client == nil || client.Name == "" {
+		return "<anonymous client>"
+	}
+	return client.Name
+}
+
+func (client *ClientInfo) weight() int {
+	if client == nil {
+		return 1
+	}
+	return max(1, client.Weight)
+}
+
+type clientContextKey struct{}
+
+// WithClient returns a copy of parent
+// in which requests are attributed to the given client.
+// Requests made with the same client pointer share a single scheduling queue.
+// Requests without a client share an anonymous queue.
+func WithClient(parent context.Context, client *ClientInfo) context.Context {
+	return context.WithValue(parent, clientContextKey{}, client)
+}
+
+// ClientFromContext returns the client attached to ctx by [WithClient]
+// or nil if there is none.
+func ClientFromContext(ctx context.Context) *ClientInfo {
+	client, _ := ctx.Value(clientContextKey{}).(*ClientInfo)
+	return client
+}
+
+// errTooManyQueued is returned by [*buildScheduler.acquire]
+// when a client has reached its limit of derivations waiting to build.
+var errTooManyQueued = errors.New("client has too many derivations waiting to build")
+
+// buildScheduler is a semaphore for build slots.
+// Waiters with a higher priority always receive slots first.
+// Among the waiters with the highest priority,
+// buildScheduler hands out slots to clients in weighted round-robin order.
+// Methods on buildScheduler are safe to call concurrently from multiple goroutines.
+type buildScheduler struct {
+	slots       int
+	maxQueued   int
+	maxPriority int
+
+	mu      sync.Mutex
+	running int
+	queues  map[*ClientInfo]*clientQueue
+	// order is the round-robin order of clients that have waiters.
+	order []*ClientInfo
+	// next is the index into order of the client that should receive the next slot.
+	next int
+}
+
+type clientQueue struct {
+	// waiters is sorted by descending priority
+	// and then in the order the waiters arrived.
+	waiters []schedulerWaiter
+	// credit is the number of slots the client can still receive
+	// before the next client in order gets a turn.
+	credit int
+}
+
+type schedulerWaiter struct {
+	granted  chan struct{}
+	priority int
+}
+
+// newBuildScheduler returns a new scheduler with the given number of build slots.
+// If slots is non-positive, then acquire never blocks.
+// If maxQueued is positive, then it is the maximum number of waiters any single client may have.
+// Priorities passed to acquire that are greater than maxPriority
+// are lowered to maxPriority.
+func newBuildScheduler(slots, maxQueued, maxPriority int) *buildScheduler {
+	return &buildScheduler{
+		slots:       slots,
+		maxQueued:   maxQueued,
+		maxPriority: maxPriority,
+		queues:      make(map[*ClientInfo]*clientQueue),
+	}
+}
+
+// acquire waits until a build slot is available for client
+// or ctx.Done is closed.
+// Waiters with a higher priority receive slots before waiters with a lower priority.
+// priority is clamped to the scheduler's maximum priority
+// so that clients cannot starve each other.
+// On success, the caller must call release once the build is finished.
+func (s *buildScheduler) acquire(ctx context.Context, client *ClientInfo, priority int) (release func(), err error) {
+	if s.slots <= 0 {
+		return func() {}, nil
+	}
+
+	s.mu.Lock()
+	if s.running < s.slots && len(s.order) == 0 {
+		s.running++
+		s.mu.Unlock()
+		return s.releaseFunc(), nil
+	}
+	q := s.queues[client]
+	if q == nil {
+		q = new(clientQueue)
+		s.queues[client] = q
+		s.order = append(s.order, client)
+	}
+	if s.maxQueued > 0 && len(q.waiters) >= s.maxQueued {
+		s.mu.Unlock()
+		return nil, errTooManyQueued
+	}
+	priority = min(priority, s.maxPriority)
+	granted := make(chan struct{})
+	i := slices.IndexFunc(q.waiters, func(w schedulerWaiter) bool {
+		return w.priority < priority
+	})
+	if i < 0 {
+		i = len(q.waiters)
+	}
+	q.waiters = slices.Insert(q.waiters, i, schedulerWaiter{granted, priority})
+	s.dispatch()
+	s.mu.Unlock()
+
+	select {
+	case <-granted:
+		return s.releaseFunc(), nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if i := slices.IndexFunc(q.waiters, func(w schedulerWaiter) bool { return w.granted == granted }); i >= 0 {
+			q.waiters = slices.Delete(q.waiters, i, i+1)
+			if len(q.waiters) == 0 {
+				s.removeClient(slices.Index(s.order, client))
+			}
+		} else {
+			// Slot was granted concurrently with cancellation. Give it back.
+			s.running--
+			s.dispatch()
+		}
+		return nil, ctx.Err()
+	}
+}
+
+func (s *buildScheduler) releaseFunc() func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.mu.Lock()
+			s.running--
+			s.dispatch()
+			s.mu.Unlock()
+		})
+	}
+}
+
+// dispatch hands out available slots to waiting clients.
+// s.mu must be held.
+func (s *buildScheduler) dispatch() {
+	for s.running < s.slots && len(s.order) > 0 {
+		s.skipToHighestPriority()
+		client := s.order[s.next]
+		q := s.queues[client]
+		if q.credit <= 0 {
+			q.credit = client.weight()
+		}
+		close(q.waiters[0].granted)
+		q.waiters = slices.Delete(q.waiters, 0, 1)
+		q.credit--
+		s.running++
+
+		switch {
+		case len(q.waiters) == 0:
+			s.removeClient(s.next)
+		case q.credit == 0:
+			s.next = (s.next + 1) % len(s.order)
+		}
+	}
+}
+
+// skipToHighestPriority advances s.next to the next client in round-robin order
+// that has a waiter with the highest priority of all waiters.
+// s.mu must be held.
+func (s *buildScheduler) skipToHighestPriority() {
+	highest := s.queues[s.order[0]].waiters[0].priority
+	for _, client := range s.order[1:] {
+		highest = max(highest, s.queues[client].waiters[0].priority)
+	}
+	for s.queues[s.order[s.next]].waiters[0].priority < highest {
+		s.next = (s.next + 1) % len(s.order)
+	}
+}
+
+// removeClient removes the client at index i in s.order.
+// s.mu must be held.
+func (s *buildScheduler) removeClient(i int) {
+	delete(s.queues, s.order[i])
+	s.order = slices.Delete(s.order, i, i+1)
+	if s.next > i {
+		s.next--
+	}
+	if s.next >= len(s.order) {
+		s.next = 0
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package backend
+
+import (
+	"context"
+	"testing"
+	"testing/synctest"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestBuildScheduler(t *testing.T) {
+	tests := []struct {
+		name        string
+		weightA     int
+		maxPriority int
+		priorities  map[string]int
+		want        []string
+	}{
+		{
+			name:    "Equal",
+			weightA: 1,
+			want:    []string{"a1", "b1", "a2", "b2", "a3"},
+		},
+		{
+			name:    "Weighted",
+			weightA: 2,
+			want:    []string{"a1", "a2", "b1", "a3", "b2"},
+		},
+		{
+			name:        "Priority",
+			weightA:     1,
+			maxPriority: 1,
+			priorities:  map[string]int{"a3": 1, "b2": 1},
+			want:        []string{"a3", "b2", "a1", "b1", "a2"},
+		},
+		{
+			name:       "LowPriority",
+			weightA:    1,
+			priorities: map[string]int{"a1": -1, "a2": -1},
+			want:       []string{"a3", "b1", "b2", "a1", "a2"},
+		},
+		{
+			name:        "MaxPriority",
+			weightA:     1,
+			maxPriority: 1,
+			priorities:  map[string]int{"a2": 100, "a3": 1, "b1": 1},
+			want:        []string{"a2", "b1", "a3", "b2", "a1"},
+		},
+		{
+			name:       "DefaultMaxPriority",
+			weightA:    1,
+			priorities: map[string]int{"a3": 1, "b2": 1},
+			want:       []string{"a1", "b1", "a2", "b2", "a3"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			synctest.Test(t, func(t *testing.T) {
+				ctx := context.Background()
+				s := newBuildScheduler(1, 0, test.maxPriority)
+				clientA := &ClientInfo{Name: "a", Weight: test.weightA}
+				clientB := &ClientInfo{Name: "b"}
+
+				releaseFirst, err := s.acquire(ctx, nil, 0)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				var got []string
+				granted := make(chan string)
+				enqueue := func(client *ClientInfo, name string) {
+					go func() {
+						release, err := s.acquire(ctx, client, test.priorities[name])
+						if err != nil {
+							t.Error(err)
+							return
+						}
+						granted <- name
+						release()
+					}()
+					synctest.Wait()
+				}
+				enqueue(clientA, "a1")
+				enqueue(clientA, "a2")
+				enqueue(clientA, "a3")
+				enqueue(clientB, "b1")
+				enqueue(clientB, "b2")
+
+				releaseFirst()
+				for range test.want {
+					got = append(got, <-granted)
+				}
+				if diff := cmp.Diff(test.want, got); diff != "" {
+					t.Errorf("grant order (-want +got):\n%s", diff)
+				}
+			})
+		})
+	}
+}
+
+func TestBuildSchedulerMaxQueued(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		ctx := context.Background()
+		s := newBuildScheduler(1, 1, 0)
+		client := &ClientInfo{Name: "a"}
+
+		release1, err := s.acquire(ctx, client, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			release2, err := s.acquire(ctx, client, 0)
+			if err != nil {
+				t.Error("second acquire:", err)
+				return
+			}
+			release2()
+		}()
+		synctest.Wait()
+
+		if _, err := s.acquire(ctx, client, 0); err != errTooManyQueued {
+			t.Errorf("third acquire error = %v; want %v", err, errTooManyQueued)
+		}
+		// Other clients have their own limit.
+		otherCtx, cancel := context.WithCancel(ctx)
+		otherDone := make(chan error)
+		go func() {
+			_, err := s.acquire(otherCtx, &ClientInfo{Name: "b"}, 0)
+			otherDone <- err
+		}()
+		synctest.Wait()
+		cancel()
+		if err := <-otherDone; err != context.Canceled {
+			t.Errorf("canceled acquire error = %v; want %v", err, context.Canceled)
+		}
+
+		release1()
+		<-done
+		if s.running != 0 || len(s.order) != 0 {
+			t.Errorf("after all releases: running = %d, %d clients waiting; want 0, 0", s.running, len(s.order))
+		}
+	})
+}
@@ -0,0 +1,6 @@
+select "attestations"."envelope" as "envelope"
+from
+  "attestations"
+  join "paths" on "paths"."id" = "attestations"."output_path"
+where "paths"."path" = :path
+order by "attestations"."id";
@@ -0,0 +1,11 @@
+insert into "attestations" (
+  "drv_hash",
+  "output_name",
+  "output_path",
+  "envelope"
+) values (
+  (select "id" from "drv_hashes" where ("algorithm", "bits") = (:drv_hash_algorithm, :drv_hash_bits)),
+  :output_name,
+  (select "id" from "paths" where "path" = :output_path),
+  :envelope
+);
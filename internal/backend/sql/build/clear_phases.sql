@@ -0,0 +1,2 @@
+delete from "build_phases"
+where "result_id" = :id;
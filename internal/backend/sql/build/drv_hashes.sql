@@ -0,0 +1,10 @@
+select distinct
+  "drv_hashes"."algorithm" as "drv_hash_algorithm",
+  "drv_hashes"."bits" as "drv_hash_bits"
+from
+  "build_results"
+  join "paths" as "drv_path" on "build_results"."drv_path" = "drv_path"."id"
+  join "drv_hashes" on "build_results"."drv_hash" = "drv_hashes"."id"
+where
+  "drv_path"."path" = :drv_path
+order by 1, 2;
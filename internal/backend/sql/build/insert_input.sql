@@ -0,0 +1,10 @@
+insert into "build_inputs" (
+  "result_id",
+  "input_path",
+  "used"
+) values (
+  :id,
+  (select "id" from "paths" where "path" = :input_path),
+  :used
+)
+on conflict ("result_id", "input_path") do update set "used" = excluded."used";
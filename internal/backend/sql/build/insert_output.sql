@@ -1,7 +1,9 @@
 insert into "build_outputs" (
   "result_id",
   "output_name",
-  "output_path"
+  "output_path",
+  "provenance",
+  "provenance_store"
 ) values (
   :id,
   :output_name,
@@ -9,5 +11,7 @@ insert into "build_outputs" (
     coalesce(:output_path, '') <> '',
     (select "id" from "paths" where "path" = :output_path),
     null
-  )
+  ),
+  nullif(:provenance, ''),
+  nullif(:provenance_store, '')
 );
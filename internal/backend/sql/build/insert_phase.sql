@@ -0,0 +1,13 @@
+insert into "build_phases" (
+  "result_id",
+  "seq",
+  "name",
+  "started_at",
+  "ended_at"
+) values (
+  :id,
+  :seq,
+  :name,
+  :started_at_millis,
+  :ended_at_millis
+);
@@ -0,0 +1,17 @@
+select
+  uuidhex("builds"."uuid") as "build_id"
+from
+  "build_results"
+  join "builds" on "builds"."id" = "build_results"."build_id"
+  join "paths" as "drv_path" on "drv_path"."id" = "build_results"."drv_path"
+  join "drv_hashes" as "drv_hash" on "drv_hash"."id" = "build_results"."drv_hash"
+where
+  "drv_path"."path" = :drv_path and
+  "build_results"."status" = 'success' and
+  "build_results"."builder_started_at" is not null and
+  not (
+    "drv_hash"."algorithm" = :drv_hash_algorithm and
+    "drv_hash"."bits" = :drv_hash_bits
+  )
+order by "build_results"."started_at" desc
+limit 1;
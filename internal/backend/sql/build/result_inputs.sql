@@ -0,0 +1,13 @@
+select
+  "input_path"."path" as "input_path",
+  "build_inputs"."used" as "used"
+from
+  "build_inputs"
+  join "build_results" on "build_results"."id" = "build_inputs"."result_id"
+  join "builds" on "builds"."id" = "build_results"."build_id"
+  join "paths" as "drv_path" on "drv_path"."id" = "build_results"."drv_path"
+  join "paths" as "input_path" on "input_path"."id" = "build_inputs"."input_path"
+where
+  "builds"."uuid" = uuid(:build_id) and
+  "drv_path"."path" = :drv_path
+order by "input_path"."path";
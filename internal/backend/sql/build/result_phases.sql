@@ -0,0 +1,13 @@
+select
+  "build_phases"."name" as "name",
+  "build_phases"."started_at" as "started_at",
+  "build_phases"."ended_at" as "ended_at"
+from
+  "build_phases"
+  join "build_results" on "build_results"."id" = "build_phases"."result_id"
+  join "builds" on "builds"."id" = "build_results"."build_id"
+  join "paths" as "drv_path" on "drv_path"."id" = "build_results"."drv_path"
+where
+  "builds"."uuid" = uuid(:build_id) and
+  "drv_path"."path" = :drv_path
+order by "build_phases"."seq";
@@ -7,8 +7,14 @@ select
   "build_results"."ended_at" as "ended_at",
   "build_results"."builder_started_at" as "builder_started_at",
   "build_results"."builder_ended_at" as "builder_ended_at",
+  "build_results"."rebuild_reason" as "rebuild_reason",
+  "build_results"."schedule_seq" as "schedule_seq",
+  "build_results"."build_uid" as "build_uid",
+  "build_results"."build_gid" as "build_gid",
   "outputs"."output_name" as "output_name",
-  "output_path"."path" as "output_path"
+  "output_path"."path" as "output_path",
+  "outputs"."provenance" as "provenance",
+  "outputs"."provenance_store" as "provenance_store"
 from
   "build_results"
   join "builds" on "builds"."id" = "build_results"."build_id"
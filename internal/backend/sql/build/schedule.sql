@@ -0,0 +1,14 @@
+select
+  "drv_path"."path" as "drv_path",
+  "build_results"."schedule_seq" as "schedule_seq",
+  "build_results"."builder_started_at" is not null as "built",
+  "build_results"."build_uid" as "build_uid",
+  "build_results"."build_gid" as "build_gid"
+from
+  "build_results"
+  join "builds" on "builds"."id" = "build_results"."build_id"
+  join "paths" as "drv_path" on "drv_path"."id" = "build_results"."drv_path"
+where
+  "builds"."uuid" = uuid(:build_id) and
+  "build_results"."schedule_seq" is not null
+order by "build_results"."schedule_seq";
@@ -1,3 +1,6 @@
 update "build_results"
-set "builder_started_at" = :timestamp_millis
+set
+  "builder_started_at" = :timestamp_millis,
+  "build_uid" = :build_uid,
+  "build_gid" = :build_gid
 where "id" = :id;
@@ -0,0 +1,3 @@
+update "build_results"
+set "rebuild_reason" = :rebuild_reason
+where "id" = :id;
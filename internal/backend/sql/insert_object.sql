@@ -2,10 +2,12 @@ insert into "objects" (
   "id",
   "nar_size",
   "nar_hash",
-  "ca"
+  "ca",
+  "registered_at"
 ) values (
   (select "id" from "paths" where "path" = :path),
   :nar_size,
   nullif(:nar_hash, ''),
-  nullif(:ca, '')
+  nullif(:ca, ''),
+  :registered_at
 );
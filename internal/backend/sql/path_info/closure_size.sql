@@ -0,0 +1,19 @@
+with
+  "closure"("id") as (
+    select "id"
+      from
+        "objects"
+        join "paths" using ("id")
+      where "path" = :path
+    union
+      select "references"."reference"
+      from
+        "references"
+        join "closure" on "closure"."id" = "references"."referrer"
+  )
+
+select
+  coalesce(sum("objects"."nar_size"), 0) as "closure_size"
+from
+  "closure"
+  join "objects" using ("id");
@@ -0,0 +1,9 @@
+select distinct
+  "drv_path"."path" as "drv_path"
+from
+  "build_outputs"
+  join "paths" as "output_path" on "build_outputs"."output_path" = "output_path"."id"
+  join "build_results" on "build_outputs"."result_id" = "build_results"."id"
+  join "paths" as "drv_path" on "build_results"."drv_path" = "drv_path"."id"
+where "output_path"."path" = :path
+order by 1;
@@ -0,0 +1,7 @@
+select
+  "registered_at" as "registered_at"
+from
+  "objects"
+  join "paths" using ("id")
+where "path" = :path
+limit 1;
@@ -0,0 +1,15 @@
+-- Reference classes for references to sources have null derivation hashes,
+-- which the unique index considers distinct,
+-- so recording the same realization multiple times can leave duplicates.
+delete from "reference_classes"
+where "id" not in (
+  select min("id")
+  from "reference_classes"
+  group by
+    "referrer",
+    "referrer_drv_hash",
+    "referrer_output_name",
+    "reference",
+    "reference_drv_hash",
+    "reference_output_name"
+);
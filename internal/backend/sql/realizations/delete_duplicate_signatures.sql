@@ -0,0 +1,11 @@
+delete from "signatures"
+where "id" not in (
+  select min("id")
+  from "signatures"
+  group by
+    "drv_hash",
+    "output_name",
+    "output_path",
+    "public_key_id",
+    "signature"
+);
@@ -0,0 +1,15 @@
+-- Delete realizations whose output is no longer in the store
+-- and that no other realization's reference classes point to.
+-- Deleting a realization cascades to its own reference classes,
+-- so callers should repeat this until no rows are deleted.
+delete from "realizations"
+where
+  "output_path" not in (select "id" from "objects") and
+  not exists (
+    select 1
+    from "reference_classes" as rc
+    where
+      rc."reference_drv_hash" = "realizations"."drv_hash" and
+      rc."reference_output_name" = "realizations"."output_name" and
+      rc."reference" = "realizations"."output_path"
+  );
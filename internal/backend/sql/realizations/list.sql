@@ -0,0 +1,14 @@
+select
+  "drv_hashes"."algorithm" as "drv_hash_algorithm",
+  "drv_hashes"."bits" as "drv_hash_bits",
+  "realizations"."output_name" as "output_name",
+  "output_path"."path" as "output_path"
+from
+  "realizations"
+  join "drv_hashes" on "realizations"."drv_hash" = "drv_hashes"."id"
+  join "paths" as "output_path" on "realizations"."output_path" = "output_path"."id"
+where
+  (:drv_hash_algorithm = '' or ("drv_hashes"."algorithm", "drv_hashes"."bits") = (:drv_hash_algorithm, :drv_hash_bits)) and
+  (:output_name = '' or "realizations"."output_name" = :output_name) and
+  (:output_path = '' or "realizations"."output_path" = (select "id" from "paths" where "path" = :output_path))
+order by 1, 2, 3, 4;
@@ -0,0 +1,39 @@
+-- Store objects that reference :path,
+-- either directly or (if :recursive) transitively.
+-- Realization reference classes are followed as well as object references
+-- so that referrers whose intermediate objects are absent from the store are still found.
+-- Each recursive step looks up back references by index.
+-- Compounding the steps with union (rather than union all)
+-- skips objects that have already been visited.
+with recursive
+  "target"("id") as (
+    select "id" from "paths" where "path" = :path
+  ),
+  "closure"("id") as (
+    select "id" from "target"
+    union
+    select r."referrer"
+    from
+      "closure"
+      join "references" as r on r."reference" = "closure"."id"
+    where
+      r."referrer" <> r."reference" and
+      (:recursive or "closure"."id" in (select "id" from "target"))
+    union
+    select rc."referrer"
+    from
+      "closure"
+      join "reference_classes" as rc on rc."reference" = "closure"."id"
+    where
+      rc."referrer" <> rc."reference" and
+      (:recursive or "closure"."id" in (select "id" from "target"))
+  )
+
+select
+  "paths"."path" as "path"
+from
+  "closure"
+  join "objects" using ("id")
+  join "paths" using ("id")
+where "closure"."id" not in (select "id" from "target")
+order by 1;
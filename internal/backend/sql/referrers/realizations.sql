@@ -0,0 +1,39 @@
+-- Realizations with a reference class that names :path
+-- or (if :recursive) any path that transitively references :path.
+-- The closure is computed the same way as in objects.sql.
+with recursive
+  "target"("id") as (
+    select "id" from "paths" where "path" = :path
+  ),
+  "closure"("id") as (
+    select "id" from "target"
+    union
+    select r."referrer"
+    from
+      "closure"
+      join "references" as r on r."reference" = "closure"."id"
+    where
+      :recursive and
+      r."referrer" <> r."reference"
+    union
+    select rc."referrer"
+    from
+      "closure"
+      join "reference_classes" as rc on rc."reference" = "closure"."id"
+    where
+      :recursive and
+      rc."referrer" <> rc."reference"
+  )
+
+select distinct
+  "drv_hashes"."algorithm" as "drv_hash_algorithm",
+  "drv_hashes"."bits" as "drv_hash_bits",
+  rc."referrer_output_name" as "output_name",
+  "output_path"."path" as "output_path"
+from
+  "reference_classes" as rc
+  join "closure" on rc."reference" = "closure"."id"
+  join "drv_hashes" on rc."referrer_drv_hash" = "drv_hashes"."id"
+  join "paths" as "output_path" on rc."referrer" = "output_path"."id"
+where rc."referrer" <> rc."reference"
+order by 1, 2, 3, 4;
@@ -0,0 +1 @@
+delete from "gc_roots" where "link" = :link;
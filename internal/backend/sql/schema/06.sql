@@ -0,0 +1,2 @@
+alter table "build_outputs" add column "provenance" text;
+alter table "build_outputs" add column "provenance_store" text;
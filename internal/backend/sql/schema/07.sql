@@ -0,0 +1,15 @@
+create table "attestations" (
+  "id" integer primary key not null,
+
+  "drv_hash" integer not null,
+  "output_name" text not null,
+  "output_path" integer not null,
+
+  -- DSSE envelope as JSON.
+  "envelope" text not null,
+
+  foreign key ("drv_hash", "output_name", "output_path") references "realizations"
+    on delete cascade
+);
+
+create index "attestations_by_output_path" on "attestations"("output_path");
@@ -0,0 +1,3 @@
+create table "gc_roots" (
+  "link" text primary key not null
+);
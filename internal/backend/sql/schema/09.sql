@@ -0,0 +1,2 @@
+-- JSON-encoded zbstorerpc.RebuildReason.
+alter table "build_results" add column "rebuild_reason" text;
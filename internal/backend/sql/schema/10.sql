@@ -0,0 +1,13 @@
+create table "build_phases" (
+  "result_id" integer
+    not null
+    references "build_results" on delete cascade,
+  "seq" integer
+    not null,
+  "name" text
+    not null,
+  "started_at" integer not null, -- Milliseconds since Unix epoch
+  "ended_at" integer not null,   -- Milliseconds since Unix epoch
+
+  primary key ("result_id", "seq")
+) without rowid;
@@ -0,0 +1,12 @@
+-- Derivation hashes that a fallback store had no realizations for
+-- when the store last asked.
+-- Rows older than the server's configured TTL are ignored
+-- and eventually deleted.
+create table "substituter_misses" (
+  "store" text not null, -- Redacted URL of the fallback store
+  "algorithm" text not null,
+  "bits" blob not null,
+  "checked_at" integer not null, -- Milliseconds since Unix epoch
+
+  primary key ("store", "algorithm", "bits")
+) without rowid;
@@ -0,0 +1,3 @@
+-- Milliseconds since Unix epoch.
+-- Null for store objects registered before this column was added.
+alter table "objects" add column "registered_at" integer;
@@ -0,0 +1,9 @@
+-- Scheduling decisions for each build result,
+-- recorded so that a later build can replay them.
+-- Null for build results recorded before these columns were added.
+
+-- 1-based position of the derivation in the order that the build processed it.
+alter table "build_results" add column "schedule_seq" integer;
+-- Build user that the builder ran as, if any.
+alter table "build_results" add column "build_uid" integer;
+alter table "build_results" add column "build_gid" integer;
@@ -0,0 +1,13 @@
+-- Inputs of each build result and whether the builder opened them.
+-- Only recorded for builds that requested input tracing.
+create table "build_inputs" (
+  "result_id" integer
+    not null
+    references "build_results" on delete cascade,
+  "input_path" integer
+    not null
+    references "paths",
+  "used" integer not null, -- Boolean
+
+  primary key ("result_id", "input_path")
+) without rowid;
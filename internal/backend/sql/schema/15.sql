@@ -0,0 +1 @@
+create index "reference_class_back_references" on "reference_classes"("reference");
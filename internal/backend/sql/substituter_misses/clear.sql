@@ -0,0 +1 @@
+delete from "substituter_misses";
@@ -0,0 +1,5 @@
+delete from "substituter_misses"
+where
+  "store" = :store and
+  "algorithm" = :algorithm and
+  "bits" = :bits;
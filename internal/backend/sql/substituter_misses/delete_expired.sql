@@ -0,0 +1 @@
+delete from "substituter_misses" where "checked_at" < :not_before;
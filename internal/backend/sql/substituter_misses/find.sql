@@ -0,0 +1,8 @@
+select 1 as "found"
+from "substituter_misses"
+where
+  "store" = :store and
+  "algorithm" = :algorithm and
+  "bits" = :bits and
+  "checked_at" >= :not_before
+limit 1;
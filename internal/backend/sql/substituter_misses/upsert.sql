@@ -0,0 +1,12 @@
+insert into "substituter_misses" (
+  "store",
+  "algorithm",
+  "bits",
+  "checked_at"
+) values (
+  :store,
+  :algorithm,
+  :bits,
+  :now
+) on conflict ("store", "algorithm", "bits") do update set
+  "checked_at" = excluded."checked_at";
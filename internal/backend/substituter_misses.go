@@ -0,0 +1,106 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package backend
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	jsonv2 "github.com/go-json-experiment/json"
+	"zb.256lights.llc/pkg/internal/jsonrpc"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+	"zombiezen.com/go/nix"
+	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+// hasSubstituterMiss reports whether the fallback store named store
+// was recorded as having no realizations for drvHash at or after notBefore.
+func hasSubstituterMiss(conn *sqlite.Conn, store string, drvHash nix.Hash, notBefore time.Time) (bool, error) {
+	var found bool
+	err := sqlitex.ExecuteFS(conn, sqlFiles(), "substituter_misses/find.sql", &sqlitex.ExecOptions{
+		Named: map[string]any{
+			":store":      store,
+			":algorithm":  drvHash.Type().String(),
+			":bits":       drvHash.Bytes(nil),
+			":not_before": notBefore.UnixMilli(),
+		},
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			found = stmt.GetBool("found")
+			return nil
+		},
+	})
+	if err != nil {
+		return false, fmt.Errorf("look up substituter miss for %v: %v", drvHash, err)
+	}
+	return found, nil
+}
+
+// recordSubstituterMiss records that the fallback store named store
+// had no realizations for drvHash at the given time.
+func recordSubstituterMiss(conn *sqlite.Conn, store string, drvHash nix.Hash, now time.Time) error {
+	err := sqlitex.ExecuteFS(conn, sqlFiles(), "substituter_misses/upsert.sql", &sqlitex.ExecOptions{
+		Named: map[string]any{
+			":store":     store,
+			":algorithm": drvHash.Type().String(),
+			":bits":      drvHash.Bytes(nil),
+			":now":       now.UnixMilli(),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("record substituter miss for %v: %v", drvHash, err)
+	}
+	return nil
+}
+
+// deleteSubstituterMiss removes any record that the fallback store named store
+// had no realizations for drvHash.
+func deleteSubstituterMiss(conn *sqlite.Conn, store string, drvHash nix.Hash) error {
+	err := sqlitex.ExecuteFS(conn, sqlFiles(), "substituter_misses/delete.sql", &sqlitex.ExecOptions{
+		Named: map[string]any{
+			":store":     store,
+			":algorithm": drvHash.Type().String(),
+			":bits":      drvHash.Bytes(nil),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("delete substituter miss for %v: %v", drvHash, err)
+	}
+	return nil
+}
+
+// deleteExpiredSubstituterMisses removes records of misses
+// that were recorded before notBefore.
+func deleteExpiredSubstituterMisses(conn *sqlite.Conn, notBefore time.Time) (int64, error) {
+	err := sqlitex.ExecuteFS(conn, sqlFiles(), "substituter_misses/delete_expired.sql", &sqlitex.ExecOptions{
+		Named: map[string]any{
+			":not_before": notBefore.UnixMilli(),
+		},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("delete expired substituter misses: %v", err)
+	}
+	return int64(conn.Changes()), nil
+}
+
+func (s *Server) clearSubstituterMisses(ctx context.Context, req *jsonrpc.Request) (*jsonrpc.Response, error) {
+	var args zbstorerpc.ClearSubstituterMissesRequest
+	if err := jsonv2.Unmarshal(req.Params, &args); err != nil {
+		return nil, jsonrpc.Error(jsonrpc.InvalidParams, err)
+	}
+
+	conn, err := s.db.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer s.db.Put(conn)
+
+	if err := sqlitex.ExecuteFS(conn, sqlFiles(), "substituter_misses/clear.sql", nil); err != nil {
+		return nil, fmt.Errorf("clear substituter misses: %v", err)
+	}
+	return marshalResponse(&zbstorerpc.ClearSubstituterMissesResponse{
+		Deleted: int64(conn.Changes()),
+	})
+}
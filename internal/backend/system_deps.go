@@ -0,0 +1,112 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package backend
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"strings"
+
+	"zombiezen.com/go/log"
+	"zombiezen.com/go/nix"
+	"zombiezen.com/go/nix/nar"
+)
+
+// parseHashedSystemDeps parses the value of the __hashedSystemDeps environment variable.
+// The value is a whitespace-separated list of PATH=HASH pairs,
+// where PATH is an absolute path on the host machine
+// and HASH is the expected NAR hash of the file or directory at PATH.
+func parseHashedSystemDeps(s string) (map[string]nix.Hash, error) {
+	var result map[string]nix.Hash
+	for field := range strings.FieldsSeq(s) {
+		// Paths can't contain '=' but base64-encoded hashes can end with it.
+		path, rawHash, ok := strings.Cut(field, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s: %q is not in the form PATH=HASH", hashedSystemDepsVar, field)
+		}
+		if !filepath.IsAbs(path) || filepath.Clean(path) != path {
+			return nil, fmt.Errorf("%s: %q is not a clean absolute path", hashedSystemDepsVar, path)
+		}
+		h, err := nix.ParseHash(rawHash)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %v", hashedSystemDepsVar, path, err)
+		}
+		if _, dup := result[path]; dup {
+			return nil, fmt.Errorf("%s: %s listed multiple times", hashedSystemDepsVar, path)
+		}
+		if result == nil {
+			result = make(map[string]nix.Hash)
+		}
+		result[path] = h
+	}
+	return result, nil
+}
+
+// verifyHashedSystemDeps checks that each of the paths
+// in the value of a __hashedSystemDeps environment variable
+// is allowed by the server's HashedSandboxPaths
+// and has the declared NAR hash.
+// It returns a map of paths inside the sandbox to paths on the host machine
+// suitable for [builderInvocation.sandboxPaths].
+func (s *Server) verifyHashedSystemDeps(ctx context.Context, deps string) (map[string]string, error) {
+	hashes, err := parseHashedSystemDeps(deps)
+	if err != nil || len(hashes) == 0 {
+		return nil, err
+	}
+	result := make(map[string]string, len(hashes))
+	for path, want := range hashes {
+		if !isHashedPathAllowed(s.hashedPaths, path) {
+			return nil, fmt.Errorf("system dependency %s not allowed", path)
+		}
+		// Resolve symlinks so that the hash covers the content that the builder sees.
+		hostPath, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			return nil, fmt.Errorf("system dependency %s: %v", path, err)
+		}
+		if !isHashedPathAllowed(s.hashedPaths, hostPath) {
+			return nil, fmt.Errorf("system dependency %s: resolves to %s, which is not allowed", path, hostPath)
+		}
+		log.Debugf(ctx, "Hashing system dependency %s...", hostPath)
+		h := nix.NewHasher(want.Type())
+		if err := nar.DumpPath(h, hostPath); err != nil {
+			return nil, fmt.Errorf("system dependency %s: %v", path, err)
+		}
+		if got := h.SumHash(); !got.Equal(want) {
+			return nil, fmt.Errorf("system dependency %s has hash %v (expected %v)", path, got.SRI(), want.SRI())
+		}
+		result[path] = hostPath
+	}
+	return result, nil
+}
+
+// isHashedPathAllowed reports whether path is one of the allowed paths
+// or inside one of the allowed directories.
+func isHashedPathAllowed(allowed []string, path string) bool {
+	for _, dir := range allowed {
+		rel, err := filepath.Rel(dir, path)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// withSandboxPaths returns a [runnerFunc] that calls f
+// with the given paths added to the invocation's sandboxPaths.
+func withSandboxPaths(f runnerFunc, paths map[string]string) runnerFunc {
+	if len(paths) == 0 {
+		return f
+	}
+	return func(ctx context.Context, invocation *builderInvocation) error {
+		newInvocation := *invocation
+		newInvocation.sandboxPaths = maps.Clone(invocation.sandboxPaths)
+		if newInvocation.sandboxPaths == nil {
+			newInvocation.sandboxPaths = make(map[string]string, len(paths))
+		}
+		maps.Copy(newInvocation.sandboxPaths, paths)
+		return f(ctx, &newInvocation)
+	}
+}
@@ -0,0 +1,42 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package backend
+
+import (
+	"os"
+	"runtime"
+	"slices"
+	"strings"
+
+	"zb.256lights.llc/pkg/sets"
+)
+
+// DefaultSystemFeatures returns the system features
+// that the current machine is assumed to support.
+// The "kvm" feature is only included if /dev/kvm can be opened.
+func DefaultSystemFeatures() []string {
+	features := []string{"benchmark", "big-parallel"}
+	if runtime.GOOS == "linux" {
+		if f, err := os.OpenFile("/dev/kvm", os.O_RDWR, 0); err == nil {
+			f.Close()
+			features = append(features, "kvm")
+		}
+	}
+	return features
+}
+
+// missingSystemFeatures returns the features
+// in the whitespace-separated list of required features
+// that are not in supported.
+// The returned slice is sorted and has no duplicates.
+func missingSystemFeatures(required string, supported sets.Set[string]) []string {
+	var missing []string
+	for feature := range strings.FieldsSeq(required) {
+		if !supported.Has(feature) {
+			missing = append(missing, feature)
+		}
+	}
+	slices.Sort(missing)
+	return slices.Compact(missing)
+}
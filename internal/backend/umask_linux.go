@@ -0,0 +1,50 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package backend
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+
+	"golang.org/x/sys/unix"
+)
+
+// startCommand starts c, optionally with a umask of 022.
+// The umask is process-wide, so to avoid affecting files that the server creates,
+// startCommand starts c from a dedicated OS thread
+// that has its own umask (see [setThreadUmask]).
+func startCommand(c *exec.Cmd, enforceUmask bool) error {
+	if !enforceUmask {
+		return c.Start()
+	}
+	errc := make(chan error, 1)
+	go func() {
+		// The thread is intentionally never unlocked
+		// so that it exits along with the goroutine
+		// instead of running other goroutines with the builder's umask.
+		runtime.LockOSThread()
+		if err := setThreadUmask(0o022); err != nil {
+			errc <- err
+			return
+		}
+		errc <- c.Start()
+	}()
+	return <-errc
+}
+
+// setThreadUmask sets the umask of the calling OS thread
+// without changing the umask of the rest of the process.
+// Processes started from the thread inherit its umask.
+// The caller must have locked the goroutine to the thread
+// with [runtime.LockOSThread] and must never unlock it.
+func setThreadUmask(mask int) error {
+	// Each thread shares its umask with the rest of the process
+	// until it gets its own copy of the filesystem attributes.
+	if err := unix.Unshare(unix.CLONE_FS); err != nil {
+		return fmt.Errorf("set builder umask: %v", err)
+	}
+	unix.Umask(mask)
+	return nil
+}
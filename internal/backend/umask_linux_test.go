@@ -0,0 +1,35 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package backend
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestStartCommandUmask(t *testing.T) {
+	shPath, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh not found")
+	}
+	// Reading the umask requires setting it.
+	processMask := unix.Umask(0o077)
+	defer unix.Umask(processMask)
+
+	c := exec.Command(shPath, "-c", "umask")
+	out := new(strings.Builder)
+	c.Stdout = out
+	if err := runCommand(c, true); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.TrimSpace(out.String()), "0022"; got != want {
+		t.Errorf("builder umask = %s; want %s", got, want)
+	}
+	if got := unix.Umask(0o077); got != 0o077 {
+		t.Errorf("process umask = %#o after starting builder; want %#o", got, 0o077)
+	}
+}
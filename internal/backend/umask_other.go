@@ -0,0 +1,20 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+//go:build unix && !linux
+
+package backend
+
+import "os/exec"
+
+// startCommand starts c, optionally with a umask of 022.
+// The umask is process-wide, so to avoid affecting files that the server creates,
+// startCommand runs c through /bin/sh, which sets its own umask.
+func startCommand(c *exec.Cmd, enforceUmask bool) error {
+	if enforceUmask {
+		const script = `umask 022 && exec "$0" "$@"`
+		c.Args = append([]string{"/bin/sh", "-c", script, c.Path}, c.Args[1:]...)
+		c.Path = "/bin/sh"
+	}
+	return c.Start()
+}
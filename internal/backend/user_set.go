@@ -13,48 +13,57 @@ import (
 )
 
 // userSet acts as a semaphore for build users.
+// Users from the static list are handed out first.
+// Once those are exhausted, users are allocated from the ephemeral range.
 // Methods on userSet are safe to call concurrently from multiple goroutines.
 type userSet struct {
 	users       []BuildUser
+	ephemeral   BuildUserRange
 	releaseFull chan struct{}
 
-	mu    sync.Mutex
-	inUse sets.Bit
+	mu             sync.Mutex
+	inUse          sets.Bit
+	ephemeralInUse sets.Bit
+	// released is closed and replaced on every release
+	// to wake callers of acquireUser.
+	released chan struct{}
 }
 
-func newUserSet(users []BuildUser) (*userSet, error) {
+func newUserSet(users []BuildUser, ephemeral BuildUserRange) (*userSet, error) {
+	if ephemeral.Count < 0 || ephemeral.Count > 0 && ephemeral.Start < 0 {
+		return nil, fmt.Errorf("invalid build user range %d+%d", ephemeral.Start, ephemeral.Count)
+	}
 	for i, u1 := range users {
 		for _, u2 := range users[i+1:] {
 			if u1.UID == u2.UID {
 				return nil, fmt.Errorf("uid %d used multiple times", u1.UID)
 			}
 		}
+		if ephemeral.Contains(u1.UID) {
+			return nil, fmt.Errorf("uid %d is in build user range %v", u1.UID, ephemeral)
+		}
 	}
 	return &userSet{
 		users:       slices.Clone(users),
+		ephemeral:   ephemeral,
 		releaseFull: make(chan struct{}, 1),
 	}, nil
 }
 
+// size returns the total number of users in the set.
+func (users *userSet) size() int {
+	return len(users.users) + max(users.ephemeral.Count, 0)
+}
+
 func (users *userSet) acquire(ctx context.Context) (*BuildUser, error) {
-	if len(users.users) == 0 {
+	if users.size() == 0 {
 		return nil, nil
 	}
 
 	for {
-		users.mu.Lock()
-		if users.inUse.Len() < len(users.users) {
-			for i := range users.users {
-				if !users.inUse.Has(uint(i)) {
-					users.inUse.Add(uint(i))
-					users.mu.Unlock()
-					u := users.users[i]
-					return &u, nil
-				}
-			}
+		if u := users.tryAcquire(); u != nil {
+			return u, nil
 		}
-		users.mu.Unlock()
-
 		select {
 		case <-users.releaseFull:
 		case <-ctx.Done():
@@ -63,22 +72,103 @@ func (users *userSet) acquire(ctx context.Context) (*BuildUser, error) {
 	}
 }
 
+// acquireUser waits for the given user to become available and acquires it.
+// If the user is not in the set, acquireUser acquires any user as [*userSet.acquire] does.
+func (users *userSet) acquireUser(ctx context.Context, want BuildUser) (*BuildUser, error) {
+	for {
+		u, released, known := users.tryAcquireUser(want)
+		if !known {
+			return users.acquire(ctx)
+		}
+		if u != nil {
+			return u, nil
+		}
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// tryAcquireUser acquires the given user if it is unused.
+// known reports whether the user is in the set.
+// If the user is in use, tryAcquireUser returns a channel
+// that will be closed on the next release.
+func (users *userSet) tryAcquireUser(want BuildUser) (_ *BuildUser, released <-chan struct{}, known bool) {
+	users.mu.Lock()
+	defer users.mu.Unlock()
+
+	var inUse *sets.Bit
+	var i uint
+	if j := slices.Index(users.users, want); j >= 0 {
+		inUse, i = &users.inUse, uint(j)
+	} else if users.ephemeral.Contains(want.UID) && want.GID == users.ephemeral.GID {
+		inUse, i = &users.ephemeralInUse, uint(want.UID-users.ephemeral.Start)
+	} else {
+		return nil, nil, false
+	}
+	if !inUse.Has(i) {
+		inUse.Add(i)
+		return &want, nil, true
+	}
+	if users.released == nil {
+		users.released = make(chan struct{})
+	}
+	return nil, users.released, true
+}
+
+// tryAcquire returns an unused user
+// or nil if all users are in use.
+func (users *userSet) tryAcquire() *BuildUser {
+	users.mu.Lock()
+	defer users.mu.Unlock()
+
+	if users.inUse.Len() < len(users.users) {
+		for i := range users.users {
+			if !users.inUse.Has(uint(i)) {
+				users.inUse.Add(uint(i))
+				u := users.users[i]
+				return &u
+			}
+		}
+	}
+	if users.ephemeralInUse.Len() < users.ephemeral.Count {
+		for i := range users.ephemeral.Count {
+			if !users.ephemeralInUse.Has(uint(i)) {
+				users.ephemeralInUse.Add(uint(i))
+				return &BuildUser{
+					UID: users.ephemeral.Start + i,
+					GID: users.ephemeral.GID,
+				}
+			}
+		}
+	}
+	return nil
+}
+
 func (users *userSet) release(user *BuildUser) {
 	if user == nil {
-		if len(users.users) > 0 {
+		if users.size() > 0 {
 			panic("userSet.release(nil)")
 		}
 		return
 	}
 
-	i := slices.Index(users.users, *user)
-	if i < 0 {
+	users.mu.Lock()
+	shouldNotify := users.inUse.Len()+users.ephemeralInUse.Len() == users.size()
+	if i := slices.Index(users.users, *user); i >= 0 {
+		users.inUse.Delete(uint(i))
+	} else if users.ephemeral.Contains(user.UID) && user.GID == users.ephemeral.GID {
+		users.ephemeralInUse.Delete(uint(user.UID - users.ephemeral.Start))
+	} else {
+		users.mu.Unlock()
 		panic("userSet.release on unknown user")
 	}
-
-	users.mu.Lock()
-	shouldNotify := users.inUse.Len() == len(users.users)
-	users.inUse.Delete(uint(i))
+	if users.released != nil {
+		close(users.released)
+		users.released = nil
+	}
 	users.mu.Unlock()
 
 	if shouldNotify {
@@ -0,0 +1,141 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package backend
+
+import (
+	"context"
+	"testing"
+	"testing/synctest"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestUserSet(t *testing.T) {
+	t.Run("EphemeralAfterStatic", func(t *testing.T) {
+		users, err := newUserSet(
+			[]BuildUser{{UID: 1001, GID: 100}},
+			BuildUserRange{Start: 30000, Count: 2, GID: 100},
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx := context.Background()
+		var got []BuildUser
+		for range 3 {
+			u, err := users.acquire(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, *u)
+		}
+		want := []BuildUser{
+			{UID: 1001, GID: 100},
+			{UID: 30000, GID: 100},
+			{UID: 30001, GID: 100},
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("acquired users (-want +got):\n%s", diff)
+		}
+
+		users.release(&got[1])
+		u, err := users.acquire(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *u != got[1] {
+			t.Errorf("after release, acquire() = %v; want %v", u, got[1])
+		}
+	})
+
+	t.Run("WaitsWhenExhausted", func(t *testing.T) {
+		synctest.Test(t, func(t *testing.T) {
+			users, err := newUserSet(nil, BuildUserRange{Start: 30000, Count: 1, GID: 100})
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx := context.Background()
+			first, err := users.acquire(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			done := make(chan *BuildUser)
+			go func() {
+				u, err := users.acquire(ctx)
+				if err != nil {
+					t.Error(err)
+				}
+				done <- u
+			}()
+			synctest.Wait()
+			select {
+			case u := <-done:
+				t.Fatalf("acquire() = %v before release", u)
+			default:
+			}
+			users.release(first)
+			if u := <-done; *u != *first {
+				t.Errorf("acquire() = %v; want %v", u, first)
+			}
+		})
+	})
+
+	t.Run("AcquireUser", func(t *testing.T) {
+		synctest.Test(t, func(t *testing.T) {
+			users, err := newUserSet(
+				[]BuildUser{{UID: 1001, GID: 100}},
+				BuildUserRange{Start: 30000, Count: 2, GID: 100},
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx := context.Background()
+			want := BuildUser{UID: 30001, GID: 100}
+			first, err := users.acquireUser(ctx, want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *first != want {
+				t.Errorf("acquireUser(ctx, %v) = %v", want, first)
+			}
+
+			done := make(chan *BuildUser)
+			go func() {
+				u, err := users.acquireUser(ctx, want)
+				if err != nil {
+					t.Error(err)
+				}
+				done <- u
+			}()
+			synctest.Wait()
+			select {
+			case u := <-done:
+				t.Fatalf("acquireUser(ctx, %v) = %v before release", want, u)
+			default:
+			}
+			users.release(first)
+			if u := <-done; *u != want {
+				t.Errorf("acquireUser(ctx, %v) = %v after release", want, u)
+			}
+
+			unknown := BuildUser{UID: 5000, GID: 100}
+			u, err := users.acquireUser(ctx, unknown)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := *u, (BuildUser{UID: 1001, GID: 100}); got != want {
+				t.Errorf("acquireUser(ctx, %v) = %v; want %v", unknown, got, want)
+			}
+		})
+	})
+
+	t.Run("Overlap", func(t *testing.T) {
+		_, err := newUserSet(
+			[]BuildUser{{UID: 30001, GID: 100}},
+			BuildUserRange{Start: 30000, Count: 2, GID: 100},
+		)
+		if err == nil {
+			t.Error("newUserSet did not return an error")
+		}
+	})
+}
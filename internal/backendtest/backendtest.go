@@ -52,10 +52,16 @@ type Options struct {
 	backend.Options
 
 	// TempDir is the directory to use to store intermediate build results
-	// and the store database.
+	// and build logs.
+	// The store database is always kept in memory.
 	// If empty, then a new directory is created and registered for cleanup.
 	TempDir string
 
+	// If Sandbox is true, then the server runs builders in a sandbox
+	// if [backend.CanSandbox] reports true.
+	// By default, test servers do not use a sandbox.
+	Sandbox bool
+
 	ClientOptions zbstorerpc.CodecOptions
 }
 
@@ -92,7 +98,10 @@ func NewServer(ctx context.Context, tb TB, storeDir zbstore.Directory, opts *Opt
 		*opts2 = opts.Options
 	}
 	opts2.BuildDirectory = buildDir
-	opts2.DisableSandbox = true
+	if opts2.LogDirectory == "" {
+		opts2.LogDirectory = filepath.Join(tempDir, "log")
+	}
+	opts2.DisableSandbox = !opts.Sandbox
 	if opts2.CoresPerBuild < 1 {
 		opts2.CoresPerBuild = 1
 	}
@@ -105,13 +114,14 @@ func NewServer(ctx context.Context, tb TB, storeDir zbstore.Directory, opts *Opt
 	if realStoreDir == "" {
 		realStoreDir = string(storeDir)
 	}
-	srv := backend.NewServer(storeDir, filepath.Join(tempDir, "db.sqlite"), opts2)
+	srv := backend.NewServer(storeDir, backend.MemoryDatabase, opts2)
 	serverConn, clientConn := net.Pipe()
 
 	serveCtx, stopServe := context.WithCancel(context.WithoutCancel(ctx))
 	serverReceiver := srv.NewNARReceiver(serveCtx, bytebuffer.BufferCreator{})
 	serverCodec := zbstorerpc.NewCodec(serverConn, &zbstorerpc.CodecOptions{
 		Importer: zbstorerpc.NewReceiverImporter(serverReceiver),
+		Msgpack:  true,
 	})
 	wg.Go(func() {
 		jsonrpc.Serve(backend.WithExporter(serveCtx, serverCodec), serverCodec, srv)
@@ -121,13 +131,16 @@ func NewServer(ctx context.Context, tb TB, storeDir zbstore.Directory, opts *Opt
 	clientCodec := zbstorerpc.NewCodec(clientConn, &opts.ClientOptions)
 	client := jsonrpc.NewClient(func(ctx context.Context) (jsonrpc.ClientCodec, error) {
 		return clientCodec, nil
-	})
+	}, nil)
 
 	tb.Cleanup(func() {
 		if err := client.Close(); err != nil {
 			tb.Logf("client.Close: %v", err)
 			tb.Fail()
 		}
+		// The client only closes the codec if it connected,
+		// so close it here in case the test never sent a request.
+		clientCodec.Close()
 
 		stopServe()
 		wg.Wait()
@@ -197,6 +210,7 @@ func WaitForSuccessfulBuild(ctx context.Context, client jsonrpc.Handler, buildID
 }
 
 // ReadLog reads the entire log for the given build and derivation path into memory.
+// The log is read without line prefixes.
 func ReadLog(ctx context.Context, client *jsonrpc.Client, buildID string, drvPath zbstore.Path) ([]byte, error) {
 	buf := new(bytes.Buffer)
 	for {
@@ -0,0 +1,161 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+// Package credentials finds secrets used to authenticate requests
+// to remote sources like HTTPS servers.
+// Credentials are only attached to requests as they are sent:
+// they must never be stored in derivations, store objects, or logs.
+package credentials
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"zb.256lights.llc/pkg/internal/netrc"
+)
+
+// Credentials is a secret used to authenticate to a remote source.
+// The String and GoString methods do not include the secret,
+// so Credentials can be safely formatted in logs.
+type Credentials struct {
+	// Username is the user name to use with HTTP basic authentication.
+	Username string
+	// Password is the password to use with HTTP basic authentication.
+	Password string
+	// Token is a bearer token.
+	// If Token is not empty, then Username and Password are ignored.
+	Token string
+}
+
+// SetAuthorization sets the Authorization header of req from c.
+func (c *Credentials) SetAuthorization(req *http.Request) {
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	} else {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+}
+
+// String returns a description of the credentials without the secret.
+func (c *Credentials) String() string {
+	if c == nil {
+		return "<nil>"
+	}
+	if c.Token != "" {
+		return "token"
+	}
+	return "user " + c.Username
+}
+
+// GoString returns the same value as [*Credentials.String].
+func (c *Credentials) GoString() string {
+	return c.String()
+}
+
+// A Provider finds credentials for URLs.
+type Provider interface {
+	// Credentials returns the credentials to use for a request to u.
+	// If u has a username, then the provider should only return credentials for that user.
+	// If the provider does not have credentials for u,
+	// then Credentials returns (nil, nil).
+	Credentials(ctx context.Context, u *url.URL) (*Credentials, error)
+}
+
+// Chain is a [Provider] that returns the first credentials found
+// by its elements, in order.
+type Chain []Provider
+
+// Credentials calls each provider in the chain until one returns credentials.
+func (chain Chain) Credentials(ctx context.Context, u *url.URL) (*Credentials, error) {
+	for _, p := range chain {
+		c, err := p.Credentials(ctx, u)
+		if c != nil || err != nil {
+			return c, err
+		}
+	}
+	return nil, nil
+}
+
+// Netrc is a [Provider] that finds credentials in the content of a [.netrc file].
+//
+// [.netrc file]: https://everything.curl.dev/usingcurl/netrc.html
+type Netrc []byte
+
+// Credentials returns the first entry in the .netrc file that matches u's host
+// (and username, if present).
+func (data Netrc) Credentials(ctx context.Context, u *url.URL) (*Credentials, error) {
+	var userinfo *url.Userinfo
+	if username := u.User.Username(); username != "" {
+		userinfo = netrc.FindUser(data, u.Host, username)
+	} else {
+		userinfo = netrc.Find(data, u.Host)
+	}
+	password, hasPassword := userinfo.Password()
+	if !hasPassword {
+		return nil, nil
+	}
+	return &Credentials{
+		Username: userinfo.Username(),
+		Password: password,
+	}, nil
+}
+
+// TokenEnvPrefix is the prefix of the environment variables
+// read by [Environment].
+const TokenEnvPrefix = "ZB_TOKEN_"
+
+// Environment is a [Provider] that finds bearer tokens in environment variables.
+// The token for a host is read from the variable returned by [TokenEnvVar].
+// If the URL has an explicit port and the variable for the host and port is not set,
+// then the variable for the host without the port is used.
+// URLs with a username are not matched.
+type Environment struct {
+	// Getenv returns the value of the environment variable with the given name
+	// or the empty string if it is not set.
+	// If Getenv is nil, then [os.Getenv] is used.
+	Getenv func(key string) string
+}
+
+// Credentials returns the token in the environment variable for u's host, if set.
+func (env Environment) Credentials(ctx context.Context, u *url.URL) (*Credentials, error) {
+	if u.User.Username() != "" || u.Host == "" {
+		return nil, nil
+	}
+	getenv := env.Getenv
+	if getenv == nil {
+		getenv = os.Getenv
+	}
+	token := getenv(TokenEnvVar(u.Host))
+	if token == "" && u.Port() != "" {
+		token = getenv(TokenEnvVar(u.Hostname()))
+	}
+	if token == "" {
+		return nil, nil
+	}
+	return &Credentials{Token: token}, nil
+}
+
+// TokenEnvVar returns the name of the environment variable
+// that [Environment] reads the token for host from.
+// The name is [TokenEnvPrefix] followed by the upper-cased host
+// with every character other than an ASCII letter or digit replaced with an underscore.
+// For example, the token for "api.example.com:8443" is read from ZB_TOKEN_API_EXAMPLE_COM_8443.
+func TokenEnvVar(host string) string {
+	sb := new(strings.Builder)
+	sb.Grow(len(TokenEnvPrefix) + len(host))
+	sb.WriteString(TokenEnvPrefix)
+	for i := range len(host) {
+		switch c := host[i]; {
+		case 'A' <= c && c <= 'Z' || '0' <= c && c <= '9':
+			sb.WriteByte(c)
+		case 'a' <= c && c <= 'z':
+			sb.WriteByte(c - 'a' + 'A')
+		default:
+			sb.WriteByte('_')
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,271 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package credentials
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const helperEnvVar = "ZB_TEST_CREDENTIAL_HELPER"
+
+func TestMain(m *testing.M) {
+	if countFile := os.Getenv(helperEnvVar); countFile != "" {
+		os.Exit(runTestHelper(countFile))
+	}
+	os.Exit(m.Run())
+}
+
+// runTestHelper is a credential helper used by [TestHelper].
+// It appends a line to countFile every time it is run.
+func runTestHelper(countFile string) int {
+	if os.Args[len(os.Args)-1] != "get" {
+		fmt.Fprintln(os.Stderr, "last argument is not get")
+		return 1
+	}
+	f, err := os.OpenFile(countFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o666)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	f.WriteString("run\n")
+	f.Close()
+
+	attrs := make(map[string]string)
+	s := bufio.NewScanner(os.Stdin)
+	for s.Scan() && s.Text() != "" {
+		k, v, _ := strings.Cut(s.Text(), "=")
+		attrs[k] = v
+	}
+	switch attrs["host"] {
+	case "basic.example.com":
+		if attrs["protocol"] != "https" || attrs["path"] != "foo/bar.tar.gz" {
+			fmt.Fprintf(os.Stderr, "unexpected attributes %q\n", attrs)
+			return 1
+		}
+		username := attrs["username"]
+		if username == "" {
+			username = "alice"
+		}
+		fmt.Printf("protocol=https\nhost=basic.example.com\nusername=%s\npassword=xyzzy\n", username)
+	case "token.example.com":
+		if attrs["capability[]"] != "authtype" {
+			fmt.Fprintln(os.Stderr, "missing authtype capability")
+			return 1
+		}
+		fmt.Println("authtype=Bearer")
+		fmt.Println("credential=s3cret")
+	case "fail.example.com":
+		fmt.Fprintln(os.Stderr, "no credentials for you")
+		return 1
+	}
+	return 0
+}
+
+func TestNetrc(t *testing.T) {
+	p := Netrc("machine example.com\n" +
+		"login alice\n" +
+		"password xyzzy\n" +
+		"machine example.com\n" +
+		"login bob\n" +
+		"password plugh\n")
+
+	tests := []struct {
+		url  string
+		want *Credentials
+	}{
+		{"https://example.com/foo", &Credentials{Username: "alice", Password: "xyzzy"}},
+		{"https://bob@example.com/foo", &Credentials{Username: "bob", Password: "plugh"}},
+		{"https://carol@example.com/foo", nil},
+		{"https://example.org/foo", nil},
+	}
+	for _, test := range tests {
+		got, err := p.Credentials(t.Context(), mustParseURL(t, test.url))
+		if err != nil {
+			t.Errorf("Netrc.Credentials(ctx, %q): %v", test.url, err)
+			continue
+		}
+		if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("Netrc.Credentials(ctx, %q) (-want +got):\n%s", test.url, diff)
+		}
+	}
+}
+
+func TestEnvironment(t *testing.T) {
+	env := map[string]string{
+		"ZB_TOKEN_EXAMPLE_COM":      "abc",
+		"ZB_TOKEN_EXAMPLE_COM_8443": "def",
+	}
+	p := Environment{Getenv: func(key string) string { return env[key] }}
+
+	tests := []struct {
+		url  string
+		want *Credentials
+	}{
+		{"https://example.com/foo", &Credentials{Token: "abc"}},
+		{"https://EXAMPLE.com/foo", &Credentials{Token: "abc"}},
+		{"https://example.com:8443/foo", &Credentials{Token: "def"}},
+		{"https://example.com:9000/foo", &Credentials{Token: "abc"}},
+		{"https://alice@example.com/foo", nil},
+		{"https://example.org/foo", nil},
+	}
+	for _, test := range tests {
+		got, err := p.Credentials(t.Context(), mustParseURL(t, test.url))
+		if err != nil {
+			t.Errorf("Environment.Credentials(ctx, %q): %v", test.url, err)
+			continue
+		}
+		if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("Environment.Credentials(ctx, %q) (-want +got):\n%s", test.url, diff)
+		}
+	}
+}
+
+func TestTokenEnvVar(t *testing.T) {
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"example.com", "ZB_TOKEN_EXAMPLE_COM"},
+		{"api.Example-2.com:8443", "ZB_TOKEN_API_EXAMPLE_2_COM_8443"},
+		{"[::1]", "ZB_TOKEN____1_"},
+	}
+	for _, test := range tests {
+		if got := TokenEnvVar(test.host); got != test.want {
+			t.Errorf("TokenEnvVar(%q) = %q; want %q", test.host, got, test.want)
+		}
+	}
+}
+
+func TestChain(t *testing.T) {
+	p := Chain{
+		Netrc("machine example.com login alice password xyzzy\n"),
+		Environment{Getenv: func(key string) string {
+			if key == "ZB_TOKEN_EXAMPLE_COM" || key == "ZB_TOKEN_EXAMPLE_ORG" {
+				return "abc"
+			}
+			return ""
+		}},
+	}
+
+	tests := []struct {
+		url  string
+		want *Credentials
+	}{
+		{"https://example.com/foo", &Credentials{Username: "alice", Password: "xyzzy"}},
+		{"https://example.org/foo", &Credentials{Token: "abc"}},
+		{"https://example.net/foo", nil},
+	}
+	for _, test := range tests {
+		got, err := p.Credentials(t.Context(), mustParseURL(t, test.url))
+		if err != nil {
+			t.Errorf("Chain.Credentials(ctx, %q): %v", test.url, err)
+			continue
+		}
+		if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("Chain.Credentials(ctx, %q) (-want +got):\n%s", test.url, diff)
+		}
+	}
+}
+
+func TestHelper(t *testing.T) {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	countFile := filepath.Join(t.TempDir(), "count.txt")
+	t.Setenv(helperEnvVar, countFile)
+	h := &Helper{Command: []string{exe, "-test.run=^$"}}
+
+	tests := []struct {
+		url     string
+		want    *Credentials
+		wantErr bool
+	}{
+		{url: "https://basic.example.com/foo/bar.tar.gz", want: &Credentials{Username: "alice", Password: "xyzzy"}},
+		{url: "https://bob@basic.example.com/foo/bar.tar.gz", want: &Credentials{Username: "bob", Password: "xyzzy"}},
+		{url: "https://token.example.com/", want: &Credentials{Token: "s3cret"}},
+		{url: "https://none.example.com/", want: nil},
+		{url: "https://fail.example.com/", wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := h.Credentials(t.Context(), mustParseURL(t, test.url))
+		if err != nil {
+			if !test.wantErr {
+				t.Errorf("Helper.Credentials(ctx, %q): %v", test.url, err)
+			}
+			continue
+		}
+		if test.wantErr {
+			t.Errorf("Helper.Credentials(ctx, %q) = %v, <nil>; want _, <error>", test.url, got)
+			continue
+		}
+		if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("Helper.Credentials(ctx, %q) (-want +got):\n%s", test.url, diff)
+		}
+	}
+
+	// Results should be cached.
+	beforeCount := countRuns(t, countFile)
+	if _, err := h.Credentials(t.Context(), mustParseURL(t, "https://basic.example.com/foo/bar.tar.gz")); err != nil {
+		t.Error(err)
+	}
+	if afterCount := countRuns(t, countFile); afterCount != beforeCount {
+		t.Errorf("helper ran %d more times for cached URL", afterCount-beforeCount)
+	}
+}
+
+func TestCredentialsString(t *testing.T) {
+	c := &Credentials{Username: "alice", Password: "xyzzy"}
+	tok := &Credentials{Token: "s3cret"}
+	for _, s := range []string{
+		fmt.Sprint(c), fmt.Sprintf("%+v", c), fmt.Sprintf("%#v", c),
+		fmt.Sprint(tok), fmt.Sprintf("%+v", tok), fmt.Sprintf("%#v", tok),
+	} {
+		if strings.Contains(s, "xyzzy") || strings.Contains(s, "s3cret") {
+			t.Errorf("formatted credentials %q contains secret", s)
+		}
+	}
+}
+
+func TestSetAuthorization(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "https://example.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	(&Credentials{Token: "s3cret"}).SetAuthorization(req)
+	if got, want := req.Header.Get("Authorization"), "Bearer s3cret"; got != want {
+		t.Errorf("Authorization = %q; want %q", got, want)
+	}
+	(&Credentials{Username: "alice", Password: "xyzzy"}).SetAuthorization(req)
+	if username, password, ok := req.BasicAuth(); !ok || username != "alice" || password != "xyzzy" {
+		t.Errorf("BasicAuth() = %q, %q, %t; want \"alice\", \"xyzzy\", true", username, password, ok)
+	}
+}
+
+func countRuns(tb testing.TB, countFile string) int {
+	tb.Helper()
+	data, err := os.ReadFile(countFile)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return strings.Count(string(data), "\n")
+}
+
+func mustParseURL(tb testing.TB, s string) *url.URL {
+	tb.Helper()
+	u, err := url.Parse(s)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return u
+}
@@ -0,0 +1,146 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package credentials
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// Helper is a [Provider] that runs an external program to find credentials.
+// The program speaks the [git credential helper protocol]:
+// it is run with "get" as its last argument,
+// receives a description of the URL on standard input,
+// and prints the credentials (if any) on standard output.
+// Helpers can return a bearer token by printing "authtype=Bearer"
+// and the token in the "credential" attribute.
+// The program's standard error is passed through to the current process's standard error.
+//
+// Results are cached for the lifetime of the Helper,
+// so the program is only run once for each protocol, host, and username.
+//
+// [git credential helper protocol]: https://git-scm.com/docs/git-credential#IOFMT
+type Helper struct {
+	// Command is the program to run followed by its arguments.
+	Command []string
+
+	mu    sync.Mutex
+	cache map[helperCacheKey]*Credentials
+}
+
+type helperCacheKey struct {
+	protocol string
+	host     string
+	username string
+}
+
+// Credentials runs the helper program for u,
+// or returns the credentials from a previous run for the same protocol, host, and username.
+func (h *Helper) Credentials(ctx context.Context, u *url.URL) (*Credentials, error) {
+	if len(h.Command) == 0 {
+		return nil, errors.New("credential helper: empty command")
+	}
+	key := helperCacheKey{
+		protocol: u.Scheme,
+		host:     u.Host,
+		username: u.User.Username(),
+	}
+	if strings.ContainsAny(key.protocol+key.host+key.username+u.Path, "\x00\n") {
+		return nil, fmt.Errorf("credential helper: %s contains a newline or NUL", u.Redacted())
+	}
+	h.mu.Lock()
+	c, ok := h.cache[key]
+	h.mu.Unlock()
+	if ok {
+		return c, nil
+	}
+
+	input := new(bytes.Buffer)
+	fmt.Fprintf(input, "protocol=%s\nhost=%s\n", key.protocol, key.host)
+	if u.Path != "" {
+		fmt.Fprintf(input, "path=%s\n", strings.TrimPrefix(u.Path, "/"))
+	}
+	if key.username != "" {
+		fmt.Fprintf(input, "username=%s\n", key.username)
+	}
+	input.WriteString("capability[]=authtype\n\n")
+
+	cmd := exec.CommandContext(ctx, h.Command[0], append(h.Command[1:len(h.Command):len(h.Command)], "get")...)
+	cmd.Stdin = input
+	cmd.Stderr = os.Stderr
+	output, err := cmd.Output()
+	if err != nil {
+		// Don't include output in the error: it may contain secrets.
+		return nil, fmt.Errorf("credential helper %s: %v", h.Command[0], err)
+	}
+	c, err = parseHelperOutput(output)
+	if err != nil {
+		return nil, fmt.Errorf("credential helper %s: %v", h.Command[0], err)
+	}
+	if c != nil && key.username != "" && c.Token == "" && c.Username != key.username {
+		// Helper returned credentials for a different user.
+		c = nil
+	}
+
+	h.mu.Lock()
+	if h.cache == nil {
+		h.cache = make(map[helperCacheKey]*Credentials)
+	}
+	h.cache[key] = c
+	h.mu.Unlock()
+	return c, nil
+}
+
+// parseHelperOutput parses the attributes printed by a credential helper.
+// It returns nil if the output does not contain a password or token.
+func parseHelperOutput(output []byte) (*Credentials, error) {
+	var username, password, authType, credential string
+	s := bufio.NewScanner(bytes.NewReader(output))
+	for s.Scan() {
+		line := s.Text()
+		if line == "" {
+			break
+		}
+		k, v, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid output line (missing '=')")
+		}
+		switch k {
+		case "username":
+			username = v
+		case "password":
+			password = v
+		case "authtype":
+			authType = v
+		case "credential":
+			credential = v
+		case "quit":
+			if v == "1" || v == "true" {
+				return nil, errors.New("helper requested to stop")
+			}
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	switch {
+	case authType != "" && credential != "":
+		if !strings.EqualFold(authType, "Bearer") {
+			return nil, fmt.Errorf("unsupported authtype %q", authType)
+		}
+		return &Credentials{Token: credential}, nil
+	case password != "":
+		return &Credentials{Username: username, Password: password}, nil
+	default:
+		return nil, nil
+	}
+}
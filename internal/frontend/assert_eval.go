@@ -0,0 +1,42 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package frontend
+
+import (
+	"context"
+	"fmt"
+
+	"zb.256lights.llc/pkg/internal/lua"
+)
+
+// assertMsgFunction is the implementation of the assertMsg built-in.
+// Unlike the standard assert function,
+// assertMsg requires a message
+// and prefixes it with the position of the call to assertMsg,
+// so that failures in deeply nested functions point to the assertion.
+func assertMsgFunction(ctx context.Context, l *lua.State) (int, error) {
+	if l.Type(1) == lua.TypeNone {
+		return 0, lua.NewArgError(l, 1, "value expected")
+	}
+	msg, err := lua.CheckString(l, 2)
+	if err != nil {
+		return 0, err
+	}
+	if !l.ToBoolean(1) {
+		return 0, fmt.Errorf("%sassertion failed: %s", lua.Where(l, 1), msg)
+	}
+	l.SetTop(1)
+	return 1, nil
+}
+
+// throwFunction is the implementation of the throw built-in.
+// It raises an error with the given message
+// prefixed with the position of the call to throw.
+func throwFunction(ctx context.Context, l *lua.State) (int, error) {
+	msg, err := lua.CheckString(l, 1)
+	if err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("%s%s", lua.Where(l, 1), msg)
+}
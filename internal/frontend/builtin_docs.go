@@ -0,0 +1,318 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package frontend
+
+import (
+	"slices"
+	"strings"
+
+	"zb.256lights.llc/pkg/internal/luadoc"
+)
+
+// builtinDocs is the documentation for the globals that zb adds to Lua,
+// sorted by name.
+// It should be kept in sync with zb_defs.lua.
+var builtinDocs = []*luadoc.Doc{
+	{
+		Name:   "addContext",
+		Kind:   luadoc.Function,
+		Params: []string{"s", "context"},
+		Text: "Return a copy of s with the given dependencies added.\n" +
+			"Every store path must exist in the store.",
+	},
+	{
+		Name:   "applyPatches",
+		Kind:   luadoc.Function,
+		Params: []string{"args"},
+		Text: "Create a derivation that copies src and applies the unified diffs in patches to it in order.\n" +
+			"strip is the number of leading path components to remove from file names in the patches\n" +
+			"(like patch -p, default 1).\n" +
+			"The patches are applied inside the store without running a builder program.",
+	},
+	{
+		Name:   "assertMsg",
+		Kind:   luadoc.Function,
+		Params: []string{"cond", "msg"},
+		Text: "Raise an error with msg if cond is false or nil.\n" +
+			"Unlike assert, the error message includes the position of the call to assertMsg.\n" +
+			"Returns cond otherwise.",
+	},
+	{
+		Name:   "await",
+		Kind:   luadoc.Function,
+		Params: []string{"x"},
+		Text:   "Force a module to load.",
+	},
+	{
+		Name:   "composeTree",
+		Kind:   luadoc.Function,
+		Params: []string{"args"},
+		Text: "Create a derivation whose output is a directory built from a description of its contents.\n" +
+			"tree maps slash-separated paths to entries:\n" +
+			"`{text = s, executable = b}` creates a file,\n" +
+			"`{symlink = target}` creates a symbolic link,\n" +
+			"and `{copy = path}` copies a store path.\n" +
+			"Every file and symlink in each directory in paths is symlinked at the same location.\n" +
+			"Two entries with the same path are an error unless ignoreCollisions is true,\n" +
+			"in which case the first one wins (tree entries come before paths).\n" +
+			"The output is created inside the store without running a builder program.",
+	},
+	{
+		Name:   "derivation",
+		Kind:   luadoc.Function,
+		Params: []string{"args"},
+		Text: "Create a derivation (a buildable target).\n" +
+			"`outputs` lists the names of the derivation's outputs (default `{\"out\"}`).\n" +
+			"Each output is available as a field of the returned derivation,\n" +
+			"and converting the derivation to a string uses the first output.\n" +
+			"`checks` attaches test derivations that `zb build --with-checks` also builds:\n" +
+			"either a table of derivations or a function that takes the derivation and returns one.\n" +
+			"Checks are not passed to the builder and do not change the derivation.\n" +
+			"`meta` describes the package for tools like `zb search` and `zb sbom`\n" +
+			"and is not passed to the builder either.\n" +
+			"Unknown `meta` fields produce a warning.",
+	},
+	{
+		Name:   "discardContext",
+		Kind:   luadoc.Function,
+		Params: []string{"s"},
+		Text: "Return a copy of s without any dependencies.\n" +
+			"This is useful for strings that mention store paths\n" +
+			"but should not cause them to be built, like documentation.",
+	},
+	{
+		Name:   "extract",
+		Kind:   luadoc.Function,
+		Params: []string{"args"},
+		Text: "Create a derivation that extracts an archive.\n" +
+			"The source must be a .tar, .tar.gz, .tar.bz2, or .zip file.\n" +
+			"If stripFirstComponent is true (the default),\n" +
+			"then the root directory is stripped during extraction.",
+	},
+	{
+		Name:   "fetchArchive",
+		Kind:   luadoc.Function,
+		Params: []string{"args"},
+		Text: "Create a derivation that extracts an archive from a URL.\n" +
+			"This is a convenience wrapper around fetchurl and extract.",
+	},
+	{
+		Name:   "fetchurl",
+		Kind:   luadoc.Function,
+		Params: []string{"args"},
+		Text:   "Create a derivation that downloads a URL.",
+	},
+	{
+		Name:   "getContext",
+		Kind:   luadoc.Function,
+		Params: []string{"s"},
+		Text: "Return the store objects and derivation outputs that a string depends on.\n" +
+			"Each key is a store path.\n" +
+			"`path` is true if the string depends on the store object itself\n" +
+			"and `outputs` lists the outputs of a derivation that the string depends on.",
+	},
+	{
+		Name:   "import",
+		Kind:   luadoc.Function,
+		Params: []string{"path"},
+		Text:   "Import a Lua file.",
+	},
+	{
+		Name:   "lazy",
+		Kind:   luadoc.Function,
+		Params: []string{"f", "init"},
+		Text:   "Return a table whose fields are initialized lazily by calling f.",
+	},
+	{
+		Name:   "linkFarm",
+		Kind:   luadoc.Function,
+		Params: []string{"name", "links"},
+		Text: "Create a derivation whose output is a directory of symbolic links.\n" +
+			"links maps slash-separated paths to link targets.\n" +
+			"This is a convenience wrapper around composeTree.",
+	},
+	{
+		Name:   "os.getenv",
+		Kind:   luadoc.Function,
+		Params: []string{"varname"},
+		Text: "Returns the value of the process environment variable `varname`\n" +
+			"or `nil` if the variable is not defined.",
+	},
+	{
+		Name:   "outputPlaceholder",
+		Kind:   luadoc.Function,
+		Params: []string{"drv", "outputName"},
+		Text: "Return the string that stands in for the path of another derivation's output\n" +
+			"until the output is realized.\n" +
+			"Using the string in a derivation's arguments\n" +
+			"adds a dependency on the output.",
+	},
+	{
+		Name:   "path",
+		Kind:   luadoc.Function,
+		Params: []string{"p"},
+		Text:   "Make a file or directory available to a derivation.",
+	},
+	{
+		Name:   "placeholder",
+		Kind:   luadoc.Function,
+		Params: []string{"outputName"},
+		Text: "Return the string that a derivation's builder sees\n" +
+			"in place of the path of one of the derivation's own outputs.",
+	},
+	{
+		Name:   "readFile",
+		Kind:   luadoc.Function,
+		Params: []string{"path"},
+		Text: "Return the contents of a file.\n" +
+			"Relative paths are resolved relative to the source file that called `readFile`.",
+	},
+	{
+		Name:   "sourceTree",
+		Kind:   luadoc.Function,
+		Params: []string{"p"},
+		Text: "Make a project's source directory available to a derivation,\n" +
+			"leaving out files that version control ignores.\n" +
+			"Takes the same arguments as `path`.\n" +
+			"Inside a git working copy, only the files that `git ls-files` lists are kept.\n" +
+			"Otherwise, `.gitignore` files are read directly.\n" +
+			"Paths matched by `.zbignore` files (which use the same syntax) are always left out.",
+	},
+	{
+		Name: "storeDir",
+		Kind: luadoc.Value,
+		Text: "The path of the store directory (e.g. \"/opt/zb/store\").",
+	},
+	{
+		Name:   "storePath",
+		Kind:   luadoc.Function,
+		Params: []string{"path"},
+		Text: "Adds a dependency on an existing store path.\n" +
+			"If the store object named by the path does not exist in the store,\n" +
+			"storePath raises an error.",
+	},
+	{
+		Name:   "substitute",
+		Kind:   luadoc.Function,
+		Params: []string{"args"},
+		Text: "Create a derivation that copies src and replaces literal strings in it.\n" +
+			"replacements is a list of {from, to} pairs.\n" +
+			"If src is a directory, files lists the slash-separated paths of the files to change.\n" +
+			"The build fails if a file does not contain a string to replace.\n" +
+			"The replacements are performed inside the store without running a builder program.",
+	},
+	{
+		Name:   "symlinkJoin",
+		Kind:   luadoc.Function,
+		Params: []string{"args"},
+		Text: "Create a derivation that merges the directories in paths\n" +
+			"by symlinking every file in each of them at the same location in the output.\n" +
+			"This is useful for profiles and development environments.\n" +
+			"This is a convenience wrapper around composeTree.",
+	},
+	{
+		Name:   "test",
+		Kind:   luadoc.Function,
+		Params: []string{"name", "f"},
+		Text: "Declare a test named name that calls f.\n" +
+			"A test fails if f raises an error (for example, with assert).\n" +
+			"Only available in *_test.lua files run by `zb test`.",
+	},
+	{
+		Name:   "throw",
+		Kind:   luadoc.Function,
+		Params: []string{"msg"},
+		Text:   "Raise an error with msg prefixed by the position of the call to throw.",
+	},
+	{
+		Name:   "toFile",
+		Kind:   luadoc.Function,
+		Params: []string{"name", "s"},
+		Text:   "Store a plain file in the store.",
+	},
+	{
+		Name:   "zb.hasFeature",
+		Kind:   luadoc.Function,
+		Params: []string{"name"},
+		Text: "Report whether the zb evaluating the code supports the named feature.\n" +
+			"Feature names are usually the names of built-in functions (e.g. `\"lazy\"` or `\"os.getenv\"`).\n" +
+			"`zb doctor` lists the supported features.",
+	},
+	{
+		Name:   "zb.options",
+		Kind:   luadoc.Function,
+		Params: []string{"schema", "..."},
+		Text: "Validate and merge tables of options.\n" +
+			"schema maps each option name to a declaration table\n" +
+			"with the fields `type`, `default`, `optional`, and `description`.\n" +
+			"The remaining arguments are tables of option values (or nil)\n" +
+			"that are merged from left to right into a new table.\n" +
+			"Values of options with type \"table\" are merged field by field.\n" +
+			"Unknown options, values of the wrong type,\n" +
+			"and missing options without a default raise an error.",
+	},
+	{
+		Name: "zb.system",
+		Kind: luadoc.Value,
+		Text: "Information about the system that evaluation targets.\n" +
+			"`current` is the target system triple (set with `--system`)\n" +
+			"and `host` is the triple of the system running zb.\n" +
+			"The predicates `isLinux`, `isDarwin`, `isMacOS`, `isWindows`,\n" +
+			"`isX86`, `isARM`, `isRISCV`, `is32Bit`, and `is64Bit`\n" +
+			"test the system string they are given, which defaults to `current`.",
+	},
+	{
+		Name:   "zb.system.parse",
+		Kind:   luadoc.Function,
+		Params: []string{"s"},
+		Text: "Parse a system string into a table\n" +
+			"with the fields `arch`, `vendor`, `os`, `env`, and `triple`.\n" +
+			"Raises an error if s is not a valid system.",
+	},
+	{
+		Name: "zb.version",
+		Kind: luadoc.Value,
+		Text: "The version of zb evaluating the code,\n" +
+			"or `nil` if the version is unknown (e.g. a development build).\n" +
+			"`major`, `minor`, `patch`, and `prerelease` are only set\n" +
+			"if `string` is a semantic version like \"1.2.3\" or \"1.2.3-rc.1\".",
+	},
+	{
+		Name:   "zb.warn",
+		Kind:   luadoc.Function,
+		Params: []string{"msg", "key"},
+		Text: "Emit a warning without stopping evaluation,\n" +
+			"for example to deprecate an attribute.\n" +
+			"Warnings are reported once per `key`, which defaults to `msg`.",
+	},
+}
+
+// BuiltinDocs returns the documentation for the globals that zb adds to Lua,
+// sorted by name.
+func BuiltinDocs() []*luadoc.Doc {
+	docs := make([]*luadoc.Doc, len(builtinDocs))
+	for i, doc := range builtinDocs {
+		docs[i] = cloneDoc(doc)
+	}
+	return docs
+}
+
+// BuiltinDoc returns the documentation for the global that zb adds to Lua
+// with the given name (e.g. "derivation" or "zb.hasFeature").
+func BuiltinDoc(name string) (_ *luadoc.Doc, found bool) {
+	i, found := slices.BinarySearchFunc(builtinDocs, name, func(doc *luadoc.Doc, name string) int {
+		return strings.Compare(doc.Name, name)
+	})
+	if !found {
+		return nil, false
+	}
+	return cloneDoc(builtinDocs[i]), true
+}
+
+func cloneDoc(doc *luadoc.Doc) *luadoc.Doc {
+	doc2 := new(*doc)
+	doc2.Params = slices.Clone(doc.Params)
+	doc2.Annotations = slices.Clone(doc.Annotations)
+	return doc2
+}
@@ -0,0 +1,34 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package frontend
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"zb.256lights.llc/pkg/internal/luadoc"
+)
+
+func TestBuiltinDocs(t *testing.T) {
+	isSorted := slices.IsSortedFunc(builtinDocs, func(a, b *luadoc.Doc) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	if !isSorted {
+		t.Error("builtinDocs is not sorted by name")
+	}
+
+	for _, name := range features {
+		if name == "derivation.outputs" {
+			// Not a built-in itself.
+			continue
+		}
+		if _, found := BuiltinDoc(name); found {
+			continue
+		}
+		if _, found := BuiltinDoc("zb." + name); !found {
+			t.Errorf("BuiltinDoc(%q) not found", name)
+		}
+	}
+}
@@ -0,0 +1,10 @@
+select
+  "module_results"."value" as "value",
+  "module_result_files"."path" as "file_path",
+  "module_result_files"."hash" as "file_hash"
+from
+  "module_results"
+  join "module_result_files" on "module_result_files"."module_id" = "module_results"."id"
+where
+  "module_results"."path" = :path and
+  "module_results"."salt" = :salt;
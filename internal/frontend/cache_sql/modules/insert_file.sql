@@ -0,0 +1,9 @@
+insert into "module_result_files" (
+  "module_id",
+  "path",
+  "hash"
+) values (
+  (select "id" from "module_results" where "path" = :module_path),
+  :path,
+  :hash
+);
@@ -0,0 +1,4 @@
+delete from "module_results" where "path" = :path;
+
+insert into "module_results"("path", "salt", "value")
+values (:path, :salt, :value);
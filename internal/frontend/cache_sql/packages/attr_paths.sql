@@ -0,0 +1,6 @@
+select "attr_path" as "attr_path"
+from
+  "packages"
+  join "package_sources" on "package_sources"."id" = "packages"."source_id"
+where "package_sources"."source" = :source
+order by "attr_path";
@@ -0,0 +1,13 @@
+select
+  "attr_path" as "attr_path",
+  "name" as "name",
+  "version" as "version",
+  "description" as "description",
+  "drv_path" as "drv_path"
+from
+  "packages"
+  join "package_sources" on "package_sources"."id" = "packages"."source_id"
+where
+  "package_sources"."source" = :source and
+  "package_sources"."fingerprint" = :fingerprint
+order by "attr_path";
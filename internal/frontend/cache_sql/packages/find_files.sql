@@ -0,0 +1,9 @@
+select
+  "package_source_files"."path" as "path",
+  "package_source_files"."hash" as "hash"
+from
+  "package_source_files"
+  join "package_sources" on "package_sources"."id" = "package_source_files"."source_id"
+where
+  "package_sources"."source" = :source and
+  "package_sources"."fingerprint" = :fingerprint;
@@ -0,0 +1,3 @@
+select 1 as "found"
+from "package_sources"
+where "source" = :source and "fingerprint" = :fingerprint;
@@ -0,0 +1,15 @@
+insert into "packages" (
+  "source_id",
+  "attr_path",
+  "name",
+  "version",
+  "description",
+  "drv_path"
+) values (
+  (select "id" from "package_sources" where "source" = :source),
+  :attr_path,
+  :name,
+  :version,
+  :description,
+  :drv_path
+);
@@ -0,0 +1,9 @@
+insert into "package_source_files" (
+  "source_id",
+  "path",
+  "hash"
+) values (
+  (select "id" from "package_sources" where "source" = :source),
+  :path,
+  :hash
+);
@@ -0,0 +1,4 @@
+delete from "package_sources" where "source" = :source;
+
+insert into "package_sources"("source", "fingerprint")
+values (:source, :fingerprint);
@@ -0,0 +1,19 @@
+create table "package_sources" (
+  "id" integer not null primary key,
+  "source" text not null unique,
+  "fingerprint" text not null
+);
+
+create table "packages" (
+  "source_id" integer
+    not null
+    references "package_sources"
+    on delete cascade,
+  "attr_path" text not null,
+  "name" text not null,
+  "version" text not null default '',
+  "description" text not null default '',
+  "drv_path" text not null,
+
+  primary key ("source_id", "attr_path")
+) without rowid;
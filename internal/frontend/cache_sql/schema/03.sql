@@ -0,0 +1,17 @@
+create table "module_results" (
+  "id" integer not null primary key,
+  "path" text not null unique,
+  "salt" text not null,
+  "value" blob not null
+);
+
+create table "module_result_files" (
+  "module_id" integer
+    not null
+    references "module_results"
+    on delete cascade,
+  "path" text not null,
+  "hash" blob not null,
+
+  primary key ("module_id", "path")
+) without rowid;
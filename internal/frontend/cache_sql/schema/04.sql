@@ -0,0 +1,10 @@
+create table "package_source_files" (
+  "source_id" integer
+    not null
+    references "package_sources"
+    on delete cascade,
+  "path" text not null,
+  "hash" blob not null,
+
+  primary key ("source_id", "path")
+) without rowid;
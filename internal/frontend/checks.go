@@ -0,0 +1,114 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package frontend
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"zb.256lights.llc/pkg/internal/lua"
+	"zb.256lights.llc/pkg/internal/lualex"
+)
+
+// checksArgument is the name of the derivation function argument
+// that attaches checks to a derivation.
+// The argument is either a table of derivations
+// or a function that takes the derivation and returns a table of derivations.
+// Unlike other arguments, checks are not passed to the builder
+// and do not affect the derivation's store path.
+const checksArgument = "checks"
+
+// A Check is a derivation that tests another derivation.
+type Check struct {
+	// Name is the key of the check in the checks table.
+	// Checks in a sequence use the check derivation's name.
+	Name string
+	*Derivation
+}
+
+// Checks evaluates the checks attached to the derivation at each URL
+// with the derivation function's checks argument.
+// URLs are interpreted the same as in [*Eval.URLs].
+// The result has a list of checks for each URL in the same order as urls,
+// which is empty if the derivation does not have any checks.
+// It is an error for any URL to not refer to a derivation.
+func (eval *Eval) Checks(ctx context.Context, urls []string) ([][]*Check, error) {
+	if len(urls) == 0 {
+		return nil, nil
+	}
+	parsedURLs, err := eval.parseURLs(urls)
+	if err != nil {
+		return nil, err
+	}
+	importedStorePaths, err := eval.importURLs(ctx, parsedURLs)
+	if err != nil {
+		return nil, err
+	}
+	result := make([][]*Check, len(urls))
+	err = eval.lookupURLs(ctx, urls, parsedURLs, importedStorePaths, func(l *lua.State, i int) error {
+		checks, err := derivationChecks(ctx, l)
+		if err != nil {
+			return fmt.Errorf("%s: %v", urls[i], err)
+		}
+		result[i] = checks
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// derivationChecks returns the checks of the derivation at the top of the stack.
+// The stack is left unchanged.
+func derivationChecks(ctx context.Context, l *lua.State) (_ []*Check, err error) {
+	defer l.SetTop(l.Top())
+
+	if testDerivation(l, -1) == nil {
+		return nil, fmt.Errorf("%v is not a derivation", l.Type(-1))
+	}
+	l.UserValue(-1, 1) // Push derivation argument table.
+	switch l.RawField(-1, checksArgument) {
+	case lua.TypeNil:
+		return nil, nil
+	case lua.TypeFunction:
+		l.PushValue(-3) // Push derivation.
+		if err := l.Call(ctx, 1, 1); err != nil {
+			return nil, fmt.Errorf("%s: %v", checksArgument, err)
+		}
+		if typ := l.Type(-1); typ != lua.TypeTable {
+			return nil, fmt.Errorf("%s: function returned %v (want %v)", checksArgument, typ, lua.TypeTable)
+		}
+	}
+
+	var checks []*Check
+	l.PushNil()
+	for l.Next(-2) {
+		drv := testDerivation(l, -1)
+		var name string
+		switch l.Type(-2) {
+		case lua.TypeString:
+			name, _ = l.ToString(-2)
+			if drv == nil {
+				return nil, fmt.Errorf("%s[%s]: derivation expected, got %v", checksArgument, lualex.Quote(name), l.Type(-1))
+			}
+		case lua.TypeNumber:
+			if drv == nil {
+				i, _ := l.ToNumber(-2)
+				return nil, fmt.Errorf("%s[%v]: derivation expected, got %v", checksArgument, i, l.Type(-1))
+			}
+			name = drv.Name
+		default:
+			return nil, fmt.Errorf("%s: keys must be strings or numbers (found %v)", checksArgument, l.Type(-2))
+		}
+		checks = append(checks, &Check{Name: name, Derivation: drv})
+		l.Pop(1)
+	}
+	slices.SortStableFunc(checks, func(a, b *Check) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return checks, nil
+}
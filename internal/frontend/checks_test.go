@@ -0,0 +1,138 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package frontend
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"zb.256lights.llc/pkg/internal/backendtest"
+	"zb.256lights.llc/pkg/internal/testcontext"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+)
+
+func TestChecks(t *testing.T) {
+	ctx := testcontext.New(t)
+	storeDir := backendtest.NewStoreDirectory(t)
+
+	di := new(zbstorerpc.DeferredImporter)
+	_, store, err := backendtest.NewServer(ctx, t, storeDir, &backendtest.Options{
+		TempDir: t.TempDir(),
+		ClientOptions: zbstorerpc.CodecOptions{
+			Importer: di,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	eval, err := NewEval(&Options{
+		Store:          newTestRPCStore(store, di),
+		StoreDirectory: storeDir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := eval.Close(); err != nil {
+			t.Error("eval.Close:", err)
+		}
+	}()
+
+	const source = "local function drv(name, args)\n" +
+		"  return derivation {\n" +
+		"    name = name;\n" +
+		"    system = \"x86_64-linux\";\n" +
+		"    builder = \"/bin/sh\";\n" +
+		"    args = args or {};\n" +
+		"  }\n" +
+		"end\n" +
+		"\n" +
+		"plain = drv(\"plain\")\n" +
+		"\n" +
+		"withTable = derivation {\n" +
+		"  name = \"withTable\";\n" +
+		"  system = \"x86_64-linux\";\n" +
+		"  builder = \"/bin/sh\";\n" +
+		"  checks = { drv(\"listed\"), unit = drv(\"unit-test\") };\n" +
+		"}\n" +
+		"\n" +
+		"withFunction = derivation {\n" +
+		"  name = \"withFunction\";\n" +
+		"  system = \"x86_64-linux\";\n" +
+		"  builder = \"/bin/sh\";\n" +
+		"  checks = function(self)\n" +
+		"    return { smoke = drv(\"smoke\", { \"-c\", self.out }) }\n" +
+		"  end;\n" +
+		"}\n" +
+		"\n" +
+		"withoutChecks = derivation {\n" +
+		"  name = \"withTable\";\n" +
+		"  system = \"x86_64-linux\";\n" +
+		"  builder = \"/bin/sh\";\n" +
+		"}\n"
+	path := filepath.Join(t.TempDir(), "packages.lua")
+	if err := os.WriteFile(path, []byte(source), 0o666); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := eval.Checks(ctx, []string{
+		path + "#plain",
+		path + "#withTable",
+		path + "#withFunction",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 {
+		t.Fatalf("len(eval.Checks(...)) = %d; want 3", len(got))
+	}
+	if len(got[0]) != 0 {
+		t.Errorf("checks for plain = %v; want []", got[0])
+	}
+	checkNames := func(checks []*Check) []string {
+		var names []string
+		for _, c := range checks {
+			names = append(names, c.Name+"="+c.Derivation.Name)
+		}
+		return names
+	}
+	if got, want := strings.Join(checkNames(got[1]), ","), "listed=listed,unit=unit-test"; got != want {
+		t.Errorf("checks for withTable = %s; want %s", got, want)
+	}
+	if got, want := strings.Join(checkNames(got[2]), ","), "smoke=smoke"; got != want {
+		t.Errorf("checks for withFunction = %s; want %s", got, want)
+	}
+
+	results, err := eval.URLs(ctx, []string{
+		path + "#withTable",
+		path + "#withoutChecks",
+		path + "#withFunction",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	drvs := make([]*Derivation, len(results))
+	for i, result := range results {
+		drvs[i], _ = result.(*Derivation)
+		if drvs[i] == nil {
+			t.Fatalf("results[%d] = %v; want derivation", i, result)
+		}
+	}
+	// Checks must not change the derivation.
+	if drvs[0].Path != drvs[1].Path {
+		t.Errorf("derivation with checks path = %s; without checks = %s", drvs[0].Path, drvs[1].Path)
+	}
+	// Check functions receive the derivation.
+	if len(got[2]) > 0 {
+		if _, ok := got[2][0].InputDerivations[drvs[2].Path]; !ok {
+			t.Errorf("smoke check inputs = %v; want to include %s", got[2][0].InputDerivations, drvs[2].Path)
+		}
+	}
+
+	if _, err := eval.Checks(ctx, []string{path}); err == nil {
+		t.Error("eval.Checks on a non-derivation did not return an error")
+	}
+}
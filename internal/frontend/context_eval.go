@@ -0,0 +1,174 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package frontend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+
+	"zb.256lights.llc/pkg/internal/lua"
+	"zb.256lights.llc/pkg/internal/lualex"
+	"zb.256lights.llc/pkg/sets"
+	"zb.256lights.llc/pkg/zbstore"
+)
+
+// getContextFunction returns a table that describes the context of a string.
+// Each key in the table is a store path
+// and each value is a table with the following fields:
+//
+//   - path: true if the string depends on the store object itself.
+//   - outputs: if the store path is a derivation,
+//     a sorted list of the derivation's outputs that the string depends on.
+//
+// The table is in the same format that [*Eval.addContextFunction] accepts.
+func getContextFunction(ctx context.Context, l *lua.State) (int, error) {
+	if _, err := lua.CheckString(l, 1); err != nil {
+		return 0, err
+	}
+
+	type contextEntry struct {
+		path    bool
+		outputs sets.Sorted[string]
+	}
+	entries := make(map[zbstore.Path]*contextEntry)
+	entry := func(p zbstore.Path) *contextEntry {
+		e := entries[p]
+		if e == nil {
+			e = new(contextEntry)
+			entries[p] = e
+		}
+		return e
+	}
+	for dep := range l.StringContext(1).All() {
+		c, err := parseContextString(dep)
+		if err != nil {
+			return 0, fmt.Errorf("%sgetContext: internal error: %v", lua.Where(l, 1), err)
+		}
+		switch {
+		case c.path != "":
+			entry(c.path).path = true
+		case !c.outputReference.IsZero():
+			entry(c.outputReference.DrvPath).outputs.Add(c.outputReference.OutputName)
+		default:
+			return 0, fmt.Errorf("%sgetContext: internal error: unhandled context %v", lua.Where(l, 1), c)
+		}
+	}
+
+	l.CreateTable(0, len(entries))
+	for _, p := range slices.Sorted(maps.Keys(entries)) {
+		e := entries[p]
+		l.CreateTable(0, 2)
+		if e.path {
+			l.PushBoolean(true)
+			if err := l.RawSetField(-2, "path"); err != nil {
+				return 0, err
+			}
+		}
+		if e.outputs.Len() > 0 {
+			l.CreateTable(e.outputs.Len(), 0)
+			for i, outputName := range e.outputs.All() {
+				l.PushString(outputName)
+				if err := l.RawSetIndex(-2, int64(i)+1); err != nil {
+					return 0, err
+				}
+			}
+			if err := l.RawSetField(-2, "outputs"); err != nil {
+				return 0, err
+			}
+		}
+		if err := l.RawSetField(-2, string(p)); err != nil {
+			return 0, err
+		}
+	}
+	return 1, nil
+}
+
+// discardContextFunction returns its string argument without any context.
+// This is useful for strings that mention store paths
+// but should not add dependencies, like documentation.
+func discardContextFunction(ctx context.Context, l *lua.State) (int, error) {
+	s, err := lua.CheckString(l, 1)
+	if err != nil {
+		return 0, err
+	}
+	l.PushString(s)
+	return 1, nil
+}
+
+// addContextFunction returns its string argument
+// with the context described by the second argument added.
+// The second argument is a table in the format returned by [getContextFunction].
+// Every store path in the table must exist in the store.
+func (eval *Eval) addContextFunction(ctx context.Context, l *lua.State) (int, error) {
+	s, err := lua.CheckString(l, 1)
+	if err != nil {
+		return 0, err
+	}
+	if l.Type(2) != lua.TypeTable {
+		return 0, lua.NewTypeError(l, 2, lua.TypeTable.String())
+	}
+	sctx := l.StringContext(1).Clone()
+
+	l.PushNil()
+	for l.Next(2) {
+		if l.Type(-2) != lua.TypeString {
+			return 0, lua.NewArgError(l, 2, fmt.Sprintf("keys must be store paths (found %v)", l.Type(-2)))
+		}
+		rawPath, _ := l.ToString(-2)
+		path, _, err := eval.storeDir.ParsePath(rawPath)
+		if err != nil {
+			return 0, lua.NewArgError(l, 2, fmt.Sprintf("path %s is not under %s",
+				lualex.Quote(rawPath), lualex.Quote(string(eval.storeDir))))
+		}
+		if l.Type(-1) != lua.TypeTable {
+			return 0, lua.NewArgError(l, 2, fmt.Sprintf("%s: table expected, got %v", path, l.Type(-1)))
+		}
+		if _, err := eval.store.Object(ctx, path); errors.Is(err, zbstore.ErrNotFound) {
+			return 0, fmt.Errorf("%saddContext: %s does not exist", lua.Where(l, 1), path)
+		} else if err != nil {
+			return 0, fmt.Errorf("%saddContext: %v", lua.Where(l, 1), err)
+		}
+
+		l.RawField(-1, "path")
+		if l.ToBoolean(-1) {
+			sctx.Add(contextValue{path: path}.String())
+		}
+		l.Pop(1)
+
+		switch typ := l.RawField(-1, "outputs"); typ {
+		case lua.TypeNil:
+		case lua.TypeTable:
+			if _, isDrv := path.DerivationName(); !isDrv {
+				return 0, lua.NewArgError(l, 2, fmt.Sprintf("%s: outputs given for a path that is not a derivation", path))
+			}
+			err := ipairs(ctx, l, -1, func(i int64) error {
+				if l.Type(-1) != lua.TypeString {
+					return fmt.Errorf("#%d: %v is not a string", i, l.Type(-1))
+				}
+				outputName, _ := l.ToString(-1)
+				if !zbstore.IsValidOutputName(outputName) {
+					return fmt.Errorf("#%d: invalid output name %s", i, lualex.Quote(outputName))
+				}
+				sctx.Add(contextValue{outputReference: zbstore.OutputReference{
+					DrvPath:    path,
+					OutputName: outputName,
+				}}.String())
+				return nil
+			})
+			if err != nil {
+				return 0, lua.NewArgError(l, 2, fmt.Sprintf("%s: outputs: %v", path, err))
+			}
+		default:
+			return 0, lua.NewArgError(l, 2, fmt.Sprintf("%s: outputs: table expected, got %v", path, typ))
+		}
+		// Remove outputs and value, keeping key for the next iteration.
+		l.Pop(2)
+	}
+
+	l.PushStringContext(s, sctx)
+	return 1, nil
+}
@@ -0,0 +1,125 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package frontend
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"zb.256lights.llc/pkg/internal/backendtest"
+	"zb.256lights.llc/pkg/internal/lualex"
+	"zb.256lights.llc/pkg/internal/testcontext"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+)
+
+func TestStringContext(t *testing.T) {
+	ctx := testcontext.New(t)
+	storeDir := backendtest.NewStoreDirectory(t)
+
+	di := new(zbstorerpc.DeferredImporter)
+	_, store, err := backendtest.NewServer(ctx, t, storeDir, &backendtest.Options{
+		TempDir: t.TempDir(),
+		ClientOptions: zbstorerpc.CodecOptions{
+			Importer: di,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	eval, err := NewEval(&Options{
+		Store:          newTestRPCStore(store, di),
+		StoreDirectory: storeDir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := eval.Close(); err != nil {
+			t.Error("eval.Close:", err)
+		}
+	}()
+
+	const fileExpr = `toFile("hello.txt", "Hello, World!\n")`
+	const drvExpr = `derivation { name = "x"; system = "x86_64-linux"; builder = "/bin/sh" }`
+	result, err := eval.Expression(ctx, fileExpr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filePath := result.(string)
+	result, err = eval.Expression(ctx, drvExpr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	drvPath := string(result.(*Derivation).Path)
+
+	combinedExpr := `(` + fileExpr + ` .. " " .. ` + drvExpr + `.out)`
+	wantContext := map[string]any{
+		filePath: map[string]any{"path": true},
+		drvPath:  map[string]any{"outputs": []any{"out"}},
+	}
+	tests := []struct {
+		name string
+		expr string
+		want any
+	}{
+		{
+			name: "Get",
+			expr: `getContext(` + combinedExpr + `)`,
+			want: wantContext,
+		},
+		{
+			name: "Plain",
+			expr: `getContext("foo")`,
+			want: map[string]any{},
+		},
+		{
+			name: "Discard",
+			expr: `getContext(discardContext(` + combinedExpr + `))`,
+			want: map[string]any{},
+		},
+		{
+			name: "DiscardKeepsString",
+			expr: `discardContext(` + fileExpr + `)`,
+			want: filePath,
+		},
+		{
+			name: "AddRoundTrip",
+			expr: `getContext(addContext("foo", getContext(` + combinedExpr + `)))`,
+			want: wantContext,
+		},
+		{
+			name: "AddMerges",
+			expr: `getContext(addContext(` + fileExpr + `, { [` + lualex.Quote(drvPath) + `] = { outputs = { "out" } } }))`,
+			want: wantContext,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := eval.Expression(ctx, test.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.want, got, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("%s (-want +got):\n%s", test.expr, diff)
+			}
+		})
+	}
+
+	t.Run("AddMissing", func(t *testing.T) {
+		missingPath := storeDir.Join("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa-missing.txt")
+		_, err := eval.Expression(ctx, `addContext("foo", { [`+lualex.Quote(string(missingPath))+`] = { path = true } })`)
+		if err == nil {
+			t.Error("addContext with missing path did not return an error")
+		}
+	})
+
+	t.Run("AddOutputsToNonDerivation", func(t *testing.T) {
+		_, err := eval.Expression(ctx, `addContext("foo", { [`+lualex.Quote(filePath)+`] = { outputs = { "out" } } })`)
+		if err == nil {
+			t.Error("addContext with outputs for a non-derivation did not return an error")
+		}
+	})
+}
@@ -0,0 +1,142 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package frontend
+
+import (
+	"bufio"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"sync"
+
+	"zb.256lights.llc/pkg/internal/lua"
+	"zb.256lights.llc/pkg/internal/luacode"
+	"zb.256lights.llc/pkg/sets"
+)
+
+// A Coverage records which lines of Lua source files are executed during evaluation.
+// Only code loaded from files is recorded.
+// Pass a Coverage in [Options] to use it.
+// A Coverage is safe to use from multiple goroutines.
+type Coverage struct {
+	mu sync.Mutex
+	// hits is a map of file paths to line numbers to the number of times
+	// the line was executed.
+	hits map[string]map[int]int64
+}
+
+// CoverageFile is the coverage of a single Lua source file.
+type CoverageFile struct {
+	// Path is the absolute path of the file.
+	Path string `json:"path"`
+	// Lines is the set of lines in the file that contain code,
+	// sorted by line number.
+	Lines []CoverageLine `json:"lines"`
+}
+
+// CoverageLine is the coverage of a single line of a Lua source file.
+type CoverageLine struct {
+	Line int `json:"line"`
+	// Hits is the number of times that execution entered the line.
+	Hits int64 `json:"hits"`
+}
+
+// Covered returns the number of lines in f that were executed at least once.
+func (f *CoverageFile) Covered() int {
+	n := 0
+	for _, line := range f.Lines {
+		if line.Hits > 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// NewCoverage returns a new [Coverage] with no lines recorded.
+func NewCoverage() *Coverage {
+	return &Coverage{hits: make(map[string]map[int]int64)}
+}
+
+// record records that the line currently executing in l was entered.
+func (c *Coverage) record(l *lua.State) {
+	db := l.Info(0)
+	if db == nil || db.CurrentLine <= 0 {
+		return
+	}
+	path, ok := db.Source.Filename()
+	if !ok {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fileHits := c.hits[path]
+	if fileHits == nil {
+		fileHits = make(map[int]int64)
+		c.hits[path] = fileHits
+	}
+	fileHits[db.CurrentLine]++
+}
+
+// Files returns the coverage of each file that was executed so far,
+// sorted by path.
+// Files reads each file to determine which of its lines contain code.
+func (c *Coverage) Files() ([]*CoverageFile, error) {
+	c.mu.Lock()
+	hits := make(map[string]map[int]int64, len(c.hits))
+	for path, fileHits := range c.hits {
+		hits[path] = maps.Clone(fileHits)
+	}
+	c.mu.Unlock()
+
+	files := make([]*CoverageFile, 0, len(hits))
+	for _, path := range slices.Sorted(maps.Keys(hits)) {
+		lines, err := executableLines(path)
+		if err != nil {
+			return nil, fmt.Errorf("coverage: %v", err)
+		}
+		fileHits := hits[path]
+		// Lines can only be executed if they contain code,
+		// but include any executed lines in case the file changed.
+		lines.AddSeq(maps.Keys(fileHits))
+		f := &CoverageFile{Path: path}
+		for _, line := range slices.Sorted(lines.All()) {
+			f.Lines = append(f.Lines, CoverageLine{
+				Line: line,
+				Hits: fileHits[line],
+			})
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// executableLines returns the set of line numbers in the Lua file at path
+// that contain code.
+func executableLines(path string) (sets.Set[int], error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	proto, err := luacode.Parse(lua.FilenameSource(path), bufio.NewReader(f))
+	if err != nil {
+		return nil, err
+	}
+	lines := make(sets.Set[int])
+	var visit func(p *luacode.Prototype)
+	visit = func(p *luacode.Prototype) {
+		for _, line := range p.LineInfo.All() {
+			if line > 0 {
+				lines.Add(line)
+			}
+		}
+		for _, child := range p.Functions {
+			visit(child)
+		}
+	}
+	visit(proto)
+	return lines, nil
+}
@@ -0,0 +1,78 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package frontend
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"zb.256lights.llc/pkg/internal/backendtest"
+	"zb.256lights.llc/pkg/internal/lualex"
+	"zb.256lights.llc/pkg/internal/testcontext"
+)
+
+func TestCoverage(t *testing.T) {
+	ctx := testcontext.New(t)
+	path := filepath.Join(t.TempDir(), "foo.lua")
+	const source = "local function used(x)\n" +
+		"  return x + 1\n" +
+		"end\n" +
+		"\n" +
+		"local function unused(x)\n" +
+		"  return x * 2\n" +
+		"end\n" +
+		"\n" +
+		"for i = 1, 3 do\n" +
+		"  answer = used(i)\n" +
+		"end\n"
+	if err := os.WriteFile(path, []byte(source), 0o666); err != nil {
+		t.Fatal(err)
+	}
+
+	coverage := NewCoverage()
+	// Importing a file does not use the store.
+	eval, err := NewEval(&Options{
+		StoreDirectory: backendtest.NewStoreDirectory(t),
+		Coverage:       coverage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := eval.Close(); err != nil {
+			t.Error("eval.Close:", err)
+		}
+	}()
+
+	result, err := eval.Expression(ctx, "import("+lualex.Quote(path)+").answer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := int64(4); result != want {
+		t.Errorf("result = %v; want %d", result, want)
+	}
+
+	got, err := coverage.Files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*CoverageFile{{
+		Path: path,
+		Lines: []CoverageLine{
+			{Line: 1, Hits: 1},
+			{Line: 2, Hits: 3},
+			{Line: 3, Hits: 1},
+			{Line: 6, Hits: 0},
+			{Line: 7, Hits: 1},
+			{Line: 9, Hits: 4},
+			{Line: 10, Hits: 3},
+			{Line: 11, Hits: 1},
+		},
+	}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("coverage.Files() (-want +got):\n%s", diff)
+	}
+}
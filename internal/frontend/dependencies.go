@@ -0,0 +1,103 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package frontend
+
+import (
+	"context"
+	"maps"
+	"slices"
+
+	"zb.256lights.llc/pkg/sets"
+)
+
+// Dependencies is the set of local inputs that an evaluation read.
+// If none of the inputs change,
+// then evaluating the same code again produces the same result,
+// barring changes to network resources or the store.
+type Dependencies struct {
+	// Files is the sorted list of absolute paths of files that were read,
+	// including Lua source files and files read with readFile.
+	Files []string
+	// Paths is the sorted list of absolute paths of files and directories
+	// that were imported into the store with path or sourceTree.
+	// A change to anything inside one of these paths
+	// can change the result of evaluation.
+	Paths []string
+	// Env is the set of environment variables that were looked up with os.getenv,
+	// keyed by variable name.
+	Env map[string]EnvLookup
+}
+
+// EnvLookup is the result of looking up an environment variable.
+type EnvLookup struct {
+	// Value is the value of the variable.
+	// It is empty if Found is false.
+	Value string
+	// Found is true if the variable was set
+	// and evaluation was permitted to read it.
+	Found bool
+}
+
+// ExpressionWithDependencies evaluates a single Lua expression like [*Eval.Expression]
+// and also returns the inputs that the evaluation read.
+// The dependencies are returned even if evaluation fails,
+// since changing them may fix the error.
+func (eval *Eval) ExpressionWithDependencies(ctx context.Context, expr string) (any, *Dependencies, error) {
+	deps := new(moduleDeps)
+	result, err := eval.Expression(contextWithModuleDeps(ctx, deps), expr)
+	return result, eval.collectDependencies(ctx, deps), err
+}
+
+// URLsWithDependencies evaluates URLs like [*Eval.URLs]
+// and also returns the inputs that the evaluation read.
+// The dependencies are returned even if evaluation fails,
+// since changing them may fix the error.
+func (eval *Eval) URLsWithDependencies(ctx context.Context, urls []string) ([]any, *Dependencies, error) {
+	deps := new(moduleDeps)
+	results, err := eval.URLs(contextWithModuleDeps(ctx, deps), urls)
+	return results, eval.collectDependencies(ctx, deps), err
+}
+
+// collectDependencies returns the inputs recorded in root
+// and in the modules that root transitively imported.
+// Modules that are still being evaluated are waited on
+// so that their inputs are included,
+// unless ctx is canceled first.
+func (eval *Eval) collectDependencies(ctx context.Context, root *moduleDeps) *Dependencies {
+	files := make(sets.Set[string])
+	paths := make(sets.Set[string])
+	env := make(map[string]EnvLookup)
+	add := func(deps *moduleDeps) []*module {
+		deps.mu.Lock()
+		defer deps.mu.Unlock()
+		files.AddSeq(maps.Keys(deps.files))
+		paths.AddSeq(deps.paths.All())
+		maps.Copy(env, deps.env)
+		return slices.Clone(deps.imports)
+	}
+
+	visited := make(sets.Set[*module])
+	stack := add(root)
+	for len(stack) > 0 {
+		mod := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if visited.Has(mod) {
+			continue
+		}
+		visited.Add(mod)
+		select {
+		case <-mod.finished:
+		case <-ctx.Done():
+		}
+		if mod.deps != nil {
+			stack = append(stack, add(mod.deps)...)
+		}
+	}
+
+	return &Dependencies{
+		Files: slices.Sorted(files.All()),
+		Paths: slices.Sorted(paths.All()),
+		Env:   env,
+	}
+}
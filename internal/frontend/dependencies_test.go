@@ -0,0 +1,124 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package frontend
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"zb.256lights.llc/pkg/internal/backendtest"
+	"zb.256lights.llc/pkg/internal/lualex"
+	"zb.256lights.llc/pkg/internal/testcontext"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+)
+
+func TestDependencies(t *testing.T) {
+	ctx := testcontext.New(t)
+	storeDir := backendtest.NewStoreDirectory(t)
+
+	di := new(zbstorerpc.DeferredImporter)
+	_, store, err := backendtest.NewServer(ctx, t, storeDir, &backendtest.Options{
+		TempDir: t.TempDir(),
+		ClientOptions: zbstorerpc.CodecOptions{
+			Importer: di,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	eval, err := NewEval(&Options{
+		Store:          newTestRPCStore(store, di),
+		StoreDirectory: storeDir,
+		LookupEnv: func(ctx context.Context, key string) (string, bool) {
+			if key == "GREETING" {
+				return "Hello", true
+			}
+			return "", false
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := eval.Close(); err != nil {
+			t.Error("eval.Close:", err)
+		}
+	}()
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"main.lua": "local lib = import \"lib.lua\"\n" +
+			"return { greeting = lib.greeting; src = path \"src\" }\n",
+		"lib.lua": "local name = readFile \"name.txt\"\n" +
+			"return { greeting = (os.getenv(\"GREETING\") or \"Hi\") .. \", \" .. name; missing = os.getenv(\"MISSING\") }\n",
+		"name.txt":   "World",
+		"src/a.txt":  "a\n",
+		"unused.lua": "return 42\n",
+	}
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mainPath := filepath.Join(dir, "main.lua")
+	want := &Dependencies{
+		Files: []string{
+			filepath.Join(dir, "lib.lua"),
+			mainPath,
+			filepath.Join(dir, "name.txt"),
+		},
+		Paths: []string{
+			filepath.Join(dir, "src"),
+		},
+		Env: map[string]EnvLookup{
+			"GREETING": {Value: "Hello", Found: true},
+			"MISSING":  {},
+		},
+	}
+
+	t.Run("Expression", func(t *testing.T) {
+		result, deps, err := eval.ExpressionWithDependencies(ctx, "import("+lualex.Quote(mainPath)+").greeting")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "Hello, World"; result != want {
+			t.Errorf("result = %v; want %q", result, want)
+		}
+		if diff := cmp.Diff(want, deps); diff != "" {
+			t.Errorf("dependencies (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("URLs", func(t *testing.T) {
+		// The modules have already been imported by the previous subtest,
+		// so this checks that dependencies are found for modules loaded earlier.
+		results, deps, err := eval.URLsWithDependencies(ctx, []string{mainPath + "#greeting"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "Hello, World"; len(results) != 1 || results[0] != want {
+			t.Errorf("results = %v; want [%q]", results, want)
+		}
+		if diff := cmp.Diff(want, deps); diff != "" {
+			t.Errorf("dependencies (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		_, deps, err := eval.ExpressionWithDependencies(ctx, "readFile("+lualex.Quote(filepath.Join(dir, "nope.txt"))+")")
+		if err == nil {
+			t.Error("evaluation did not return an error")
+		}
+		if deps == nil {
+			t.Fatal("dependencies = nil")
+		}
+	})
+}
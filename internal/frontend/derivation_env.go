@@ -0,0 +1,151 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package frontend
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf16"
+
+	"zb.256lights.llc/pkg/internal/system"
+	"zb.256lights.llc/pkg/internal/xmaps"
+	"zb.256lights.llc/pkg/zbstore"
+)
+
+// Limits on the size of a new process's command line and environment.
+// Exceeding these causes exec to fail with E2BIG
+// (or an equivalent error on Windows).
+const (
+	// linuxMaxArgStrlen is the largest argument or environment variable
+	// (including the NUL terminator) that Linux accepts.
+	// It is MAX_ARG_STRLEN in the Linux source.
+	linuxMaxArgStrlen = 32 * 4096
+	// linuxArgMax is the default limit on the combined size
+	// of the arguments and environment on Linux.
+	// The actual limit is a quarter of the stack size limit,
+	// which is 8 MiB by default.
+	linuxArgMax = 2 << 20
+	// darwinArgMax is the limit on the combined size
+	// of the arguments and environment on macOS.
+	darwinArgMax = 1 << 20
+	// windowsMaxLength is the maximum number of UTF-16 code units
+	// in a command line or an environment variable on Windows.
+	windowsMaxLength = 32767
+
+	// envReserve is the number of bytes reserved
+	// for the environment variables that the store sets for each builder
+	// (e.g. ZB_BUILD_TOP).
+	envReserve = 4 << 10
+)
+
+// checkDerivationEnv reports an error if drv's builder, arguments, or environment
+// could not be passed to a new process
+// on the operating system named by drv's system.
+// Names and values must not contain NUL bytes
+// and names must be non-empty and must not contain '='.
+// If the system is known, then the sizes are checked against the system's limits.
+func checkDerivationEnv(drv *zbstore.Derivation) error {
+	if strings.Contains(drv.Builder, "\x00") {
+		return fmt.Errorf("builder contains a NUL byte")
+	}
+	for i, arg := range drv.Args {
+		if strings.Contains(arg, "\x00") {
+			return fmt.Errorf("args #%d contains a NUL byte", i+1)
+		}
+	}
+	for k, v := range xmaps.Sorted(drv.Env) {
+		switch {
+		case k == "":
+			return fmt.Errorf("environment variable name is empty")
+		case strings.ContainsAny(k, "=\x00"):
+			return fmt.Errorf("environment variable %q: name must not contain '=' or NUL", k)
+		case strings.Contains(v, "\x00"):
+			return fmt.Errorf("environment variable %s contains a NUL byte", k)
+		}
+	}
+
+	sys, err := system.Parse(drv.System)
+	if err != nil {
+		// Builtin and unknown systems don't have limits we can check.
+		return nil
+	}
+	switch {
+	case sys.OS.IsLinux():
+		return checkUnixEnvSize(drv, "Linux", linuxArgMax, linuxMaxArgStrlen)
+	case sys.OS.IsDarwin():
+		return checkUnixEnvSize(drv, "macOS", darwinArgMax, 0)
+	case sys.OS.IsWindows():
+		return checkWindowsEnvSize(drv)
+	default:
+		return nil
+	}
+}
+
+// checkUnixEnvSize checks the sizes of drv's builder, arguments, and environment
+// against the limits of a Unix-like operating system.
+// If maxString is zero, then individual strings are not checked.
+func checkUnixEnvSize(drv *zbstore.Derivation, osName string, argMax, maxString int) error {
+	total := len(drv.Builder) + 1
+	for i, arg := range drv.Args {
+		n := len(arg) + 1
+		if maxString > 0 && n > maxString {
+			return fmt.Errorf("args #%d is %d bytes, which exceeds the %s limit of %d bytes per argument",
+				i+1, n, osName, maxString)
+		}
+		total += n
+	}
+	for k, v := range xmaps.Sorted(drv.Env) {
+		n := len(k) + len("=") + len(v) + 1
+		if maxString > 0 && n > maxString {
+			return fmt.Errorf("environment variable %s is %d bytes, which exceeds the %s limit of %d bytes per variable",
+				k, n, osName, maxString)
+		}
+		total += n
+	}
+	if limit := argMax - envReserve; total > limit {
+		return fmt.Errorf("arguments and environment are %d bytes, which exceeds the %s limit of %d bytes",
+			total, osName, limit)
+	}
+	return nil
+}
+
+// checkWindowsEnvSize checks the sizes of drv's builder, arguments, and environment
+// against the limits of Windows.
+// Windows environment variable names are case-insensitive,
+// so checkWindowsEnvSize also reports names that differ only in case.
+func checkWindowsEnvSize(drv *zbstore.Derivation) error {
+	// The command line also includes spaces and quotes,
+	// so this is an underestimate.
+	cmdLine := utf16Len(drv.Builder)
+	for _, arg := range drv.Args {
+		cmdLine += 1 + utf16Len(arg)
+	}
+	if cmdLine > windowsMaxLength {
+		return fmt.Errorf("command line is at least %d characters, which exceeds the Windows limit of %d characters",
+			cmdLine, windowsMaxLength)
+	}
+
+	names := make(map[string]string, len(drv.Env))
+	for k, v := range xmaps.Sorted(drv.Env) {
+		if n := utf16Len(k) + len("=") + utf16Len(v); n > windowsMaxLength {
+			return fmt.Errorf("environment variable %s is %d characters, which exceeds the Windows limit of %d characters",
+				k, n, windowsMaxLength)
+		}
+		folded := strings.ToUpper(k)
+		if prev, ok := names[folded]; ok {
+			return fmt.Errorf("environment variables %s and %s differ only in case, which Windows does not distinguish", prev, k)
+		}
+		names[folded] = k
+	}
+	return nil
+}
+
+// utf16Len returns the number of UTF-16 code units needed to encode s.
+func utf16Len(s string) int {
+	n := 0
+	for _, c := range s {
+		n += utf16.RuneLen(c)
+	}
+	return n
+}
@@ -0,0 +1,149 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package frontend
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"zb.256lights.llc/pkg/zbstore"
+)
+
+func TestCheckDerivationEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		drv     *zbstore.Derivation
+		wantErr bool
+	}{
+		{
+			name: "Valid",
+			drv: &zbstore.Derivation{
+				System:  "x86_64-linux",
+				Builder: "/bin/sh",
+				Args:    []string{"-c", "echo hi"},
+				Env:     map[string]string{"FOO": "bar"},
+			},
+		},
+		{
+			name: "NULInValue",
+			drv: &zbstore.Derivation{
+				System:  "x86_64-linux",
+				Builder: "/bin/sh",
+				Env:     map[string]string{"FOO": "b\x00ar"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "NULInArg",
+			drv: &zbstore.Derivation{
+				System:  "x86_64-linux",
+				Builder: "/bin/sh",
+				Args:    []string{"-c", "echo\x00hi"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "EqualsInName",
+			drv: &zbstore.Derivation{
+				System:  "x86_64-linux",
+				Builder: "/bin/sh",
+				Env:     map[string]string{"FOO=BAR": "baz"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "EmptyName",
+			drv: &zbstore.Derivation{
+				System:  "x86_64-linux",
+				Builder: "/bin/sh",
+				Env:     map[string]string{"": "baz"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "LinuxLargeVariable",
+			drv: &zbstore.Derivation{
+				System:  "x86_64-linux",
+				Builder: "/bin/sh",
+				Env:     map[string]string{"FOO": strings.Repeat("x", linuxMaxArgStrlen)},
+			},
+			wantErr: true,
+		},
+		{
+			name: "LinuxLargeTotal",
+			drv: &zbstore.Derivation{
+				System:  "x86_64-linux",
+				Builder: "/bin/sh",
+				Env: map[string]string{
+					"A": strings.Repeat("x", 100_000),
+					"B": strings.Repeat("x", 100_000),
+				},
+				Args: slices.Repeat([]string{strings.Repeat("x", 100_000)}, 20),
+			},
+			wantErr: true,
+		},
+		{
+			name: "MacOSLargeVariable",
+			drv: &zbstore.Derivation{
+				System:  "aarch64-macos",
+				Builder: "/bin/sh",
+				Env:     map[string]string{"FOO": strings.Repeat("x", linuxMaxArgStrlen)},
+			},
+		},
+		{
+			name: "MacOSLargeTotal",
+			drv: &zbstore.Derivation{
+				System:  "aarch64-macos",
+				Builder: "/bin/sh",
+				Env:     map[string]string{"FOO": strings.Repeat("x", darwinArgMax)},
+			},
+			wantErr: true,
+		},
+		{
+			name: "WindowsLargeVariable",
+			drv: &zbstore.Derivation{
+				System:  "x86_64-windows",
+				Builder: `C:\Windows\System32\cmd.exe`,
+				Env:     map[string]string{"FOO": strings.Repeat("x", windowsMaxLength)},
+			},
+			wantErr: true,
+		},
+		{
+			name: "WindowsCaseConflict",
+			drv: &zbstore.Derivation{
+				System:  "x86_64-windows",
+				Builder: `C:\Windows\System32\cmd.exe`,
+				Env:     map[string]string{"Path": "a", "PATH": "b"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "LinuxCaseDistinct",
+			drv: &zbstore.Derivation{
+				System:  "x86_64-linux",
+				Builder: "/bin/sh",
+				Env:     map[string]string{"Path": "a", "PATH": "b"},
+			},
+		},
+		{
+			name: "Builtin",
+			drv: &zbstore.Derivation{
+				System:  "builtin",
+				Builder: "builtin:fetchurl",
+				Env:     map[string]string{"FOO": strings.Repeat("x", darwinArgMax*3)},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := checkDerivationEnv(test.drv)
+			if test.wantErr && err == nil {
+				t.Error("checkDerivationEnv(...) = <nil>; want error")
+			} else if !test.wantErr && err != nil {
+				t.Errorf("checkDerivationEnv(...) = %v; want <nil>", err)
+			}
+		})
+	}
+}
@@ -7,10 +7,13 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"slices"
 	"strings"
 
 	"zb.256lights.llc/pkg/internal/lua"
 	"zb.256lights.llc/pkg/internal/lualex"
+	"zb.256lights.llc/pkg/internal/system"
+	"zb.256lights.llc/pkg/internal/windowspath"
 	"zb.256lights.llc/pkg/sets"
 	"zb.256lights.llc/pkg/zbstore"
 	"zombiezen.com/go/log"
@@ -22,10 +25,82 @@ const derivationTypeName = "derivation"
 type Derivation struct {
 	*zbstore.Derivation
 	Path zbstore.Path
+
+	// outputNames is the list of output names
+	// in the order they were passed to the derivation function.
+	outputNames []string
+
+	// Position is the location in a Lua file
+	// where the derivation function was called to create the derivation.
+	// It is the zero value if the derivation was not created from a file.
+	Position Position
+
+	// Meta is the information from the derivation function's meta argument.
+	Meta Meta
+}
+
+// Position is a location in a Lua source file.
+type Position struct {
+	Filename string
+	Line     int
+}
+
+// IsValid reports whether pos refers to a file.
+func (pos Position) IsValid() bool {
+	return pos.Filename != ""
+}
+
+// String formats the position as "filename:line".
+func (pos Position) String() string {
+	if !pos.IsValid() {
+		return "-"
+	}
+	if pos.Line <= 0 {
+		return pos.Filename
+	}
+	return fmt.Sprintf("%s:%d", pos.Filename, pos.Line)
+}
+
+// callerPosition returns the position of the innermost function on the call stack
+// that was loaded from a file.
+// Functions from the prelude and from expressions are skipped
+// so that helpers like fetchurl report their caller's position.
+func callerPosition(l *lua.State) Position {
+	for level := 1; ; level++ {
+		ar := l.Info(level)
+		if ar == nil {
+			return Position{}
+		}
+		if filename, ok := ar.Source.Filename(); ok {
+			return Position{
+				Filename: filename,
+				Line:     ar.CurrentLine,
+			}
+		}
+	}
 }
 
 func (drv *Derivation) Freeze() error { return nil }
 
+// OutputNames returns the names of the derivation's outputs
+// in the order they were passed to the derivation function.
+// The first output is the default output.
+func (drv *Derivation) OutputNames() []string {
+	if len(drv.outputNames) == 0 {
+		return []string{zbstore.DefaultDerivationOutputName}
+	}
+	return slices.Clone(drv.outputNames)
+}
+
+// DefaultOutputName returns the name of the output
+// that is used when the derivation is converted to a string.
+func (drv *Derivation) DefaultOutputName() string {
+	if len(drv.outputNames) == 0 {
+		return zbstore.DefaultDerivationOutputName
+	}
+	return drv.outputNames[0]
+}
+
 func registerDerivationMetatable(ctx context.Context, l *lua.State) error {
 	lua.NewMetatable(l, derivationTypeName)
 	err := lua.SetPureFunctions(ctx, l, 0, map[string]lua.Function{
@@ -51,6 +126,11 @@ func (eval *Eval) derivationFunction(ctx context.Context, l *lua.State) (int, er
 			Dir: eval.storeDir,
 			Env: make(map[string]string),
 		},
+		Position: callerPosition(l),
+	}
+	where := drv.Position.String() + ": "
+	if !drv.Position.IsValid() {
+		where = lua.Where(l, 1)
 	}
 
 	// Configure outputs.
@@ -62,42 +142,106 @@ func (eval *Eval) derivationFunction(ctx context.Context, l *lua.State) (int, er
 		var err error
 		h, err = nix.ParseHash(s)
 		if err != nil {
-			return 0, fmt.Errorf("outputHash argument: %v", err)
+			return 0, fmt.Errorf("%soutputHash argument: %v", where, err)
 		}
 	default:
-		return 0, fmt.Errorf("outputHash argument: %v expected, got %v", lua.TypeString, typ)
+		return 0, fmt.Errorf("%soutputHash argument: %v expected, got %v", where, lua.TypeString, typ)
 	}
 	l.Pop(1)
 
+	var mode string
 	switch typ := l.RawField(1, "outputHashMode"); typ {
+	case lua.TypeNil:
+	case lua.TypeString:
+		mode, _ = l.ToString(-1)
+		if mode != "flat" && mode != "recursive" && mode != "text" {
+			return 0, fmt.Errorf("%soutputHashMode argument: invalid mode %q", where, mode)
+		}
+	default:
+		return 0, fmt.Errorf("%soutputHashMode argument: %v expected, got %v", where, lua.TypeString, typ)
+	}
+	l.Pop(1)
+
+	hashAlgo := nix.SHA256
+	switch typ := l.RawField(1, "outputHashAlgo"); typ {
 	case lua.TypeNil:
 		if !h.IsZero() {
-			drv.Outputs = map[string]*zbstore.DerivationOutputType{
-				zbstore.DefaultDerivationOutputName: zbstore.FixedCAOutput(nix.FlatFileContentAddress(h)),
-			}
+			hashAlgo = h.Type()
 		}
 	case lua.TypeString:
-		switch mode, _ := l.ToString(-1); mode {
-		case "flat":
-			drv.Outputs = map[string]*zbstore.DerivationOutputType{
-				zbstore.DefaultDerivationOutputName: zbstore.FixedCAOutput(nix.FlatFileContentAddress(h)),
+		s, _ := l.ToString(-1)
+		var err error
+		hashAlgo, err = nix.ParseHashType(s)
+		if err != nil {
+			return 0, fmt.Errorf("%soutputHashAlgo argument: %v", where, err)
+		}
+		if !h.IsZero() && h.Type() != hashAlgo {
+			return 0, fmt.Errorf("%soutputHashAlgo argument: %v does not match outputHash (uses %v)", where, hashAlgo, h.Type())
+		}
+	default:
+		return 0, fmt.Errorf("%soutputHashAlgo argument: %v expected, got %v", where, lua.TypeString, typ)
+	}
+	l.Pop(1)
+
+	outputNames := []string{zbstore.DefaultDerivationOutputName}
+	switch typ := l.RawField(1, "outputs"); typ {
+	case lua.TypeNil:
+	case lua.TypeTable:
+		outputNames = outputNames[:0]
+		err := ipairs(ctx, l, -1, func(i int64) error {
+			if typ := l.Type(-1); typ != lua.TypeString {
+				return fmt.Errorf("#%d: %v expected, got %v", i, lua.TypeString, typ)
 			}
-		case "recursive":
-			drv.Outputs = map[string]*zbstore.DerivationOutputType{
-				zbstore.DefaultDerivationOutputName: zbstore.FixedCAOutput(nix.RecursiveFileContentAddress(h)),
+			outputName, _ := l.ToString(-1)
+			if !eval.isWellFormedOutputName(outputName) {
+				return fmt.Errorf("#%d: invalid output name %s", i, lualex.Quote(outputName))
 			}
-		default:
-			return 0, fmt.Errorf("outputHashMode argument: invalid mode %q", mode)
+			if slices.Contains(outputNames, outputName) {
+				return fmt.Errorf("#%d: duplicate output name %s", i, lualex.Quote(outputName))
+			}
+			outputNames = append(outputNames, outputName)
+			return nil
+		})
+		if err != nil {
+			return 0, fmt.Errorf("%soutputs argument: %v", where, err)
+		}
+		if len(outputNames) == 0 {
+			return 0, fmt.Errorf("%soutputs argument: must have at least one output", where)
 		}
 	default:
-		return 0, fmt.Errorf("outputHashMode argument: %v expected, got %v", lua.TypeString, typ)
+		return 0, fmt.Errorf("%soutputs argument: %v expected, got %v", where, lua.TypeTable, typ)
 	}
 	l.Pop(1)
+	drv.outputNames = outputNames
 
-	if h.IsZero() {
-		// TODO(someday): Multiple outputs.
+	if !h.IsZero() {
+		if len(outputNames) != 1 || outputNames[0] != zbstore.DefaultDerivationOutputName {
+			return 0, fmt.Errorf("%soutputs argument: fixed-output derivations must have a single %s output", where,
+				zbstore.DefaultDerivationOutputName)
+		}
+		var ca nix.ContentAddress
+		switch mode {
+		case "recursive":
+			ca = nix.RecursiveFileContentAddress(h)
+		case "text":
+			ca = nix.TextContentAddress(h)
+		default:
+			ca = nix.FlatFileContentAddress(h)
+		}
 		drv.Outputs = map[string]*zbstore.DerivationOutputType{
-			zbstore.DefaultDerivationOutputName: zbstore.RecursiveFileFloatingCAOutput(nix.SHA256),
+			zbstore.DefaultDerivationOutputName: zbstore.FixedCAOutput(ca),
+		}
+	} else {
+		drv.Outputs = make(map[string]*zbstore.DerivationOutputType, len(outputNames))
+		for _, outputName := range outputNames {
+			switch mode {
+			case "flat":
+				drv.Outputs[outputName] = zbstore.FlatFileFloatingCAOutput(hashAlgo)
+			case "text":
+				drv.Outputs[outputName] = zbstore.TextFloatingCAOutput(hashAlgo)
+			default:
+				drv.Outputs[outputName] = zbstore.RecursiveFileFloatingCAOutput(hashAlgo)
+			}
 		}
 	}
 
@@ -106,6 +250,7 @@ func (eval *Eval) derivationFunction(ctx context.Context, l *lua.State) (int, er
 	tableCopyIndex := l.Top()
 
 	// Obtain environment variables from extra pairs.
+	builderHasContext := false
 	l.PushNil()
 	for l.Next(1) {
 		if l.Type(-2) != lua.TypeString {
@@ -125,29 +270,30 @@ func (eval *Eval) derivationFunction(ctx context.Context, l *lua.State) (int, er
 		switch k {
 		case "name":
 			if typ := l.Type(-1); typ != lua.TypeString {
-				return 0, fmt.Errorf("name argument: %v expected, got %v", lua.TypeString, typ)
+				return 0, fmt.Errorf("%sname argument: %v expected, got %v", where, lua.TypeString, typ)
 			}
 			drv.Name, _ = l.ToString(-1)
 			if _, err := eval.storeDir.Object("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa-" + drv.Name); err != nil {
-				return 0, fmt.Errorf("name argument: %s is an invalid name", lualex.Quote(drv.Name))
+				return 0, fmt.Errorf("%sname argument: %s is an invalid name", where, lualex.Quote(drv.Name))
 			}
 		case "system":
 			if typ := l.Type(-1); typ != lua.TypeString {
-				return 0, fmt.Errorf("system argument: %v expected, got %v", lua.TypeString, typ)
+				return 0, fmt.Errorf("%ssystem argument: %v expected, got %v", where, lua.TypeString, typ)
 			}
 			drv.System, _ = l.ToString(-1)
 		case "builder":
 			if typ := l.Type(-1); typ != lua.TypeString {
-				return 0, fmt.Errorf("system argument: %v expected, got %v", lua.TypeString, typ)
+				return 0, fmt.Errorf("%sbuilder argument: %v expected, got %v", where, lua.TypeString, typ)
 			}
+			builderHasContext = l.StringContext(-1).Len() > 0
 			var err error
 			drv.Builder, err = stringToEnvVar(l, drv.Derivation, -1)
 			if err != nil {
-				return 0, fmt.Errorf("%s: %v", k, err)
+				return 0, fmt.Errorf("%s%s: %v", where, k, err)
 			}
 		case "args":
 			if typ := l.Type(-1); typ != lua.TypeTable {
-				return 0, fmt.Errorf("args argument: %v expected, got %v", lua.TypeTable, typ)
+				return 0, fmt.Errorf("%sargs argument: %v expected, got %v", where, lua.TypeTable, typ)
 			}
 			err := ipairs(ctx, l, -1, func(i int64) error {
 				arg, err := stringToEnvVar(l, drv.Derivation, -1)
@@ -158,13 +304,30 @@ func (eval *Eval) derivationFunction(ctx context.Context, l *lua.State) (int, er
 				return nil
 			})
 			if err != nil {
-				return 0, fmt.Errorf("%s %v", k, err)
+				return 0, fmt.Errorf("%s%s %v", where, k, err)
+			}
+		case checksArgument:
+			// Checks are not part of the derivation,
+			// so that changing them does not cause a rebuild.
+			if typ := l.Type(-1); typ != lua.TypeTable && typ != lua.TypeFunction {
+				return 0, fmt.Errorf("%s%s argument: %v or %v expected, got %v", where, k, lua.TypeTable, lua.TypeFunction, typ)
 			}
+			l.Pop(1)
+			continue
+		case metaArgument:
+			// Like checks, metadata is not part of the derivation.
+			meta, err := eval.parseMeta(ctx, l, -1, strings.TrimSuffix(where, ": "))
+			if err != nil {
+				return 0, fmt.Errorf("%s%s argument: %v", where, k, err)
+			}
+			drv.Meta = *meta
+			l.Pop(1)
+			continue
 		}
 
 		v, err := toEnvVar(ctx, l, drv.Derivation, -1, true)
 		if err != nil {
-			return 0, fmt.Errorf("%s: %v", k, err)
+			return 0, fmt.Errorf("%s%s: %v", where, k, err)
 		}
 		drv.Env[k] = v
 
@@ -172,6 +335,22 @@ func (eval *Eval) derivationFunction(ctx context.Context, l *lua.State) (int, er
 		l.Pop(1)
 	}
 
+	if err := checkSystemArgument(drv.System); err != nil {
+		return 0, fmt.Errorf("%ssystem argument: %v", where, err)
+	}
+	if !drv.Meta.supportsSystem(eval.system) {
+		position := strings.TrimSuffix(where, ": ")
+		msg := fmt.Sprintf("%s does not list %s in %s.platforms", lualex.Quote(drv.Name), SystemTriple(eval.system), metaArgument)
+		eval.emitWarning(ctx, &Warning{
+			Message:  msg,
+			Key:      position + ": " + msg,
+			Position: position,
+		})
+	}
+	if err := checkBuilderArgument(drv.Derivation, builderHasContext); err != nil {
+		return 0, fmt.Errorf("%sbuilder argument: %v", where, err)
+	}
+
 	for outputName, outType := range drv.Outputs {
 		switch {
 		case outType.IsFloating():
@@ -186,39 +365,29 @@ func (eval *Eval) derivationFunction(ctx context.Context, l *lua.State) (int, er
 			panic(outputName + " has an unhandled output type")
 		}
 	}
+	if err := checkDerivationEnv(drv.Derivation); err != nil {
+		return 0, fmt.Errorf("%sderivation: %v", where, err)
+	}
 	var err error
 	drv.Path, err = writeDerivation(ctx, eval.store, drv.Derivation)
 	if err != nil {
-		return 0, fmt.Errorf("derivation: %v", err)
+		return 0, fmt.Errorf("%sderivation: %v", where, err)
+	}
+	if eval.reportDerivation != nil {
+		eval.reportDerivation(ctx, drv.Path)
+	}
+	if eval.stats != nil {
+		eval.stats.derivations.Add(1)
 	}
 
 	pushStorePath(l, drv.Path)
 	if err := l.SetField(ctx, tableCopyIndex, "drvPath"); err != nil {
-		return 0, fmt.Errorf("derivation: %v", err)
+		return 0, fmt.Errorf("%sderivation: %v", where, err)
 	}
-	for outputName, outType := range drv.Outputs {
-		var placeholder string
-		switch {
-		case outType.IsFloating():
-			placeholder = zbstore.UnknownCAOutputPlaceholder(zbstore.OutputReference{
-				DrvPath:    drv.Path,
-				OutputName: zbstore.DefaultDerivationOutputName,
-			})
-		case outType.IsFixed():
-			// TODO(someday): We already computed this earlier.
-			p, err := drv.OutputPath(outputName)
-			if err != nil {
-				panic(err)
-			}
-			placeholder = string(p)
-		}
-		ref := zbstore.OutputReference{
-			DrvPath:    drv.Path,
-			OutputName: outputName,
-		}
-		l.PushStringContext(placeholder, sets.New(contextValue{outputReference: ref}.String()))
+	for outputName := range drv.Outputs {
+		pushOutputPlaceholder(l, drv, outputName)
 		if err := l.SetField(ctx, tableCopyIndex, outputName); err != nil {
-			return 0, fmt.Errorf("derivation: %v", err)
+			return 0, fmt.Errorf("%sderivation: %v", where, err)
 		}
 	}
 
@@ -235,6 +404,146 @@ func (eval *Eval) derivationFunction(ctx context.Context, l *lua.State) (int, er
 	return 1, nil
 }
 
+// isWellFormedOutputName reports whether name can be used as an output name.
+// In addition to the rules of [zbstore.IsValidOutputName],
+// the name must be usable as an environment variable name
+// and as the suffix of a store object name.
+func (eval *Eval) isWellFormedOutputName(name string) bool {
+	if !zbstore.IsValidOutputName(name) || strings.Contains(name, "=") {
+		return false
+	}
+	_, err := eval.storeDir.Object("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa-x-" + name)
+	return err == nil
+}
+
+// Builtin builders run inside the store server
+// instead of as a separate process.
+const (
+	builtinSystem        = "builtin"
+	builtinBuilderPrefix = "builtin:"
+)
+
+// checkSystemArgument reports an error if sys is not a system
+// that a derivation can be built for.
+func checkSystemArgument(sys string) error {
+	if sys == "" {
+		return errors.New("missing")
+	}
+	if sys == builtinSystem {
+		return nil
+	}
+	if _, err := system.Parse(sys); err != nil {
+		return err
+	}
+	return nil
+}
+
+// checkBuilderArgument reports an error if drv's builder
+// cannot be run on drv's system.
+// Builders for the builtin system must name a builtin.
+// Other builders must either be an absolute path
+// or refer to a store object (as indicated by hasContext).
+// drv.System must have been validated by [checkSystemArgument].
+func checkBuilderArgument(drv *zbstore.Derivation, hasContext bool) error {
+	if drv.Builder == "" {
+		return errors.New("missing")
+	}
+	if drv.System == builtinSystem {
+		if !strings.HasPrefix(drv.Builder, builtinBuilderPrefix) || drv.Builder == builtinBuilderPrefix {
+			return fmt.Errorf("%s does not name a builtin (required for system %s)",
+				lualex.Quote(drv.Builder), lualex.Quote(builtinSystem))
+		}
+		return nil
+	}
+	if strings.HasPrefix(drv.Builder, builtinBuilderPrefix) {
+		return fmt.Errorf("builtin %s requires system %s",
+			lualex.Quote(drv.Builder), lualex.Quote(builtinSystem))
+	}
+	if hasContext {
+		return nil
+	}
+	sys, err := system.Parse(drv.System)
+	if err != nil {
+		return err
+	}
+	if sys.OS.IsWindows() && !windowspath.IsAbs(drv.Builder) ||
+		!sys.OS.IsWindows() && !strings.HasPrefix(drv.Builder, "/") {
+		return fmt.Errorf("%s is not an absolute path or a store path", lualex.Quote(drv.Builder))
+	}
+	return nil
+}
+
+// pushOutputPlaceholder pushes the string that stands in
+// for the path of the given output of drv
+// in other derivations' environment variables and arguments.
+// The string's context refers to the output.
+// drv.Outputs must have an entry for outputName.
+func pushOutputPlaceholder(l *lua.State, drv *Derivation, outputName string) {
+	ref := zbstore.OutputReference{
+		DrvPath:    drv.Path,
+		OutputName: outputName,
+	}
+	var placeholder string
+	switch outType := drv.Outputs[outputName]; {
+	case outType.IsFloating():
+		placeholder = zbstore.UnknownCAOutputPlaceholder(ref)
+	case outType.IsFixed():
+		// TODO(someday): We already computed this earlier.
+		p, err := drv.OutputPath(outputName)
+		if err != nil {
+			panic(err)
+		}
+		placeholder = string(p)
+	default:
+		panic(outputName + " has an unhandled output type")
+	}
+	l.PushStringContext(placeholder, sets.New(contextValue{outputReference: ref}.String()))
+}
+
+// placeholderFunction returns the string that a derivation's builder
+// will see in place of the path of one of the derivation's own outputs.
+// The output name defaults to "out".
+func placeholderFunction(ctx context.Context, l *lua.State) (int, error) {
+	outputName := zbstore.DefaultDerivationOutputName
+	if !l.IsNoneOrNil(1) {
+		var err error
+		outputName, err = lua.CheckString(l, 1)
+		if err != nil {
+			return 0, err
+		}
+		if !zbstore.IsValidOutputName(outputName) {
+			return 0, lua.NewArgError(l, 1, fmt.Sprintf("invalid output name %s", lualex.Quote(outputName)))
+		}
+	}
+	l.PushString(zbstore.HashPlaceholder(outputName))
+	return 1, nil
+}
+
+// outputPlaceholderFunction returns the string that stands in
+// for the path of an output of another derivation
+// until the output is realized.
+// The output name defaults to "out".
+// Unlike the derivation's fields, the output does not need to be accessed by name,
+// so outputPlaceholder can be used with computed output names.
+func outputPlaceholderFunction(ctx context.Context, l *lua.State) (int, error) {
+	drv, err := toDerivation(l)
+	if err != nil {
+		return 0, err
+	}
+	outputName := zbstore.DefaultDerivationOutputName
+	if !l.IsNoneOrNil(2) {
+		outputName, err = lua.CheckString(l, 2)
+		if err != nil {
+			return 0, err
+		}
+	}
+	if _, ok := drv.Outputs[outputName]; !ok {
+		return 0, lua.NewArgError(l, 2, fmt.Sprintf("%s does not have an output named %s", drv.Path, lualex.Quote(outputName)))
+	}
+	pushOutputPlaceholder(l, drv, outputName)
+	return 1, nil
+}
+
 func toEnvVar(ctx context.Context, l *lua.State, drv *zbstore.Derivation, idx int, allowLists bool) (string, error) {
 	idx = l.AbsIndex(idx)
 	switch typ := l.Type(idx); typ {
@@ -406,39 +715,29 @@ func derivationPairNext(ctx context.Context, l *lua.State) (int, error) {
 }
 
 // derivationToString handles the __tostring metamethod on derivations.
+// The result is the placeholder for the derivation's default output.
 func derivationToString(ctx context.Context, l *lua.State) (int, error) {
-	if _, err := toDerivation(l); err != nil {
-		return 0, err
-	}
-	l.UserValue(1, 1) // Push derivation argument table.
-	if _, err := l.Field(ctx, -1, "out"); err != nil {
+	drv, err := toDerivation(l)
+	if err != nil {
 		return 0, err
 	}
+	pushOutputPlaceholder(l, drv, drv.DefaultOutputName())
 	return 1, nil
 }
 
 // concatDerivation handles the __concat metamethod on derivations.
+// Derivation operands are replaced with the placeholder for their default output.
 func concatDerivation(ctx context.Context, l *lua.State) (int, error) {
 	l.SetTop(2)
-	if testDerivation(l, 1) != nil {
-		l.UserValue(1, 1) // Push derivation argument table.
-		if _, err := l.Field(ctx, -1, "out"); err != nil {
-			return 0, err
-		}
-		if err := l.Replace(1); err != nil {
-			return 0, err
-		}
-		l.Pop(1)
-	}
-	if testDerivation(l, 2) != nil {
-		l.UserValue(2, 1) // Push derivation argument table.
-		if _, err := l.Field(ctx, -1, "out"); err != nil {
-			return 0, err
+	for idx := 1; idx <= 2; idx++ {
+		drv := testDerivation(l, idx)
+		if drv == nil {
+			continue
 		}
-		if err := l.Replace(2); err != nil {
+		pushOutputPlaceholder(l, drv, drv.DefaultOutputName())
+		if err := l.Replace(idx); err != nil {
 			return 0, err
 		}
-		l.Pop(1)
 	}
 	if err := l.Concat(ctx, 2); err != nil {
 		return 0, err
@@ -0,0 +1,47 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package frontend
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultEntryFiles is the list of file names that are searched for
+// when a URL names a local directory, in order of preference.
+var DefaultEntryFiles = []string{"zb.lua", "default.lua"}
+
+// findEntryFile returns the Lua file that path refers to.
+// If path is not a directory (or does not exist), it is returned as-is.
+// Otherwise, findEntryFile returns the first file in names
+// that is present in the directory.
+// If none of the names are present, the parent directories are searched in turn,
+// like Git does for its repository directory.
+// findEntryFile returns an error if no entry file is found.
+func findEntryFile(path string, names []string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil || !info.IsDir() {
+		return path, nil
+	}
+	dir, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	for {
+		for _, name := range names {
+			candidate := filepath.Join(dir, name)
+			if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+				return candidate, nil
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", fmt.Errorf("no %s in %s or any parent directory",
+				strings.Join(names, " or "), path)
+		}
+		dir = parent
+	}
+}
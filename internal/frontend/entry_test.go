@@ -0,0 +1,126 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package frontend
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"zb.256lights.llc/pkg/internal/backendtest"
+	"zb.256lights.llc/pkg/internal/testcontext"
+)
+
+func TestFindEntryFile(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{
+		"zb.lua",
+		"default.lua",
+		filepath.Join("lib", "default.lua"),
+		filepath.Join("src", "pkg", "main.lua"),
+	} {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("return {}\n"), 0o666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A directory named like an entry file is skipped.
+	if err := os.MkdirAll(filepath.Join(root, "lib", "zb.lua"), 0o777); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path  string
+		names []string
+		want  string
+	}{
+		{
+			path:  root,
+			names: DefaultEntryFiles,
+			want:  filepath.Join(root, "zb.lua"),
+		},
+		{
+			path:  root,
+			names: []string{"default.lua"},
+			want:  filepath.Join(root, "default.lua"),
+		},
+		{
+			path:  filepath.Join(root, "lib"),
+			names: DefaultEntryFiles,
+			want:  filepath.Join(root, "lib", "default.lua"),
+		},
+		{
+			path:  filepath.Join(root, "src", "pkg"),
+			names: DefaultEntryFiles,
+			want:  filepath.Join(root, "zb.lua"),
+		},
+		{
+			path:  filepath.Join(root, "src", "pkg"),
+			names: []string{"main.lua"},
+			want:  filepath.Join(root, "src", "pkg", "main.lua"),
+		},
+		{
+			path:  filepath.Join(root, "src", "pkg", "main.lua"),
+			names: DefaultEntryFiles,
+			want:  filepath.Join(root, "src", "pkg", "main.lua"),
+		},
+		{
+			path:  filepath.Join(root, "nonexistent.lua"),
+			names: DefaultEntryFiles,
+			want:  filepath.Join(root, "nonexistent.lua"),
+		},
+	}
+	for _, test := range tests {
+		got, err := findEntryFile(test.path, test.names)
+		if got != test.want || err != nil {
+			t.Errorf("findEntryFile(%q, %q) = %q, %v; want %q, <nil>",
+				test.path, test.names, got, err, test.want)
+		}
+	}
+
+	t.Run("NotFound", func(t *testing.T) {
+		got, err := findEntryFile(filepath.Join(root, "src"), []string{"nonexistent-entry.lua"})
+		if err == nil {
+			t.Errorf("findEntryFile(...) = %q, <nil>; want error", got)
+		}
+	})
+}
+
+func TestURLsDirectory(t *testing.T) {
+	ctx := testcontext.New(t)
+	eval, err := NewEval(&Options{
+		StoreDirectory: backendtest.NewStoreDirectory(t),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := eval.Close(); err != nil {
+			t.Error("eval.Close:", err)
+		}
+	}()
+
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "zb.lua"), []byte("return { hello = \"hi\" }\n"), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	subdir := filepath.Join(root, "sub")
+	if err := os.Mkdir(subdir, 0o777); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, u := range []string{root + "#hello", subdir + "#hello"} {
+		got, err := eval.URLs(ctx, []string{u})
+		if err != nil {
+			t.Errorf("eval.URLs(ctx, %q): %v", u, err)
+			continue
+		}
+		if len(got) != 1 || got[0] != "hi" {
+			t.Errorf("eval.URLs(ctx, %q) = %v; want [hi]", u, got)
+		}
+	}
+}
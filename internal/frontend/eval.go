@@ -9,6 +9,7 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"embed"
 	"errors"
 	"fmt"
@@ -19,12 +20,15 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	"zb.256lights.llc/pkg/bytebuffer"
 	"zb.256lights.llc/pkg/internal/lua"
 	"zb.256lights.llc/pkg/internal/lualex"
+	"zb.256lights.llc/pkg/internal/system"
 	"zb.256lights.llc/pkg/internal/zbstorerpc"
 	"zb.256lights.llc/pkg/sets"
 	"zb.256lights.llc/pkg/zbstore"
@@ -33,7 +37,10 @@ import (
 	"zombiezen.com/go/sqlite/sqlitex"
 )
 
-const stdlibRegistryKey = "zb.256lights.llc/pkg/internal/frontend stdlib"
+const (
+	stdlibRegistryKey           = "zb.256lights.llc/pkg/internal/frontend stdlib"
+	globalsMetatableRegistryKey = "zb.256lights.llc/pkg/internal/frontend globals metatable"
+)
 
 //go:embed prelude.luac
 var preludeSource []byte
@@ -65,8 +72,11 @@ type Options struct {
 	StoreDirectory zbstore.Directory
 	// CacheDBPath is the path to a database file used to speed up store imports.
 	// If empty, an in-memory cache will be used.
+	// If not empty, the values of imported modules are also saved in the database
+	// and reused by later evaluations if the files they read have not changed.
 	CacheDBPath string
 	// LookupEnv is called for the Lua os.getenv function.
+	// It may be called concurrently from multiple goroutines.
 	// If nil, os.getenv will always return nil.
 	LookupEnv func(ctx context.Context, key string) (string, bool)
 	// HTTPClient is used for making web requests.
@@ -75,6 +85,46 @@ type Options struct {
 	// DownloadBufferCreator is used to create buffers for unbounded downloads.
 	// If nil, then in-memory byte slices are used with reasonable limits.
 	DownloadBufferCreator bytebuffer.Creator
+	// Version is the version of zb (e.g. "1.2.3") exposed to Lua as zb.version.
+	// If empty, then zb.version is nil.
+	Version string
+	// System is the system that evaluation targets.
+	// It is exposed to Lua as zb.system.current
+	// and selects system-specific values in URL fragments and package listings.
+	// If zero, [system.Current] is used.
+	System system.System
+	// Warn is called for each distinct warning emitted by the Lua zb.warn function.
+	// Warnings with the same key are only reported once per [Eval].
+	// It may be called concurrently from multiple goroutines.
+	// If nil, warnings are discarded.
+	Warn func(ctx context.Context, w *Warning)
+	// Profiler receives samples of the Lua call stack during evaluation.
+	// If nil, evaluation is not profiled.
+	Profiler *Profiler
+	// Coverage records the lines of Lua files executed during evaluation.
+	// If nil, coverage is not recorded.
+	Coverage *Coverage
+	// Stats counts the work done during evaluation.
+	// If nil, statistics are not collected.
+	Stats *Stats
+	// ReportImportBuild is called before evaluation builds derivations
+	// because Lua code read one of their outputs (import from derivation).
+	// It may be called concurrently from multiple goroutines.
+	// If nil, such builds are not reported.
+	ReportImportBuild func(ctx context.Context, b *ImportBuild)
+	// ReportDerivation is called after the Lua derivation function
+	// writes a derivation to the store.
+	// It may be called concurrently from multiple goroutines.
+	// If nil, derivations are not reported.
+	ReportDerivation func(ctx context.Context, drvPath zbstore.Path)
+	// NoImportFromDerivation forbids evaluation from building derivations.
+	// Reading a derivation's output (e.g. with import or readFile)
+	// raises an error instead.
+	NoImportFromDerivation bool
+	// EntryFiles is the list of file names to search for
+	// when a URL passed to [*Eval.URLs] names a local directory.
+	// If empty, [DefaultEntryFiles] is used.
+	EntryFiles []string
 }
 
 // Store is the set of store operations that [Eval] needs.
@@ -90,6 +140,22 @@ type Store interface {
 	Realize(ctx context.Context, want sets.Set[zbstore.OutputReference]) ([]*zbstorerpc.BuildResult, error)
 }
 
+// An Eval evaluates Lua code that describes derivations.
+//
+// Methods on Eval other than Close are safe to call concurrently
+// from multiple goroutines.
+// Each call runs in its own Lua state,
+// so independent evaluations do not block each other.
+// Concurrent calls share the modules that have been imported,
+// the cache database, and builds started by import from derivation,
+// so a file is only evaluated once per Eval
+// no matter how many calls import it.
+// Module values are frozen, so one call cannot modify values that another call sees.
+// Imported modules are evaluated independently of the context
+// of the call that imported them,
+// so canceling one call does not cause errors in other calls.
+// Modules are not re-read if their files change;
+// create a new Eval to see changes.
 type Eval struct {
 	store        Store
 	storeDir     zbstore.Directory
@@ -97,6 +163,32 @@ type Eval struct {
 	lookupEnv    func(ctx context.Context, key string) (string, bool)
 	httpClient   HTTPClient
 	downloadTemp bytebuffer.Creator
+	version      string
+	system       system.System
+	warn         func(ctx context.Context, w *Warning)
+	profiler     *Profiler
+	coverage     *Coverage
+	stats        *Stats
+
+	reportImportBuild      func(ctx context.Context, b *ImportBuild)
+	reportDerivation       func(ctx context.Context, drvPath zbstore.Path)
+	noImportFromDerivation bool
+	entryFiles             []string
+
+	importRealizationsMutex sync.Mutex
+	importRealizations      map[zbstore.OutputReference]*importRealization
+
+	// moduleCache is true if module values are persisted in the cache database.
+	moduleCache     bool
+	moduleCacheHits atomic.Int64
+	modulesMutex    sync.Mutex
+	// modules is the list of modules imported during evaluation
+	// in the order they were imported.
+	// It is only populated if moduleCache is true.
+	modules []*module
+
+	warnedMutex sync.Mutex
+	warned      sets.Set[string]
 
 	baseImportContext context.Context
 	cancelImports     context.CancelFunc
@@ -112,6 +204,9 @@ type Eval struct {
 	loadedState lua.State
 }
 
+// NewEval returns a new [*Eval] with the given options.
+// The caller is responsible for calling [*Eval.Close]
+// when the Eval is no longer needed.
 func NewEval(opts *Options) (_ *Eval, err error) {
 	eval := &Eval{
 		store:        opts.Store,
@@ -119,6 +214,21 @@ func NewEval(opts *Options) (_ *Eval, err error) {
 		lookupEnv:    opts.LookupEnv,
 		httpClient:   opts.HTTPClient,
 		downloadTemp: opts.DownloadBufferCreator,
+		version:      opts.Version,
+		system:       opts.System,
+		warn:         opts.Warn,
+		profiler:     opts.Profiler,
+		coverage:     opts.Coverage,
+		stats:        opts.Stats,
+		warned:       make(sets.Set[string]),
+
+		reportImportBuild:      opts.ReportImportBuild,
+		reportDerivation:       opts.ReportDerivation,
+		noImportFromDerivation: opts.NoImportFromDerivation,
+		entryFiles:             slices.Clone(opts.EntryFiles),
+
+		// Profiles and coverage reports would miss modules loaded from the cache.
+		moduleCache: opts.CacheDBPath != "" && opts.Profiler == nil && opts.Coverage == nil,
 	}
 	if eval.lookupEnv == nil {
 		eval.lookupEnv = func(ctx context.Context, key string) (string, bool) {
@@ -128,9 +238,15 @@ func NewEval(opts *Options) (_ *Eval, err error) {
 	if eval.httpClient == nil {
 		eval.httpClient = http.DefaultClient
 	}
+	if len(eval.entryFiles) == 0 {
+		eval.entryFiles = DefaultEntryFiles
+	}
 	if eval.downloadTemp == nil {
 		eval.downloadTemp = bytebuffer.BufferCreator{}
 	}
+	if eval.system == (system.System{}) {
+		eval.system = system.Current()
+	}
 
 	var schema sqlitemigration.Schema
 	for i := 1; ; i++ {
@@ -198,14 +314,22 @@ func (eval *Eval) initZygote() error {
 
 	// Set other built-ins.
 	extraBaseFunctions := map[string]lua.Function{
-		"await":      awaitFunction,
-		"derivation": eval.derivationFunction,
-		"import":     eval.importFunction,
-		"lazy":       lazyFunction,
-		"toFile":     eval.toFileFunction,
-		"path":       eval.pathFunction,
-		"readFile":   eval.readFileFunction,
-		"storePath":  eval.storePathFunction,
+		"addContext":        eval.addContextFunction,
+		"assertMsg":         assertMsgFunction,
+		"await":             awaitFunction,
+		"derivation":        eval.derivationFunction,
+		"discardContext":    discardContextFunction,
+		"getContext":        getContextFunction,
+		"import":            eval.importFunction,
+		"lazy":              lazyFunction,
+		"outputPlaceholder": outputPlaceholderFunction,
+		"placeholder":       placeholderFunction,
+		"toFile":            eval.toFileFunction,
+		"path":              eval.pathFunction,
+		"readFile":          eval.readFileFunction,
+		"sourceTree":        eval.sourceTreeFunction,
+		"storePath":         eval.storePathFunction,
+		"throw":             throwFunction,
 	}
 	if err := lua.SetPureFunctions(ctx, l, 0, extraBaseFunctions); err != nil {
 		return err
@@ -259,6 +383,10 @@ func (eval *Eval) initZygote() error {
 		return err
 	}
 	l.Pop(1)
+	if err := lua.Require(ctx, l, "zb", true, eval.openZB); err != nil {
+		return err
+	}
+	l.Pop(1)
 
 	// Run prelude.
 	if err := l.Load(bytes.NewReader(preludeSource), lua.UnknownSource, "b"); err != nil {
@@ -274,6 +402,17 @@ func (eval *Eval) initZygote() error {
 		return err
 	}
 
+	// Create the metatable for each state's globals table.
+	// It is frozen along with the rest of the registry
+	// so that every state can share it instead of building its own.
+	lua.NewPureLib(l, map[string]lua.Function{
+		"__metatable": nil,
+		"__index":     globalsIndex,
+	})
+	if err := l.RawSetField(lua.RegistryIndex, globalsMetatableRegistryKey); err != nil {
+		return err
+	}
+
 	// Freeze everything in registry and metatables.
 	if err := l.Freeze(lua.RegistryIndex); err != nil {
 		return err
@@ -312,7 +451,9 @@ func (eval *Eval) openOS(ctx context.Context, l *lua.State) (int, error) {
 			if err != nil {
 				return 0, err
 			}
-			if val, ok := eval.lookupEnv(ctx, key); ok {
+			val, ok := eval.lookupEnv(ctx, key)
+			moduleDepsFromContext(ctx).addEnv(key, EnvLookup{Value: val, Found: ok})
+			if ok {
 				l.PushString(val)
 			} else {
 				l.PushNil()
@@ -398,43 +539,79 @@ func (eval *Eval) initState(l *lua.State) error {
 	l.Pop(1) // Pop the zygote registry.
 
 	// Set up globals metatable.
-	// Any unknown names will be looked up in the standard library registry key.
-	// We don't set it to the standard library table directly
-	// because if this value gets moved to a different state,
-	// we want to respect the state's registry key.
 	l.RawIndex(lua.RegistryIndex, lua.RegistryIndexGlobals)
-	lua.NewPureLib(l, map[string]lua.Function{
-		"__metatable": nil,
-		"__index": func(ctx context.Context, l *lua.State) (int, error) {
-			if l.Type(2) == lua.TypeString {
-				if s, _ := l.ToString(2); s == lua.GName {
-					l.SetTop(1)
-					return 1, nil
-				}
-			}
-			if tp, err := l.Field(ctx, lua.RegistryIndex, stdlibRegistryKey); err != nil {
-				return 0, err
-			} else if tp == lua.TypeNil {
-				// If the state does not have a standard library in the registry (bug?),
-				// then return nothing.
-				return 1, nil
-			}
-			l.Insert(2)
-			l.SetTop(3)
-			if _, err := l.Table(ctx, 2); err != nil {
-				return 0, err
-			}
-			return 1, nil
-		},
-	})
+	if tp := l.RawField(lua.RegistryIndex, globalsMetatableRegistryKey); tp != lua.TypeTable {
+		l.Pop(2)
+		return fmt.Errorf("internal error: globals metatable is a %v", tp)
+	}
 	if err := l.SetMetatable(-2); err != nil {
 		return err
 	}
 	l.Pop(1)
 
+	l.SetHook(eval.evalHook())
+	if eval.stats != nil {
+		eval.stats.states.Add(1)
+		l.SetAllocStats(&eval.stats.alloc)
+	}
+
 	return nil
 }
 
+// globalsIndex is the __index metamethod for each state's globals table.
+// Any unknown names will be looked up in the standard library registry key.
+// We don't set the __index field to the standard library table directly
+// because if this value gets moved to a different state,
+// we want to respect the state's registry key.
+func globalsIndex(ctx context.Context, l *lua.State) (int, error) {
+	if l.Type(2) == lua.TypeString {
+		if s, _ := l.ToString(2); s == lua.GName {
+			l.SetTop(1)
+			return 1, nil
+		}
+	}
+	if tp, err := l.Field(ctx, lua.RegistryIndex, stdlibRegistryKey); err != nil {
+		return 0, err
+	} else if tp == lua.TypeNil {
+		// If the state does not have a standard library in the registry (bug?),
+		// then return nothing.
+		return 1, nil
+	}
+	l.Insert(2)
+	l.SetTop(3)
+	if _, err := l.Table(ctx, 2); err != nil {
+		return 0, err
+	}
+	return 1, nil
+}
+
+// evalHook returns the arguments to [*lua.State.SetHook] for a new Lua state.
+// If eval is neither profiling nor recording coverage,
+// then evalHook returns a nil hook.
+func (eval *Eval) evalHook() (lua.Hook, lua.HookMask, int) {
+	var profileHook lua.Hook
+	var mask lua.HookMask
+	if eval.profiler != nil {
+		profileHook = eval.profiler.hook()
+		mask |= lua.MaskCount
+	}
+	if eval.coverage != nil {
+		mask |= lua.MaskLine
+	}
+	if mask == 0 {
+		return nil, 0, 0
+	}
+	hook := func(ctx context.Context, l *lua.State, event lua.HookMask) {
+		switch event {
+		case lua.MaskCount:
+			profileHook(ctx, l, event)
+		case lua.MaskLine:
+			eval.coverage.record(l)
+		}
+	}
+	return hook, mask, profileSampleInterval
+}
+
 func prepareCache(conn *sqlite.Conn) error {
 	if err := sqlitex.ExecuteTransient(conn, "PRAGMA journal_mode=wal;", nil); err != nil {
 		return fmt.Errorf("enable write-ahead logging: %v", err)
@@ -473,9 +650,17 @@ func prepareCache(conn *sqlite.Conn) error {
 	return nil
 }
 
+// Close stops any in-progress imports,
+// saves the values of imported modules to the cache database
+// if [Options.CacheDBPath] was set,
+// and releases resources associated with eval.
+// Close must not be called concurrently with other methods on eval.
 func (eval *Eval) Close() error {
 	eval.cancelImports()
 	eval.importGroup.Wait()
+	if eval.moduleCache {
+		eval.saveModules()
+	}
 	return eval.cachePool.Close()
 }
 
@@ -630,21 +815,35 @@ func luaTableToGoSlice(ctx context.Context, l *lua.State) ([]any, error) {
 }
 
 func loadFile(l *lua.State, path string) error {
+	_, err := loadFileHash(l, path)
+	return err
+}
+
+// loadFileHash is like [loadFile],
+// but also returns the SHA-256 hash of the file's content.
+func loadFileHash(l *lua.State, path string) (fileHash, error) {
 	path, err := filepath.Abs(path)
 	if err != nil {
-		return fmt.Errorf("load file: %w", err)
+		return fileHash{}, fmt.Errorf("load file: %w", err)
 	}
 	// TODO(#44): Use store to open file if pathInStore(path, dir).
 	f, err := os.Open(path)
 	if err != nil {
-		return fmt.Errorf("load file: %w", err)
+		return fileHash{}, fmt.Errorf("load file: %w", err)
 	}
 	defer f.Close()
 
-	if err := l.Load(bufio.NewReader(f), lua.FilenameSource(path), "t"); err != nil {
-		return fmt.Errorf("load file %s: %w", path, err)
+	h := sha256.New()
+	if err := l.Load(bufio.NewReader(io.TeeReader(f, h)), lua.FilenameSource(path), "t"); err != nil {
+		return fileHash{}, fmt.Errorf("load file %s: %w", path, err)
 	}
-	return nil
+	// The parser may stop reading before the end of the file.
+	if _, err := io.Copy(h, f); err != nil {
+		return fileHash{}, fmt.Errorf("load file %s: %w", path, err)
+	}
+	var sum fileHash
+	h.Sum(sum[:0])
+	return sum, nil
 }
 
 func loadExpression(l *lua.State, expr string) error {
@@ -4,11 +4,13 @@
 package frontend
 
 import (
+	"bytes"
 	"context"
 	"io"
 	"os"
 	"path/filepath"
 	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"testing"
@@ -234,6 +236,376 @@ func TestStringMethod(t *testing.T) {
 	}
 }
 
+func TestZBLibrary(t *testing.T) {
+	if !slices.IsSorted(features) {
+		t.Errorf("features = %q; want sorted", features)
+	}
+
+	tests := []struct {
+		name    string
+		version string
+		expr    string
+		want    any
+	}{
+		{name: "HasFeature", expr: `zb.hasFeature("lazy")`, want: true},
+		{name: "MissingFeature", expr: `zb.hasFeature("teleport")`, want: false},
+		{name: "UnknownVersion", expr: `zb.version == nil`, want: true},
+		{name: "VersionString", version: "1.2.3", expr: `zb.version.string`, want: "1.2.3"},
+		{name: "VersionMajor", version: "1.2.3", expr: `zb.version.major`, want: int64(1)},
+		{name: "VersionPatch", version: "1.2.3-rc.1", expr: `zb.version.patch`, want: int64(3)},
+		{name: "Prerelease", version: "1.2.3-rc.1", expr: `zb.version.prerelease`, want: "rc.1"},
+		{name: "Release", version: "1.2.3", expr: `zb.version.prerelease == nil`, want: true},
+		{name: "Unparsable", version: "devel", expr: `zb.version.major == nil`, want: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := testcontext.New(t)
+			// The zb library does not use the store.
+			eval, err := NewEval(&Options{
+				StoreDirectory: backendtest.NewStoreDirectory(t),
+				Version:        test.version,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				if err := eval.Close(); err != nil {
+					t.Error("eval.Close:", err)
+				}
+			}()
+
+			got, err := eval.Expression(ctx, test.expr)
+			if err != nil {
+				t.Fatalf("%s: %v", test.expr, err)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("%s (-want +got):\n%s", test.expr, diff)
+			}
+		})
+	}
+}
+
+func TestWarn(t *testing.T) {
+	ctx := testcontext.New(t)
+	var got []*Warning
+	// The zb library does not use the store.
+	eval, err := NewEval(&Options{
+		StoreDirectory: backendtest.NewStoreDirectory(t),
+		Warn: func(ctx context.Context, w *Warning) {
+			got = append(got, w)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := eval.Close(); err != nil {
+			t.Error("eval.Close:", err)
+		}
+	}()
+
+	const expr = `(function()
+		zb.warn("foo is deprecated")
+		zb.warn("foo is deprecated")
+		zb.warn("bar is deprecated; use baz", "bar")
+		zb.warn("bar is still deprecated", "bar")
+		return 42
+	end)()`
+	result, err := eval.Expression(ctx, expr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := int64(42); result != want {
+		t.Errorf("result = %v; want %d", result, want)
+	}
+	want := []*Warning{
+		{Message: "foo is deprecated", Key: "foo is deprecated"},
+		{Message: "bar is deprecated; use baz", Key: "bar"},
+	}
+	if diff := cmp.Diff(want, got, cmpopts.IgnoreFields(Warning{}, "Position")); diff != "" {
+		t.Errorf("warnings (-want +got):\n%s", diff)
+	}
+	for _, w := range got {
+		if w.Position == "" {
+			t.Errorf("warning %q has empty position", w.Message)
+		}
+	}
+}
+
+func TestZBOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		expr    string
+		want    any
+		wantErr string
+	}{
+		{
+			name: "Default",
+			expr: `zb.options({jobs = {type = "integer", default = 1}}).jobs`,
+			want: int64(1),
+		},
+		{
+			name: "Override",
+			expr: `zb.options({jobs = {type = "integer", default = 1}}, {jobs = 4}).jobs`,
+			want: int64(4),
+		},
+		{
+			name: "LaterWins",
+			expr: `zb.options({name = {type = "string"}}, {name = "a"}, nil, {name = "b"}).name`,
+			want: "b",
+		},
+		{
+			name: "MergeTables",
+			expr: `(function()
+				local opts = zb.options(
+					{env = {type = "table", default = {}}},
+					{env = {A = "1", B = "2"}},
+					{env = {B = "3"}}
+				)
+				return opts.env.A .. opts.env.B
+			end)()`,
+			want: "13",
+		},
+		{
+			name: "Optional",
+			expr: `zb.options({x = {type = "string", optional = true}}).x == nil`,
+			want: true,
+		},
+		{
+			name: "AnyType",
+			expr: `zb.options({x = {}}, {x = 3.5}).x`,
+			want: 3.5,
+		},
+		{
+			name:    "UnknownOption",
+			expr:    `zb.options({jobs = {type = "integer"}}, {job = 4})`,
+			wantErr: `unknown option "job"`,
+		},
+		{
+			name:    "WrongType",
+			expr:    `zb.options({jobs = {type = "integer"}}, {jobs = "4"})`,
+			wantErr: `option "jobs": integer expected, got string`,
+		},
+		{
+			name:    "FloatForInteger",
+			expr:    `zb.options({jobs = {type = "integer"}}, {jobs = 1.5})`,
+			wantErr: `option "jobs": integer expected, got number`,
+		},
+		{
+			name:    "Missing",
+			expr:    `zb.options({src = {type = "string", description = "source directory"}})`,
+			wantErr: `missing required option "src" (source directory)`,
+		},
+		{
+			name:    "BadDefault",
+			expr:    `zb.options({jobs = {type = "integer", default = "many"}})`,
+			wantErr: `option "jobs": default: integer expected, got string`,
+		},
+		{
+			name:    "UnknownType",
+			expr:    `zb.options({jobs = {type = "int"}})`,
+			wantErr: `option "jobs": unknown type "int"`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := testcontext.New(t)
+			// The zb library does not use the store.
+			eval, err := NewEval(&Options{
+				StoreDirectory: backendtest.NewStoreDirectory(t),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				if err := eval.Close(); err != nil {
+					t.Error("eval.Close:", err)
+				}
+			}()
+
+			got, err := eval.Expression(ctx, test.expr)
+			if test.wantErr != "" {
+				if err == nil {
+					t.Fatalf("%s = %v; want error containing %q", test.expr, got, test.wantErr)
+				}
+				if !strings.Contains(err.Error(), test.wantErr) {
+					t.Errorf("%s: %v; want error containing %q", test.expr, err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("%s: %v", test.expr, err)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("%s (-want +got):\n%s", test.expr, diff)
+			}
+		})
+	}
+}
+
+func TestAssertMsg(t *testing.T) {
+	tests := []struct {
+		name    string
+		expr    string
+		want    any
+		wantErr string
+	}{
+		{
+			name: "True",
+			expr: `assertMsg(42, "unused")`,
+			want: int64(42),
+		},
+		{
+			name:    "False",
+			expr:    `assertMsg(false, "boom")`,
+			wantErr: ":1: assertion failed: boom",
+		},
+		{
+			name: "Nested",
+			expr: `(function()
+				local function check(x)
+					assertMsg(x > 0, "x must be positive")
+				end
+				check(-1)
+			end)()`,
+			wantErr: ":3: assertion failed: x must be positive",
+		},
+		{
+			name:    "MissingMessage",
+			expr:    `assertMsg(true)`,
+			wantErr: "bad argument #2",
+		},
+		{
+			name:    "Throw",
+			expr:    `throw("not supported on this system")`,
+			wantErr: ":1: not supported on this system",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := testcontext.New(t)
+			// These built-ins do not use the store.
+			eval, err := NewEval(&Options{
+				StoreDirectory: backendtest.NewStoreDirectory(t),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				if err := eval.Close(); err != nil {
+					t.Error("eval.Close:", err)
+				}
+			}()
+
+			got, err := eval.Expression(ctx, test.expr)
+			if test.wantErr != "" {
+				if err == nil {
+					t.Fatalf("%s = %v; want error containing %q", test.expr, got, test.wantErr)
+				}
+				if !strings.Contains(err.Error(), test.wantErr) {
+					t.Errorf("%s: %v; want error containing %q", test.expr, err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("%s: %v", test.expr, err)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("%s (-want +got):\n%s", test.expr, diff)
+			}
+		})
+	}
+}
+
+func TestDerivationArgumentErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		expr    string
+		wantErr string
+	}{
+		{
+			name:    "MissingSystem",
+			expr:    `derivation { name = "x"; builder = "/bin/sh" }`,
+			wantErr: ":1: system argument: missing",
+		},
+		{
+			name:    "BadSystem",
+			expr:    `derivation { name = "x"; system = "x86_64"; builder = "/bin/sh" }`,
+			wantErr: ":1: system argument: ",
+		},
+		{
+			name:    "MissingBuilder",
+			expr:    `derivation { name = "x"; system = "x86_64-linux" }`,
+			wantErr: ":1: builder argument: missing",
+		},
+		{
+			name:    "RelativeBuilder",
+			expr:    `derivation { name = "x"; system = "x86_64-linux"; builder = "bin/sh" }`,
+			wantErr: ":1: builder argument: \"bin/sh\" is not an absolute path",
+		},
+		{
+			name:    "UnixBuilderOnWindows",
+			expr:    `derivation { name = "x"; system = "x86_64-windows"; builder = "/bin/sh" }`,
+			wantErr: ":1: builder argument: ",
+		},
+		{
+			name:    "BuiltinSystem",
+			expr:    `derivation { name = "x"; system = "builtin"; builder = "/bin/sh" }`,
+			wantErr: ":1: builder argument: \"/bin/sh\" does not name a builtin",
+		},
+		{
+			name:    "BuiltinBuilder",
+			expr:    `derivation { name = "x"; system = "x86_64-linux"; builder = "builtin:fetchurl" }`,
+			wantErr: ":1: builder argument: builtin \"builtin:fetchurl\" requires system",
+		},
+		{
+			name:    "BuilderType",
+			expr:    `derivation { name = "x"; system = "x86_64-linux"; builder = {} }`,
+			wantErr: ":1: builder argument: string expected, got table",
+		},
+		{
+			name:    "BadOutputName",
+			expr:    `derivation { name = "x"; system = "x86_64-linux"; builder = "/bin/sh"; outputs = { "a/b" } }`,
+			wantErr: ":1: outputs argument: #1: invalid output name",
+		},
+		{
+			name: "Nested",
+			expr: `(function()
+				local function mk(sys)
+					return derivation { name = "x"; system = sys; builder = "/bin/sh" }
+				end
+				return mk("bogus")
+			end)()`,
+			wantErr: ":3: system argument: ",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := testcontext.New(t)
+			// Validation happens before the derivation is written to the store.
+			eval, err := NewEval(&Options{
+				StoreDirectory: backendtest.NewStoreDirectory(t),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				if err := eval.Close(); err != nil {
+					t.Error("eval.Close:", err)
+				}
+			}()
+
+			got, err := eval.Expression(ctx, test.expr)
+			if err == nil {
+				t.Fatalf("%s = %v; want error containing %q", test.expr, got, test.wantErr)
+			}
+			if !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("%s: %v; want error containing %q", test.expr, err, test.wantErr)
+			}
+		})
+	}
+}
+
 func TestImportFromDerivation(t *testing.T) {
 	ctx := testcontext.New(t)
 	storeDir := backendtest.NewStoreDirectory(t)
@@ -276,6 +648,321 @@ func TestImportFromDerivation(t *testing.T) {
 	}
 }
 
+func TestDerivationPosition(t *testing.T) {
+	ctx := testcontext.New(t)
+	storeDir := backendtest.NewStoreDirectory(t)
+
+	di := new(zbstorerpc.DeferredImporter)
+	_, store, err := backendtest.NewServer(ctx, t, storeDir, &backendtest.Options{
+		TempDir: t.TempDir(),
+		ClientOptions: zbstorerpc.CodecOptions{
+			Importer: di,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	eval, err := NewEval(&Options{
+		Store:          newTestRPCStore(store, di),
+		StoreDirectory: storeDir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := eval.Close(); err != nil {
+			t.Error("eval.Close:", err)
+		}
+	}()
+
+	source, err := filepath.Abs(filepath.Join("testdata", "packages.lua"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := eval.URLs(ctx, []string{source + "#hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	drv, _ := results[0].(*Derivation)
+	if drv == nil {
+		t.Fatalf("result = %#v; want derivation", results[0])
+	}
+	want := Position{Filename: source, Line: 5}
+	if drv.Position != want {
+		t.Errorf("drv.Position = %v; want %v", drv.Position, want)
+	}
+
+	result, err := eval.Expression(ctx, `derivation { name = "x"; system = "x86_64-linux"; builder = "/bin/sh" }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if drv := result.(*Derivation); drv.Position.IsValid() {
+		t.Errorf("Position for derivation from expression = %v; want invalid", drv.Position)
+	}
+}
+
+func TestReportDerivation(t *testing.T) {
+	ctx := testcontext.New(t)
+	storeDir := backendtest.NewStoreDirectory(t)
+
+	di := new(zbstorerpc.DeferredImporter)
+	_, store, err := backendtest.NewServer(ctx, t, storeDir, &backendtest.Options{
+		TempDir: t.TempDir(),
+		ClientOptions: zbstorerpc.CodecOptions{
+			Importer: di,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	var reported []zbstore.Path
+	eval, err := NewEval(&Options{
+		Store:          newTestRPCStore(store, di),
+		StoreDirectory: storeDir,
+		ReportDerivation: func(ctx context.Context, drvPath zbstore.Path) {
+			mu.Lock()
+			reported = append(reported, drvPath)
+			mu.Unlock()
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := eval.Close(); err != nil {
+			t.Error("eval.Close:", err)
+		}
+	}()
+
+	result, err := eval.Expression(ctx, `derivation { name = "x"; system = "x86_64-linux"; builder = "/bin/sh" }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	drv := result.(*Derivation)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []zbstore.Path{drv.Path}; !slices.Equal(reported, want) {
+		t.Errorf("reported derivations = %v; want %v", reported, want)
+	}
+}
+
+func TestReplayStore(t *testing.T) {
+	ctx := testcontext.New(t)
+	storeDir := backendtest.NewStoreDirectory(t)
+	const expr = `derivation { name = "x"; system = "x86_64-linux"; builder = "/bin/sh"; src = toFile("hello.txt", "Hello, World!\n") }`
+
+	di := new(zbstorerpc.DeferredImporter)
+	_, client, err := backendtest.NewServer(ctx, t, storeDir, &backendtest.Options{
+		TempDir: t.TempDir(),
+		ClientOptions: zbstorerpc.CodecOptions{
+			Importer: di,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	recording := new(bytes.Buffer)
+	recorder := zbstorerpc.NewRecorder(client, recording)
+	recordStore := &testRPCStore{Store: zbstorerpc.Store{Handler: recorder}}
+	di.SetImporter(recordStore)
+	want := evalExpression(ctx, t, recordStore, storeDir, expr)
+	if err := recorder.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(recording.Bytes(), []byte(zbstorerpc.InfoMethod)) {
+		t.Errorf("recording does not contain any %s requests:\n%s", zbstorerpc.InfoMethod, recording)
+	}
+
+	replayer, err := zbstorerpc.NewReplayer(recording)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := evalExpression(ctx, t, &testRPCStore{Store: zbstorerpc.Store{Handler: replayer}}, storeDir, expr)
+	if got.(*Derivation).Path != want.(*Derivation).Path {
+		t.Errorf("replayed derivation = %s; want %s", got.(*Derivation).Path, want.(*Derivation).Path)
+	}
+}
+
+// evalExpression evaluates expr with a new [Eval] that uses the given store.
+func evalExpression(ctx context.Context, tb testing.TB, store Store, storeDir zbstore.Directory, expr string) any {
+	tb.Helper()
+	eval, err := NewEval(&Options{
+		Store:          store,
+		StoreDirectory: storeDir,
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer func() {
+		if err := eval.Close(); err != nil {
+			tb.Error("eval.Close:", err)
+		}
+	}()
+	result, err := eval.Expression(ctx, expr)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return result
+}
+
+func TestPlaceholder(t *testing.T) {
+	ctx := testcontext.New(t)
+	storeDir := backendtest.NewStoreDirectory(t)
+
+	di := new(zbstorerpc.DeferredImporter)
+	_, store, err := backendtest.NewServer(ctx, t, storeDir, &backendtest.Options{
+		TempDir: t.TempDir(),
+		ClientOptions: zbstorerpc.CodecOptions{
+			Importer: di,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	eval, err := NewEval(&Options{
+		Store:          newTestRPCStore(store, di),
+		StoreDirectory: storeDir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := eval.Close(); err != nil {
+			t.Error("eval.Close:", err)
+		}
+	}()
+
+	const depExpr = `derivation { name = "dep"; system = "x86_64-linux"; builder = "/bin/sh" }`
+	result, err := eval.Expression(ctx, depExpr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dep := result.(*Derivation)
+
+	result, err = eval.Expression(ctx, `derivation {
+		name = "x";
+		system = "x86_64-linux";
+		builder = "/bin/sh";
+		args = { "-c", "echo " .. placeholder() };
+		dep = outputPlaceholder(`+depExpr+`);
+		bin = placeholder("bin");
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	drv := result.(*Derivation)
+	depRef := zbstore.OutputReference{
+		DrvPath:    dep.Path,
+		OutputName: zbstore.DefaultDerivationOutputName,
+	}
+	if got, want := drv.Env["dep"], zbstore.UnknownCAOutputPlaceholder(depRef); got != want {
+		t.Errorf("dep = %q; want %q", got, want)
+	}
+	if got, want := drv.Env["bin"], zbstore.HashPlaceholder("bin"); got != want {
+		t.Errorf("bin = %q; want %q", got, want)
+	}
+	if got, want := drv.Args, []string{"-c", "echo " + zbstore.HashPlaceholder("out")}; !slices.Equal(got, want) {
+		t.Errorf("args = %q; want %q", got, want)
+	}
+	if outputs := drv.InputDerivations[dep.Path]; outputs == nil || !outputs.Has(zbstore.DefaultDerivationOutputName) {
+		t.Errorf("input derivations = %v; want to include %v", drv.InputDerivations, depRef)
+	}
+
+	if _, err := eval.Expression(ctx, `outputPlaceholder(`+depExpr+`, "bin")`); err == nil {
+		t.Error("outputPlaceholder with missing output did not return an error")
+	}
+}
+
+func TestMultipleOutputs(t *testing.T) {
+	ctx := testcontext.New(t)
+	storeDir := backendtest.NewStoreDirectory(t)
+
+	di := new(zbstorerpc.DeferredImporter)
+	_, store, err := backendtest.NewServer(ctx, t, storeDir, &backendtest.Options{
+		TempDir: t.TempDir(),
+		ClientOptions: zbstorerpc.CodecOptions{
+			Importer: di,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	eval, err := NewEval(&Options{
+		Store:          newTestRPCStore(store, di),
+		StoreDirectory: storeDir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := eval.Close(); err != nil {
+			t.Error("eval.Close:", err)
+		}
+	}()
+
+	const drvExpr = `derivation { name = "x"; system = "x86_64-linux"; builder = "/bin/sh"; outputs = { "bin", "dev" } }`
+	result, err := eval.Expression(ctx, drvExpr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	drv := result.(*Derivation)
+	if got, want := drv.OutputNames(), []string{"bin", "dev"}; !slices.Equal(got, want) {
+		t.Errorf("drv.OutputNames() = %q; want %q", got, want)
+	}
+	if got, want := drv.DefaultOutputName(), "bin"; got != want {
+		t.Errorf("drv.DefaultOutputName() = %q; want %q", got, want)
+	}
+	for _, outputName := range []string{"bin", "dev"} {
+		if !drv.Outputs[outputName].IsFloating() {
+			t.Errorf("drv.Outputs[%q] = %v; want floating", outputName, drv.Outputs[outputName])
+		}
+		if got, want := drv.Env[outputName], zbstore.HashPlaceholder(outputName); got != want {
+			t.Errorf("drv.Env[%q] = %q; want %q", outputName, got, want)
+		}
+	}
+	if got, want := drv.Env["outputs"], "bin dev"; got != want {
+		t.Errorf("drv.Env[\"outputs\"] = %q; want %q", got, want)
+	}
+
+	placeholder := func(outputName string) string {
+		return zbstore.UnknownCAOutputPlaceholder(zbstore.OutputReference{
+			DrvPath:    drv.Path,
+			OutputName: outputName,
+		})
+	}
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{expr: `tostring(` + drvExpr + `)`, want: placeholder("bin")},
+		{expr: `(` + drvExpr + `) .. ""`, want: placeholder("bin")},
+		{expr: `(` + drvExpr + `).dev`, want: placeholder("dev")},
+	}
+	for _, test := range tests {
+		got, err := eval.Expression(ctx, test.expr)
+		if err != nil {
+			t.Errorf("%s: %v", test.expr, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%s = %#v; want %#v", test.expr, got, test.want)
+		}
+	}
+
+	badExprs := []string{
+		`derivation { name = "x"; system = "x86_64-linux"; builder = "/bin/sh"; outputs = {} }`,
+		`derivation { name = "x"; system = "x86_64-linux"; builder = "/bin/sh"; outputs = { "out", "out" } }`,
+		`derivation { name = "x"; system = "x86_64-linux"; builder = "/bin/sh"; outputs = { "out", "dev" }; outputHash = "sha256-47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=" }`,
+	}
+	for _, expr := range badExprs {
+		if _, err := eval.Expression(ctx, expr); err == nil {
+			t.Errorf("%s did not return an error", expr)
+		}
+	}
+}
+
 func TestImportExitStore(t *testing.T) {
 	ctx := testcontext.New(t)
 	storeDir := backendtest.NewStoreDirectory(t)
@@ -549,6 +1236,84 @@ func TestNewState(t *testing.T) {
 	}
 }
 
+func TestConcurrentEval(t *testing.T) {
+	ctx := testcontext.New(t)
+	storeDir := backendtest.NewStoreDirectory(t)
+
+	di := new(zbstorerpc.DeferredImporter)
+	_, store, err := backendtest.NewServer(ctx, t, storeDir, &backendtest.Options{
+		TempDir: t.TempDir(),
+		ClientOptions: zbstorerpc.CodecOptions{
+			Importer: di,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	eval, err := NewEval(&Options{
+		Store:          newTestRPCStore(store, di),
+		StoreDirectory: storeDir,
+		CacheDBPath:    filepath.Join(t.TempDir(), "cache.db"),
+		Warn:           func(ctx context.Context, w *Warning) {},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := eval.Close(); err != nil {
+			t.Error("eval.Close:", err)
+		}
+	}()
+
+	source, err := filepath.Abs(filepath.Join("testdata", "packages.lua"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const n = 8
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Go(func() {
+			results, err := eval.URLs(ctx, []string{source + "#hello", source + "#answer"})
+			if err != nil {
+				t.Errorf("URLs #%d: %v", i, err)
+				return
+			}
+			if drv, ok := results[0].(*Derivation); !ok || drv.Name != "hello-1.0" {
+				t.Errorf("URLs #%d: hello = %v; want hello-1.0 derivation", i, results[0])
+			}
+			if got, want := results[1], int64(42); got != want {
+				t.Errorf("URLs #%d: answer = %v; want %d", i, got, want)
+			}
+		})
+		wg.Go(func() {
+			expr := "(function()\n" +
+				"  zb.warn(\"concurrent\", \"hello\")\n" +
+				"  local f = toFile(\"greeting.txt\", \"Hello #" + strconv.Itoa(i%2) + "\")\n" +
+				"  return import(" + lualex.Quote(source) + ").tools.goodbye.name .. \" \" .. tostring(f):sub(1, 1)\n" +
+				"end)()"
+			got, err := eval.Expression(ctx, expr)
+			if err != nil {
+				t.Errorf("Expression #%d: %v", i, err)
+				return
+			}
+			if want := "goodbye-2.1 /"; got != want {
+				t.Errorf("Expression #%d = %v; want %q", i, got, want)
+			}
+		})
+		wg.Go(func() {
+			pkgs, err := eval.Packages(ctx, source, nil)
+			if err != nil {
+				t.Errorf("Packages #%d: %v", i, err)
+				return
+			}
+			if got, want := len(pkgs), 2; got != want {
+				t.Errorf("Packages #%d returned %d packages; want %d", i, got, want)
+			}
+		})
+	}
+	wg.Wait()
+}
+
 // BenchmarkNewState measures the performance of spinning up a new interpreter.
 func BenchmarkNewState(b *testing.B) {
 	ctx := testcontext.New(b)
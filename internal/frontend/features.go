@@ -0,0 +1,196 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package frontend
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"strings"
+
+	"zb.256lights.llc/pkg/internal/lua"
+)
+
+// features is the sorted list of names that zb.hasFeature reports as supported.
+// New built-ins and changes in built-in behavior should add a name here
+// so that Lua code can adapt to older versions of zb.
+var features = []string{
+	"addContext",
+	"applyPatches",
+	"assertMsg",
+	"await",
+	"composeTree",
+	"derivation",
+	"derivation.outputs",
+	"discardContext",
+	"extract",
+	"fetchArchive",
+	"fetchurl",
+	"getContext",
+	"import",
+	"lazy",
+	"linkFarm",
+	"options",
+	"os.getenv",
+	"outputPlaceholder",
+	"path",
+	"placeholder",
+	"readFile",
+	"sourceTree",
+	"storeDir",
+	"storePath",
+	"substitute",
+	"symlinkJoin",
+	"system",
+	"throw",
+	"toFile",
+	"warn",
+}
+
+// Features returns the sorted list of feature names
+// that Lua code can detect with zb.hasFeature.
+func Features() []string {
+	return slices.Clone(features)
+}
+
+// openZB is a [lua.Function] that pushes the zb library table.
+func (eval *Eval) openZB(ctx context.Context, l *lua.State) (int, error) {
+	lua.NewPureLib(l, map[string]lua.Function{
+		"hasFeature": hasFeatureFunction,
+		"options":    optionsFunction,
+		"warn":       eval.warnFunction,
+	})
+	if eval.version != "" {
+		if err := pushVersionTable(l, eval.version); err != nil {
+			return 0, err
+		}
+		if err := l.RawSetField(-2, "version"); err != nil {
+			return 0, err
+		}
+	}
+	if err := eval.pushSystemTable(l); err != nil {
+		return 0, err
+	}
+	if err := l.RawSetField(-2, "system"); err != nil {
+		return 0, err
+	}
+	return 1, nil
+}
+
+// pushVersionTable pushes a table describing the given version string
+// (e.g. "1.2.3" or "1.2.3-rc.1") onto the stack.
+// The table always has a "string" field with the full version.
+// The "major", "minor", and "patch" integer fields
+// and the "prerelease" string field
+// are only set if the version string could be parsed.
+func pushVersionTable(l *lua.State, version string) error {
+	l.CreateTable(0, 5)
+	l.PushString(version)
+	if err := l.RawSetField(-2, "string"); err != nil {
+		return err
+	}
+
+	core, prerelease, _ := strings.Cut(strings.TrimPrefix(version, "v"), "-")
+	core, _, _ = strings.Cut(core, "+")
+	prerelease, _, _ = strings.Cut(prerelease, "+")
+	parts := strings.Split(core, ".")
+	if len(parts) != 3 {
+		return nil
+	}
+	var nums [3]int64
+	for i, p := range parts {
+		n, err := strconv.ParseInt(p, 10, 64)
+		if err != nil || n < 0 {
+			return nil
+		}
+		nums[i] = n
+	}
+	for i, k := range []string{"major", "minor", "patch"} {
+		l.PushInteger(nums[i])
+		if err := l.RawSetField(-2, k); err != nil {
+			return err
+		}
+	}
+	if prerelease != "" {
+		l.PushString(prerelease)
+		if err := l.RawSetField(-2, "prerelease"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hasFeatureFunction is the implementation of zb.hasFeature.
+func hasFeatureFunction(ctx context.Context, l *lua.State) (int, error) {
+	name, err := lua.CheckString(l, 1)
+	if err != nil {
+		return 0, err
+	}
+	_, found := slices.BinarySearch(features, name)
+	l.PushBoolean(found)
+	return 1, nil
+}
+
+// A Warning is a message emitted by Lua code with zb.warn
+// or by the evaluator about questionable Lua code.
+type Warning struct {
+	// Message is the human-readable warning message.
+	Message string
+	// Key identifies the warning for deduplication.
+	// If zb.warn was not given a key, then Key is the same as Message.
+	Key string
+	// Position is the location of the zb.warn call
+	// (or the code that caused the warning)
+	// in the form "chunkname:line".
+	// It is empty if the position is not known.
+	Position string
+}
+
+// String returns the warning message prefixed by its position (if known).
+func (w *Warning) String() string {
+	if w.Position == "" {
+		return w.Message
+	}
+	return w.Position + ": " + w.Message
+}
+
+// warnFunction is the implementation of zb.warn.
+func (eval *Eval) warnFunction(ctx context.Context, l *lua.State) (int, error) {
+	msg, err := lua.CheckString(l, 1)
+	if err != nil {
+		return 0, err
+	}
+	key := msg
+	if !l.IsNoneOrNil(2) {
+		key, err = lua.CheckString(l, 2)
+		if err != nil {
+			return 0, err
+		}
+	}
+	eval.emitWarning(ctx, &Warning{
+		Message:  msg,
+		Key:      key,
+		Position: strings.TrimSuffix(lua.Where(l, 1), ": "),
+	})
+	return 0, nil
+}
+
+// emitWarning reports w to the [Options.Warn] callback
+// unless a warning with the same key has already been reported.
+func (eval *Eval) emitWarning(ctx context.Context, w *Warning) {
+	// Warnings would not be reported for cached modules.
+	moduleDepsFromContext(ctx).markImpure()
+	if eval.warn == nil {
+		return
+	}
+
+	eval.warnedMutex.Lock()
+	dup := eval.warned.Has(w.Key)
+	eval.warned.Add(w.Key)
+	eval.warnedMutex.Unlock()
+	if dup {
+		return
+	}
+	eval.warn(ctx, w)
+}
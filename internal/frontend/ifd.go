@@ -0,0 +1,203 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package frontend
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+	"zb.256lights.llc/pkg/sets"
+	"zb.256lights.llc/pkg/zbstore"
+)
+
+// An ImportBuild describes a build that evaluation started
+// because Lua code read the output of a derivation
+// (e.g. by passing it to import or readFile),
+// commonly called "import from derivation".
+type ImportBuild struct {
+	// Outputs is the set of derivation outputs that the build realizes,
+	// sorted by derivation path and output name.
+	Outputs []zbstore.OutputReference
+	// Position is the location in a Lua file
+	// of the code that requested the outputs.
+	// It is the zero value if the request did not come from a file.
+	Position Position
+}
+
+// String returns a description of the build prefixed by its position (if known).
+func (b *ImportBuild) String() string {
+	sb := new(strings.Builder)
+	if b.Position.IsValid() {
+		sb.WriteString(b.Position.String())
+		sb.WriteString(": ")
+	}
+	sb.WriteString("building ")
+	for i, ref := range b.Outputs {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(ref.String())
+	}
+	sb.WriteString(" for evaluation")
+	return sb.String()
+}
+
+// importRealization is the result of realizing a derivation output
+// during evaluation.
+type importRealization struct {
+	// done is closed once path and err are set.
+	done chan struct{}
+	path zbstore.Path
+	err  error
+}
+
+// outputPlaceholders returns the derivation outputs
+// whose placeholders appear in s,
+// keyed by placeholder.
+// sContext is the string context of s.
+func outputPlaceholders(s string, sContext sets.Set[string]) (map[string]zbstore.OutputReference, error) {
+	var placeholders map[string]zbstore.OutputReference
+	for dep := range sContext {
+		c, err := parseContextString(dep)
+		if err != nil {
+			return nil, fmt.Errorf("internal error: %w", err)
+		}
+		if c.outputReference.IsZero() {
+			continue
+		}
+		placeholder := zbstore.UnknownCAOutputPlaceholder(c.outputReference)
+		if !strings.Contains(s, placeholder) {
+			continue
+		}
+		if placeholders == nil {
+			placeholders = make(map[string]zbstore.OutputReference)
+		}
+		placeholders[placeholder] = c.outputReference
+	}
+	return placeholders, nil
+}
+
+// hasPlaceholderPrefix reports whether s begins with one of the placeholders,
+// meaning that s is an absolute path inside a derivation's output.
+func hasPlaceholderPrefix(s string, placeholders map[string]zbstore.OutputReference) bool {
+	for placeholder := range placeholders {
+		if strings.HasPrefix(s, placeholder) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkImportFromDerivation returns an error
+// if the evaluation does not permit building the given outputs.
+func (eval *Eval) checkImportFromDerivation(placeholders map[string]zbstore.OutputReference) error {
+	if !eval.noImportFromDerivation || len(placeholders) == 0 {
+		return nil
+	}
+	refs := slices.SortedFunc(maps.Values(placeholders), compareOutputReferences)
+	return fmt.Errorf("import from derivation is disabled (needs %v)", refs[0])
+}
+
+// realizePlaceholders builds the outputs referenced by placeholders
+// and returns s with the placeholders replaced by the outputs' store paths.
+// position is the location of the Lua code that requested the outputs
+// and is used to report the build.
+//
+// Outputs are only built once per [Eval]:
+// if another caller has already started building an output,
+// realizePlaceholders waits for that build to finish.
+// Because imports are evaluated concurrently,
+// independent imports that depend on different derivations build in parallel.
+func (eval *Eval) realizePlaceholders(ctx context.Context, position Position, s string, placeholders map[string]zbstore.OutputReference) (string, error) {
+	if len(placeholders) == 0 {
+		return s, nil
+	}
+	if err := eval.checkImportFromDerivation(placeholders); err != nil {
+		return "", err
+	}
+	// Build outputs are not recorded in the module cache.
+	moduleDepsFromContext(ctx).markImpure()
+
+	// Claim the outputs that nobody else has started building.
+	want := make(sets.Set[zbstore.OutputReference])
+	realizations := make(map[zbstore.OutputReference]*importRealization, len(placeholders))
+	eval.importRealizationsMutex.Lock()
+	for _, ref := range placeholders {
+		r := eval.importRealizations[ref]
+		if r == nil {
+			r = &importRealization{done: make(chan struct{})}
+			if eval.importRealizations == nil {
+				eval.importRealizations = make(map[zbstore.OutputReference]*importRealization)
+			}
+			eval.importRealizations[ref] = r
+			want.Add(ref)
+		}
+		realizations[ref] = r
+	}
+	eval.importRealizationsMutex.Unlock()
+
+	if want.Len() > 0 {
+		if eval.reportImportBuild != nil {
+			eval.reportImportBuild(ctx, &ImportBuild{
+				Outputs:  slices.SortedFunc(want.All(), compareOutputReferences),
+				Position: position,
+			})
+		}
+		results, err := eval.store.Realize(ctx, want)
+		for ref := range want.All() {
+			r := realizations[ref]
+			if err != nil {
+				r.err = err
+			} else {
+				outputPath, findErr := zbstorerpc.FindRealizeOutput(slices.Values(results), ref)
+				switch {
+				case findErr != nil:
+					r.err = findErr
+				case !outputPath.Valid || outputPath.X == "":
+					r.err = fmt.Errorf("realize %v: build failed", ref)
+				default:
+					r.path = outputPath.X
+				}
+			}
+			close(r.done)
+		}
+		if err != nil {
+			// Let later evaluations retry builds that were interrupted.
+			eval.importRealizationsMutex.Lock()
+			for ref := range want.All() {
+				delete(eval.importRealizations, ref)
+			}
+			eval.importRealizationsMutex.Unlock()
+		}
+	}
+
+	rewrites := make([]string, 0, len(placeholders)*2)
+	for placeholder, ref := range placeholders {
+		r := realizations[ref]
+		select {
+		case <-r.done:
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+		if r.err != nil {
+			return "", r.err
+		}
+		rewrites = append(rewrites, placeholder, string(r.path))
+	}
+	return strings.NewReplacer(rewrites...).Replace(s), nil
+}
+
+// compareOutputReferences orders output references
+// by derivation path then output name.
+func compareOutputReferences(a, b zbstore.OutputReference) int {
+	return cmp.Or(
+		strings.Compare(string(a.DrvPath), string(b.DrvPath)),
+		strings.Compare(a.OutputName, b.OutputName),
+	)
+}
@@ -0,0 +1,215 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package frontend
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"zb.256lights.llc/pkg/internal/backendtest"
+	"zb.256lights.llc/pkg/internal/system"
+	"zb.256lights.llc/pkg/internal/testcontext"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+	"zb.256lights.llc/pkg/sets"
+	"zb.256lights.llc/pkg/zbstore"
+)
+
+func TestImportBuildReport(t *testing.T) {
+	ctx := testcontext.New(t)
+	storeDir := backendtest.NewStoreDirectory(t)
+
+	di := new(zbstorerpc.DeferredImporter)
+	_, store, err := backendtest.NewServer(ctx, t, storeDir, &backendtest.Options{
+		TempDir: t.TempDir(),
+		ClientOptions: zbstorerpc.CodecOptions{
+			Importer: di,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	var builds []*ImportBuild
+	eval, err := NewEval(&Options{
+		Store:          newTestRPCStore(store, di),
+		StoreDirectory: storeDir,
+		ReportImportBuild: func(ctx context.Context, b *ImportBuild) {
+			mu.Lock()
+			builds = append(builds, b)
+			mu.Unlock()
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := eval.Close(); err != nil {
+			t.Error("eval.Close:", err)
+		}
+	}()
+
+	path := filepath.Join("testdata", "ifd.lua")
+	for range 2 {
+		results, err := eval.URLs(ctx, []string{path + "#" + system.Current().String()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) == 0 || results[0] != "Hello, World!" {
+			t.Fatalf("results = %#v; want [\"Hello, World!\"]", results)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	// The second evaluation reuses the first build.
+	if len(builds) != 1 {
+		t.Fatalf("reported %d builds; want 1", len(builds))
+	}
+	b := builds[0]
+	if len(b.Outputs) != 1 || b.Outputs[0].OutputName != "out" || !strings.HasSuffix(string(b.Outputs[0].DrvPath), "-hello.lua.drv") {
+		t.Errorf("builds[0].Outputs = %v; want [.../hello.lua.drv!out]", b.Outputs)
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Position{Filename: absPath, Line: 38}); b.Position != want {
+		t.Errorf("builds[0].Position = %v; want %v", b.Position, want)
+	}
+}
+
+func TestNoImportFromDerivation(t *testing.T) {
+	ctx := testcontext.New(t)
+	storeDir := backendtest.NewStoreDirectory(t)
+
+	di := new(zbstorerpc.DeferredImporter)
+	_, client, err := backendtest.NewServer(ctx, t, storeDir, &backendtest.Options{
+		TempDir: t.TempDir(),
+		ClientOptions: zbstorerpc.CodecOptions{
+			Importer: di,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &barrierStore{
+		Store: newTestRPCStore(client, di),
+		n:     1,
+	}
+	eval, err := NewEval(&Options{
+		Store:                  store,
+		StoreDirectory:         storeDir,
+		NoImportFromDerivation: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := eval.Close(); err != nil {
+			t.Error("eval.Close:", err)
+		}
+	}()
+
+	const drvExpr = `derivation { name = "hello.lua"; system = "x86_64-linux"; builder = "/bin/sh"; }`
+	const want = "import from derivation is disabled"
+	result, err := eval.Expression(ctx, "select(2, import(("+drvExpr+").out))")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := result.(string); !strings.Contains(got, want) {
+		t.Errorf("import error = %#v; want to contain %q", result, want)
+	}
+	_, err = eval.Expression(ctx, "readFile(("+drvExpr+").out)")
+	if err == nil {
+		t.Error("readFile did not raise an error")
+	} else if got := err.Error(); !strings.Contains(got, want) {
+		t.Errorf("readFile error = %q; want to contain %q", got, want)
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if store.calls > 0 {
+		t.Errorf("store.Realize called %d times; want 0", store.calls)
+	}
+}
+
+func TestImportFromDerivationParallel(t *testing.T) {
+	ctx := testcontext.New(t)
+	storeDir := backendtest.NewStoreDirectory(t)
+
+	di := new(zbstorerpc.DeferredImporter)
+	_, client, err := backendtest.NewServer(ctx, t, storeDir, &backendtest.Options{
+		TempDir: t.TempDir(),
+		ClientOptions: zbstorerpc.CodecOptions{
+			Importer: di,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &barrierStore{
+		Store: newTestRPCStore(client, di),
+		n:     2,
+	}
+	eval, err := NewEval(&Options{
+		Store:          store,
+		StoreDirectory: storeDir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := eval.Close(); err != nil {
+			t.Error("eval.Close:", err)
+		}
+	}()
+
+	results, err := eval.URLs(ctx, []string{
+		filepath.Join("testdata", "ifd_parallel.lua") + "#" + system.Current().String(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) == 0 || results[0] != "Hello, World!" {
+		t.Errorf("results = %#v; want [\"Hello, World!\"]", results)
+	}
+}
+
+// barrierStore is a [Store] that waits in Realize
+// until n calls to Realize are in progress
+// before forwarding them to the underlying store.
+type barrierStore struct {
+	Store
+	n int
+
+	mu      sync.Mutex
+	calls   int
+	waiting chan struct{}
+}
+
+func (store *barrierStore) Realize(ctx context.Context, want sets.Set[zbstore.OutputReference]) ([]*zbstorerpc.BuildResult, error) {
+	store.mu.Lock()
+	store.calls++
+	if store.waiting == nil {
+		store.waiting = make(chan struct{})
+	}
+	waiting := store.waiting
+	if store.calls == store.n {
+		close(waiting)
+	}
+	store.mu.Unlock()
+
+	select {
+	case <-waiting:
+	case <-time.After(10 * time.Second):
+		return nil, fmt.Errorf("timed out waiting for %d concurrent builds", store.n)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return store.Store.Realize(ctx, want)
+}
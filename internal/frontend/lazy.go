@@ -199,6 +199,9 @@ func indexLazy(ctx context.Context, l *lua.State) (int, error) {
 	}
 
 	close(done)
+	if callError != nil {
+		return 0, callError
+	}
 	return 1, nil
 }
 
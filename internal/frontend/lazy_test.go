@@ -48,3 +48,25 @@ func TestLazy(t *testing.T) {
 		t.Errorf("%s (-want +got):\n%s", expr, diff)
 	}
 }
+
+func TestLazyError(t *testing.T) {
+	ctx := testcontext.New(t)
+	eval, err := NewEval(&Options{
+		StoreDirectory: backendtest.NewStoreDirectory(t),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := eval.Close(); err != nil {
+			t.Error("eval.Close:", err)
+		}
+	}()
+
+	// The first access must raise the error, not just the cached accesses after it.
+	const expr = `lazy(function(t, k) error("bad key " .. k) end).x`
+	got, err := eval.Expression(ctx, expr)
+	if err == nil {
+		t.Errorf("%s = %v; want error", expr, got)
+	}
+}
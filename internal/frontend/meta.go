@@ -0,0 +1,150 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package frontend
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"slices"
+
+	"zb.256lights.llc/pkg/internal/lua"
+	"zb.256lights.llc/pkg/internal/lualex"
+	"zb.256lights.llc/pkg/internal/system"
+)
+
+// metaArgument is the name of the derivation function argument
+// that describes a derivation for people and tools.
+// Like checks, the meta table is not passed to the builder
+// and does not affect the derivation's store path.
+const metaArgument = "meta"
+
+// Meta is the information in a derivation's meta table.
+// All fields are optional.
+type Meta struct {
+	// Description is a short, one-line description of the package.
+	Description string
+	// License is an [SPDX license expression].
+	//
+	// [SPDX license expression]: https://spdx.github.io/spdx-spec/v2.3/SPDX-license-expressions/
+	License string
+	// Homepage is an absolute http or https URL of the package's website.
+	Homepage string
+	// MainProgram is the name of the program in the output's bin directory
+	// that runs the package.
+	MainProgram string
+	// Platforms is the list of systems that the package supports.
+	// An empty list means the package does not declare its supported systems.
+	// Evaluating a derivation for a system not in the list produces a warning.
+	Platforms []string
+}
+
+// supportsSystem reports whether meta.Platforms permits sys.
+// Every system is permitted if meta.Platforms is empty.
+func (meta *Meta) supportsSystem(sys system.System) bool {
+	if len(meta.Platforms) == 0 {
+		return true
+	}
+	want := SystemTriple(sys)
+	for _, p := range meta.Platforms {
+		if parsed, err := system.Parse(p); err == nil && SystemTriple(parsed) == want {
+			return true
+		}
+	}
+	return false
+}
+
+// metaFields is the sorted list of fields permitted in a meta table.
+var metaFields = []string{
+	"description",
+	"homepage",
+	"license",
+	"mainProgram",
+	"platforms",
+}
+
+// parseMeta validates the meta table at the given index and returns its contents.
+// Unknown fields are reported as warnings
+// attributed to the given position (in the form "chunkname:line").
+func (eval *Eval) parseMeta(ctx context.Context, l *lua.State, idx int, position string) (*Meta, error) {
+	idx = l.AbsIndex(idx)
+	if typ := l.Type(idx); typ != lua.TypeTable {
+		return nil, fmt.Errorf("%v expected, got %v", lua.TypeTable, typ)
+	}
+	meta := new(Meta)
+	stringFields := []struct {
+		name string
+		dst  *string
+	}{
+		{"description", &meta.Description},
+		{"license", &meta.License},
+		{"homepage", &meta.Homepage},
+		{"mainProgram", &meta.MainProgram},
+	}
+	for _, f := range stringFields {
+		switch typ := l.RawField(idx, f.name); typ {
+		case lua.TypeNil:
+		case lua.TypeString:
+			*f.dst, _ = l.ToString(-1)
+		default:
+			l.Pop(1)
+			return nil, fmt.Errorf("%s: %v expected, got %v", f.name, lua.TypeString, typ)
+		}
+		l.Pop(1)
+	}
+	if meta.Homepage != "" {
+		u, err := url.Parse(meta.Homepage)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("homepage: %s is not an http or https URL", lualex.Quote(meta.Homepage))
+		}
+	}
+
+	switch typ := l.RawField(idx, "platforms"); typ {
+	case lua.TypeNil:
+	case lua.TypeTable:
+		err := ipairs(ctx, l, -1, func(i int64) error {
+			if typ := l.Type(-1); typ != lua.TypeString {
+				return fmt.Errorf("#%d: %v expected, got %v", i, lua.TypeString, typ)
+			}
+			sys, _ := l.ToString(-1)
+			if err := checkSystemArgument(sys); err != nil {
+				return fmt.Errorf("#%d: %v", i, err)
+			}
+			meta.Platforms = append(meta.Platforms, sys)
+			return nil
+		})
+		if err != nil {
+			l.Pop(1)
+			return nil, fmt.Errorf("platforms: %v", err)
+		}
+	default:
+		l.Pop(1)
+		return nil, fmt.Errorf("platforms: %v expected, got %v", lua.TypeTable, typ)
+	}
+	l.Pop(1)
+
+	l.PushNil()
+	for l.Next(idx) {
+		l.Pop(1)
+		k, isString := "", l.Type(-1) == lua.TypeString
+		if isString {
+			k, _ = l.ToString(-1)
+		}
+		if isString && slices.Contains(metaFields, k) {
+			continue
+		}
+		var msg string
+		if isString {
+			msg = fmt.Sprintf("unknown %s field %s", metaArgument, lualex.Quote(k))
+		} else {
+			msg = fmt.Sprintf("%s has a %v key", metaArgument, l.Type(-1))
+		}
+		eval.emitWarning(ctx, &Warning{
+			Message:  msg,
+			Key:      position + ": " + msg,
+			Position: position,
+		})
+	}
+	return meta, nil
+}
@@ -0,0 +1,133 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package frontend
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"zb.256lights.llc/pkg/internal/backendtest"
+	"zb.256lights.llc/pkg/internal/system"
+	"zb.256lights.llc/pkg/internal/testcontext"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+)
+
+func TestMeta(t *testing.T) {
+	ctx := testcontext.New(t)
+	storeDir := backendtest.NewStoreDirectory(t)
+
+	di := new(zbstorerpc.DeferredImporter)
+	_, store, err := backendtest.NewServer(ctx, t, storeDir, &backendtest.Options{
+		TempDir: t.TempDir(),
+		ClientOptions: zbstorerpc.CodecOptions{
+			Importer: di,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sys, err := system.Parse("x86_64-linux")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var warnings []*Warning
+	eval, err := NewEval(&Options{
+		Store:          newTestRPCStore(store, di),
+		StoreDirectory: storeDir,
+		System:         sys,
+		Warn: func(ctx context.Context, w *Warning) {
+			warnings = append(warnings, w)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := eval.Close(); err != nil {
+			t.Error("eval.Close:", err)
+		}
+	}()
+
+	const plainExpr = `derivation { name = "hello"; system = "x86_64-linux"; builder = "/bin/sh" }`
+	plain, err := eval.Expression(ctx, plainExpr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const metaExpr = `derivation {
+		name = "hello";
+		system = "x86_64-linux";
+		builder = "/bin/sh";
+		meta = {
+			description = "Print a friendly greeting";
+			license = "MIT OR Apache-2.0";
+			homepage = "https://example.com/hello";
+			mainProgram = "hello";
+			platforms = { "x86_64-linux", "aarch64-darwin" };
+			maintainers = { "alice" };
+		};
+	}`
+	result, err := eval.Expression(ctx, metaExpr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	drv := result.(*Derivation)
+	want := Meta{
+		Description: "Print a friendly greeting",
+		License:     "MIT OR Apache-2.0",
+		Homepage:    "https://example.com/hello",
+		MainProgram: "hello",
+		Platforms:   []string{"x86_64-linux", "aarch64-darwin"},
+	}
+	if diff := cmp.Diff(want, drv.Meta); diff != "" {
+		t.Errorf("drv.Meta (-want +got):\n%s", diff)
+	}
+	if got, want := drv.Path, plain.(*Derivation).Path; got != want {
+		t.Errorf("derivation with meta = %s; want %s (same as without meta)", got, want)
+	}
+	if _, ok := drv.Env[metaArgument]; ok {
+		t.Errorf("drv.Env has %q", metaArgument)
+	}
+	wantWarnings := []*Warning{{Message: `unknown meta field "maintainers"`}}
+	if diff := cmp.Diff(wantWarnings, warnings, cmpopts.IgnoreFields(Warning{}, "Key", "Position")); diff != "" {
+		t.Errorf("warnings (-want +got):\n%s", diff)
+	}
+
+	warnings = nil
+	const otherPlatformExpr = `derivation {
+		name = "hello";
+		system = "x86_64-linux";
+		builder = "/bin/sh";
+		meta = { platforms = { "aarch64-darwin" } };
+	}`
+	if _, err := eval.Expression(ctx, otherPlatformExpr); err != nil {
+		t.Fatal(err)
+	}
+	wantWarnings = []*Warning{{Message: `"hello" does not list x86_64-unknown-linux in meta.platforms`}}
+	if diff := cmp.Diff(wantWarnings, warnings, cmpopts.IgnoreFields(Warning{}, "Key", "Position")); diff != "" {
+		t.Errorf("warnings for %s (-want +got):\n%s", otherPlatformExpr, diff)
+	}
+
+	badExprs := []struct {
+		meta    string
+		wantErr string
+	}{
+		{`"hello"`, "meta argument: table expected"},
+		{`{ description = 42 }`, "meta argument: description: string expected"},
+		{`{ homepage = "ftp://example.com/" }`, "meta argument: homepage:"},
+		{`{ platforms = "x86_64-linux" }`, "meta argument: platforms: table expected"},
+		{`{ platforms = { "x86_64" } }`, "meta argument: platforms: #1:"},
+	}
+	for _, test := range badExprs {
+		expr := `derivation { name = "hello"; system = "x86_64-linux"; builder = "/bin/sh"; meta = ` + test.meta + ` }`
+		_, err := eval.Expression(ctx, expr)
+		if err == nil {
+			t.Errorf("%s did not return an error", expr)
+		} else if !strings.Contains(err.Error(), test.wantErr) {
+			t.Errorf("%s: %v; want error containing %q", expr, err, test.wantErr)
+		}
+	}
+}
@@ -6,12 +6,14 @@ package frontend
 import (
 	"context"
 	"iter"
+	"path/filepath"
 	"slices"
 	"strings"
 	"sync"
 
 	"zb.256lights.llc/pkg/internal/lua"
 	"zb.256lights.llc/pkg/internal/luacode"
+	"zb.256lights.llc/pkg/zbstore"
 )
 
 const moduleTypeName = "module"
@@ -30,6 +32,14 @@ type module struct {
 	// Once the module has finished execution,
 	// its return value is the only value left on the state's stack.
 	state lua.State
+
+	// path is the absolute path of the module's file,
+	// or empty if the module was imported from a derivation output.
+	path string
+	// deps records the inputs that the module's value depends on.
+	deps *moduleDeps
+	// cached is true if the module's value was loaded from the cache database.
+	cached bool
 }
 
 func (mod *module) Freeze() error { return nil }
@@ -70,7 +80,22 @@ func (eval *Eval) importFunction(ctx context.Context, l *lua.State) (int, error)
 	}
 	filenameContext := l.StringContext(1)
 
-	filename, err = absSourcePathWithDeps(ctx, l, eval, filename, filenameContext)
+	// If the path is inside a derivation's output,
+	// build the derivation in the module's goroutine
+	// so that independent imports build in parallel.
+	placeholders, err := outputPlaceholders(filename, filenameContext)
+	if err == nil {
+		err = eval.checkImportFromDerivation(placeholders)
+	}
+	var position Position
+	if err == nil && hasPlaceholderPrefix(filename, placeholders) {
+		position = callerPosition(l)
+	} else {
+		placeholders = nil
+		if err == nil {
+			filename, err = absSourcePathWithDeps(ctx, l, eval, filename, filenameContext)
+		}
+	}
 	if err != nil {
 		l.PushNil()
 		l.PushString(err.Error())
@@ -101,6 +126,23 @@ func (eval *Eval) importFunction(ctx context.Context, l *lua.State) (int, error)
 		return 2, nil
 	}
 
+	if err := eval.pushModule(ctx, l, filename, placeholders, position); err != nil {
+		return 0, err
+	}
+	return 1, nil
+}
+
+// pushModule pushes the module for the Lua file at the given absolute path
+// onto l's stack,
+// starting evaluation of the file if it has not already been imported.
+// If placeholders is not empty, then filename refers to a derivation output
+// and the outputs are built before evaluating the file
+// (see [*Eval.realizePlaceholders]).
+// position is the location of the code that requested the import.
+func (eval *Eval) pushModule(ctx context.Context, l *lua.State, filename string, placeholders map[string]zbstore.OutputReference, position Position) error {
+	chain := importChainFromContext(ctx)
+	parentDeps := moduleDepsFromContext(ctx)
+
 	// Begin critical section on loaded state.
 	eval.loadedMutex.Lock()
 	defer func() {
@@ -110,56 +152,83 @@ func (eval *Eval) importFunction(ctx context.Context, l *lua.State) (int, error)
 
 	// See if the module has already been imported.
 	if got := eval.loadedState.RawField(1, filename); got != lua.TypeNil {
-		if err := l.XMove(&eval.loadedState, 1); err != nil {
-			return 0, err
-		}
-		return 1, nil
+		parentDeps.addImport(testModule(&eval.loadedState, -1))
+		return l.XMove(&eval.loadedState, 1)
 	}
 	eval.loadedState.Pop(1)
 
 	// Create new module instance.
 	finished := make(chan struct{})
-	mod := &module{finished: finished}
+	mod := &module{
+		finished: finished,
+		deps:     new(moduleDeps),
+	}
+	if len(placeholders) == 0 {
+		mod.path = filename
+	}
 	if err := eval.initState(&mod.state); err != nil {
-		return 0, err
+		return err
 	}
 	eval.loadedState.NewUserdata(mod, 0)
 	if err := lua.SetMetatable(&eval.loadedState, moduleTypeName); err != nil {
-		return 0, err
+		return err
 	}
 	if err := eval.loadedState.Freeze(-1); err != nil {
-		return 0, err
+		return err
 	}
 	eval.loadedState.PushValue(-1)
 	if err := eval.loadedState.RawSetField(1, filename); err != nil {
-		return 0, err
+		return err
+	}
+	parentDeps.addImport(mod)
+	if eval.stats != nil {
+		eval.stats.modules.Add(1)
+	}
+	if eval.moduleCache {
+		eval.modulesMutex.Lock()
+		eval.modules = append(eval.modules, mod)
+		eval.modulesMutex.Unlock()
 	}
 
 	// Start a goroutine that evaluates the module file.
 	eval.importGroup.Go(func() {
 		defer close(finished)
-		ctx := contextWithImportChain(eval.baseImportContext, &importChain{
-			path: filename,
+		ctx := contextWithModuleDeps(eval.baseImportContext, mod.deps)
+		path := filename
+		if len(placeholders) > 0 {
+			path, mod.error = eval.realizePlaceholders(ctx, position, filename, placeholders)
+			if mod.error != nil {
+				mod.state.Close()
+				return
+			}
+			path = filepath.FromSlash(path)
+		}
+		ctx = contextWithImportChain(ctx, &importChain{
+			path: path,
 			next: chain,
 		})
-		mod.error = eval.resolveModule(ctx, &mod.state, filename)
+		if eval.moduleCache && mod.path != "" {
+			if eval.loadCachedModule(ctx, mod) {
+				return
+			}
+		}
+		mod.error = eval.resolveModule(ctx, &mod.state, path)
 		if mod.error != nil {
 			mod.state.Close()
 		}
 	})
 
 	// Copy module from loaded state to top of stack.
-	if err := l.XMove(&eval.loadedState, 1); err != nil {
-		return 0, err
-	}
-	return 1, nil
+	return l.XMove(&eval.loadedState, 1)
 }
 
 func (eval *Eval) resolveModule(ctx context.Context, l *lua.State, filename string) error {
 	l.SetTop(0)
-	if err := loadFile(l, filename); err != nil {
+	hash, err := loadFileHash(l, filename)
+	if err != nil {
 		return err
 	}
+	moduleDepsFromContext(ctx).addFile(filename, hash)
 	l.PushClosure(0, messageHandler)
 	l.Insert(1)
 	if err := l.PCall(ctx, 0, lua.MultipleReturns, 1); err != nil {
@@ -0,0 +1,346 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package frontend
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sync"
+
+	"zb.256lights.llc/pkg/sets"
+	"zombiezen.com/go/log"
+	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+// moduleCacheVersion is the version of the module cache's semantics.
+// It must be incremented whenever the way that module values are computed changes
+// so that older cached values are ignored.
+// Changes to the encoding of values are covered by [valueEncodingVersion].
+const moduleCacheVersion = 1
+
+// fileHash is the SHA-256 hash of a file's content.
+type fileHash [sha256.Size]byte
+
+// moduleDeps records the inputs that a module's value depends on.
+// While a module is evaluated, its moduleDeps is stored in the [context.Context]
+// so that built-in functions can record what they read.
+type moduleDeps struct {
+	mu sync.Mutex
+	// impure is set if the module used an input
+	// that the cache cannot check for changes,
+	// like an environment variable.
+	impure bool
+	// files is the set of files the module read, keyed by absolute path.
+	files map[string]fileHash
+	// paths is the set of absolute paths of files or directories
+	// that the module imported into the store.
+	paths sets.Set[string]
+	// env is the set of environment variables that the module looked up.
+	env map[string]EnvLookup
+	// imports is the list of modules that the module imported.
+	imports []*module
+}
+
+type moduleDepsContextKey struct{}
+
+func contextWithModuleDeps(parent context.Context, deps *moduleDeps) context.Context {
+	return context.WithValue(parent, moduleDepsContextKey{}, deps)
+}
+
+// moduleDepsFromContext returns the dependencies of the module
+// being evaluated in ctx or nil if ctx is not evaluating a module.
+// All methods of a nil *moduleDeps are no-ops.
+func moduleDepsFromContext(ctx context.Context) *moduleDeps {
+	deps, _ := ctx.Value(moduleDepsContextKey{}).(*moduleDeps)
+	return deps
+}
+
+// markImpure records that the module's value cannot be cached.
+func (deps *moduleDeps) markImpure() {
+	if deps == nil {
+		return
+	}
+	deps.mu.Lock()
+	deps.impure = true
+	deps.mu.Unlock()
+}
+
+// addFile records that the module read the file at path.
+func (deps *moduleDeps) addFile(path string, hash fileHash) {
+	if deps == nil {
+		return
+	}
+	deps.mu.Lock()
+	defer deps.mu.Unlock()
+	if deps.files == nil {
+		deps.files = make(map[string]fileHash)
+	}
+	if old, ok := deps.files[path]; ok && old != hash {
+		// The file changed during evaluation.
+		deps.impure = true
+	}
+	deps.files[path] = hash
+}
+
+// addPath records that the module imported the file or directory at path
+// into the store.
+func (deps *moduleDeps) addPath(path string) {
+	if deps == nil {
+		return
+	}
+	deps.mu.Lock()
+	defer deps.mu.Unlock()
+	if deps.paths == nil {
+		deps.paths = make(sets.Set[string])
+	}
+	deps.paths.Add(path)
+}
+
+// addEnv records that the module looked up an environment variable.
+// Environment variables are not tracked by the cache,
+// so addEnv also marks the module as impure.
+func (deps *moduleDeps) addEnv(key string, lookup EnvLookup) {
+	if deps == nil {
+		return
+	}
+	deps.mu.Lock()
+	defer deps.mu.Unlock()
+	deps.impure = true
+	if deps.env == nil {
+		deps.env = make(map[string]EnvLookup)
+	}
+	deps.env[key] = lookup
+}
+
+// addImport records that the module imported mod.
+func (deps *moduleDeps) addImport(mod *module) {
+	if deps == nil || mod == nil {
+		return
+	}
+	deps.mu.Lock()
+	deps.imports = append(deps.imports, mod)
+	deps.mu.Unlock()
+}
+
+// transitiveFiles returns the files that the value of mod depends on,
+// including the files read by the modules it imported.
+// It reports false if the value cannot be cached,
+// either because mod or one of its imports used an impure input
+// or because one of its imports did not finish successfully.
+func (mod *module) transitiveFiles() (map[string]fileHash, bool) {
+	files := make(map[string]fileHash)
+	visited := make(sets.Set[*module])
+	var visit func(m *module) bool
+	visit = func(m *module) bool {
+		if visited.Has(m) {
+			return true
+		}
+		visited.Add(m)
+		select {
+		case <-m.finished:
+		default:
+			return false
+		}
+		if m.error != nil || m.deps == nil {
+			return false
+		}
+		m.deps.mu.Lock()
+		impure := m.deps.impure
+		imports := slices.Clone(m.deps.imports)
+		for path, hash := range m.deps.files {
+			if old, ok := files[path]; ok && old != hash {
+				impure = true
+			}
+			files[path] = hash
+		}
+		m.deps.mu.Unlock()
+		if impure {
+			return false
+		}
+		for _, imp := range imports {
+			if !visit(imp) {
+				return false
+			}
+		}
+		return true
+	}
+	if !visit(mod) {
+		return nil, false
+	}
+	return files, true
+}
+
+// hashFile returns the SHA-256 hash of the file at path.
+func hashFile(path string) (fileHash, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return fileHash{}, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return fileHash{}, err
+	}
+	var sum fileHash
+	h.Sum(sum[:0])
+	return sum, nil
+}
+
+// moduleCacheSalt returns a string that identifies
+// everything besides files that can change the value of a module.
+func (eval *Eval) moduleCacheSalt() string {
+	return fmt.Sprintf("%d %d %s %s %s",
+		moduleCacheVersion, valueEncodingVersion, eval.version, eval.storeDir, SystemTriple(eval.system))
+}
+
+// loadCachedModule replaces the stack of mod.state with the module's value
+// from the cache database,
+// if the files that the value depends on have not changed.
+// It reports whether the value was found.
+func (eval *Eval) loadCachedModule(ctx context.Context, mod *module) bool {
+	if eval.stats != nil {
+		eval.stats.moduleCacheLookups.Add(1)
+	}
+	value, files, err := eval.findCachedModule(ctx, mod.path)
+	if err != nil {
+		log.Debugf(ctx, "Reading cached value of %s: %v", mod.path, err)
+		return false
+	}
+	if files == nil {
+		return false
+	}
+	for path, want := range files {
+		if got, err := hashFile(path); err != nil || got != want {
+			log.Debugf(ctx, "Cached value of %s is out of date: %s changed", mod.path, path)
+			return false
+		}
+	}
+
+	l := &mod.state
+	l.SetTop(0)
+	if err := unmarshalValue(ctx, eval, l, value); err != nil {
+		log.Debugf(ctx, "Loading cached value of %s: %v", mod.path, err)
+		l.SetTop(0)
+		return false
+	}
+	mod.deps.mu.Lock()
+	mod.deps.files = files
+	mod.deps.mu.Unlock()
+	mod.cached = true
+	eval.moduleCacheHits.Add(1)
+	if eval.stats != nil {
+		eval.stats.moduleCacheHits.Add(1)
+	}
+	log.Debugf(ctx, "Using cached value of %s", mod.path)
+	return true
+}
+
+// findCachedModule reads the value of the module at path from the cache database
+// along with the files it depends on.
+// If the module is not in the cache, findCachedModule returns nil maps.
+func (eval *Eval) findCachedModule(ctx context.Context, path string) (value []byte, files map[string]fileHash, err error) {
+	conn, err := eval.cachePool.Get(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer eval.cachePool.Put(conn)
+
+	err = sqlitex.ExecuteTransientFS(conn, sqlFiles(), "modules/find.sql", &sqlitex.ExecOptions{
+		Named: map[string]any{
+			":path": path,
+			":salt": eval.moduleCacheSalt(),
+		},
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			if files == nil {
+				files = make(map[string]fileHash)
+				value = make([]byte, stmt.GetLen("value"))
+				stmt.GetBytes("value", value)
+			}
+			var hash fileHash
+			if n := stmt.GetBytes("file_hash", hash[:]); n != len(hash) {
+				return fmt.Errorf("invalid hash for %s", stmt.GetText("file_path"))
+			}
+			files[stmt.GetText("file_path")] = hash
+			return nil
+		},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return value, files, nil
+}
+
+// saveModules writes the values of the modules imported during evaluation
+// to the cache database.
+// Modules whose values cannot be cached are skipped.
+// saveModules must only be called after all imports have finished.
+func (eval *Eval) saveModules() {
+	ctx := context.Background()
+	eval.modulesMutex.Lock()
+	modules := slices.Clone(eval.modules)
+	eval.modulesMutex.Unlock()
+
+	for _, mod := range modules {
+		if mod.cached || mod.path == "" || mod.error != nil {
+			continue
+		}
+		files, ok := mod.transitiveFiles()
+		if !ok {
+			log.Debugf(ctx, "Not caching value of %s: depends on inputs other than files", mod.path)
+			continue
+		}
+		value, err := marshalValue(&mod.state, -1)
+		if err != nil {
+			log.Debugf(ctx, "Not caching value of %s: %v", mod.path, err)
+			continue
+		}
+		if err := eval.cacheModule(ctx, mod.path, value, files); err != nil {
+			log.Debugf(ctx, "Caching value of %s: %v", mod.path, err)
+		}
+	}
+}
+
+// cacheModule replaces the value of the module at path in the cache database.
+func (eval *Eval) cacheModule(ctx context.Context, path string, value []byte, files map[string]fileHash) (err error) {
+	conn, err := eval.cachePool.Get(ctx)
+	if err != nil {
+		return err
+	}
+	defer eval.cachePool.Put(conn)
+	defer sqlitex.Save(conn)(&err)
+
+	err = sqlitex.ExecuteScriptFS(conn, sqlFiles(), "modules/replace.sql", &sqlitex.ExecOptions{
+		Named: map[string]any{
+			":path":  path,
+			":salt":  eval.moduleCacheSalt(),
+			":value": value,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	stmt, err := sqlitex.PrepareTransientFS(conn, sqlFiles(), "modules/insert_file.sql")
+	if err != nil {
+		return err
+	}
+	defer stmt.Finalize()
+	for filePath, hash := range files {
+		stmt.SetText(":module_path", path)
+		stmt.SetText(":path", filePath)
+		stmt.SetBytes(":hash", hash[:])
+		if _, err := stmt.Step(); err != nil {
+			return err
+		}
+		if err := stmt.Reset(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package frontend
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"zb.256lights.llc/pkg/internal/backendtest"
+	"zb.256lights.llc/pkg/internal/testcontext"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+)
+
+func TestModuleCache(t *testing.T) {
+	ctx := testcontext.New(t)
+	storeDir := backendtest.NewStoreDirectory(t)
+
+	di := new(zbstorerpc.DeferredImporter)
+	_, client, err := backendtest.NewServer(ctx, t, storeDir, &backendtest.Options{
+		TempDir: t.TempDir(),
+		ClientOptions: zbstorerpc.CodecOptions{
+			Importer: di,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := newTestRPCStore(client, di)
+	cacheDBPath := filepath.Join(t.TempDir(), "cache.db")
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"main.lua": "local lib = import \"lib.lua\"\n" +
+			"local shared = { 1, 2.5, true }\n" +
+			"return {\n" +
+			"  answer = lib.answer + 1;\n" +
+			"  data = readFile(\"data.txt\");\n" +
+			"  lib = lib;\n" +
+			"  a = shared;\n" +
+			"  b = shared;\n" +
+			"  drv = derivation {\n" +
+			"    name = \"hello\";\n" +
+			"    system = \"x86_64-linux\";\n" +
+			"    builder = \"/bin/sh\";\n" +
+			"    args = { \"-c\", \"echo \" .. lib.name .. \" > $out\" };\n" +
+			"  };\n" +
+			"}\n",
+		"lib.lua":    "return { answer = 41, name = \"lib\" }\n",
+		"data.txt":   "hello",
+		"impure.lua": "return { home = os.getenv(\"HOME\") }\n",
+		"func.lua":   "return function() return 42 end\n",
+		"caller.lua": "return (import \"func.lua\")()\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mainPath := filepath.Join(dir, "main.lua")
+	urls := []string{
+		mainPath + "#answer",
+		mainPath + "#data",
+		mainPath + "#lib/name",
+		mainPath + "#a",
+		mainPath + "#drv",
+		filepath.Join(dir, "impure.lua"),
+		filepath.Join(dir, "caller.lua"),
+	}
+	evaluate := func(t *testing.T) ([]any, int64) {
+		t.Helper()
+		eval, err := NewEval(&Options{
+			Store:          store,
+			StoreDirectory: storeDir,
+			CacheDBPath:    cacheDBPath,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		results, err := eval.URLs(ctx, urls)
+		if err != nil {
+			eval.Close()
+			t.Fatal(err)
+		}
+		hits := eval.moduleCacheHits.Load()
+		if err := eval.Close(); err != nil {
+			t.Error("eval.Close:", err)
+		}
+		return results, hits
+	}
+
+	want, hits := evaluate(t)
+	if hits != 0 {
+		t.Errorf("first evaluation used %d cached modules; want 0", hits)
+	}
+	if got, ok := want[4].(*Derivation); !ok || got.Path == "" {
+		t.Fatalf("drv = %#v; want derivation", want[4])
+	}
+
+	got, hits := evaluate(t)
+	// impure.lua reads the environment and func.lua returns a function,
+	// so neither can be cached.
+	if hits != 3 {
+		t.Errorf("second evaluation used %d cached modules; want 3 (main.lua, lib.lua, and caller.lua)", hits)
+	}
+	if diff := cmp.Diff(want, got, cmp.Comparer(func(a, b *Derivation) bool {
+		return a.Path == b.Path && a.Position == b.Position
+	})); diff != "" {
+		t.Errorf("results from cache (-want +got):\n%s", diff)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "data.txt"), []byte("goodbye"), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	got, hits = evaluate(t)
+	if hits != 2 {
+		t.Errorf("evaluation after changing data.txt used %d cached modules; want 2 (lib.lua and caller.lua)", hits)
+	}
+	if got[1] != "goodbye" {
+		t.Errorf("data = %#v; want \"goodbye\"", got[1])
+	}
+}
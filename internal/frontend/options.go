@@ -0,0 +1,256 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package frontend
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+
+	"zb.256lights.llc/pkg/internal/lua"
+	"zb.256lights.llc/pkg/internal/lualex"
+)
+
+// optionTypes is the set of type names that an option declaration can use.
+var optionTypes = map[string]struct{}{
+	"any":        {},
+	"boolean":    {},
+	"derivation": {},
+	"function":   {},
+	"integer":    {},
+	"number":     {},
+	"string":     {},
+	"table":      {},
+}
+
+// optionDecl is a single option declaration in the schema passed to zb.options.
+type optionDecl struct {
+	typ         string
+	hasDefault  bool
+	optional    bool
+	description string
+}
+
+// optionsFunction is the implementation of zb.options.
+// The first argument is the schema:
+// a table of option names to declarations.
+// The remaining arguments are tables (or nil) of option values
+// that are validated against the schema
+// and merged from left to right into a new table.
+func optionsFunction(ctx context.Context, l *lua.State) (int, error) {
+	const schemaArg = 1
+	if l.Type(schemaArg) != lua.TypeTable {
+		return 0, optionsArgError(l, schemaArg, fmt.Sprintf("table expected, got %v", l.Type(schemaArg)))
+	}
+	nargs := l.Top()
+	decls, err := parseOptionSchema(l, schemaArg)
+	if err != nil {
+		return 0, err
+	}
+
+	l.CreateTable(0, len(decls))
+	resultIndex := l.Top()
+	for arg := schemaArg + 1; arg <= nargs; arg++ {
+		switch l.Type(arg) {
+		case lua.TypeNil:
+			continue
+		case lua.TypeTable:
+		default:
+			return 0, optionsArgError(l, arg, fmt.Sprintf("table or nil expected, got %v", l.Type(arg)))
+		}
+		if err := mergeOptions(l, resultIndex, arg, decls); err != nil {
+			return 0, err
+		}
+	}
+
+	// Fill in defaults and check for required options.
+	for _, name := range slices.Sorted(maps.Keys(decls)) {
+		decl := decls[name]
+		if l.RawField(resultIndex, name) != lua.TypeNil {
+			l.Pop(1)
+			continue
+		}
+		l.Pop(1)
+		if !decl.hasDefault {
+			if decl.optional {
+				continue
+			}
+			msg := fmt.Sprintf("missing required option %s", lualex.Quote(name))
+			if decl.description != "" {
+				msg += " (" + decl.description + ")"
+			}
+			return 0, fmt.Errorf("%szb.options: %s", lua.Where(l, 1), msg)
+		}
+		l.RawField(schemaArg, name)
+		l.RawField(-1, "default")
+		if err := l.RawSetField(resultIndex, name); err != nil {
+			return 0, err
+		}
+		l.Pop(1) // declaration
+	}
+	return 1, nil
+}
+
+// parseOptionSchema parses the table at the given argument index
+// as a zb.options schema.
+func parseOptionSchema(l *lua.State, arg int) (map[string]*optionDecl, error) {
+	decls := make(map[string]*optionDecl)
+	l.PushNil()
+	for l.Next(arg) {
+		if l.Type(-2) != lua.TypeString {
+			return nil, optionsArgError(l, arg, fmt.Sprintf("option names must be strings (found %v)", l.Type(-2)))
+		}
+		name, _ := l.ToString(-2)
+		if l.Type(-1) != lua.TypeTable {
+			return nil, optionsArgError(l, arg, fmt.Sprintf("option %s: declaration must be a table (found %v)", lualex.Quote(name), l.Type(-1)))
+		}
+		decl := &optionDecl{typ: "any"}
+
+		switch l.RawField(-1, "type") {
+		case lua.TypeNil:
+		case lua.TypeString:
+			decl.typ, _ = l.ToString(-1)
+			if _, ok := optionTypes[decl.typ]; !ok {
+				return nil, optionsArgError(l, arg, fmt.Sprintf("option %s: unknown type %s", lualex.Quote(name), lualex.Quote(decl.typ)))
+			}
+		default:
+			return nil, optionsArgError(l, arg, fmt.Sprintf("option %s: type must be a string", lualex.Quote(name)))
+		}
+		l.Pop(1)
+
+		switch l.RawField(-1, "description") {
+		case lua.TypeNil:
+		case lua.TypeString:
+			decl.description, _ = l.ToString(-1)
+		default:
+			return nil, optionsArgError(l, arg, fmt.Sprintf("option %s: description must be a string", lualex.Quote(name)))
+		}
+		l.Pop(1)
+
+		decl.optional = l.RawField(-1, "optional") != lua.TypeNil && l.ToBoolean(-1)
+		l.Pop(1)
+
+		if l.RawField(-1, "default") != lua.TypeNil {
+			decl.hasDefault = true
+			if !hasOptionType(l, -1, decl.typ) {
+				return nil, optionsArgError(l, arg, fmt.Sprintf("option %s: default: %s expected, got %s",
+					lualex.Quote(name), decl.typ, optionTypeName(l, -1)))
+			}
+		}
+		l.Pop(1)
+
+		decls[name] = decl
+		// Remove declaration, keeping key for the next iteration.
+		l.Pop(1)
+	}
+	return decls, nil
+}
+
+// mergeOptions validates the table of option values at the given argument index
+// and copies its values into the result table at resultIndex.
+// Values of options with type "table" are merged with any value
+// already in the result table into a new table.
+func mergeOptions(l *lua.State, resultIndex int, arg int, decls map[string]*optionDecl) error {
+	l.PushNil()
+	for l.Next(arg) {
+		if l.Type(-2) != lua.TypeString {
+			return optionsArgError(l, arg, fmt.Sprintf("option names must be strings (found %v)", l.Type(-2)))
+		}
+		name, _ := l.ToString(-2)
+		decl := decls[name]
+		if decl == nil {
+			return optionsArgError(l, arg, fmt.Sprintf("unknown option %s", lualex.Quote(name)))
+		}
+		if !hasOptionType(l, -1, decl.typ) {
+			return optionsArgError(l, arg, fmt.Sprintf("option %s: %s expected, got %s",
+				lualex.Quote(name), decl.typ, optionTypeName(l, -1)))
+		}
+
+		merge := false
+		if decl.typ == "table" {
+			merge = l.RawField(resultIndex, name) == lua.TypeTable
+			if !merge {
+				l.Pop(1)
+			}
+		}
+		if merge {
+			// Merge the table from a previous argument and this table into a new table.
+			l.CreateTable(0, 0)
+			if err := mergeTables(l, -1, -2); err != nil {
+				return err
+			}
+			if err := mergeTables(l, -1, -3); err != nil {
+				return err
+			}
+			l.Remove(-2)
+		} else {
+			l.PushValue(-1)
+		}
+		if err := l.RawSetField(resultIndex, name); err != nil {
+			return err
+		}
+
+		// Remove value, keeping key for the next iteration.
+		l.Pop(1)
+	}
+	return nil
+}
+
+// optionsArgError returns an error for a bad argument to zb.options.
+// Unlike [lua.NewArgError], it always names the function,
+// since functions in library tables do not have a name in the call stack.
+func optionsArgError(l *lua.State, arg int, msg string) error {
+	return fmt.Errorf("%sbad argument #%d to 'zb.options' (%s)", lua.Where(l, 1), arg, msg)
+}
+
+// mergeTables copies the fields of the table at index src
+// into the table at index dst, overwriting any existing fields.
+func mergeTables(l *lua.State, dst, src int) error {
+	dst = l.AbsIndex(dst)
+	src = l.AbsIndex(src)
+	l.PushNil()
+	for l.Next(src) {
+		l.PushValue(-2)
+		l.Insert(-2)
+		if err := l.RawSet(dst); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hasOptionType reports whether the value at the given index
+// is of the given option type.
+func hasOptionType(l *lua.State, idx int, typ string) bool {
+	switch typ {
+	case "any":
+		return !l.IsNil(idx)
+	case "boolean":
+		return l.Type(idx) == lua.TypeBoolean
+	case "derivation":
+		return testDerivation(l, idx) != nil
+	case "function":
+		return l.Type(idx) == lua.TypeFunction
+	case "integer":
+		return l.Type(idx) == lua.TypeNumber && l.IsInteger(idx)
+	case "number":
+		return l.Type(idx) == lua.TypeNumber
+	case "string":
+		return l.Type(idx) == lua.TypeString
+	case "table":
+		return l.Type(idx) == lua.TypeTable
+	default:
+		return false
+	}
+}
+
+// optionTypeName returns the name of the type of the value at the given index
+// for use in error messages.
+func optionTypeName(l *lua.State, idx int) string {
+	if testDerivation(l, idx) != nil {
+		return "derivation"
+	}
+	return l.Type(idx).String()
+}
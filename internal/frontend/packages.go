@@ -0,0 +1,396 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package frontend
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+
+	"zb.256lights.llc/pkg/internal/fileurl"
+	"zb.256lights.llc/pkg/internal/lua"
+	"zb.256lights.llc/pkg/sets"
+	"zb.256lights.llc/pkg/zbstore"
+	"zombiezen.com/go/log"
+	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+// maxPackageDepth is the maximum number of nested tables
+// that [*Eval.Packages] will descend into.
+const maxPackageDepth = 4
+
+// Package is a derivation found by [*Eval.Packages].
+type Package struct {
+	// AttrPath is the slash-separated field path of the derivation
+	// relative to the source.
+	AttrPath string
+	// Name is the derivation's pname attribute
+	// or its name if it does not have a pname.
+	Name    string
+	Version string
+	// Description is the description from the derivation's meta table
+	// or its description attribute if the meta table does not have one.
+	Description string
+	DrvPath     zbstore.Path
+}
+
+// PackagesOptions is the set of optional parameters for [*Eval.Packages].
+type PackagesOptions struct {
+	// If Refresh is true, then the source is evaluated
+	// even if the cache has an up-to-date index.
+	Refresh bool
+}
+
+// Packages returns the derivations found in the table
+// that the given URL evaluates to (see [*Eval.URLs]).
+// Nested tables are searched for derivations, too,
+// but lazy tables are skipped without being evaluated
+// because their fields cannot be enumerated.
+// If the table has a field for the current system triple,
+// then only that field is searched.
+// Fields that raise errors during evaluation are logged and skipped.
+//
+// The result is recorded in the cache database
+// and reused on subsequent calls as long as
+// none of the files read by the source or the modules it imports have changed.
+// Sources that depend on other inputs (like environment variables) are not cached.
+func (eval *Eval) Packages(ctx context.Context, source string, opts *PackagesOptions) ([]*Package, error) {
+	if opts == nil {
+		opts = new(PackagesOptions)
+	}
+	parsedURLs, err := eval.parseURLs([]string{source})
+	if err != nil {
+		return nil, err
+	}
+	importedStorePaths, err := eval.importURLs(ctx, parsedURLs)
+	if err != nil {
+		return nil, err
+	}
+	fingerprint, err := packageSourceFingerprint(parsedURLs[0], importedStorePaths)
+	if err != nil {
+		return nil, err
+	}
+	// The index depends on which system's table is listed
+	// and on the store directory the derivations are written to.
+	fingerprint += " " + SystemTriple(eval.system) + " " + string(eval.storeDir)
+
+	if !opts.Refresh {
+		pkgs, found, err := eval.cachedPackages(ctx, source, fingerprint)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			log.Debugf(ctx, "Using cached package index for %s", source)
+			return pkgs, nil
+		}
+	}
+
+	var pkgs []*Package
+	var root *module
+	err = eval.lookupURLs(ctx, []string{source}, parsedURLs, importedStorePaths, func(l *lua.State, i int) error {
+		root = testModule(l, -1)
+		l.PushValue(-1)
+		defer l.Pop(1)
+		var err error
+		pkgs, err = collectPackages(ctx, l, SystemTriple(eval.system), nil, 0, pkgs)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(pkgs, func(pkg1, pkg2 *Package) int {
+		return cmp.Compare(pkg1.AttrPath, pkg2.AttrPath)
+	})
+
+	if root == nil {
+		log.Debugf(ctx, "Not saving package index for %s: not a module", source)
+		return pkgs, nil
+	}
+	if err := waitForImports(ctx, root); err != nil {
+		return nil, err
+	}
+	files, ok := root.transitiveFiles()
+	if !ok {
+		log.Debugf(ctx, "Not saving package index for %s: depends on inputs other than files", source)
+		return pkgs, nil
+	}
+	if err := eval.cachePackages(ctx, source, fingerprint, files, pkgs); err != nil {
+		log.Warnf(ctx, "Failed to save package index: %v", err)
+	}
+	return pkgs, nil
+}
+
+// waitForImports waits for mod and the modules it transitively imports
+// to finish evaluating.
+func waitForImports(ctx context.Context, mod *module) error {
+	visited := make(sets.Set[*module])
+	stack := []*module{mod}
+	for len(stack) > 0 {
+		m := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if visited.Has(m) {
+			continue
+		}
+		visited.Add(m)
+		select {
+		case <-m.finished:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if m.deps != nil {
+			m.deps.mu.Lock()
+			stack = append(stack, m.deps.imports...)
+			m.deps.mu.Unlock()
+		}
+	}
+	return nil
+}
+
+// packageSourceFingerprint returns a string that changes
+// when the file pointed to by u changes.
+func packageSourceFingerprint(u *url.URL, importedStorePaths map[string]zbstore.Path) (string, error) {
+	if u.Scheme != "" && u.Scheme != fileurl.Scheme {
+		// Downloads are content-addressed.
+		return "store:" + string(importedStorePaths[stripFragment(u).String()]), nil
+	}
+	path, err := URLToPath(u)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	return "file:" + stampFileInfo(info), nil
+}
+
+// collectPackages appends the derivations found in the value at the top of the stack to pkgs.
+// The value is replaced with its resolved value if it is a module.
+// Lazy tables are not forced:
+// doing so would evaluate every field of the package set,
+// which is what lazy tables exist to avoid.
+// If the top-level value has a table field named sysTriple,
+// then packages are collected from that table instead.
+func collectPackages(ctx context.Context, l *lua.State, sysTriple string, attrPath []string, depth int, pkgs []*Package) ([]*Package, error) {
+	for {
+		mod := testModule(l, -1)
+		if mod == nil {
+			break
+		}
+		l.Pop(1)
+		if err := waitForModule(ctx, l, mod); err != nil {
+			if depth == 0 {
+				return pkgs, err
+			}
+			log.Warnf(ctx, "Skipping %s: %v", strings.Join(attrPath, "/"), err)
+			l.PushNil()
+			return pkgs, nil
+		}
+	}
+
+	if drv := testDerivation(l, -1); drv != nil {
+		pkg := &Package{
+			AttrPath:    strings.Join(attrPath, "/"),
+			Name:        cmp.Or(drv.Env["pname"], drv.Name),
+			Version:     drv.Env["version"],
+			Description: cmp.Or(drv.Meta.Description, drv.Env["description"]),
+			DrvPath:     drv.Path,
+		}
+		return append(pkgs, pkg), nil
+	}
+	if testLazy(l, -1) != nil {
+		log.Debugf(ctx, "Skipping %s: lazy tables cannot be enumerated", strings.Join(attrPath, "/"))
+		return pkgs, nil
+	}
+	if l.Type(-1) != lua.TypeTable || depth >= maxPackageDepth {
+		return pkgs, nil
+	}
+	if !l.CheckStack(3) {
+		return pkgs, fmt.Errorf("%s: lua stack overflow", strings.Join(attrPath, "/"))
+	}
+
+	if depth == 0 {
+		if l.RawField(-1, sysTriple) == lua.TypeTable {
+			l.Replace(-2)
+		} else {
+			l.Pop(1)
+		}
+	}
+
+	l.PushNil()
+	for l.Next(-2) {
+		if l.Type(-2) != lua.TypeString {
+			l.Pop(1)
+			continue
+		}
+		k, _ := l.ToString(-2)
+		var err error
+		pkgs, err = collectPackages(ctx, l, sysTriple, append(attrPath[:len(attrPath):len(attrPath)], k), depth+1, pkgs)
+		if err != nil {
+			return pkgs, err
+		}
+		l.Pop(1)
+	}
+	return pkgs, nil
+}
+
+// cachedPackages returns the package index for source from the cache database.
+func (eval *Eval) cachedPackages(ctx context.Context, source, fingerprint string) (_ []*Package, found bool, err error) {
+	conn, err := eval.cachePool.Get(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	defer eval.cachePool.Put(conn)
+	defer sqlitex.Save(conn)(&err)
+
+	args := map[string]any{
+		":source":      source,
+		":fingerprint": fingerprint,
+	}
+	err = sqlitex.ExecuteTransientFS(conn, sqlFiles(), "packages/has_source.sql", &sqlitex.ExecOptions{
+		Named: args,
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			found = true
+			return nil
+		},
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("read package index for %s: %v", source, err)
+	}
+	if !found {
+		return nil, false, nil
+	}
+
+	files := make(map[string]fileHash)
+	err = sqlitex.ExecuteTransientFS(conn, sqlFiles(), "packages/find_files.sql", &sqlitex.ExecOptions{
+		Named: args,
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			var hash fileHash
+			if n := stmt.GetBytes("hash", hash[:]); n != len(hash) {
+				return fmt.Errorf("invalid hash for %s", stmt.GetText("path"))
+			}
+			files[stmt.GetText("path")] = hash
+			return nil
+		},
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("read package index for %s: %v", source, err)
+	}
+	for path, want := range files {
+		if got, err := hashFile(path); err != nil || got != want {
+			log.Debugf(ctx, "Package index for %s is out of date: %s changed", source, path)
+			return nil, false, nil
+		}
+	}
+
+	var pkgs []*Package
+	err = sqlitex.ExecuteTransientFS(conn, sqlFiles(), "packages/find.sql", &sqlitex.ExecOptions{
+		Named: args,
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			pkgs = append(pkgs, &Package{
+				AttrPath:    stmt.GetText("attr_path"),
+				Name:        stmt.GetText("name"),
+				Version:     stmt.GetText("version"),
+				Description: stmt.GetText("description"),
+				DrvPath:     zbstore.Path(stmt.GetText("drv_path")),
+			})
+			return nil
+		},
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("read package index for %s: %v", source, err)
+	}
+	return pkgs, true, nil
+}
+
+// cachePackages replaces the package index for source in the cache database.
+// files is the set of files that the index depends on.
+func (eval *Eval) cachePackages(ctx context.Context, source, fingerprint string, files map[string]fileHash, pkgs []*Package) (err error) {
+	conn, err := eval.cachePool.Get(ctx)
+	if err != nil {
+		return err
+	}
+	defer eval.cachePool.Put(conn)
+	defer sqlitex.Save(conn)(&err)
+
+	err = sqlitex.ExecuteScriptFS(conn, sqlFiles(), "packages/replace_source.sql", &sqlitex.ExecOptions{
+		Named: map[string]any{
+			":source":      source,
+			":fingerprint": fingerprint,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("save package index for %s: %v", source, err)
+	}
+
+	fileStmt, err := sqlitex.PrepareTransientFS(conn, sqlFiles(), "packages/insert_file.sql")
+	if err != nil {
+		return fmt.Errorf("save package index for %s: %v", source, err)
+	}
+	defer fileStmt.Finalize()
+	for path, hash := range files {
+		fileStmt.SetText(":source", source)
+		fileStmt.SetText(":path", path)
+		fileStmt.SetBytes(":hash", hash[:])
+		if _, err := fileStmt.Step(); err != nil {
+			return fmt.Errorf("save package index for %s: %v", source, err)
+		}
+		if err := fileStmt.Reset(); err != nil {
+			return fmt.Errorf("save package index for %s: %v", source, err)
+		}
+	}
+
+	stmt, err := sqlitex.PrepareTransientFS(conn, sqlFiles(), "packages/insert.sql")
+	if err != nil {
+		return fmt.Errorf("save package index for %s: %v", source, err)
+	}
+	defer stmt.Finalize()
+	for _, pkg := range pkgs {
+		stmt.SetText(":source", source)
+		stmt.SetText(":attr_path", pkg.AttrPath)
+		stmt.SetText(":name", pkg.Name)
+		stmt.SetText(":version", pkg.Version)
+		stmt.SetText(":description", pkg.Description)
+		stmt.SetText(":drv_path", string(pkg.DrvPath))
+		if _, err := stmt.Step(); err != nil {
+			return fmt.Errorf("save package index for %s: %v", source, err)
+		}
+		if err := stmt.Reset(); err != nil {
+			return fmt.Errorf("save package index for %s: %v", source, err)
+		}
+	}
+	return nil
+}
+
+// IndexedAttrPaths returns the attribute paths of the packages
+// recorded for source by [*Eval.Packages] in the cache database at cacheDBPath
+// without evaluating anything.
+// The index may be out of date.
+// If the source has not been indexed, IndexedAttrPaths returns an empty list.
+func IndexedAttrPaths(ctx context.Context, cacheDBPath, source string) ([]string, error) {
+	conn, err := sqlite.OpenConn(cacheDBPath, sqlite.OpenReadOnly)
+	if err != nil {
+		return nil, fmt.Errorf("read package index for %s: %v", source, err)
+	}
+	defer conn.Close()
+	conn.SetInterrupt(ctx.Done())
+
+	var attrPaths []string
+	err = sqlitex.ExecuteTransientFS(conn, sqlFiles(), "packages/attr_paths.sql", &sqlitex.ExecOptions{
+		Named: map[string]any{":source": source},
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			attrPaths = append(attrPaths, stmt.GetText("attr_path"))
+			return nil
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("read package index for %s: %v", source, err)
+	}
+	return attrPaths, nil
+}
@@ -0,0 +1,134 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package frontend
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"zb.256lights.llc/pkg/internal/backendtest"
+	"zb.256lights.llc/pkg/internal/testcontext"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+)
+
+func TestPackages(t *testing.T) {
+	ctx := testcontext.New(t)
+	storeDir := backendtest.NewStoreDirectory(t)
+
+	di := new(zbstorerpc.DeferredImporter)
+	_, store, err := backendtest.NewServer(ctx, t, storeDir, &backendtest.Options{
+		TempDir: t.TempDir(),
+		ClientOptions: zbstorerpc.CodecOptions{
+			Importer: di,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cacheDBPath := filepath.Join(t.TempDir(), "cache.db")
+	source := filepath.Join("testdata", "packages.lua")
+	want := []*Package{
+		{
+			AttrPath:    "hello",
+			Name:        "hello",
+			Version:     "1.0",
+			Description: "Print a friendly greeting",
+		},
+		{
+			AttrPath:    "tools/goodbye",
+			Name:        "goodbye",
+			Version:     "2.1",
+			Description: "Print a farewell",
+		},
+	}
+
+	for _, opts := range []*PackagesOptions{nil, nil, {Refresh: true}} {
+		eval, err := NewEval(&Options{
+			Store:          newTestRPCStore(store, di),
+			StoreDirectory: storeDir,
+			CacheDBPath:    cacheDBPath,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := eval.Packages(ctx, source, opts)
+		if err := eval.Close(); err != nil {
+			t.Error("eval.Close:", err)
+		}
+		if err != nil {
+			t.Fatalf("Packages(ctx, %q, %+v): %v", source, opts, err)
+		}
+		if diff := cmp.Diff(want, got, cmpopts.IgnoreFields(Package{}, "DrvPath")); diff != "" {
+			t.Errorf("Packages(ctx, %q, %+v) (-want +got):\n%s", source, opts, diff)
+		}
+		for _, pkg := range got {
+			if pkg.DrvPath.Dir() != storeDir || !pkg.DrvPath.IsDerivation() {
+				t.Errorf("%s.DrvPath = %q; want derivation in %s", pkg.AttrPath, pkg.DrvPath, storeDir)
+			}
+		}
+	}
+}
+
+func TestPackagesImportChanged(t *testing.T) {
+	ctx := testcontext.New(t)
+	storeDir := backendtest.NewStoreDirectory(t)
+
+	di := new(zbstorerpc.DeferredImporter)
+	_, store, err := backendtest.NewServer(ctx, t, storeDir, &backendtest.Options{
+		TempDir: t.TempDir(),
+		ClientOptions: zbstorerpc.CodecOptions{
+			Importer: di,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cacheDBPath := filepath.Join(t.TempDir(), "cache.db")
+	dir := t.TempDir()
+	source := filepath.Join(dir, "packages.lua")
+	const sourceContent = "return {\n" +
+		"  hello = derivation {\n" +
+		"    name = \"hello\";\n" +
+		"    description = import(\"description.lua\");\n" +
+		"    system = \"x86_64-linux\";\n" +
+		"    builder = \"/bin/sh\";\n" +
+		"  };\n" +
+		"}\n"
+	if err := os.WriteFile(source, []byte(sourceContent), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	descriptionPath := filepath.Join(dir, "description.lua")
+
+	for _, description := range []string{"Hello", "Hello", "Goodbye"} {
+		if err := os.WriteFile(descriptionPath, []byte("return \""+description+"\"\n"), 0o666); err != nil {
+			t.Fatal(err)
+		}
+		eval, err := NewEval(&Options{
+			Store:          newTestRPCStore(store, di),
+			StoreDirectory: storeDir,
+			CacheDBPath:    cacheDBPath,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := eval.Packages(ctx, source, nil)
+		if err := eval.Close(); err != nil {
+			t.Error("eval.Close:", err)
+		}
+		if err != nil {
+			t.Fatalf("Packages(ctx, %q, nil): %v", source, err)
+		}
+		want := []*Package{{
+			AttrPath:    "hello",
+			Name:        "hello",
+			Description: description,
+		}}
+		if diff := cmp.Diff(want, got, cmpopts.IgnoreFields(Package{}, "DrvPath")); diff != "" {
+			t.Errorf("Packages(ctx, %q, nil) with description %q (-want +got):\n%s", source, description, diff)
+		}
+	}
+}
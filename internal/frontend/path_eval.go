@@ -5,19 +5,18 @@ package frontend
 
 import (
 	"context"
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
-	"slices"
 	"strings"
 	"sync"
 
 	"zb.256lights.llc/pkg/internal/lua"
 	"zb.256lights.llc/pkg/internal/osutil"
-	"zb.256lights.llc/pkg/internal/zbstorerpc"
 	"zb.256lights.llc/pkg/sets"
 	"zb.256lights.llc/pkg/zbstore"
 	"zombiezen.com/go/log"
@@ -28,6 +27,38 @@ import (
 )
 
 func (eval *Eval) pathFunction(ctx context.Context, l *lua.State) (nResults int, err error) {
+	// The cache database cannot tell whether the files have changed
+	// without walking the path.
+	moduleDepsFromContext(ctx).markImpure()
+
+	args, err := eval.pathArgs(ctx, l)
+	if err != nil {
+		return 0, fmt.Errorf("path: %v", err)
+	}
+	storePath, err := eval.importPath(ctx, args.path, args.name, args.filter)
+	if err != nil {
+		return 0, fmt.Errorf("path: %v", err)
+	}
+	pushStorePath(l, storePath)
+	return 1, nil
+}
+
+// pathArguments is the parsed form of the argument to the path built-in
+// and other built-ins that take the same argument.
+type pathArguments struct {
+	// path is the absolute path to import.
+	path string
+	// name is the name of the store object.
+	name string
+	// filter is the Lua filter function or nil if none was given.
+	filter func(name string, typ fs.FileMode) (bool, error)
+}
+
+// pathArgs parses the argument at index 1,
+// which is either a path string
+// or a table with "path", "name", and "filter" fields.
+// The filter function (if present) is left on the stack.
+func (eval *Eval) pathArgs(ctx context.Context, l *lua.State) (*pathArguments, error) {
 	var p string
 	var pcontext sets.Set[string]
 	var name string
@@ -39,20 +70,20 @@ func (eval *Eval) pathFunction(ctx context.Context, l *lua.State) (nResults int,
 	case lua.TypeTable:
 		typ, err := l.Field(ctx, 1, "path")
 		if err != nil {
-			return 0, fmt.Errorf("path: %v", err)
+			return nil, err
 		}
 		if typ == lua.TypeNil {
-			return 0, lua.NewArgError(l, 1, "missing path")
+			return nil, lua.NewArgError(l, 1, "missing path")
 		}
 		p, pcontext, err = lua.ToString(ctx, l, -1)
 		if err != nil {
-			return 0, fmt.Errorf("path: %v", err)
+			return nil, err
 		}
 		l.Pop(1)
 
 		typ, err = l.Field(ctx, 1, "name")
 		if err != nil {
-			return 0, fmt.Errorf("path: %v", err)
+			return nil, err
 		}
 		if typ != lua.TypeNil {
 			name, _, _ = lua.ToString(ctx, l, -1)
@@ -61,32 +92,28 @@ func (eval *Eval) pathFunction(ctx context.Context, l *lua.State) (nResults int,
 
 		typ, err = l.Field(ctx, 1, "filter")
 		if err != nil {
-			return 0, fmt.Errorf("path: %v", err)
+			return nil, err
 		}
 		if typ != lua.TypeNil {
 			filterFuncIndex = l.Top()
 		}
 	default:
-		return 0, lua.NewTypeError(l, 1, "string or table")
+		return nil, lua.NewTypeError(l, 1, "string or table")
 	}
 
-	p, err = absSourcePath(l, eval.storeDir, p, pcontext)
+	p, err := absSourcePath(l, eval.storeDir, p, pcontext)
 	if err != nil {
-		return 0, fmt.Errorf("path: %v", err)
+		return nil, err
 	}
-	if name == "" {
-		name = filepath.Base(p)
+	args := &pathArguments{
+		path: p,
+		name: name,
 	}
-
-	cache, err := eval.cachePool.Get(ctx)
-	if err != nil {
-		return 0, err
+	if args.name == "" {
+		args.name = filepath.Base(p)
 	}
-	defer eval.cachePool.Put(cache)
-
-	var filterFunc func(name string, typ fs.FileMode) (bool, error)
 	if filterFuncIndex != 0 {
-		filterFunc = func(name string, typ fs.FileMode) (bool, error) {
+		args.filter = func(name string, typ fs.FileMode) (bool, error) {
 			defer l.SetTop(l.Top())
 			l.PushValue(filterFuncIndex)
 			l.PushString(name)
@@ -106,8 +133,25 @@ func (eval *Eval) pathFunction(ctx context.Context, l *lua.State) (nResults int,
 			return l.ToBoolean(-1), nil
 		}
 	}
-	if err := walkPath(ctx, cache, p, filterFunc); err != nil {
-		return 0, fmt.Errorf("path: %v", err)
+	return args, nil
+}
+
+// importPath imports the file or directory at the absolute path p
+// into the store as a source with the given name.
+// If filter is not nil, then it is called for each descendant of p
+// with its slash-separated path relative to p
+// to determine whether the descendant should be included.
+// importPath skips the import if the files match a previous import.
+func (eval *Eval) importPath(ctx context.Context, p, name string, filter func(name string, typ fs.FileMode) (bool, error)) (zbstore.Path, error) {
+	moduleDepsFromContext(ctx).addPath(p)
+	cache, err := eval.cachePool.Get(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer eval.cachePool.Put(cache)
+
+	if err := walkPath(ctx, cache, p, filter); err != nil {
+		return "", err
 	}
 	defer func() {
 		sqlitex.ExecuteScriptFS(cache, sqlFiles(), "walk/drop.sql", nil)
@@ -122,14 +166,14 @@ func (eval *Eval) pathFunction(ctx context.Context, l *lua.State) (nResults int,
 			log.Debugf(ctx, "%v", err)
 		} else {
 			log.Debugf(ctx, "Using existing store path %s", prevStorePath)
-			pushStorePath(l, prevStorePath)
-			return 1, nil
+			eval.countImport(true)
+			return prevStorePath, nil
 		}
 	}
 
 	exporter, closeExport, err := startExport(ctx, eval.store)
 	if err != nil {
-		return 0, fmt.Errorf("path: %v", err)
+		return "", err
 	}
 	defer closeExport(false)
 
@@ -207,12 +251,12 @@ func (eval *Eval) pathFunction(ctx context.Context, l *lua.State) (nResults int,
 	if err != nil {
 		pw.CloseWithError(err)
 		<-caChan
-		return 0, fmt.Errorf("path: %v", err)
+		return "", err
 	}
 	if err := w.Close(); err != nil {
 		pw.CloseWithError(err)
 		<-caChan
-		return 0, fmt.Errorf("path: %v", err)
+		return "", err
 	}
 
 	pw.Close()
@@ -220,17 +264,17 @@ func (eval *Eval) pathFunction(ctx context.Context, l *lua.State) (nResults int,
 
 	storePath, err := zbstore.FixedCAOutputPath(eval.storeDir, name, ca, zbstore.References{})
 	if err != nil {
-		return 0, fmt.Errorf("path: %v", err)
+		return "", err
 	}
 	err = exporter.Trailer(&zbstore.ExportTrailer{
 		StorePath:      storePath,
 		ContentAddress: ca,
 	})
 	if err != nil {
-		return 0, fmt.Errorf("path: %v", err)
+		return "", err
 	}
 	if err := closeExport(true); err != nil {
-		return 0, fmt.Errorf("path: %v", err)
+		return "", err
 	}
 
 	err = func() (err error) {
@@ -242,11 +286,11 @@ func (eval *Eval) pathFunction(ctx context.Context, l *lua.State) (nResults int,
 		return updateCache(cache, storePath)
 	}()
 	if err != nil {
-		return 0, fmt.Errorf("path: updating cache: %v", err)
+		return "", fmt.Errorf("updating cache: %v", err)
 	}
 
-	pushStorePath(l, storePath)
-	return 1, nil
+	eval.countImport(false)
+	return storePath, nil
 }
 
 func (eval *Eval) readFileFunction(ctx context.Context, l *lua.State) (int, error) {
@@ -265,6 +309,7 @@ func (eval *Eval) readFileFunction(ctx context.Context, l *lua.State) (int, erro
 	if err != nil {
 		return 0, fmt.Errorf("readFile: reading file: %v", err)
 	}
+	moduleDepsFromContext(ctx).addFile(absPath, sha256.Sum256([]byte(content)))
 
 	l.PushString(content)
 	return 1, nil
@@ -305,6 +350,7 @@ func (eval *Eval) toFileFunction(ctx context.Context, l *lua.State) (int, error)
 	} else {
 		// Already exists: no need to re-import.
 		log.Debugf(ctx, "Using existing store path %s", storePath)
+		eval.countImport(true)
 		pushStorePath(l, storePath)
 		return 1, nil
 	}
@@ -330,6 +376,7 @@ func (eval *Eval) toFileFunction(ctx context.Context, l *lua.State) (int, error)
 		return 0, fmt.Errorf("toFile %q: %v", name, err)
 	}
 
+	eval.countImport(false)
 	pushStorePath(l, storePath)
 	return 1, nil
 }
@@ -420,46 +467,19 @@ func absSourcePath(l *lua.State, dir zbstore.Directory, path string, context set
 // absSourcePathWithDeps takes a source path passed as an argument from Lua to Go
 // and resolves it relative to the calling function, taking into account
 // any dependencies the string may have.
+// Derivation outputs that the path refers to are built
+// (see [*Eval.realizePlaceholders]).
 func absSourcePathWithDeps(ctx context.Context, l *lua.State, eval *Eval, filename string, filenameContext sets.Set[string]) (path string, err error) {
 	// TODO(someday): If we have dependencies and we're using a non-local store,
 	// export the store object and read it.
-	toRealize := make(sets.Set[zbstore.OutputReference])
-	placeholders := make(map[string]zbstore.OutputReference)
-	for dep := range filenameContext {
-		c, err := parseContextString(dep)
-		if err != nil {
-			return "", fmt.Errorf("internal error: %w", err)
-		}
-		if c.outputReference.IsZero() {
-			continue
-		}
-		placeholder := zbstore.UnknownCAOutputPlaceholder(c.outputReference)
-		if !strings.Contains(filename, placeholder) {
-			continue
-		}
-		toRealize.Add(c.outputReference)
-		placeholders[placeholder] = c.outputReference
+	placeholders, err := outputPlaceholders(filename, filenameContext)
+	if err != nil {
+		return "", err
 	}
-	if toRealize.Len() > 0 {
-		results, err := eval.store.Realize(ctx, toRealize)
-		if err != nil {
-			return "", err
-		}
-
-		var rewrites []string
-		for placeholder, outputReference := range placeholders {
-			outputPath, err := zbstorerpc.FindRealizeOutput(slices.Values(results), outputReference)
-			if err != nil {
-				return "", err
-			}
-			if !outputPath.Valid || outputPath.X == "" {
-				return "", fmt.Errorf("realize %v: build failed", outputReference)
-			}
-			rewrites = append(rewrites, placeholder, string(outputPath.X))
-		}
-		filename = strings.NewReplacer(rewrites...).Replace(filename)
+	filename, err = eval.realizePlaceholders(ctx, callerPosition(l), filename, placeholders)
+	if err != nil {
+		return "", err
 	}
-
 	return absSourcePath(l, eval.storeDir, filename, filenameContext)
 }
 
@@ -111,6 +111,108 @@ function extract(args)
   }
 end
 
+---@param args {src: string, patches: string[], name: string?, strip: integer?}
+---@return derivation
+function applyPatches(args)
+  return derivation {
+    name = args.name or stripHash(fsBaseNameOf(tostring(args.src)));
+    builder = "builtin:patch";
+    system = "builtin";
+
+    src = args.src;
+    patches = args.patches;
+    strip = tostring(args.strip or 1);
+  }
+end
+
+---@param args {src: string, replacements: string[][], files: string[]?, name: string?}
+---@return derivation
+function substitute(args)
+  local builderArgs = {}
+  for _, file in ipairs(args.files or {}) do
+    builderArgs[#builderArgs + 1] = "--file"
+    builderArgs[#builderArgs + 1] = file
+  end
+  for i, r in ipairs(args.replacements) do
+    assertMsg(#r == 2, "substitute: replacements[" .. i .. "] must be a {from, to} pair")
+    builderArgs[#builderArgs + 1] = "--replace"
+    builderArgs[#builderArgs + 1] = r[1]
+    builderArgs[#builderArgs + 1] = r[2]
+  end
+  return derivation {
+    name = args.name or stripHash(fsBaseNameOf(tostring(args.src)));
+    builder = "builtin:substitute";
+    system = "builtin";
+    args = builderArgs;
+
+    src = args.src;
+  }
+end
+
+---@alias treeEntry {text: string, executable: boolean?}|{symlink: string}|{copy: string}
+
+---@param args {name: string, tree: table<string, treeEntry>?, paths: string[]?, ignoreCollisions: boolean?}
+---@return derivation
+function composeTree(args)
+  local builderArgs = {}
+  local names = {}
+  for name in pairs(args.tree or {}) do
+    names[#names + 1] = name
+  end
+  table.sort(names)
+  for _, name in ipairs(names) do
+    local entry = args.tree[name]
+    assertMsg(type(entry) == "table", "composeTree: tree[\"" .. name .. "\"] must be a table")
+    local n = #builderArgs
+    if entry.text ~= nil then
+      builderArgs[n + 1] = entry.executable and "--executable" or "--file"
+      builderArgs[n + 3] = entry.text
+    elseif entry.symlink ~= nil then
+      builderArgs[n + 1] = "--symlink"
+      builderArgs[n + 3] = tostring(entry.symlink)
+    elseif entry.copy ~= nil then
+      builderArgs[n + 1] = "--copy"
+      builderArgs[n + 3] = tostring(entry.copy)
+    else
+      error("composeTree: tree[\"" .. name .. "\"] must have a text, symlink, or copy field", 2)
+    end
+    builderArgs[n + 2] = name
+  end
+  for _, p in ipairs(args.paths or {}) do
+    builderArgs[#builderArgs + 1] = "--join"
+    builderArgs[#builderArgs + 1] = tostring(p)
+  end
+  return derivation {
+    name = args.name;
+    builder = "builtin:compose";
+    system = "builtin";
+    args = builderArgs;
+
+    ignoreCollisions = args.ignoreCollisions or false;
+  }
+end
+
+---@param name string
+---@param links table<string, string>
+---@return derivation
+function linkFarm(name, links)
+  local tree = {}
+  for path, target in pairs(links) do
+    tree[path] = { symlink = target }
+  end
+  return composeTree { name = name; tree = tree }
+end
+
+---@param args {name: string, paths: string[], ignoreCollisions: boolean?}
+---@return derivation
+function symlinkJoin(args)
+  return composeTree {
+    name = args.name;
+    paths = args.paths;
+    ignoreCollisions = args.ignoreCollisions;
+  }
+end
+
 ---@param args {url: string, hash: string, name: string?, stripFirstComponent: boolean?}
 ---@return derivation
 function fetchArchive(args)
@@ -0,0 +1,312 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package frontend
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"zb.256lights.llc/pkg/internal/lua"
+)
+
+// profileSampleInterval is the number of Lua instructions between samples.
+const profileSampleInterval = 1000
+
+// maxProfileDepth is the maximum number of stack frames recorded in a sample.
+const maxProfileDepth = 64
+
+// A Profiler collects samples of the Lua call stack during evaluation.
+// Pass a Profiler in [Options] to use it.
+// A Profiler is safe to use from multiple goroutines.
+type Profiler struct {
+	start time.Time
+
+	mu      sync.Mutex
+	samples map[string]*profileSample
+}
+
+// profileFrame is a single frame in a sampled call stack.
+type profileFrame struct {
+	function  string
+	filename  string
+	startLine int
+	line      int
+}
+
+type profileSample struct {
+	stack    []profileFrame
+	count    int64
+	duration time.Duration
+}
+
+// NewProfiler returns a new [Profiler] with no samples.
+func NewProfiler() *Profiler {
+	return &Profiler{
+		start:   time.Now(),
+		samples: make(map[string]*profileSample),
+	}
+}
+
+// hook returns a new [lua.Hook] that records samples to p.
+// Each Lua state must use its own hook,
+// since the hook attributes the time elapsed since its previous call
+// to the current call stack.
+// As a result, time spent in Go functions called from Lua
+// is attributed to the Lua code that called them.
+func (p *Profiler) hook() lua.Hook {
+	var last time.Time
+	return func(ctx context.Context, l *lua.State, event lua.HookMask) {
+		now := time.Now()
+		var elapsed time.Duration
+		if !last.IsZero() {
+			elapsed = now.Sub(last)
+		}
+		last = now
+		p.record(l, elapsed)
+	}
+}
+
+// record adds a sample of the call stack of l to p.
+func (p *Profiler) record(l *lua.State, elapsed time.Duration) {
+	var stack []profileFrame
+	key := new(strings.Builder)
+	for level := 0; level < maxProfileDepth; level++ {
+		db := l.Info(level)
+		if db == nil {
+			break
+		}
+		if db.What != "main" && db.What != "Lua" {
+			// Go functions do not have names or source locations.
+			continue
+		}
+		frame := newProfileFrame(db)
+		stack = append(stack, frame)
+		fmt.Fprintf(key, "%s\x00%d\x00%d\x00", frame.filename, frame.startLine, frame.line)
+	}
+	if len(stack) == 0 {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	sample := p.samples[key.String()]
+	if sample == nil {
+		sample = &profileSample{stack: stack}
+		p.samples[key.String()] = sample
+	}
+	sample.count++
+	sample.duration += elapsed
+}
+
+// newProfileFrame returns the frame for a Lua function.
+// Lua functions do not have intrinsic names,
+// so the frame's function name is the location of the function's definition
+// or the filename for the main chunk.
+func newProfileFrame(db *lua.Debug) profileFrame {
+	frame := profileFrame{
+		filename:  db.Source.String(),
+		startLine: max(db.LineDefined, 0),
+		line:      max(db.CurrentLine, 0),
+	}
+	if path, ok := db.Source.Filename(); ok {
+		frame.filename = path
+	}
+	if db.What == "main" {
+		frame.function = frame.filename
+	} else {
+		frame.function = fmt.Sprintf("%s:%d", frame.filename, db.LineDefined)
+	}
+	return frame
+}
+
+// WriteProfile writes the samples collected so far to w
+// as a gzip-compressed [pprof profile].
+// Each sample records the number of times a call stack was observed
+// and the wall-clock time attributed to it.
+//
+// [pprof profile]: https://github.com/google/pprof/blob/main/proto/profile.proto
+func (p *Profiler) WriteProfile(w io.Writer) error {
+	p.mu.Lock()
+	data := p.marshal(time.Now())
+	p.mu.Unlock()
+
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(data); err != nil {
+		return fmt.Errorf("write profile: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("write profile: %v", err)
+	}
+	return nil
+}
+
+// Field numbers from profile.proto.
+const (
+	profileSampleTypeField        protowire.Number = 1
+	profileSampleField            protowire.Number = 2
+	profileLocationField          protowire.Number = 4
+	profileFunctionField          protowire.Number = 5
+	profileStringTableField       protowire.Number = 6
+	profileTimeNanosField         protowire.Number = 9
+	profileDurationNanosField     protowire.Number = 10
+	profilePeriodTypeField        protowire.Number = 11
+	profilePeriodField            protowire.Number = 12
+	profileDefaultSampleTypeField protowire.Number = 14
+
+	valueTypeTypeField protowire.Number = 1
+	valueTypeUnitField protowire.Number = 2
+
+	sampleLocationIDField protowire.Number = 1
+	sampleValueField      protowire.Number = 2
+
+	locationIDField   protowire.Number = 1
+	locationLineField protowire.Number = 4
+
+	lineFunctionIDField protowire.Number = 1
+	lineLineField       protowire.Number = 2
+
+	functionIDField         protowire.Number = 1
+	functionNameField       protowire.Number = 2
+	functionSystemNameField protowire.Number = 3
+	functionFilenameField   protowire.Number = 4
+	functionStartLineField  protowire.Number = 5
+)
+
+// marshal encodes the profile as an uncompressed protocol buffer.
+// The caller must be holding onto p.mu.
+func (p *Profiler) marshal(end time.Time) []byte {
+	strs := []string{""}
+	strIndex := map[string]int64{"": 0}
+	str := func(s string) int64 {
+		i, ok := strIndex[s]
+		if !ok {
+			i = int64(len(strs))
+			strs = append(strs, s)
+			strIndex[s] = i
+		}
+		return i
+	}
+
+	type functionKey struct {
+		name      string
+		filename  string
+		startLine int
+	}
+	type locationKey struct {
+		functionID uint64
+		line       int
+	}
+	functionIDs := make(map[functionKey]uint64)
+	locationIDs := make(map[locationKey]uint64)
+	var functions, locations []byte
+
+	valueType := func(typ, unit string) []byte {
+		var b []byte
+		b = protowire.AppendTag(b, valueTypeTypeField, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(str(typ)))
+		b = protowire.AppendTag(b, valueTypeUnitField, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(str(unit)))
+		return b
+	}
+
+	var buf []byte
+	buf = protowire.AppendTag(buf, profileSampleTypeField, protowire.BytesType)
+	buf = protowire.AppendBytes(buf, valueType("samples", "count"))
+	buf = protowire.AppendTag(buf, profileSampleTypeField, protowire.BytesType)
+	buf = protowire.AppendBytes(buf, valueType("time", "nanoseconds"))
+
+	for _, sample := range sortedProfileSamples(p.samples) {
+		var locationIDList []byte
+		for _, frame := range sample.stack {
+			fk := functionKey{frame.function, frame.filename, frame.startLine}
+			fid, ok := functionIDs[fk]
+			if !ok {
+				fid = uint64(len(functionIDs) + 1)
+				functionIDs[fk] = fid
+				var f []byte
+				f = protowire.AppendTag(f, functionIDField, protowire.VarintType)
+				f = protowire.AppendVarint(f, fid)
+				f = protowire.AppendTag(f, functionNameField, protowire.VarintType)
+				f = protowire.AppendVarint(f, uint64(str(frame.function)))
+				f = protowire.AppendTag(f, functionSystemNameField, protowire.VarintType)
+				f = protowire.AppendVarint(f, uint64(str(frame.function)))
+				f = protowire.AppendTag(f, functionFilenameField, protowire.VarintType)
+				f = protowire.AppendVarint(f, uint64(str(frame.filename)))
+				f = protowire.AppendTag(f, functionStartLineField, protowire.VarintType)
+				f = protowire.AppendVarint(f, uint64(frame.startLine))
+				functions = protowire.AppendTag(functions, profileFunctionField, protowire.BytesType)
+				functions = protowire.AppendBytes(functions, f)
+			}
+
+			lk := locationKey{fid, frame.line}
+			lid, ok := locationIDs[lk]
+			if !ok {
+				lid = uint64(len(locationIDs) + 1)
+				locationIDs[lk] = lid
+				var line []byte
+				line = protowire.AppendTag(line, lineFunctionIDField, protowire.VarintType)
+				line = protowire.AppendVarint(line, fid)
+				line = protowire.AppendTag(line, lineLineField, protowire.VarintType)
+				line = protowire.AppendVarint(line, uint64(frame.line))
+				var loc []byte
+				loc = protowire.AppendTag(loc, locationIDField, protowire.VarintType)
+				loc = protowire.AppendVarint(loc, lid)
+				loc = protowire.AppendTag(loc, locationLineField, protowire.BytesType)
+				loc = protowire.AppendBytes(loc, line)
+				locations = protowire.AppendTag(locations, profileLocationField, protowire.BytesType)
+				locations = protowire.AppendBytes(locations, loc)
+			}
+			locationIDList = protowire.AppendVarint(locationIDList, lid)
+		}
+
+		var values []byte
+		values = protowire.AppendVarint(values, uint64(sample.count))
+		values = protowire.AppendVarint(values, uint64(sample.duration.Nanoseconds()))
+		var s []byte
+		s = protowire.AppendTag(s, sampleLocationIDField, protowire.BytesType)
+		s = protowire.AppendBytes(s, locationIDList)
+		s = protowire.AppendTag(s, sampleValueField, protowire.BytesType)
+		s = protowire.AppendBytes(s, values)
+		buf = protowire.AppendTag(buf, profileSampleField, protowire.BytesType)
+		buf = protowire.AppendBytes(buf, s)
+	}
+	buf = append(buf, locations...)
+	buf = append(buf, functions...)
+
+	buf = protowire.AppendTag(buf, profileTimeNanosField, protowire.VarintType)
+	buf = protowire.AppendVarint(buf, uint64(p.start.UnixNano()))
+	buf = protowire.AppendTag(buf, profileDurationNanosField, protowire.VarintType)
+	buf = protowire.AppendVarint(buf, uint64(end.Sub(p.start).Nanoseconds()))
+	buf = protowire.AppendTag(buf, profilePeriodTypeField, protowire.BytesType)
+	buf = protowire.AppendBytes(buf, valueType("instructions", "count"))
+	buf = protowire.AppendTag(buf, profilePeriodField, protowire.VarintType)
+	buf = protowire.AppendVarint(buf, profileSampleInterval)
+	buf = protowire.AppendTag(buf, profileDefaultSampleTypeField, protowire.VarintType)
+	buf = protowire.AppendVarint(buf, uint64(str("time")))
+
+	// The string table must come last, since the other fields add to it.
+	for _, s := range strs {
+		buf = protowire.AppendTag(buf, profileStringTableField, protowire.BytesType)
+		buf = protowire.AppendString(buf, s)
+	}
+	return buf
+}
+
+// sortedProfileSamples returns the samples in m sorted by key
+// so that profiles are deterministic.
+func sortedProfileSamples(m map[string]*profileSample) []*profileSample {
+	samples := make([]*profileSample, 0, len(m))
+	for _, k := range slices.Sorted(maps.Keys(m)) {
+		samples = append(samples, m[k])
+	}
+	return samples
+}
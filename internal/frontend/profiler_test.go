@@ -0,0 +1,110 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package frontend
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"slices"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"zb.256lights.llc/pkg/internal/backendtest"
+	"zb.256lights.llc/pkg/internal/testcontext"
+)
+
+func TestProfiler(t *testing.T) {
+	ctx := testcontext.New(t)
+	profiler := NewProfiler()
+	// Profiling does not use the store.
+	eval, err := NewEval(&Options{
+		StoreDirectory: backendtest.NewStoreDirectory(t),
+		Profiler:       profiler,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := eval.Close(); err != nil {
+			t.Error("eval.Close:", err)
+		}
+	}()
+
+	const expr = "(function()\n" +
+		"  local s = 0\n" +
+		"  for i = 1, 100000 do\n" +
+		"    s = s + i\n" +
+		"  end\n" +
+		"  return s\n" +
+		"end)()"
+	result, err := eval.Expression(ctx, expr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := int64(5000050000); result != want {
+		t.Errorf("result = %v; want %d", result, want)
+	}
+
+	if len(profiler.samples) == 0 {
+		t.Fatal("no samples recorded")
+	}
+	var total int64
+	for _, sample := range profiler.samples {
+		total += sample.count
+		// The expression is a tail call, so the function is the only frame.
+		if got, want := len(sample.stack), 1; got != want {
+			t.Errorf("len(sample.stack) = %d; want %d", got, want)
+			continue
+		}
+		if leaf := sample.stack[0]; leaf.startLine != 1 || leaf.line < 2 || leaf.line > 6 {
+			t.Errorf("sample.stack[0] = %+v; want function defined on line 1 executing lines 2-6", leaf)
+		}
+	}
+	// The loop executes at least 2 instructions per iteration.
+	if minSamples := int64(2 * 100000 / profileSampleInterval); total < minSamples {
+		t.Errorf("recorded %d samples; want at least %d", total, minSamples)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := profiler.WriteProfile(buf); err != nil {
+		t.Fatal("WriteProfile:", err)
+	}
+	zr, err := gzip.NewReader(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var strs []string
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			t.Fatal("parse profile:", protowire.ParseError(n))
+		}
+		data = data[n:]
+		if num == profileStringTableField && typ == protowire.BytesType {
+			s, n := protowire.ConsumeString(data)
+			if n < 0 {
+				t.Fatal("parse profile:", protowire.ParseError(n))
+			}
+			strs = append(strs, s)
+		}
+		n = protowire.ConsumeFieldValue(num, typ, data)
+		if n < 0 {
+			t.Fatal("parse profile:", protowire.ParseError(n))
+		}
+		data = data[n:]
+	}
+	if len(strs) == 0 || strs[0] != "" {
+		t.Errorf("string table = %q; want to start with empty string", strs)
+	}
+	for _, want := range []string{"samples", "time", "nanoseconds"} {
+		if !slices.Contains(strs, want) {
+			t.Errorf("string table = %q; missing %q", strs, want)
+		}
+	}
+}
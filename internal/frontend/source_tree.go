@@ -0,0 +1,327 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package frontend
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"zb.256lights.llc/pkg/internal/lua"
+	"zb.256lights.llc/pkg/sets"
+	"zombiezen.com/go/log"
+)
+
+// zbIgnoreFileName is the name of the file that lists paths
+// that sourceTree should exclude,
+// in addition to those excluded by version control.
+const zbIgnoreFileName = ".zbignore"
+
+// sourceTreeFunction is the implementation of the sourceTree built-in.
+// It takes the same arguments as path,
+// but excludes files that version control ignores.
+func (eval *Eval) sourceTreeFunction(ctx context.Context, l *lua.State) (nResults int, err error) {
+	// Like path, the cache database cannot tell whether the files have changed
+	// without walking the path.
+	moduleDepsFromContext(ctx).markImpure()
+
+	args, err := eval.pathArgs(ctx, l)
+	if err != nil {
+		return 0, fmt.Errorf("sourceTree: %v", err)
+	}
+	ignore, err := newSourceIgnorer(ctx, args.path)
+	if err != nil {
+		return 0, fmt.Errorf("sourceTree: %v", err)
+	}
+	filter := ignore.filter
+	if userFilter := args.filter; userFilter != nil {
+		filter = func(name string, typ fs.FileMode) (bool, error) {
+			if keep, err := ignore.filter(name, typ); !keep || err != nil {
+				return keep, err
+			}
+			return userFilter(name, typ)
+		}
+	}
+	storePath, err := eval.importPath(ctx, args.path, args.name, filter)
+	if err != nil {
+		return 0, fmt.Errorf("sourceTree: %v", err)
+	}
+	pushStorePath(l, storePath)
+	return 1, nil
+}
+
+// sourceIgnorer decides which files in a source tree
+// should be excluded from an import.
+type sourceIgnorer struct {
+	root string
+	// ignoreFiles is the list of ignore file names
+	// that are read from each directory.
+	ignoreFiles []string
+	// patterns is the list of patterns read from ignore files so far,
+	// in the order that they should be applied.
+	patterns []ignorePattern
+	// loadedDirs is the set of directories (relative to root)
+	// whose ignore files have been read.
+	loadedDirs sets.Set[string]
+	// tracked is the set of paths (relative to root)
+	// that git reports as tracked or untracked-but-not-ignored.
+	// Any descendants of a path in tracked are also kept.
+	// If tracked is nil, then git was not available
+	// and .gitignore files are read directly.
+	tracked sets.Set[string]
+	// trackedDirs is the set of directories (relative to root)
+	// that contain a path in tracked.
+	trackedDirs sets.Set[string]
+}
+
+// newSourceIgnorer returns a new [*sourceIgnorer] for the given absolute path.
+// If root is inside a git working copy,
+// then the files are the ones that git ls-files reports
+// minus any files excluded by .zbignore files.
+// Otherwise, .gitignore and .zbignore files are read directly.
+func newSourceIgnorer(ctx context.Context, root string) (*sourceIgnorer, error) {
+	ig := &sourceIgnorer{
+		root:        root,
+		ignoreFiles: []string{".gitignore", zbIgnoreFileName},
+		loadedDirs:  make(sets.Set[string]),
+	}
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		// Files are imported as-is.
+		return ig, nil
+	}
+
+	files, err := gitListFiles(ctx, root)
+	if err != nil {
+		log.Debugf(ctx, "Reading ignore files directly for %s: %v", root, err)
+	} else {
+		ig.ignoreFiles = []string{zbIgnoreFileName}
+		ig.tracked = make(sets.Set[string])
+		ig.trackedDirs = make(sets.Set[string])
+		for _, name := range files {
+			ig.tracked.Add(name)
+			for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+				ig.trackedDirs.Add(dir)
+			}
+		}
+	}
+	if err := ig.load(""); err != nil {
+		return nil, err
+	}
+	return ig, nil
+}
+
+// gitListFiles returns the slash-separated paths relative to dir
+// of the files in dir that git would not ignore.
+// gitListFiles returns an error if git is not installed
+// or dir is not in a git working copy.
+func gitListFiles(ctx context.Context, dir string) ([]string, error) {
+	c := exec.CommandContext(ctx, "git", "ls-files", "-z", "--cached", "--others", "--exclude-standard")
+	c.Dir = dir
+	stderr := new(bytes.Buffer)
+	c.Stderr = stderr
+	out, err := c.Output()
+	if err != nil {
+		if msg := bytes.TrimSpace(stderr.Bytes()); len(msg) > 0 {
+			return nil, fmt.Errorf("git ls-files: %s", msg)
+		}
+		return nil, fmt.Errorf("git ls-files: %v", err)
+	}
+	var files []string
+	for name := range strings.SplitSeq(string(out), "\x00") {
+		// Untracked nested repositories are listed with a trailing slash.
+		if name = strings.TrimSuffix(name, "/"); name != "" {
+			files = append(files, name)
+		}
+	}
+	return files, nil
+}
+
+// filter reports whether the given slash-separated path relative to the root
+// should be included in the import.
+// It is suitable for passing to [walkPath].
+func (ig *sourceIgnorer) filter(name string, typ fs.FileMode) (bool, error) {
+	isDir := typ.IsDir()
+	if ig.tracked != nil {
+		if !ig.isTracked(name) && !(isDir && ig.trackedDirs.Has(name)) {
+			return false, nil
+		}
+	} else if path.Base(name) == ".git" {
+		return false, nil
+	}
+
+	parent := path.Dir(name)
+	if parent == "." {
+		parent = ""
+	}
+	if err := ig.load(parent); err != nil {
+		return false, err
+	}
+	ignored := false
+	for i := range ig.patterns {
+		pat := &ig.patterns[i]
+		if pat.match(name, isDir) {
+			ignored = !pat.negate
+		}
+	}
+	return !ignored, nil
+}
+
+// isTracked reports whether name or one of its ancestors
+// is in ig.tracked.
+func (ig *sourceIgnorer) isTracked(name string) bool {
+	for ; name != "."; name = path.Dir(name) {
+		if ig.tracked.Has(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// load reads the ignore files in the given directory
+// (a slash-separated path relative to the root, or the empty string for the root)
+// if they have not been read already.
+// Patterns in deeper directories are appended after their parents' patterns
+// because [walkPath] visits parents first.
+func (ig *sourceIgnorer) load(dir string) error {
+	if ig.loadedDirs.Has(dir) {
+		return nil
+	}
+	ig.loadedDirs.Add(dir)
+	for _, fileName := range ig.ignoreFiles {
+		p := filepath.Join(ig.root, filepath.FromSlash(dir), fileName)
+		data, err := os.ReadFile(p)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		ig.patterns = append(ig.patterns, parseIgnoreFile(dir, data)...)
+	}
+	return nil
+}
+
+// ignorePattern is a single line of a .gitignore or .zbignore file.
+type ignorePattern struct {
+	// base is the directory that contains the ignore file
+	// as a slash-separated path relative to the root.
+	// It is empty for the root directory.
+	base string
+	// pattern is the slash-separated glob pattern.
+	// Each element is matched with [path.Match],
+	// except for "**", which matches zero or more elements
+	// (or one or more elements at the end of the pattern).
+	pattern string
+	// anchored is true if the pattern is matched against the full path relative to base
+	// instead of just the last element.
+	anchored bool
+	// dirOnly is true if the pattern only matches directories.
+	dirOnly bool
+	// negate is true if the pattern re-includes paths excluded by earlier patterns.
+	negate bool
+}
+
+// parseIgnoreFile parses the patterns in a file with .gitignore syntax.
+func parseIgnoreFile(base string, data []byte) []ignorePattern {
+	var patterns []ignorePattern
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		if pat, ok := parseIgnorePattern(base, s.Text()); ok {
+			patterns = append(patterns, pat)
+		}
+	}
+	return patterns
+}
+
+// parseIgnorePattern parses a single line of a file with .gitignore syntax.
+// It returns false if the line is blank or a comment.
+func parseIgnorePattern(base, line string) (ignorePattern, bool) {
+	line = strings.TrimSuffix(line, "\r")
+	if trimmed := strings.TrimRight(line, " "); trimmed != line && strings.HasSuffix(trimmed, `\`) {
+		// Escaped trailing space.
+		line = trimmed + " "
+	} else {
+		line = trimmed
+	}
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignorePattern{}, false
+	}
+	pat := ignorePattern{base: base}
+	if rest, ok := strings.CutPrefix(line, "!"); ok {
+		pat.negate = true
+		line = rest
+	}
+	if rest, ok := strings.CutSuffix(line, "/"); ok {
+		pat.dirOnly = true
+		line = rest
+	}
+	if rest, ok := strings.CutPrefix(line, "/"); ok {
+		pat.anchored = true
+		line = rest
+	} else if strings.Contains(line, "/") {
+		pat.anchored = true
+	}
+	if line == "" {
+		return ignorePattern{}, false
+	}
+	pat.pattern = line
+	return pat, true
+}
+
+// match reports whether the pattern matches the given slash-separated path
+// relative to the root.
+func (pat *ignorePattern) match(name string, isDir bool) bool {
+	if pat.dirOnly && !isDir {
+		return false
+	}
+	if pat.base != "" {
+		var ok bool
+		name, ok = strings.CutPrefix(name, pat.base+"/")
+		if !ok {
+			return false
+		}
+	}
+	if !pat.anchored {
+		name = path.Base(name)
+	}
+	return matchGlobPath(strings.Split(pat.pattern, "/"), strings.Split(name, "/"))
+}
+
+// matchGlobPath reports whether the elements of a path
+// match the elements of a glob pattern.
+func matchGlobPath(pattern, elems []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			if len(pattern) == 1 {
+				// A trailing "**" matches everything inside, but not the directory itself.
+				return len(elems) > 0
+			}
+			for i := len(elems); i >= 0; i-- {
+				if matchGlobPath(pattern[1:], elems[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(elems) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], elems[0]); !ok {
+			return false
+		}
+		pattern, elems = pattern[1:], elems[1:]
+	}
+	return len(elems) == 0
+}
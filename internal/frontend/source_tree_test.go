@@ -0,0 +1,237 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package frontend
+
+import (
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"zb.256lights.llc/pkg/internal/backendtest"
+	"zb.256lights.llc/pkg/internal/lualex"
+	"zb.256lights.llc/pkg/internal/testcontext"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+)
+
+func TestSourceTree(t *testing.T) {
+	files := map[string]string{
+		".gitignore":     "build/\n*.log\n",
+		".zbignore":      "secret.txt\n/docs/*.md\n",
+		"a.txt":          "Hello, World!\n",
+		"secret.txt":     "hunter2\n",
+		"x.log":          "log\n",
+		"build/out.o":    "object\n",
+		"docs/index.md":  "# Docs\n",
+		"docs/image.png": "PNG\n",
+		"sub/.gitignore": "!keep.log\n",
+		"sub/keep.log":   "keep\n",
+		"sub/other.log":  "other\n",
+		"sub/docs/a.md":  "# Sub\n",
+	}
+	want := map[string]string{
+		".gitignore":     files[".gitignore"],
+		".zbignore":      files[".zbignore"],
+		"a.txt":          files["a.txt"],
+		"docs":           "",
+		"docs/image.png": files["docs/image.png"],
+		"sub":            "",
+		"sub/.gitignore": files["sub/.gitignore"],
+		"sub/keep.log":   files["sub/keep.log"],
+		"sub/docs":       "",
+		"sub/docs/a.md":  files["sub/docs/a.md"],
+	}
+
+	tests := []struct {
+		name  string
+		setup func(t *testing.T, dir string)
+	}{
+		{
+			name: "IgnoreFiles",
+			setup: func(t *testing.T, dir string) {
+				// Not a valid repository, so it should be skipped like any other .git directory.
+				if err := os.MkdirAll(filepath.Join(dir, ".git"), 0o777); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(filepath.Join(dir, ".git", "config"), []byte("[core]\n"), 0o666); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "Git",
+			setup: func(t *testing.T, dir string) {
+				if _, err := exec.LookPath("git"); err != nil {
+					t.Skip("git not found:", err)
+				}
+				for _, args := range [][]string{
+					{"init", "--quiet"},
+					{"add", "a.txt", "sub/keep.log"},
+				} {
+					c := exec.Command("git", args...)
+					c.Dir = dir
+					if out, err := c.CombinedOutput(); err != nil {
+						t.Fatalf("git %q: %v\n%s", args, err, out)
+					}
+				}
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := testcontext.New(t)
+			storeDir := backendtest.NewStoreDirectory(t)
+			srcDir := t.TempDir()
+			for name, content := range files {
+				p := filepath.Join(srcDir, filepath.FromSlash(name))
+				if err := os.MkdirAll(filepath.Dir(p), 0o777); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(p, []byte(content), 0o666); err != nil {
+					t.Fatal(err)
+				}
+			}
+			test.setup(t, srcDir)
+
+			di := new(zbstorerpc.DeferredImporter)
+			_, store, err := backendtest.NewServer(ctx, t, storeDir, &backendtest.Options{
+				TempDir: t.TempDir(),
+				ClientOptions: zbstorerpc.CodecOptions{
+					Importer: di,
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			eval, err := NewEval(&Options{
+				Store:          newTestRPCStore(store, di),
+				StoreDirectory: storeDir,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				if err := eval.Close(); err != nil {
+					t.Error("eval.Close:", err)
+				}
+			}()
+
+			got, err := eval.Expression(ctx, "sourceTree("+lualex.Quote(srcDir)+")")
+			if err != nil {
+				t.Fatal(err)
+			}
+			gotString, ok := got.(string)
+			if !ok {
+				t.Fatalf("expression result is %T; want string", got)
+			}
+			if diff := cmp.Diff(want, readTree(t, gotString)); diff != "" {
+				t.Errorf("imported files (-want +got):\n%s", diff)
+			}
+
+			got, err = eval.Expression(ctx, "sourceTree{path = "+lualex.Quote(srcDir)+`; filter = function(name) return name ~= "a.txt" end }`)
+			if err != nil {
+				t.Fatal(err)
+			}
+			gotString, ok = got.(string)
+			if !ok {
+				t.Fatalf("expression result is %T; want string", got)
+			}
+			wantFiltered := make(map[string]string)
+			for name, content := range want {
+				if name != "a.txt" {
+					wantFiltered[name] = content
+				}
+			}
+			if diff := cmp.Diff(wantFiltered, readTree(t, gotString)); diff != "" {
+				t.Errorf("imported files with filter (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// readTree returns the contents of the regular files in dir
+// keyed by their slash-separated paths relative to dir.
+// Directories are included with empty content.
+func readTree(tb testing.TB, dir string) map[string]string {
+	tb.Helper()
+	tree := make(map[string]string)
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || path == dir {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			tree[filepath.ToSlash(rel)] = ""
+			return nil
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		tree[filepath.ToSlash(rel)] = string(content)
+		return nil
+	})
+	if err != nil {
+		tb.Error(err)
+	}
+	return tree
+}
+
+func TestIgnorePatternMatch(t *testing.T) {
+	tests := []struct {
+		base  string
+		line  string
+		name  string
+		isDir bool
+		want  bool
+	}{
+		{line: "*.log", name: "x.log", want: true},
+		{line: "*.log", name: "a/b/x.log", want: true},
+		{line: "*.log", name: "x.txt", want: false},
+		{line: "build/", name: "build", isDir: true, want: true},
+		{line: "build/", name: "build", isDir: false, want: false},
+		{line: "build/", name: "src/build", isDir: true, want: true},
+		{line: "/build", name: "build", isDir: true, want: true},
+		{line: "/build", name: "src/build", isDir: true, want: false},
+		{line: "doc/*.md", name: "doc/a.md", want: true},
+		{line: "doc/*.md", name: "doc/sub/a.md", want: false},
+		{line: "doc/*.md", name: "x/doc/a.md", want: false},
+		{line: "**/foo", name: "foo", want: true},
+		{line: "**/foo", name: "a/b/foo", want: true},
+		{line: "a/**/b", name: "a/b", want: true},
+		{line: "a/**/b", name: "a/x/y/b", want: true},
+		{line: "a/**", name: "a/x/y", want: true},
+		{line: "a/**", name: "a", isDir: true, want: false},
+		{base: "sub", line: "*.log", name: "sub/x.log", want: true},
+		{base: "sub", line: "*.log", name: "x.log", want: false},
+		{base: "sub", line: "/x.log", name: "sub/x.log", want: true},
+		{base: "sub", line: "/x.log", name: "sub/y/x.log", want: false},
+		{line: `\#foo`, name: "#foo", want: true},
+		{line: `\!foo`, name: "!foo", want: true},
+		{line: `foo\ `, name: "foo ", want: true},
+		{line: "foo   ", name: "foo", want: true},
+	}
+	for _, test := range tests {
+		pat, ok := parseIgnorePattern(test.base, test.line)
+		if !ok {
+			t.Errorf("parseIgnorePattern(%q, %q) = _, false; want true", test.base, test.line)
+			continue
+		}
+		if got := pat.match(test.name, test.isDir); got != test.want {
+			t.Errorf("pattern %q in %q matches %q (isDir=%t) = %t; want %t", test.line, test.base, test.name, test.isDir, got, test.want)
+		}
+	}
+
+	for _, line := range []string{"", "   ", "# comment", "/", "!"} {
+		if pat, ok := parseIgnorePattern("", line); ok {
+			t.Errorf("parseIgnorePattern(\"\", %q) = %+v, true; want false", line, pat)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package frontend
+
+import (
+	"sync/atomic"
+
+	"zb.256lights.llc/pkg/internal/lua"
+)
+
+// Stats counts the work done during evaluation.
+// Pass Stats in [Options] to use it.
+// A Stats is safe to use from multiple goroutines.
+type Stats struct {
+	alloc lua.AllocStats
+
+	states      atomic.Int64
+	derivations atomic.Int64
+
+	modules            atomic.Int64
+	moduleCacheLookups atomic.Int64
+	moduleCacheHits    atomic.Int64
+
+	imports       atomic.Int64
+	importsReused atomic.Int64
+}
+
+// StatsSummary is a snapshot of the counters in a [Stats].
+type StatsSummary struct {
+	// States is the number of Lua states created.
+	// Each module and each top-level expression runs in its own state.
+	States int64
+	// Tables, Functions, Userdata, and Strings are the number of Lua values
+	// of each kind that were allocated.
+	// Strings only includes strings created by concatenation.
+	Tables    int64
+	Functions int64
+	Userdata  int64
+	Strings   int64
+	// Derivations is the number of derivations written to the store.
+	Derivations int64
+	// Modules is the number of distinct modules imported.
+	Modules int64
+	// ModuleCacheLookups is the number of modules
+	// whose values were looked up in the cache database.
+	ModuleCacheLookups int64
+	// ModuleCacheHits is the number of modules
+	// whose values were loaded from the cache database
+	// instead of being evaluated.
+	ModuleCacheHits int64
+	// Imports is the number of store objects created
+	// by the path, sourceTree, and toFile built-ins.
+	Imports int64
+	// ImportsReused is the number of Imports
+	// that were already present in the store.
+	ImportsReused int64
+}
+
+// NewStats returns a new [Stats] with all counters set to zero.
+func NewStats() *Stats {
+	return new(Stats)
+}
+
+// Summary returns the current values of the counters in s.
+func (s *Stats) Summary() *StatsSummary {
+	return &StatsSummary{
+		States:             s.states.Load(),
+		Tables:             s.alloc.Tables.Load(),
+		Functions:          s.alloc.Functions.Load(),
+		Userdata:           s.alloc.Userdata.Load(),
+		Strings:            s.alloc.Strings.Load(),
+		Derivations:        s.derivations.Load(),
+		Modules:            s.modules.Load(),
+		ModuleCacheLookups: s.moduleCacheLookups.Load(),
+		ModuleCacheHits:    s.moduleCacheHits.Load(),
+		Imports:            s.imports.Load(),
+		ImportsReused:      s.importsReused.Load(),
+	}
+}
+
+// ModuleCacheHitRate returns the fraction of module cache lookups
+// that found a usable value.
+// It returns zero if no lookups were made.
+func (summary *StatsSummary) ModuleCacheHitRate() float64 {
+	return ratio(summary.ModuleCacheHits, summary.ModuleCacheLookups)
+}
+
+// ImportReuseRate returns the fraction of imports
+// that were already present in the store.
+// It returns zero if no imports were made.
+func (summary *StatsSummary) ImportReuseRate() float64 {
+	return ratio(summary.ImportsReused, summary.Imports)
+}
+
+// countImport records an import in eval's statistics, if any.
+// reused is true if the store object already existed.
+func (eval *Eval) countImport(reused bool) {
+	if eval.stats == nil {
+		return
+	}
+	eval.stats.imports.Add(1)
+	if reused {
+		eval.stats.importsReused.Add(1)
+	}
+}
+
+func ratio(n, d int64) float64 {
+	if d == 0 {
+		return 0
+	}
+	return float64(n) / float64(d)
+}
@@ -0,0 +1,85 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package frontend
+
+import (
+	"testing"
+
+	"zb.256lights.llc/pkg/internal/backendtest"
+	"zb.256lights.llc/pkg/internal/testcontext"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+)
+
+func TestStats(t *testing.T) {
+	ctx := testcontext.New(t)
+	storeDir := backendtest.NewStoreDirectory(t)
+
+	di := new(zbstorerpc.DeferredImporter)
+	_, store, err := backendtest.NewServer(ctx, t, storeDir, &backendtest.Options{
+		TempDir: t.TempDir(),
+		ClientOptions: zbstorerpc.CodecOptions{
+			Importer: di,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := NewStats()
+	eval, err := NewEval(&Options{
+		Store:          newTestRPCStore(store, di),
+		StoreDirectory: storeDir,
+		Stats:          stats,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := eval.Close(); err != nil {
+			t.Error("eval.Close:", err)
+		}
+	}()
+
+	const expr = "(function()\n" +
+		"  local f = toFile(\"hello.txt\", \"Hello\" .. \", World!\")\n" +
+		"  toFile(\"hello.txt\", \"Hello, World!\")\n" +
+		"  return derivation {\n" +
+		"    name = \"hello\";\n" +
+		"    system = \"x86_64-linux\";\n" +
+		"    builder = \"/bin/sh\";\n" +
+		"    src = f;\n" +
+		"  }\n" +
+		"end)()"
+	if _, err := eval.Expression(ctx, expr); err != nil {
+		t.Fatal(err)
+	}
+
+	got := stats.Summary()
+	if got.States < 1 {
+		t.Errorf("States = %d; want >=1", got.States)
+	}
+	if got.Tables < 1 {
+		t.Errorf("Tables = %d; want >=1", got.Tables)
+	}
+	if got.Functions < 1 {
+		t.Errorf("Functions = %d; want >=1", got.Functions)
+	}
+	if got.Strings < 1 {
+		t.Errorf("Strings = %d; want >=1", got.Strings)
+	}
+	if want := int64(1); got.Derivations != want {
+		t.Errorf("Derivations = %d; want %d", got.Derivations, want)
+	}
+	if want := int64(2); got.Imports != want {
+		t.Errorf("Imports = %d; want %d", got.Imports, want)
+	}
+	if want := int64(1); got.ImportsReused != want {
+		t.Errorf("ImportsReused = %d; want %d", got.ImportsReused, want)
+	}
+	if got, want := got.ImportReuseRate(), 0.5; got != want {
+		t.Errorf("ImportReuseRate() = %g; want %g", got, want)
+	}
+	if got, want := got.ModuleCacheHitRate(), 0.0; got != want {
+		t.Errorf("ModuleCacheHitRate() = %g; want %g", got, want)
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package frontend
+
+import (
+	"context"
+	"fmt"
+
+	"zb.256lights.llc/pkg/internal/lua"
+	"zb.256lights.llc/pkg/internal/lualex"
+	"zb.256lights.llc/pkg/internal/system"
+)
+
+// systemPredicates is the set of zb.system functions
+// that test a property of a system.
+var systemPredicates = map[string]func(sys system.System) bool{
+	"isLinux":   func(sys system.System) bool { return sys.OS.IsLinux() },
+	"isDarwin":  func(sys system.System) bool { return sys.OS.IsDarwin() },
+	"isMacOS":   func(sys system.System) bool { return sys.OS.IsMacOS() },
+	"isWindows": func(sys system.System) bool { return sys.OS.IsWindows() },
+	"isX86":     func(sys system.System) bool { return sys.Arch.IsX86() },
+	"isARM":     func(sys system.System) bool { return sys.Arch.IsARM() },
+	"isRISCV":   func(sys system.System) bool { return sys.Arch.IsRISCV() },
+	"is32Bit":   func(sys system.System) bool { return sys.Arch.Is32Bit() },
+	"is64Bit":   func(sys system.System) bool { return sys.Arch.Is64Bit() },
+}
+
+// pushSystemTable pushes the zb.system table onto the stack.
+func (eval *Eval) pushSystemTable(l *lua.State) error {
+	reg := map[string]lua.Function{
+		"parse": parseSystemFunction,
+	}
+	for name, pred := range systemPredicates {
+		reg[name] = eval.systemPredicateFunction(pred)
+	}
+	lua.NewPureLib(l, reg)
+
+	l.PushString(SystemTriple(eval.system))
+	if err := l.RawSetField(-2, "current"); err != nil {
+		return err
+	}
+	l.PushString(SystemTriple(system.Current()))
+	if err := l.RawSetField(-2, "host"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// systemPredicateFunction returns the implementation of a zb.system predicate.
+// The predicate's optional argument is a system string
+// that defaults to the system being evaluated for.
+func (eval *Eval) systemPredicateFunction(pred func(sys system.System) bool) lua.Function {
+	return func(ctx context.Context, l *lua.State) (int, error) {
+		sys := eval.system
+		if !l.IsNoneOrNil(1) {
+			var err error
+			sys, err = checkSystem(l, 1)
+			if err != nil {
+				return 0, err
+			}
+		}
+		l.PushBoolean(pred(sys))
+		return 1, nil
+	}
+}
+
+// parseSystemFunction is the implementation of zb.system.parse.
+func parseSystemFunction(ctx context.Context, l *lua.State) (int, error) {
+	sys, err := checkSystem(l, 1)
+	if err != nil {
+		return 0, err
+	}
+	l.CreateTable(0, 5)
+	fields := []struct {
+		name  string
+		value string
+	}{
+		{"arch", sys.Arch.String()},
+		{"vendor", sys.Vendor.String()},
+		{"os", sys.OS.String()},
+		{"env", sys.Env.String()},
+		{"triple", SystemTriple(sys)},
+	}
+	for _, f := range fields {
+		l.PushString(f.value)
+		if err := l.RawSetField(-2, f.name); err != nil {
+			return 0, err
+		}
+	}
+	return 1, nil
+}
+
+// checkSystem checks whether the function argument arg is a valid system string
+// and returns the parsed system.
+func checkSystem(l *lua.State, arg int) (system.System, error) {
+	s, err := lua.CheckString(l, arg)
+	if err != nil {
+		return system.System{}, err
+	}
+	sys, err := system.Parse(s)
+	if err != nil {
+		return system.System{}, lua.NewArgError(l, arg, fmt.Sprintf("invalid system %s", lualex.Quote(s)))
+	}
+	return sys, nil
+}
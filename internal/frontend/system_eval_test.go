@@ -0,0 +1,138 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package frontend
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"zb.256lights.llc/pkg/internal/backendtest"
+	"zb.256lights.llc/pkg/internal/system"
+	"zb.256lights.llc/pkg/internal/testcontext"
+)
+
+func TestSystemTable(t *testing.T) {
+	tests := []struct {
+		name   string
+		system string
+		expr   string
+		want   any
+	}{
+		{name: "Current", system: "aarch64-apple-darwin", expr: `zb.system.current`, want: "aarch64-apple-darwin"},
+		{name: "Default", expr: `zb.system.current == zb.system.host`, want: true},
+		{name: "Host", system: "aarch64-apple-darwin", expr: `zb.system.host`, want: SystemTriple(system.Current())},
+		{name: "IsDarwin", system: "aarch64-apple-darwin", expr: `zb.system.isDarwin()`, want: true},
+		{name: "IsLinux", system: "aarch64-apple-darwin", expr: `zb.system.isLinux()`, want: false},
+		{name: "IsARM", system: "aarch64-apple-darwin", expr: `zb.system.isARM() and zb.system.is64Bit()`, want: true},
+		{name: "IsWindowsArg", system: "aarch64-apple-darwin", expr: `zb.system.isWindows("x86_64-windows")`, want: true},
+		{name: "NilArg", system: "x86_64-windows", expr: `zb.system.isWindows(nil)`, want: true},
+		{name: "Is32BitArg", expr: `zb.system.is32Bit("i686-linux") and zb.system.isX86("i686-linux")`, want: true},
+		{name: "IsRISCVArg", expr: `zb.system.isRISCV("riscv64-linux")`, want: true},
+		{name: "Parse", expr: `zb.system.parse("x86_64-linux").triple`, want: "x86_64-unknown-linux"},
+		{name: "ParseOS", expr: `zb.system.parse("aarch64-apple-darwin").os`, want: "darwin"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := testcontext.New(t)
+			var sys system.System
+			if test.system != "" {
+				var err error
+				sys, err = system.Parse(test.system)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			// The zb library does not use the store.
+			eval, err := NewEval(&Options{
+				StoreDirectory: backendtest.NewStoreDirectory(t),
+				System:         sys,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				if err := eval.Close(); err != nil {
+					t.Error("eval.Close:", err)
+				}
+			}()
+
+			got, err := eval.Expression(ctx, test.expr)
+			if err != nil {
+				t.Fatalf("%s: %v", test.expr, err)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("%s (-want +got):\n%s", test.expr, diff)
+			}
+		})
+	}
+
+	t.Run("InvalidSystem", func(t *testing.T) {
+		ctx := testcontext.New(t)
+		eval, err := NewEval(&Options{
+			StoreDirectory: backendtest.NewStoreDirectory(t),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			if err := eval.Close(); err != nil {
+				t.Error("eval.Close:", err)
+			}
+		}()
+
+		for _, expr := range []string{`zb.system.isLinux("x86_64")`, `zb.system.parse("bogus")`} {
+			if _, err := eval.Expression(ctx, expr); err == nil {
+				t.Errorf("%s did not return an error", expr)
+			} else if !strings.Contains(err.Error(), "invalid system") {
+				t.Errorf("%s: %v; want error containing %q", expr, err, "invalid system")
+			}
+		}
+	})
+}
+
+func TestSystemPredicates(t *testing.T) {
+	// Each system lists the predicates that are true for it.
+	// All other predicates must be false.
+	tests := []struct {
+		system string
+		want   []string
+	}{
+		{"x86_64-linux", []string{"isLinux", "isX86", "is64Bit"}},
+		{"i686-linux", []string{"isLinux", "isX86", "is32Bit"}},
+		{"aarch64-linux", []string{"isLinux", "isARM", "is64Bit"}},
+		{"riscv64-linux", []string{"isLinux", "isRISCV", "is64Bit"}},
+		{"x86_64-windows", []string{"isWindows", "isX86", "is64Bit"}},
+		{"aarch64-apple-darwin", []string{"isDarwin", "isMacOS", "isARM", "is64Bit"}},
+		{"x86_64-apple-macos", []string{"isDarwin", "isMacOS", "isX86", "is64Bit"}},
+	}
+
+	ctx := testcontext.New(t)
+	eval, err := NewEval(&Options{
+		StoreDirectory: backendtest.NewStoreDirectory(t),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := eval.Close(); err != nil {
+			t.Error("eval.Close:", err)
+		}
+	}()
+
+	for _, test := range tests {
+		for name := range systemPredicates {
+			expr := `zb.system.` + name + `("` + test.system + `")`
+			got, err := eval.Expression(ctx, expr)
+			if err != nil {
+				t.Errorf("%s: %v", expr, err)
+				continue
+			}
+			if want := slices.Contains(test.want, name); got != want {
+				t.Errorf("%s = %v; want %t", expr, got, want)
+			}
+		}
+	}
+}
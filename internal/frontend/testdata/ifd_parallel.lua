@@ -0,0 +1,51 @@
+-- Copyright 2026 The zb Authors
+-- SPDX-License-Identifier: MIT
+
+---@param system string
+---@return boolean
+local function isWindows(system)
+  return system:find("-windows$") ~= nil
+end
+
+---@param name string
+---@param content string
+---@param system string
+---@return derivation
+local function luaFile(name, content, system)
+  if isWindows(system) then
+    return derivation {
+      name = name;
+      builder = [[C:\Windows\System32\WindowsPowerShell\v1.0\powershell.exe]];
+      args = {
+        "-Command",
+        [[${env:content} | Out-File -Encoding ascii -FilePath ${env:out}]],
+      };
+      content = content;
+      system = system;
+    }
+  end
+  return derivation {
+    name = name;
+    builder = "/bin/sh";
+    args = {
+      "-c",
+      [[echo "$content" > "$out"]],
+    };
+    content = content;
+    system = system;
+  }
+end
+
+local function forSystem(_, currentSystem)
+  local hello = luaFile("hello.lua", [[return "Hello, "]], currentSystem)
+  local world = luaFile("world.lua", [[return "World!"]], currentSystem)
+  -- import returns immediately, so both builds start
+  -- before the concatenation waits for either module.
+  return import(hello.out) .. import(world.out)
+end
+
+local t = {}
+setmetatable(t, {
+  __index = forSystem;
+})
+return t
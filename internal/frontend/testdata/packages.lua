@@ -0,0 +1,32 @@
+-- Copyright 2026 The zb Authors
+-- SPDX-License-Identifier: MIT
+
+local function pkg(pname, version, description)
+  return derivation {
+    name = pname.."-"..version;
+    pname = pname;
+    version = version;
+    description = description;
+    system = "x86_64-linux";
+    builder = "/bin/sh";
+  }
+end
+
+return {
+  hello = pkg("hello", "1.0", "Print a friendly greeting");
+  tools = {
+    goodbye = derivation {
+      name = "goodbye-2.1";
+      pname = "goodbye";
+      version = "2.1";
+      system = "x86_64-linux";
+      builder = "/bin/sh";
+      meta = {
+        description = "Print a farewell";
+        license = "MIT";
+      };
+    };
+  };
+  answer = 42;
+  broken = lazy(function() error("should not be evaluated") end);
+}
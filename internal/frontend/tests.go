@@ -0,0 +1,96 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package frontend
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"zb.256lights.llc/pkg/internal/lua"
+)
+
+// TestFileSuffix is the suffix of Lua files that contain tests.
+const TestFileSuffix = "_test.lua"
+
+// TestResult is the outcome of a test declared with the Lua test function.
+type TestResult struct {
+	// Name is the name passed to the test function.
+	Name string
+	// Error is the error raised by the test or nil if the test passed.
+	Error error
+	// Duration is how long the test took to run.
+	Duration time.Duration
+}
+
+// RunTestFile runs the Lua file at path in a new Lua state
+// that has an additional global function, test(name, f),
+// which declares a test.
+// After the file's main chunk finishes,
+// RunTestFile calls each declared test function in the order they were declared.
+// A test fails if its function raises an error (e.g. with assert).
+// RunTestFile returns an error if the file cannot be loaded
+// or its main chunk raises an error.
+func (eval *Eval) RunTestFile(ctx context.Context, path string) ([]*TestResult, error) {
+	l, err := eval.newState()
+	if err != nil {
+		return nil, err
+	}
+	defer l.Close()
+
+	const testsIndex = 1
+	const messageHandlerIndex = 2
+	l.CreateTable(0, 0)
+	l.PushPureFunction(0, messageHandler)
+
+	var names []string
+	running := false
+	l.PushValue(testsIndex)
+	l.PushClosure(1, func(ctx context.Context, l *lua.State) (int, error) {
+		if running {
+			return 0, fmt.Errorf("%stest: cannot declare tests while tests are running", lua.Where(l, 1))
+		}
+		if l.Type(1) != lua.TypeString {
+			return 0, fmt.Errorf("%sbad argument #1 to 'test' (string expected, got %v)", lua.Where(l, 1), l.Type(1))
+		}
+		if l.Type(2) != lua.TypeFunction {
+			return 0, fmt.Errorf("%sbad argument #2 to 'test' (function expected, got %v)", lua.Where(l, 1), l.Type(2))
+		}
+		name, _ := l.ToString(1)
+		names = append(names, name)
+		l.PushValue(2)
+		if err := l.RawSetIndex(lua.UpvalueIndex(1), int64(len(names))); err != nil {
+			return 0, err
+		}
+		return 0, nil
+	})
+	if err := l.SetGlobal(ctx, "test"); err != nil {
+		return nil, err
+	}
+
+	if err := loadFile(l, path); err != nil {
+		return nil, err
+	}
+	if err := l.PCall(ctx, 0, 0, messageHandlerIndex); err != nil {
+		return nil, err
+	}
+
+	running = true
+	results := make([]*TestResult, 0, len(names))
+	for i, name := range names {
+		l.RawIndex(testsIndex, int64(i+1))
+		start := time.Now()
+		err := l.PCall(ctx, 0, 0, messageHandlerIndex)
+		results = append(results, &TestResult{
+			Name:     name,
+			Error:    err,
+			Duration: time.Since(start),
+		})
+		l.SetTop(messageHandlerIndex)
+		if ctx.Err() != nil {
+			return results, ctx.Err()
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,90 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package frontend
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"zb.256lights.llc/pkg/internal/backendtest"
+	"zb.256lights.llc/pkg/internal/testcontext"
+)
+
+func TestRunTestFile(t *testing.T) {
+	ctx := testcontext.New(t)
+	// Tests that do not create derivations do not use the store.
+	eval, err := NewEval(&Options{
+		StoreDirectory: backendtest.NewStoreDirectory(t),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := eval.Close(); err != nil {
+			t.Error("eval.Close:", err)
+		}
+	}()
+
+	t.Run("Results", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "foo_test.lua")
+		const source = "local x = 42\n" +
+			"test(\"passes\", function()\n" +
+			"  assert(x == 42)\n" +
+			"end)\n" +
+			"test(\"fails\", function()\n" +
+			"  assertMsg(x == 0, \"x is not zero\")\n" +
+			"end)\n" +
+			"test(\"nested\", function()\n" +
+			"  test(\"inner\", function() end)\n" +
+			"end)\n"
+		if err := os.WriteFile(path, []byte(source), 0o666); err != nil {
+			t.Fatal(err)
+		}
+
+		results, err := eval.RunTestFile(ctx, path)
+		if err != nil {
+			t.Fatal("RunTestFile:", err)
+		}
+		if len(results) != 3 {
+			t.Fatalf("len(results) = %d; want 3", len(results))
+		}
+		for i, want := range []string{"passes", "fails", "nested"} {
+			if got := results[i].Name; got != want {
+				t.Errorf("results[%d].Name = %q; want %q", i, got, want)
+			}
+		}
+		if results[0].Error != nil {
+			t.Errorf("results[0].Error = %v; want <nil>", results[0].Error)
+		}
+		if results[1].Error == nil {
+			t.Error("results[1].Error = <nil>; want error")
+		} else if got, want := results[1].Error.Error(), path+":6: assertion failed: x is not zero"; !strings.HasPrefix(got, want) {
+			t.Errorf("results[1].Error = %q; want to start with %q", got, want)
+		}
+		if results[2].Error == nil {
+			t.Error("results[2].Error = <nil>; want error")
+		} else if got, want := results[2].Error.Error(), "cannot declare tests"; !strings.Contains(got, want) {
+			t.Errorf("results[2].Error = %q; want to contain %q", got, want)
+		}
+	})
+
+	t.Run("MainChunkError", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "bad_test.lua")
+		const source = "test(\"never runs\", function() end)\n" +
+			"error(\"boom\")\n"
+		if err := os.WriteFile(path, []byte(source), 0o666); err != nil {
+			t.Fatal(err)
+		}
+
+		results, err := eval.RunTestFile(ctx, path)
+		if err == nil {
+			t.Fatalf("RunTestFile(...) = %v, <nil>; want error", results)
+		}
+		if got, want := err.Error(), "boom"; !strings.Contains(got, want) {
+			t.Errorf("RunTestFile(...) error = %q; want to contain %q", got, want)
+		}
+	})
+}
@@ -11,7 +11,9 @@ import (
 	"iter"
 	"net/http"
 	"net/url"
+	"os"
 	slashpath "path"
+	"path/filepath"
 	"strings"
 	"sync"
 
@@ -31,12 +33,43 @@ import (
 // URLs imports the Lua file for each URL,
 // and uses the fragment from each URL (see [parseFragment])
 // to determine the Lua value to return.
+// A URL that names a local directory refers to its entry file
+// (see [Options.EntryFiles]).
+// A URL that names a remote archive (like a .tar.gz file)
+// is downloaded and extracted,
+// and refers to the entry file at the top level of the archive
+// unless the fragment names a file in the archive.
 func (eval *Eval) URLs(ctx context.Context, urls []string) ([]any, error) {
 	if len(urls) == 0 {
 		return nil, nil
 	}
+	parsedURLs, err := eval.parseURLs(urls)
+	if err != nil {
+		return nil, err
+	}
+	importedStorePaths, err := eval.importURLs(ctx, parsedURLs)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]any, len(urls))
+	err = eval.lookupURLs(ctx, urls, parsedURLs, importedStorePaths, func(l *lua.State, i int) error {
+		val, err := luaToGo(ctx, l)
+		if err != nil {
+			return fmt.Errorf("%s: %v", urls[i], err)
+		}
+		result[i] = val
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
 
-	// Parse URLs first before doing any expensive operations.
+// parseURLs parses and validates the URLs passed to [*Eval.URLs]
+// before doing any expensive operations.
+// Local directories are replaced with their entry files.
+func (eval *Eval) parseURLs(urls []string) ([]*url.URL, error) {
 	parsedURLs := make([]*url.URL, len(urls))
 	for i, s := range urls {
 		u, err := ParseURL(s)
@@ -48,17 +81,32 @@ func (eval *Eval) URLs(ctx context.Context, urls []string) ([]any, error) {
 			return nil, fmt.Errorf("%s: %v", s, err)
 		}
 		if u.Scheme == "" || u.Scheme == fileurl.Scheme {
-			if _, err := URLToPath(u); err != nil {
+			path, err := URLToPath(u)
+			if err != nil {
 				return nil, err
 			}
 			if archiveEntry != "" {
 				return nil, fmt.Errorf("%s: archive path not valid for local file", s)
 			}
+			entryPath, err := findEntryFile(path, eval.entryFiles)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", s, err)
+			}
+			if entryPath != path {
+				entryURL := fileurl.FromPath(entryPath)
+				entryURL.Fragment = u.Fragment
+				entryURL.RawFragment = u.RawFragment
+				u = entryURL
+			}
 		}
 		parsedURLs[i] = u
 	}
+	return parsedURLs, nil
+}
 
-	// Download and import any URLs.
+// importURLs downloads and imports any non-local URLs into the store.
+// The returned map is keyed by the URL string without its fragment.
+func (eval *Eval) importURLs(ctx context.Context, parsedURLs []*url.URL) (map[string]zbstore.Path, error) {
 	grp, grpCtx := errgroup.WithContext(ctx)
 	grp.SetLimit(2)
 	var mu sync.Mutex
@@ -91,21 +139,28 @@ func (eval *Eval) URLs(ctx context.Context, urls []string) ([]any, error) {
 	if err := grp.Wait(); err != nil {
 		return nil, err
 	}
+	return importedStorePaths, nil
+}
 
+// lookupURLs imports the Lua file for each of the parsed URLs
+// and calls f with the value named by each URL's fragment
+// at the top of the stack.
+// f must leave the stack as it found it.
+func (eval *Eval) lookupURLs(ctx context.Context, urls []string, parsedURLs []*url.URL, importedStorePaths map[string]zbstore.Path, f func(l *lua.State, i int) error) error {
 	// Start imports. These will run concurrently.
 	l, err := eval.newState()
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer l.Close()
 	l.CreateTable(len(parsedURLs), 0)
 	tableStackIndex := l.Top()
 	if _, err := l.Global(ctx, "import"); err != nil {
-		return nil, fmt.Errorf("internal error: _G.import: %v", err)
+		return fmt.Errorf("internal error: _G.import: %v", err)
 	}
 	importStackIndex := l.Top()
 	if _, err := l.Global(ctx, "extract"); err != nil {
-		return nil, fmt.Errorf("internal error: _G.extract: %v", err)
+		return fmt.Errorf("internal error: _G.extract: %v", err)
 	}
 	extractStackIndex := l.Top()
 	for i, u := range parsedURLs {
@@ -113,42 +168,50 @@ func (eval *Eval) URLs(ctx context.Context, urls []string) ([]any, error) {
 		if u.Scheme == "" || u.Scheme == fileurl.Scheme {
 			path, err := URLToPath(u)
 			if err != nil {
-				// Should have already been verified above.
-				return nil, fmt.Errorf("internal error: %v", err)
+				// Should have already been verified by parseURLs.
+				return fmt.Errorf("internal error: %v", err)
 			}
 			l.PushString(path)
 		} else {
 			storePath := importedStorePaths[stripFragment(u).String()]
 			l.PushStringContext(string(storePath), sets.New(contextValue{path: storePath}.String()))
-			if archiveFile, _, _ := parseFragment(u.Fragment); archiveFile != "" {
+			if archiveFile, _, _ := parseFragment(u.Fragment); archiveFile != "" || isArchiveURL(u) {
 				// Call extract{src=storePath}.
 				l.CreateTable(0, 1)
 				l.Insert(-2)
 				if err := l.RawSetField(-2, "src"); err != nil {
-					return nil, fmt.Errorf("internal error: {src=%s}: %v", lualex.Quote(string(storePath)), err)
+					return fmt.Errorf("internal error: {src=%s}: %v", lualex.Quote(string(storePath)), err)
 				}
 				l.PushValue(extractStackIndex)
 				l.Insert(-2)
 				if err := l.PCall(ctx, 1, 1, 0); err != nil {
-					return nil, fmt.Errorf("extract{src=%s}: %v", lualex.Quote(string(storePath)), err)
+					return fmt.Errorf("extract{src=%s}: %v", lualex.Quote(string(storePath)), err)
+				}
+				l.PushString("/")
+				if err := l.Concat(ctx, 2); err != nil {
+					return fmt.Errorf("internal error: concat extract{...}..\"/\": %v", err)
 				}
-				l.PushString("/" + archiveFile)
+				if archiveFile == "" {
+					archiveFile, err = eval.archiveEntryFile(ctx, l)
+					if err != nil {
+						return fmt.Errorf("%v: %v", stripFragment(u).Redacted(), err)
+					}
+				}
+				l.PushString(archiveFile)
 				if err := l.Concat(ctx, 2); err != nil {
-					return nil, fmt.Errorf("internal error: concat extract{...}..%s: %v",
-						lualex.Quote(archiveFile), err)
+					return fmt.Errorf("internal error: concat extract{...}..%s: %v",
+						lualex.Quote("/"+archiveFile), err)
 				}
 			}
 		}
 		if err := l.PCall(ctx, 1, 1, 0); err != nil {
-			return nil, err
+			return err
 		}
 		l.RawSetIndex(tableStackIndex, int64(i+1))
 	}
 
 	// Perform lookups on each import.
-	result := make([]any, len(urls))
-	sys := system.Current()
-	sysTriple := SystemTriple(sys)
+	sysTriple := SystemTriple(eval.system)
 	l.PushClosure(0, messageHandler)
 	for i, u := range parsedURLs {
 		l.RawIndex(tableStackIndex, int64(i+1))
@@ -157,17 +220,50 @@ func (eval *Eval) URLs(ctx context.Context, urls []string) ([]any, error) {
 			l.PushValue(-1)
 		} else {
 			if err := searchKeyPaths(ctx, l, fieldPath, []string{sysTriple}, -2); err != nil {
-				return nil, fmt.Errorf("%s: %v", urls[i], err)
+				return fmt.Errorf("%s: %v", urls[i], err)
 			}
 		}
-		val, err := luaToGo(ctx, l)
-		if err != nil {
-			return nil, fmt.Errorf("%s: %v", urls[i], err)
+		if err := f(l, i); err != nil {
+			return err
 		}
-		result[i] = val
 		l.Pop(2)
 	}
-	return result, nil
+	return nil
+}
+
+// archiveEntryFile returns the name of the entry file (see [Options.EntryFiles])
+// at the top level of the extracted archive directory
+// whose path is at the top of l's stack.
+// The archive is extracted in order to find the file.
+func (eval *Eval) archiveEntryFile(ctx context.Context, l *lua.State) (string, error) {
+	dir, _ := l.ToString(-1)
+	placeholders, err := outputPlaceholders(dir, l.StringContext(-1))
+	if err != nil {
+		return "", err
+	}
+	realDir, err := eval.realizePlaceholders(ctx, Position{}, dir, placeholders)
+	if err != nil {
+		return "", err
+	}
+	for _, name := range eval.entryFiles {
+		if info, err := os.Stat(filepath.Join(realDir, name)); err == nil && !info.IsDir() {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("no %s at top of archive (add #path/to/file.lua: to the URL to use a different file)",
+		strings.Join(eval.entryFiles, " or "))
+}
+
+// isArchiveURL reports whether u names a file that can be extracted
+// with the extract function, based on its file extension.
+func isArchiveURL(u *url.URL) bool {
+	name := strings.ToLower(slashpath.Base(u.Path))
+	for _, ext := range []string{".tar", ".tar.gz", ".tgz", ".tar.bz2", ".tbz2", ".zip"} {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
 }
 
 func (eval *Eval) importURL(ctx context.Context, u *url.URL) (zbstore.Path, error) {
@@ -325,6 +421,7 @@ func searchKeyPaths(ctx context.Context, l *lua.State, fieldPath string, prefixe
 
 // followKeyPath is a [lua.Function] that accesses a slash-separated field path
 // and returns the value.
+// Each element of the path may also be a dot-separated path (see [indexDottedKey]).
 // If a nil is encountered along the way, nil is returned.
 // The first argument to followKeyPath is the root object
 // and the second argument is the string containing the slash-separated field path.
@@ -341,7 +438,7 @@ func followKeyPath(ctx context.Context, l *lua.State) (int, error) {
 			return 1, nil
 		}
 		var err error
-		lastType, err = l.Field(ctx, -1, k)
+		lastType, err = indexDottedKey(ctx, l, k)
 		if err != nil {
 			return 0, err
 		}
@@ -350,6 +447,50 @@ func followKeyPath(ctx context.Context, l *lua.State) (int, error) {
 	return 1, nil
 }
 
+// indexDottedKey pushes the value of the field k
+// of the value at the top of the stack onto the stack
+// and returns the type of the pushed value.
+// If the field is nil (or raises an error) and k contains dots,
+// then k is treated as a dot-separated path:
+// the longest prefix of k before a dot that names a non-nil field is used,
+// and the rest of k is looked up in that field's value.
+// This allows "pkgs.python3.11" to find the "python3.11" field of the "pkgs" field.
+// If no interpretation of k finds a non-nil value,
+// then indexDottedKey pushes nil and returns the first error encountered, if any.
+func indexDottedKey(ctx context.Context, l *lua.State, k string) (lua.Type, error) {
+	if !l.CheckStack(2) {
+		return lua.TypeNil, errors.New("key path too deep")
+	}
+	obj := l.Top()
+	typ, firstErr := l.Field(ctx, obj, k)
+	if firstErr == nil && typ != lua.TypeNil || !strings.Contains(k, ".") {
+		return typ, firstErr
+	}
+	l.SetTop(obj)
+
+	for i := strings.LastIndexByte(k, '.'); i > 0; i = strings.LastIndexByte(k[:i], '.') {
+		prefix, rest := k[:i], k[i+1:]
+		if rest == "" {
+			continue
+		}
+		if typ, err := l.Field(ctx, obj, prefix); err != nil || typ == lua.TypeNil {
+			l.SetTop(obj)
+			continue
+		}
+		typ, err := indexDottedKey(ctx, l, rest)
+		if err == nil && typ != lua.TypeNil {
+			l.Remove(obj + 1)
+			return typ, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		l.SetTop(obj)
+	}
+	l.PushNil()
+	return lua.TypeNil, firstErr
+}
+
 // ParseURL parses a URL, but permits some amount of sloppiness for Windows paths.
 func ParseURL(s string) (*url.URL, error) {
 	return fileurl.Parse(s)
@@ -364,7 +505,7 @@ func URLToPath(u *url.URL) (string, error) {
 // parseFragment parses an unescaped fragment string (excluding the "#")
 // and splits it at the last colon (":") into an archive member path
 // and a slash-separated key path.
-// parseFragment returns an error if the keyPath has leading, trailing, or repeated dots.
+// parseFragment returns an error if the keyPath has leading or trailing slashes.
 func parseFragment(s string) (archivePath, keyPath string, err error) {
 	if i := strings.LastIndexByte(s, ':'); i >= 0 {
 		archivePath = s[:i]
@@ -384,6 +525,32 @@ func parseFragment(s string) (archivePath, keyPath string, err error) {
 	return archivePath, keyPath, nil
 }
 
+// AllOutputs is the output selector that selects every output of a derivation.
+const AllOutputs = "*"
+
+// CutOutputSelector splits an installable URL of the form "url^dev,out"
+// into the URL and the list of output names after the caret.
+// The selector may also be [AllOutputs].
+// If the URL does not end in an output selector,
+// then CutOutputSelector returns the URL unchanged and a nil list.
+func CutOutputSelector(s string) (rawURL string, outputNames []string) {
+	i := strings.LastIndexByte(s, '^')
+	if i < 0 || !strings.Contains(s[:i], "#") {
+		return s, nil
+	}
+	selector := s[i+1:]
+	if selector == AllOutputs {
+		return s[:i], []string{AllOutputs}
+	}
+	outputNames = strings.Split(selector, ",")
+	for _, name := range outputNames {
+		if !zbstore.IsValidOutputName(name) {
+			return s, nil
+		}
+	}
+	return s[:i], outputNames
+}
+
 // splitKeyPath splits a slash-separated path into its components.
 // A run of slashes is treated the same as a single slash.
 // An empty string yields no elements.
@@ -4,8 +4,14 @@
 package frontend
 
 import (
+	"net/url"
+	"os"
+	"path/filepath"
 	"slices"
 	"testing"
+
+	"zb.256lights.llc/pkg/internal/backendtest"
+	"zb.256lights.llc/pkg/internal/testcontext"
 )
 
 func TestParseFragment(t *testing.T) {
@@ -124,3 +130,143 @@ func TestSplitKeyPath(t *testing.T) {
 		}
 	}
 }
+
+func TestCutOutputSelector(t *testing.T) {
+	tests := []struct {
+		s           string
+		rawURL      string
+		outputNames []string
+	}{
+		{
+			s:      "foo.lua#hello",
+			rawURL: "foo.lua#hello",
+		},
+		{
+			s:           "foo.lua#hello^dev",
+			rawURL:      "foo.lua#hello",
+			outputNames: []string{"dev"},
+		},
+		{
+			s:           "foo.lua#hello^dev,out",
+			rawURL:      "foo.lua#hello",
+			outputNames: []string{"dev", "out"},
+		},
+		{
+			s:           "foo.lua#hello^*",
+			rawURL:      "foo.lua#hello",
+			outputNames: []string{AllOutputs},
+		},
+		{
+			s:      "foo^bar.lua#hello",
+			rawURL: "foo^bar.lua#hello",
+		},
+		{
+			s:      "foo.lua#hello^",
+			rawURL: "foo.lua#hello^",
+		},
+		{
+			s:      "foo.lua#hello^dev,",
+			rawURL: "foo.lua#hello^dev,",
+		},
+	}
+	for _, test := range tests {
+		rawURL, outputNames := CutOutputSelector(test.s)
+		if rawURL != test.rawURL || !slices.Equal(outputNames, test.outputNames) {
+			t.Errorf("CutOutputSelector(%q) = %q, %q; want %q, %q",
+				test.s, rawURL, outputNames, test.rawURL, test.outputNames)
+		}
+	}
+}
+
+func TestURLKeyPaths(t *testing.T) {
+	ctx := testcontext.New(t)
+	// Looking up fields in a local file does not use the store.
+	eval, err := NewEval(&Options{
+		StoreDirectory: backendtest.NewStoreDirectory(t),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := eval.Close(); err != nil {
+			t.Error("eval.Close:", err)
+		}
+	}()
+
+	source := filepath.Join(t.TempDir(), "pkgs.lua")
+	const content = "return {\n" +
+		"  pkgs = {\n" +
+		"    hello = \"hi\";\n" +
+		"    [\"python3.11\"] = { dev = \"py-dev\" };\n" +
+		"    [\"1.2.3\"] = \"v\";\n" +
+		"  };\n" +
+		"  [\"a.b\"] = \"literal\";\n" +
+		"  a = { b = \"nested\", c = \"c\" };\n" +
+		"  broken = lazy(function(t, k) error(\"bad key \" .. k) end);\n" +
+		"  sometimes = lazy(function(t, k) if k == \"x\" then return { y = \"xy\" } end error(\"bad key \" .. k) end);\n" +
+		"}\n"
+	if err := os.WriteFile(source, []byte(content), 0o666); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		fragment string
+		want     any
+		wantErr  bool
+	}{
+		{fragment: "pkgs/hello", want: "hi"},
+		{fragment: "pkgs.hello", want: "hi"},
+		{fragment: "pkgs/1.2.3", want: "v"},
+		{fragment: "pkgs.1.2.3", want: "v"},
+		{fragment: "pkgs.python3.11.dev", want: "py-dev"},
+		{fragment: "pkgs/python3.11/dev", want: "py-dev"},
+		{fragment: "pkgs.python3.11/dev", want: "py-dev"},
+		{fragment: "a.b", want: "literal"},
+		{fragment: "a.c", want: "c"},
+		{fragment: "pkgs.nope", want: nil},
+		{fragment: "sometimes.x.y", want: "xy"},
+		{fragment: "broken.x", wantErr: true},
+	}
+	for _, test := range tests {
+		results, err := eval.URLs(ctx, []string{source + "#" + test.fragment})
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("#%s = %v; want error", test.fragment, results)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%s: %v", test.fragment, err)
+			continue
+		}
+		if len(results) != 1 || results[0] != test.want {
+			t.Errorf("#%s = %v; want [%v]", test.fragment, results, test.want)
+		}
+	}
+}
+
+func TestIsArchiveURL(t *testing.T) {
+	tests := []struct {
+		s    string
+		want bool
+	}{
+		{"https://example.com/proj.tar.gz", true},
+		{"https://example.com/proj-1.0.TGZ", true},
+		{"https://example.com/proj.tar.bz2?download=1", true},
+		{"https://example.com/archive/main.zip", true},
+		{"https://example.com/proj.tar", true},
+		{"https://example.com/zb.lua", false},
+		{"https://example.com/proj.gz", false},
+		{"https://example.com/", false},
+	}
+	for _, test := range tests {
+		u, err := url.Parse(test.s)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if got := isArchiveURL(u); got != test.want {
+			t.Errorf("isArchiveURL(%s) = %t; want %t", test.s, got, test.want)
+		}
+	}
+}
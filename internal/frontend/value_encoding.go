@@ -0,0 +1,487 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package frontend
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+
+	"zb.256lights.llc/pkg/internal/lua"
+	"zb.256lights.llc/pkg/sets"
+	"zb.256lights.llc/pkg/zbstore"
+)
+
+// valueEncodingVersion is the version of the encoding produced by [marshalValue].
+// It must be incremented whenever the encoding changes
+// so that values encoded by older versions of zb are rejected.
+const valueEncodingVersion = 1
+
+// maxValueDepth is the maximum nesting of tables
+// in a value that can be encoded.
+const maxValueDepth = 100
+
+// Tags used in the encoding of values.
+const (
+	valueTagNil byte = iota
+	valueTagFalse
+	valueTagTrue
+	valueTagInteger
+	valueTagFloat
+	valueTagString
+	valueTagTable
+	valueTagDerivation
+	valueTagModule
+	// valueTagReference refers to a table, derivation, or module
+	// that appeared earlier in the value
+	// by the order in which it appeared.
+	valueTagReference
+)
+
+// marshalValue encodes the value at the given stack index
+// so that it can be stored outside of a Lua state
+// and later recreated with [unmarshalValue].
+// Only nil, booleans, numbers, strings (including their contexts),
+// tables without metatables, derivations, and modules can be encoded.
+// Tables that are referenced more than once (including cycles)
+// are encoded once and referenced thereafter.
+//
+// The encoding begins with [valueEncodingVersion] as a uvarint
+// followed by the value.
+// Each value starts with a tag byte:
+//
+//   - valueTagNil, valueTagFalse, and valueTagTrue have no payload.
+//   - valueTagInteger is followed by a varint.
+//   - valueTagFloat is followed by the little-endian IEEE 754 bits.
+//   - valueTagString is followed by the string,
+//     the number of context strings as a uvarint,
+//     then each context string in sorted order.
+//     Strings are encoded as a uvarint length followed by the bytes.
+//   - valueTagTable is followed by key/value pairs terminated by a nil key.
+//   - valueTagDerivation is followed by the filename and line (as a uvarint)
+//     of the derivation's position
+//     and the key/value pairs of the derivation's arguments
+//     terminated by a nil key.
+//   - valueTagModule is followed by the module's absolute path.
+//   - valueTagReference is followed by the reference number as a uvarint.
+//     Tables, derivations, and modules are numbered from zero
+//     in the order that their tags appear.
+func marshalValue(l *lua.State, idx int) ([]byte, error) {
+	enc := &valueEncoder{
+		l:    l,
+		buf:  binary.AppendUvarint(nil, valueEncodingVersion),
+		refs: make(map[uint64]uint64),
+	}
+	if err := enc.encode(l.AbsIndex(idx), 0); err != nil {
+		return nil, err
+	}
+	return enc.buf, nil
+}
+
+type valueEncoder struct {
+	l    *lua.State
+	buf  []byte
+	refs map[uint64]uint64
+}
+
+// encode appends the value at the given absolute stack index to enc.buf.
+func (enc *valueEncoder) encode(idx int, depth int) error {
+	l := enc.l
+	switch typ := l.Type(idx); typ {
+	case lua.TypeNil:
+		enc.buf = append(enc.buf, valueTagNil)
+	case lua.TypeBoolean:
+		if l.ToBoolean(idx) {
+			enc.buf = append(enc.buf, valueTagTrue)
+		} else {
+			enc.buf = append(enc.buf, valueTagFalse)
+		}
+	case lua.TypeNumber:
+		if n, ok := l.ToInteger(idx); ok && l.IsInteger(idx) {
+			enc.buf = append(enc.buf, valueTagInteger)
+			enc.buf = binary.AppendVarint(enc.buf, n)
+		} else {
+			f, _ := l.ToNumber(idx)
+			enc.buf = append(enc.buf, valueTagFloat)
+			enc.buf = binary.LittleEndian.AppendUint64(enc.buf, math.Float64bits(f))
+		}
+	case lua.TypeString:
+		s, _ := l.ToString(idx)
+		enc.buf = append(enc.buf, valueTagString)
+		enc.appendString(s)
+		sctx := l.StringContext(idx)
+		enc.buf = binary.AppendUvarint(enc.buf, uint64(sctx.Len()))
+		for _, c := range slices.Sorted(sctx.All()) {
+			enc.appendString(c)
+		}
+	case lua.TypeTable:
+		if enc.appendReference(idx) {
+			return nil
+		}
+		if depth >= maxValueDepth {
+			return fmt.Errorf("tables nested too deeply")
+		}
+		if l.Metatable(idx) {
+			l.Pop(1)
+			return fmt.Errorf("tables with metatables cannot be encoded")
+		}
+		enc.buf = append(enc.buf, valueTagTable)
+		if err := enc.encodePairs(idx, depth, nil); err != nil {
+			return err
+		}
+	case lua.TypeUserdata:
+		if drv := testDerivation(l, idx); drv != nil {
+			if enc.appendReference(idx) {
+				return nil
+			}
+			enc.buf = append(enc.buf, valueTagDerivation)
+			enc.appendString(drv.Position.Filename)
+			enc.buf = binary.AppendUvarint(enc.buf, uint64(max(drv.Position.Line, 0)))
+			// The argument table copy has fields added by the derivation function.
+			skip := sets.New("drvPath")
+			skip.Add(drv.outputNames...)
+			l.UserValue(idx, 1)
+			err := enc.encodePairs(l.Top(), depth, skip)
+			l.Pop(1)
+			return err
+		}
+		if mod := testModule(l, idx); mod != nil {
+			if mod.path == "" {
+				return fmt.Errorf("modules imported from derivations cannot be encoded")
+			}
+			if enc.appendReference(idx) {
+				return nil
+			}
+			enc.buf = append(enc.buf, valueTagModule)
+			enc.appendString(mod.path)
+			return nil
+		}
+		return fmt.Errorf("%v values other than derivations and modules cannot be encoded", typ)
+	default:
+		return fmt.Errorf("%v values cannot be encoded", typ)
+	}
+	return nil
+}
+
+// encodePairs appends the key/value pairs of the table at the given absolute stack index,
+// followed by a nil key.
+// String keys in skip are omitted.
+func (enc *valueEncoder) encodePairs(idx int, depth int, skip sets.Set[string]) error {
+	l := enc.l
+	if !l.CheckStack(3) {
+		return fmt.Errorf("tables nested too deeply")
+	}
+	l.PushNil()
+	for l.Next(idx) {
+		if l.Type(-2) == lua.TypeString {
+			if k, _ := l.ToString(-2); skip.Has(k) {
+				l.Pop(1)
+				continue
+			}
+		}
+		if err := enc.encode(l.Top()-1, depth+1); err != nil {
+			l.Pop(2)
+			return err
+		}
+		if err := enc.encode(l.Top(), depth+1); err != nil {
+			l.Pop(2)
+			return err
+		}
+		l.Pop(1)
+	}
+	enc.buf = append(enc.buf, valueTagNil)
+	return nil
+}
+
+// appendReference appends a reference to the value at the given stack index
+// if it has been encoded before and reports whether it did so.
+// Otherwise, appendReference assigns the value the next reference number
+// and the caller must encode it.
+func (enc *valueEncoder) appendReference(idx int) bool {
+	id := enc.l.ID(idx)
+	if ref, ok := enc.refs[id]; ok {
+		enc.buf = append(enc.buf, valueTagReference)
+		enc.buf = binary.AppendUvarint(enc.buf, ref)
+		return true
+	}
+	enc.refs[id] = uint64(len(enc.refs))
+	return false
+}
+
+func (enc *valueEncoder) appendString(s string) {
+	enc.buf = binary.AppendUvarint(enc.buf, uint64(len(s)))
+	enc.buf = append(enc.buf, s...)
+}
+
+// errInvalidValue is returned by [unmarshalValue]
+// when the encoded value is malformed.
+var errInvalidValue = errors.New("invalid encoded value")
+
+// unmarshalValue decodes a value encoded by [marshalValue],
+// freezes it, and pushes it onto l's stack.
+// Derivations are recreated with the derivation function
+// so that their store objects exist,
+// and unmarshalValue returns an error
+// if a string refers to a store object that no longer exists.
+// eval may be nil if the value does not contain derivations, modules,
+// or strings with contexts.
+func unmarshalValue(ctx context.Context, eval *Eval, l *lua.State, data []byte) error {
+	version, n := binary.Uvarint(data)
+	if n <= 0 {
+		return errInvalidValue
+	}
+	if version != valueEncodingVersion {
+		return fmt.Errorf("unsupported value encoding version %d", version)
+	}
+
+	l.CreateTable(0, 0)
+	dec := &valueDecoder{
+		eval:      eval,
+		l:         l,
+		data:      data[n:],
+		refsIndex: l.Top(),
+		exists:    make(sets.Set[zbstore.Path]),
+	}
+	if err := dec.decode(ctx, 0); err != nil {
+		l.SetTop(dec.refsIndex - 1)
+		return err
+	}
+	if len(dec.data) > 0 {
+		l.SetTop(dec.refsIndex - 1)
+		return errInvalidValue
+	}
+	l.Remove(dec.refsIndex)
+	if err := l.Freeze(-1); err != nil {
+		l.Pop(1)
+		return err
+	}
+	return nil
+}
+
+type valueDecoder struct {
+	eval *Eval
+	l    *lua.State
+	data []byte
+	// refsIndex is the stack index of a table
+	// that maps reference numbers to values.
+	refsIndex int
+	nrefs     int64
+	// exists is the set of store objects known to exist.
+	exists sets.Set[zbstore.Path]
+}
+
+// decode pushes the next value onto the stack.
+func (dec *valueDecoder) decode(ctx context.Context, depth int) error {
+	l := dec.l
+	if depth > maxValueDepth+1 {
+		return errInvalidValue
+	}
+	tag, err := dec.readByte()
+	if err != nil {
+		return err
+	}
+	switch tag {
+	case valueTagNil:
+		l.PushNil()
+	case valueTagFalse:
+		l.PushBoolean(false)
+	case valueTagTrue:
+		l.PushBoolean(true)
+	case valueTagInteger:
+		n, size := binary.Varint(dec.data)
+		if size <= 0 {
+			return errInvalidValue
+		}
+		dec.data = dec.data[size:]
+		l.PushInteger(n)
+	case valueTagFloat:
+		if len(dec.data) < 8 {
+			return errInvalidValue
+		}
+		l.PushNumber(math.Float64frombits(binary.LittleEndian.Uint64(dec.data)))
+		dec.data = dec.data[8:]
+	case valueTagString:
+		s, err := dec.readString()
+		if err != nil {
+			return err
+		}
+		n, err := dec.readUvarint()
+		if err != nil {
+			return err
+		}
+		var sctx sets.Set[string]
+		for range n {
+			c, err := dec.readString()
+			if err != nil {
+				return err
+			}
+			if err := dec.checkContext(ctx, c); err != nil {
+				return err
+			}
+			if sctx == nil {
+				sctx = make(sets.Set[string])
+			}
+			sctx.Add(c)
+		}
+		l.PushStringContext(s, sctx)
+	case valueTagTable:
+		l.CreateTable(0, 0)
+		if err := dec.addReference(l.Top()); err != nil {
+			return err
+		}
+		if err := dec.decodePairs(ctx, l.Top(), depth); err != nil {
+			return err
+		}
+	case valueTagDerivation:
+		if dec.eval == nil {
+			return fmt.Errorf("cannot decode derivation without an evaluator")
+		}
+		ref := dec.nrefs
+		dec.nrefs++
+		filename, err := dec.readString()
+		if err != nil {
+			return err
+		}
+		line, err := dec.readUvarint()
+		if err != nil {
+			return err
+		}
+		l.PushClosure(0, dec.eval.derivationFunction)
+		l.CreateTable(0, 0)
+		if err := dec.decodePairs(ctx, l.Top(), depth); err != nil {
+			return err
+		}
+		if err := l.Call(ctx, 1, 1); err != nil {
+			return err
+		}
+		drv := testDerivation(l, -1)
+		if drv == nil {
+			return errInvalidValue
+		}
+		drv.Position = Position{Filename: filename, Line: int(line)}
+		l.PushValue(-1)
+		if err := l.RawSetIndex(dec.refsIndex, ref); err != nil {
+			return err
+		}
+	case valueTagModule:
+		if dec.eval == nil {
+			return fmt.Errorf("cannot decode module without an evaluator")
+		}
+		path, err := dec.readString()
+		if err != nil {
+			return err
+		}
+		if err := dec.eval.pushModule(ctx, l, path, nil, Position{}); err != nil {
+			return err
+		}
+		if err := dec.addReference(l.Top()); err != nil {
+			return err
+		}
+	case valueTagReference:
+		ref, err := dec.readUvarint()
+		if err != nil {
+			return err
+		}
+		if ref >= uint64(dec.nrefs) || l.RawIndex(dec.refsIndex, int64(ref)) == lua.TypeNil {
+			l.Pop(1)
+			return errInvalidValue
+		}
+	default:
+		return errInvalidValue
+	}
+	return nil
+}
+
+// decodePairs reads key/value pairs up to a nil key
+// and sets them in the table at the given absolute stack index.
+func (dec *valueDecoder) decodePairs(ctx context.Context, idx int, depth int) error {
+	l := dec.l
+	if !l.CheckStack(3) {
+		return errInvalidValue
+	}
+	for {
+		if err := dec.decode(ctx, depth+1); err != nil {
+			return err
+		}
+		if l.IsNil(-1) {
+			l.Pop(1)
+			return nil
+		}
+		if err := dec.decode(ctx, depth+1); err != nil {
+			return err
+		}
+		if err := l.RawSet(idx); err != nil {
+			return err
+		}
+	}
+}
+
+// addReference records the value at the given stack index
+// as the next reference number.
+func (dec *valueDecoder) addReference(idx int) error {
+	dec.l.PushValue(idx)
+	if err := dec.l.RawSetIndex(dec.refsIndex, dec.nrefs); err != nil {
+		return err
+	}
+	dec.nrefs++
+	return nil
+}
+
+// checkContext returns an error if the store object
+// that the context string refers to does not exist.
+func (dec *valueDecoder) checkContext(ctx context.Context, s string) error {
+	c, err := parseContextString(s)
+	if err != nil {
+		return err
+	}
+	path := c.path
+	if path == "" {
+		path = c.outputReference.DrvPath
+	}
+	if dec.exists.Has(path) {
+		return nil
+	}
+	if dec.eval == nil {
+		return fmt.Errorf("cannot check %s without an evaluator", path)
+	}
+	if _, err := dec.eval.store.Object(ctx, path); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	dec.exists.Add(path)
+	return nil
+}
+
+func (dec *valueDecoder) readByte() (byte, error) {
+	if len(dec.data) == 0 {
+		return 0, errInvalidValue
+	}
+	b := dec.data[0]
+	dec.data = dec.data[1:]
+	return b, nil
+}
+
+func (dec *valueDecoder) readUvarint() (uint64, error) {
+	n, size := binary.Uvarint(dec.data)
+	if size <= 0 {
+		return 0, errInvalidValue
+	}
+	dec.data = dec.data[size:]
+	return n, nil
+}
+
+func (dec *valueDecoder) readString() (string, error) {
+	n, err := dec.readUvarint()
+	if err != nil {
+		return "", err
+	}
+	if uint64(len(dec.data)) < n {
+		return "", errInvalidValue
+	}
+	s := string(dec.data[:n])
+	dec.data = dec.data[n:]
+	return s, nil
+}
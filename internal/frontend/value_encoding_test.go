@@ -0,0 +1,201 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package frontend
+
+import (
+	"context"
+	"encoding/binary"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"zb.256lights.llc/pkg/internal/lua"
+	"zb.256lights.llc/pkg/internal/testcontext"
+)
+
+func TestValueEncoding(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+	}{
+		{name: "Nil", expr: "nil"},
+		{name: "False", expr: "false"},
+		{name: "True", expr: "true"},
+		{name: "Integer", expr: "-42"},
+		{name: "MaxInteger", expr: "0x7fffffffffffffff"},
+		{name: "Float", expr: "3.5"},
+		{name: "Infinity", expr: "1/0"},
+		{name: "EmptyString", expr: `""`},
+		{name: "String", expr: `"Hello, World!\0"`},
+		{name: "EmptyTable", expr: "{}"},
+		{name: "List", expr: `{1, "two", 3.0}`},
+		{name: "Nested", expr: `{a = {b = {c = true}}, [1] = {"x"}}`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := testcontext.New(t)
+			l := new(lua.State)
+			defer func() {
+				if err := l.Close(); err != nil {
+					t.Error("Close:", err)
+				}
+			}()
+
+			if err := l.Load(strings.NewReader("return "+test.expr), lua.LiteralSource(test.expr), "t"); err != nil {
+				t.Fatal(err)
+			}
+			if err := l.Call(ctx, 0, 1); err != nil {
+				t.Fatal(err)
+			}
+			data, err := marshalValue(l, -1)
+			if err != nil {
+				t.Fatal("marshalValue:", err)
+			}
+			want, err := luaToGo(ctx, l)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			l.SetTop(0)
+			if err := unmarshalValue(ctx, nil, l, data); err != nil {
+				t.Fatal("unmarshalValue:", err)
+			}
+			if got := l.Top(); got != 1 {
+				t.Fatalf("after unmarshalValue, l.Top() = %d; want 1", got)
+			}
+			got, err := luaToGo(ctx, l)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("decoded value (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestValueEncodingReferences(t *testing.T) {
+	ctx := testcontext.New(t)
+	l := new(lua.State)
+	defer func() {
+		if err := l.Close(); err != nil {
+			t.Error("Close:", err)
+		}
+	}()
+
+	const source = "local t = {}\n" +
+		"t.self = t\n" +
+		"t.a = {42}\n" +
+		"t.b = t.a\n" +
+		"return t\n"
+	if err := l.Load(strings.NewReader(source), lua.LiteralSource(source), "t"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Call(ctx, 0, 1); err != nil {
+		t.Fatal(err)
+	}
+	data, err := marshalValue(l, -1)
+	if err != nil {
+		t.Fatal("marshalValue:", err)
+	}
+	l.SetTop(0)
+	if err := unmarshalValue(ctx, nil, l, data); err != nil {
+		t.Fatal("unmarshalValue:", err)
+	}
+
+	if got, want := l.RawField(1, "self"), lua.TypeTable; got != want {
+		t.Fatalf("type(t.self) = %v; want %v", got, want)
+	}
+	if l.ID(1) != l.ID(2) {
+		t.Error("t.self ~= t")
+	}
+	l.SetTop(1)
+	l.RawField(1, "a")
+	l.RawField(1, "b")
+	if l.ID(2) != l.ID(3) {
+		t.Error("t.a ~= t.b")
+	}
+	if got, want := l.RawIndex(2, 1), lua.TypeNumber; got != want {
+		t.Errorf("type(t.a[1]) = %v; want %v", got, want)
+	} else if n, _ := l.ToInteger(-1); n != 42 {
+		t.Errorf("t.a[1] = %d; want 42", n)
+	}
+
+	l.SetTop(1)
+	l.PushBoolean(true)
+	if err := l.RawSetField(1, "x"); err == nil {
+		t.Error("Setting field on decoded table did not return an error (table not frozen)")
+	}
+}
+
+func TestValueEncodingErrors(t *testing.T) {
+	t.Run("Function", func(t *testing.T) {
+		l := new(lua.State)
+		defer l.Close()
+		l.PushPureFunction(0, func(ctx context.Context, l *lua.State) (int, error) {
+			return 0, nil
+		})
+		if _, err := marshalValue(l, -1); err == nil {
+			t.Error("marshalValue did not return an error")
+		}
+	})
+
+	t.Run("NestedTooDeep", func(t *testing.T) {
+		ctx := testcontext.New(t)
+		l := new(lua.State)
+		defer l.Close()
+		source := "return " + strings.Repeat("{", maxValueDepth+1) + strings.Repeat("}", maxValueDepth+1)
+		if err := l.Load(strings.NewReader(source), lua.AbstractSource("nested"), "t"); err != nil {
+			t.Fatal(err)
+		}
+		if err := l.Call(ctx, 0, 1); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := marshalValue(l, -1); err == nil {
+			t.Error("marshalValue did not return an error")
+		}
+	})
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "Empty", data: nil},
+		{name: "UnknownVersion", data: []byte{valueEncodingVersion + 1, valueTagNil}},
+		{name: "MissingValue", data: []byte{valueEncodingVersion}},
+		{name: "UnknownTag", data: []byte{valueEncodingVersion, 0xff}},
+		{name: "TrailingData", data: []byte{valueEncodingVersion, valueTagNil, valueTagNil}},
+		{name: "TruncatedFloat", data: []byte{valueEncodingVersion, valueTagFloat, 0, 0}},
+		{name: "TruncatedString", data: []byte{valueEncodingVersion, valueTagString, 5, 'a'}},
+		{name: "UnterminatedTable", data: []byte{valueEncodingVersion, valueTagTable, valueTagTrue, valueTagTrue}},
+		{name: "ForwardReference", data: []byte{valueEncodingVersion, valueTagTable, valueTagTrue, valueTagReference, 1, valueTagNil}},
+		{
+			name: "Derivation",
+			data: append([]byte{valueEncodingVersion, valueTagDerivation, 0, 0}, valueTagNil),
+		},
+		{
+			name: "FloatKey",
+			data: binary.LittleEndian.AppendUint64(
+				[]byte{valueEncodingVersion, valueTagTable, valueTagFloat},
+				math.Float64bits(math.NaN()),
+			),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := testcontext.New(t)
+			l := new(lua.State)
+			defer l.Close()
+			l.PushString("sentinel")
+			if err := unmarshalValue(ctx, nil, l, test.data); err == nil {
+				t.Error("unmarshalValue did not return an error")
+			}
+			if got := l.Top(); got != 1 {
+				t.Errorf("after unmarshalValue, l.Top() = %d; want 1", got)
+			}
+		})
+	}
+}
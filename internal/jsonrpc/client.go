@@ -9,6 +9,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"slices"
 	"strconv"
 	"strings"
@@ -58,20 +59,63 @@ type Client struct {
 	commsDone chan struct{}
 	// codecRequests is a channel for requests of the codec.
 	codecRequests chan clientCodecRequest
+	// methodTimeouts is a copy of [ClientOptions.MethodTimeouts].
+	methodTimeouts map[string]time.Duration
+	// replay is [ClientOptions.Replay].
+	replay func(method string) bool
+	// renegotiateFunc is [ClientOptions.Renegotiate].
+	renegotiateFunc func(ctx context.Context, h Handler) error
+}
+
+// ClientOptions is the set of optional parameters to [NewClient].
+type ClientOptions struct {
+	// MethodTimeouts is a map of method names to the maximum amount of time
+	// that the client waits for a response to a request of that method.
+	// The timeout for the empty string is used for methods not in the map.
+	// Methods without a positive timeout only time out
+	// if the request's context has a deadline.
+	//
+	// The deadline of a request's context (including one set by MethodTimeouts)
+	// is sent to the server so that the server can stop handling the request
+	// once the client is no longer waiting for it.
+	MethodTimeouts map[string]time.Duration
+
+	// Replay reports whether requests of the given method
+	// may be sent again if the connection is lost
+	// before the response is received.
+	// It should only return true for methods that are safe to call more than once,
+	// like methods that only read data.
+	// If Replay is nil, then requests are never replayed
+	// and return an error when the connection is lost.
+	Replay func(method string) bool
+	// Renegotiate is called after the connection is lost
+	// and before requests are replayed.
+	// It can be used to repeat any setup that the server expects on a new connection.
+	// h sends requests on the new connection.
+	// If Renegotiate returns an error,
+	// then the requests are not replayed and fail with the error.
+	Renegotiate func(ctx context.Context, h Handler) error
 }
 
 // NewClient returns a new [Client] that opens connections using the given function.
+// opts may be nil, in which case it is treated the same as the zero value.
 // The caller is responsible for calling [Client.Close]
 // when the Client is no longer in use.
 //
 // NewClient will start opening a connection in the background,
 // but will return before the connection is established.
 // The first call to [Client.JSONRPC] will block on the connection.
-func NewClient(open OpenFunc) *Client {
+func NewClient(open OpenFunc, opts *ClientOptions) *Client {
+	if opts == nil {
+		opts = new(ClientOptions)
+	}
 	c := &Client{
-		comms:         make(chan clientRequest),
-		commsDone:     make(chan struct{}),
-		codecRequests: make(chan clientCodecRequest),
+		comms:           make(chan clientRequest),
+		commsDone:       make(chan struct{}),
+		codecRequests:   make(chan clientCodecRequest),
+		methodTimeouts:  maps.Clone(opts.MethodTimeouts),
+		replay:          opts.Replay,
+		renegotiateFunc: opts.Renegotiate,
 	}
 	var commsCtx context.Context
 	commsCtx, c.cancelComms = context.WithCancel(context.Background())
@@ -90,11 +134,34 @@ func (c *Client) Close() error {
 }
 
 // JSONRPC sends a request to the server.
+// If the connection is lost before the response is received
+// and [ClientOptions.Replay] permits it,
+// then JSONRPC sends the request again once the connection has been reestablished.
 func (c *Client) JSONRPC(ctx context.Context, req *Request) (*Response, error) {
 	if !isValidParamStruct(req.Params) {
 		return nil, Error(InvalidRequest, fmt.Errorf("call json rpc %s: params must be an object or an array", req.Method))
 	}
+	ctx, cancel := c.withMethodTimeout(ctx, req.Method)
+	defer cancel()
 
+	for replays := 0; ; replays++ {
+		resp, err := c.send(ctx, req)
+		if err == nil {
+			return resp, nil
+		}
+		if !c.canReplay(req, err, replays) {
+			return resp, fmt.Errorf("call json rpc %s: %w", req.Method, err)
+		}
+		log.Debugf(ctx, "Connection lost during %s JSON-RPC; replaying", req.Method)
+		if err := c.renegotiate(ctx); err != nil {
+			return nil, fmt.Errorf("call json rpc %s: %w", req.Method, err)
+		}
+	}
+}
+
+// send sends a single request to the server
+// and waits for the response if the request is not a notification.
+func (c *Client) send(ctx context.Context, req *Request) (*Response, error) {
 	write := make(chan error, 1)
 	creq := clientRequest{
 		context: ctx,
@@ -106,15 +173,12 @@ func (c *Client) JSONRPC(ctx context.Context, req *Request) (*Response, error) {
 		case c.comms <- creq:
 			select {
 			case err := <-write:
-				if err != nil {
-					return nil, fmt.Errorf("call json rpc %s: %w", req.Method, err)
-				}
-				return nil, nil
+				return nil, err
 			case <-ctx.Done():
-				return nil, fmt.Errorf("call json rpc %s: %w", req.Method, ctx.Err())
+				return nil, ctx.Err()
 			}
 		case <-ctx.Done():
-			return nil, fmt.Errorf("call json rpc %s: %w", req.Method, ctx.Err())
+			return nil, ctx.Err()
 		}
 	}
 
@@ -123,13 +187,168 @@ func (c *Client) JSONRPC(ctx context.Context, req *Request) (*Response, error) {
 	select {
 	case c.comms <- creq:
 	case <-ctx.Done():
-		return nil, fmt.Errorf("call json rpc %s: %w", req.Method, ctx.Err())
+		return nil, ctx.Err()
 	}
-	resp, err := (<-responseChan).toResponse()
-	if err != nil {
-		return resp, fmt.Errorf("call json rpc %s: %w", req.Method, err)
+	return (<-responseChan).toResponse()
+}
+
+// maxReplays is the maximum number of times that [Client] sends a request again
+// after losing its connection.
+// It prevents a server that crashes on a particular request from being restarted indefinitely.
+const maxReplays = 3
+
+// canReplay reports whether req should be sent again
+// after the previous attempt failed with err.
+func (c *Client) canReplay(req *Request, err error, replays int) bool {
+	return replays < maxReplays &&
+		!req.Notification &&
+		c.replay != nil &&
+		errors.Is(err, errInterrupt) &&
+		c.replay(req.Method)
+}
+
+// renegotiate calls [ClientOptions.Renegotiate] if it was set.
+func (c *Client) renegotiate(ctx context.Context) error {
+	if c.renegotiateFunc == nil {
+		return nil
+	}
+	if err := c.renegotiateFunc(ctx, c); err != nil {
+		return fmt.Errorf("renegotiate: %w", err)
+	}
+	return nil
+}
+
+// JSONRPCBatch sends the requests to the server as a single [batch]
+// and waits for all of the responses.
+// The results are in the same order as reqs.
+// Requests are replayed as described in [Client.JSONRPC].
+//
+// [batch]: https://www.jsonrpc.org/specification#batch
+func (c *Client) JSONRPCBatch(ctx context.Context, reqs []*Request) []BatchResult {
+	results := make([]BatchResult, len(reqs))
+	batch := make([]*Request, 0, len(reqs))
+	indices := make([]int, 0, len(reqs))
+	for i, req := range reqs {
+		if !isValidParamStruct(req.Params) {
+			results[i].Err = Error(InvalidRequest, fmt.Errorf("call json rpc %s: params must be an object or an array", req.Method))
+			continue
+		}
+		batch = append(batch, req)
+		indices = append(indices, i)
+	}
+	if len(batch) == 0 {
+		return results
+	}
+	ctx, cancel := c.withBatchTimeout(ctx, batch)
+	defer cancel()
+
+	for replays := 0; len(batch) > 0; replays++ {
+		batchResults := c.sendBatch(ctx, batch)
+		var retryBatch []*Request
+		var retryIndices []int
+		for i, result := range batchResults {
+			if result.Err != nil && c.canReplay(batch[i], result.Err, replays) {
+				retryBatch = append(retryBatch, batch[i])
+				retryIndices = append(retryIndices, indices[i])
+				continue
+			}
+			if result.Err != nil {
+				result.Err = fmt.Errorf("call json rpc %s: %w", batch[i].Method, result.Err)
+			}
+			results[indices[i]] = result
+		}
+		if len(retryBatch) > 0 {
+			log.Debugf(ctx, "Connection lost during JSON-RPC batch; replaying %d requests", len(retryBatch))
+			if err := c.renegotiate(ctx); err != nil {
+				for i, req := range retryBatch {
+					results[retryIndices[i]].Err = fmt.Errorf("call json rpc %s: %w", req.Method, err)
+				}
+				break
+			}
+		}
+		batch, indices = retryBatch, retryIndices
+	}
+	return results
+}
+
+// sendBatch sends the requests to the server as a single batch
+// and waits for all of the responses.
+// The results are in the same order as batch.
+func (c *Client) sendBatch(ctx context.Context, batch []*Request) []BatchResult {
+	results := make([]BatchResult, len(batch))
+
+	write := make(chan error, 1)
+	creq := clientRequest{
+		context:        ctx,
+		batch:          batch,
+		batchResponses: make([]chan<- rawResponse, len(batch)),
+		write:          write,
+	}
+	responseChans := make([]chan rawResponse, len(batch))
+	for i, req := range batch {
+		if !req.Notification {
+			responseChans[i] = make(chan rawResponse, 1)
+			creq.batchResponses[i] = responseChans[i]
+		}
+	}
+	fail := func(err error) []BatchResult {
+		for i := range results {
+			results[i].Err = err
+		}
+		return results
+	}
+	select {
+	case c.comms <- creq:
+	case <-ctx.Done():
+		return fail(ctx.Err())
+	}
+	// The connection handler always reports the result of writing a batch.
+	if err := <-write; err != nil {
+		return fail(err)
+	}
+
+	for i, responseChan := range responseChans {
+		if responseChan == nil {
+			continue
+		}
+		resp, err := (<-responseChan).toResponse()
+		results[i] = BatchResult{Response: resp, Err: err}
 	}
-	return resp, nil
+	return results
+}
+
+// methodTimeout returns the timeout for the given method
+// or a non-positive duration if requests of the method do not time out.
+func (c *Client) methodTimeout(method string) time.Duration {
+	if timeout, ok := c.methodTimeouts[method]; ok {
+		return timeout
+	}
+	return c.methodTimeouts[""]
+}
+
+// withMethodTimeout returns a context that is canceled
+// once the timeout for the given method elapses.
+func (c *Client) withMethodTimeout(ctx context.Context, method string) (context.Context, context.CancelFunc) {
+	timeout := c.methodTimeout(method)
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// withBatchTimeout returns a context for sending a batch of requests.
+// A batch only times out if every request in the batch has a timeout,
+// in which case the longest timeout is used.
+func (c *Client) withBatchTimeout(ctx context.Context, batch []*Request) (context.Context, context.CancelFunc) {
+	var longest time.Duration
+	for _, req := range batch {
+		timeout := c.methodTimeout(req.Method)
+		if timeout <= 0 {
+			return ctx, func() {}
+		}
+		longest = max(longest, timeout)
+	}
+	return context.WithTimeout(ctx, longest)
 }
 
 func (c *Client) communicate(ctx context.Context, open OpenFunc) {
@@ -205,6 +424,24 @@ func (c *Client) handleConn(ctx context.Context, conn ClientCodec, closer io.Clo
 		}
 	}()
 
+	// track registers an in-flight request with the given ID.
+	track := func(id int64, reqCtx context.Context, responseChan chan<- rawResponse) {
+		cancelGroup.Add(1)
+		stopAfterFunc := context.AfterFunc(reqCtx, func() {
+			cancels <- id
+			cancelGroup.Done()
+		})
+		inflight[id] = inflightRequestState{
+			context:      reqCtx,
+			responseChan: responseChan,
+			ignoreCancel: func() {
+				if stopAfterFunc() {
+					cancelGroup.Done()
+				}
+			},
+		}
+	}
+
 	nextID := int64(1)
 	buf := new(bytes.Buffer)
 	var enc jsontext.Encoder
@@ -219,27 +456,44 @@ func (c *Client) handleConn(ctx context.Context, conn ClientCodec, closer io.Clo
 		case req := <-c.comms:
 			// Handle incoming application requests.
 
+			if req.batch != nil {
+				ids := make([]int64, len(req.batch))
+				for i, r := range req.batch {
+					if r.Notification {
+						ids[i] = -1
+					} else {
+						ids[i] = nextID
+						nextID++
+					}
+				}
+				buf.Reset()
+				enc.Reset(buf)
+				if err := marshalClientBatchJSONTo(&enc, ids, req.context, req.batch); err != nil {
+					req.write <- err
+					continue
+				}
+				for i, id := range ids {
+					if id != -1 {
+						track(id, req.context, req.batchResponses[i])
+					}
+				}
+				log.Debugf(ctx, "Writing JSON-RPC batch of %d requests", len(ids))
+				err := conn.WriteRequest(jsonValueFromBuffer(buf))
+				req.write <- err
+				if err != nil {
+					log.Debugf(ctx, "Failed to send message: %v", err)
+					return
+				}
+				continue
+			}
+
 			id := int64(-1)
 			if req.Notification {
 				log.Debugf(ctx, "Writing %s JSON-RPC notification", req.Method)
 			} else {
 				id = nextID
 				nextID++
-
-				cancelGroup.Add(1)
-				stopAfterFunc := context.AfterFunc(req.context, func() {
-					cancels <- id
-					cancelGroup.Done()
-				})
-				inflight[id] = inflightRequestState{
-					context:      req.context,
-					responseChan: req.response,
-					ignoreCancel: func() {
-						if stopAfterFunc() {
-							cancelGroup.Done()
-						}
-					},
-				}
+				track(id, req.context, req.response)
 
 				if log.IsEnabled(log.Debug) {
 					clientID := marshalClientID(nil, id)
@@ -367,12 +621,43 @@ func marshalClientRequestJSONTo(enc *jsontext.Encoder, id int64, req clientReque
 		}
 	}
 
+	if req.context != nil {
+		if deadline, ok := req.context.Deadline(); ok {
+			if err := enc.WriteToken(jsontext.String(timeoutField)); err != nil {
+				return err
+			}
+			// Round up so that the server never gives up before the client.
+			ms := max(1, (time.Until(deadline)+time.Millisecond-1)/time.Millisecond)
+			if err := enc.WriteToken(jsontext.Int(int64(ms))); err != nil {
+				return err
+			}
+		}
+	}
+
 	if err := enc.WriteToken(jsontext.EndObject); err != nil {
 		return err
 	}
 	return nil
 }
 
+// marshalClientBatchJSONTo writes a JSON array of requests.
+// ids[i] is the ID for reqs[i], or -1 if reqs[i] is a notification.
+// The requests are sent with the deadline of ctx, if any.
+func marshalClientBatchJSONTo(enc *jsontext.Encoder, ids []int64, ctx context.Context, reqs []*Request) error {
+	if err := enc.WriteToken(jsontext.BeginArray); err != nil {
+		return fmt.Errorf("marshal json-rpc batch: %v", err)
+	}
+	for i, req := range reqs {
+		if err := marshalClientRequestJSONTo(enc, ids[i], clientRequest{context: ctx, Request: req}); err != nil {
+			return err
+		}
+	}
+	if err := enc.WriteToken(jsontext.EndArray); err != nil {
+		return fmt.Errorf("marshal json-rpc batch: %v", err)
+	}
+	return nil
+}
+
 func marshalCancelRequestJSONTo(enc *jsontext.Encoder, id int64) (err error) {
 	defer func() {
 		if err != nil {
@@ -501,6 +786,15 @@ type clientRequest struct {
 	// If the connection is interrupted before a response is received,
 	// it will receive a nil message.
 	response chan<- rawResponse
+
+	// batch is the list of requests to send as a batch if non-nil.
+	// If batch is set, then Request is ignored and write must be non-nil.
+	batch []*Request
+	// batchResponses is the list of channels
+	// that will receive the responses to the corresponding requests in batch.
+	// Notifications have a nil channel.
+	// Each channel must have a buffer of at least 1.
+	batchResponses []chan<- rawResponse
 }
 
 type clientCodecRequest struct {
@@ -626,7 +920,7 @@ func unmarshalClientID(s string) (int64, bool) {
 // unmarshalResponseBatch unmarshals either a JSON-RPC response object
 // or an array of such objects.
 func unmarshalResponseBatch(msg jsontext.Value) ([]rawResponse, error) {
-	if msg.Kind() != '[' {
+	if !isBatch(msg) {
 		var response rawResponse
 		if err := jsonv2.Unmarshal(msg, &response.msg); err != nil {
 			return nil, err
@@ -636,18 +930,12 @@ func unmarshalResponseBatch(msg jsontext.Value) ([]rawResponse, error) {
 
 	// Split apart the array ourselves.
 	// If one element isn't an object, don't fail the entire batch.
-	dec := jsontext.NewDecoder(bytes.NewBuffer(msg))
-	if tok, err := dec.ReadToken(); err != nil {
+	elems, err := splitBatch(msg)
+	if err != nil {
 		return nil, err
-	} else if got := tok.Kind(); got != '[' {
-		return nil, fmt.Errorf("unexpected %v token", got)
 	}
-	var responses []rawResponse
-	for dec.PeekKind() != ']' {
-		data, err := dec.ReadValue()
-		if err != nil {
-			return nil, err
-		}
+	responses := make([]rawResponse, 0, len(elems))
+	for _, data := range elems {
 		var r rawResponse
 		r.error = jsonv2.Unmarshal(data, &r.msg)
 		responses = append(responses, r)
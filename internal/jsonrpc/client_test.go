@@ -216,7 +216,7 @@ func TestClient(t *testing.T) {
 					return nil, fmt.Errorf("open called %d times", openCount)
 				}
 				return codec, nil
-			})
+			}, nil)
 			defer func() {
 				if err := client.Close(); err != nil {
 					t.Error("Close:", err)
@@ -264,6 +264,7 @@ func TestClientCancel(t *testing.T) {
 					"jsonrpc": "2.0",
 					"method":  "hang",
 					"id":      "1",
+					"timeout": positiveTimeout,
 				},
 				map[string]any{
 					"jsonrpc": "2.0",
@@ -285,7 +286,7 @@ func TestClientCancel(t *testing.T) {
 			return nil, fmt.Errorf("open called %d times", openCount)
 		}
 		return codec, nil
-	})
+	}, nil)
 	defer func() {
 		if err := client.Close(); err != nil {
 			t.Error("Close:", err)
@@ -304,6 +305,185 @@ func TestClientCancel(t *testing.T) {
 	}
 }
 
+func TestClientMethodTimeout(t *testing.T) {
+	ctx := context.Background()
+	codec := newTestClientCodec(t, []clientTestWireInteraction{
+		{
+			wantRequests: []any{
+				map[string]any{
+					"jsonrpc": "2.0",
+					"method":  "hang",
+					"id":      "1",
+					"timeout": positiveTimeout,
+				},
+				map[string]any{
+					"jsonrpc": "2.0",
+					"method":  "$/cancelRequest",
+					"params": map[string]any{
+						"id": "1",
+					},
+				},
+			},
+			responses: []jsontext.Value{
+				jsontext.Value(`{"jsonrpc": "2.0", "result": 123, "id": "1"}`),
+			},
+		},
+	})
+	client := NewClient(func(ctx context.Context) (ClientCodec, error) {
+		return codec, nil
+	}, &ClientOptions{
+		MethodTimeouts: map[string]time.Duration{
+			"hang": 10 * time.Millisecond,
+		},
+	})
+	defer func() {
+		if err := client.Close(); err != nil {
+			t.Error("Close:", err)
+		}
+	}()
+
+	got, err := client.JSONRPC(ctx, &Request{
+		Method: "hang",
+	})
+	if !errors.Is(err, context.DeadlineExceeded) || got != nil {
+		t.Errorf("client.JSONRPC(...) = %v, %v; want <nil>, %v", got, err, context.DeadlineExceeded)
+	} else {
+		t.Log("error (expected):", err)
+	}
+}
+
+func TestClientReplay(t *testing.T) {
+	ctx := context.Background()
+	codec := newTestClientCodec(t, []clientTestWireInteraction{
+		{
+			wantRequests: []any{
+				map[string]any{
+					"jsonrpc": "2.0",
+					"method":  "hello",
+					"id":      "1",
+				},
+			},
+			responses: []jsontext.Value{
+				jsontext.Value(`{"jsonrpc": "2.0", "result": null, "id": "1"}`),
+			},
+		},
+		{
+			wantRequests: []any{
+				map[string]any{
+					"jsonrpc": "2.0",
+					"method":  "poll",
+					"id":      "2",
+				},
+			},
+			responses: []jsontext.Value{
+				jsontext.Value(`{"jsonrpc": "2.0", "result": 42, "id": "2"}`),
+			},
+		},
+	})
+	openCount := 0
+	client := NewClient(func(ctx context.Context) (ClientCodec, error) {
+		openCount++
+		switch openCount {
+		case 1:
+			return newDroppingClientCodec(), nil
+		case 2:
+			return codec, nil
+		default:
+			t.Errorf("OpenFunc called %d times", openCount)
+			return nil, fmt.Errorf("open called %d times", openCount)
+		}
+	}, &ClientOptions{
+		Replay: func(method string) bool {
+			return method == "poll"
+		},
+		Renegotiate: func(ctx context.Context, h Handler) error {
+			return Do(ctx, h, "hello", nil, nil)
+		},
+	})
+	defer func() {
+		if err := client.Close(); err != nil {
+			t.Error("Close:", err)
+		}
+	}()
+
+	got, err := client.JSONRPC(ctx, &Request{Method: "poll"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Response{Result: jsontext.Value(`42`)}
+	if diff := cmp.Diff(want, got, parseRawJSON()); diff != "" {
+		t.Errorf("response (-want +got):\n%s", diff)
+	}
+}
+
+func TestClientBatch(t *testing.T) {
+	// No deadline, so that requests do not include a timeout.
+	ctx := context.Background()
+
+	codec := newTestClientCodec(t, []clientTestWireInteraction{
+		{
+			wantRequests: []any{
+				[]any{
+					map[string]any{
+						"jsonrpc": "2.0",
+						"method":  "subtract",
+						"params":  []any{42.0, 23.0},
+						"id":      "1",
+					},
+					map[string]any{
+						"jsonrpc": "2.0",
+						"method":  "notify",
+					},
+					map[string]any{
+						"jsonrpc": "2.0",
+						"method":  "foobar",
+						"id":      "2",
+					},
+				},
+			},
+			responses: []jsontext.Value{
+				jsontext.Value(`[` +
+					`{"jsonrpc": "2.0", "error": {"code": -32601, "message": "Method not found"}, "id": "2"},` +
+					`{"jsonrpc": "2.0", "result": 19, "id": "1"}` +
+					`]`),
+			},
+		},
+	})
+	client := NewClient(func(ctx context.Context) (ClientCodec, error) {
+		return codec, nil
+	}, nil)
+	defer func() {
+		if err := client.Close(); err != nil {
+			t.Error("Close:", err)
+		}
+	}()
+
+	got := Batch(ctx, client, []*Request{
+		{Method: "subtract", Params: jsontext.Value(`[42, 23]`)},
+		{Method: "notify", Notification: true},
+		{Method: "foobar"},
+		{Method: "invalid", Params: jsontext.Value(`42`)},
+	})
+	if len(got) != 4 {
+		t.Fatalf("len(Batch(...)) = %d; want 4", len(got))
+	}
+	if got[0].Err != nil {
+		t.Errorf("result[0].Err = %v; want <nil>", got[0].Err)
+	}
+	if diff := cmp.Diff(&Response{Result: jsontext.Value(`19`)}, got[0].Response, parseRawJSON()); diff != "" {
+		t.Errorf("result[0].Response (-want +got):\n%s", diff)
+	}
+	if got[1].Response != nil || got[1].Err != nil {
+		t.Errorf("result[1] = %+v; want zero", got[1])
+	}
+	if code, _ := CodeFromError(got[2].Err); code != MethodNotFound {
+		t.Errorf("result[2].Err = %v; want code %d", got[2].Err, MethodNotFound)
+	}
+	if code, _ := CodeFromError(got[3].Err); code != InvalidRequest {
+		t.Errorf("result[3].Err = %v; want code %d", got[3].Err, InvalidRequest)
+	}
+}
+
 func TestClientCodec(t *testing.T) {
 	ctx := context.Background()
 	openCount := 0
@@ -325,7 +505,7 @@ func TestClientCodec(t *testing.T) {
 			return nil, fmt.Errorf("open called %d times", openCount)
 		}
 		return codec, nil
-	})
+	}, nil)
 	defer func() {
 		if err := client.Close(); err != nil {
 			t.Error("Close:", err)
@@ -400,6 +580,7 @@ func (c *testClientCodec) WriteRequest(request jsontext.Value) error {
 		c.lockedAdvance()
 		return err
 	}
+	normalizeTimeouts(parsed)
 	if diff := cmp.Diff(c.interactions[c.currInteraction].wantRequests[c.requestIndex], parsed); diff != "" {
 		c.tb.Errorf("client request (-want +got):\n%s", diff)
 	}
@@ -455,3 +636,49 @@ func (c *testClientCodec) Close() error {
 	c.responsesCond.Broadcast()
 	return nil
 }
+
+// droppingClientCodec is a [ClientCodec]
+// that loses its connection after the first request is written.
+type droppingClientCodec struct {
+	once    sync.Once
+	written chan struct{}
+}
+
+func newDroppingClientCodec() *droppingClientCodec {
+	return &droppingClientCodec{written: make(chan struct{})}
+}
+
+func (c *droppingClientCodec) WriteRequest(request jsontext.Value) error {
+	c.once.Do(func() { close(c.written) })
+	return nil
+}
+
+func (c *droppingClientCodec) ReadResponse() (jsontext.Value, error) {
+	<-c.written
+	return nil, io.ErrUnexpectedEOF
+}
+
+func (c *droppingClientCodec) Close() error {
+	c.once.Do(func() { close(c.written) })
+	return nil
+}
+
+// positiveTimeout is the value that [normalizeTimeouts]
+// substitutes for positive request timeouts.
+const positiveTimeout = "<positive>"
+
+// normalizeTimeouts replaces positive "timeout" fields in the parsed request or batch
+// with [positiveTimeout],
+// since the exact value depends on how long the request took to send.
+func normalizeTimeouts(parsed any) {
+	switch parsed := parsed.(type) {
+	case map[string]any:
+		if ms, ok := parsed[timeoutField].(float64); ok && ms > 0 {
+			parsed[timeoutField] = positiveTimeout
+		}
+	case []any:
+		for _, elem := range parsed {
+			normalizeTimeouts(elem)
+		}
+	}
+}
@@ -37,7 +37,7 @@ func Example() {
 	// Create a client that communicates on the in-memory pipe.
 	client := jsonrpc.NewClient(func(ctx context.Context) (jsonrpc.ClientCodec, error) {
 		return newCodec(clientConn), nil
-	})
+	}, nil)
 	defer client.Close()
 
 	// Call the server using the client.
@@ -0,0 +1,61 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package jsonrpc
+
+import (
+	"context"
+	"slices"
+)
+
+// An Interceptor wraps calls to a [Handler].
+// It is given the request and the next handler in the chain.
+// An Interceptor may inspect or modify the context and request,
+// call next zero or more times,
+// and inspect or replace the response and error.
+// Interceptors are useful for concerns that apply to every method,
+// like logging, metrics, authorization, and rate limiting.
+//
+// Information about the peer is available from the context,
+// such as [RequestIDFromContext] for requests served by [Serve].
+// Interceptors can be used on the client side
+// by passing a [Client] to [Intercept].
+type Interceptor func(ctx context.Context, req *Request, next Handler) (*Response, error)
+
+// Intercept returns a [Handler] that passes each request through the interceptors
+// before calling h.
+// The first interceptor is the outermost:
+// it is called first and its next handler calls the second interceptor,
+// and so on until the last interceptor's next handler calls h.
+// If there are no interceptors, Intercept returns h.
+//
+// The returned handler does not implement [BatchHandler],
+// so [Batch] sends each request through the interceptors individually.
+func Intercept(h Handler, interceptors ...Interceptor) Handler {
+	if len(interceptors) == 0 {
+		return h
+	}
+	return &interceptedHandler{
+		handler:      h,
+		interceptors: slices.Clone(interceptors),
+	}
+}
+
+type interceptedHandler struct {
+	handler      Handler
+	interceptors []Interceptor
+}
+
+func (ih *interceptedHandler) JSONRPC(ctx context.Context, req *Request) (*Response, error) {
+	next := ih.handler
+	for i := len(ih.interceptors) - 1; i > 0; i-- {
+		next = chainLink(ih.interceptors[i], next)
+	}
+	return ih.interceptors[0](ctx, req, next)
+}
+
+func chainLink(interceptor Interceptor, next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, req *Request) (*Response, error) {
+		return interceptor(ctx, req, next)
+	})
+}
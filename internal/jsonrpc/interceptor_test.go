@@ -0,0 +1,73 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package jsonrpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/go-json-experiment/json/jsontext"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestIntercept(t *testing.T) {
+	var calls []string
+	h := HandlerFunc(func(ctx context.Context, req *Request) (*Response, error) {
+		calls = append(calls, "handler "+req.Method)
+		return &Response{Result: jsontext.Value(`"ok"`)}, nil
+	})
+	record := func(name string) Interceptor {
+		return func(ctx context.Context, req *Request, next Handler) (*Response, error) {
+			calls = append(calls, name+" before")
+			resp, err := next.JSONRPC(ctx, req)
+			calls = append(calls, name+" after")
+			return resp, err
+		}
+	}
+	errDenied := errors.New("denied")
+	deny := func(ctx context.Context, req *Request, next Handler) (*Response, error) {
+		if req.Method == "secret" {
+			return nil, errDenied
+		}
+		return next.JSONRPC(ctx, req)
+	}
+	ih := Intercept(h, record("first"), deny, record("second"))
+
+	t.Run("Order", func(t *testing.T) {
+		calls = nil
+		resp, err := ih.JSONRPC(context.Background(), &Request{Method: "foo"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := string(resp.Result), `"ok"`; got != want {
+			t.Errorf("result = %s; want %s", got, want)
+		}
+		want := []string{
+			"first before",
+			"second before",
+			"handler foo",
+			"second after",
+			"first after",
+		}
+		if diff := cmp.Diff(want, calls); diff != "" {
+			t.Errorf("calls (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("ShortCircuit", func(t *testing.T) {
+		calls = nil
+		_, err := ih.JSONRPC(context.Background(), &Request{Method: "secret"})
+		if !errors.Is(err, errDenied) {
+			t.Errorf("err = %v; want %v", err, errDenied)
+		}
+		want := []string{
+			"first before",
+			"first after",
+		}
+		if diff := cmp.Diff(want, calls); diff != "" {
+			t.Errorf("calls (-want +got):\n%s", diff)
+		}
+	})
+}
@@ -11,6 +11,7 @@ import (
 	"errors"
 	"fmt"
 	"strconv"
+	"sync"
 
 	jsonv2 "github.com/go-json-experiment/json"
 	"github.com/go-json-experiment/json/jsontext"
@@ -29,6 +30,8 @@ type Request struct {
 	// Notification is true if the client does not care about a response.
 	Notification bool
 	// Extra holds a map of additional top-level fields on the request object.
+	// Extra never contains the "timeout" field
+	// that [Client] uses to propagate its context's deadline.
 	Extra map[string]jsontext.Value
 }
 
@@ -74,6 +77,41 @@ func Do(ctx context.Context, h Handler, method string, response, request any) er
 	return nil
 }
 
+// BatchResult is the outcome of a single request in a batch.
+// Results for notifications have a nil Response and Err
+// unless the notification could not be sent.
+type BatchResult struct {
+	Response *Response
+	Err      error
+}
+
+// A BatchHandler is a [Handler] that can send multiple requests at once.
+// JSONRPCBatch must return a slice with the same length as reqs.
+// [Client] implements BatchHandler.
+type BatchHandler interface {
+	Handler
+	JSONRPCBatch(ctx context.Context, reqs []*Request) []BatchResult
+}
+
+// Batch sends the requests to the given [Handler]
+// and returns their results in the same order as reqs.
+// If h implements [BatchHandler], then Batch calls JSONRPCBatch.
+// Otherwise, Batch calls h.JSONRPC for each request concurrently.
+func Batch(ctx context.Context, h Handler, reqs []*Request) []BatchResult {
+	if bh, ok := h.(BatchHandler); ok {
+		return bh.JSONRPCBatch(ctx, reqs)
+	}
+	results := make([]BatchResult, len(reqs))
+	var wg sync.WaitGroup
+	for i, req := range reqs {
+		wg.Go(func() {
+			results[i].Response, results[i].Err = h.JSONRPC(ctx, req)
+		})
+	}
+	wg.Wait()
+	return results
+}
+
 // Notify makes a single JSON-RPC to the given [Handler].
 // params should be any Go value that can be passed to [jsonv2.Marshal].
 func Notify(ctx context.Context, h Handler, method string, params any) error {
@@ -275,6 +313,36 @@ func marshalJSONRPCVersionTo(enc *jsontext.Encoder) error {
 	return nil
 }
 
+// isBatch reports whether msg is a JSON array,
+// which JSON-RPC uses to send multiple requests or responses at once.
+func isBatch(msg jsontext.Value) bool {
+	msg = bytes.TrimLeft(msg, " \t\r\n")
+	return len(msg) > 0 && msg[0] == '['
+}
+
+// splitBatch returns the elements of the JSON array msg.
+// The elements are not validated beyond being well-formed JSON.
+func splitBatch(msg jsontext.Value) ([]jsontext.Value, error) {
+	dec := jsontext.NewDecoder(bytes.NewBuffer(msg))
+	if tok, err := dec.ReadToken(); err != nil {
+		return nil, err
+	} else if got := tok.Kind(); got != '[' {
+		return nil, fmt.Errorf("unexpected %v token", got)
+	}
+	var elems []jsontext.Value
+	for dec.PeekKind() != ']' {
+		data, err := dec.ReadValue()
+		if err != nil {
+			return nil, err
+		}
+		elems = append(elems, data.Clone())
+	}
+	if _, err := dec.ReadToken(); err != nil {
+		return nil, err
+	}
+	return elems, nil
+}
+
 func jsonValueFromBuffer(buf *bytes.Buffer) jsontext.Value {
 	return jsontext.Value(bytes.TrimSuffix(buf.Bytes(), []byte{'\n'}))
 }
@@ -300,8 +368,15 @@ func inverseFilterMap[K comparable, V any, M ~map[K]V](m M, f func(K) bool) map[
 	return result
 }
 
+// timeoutField is the name of the top-level request field
+// that holds the number of milliseconds the client is willing to wait for a response.
+// It is an extension to JSON-RPC 2.0:
+// [Client] sends it for requests whose context has a deadline,
+// and [Serve] cancels the handler's context once the timeout elapses.
+const timeoutField = "timeout"
+
 func isReservedRequestField(key string) bool {
-	return key == "jsonrpc" || key == "method" || key == "params" || key == "id"
+	return key == "jsonrpc" || key == "method" || key == "params" || key == "id" || key == timeoutField
 }
 
 func isReservedResponseField(key string) bool {
@@ -8,8 +8,10 @@ import (
 	"context"
 	"fmt"
 	"maps"
+	"math"
 	"slices"
 	"sync"
+	"time"
 
 	jsonv2 "github.com/go-json-experiment/json"
 	"github.com/go-json-experiment/json/jsontext"
@@ -77,7 +79,10 @@ func Serve(ctx context.Context, codec ServerCodec, handler Handler) error {
 			return err
 		}
 
-		// TODO(someday): Support batches.
+		if isBatch(content) {
+			srv.batch(ctx, &wg, handler, content)
+			continue
+		}
 		parsed := new(serverRequest)
 		dec := jsontext.NewDecoder(bytes.NewBuffer(content))
 		if err := parsed.UnmarshalJSONFrom(dec); err != nil {
@@ -85,20 +90,110 @@ func Serve(ctx context.Context, codec ServerCodec, handler Handler) error {
 			continue
 		}
 
-		requestCtx, cancel := context.WithCancel(ctx)
-		if !parsed.Notification {
-			srv.mu.Lock()
-			srv.cancelMap[parsed.id] = cancel
-			srv.mu.Unlock()
-		}
-
+		requestCtx, cancel := srv.prepare(ctx, parsed)
 		wg.Go(func() {
 			srv.single(requestCtx, handler, parsed, cancel)
 		})
 	}
 }
 
+// prepare returns the context for handling req.
+// If the client sent a timeout, then the context is canceled once the timeout elapses.
+// If req is not a notification, then the request can be canceled by the client
+// until the returned cancel function is called.
+func (srv *server) prepare(ctx context.Context, req *serverRequest) (context.Context, context.CancelFunc) {
+	var requestCtx context.Context
+	var cancel context.CancelFunc
+	if req.timeout > 0 {
+		requestCtx, cancel = context.WithTimeout(ctx, req.timeout)
+	} else {
+		requestCtx, cancel = context.WithCancel(ctx)
+	}
+	if !req.Notification {
+		srv.mu.Lock()
+		srv.cancelMap[req.id] = cancel
+		srv.mu.Unlock()
+	}
+	return requestCtx, cancel
+}
+
+// batch handles a [batch request].
+// The requests in the batch are handled concurrently
+// and a single response array is written once all of them have completed.
+// The responses are in the same order as the requests.
+//
+// [batch request]: https://www.jsonrpc.org/specification#batch
+func (srv *server) batch(ctx context.Context, wg *sync.WaitGroup, handler Handler, content jsontext.Value) {
+	elems, err := splitBatch(content)
+	if err != nil {
+		srv.writeError(Error(ParseError, err))
+		return
+	}
+	if len(elems) == 0 {
+		srv.writeError(Error(InvalidRequest, fmt.Errorf("empty batch")))
+		return
+	}
+
+	responses := make([]jsontext.Value, len(elems))
+	var batchGroup sync.WaitGroup
+	for i, elem := range elems {
+		parsed := new(serverRequest)
+		dec := jsontext.NewDecoder(bytes.NewBuffer(elem))
+		if err := parsed.UnmarshalJSONFrom(dec); err != nil {
+			buf := new(bytes.Buffer)
+			enc := jsontext.NewEncoder(buf)
+			if err := marshalErrorResponseJSONTo(enc, RequestID{}, err); err != nil {
+				panic(err)
+			}
+			responses[i] = jsonValueFromBuffer(buf)
+			continue
+		}
+		requestCtx, cancel := srv.prepare(ctx, parsed)
+		batchGroup.Go(func() {
+			responses[i] = srv.handle(requestCtx, handler, parsed, cancel)
+		})
+	}
+
+	wg.Go(func() {
+		batchGroup.Wait()
+		buf := new(bytes.Buffer)
+		buf.WriteByte('[')
+		n := 0
+		for _, resp := range responses {
+			if resp == nil {
+				continue
+			}
+			if n > 0 {
+				buf.WriteByte(',')
+			}
+			buf.Write(resp)
+			n++
+		}
+		buf.WriteByte(']')
+		if n == 0 {
+			// A batch of only notifications does not receive a response.
+			return
+		}
+
+		srv.writeLock.Lock()
+		defer srv.writeLock.Unlock()
+		srv.codec.WriteResponse(jsontext.Value(buf.Bytes()))
+	})
+}
+
 func (srv *server) single(ctx context.Context, handler Handler, req *serverRequest, cancel context.CancelFunc) {
+	resp := srv.handle(ctx, handler, req, cancel)
+	if resp == nil {
+		return
+	}
+	srv.writeLock.Lock()
+	defer srv.writeLock.Unlock()
+	srv.codec.WriteResponse(resp)
+}
+
+// handle calls the handler for req and returns the marshaled response,
+// or nil if req is a notification.
+func (srv *server) handle(ctx context.Context, handler Handler, req *serverRequest, cancel context.CancelFunc) jsontext.Value {
 	defer cancel()
 	// Make defensive copy of request information.
 	notification := req.Notification
@@ -119,7 +214,7 @@ func (srv *server) single(ctx context.Context, handler Handler, req *serverReque
 
 	if notification {
 		// Notifications do not receive a response.
-		return
+		return nil
 	}
 
 	srv.mu.Lock()
@@ -137,10 +232,7 @@ func (srv *server) single(ctx context.Context, handler Handler, req *serverReque
 			panic(err)
 		}
 	}
-
-	srv.writeLock.Lock()
-	defer srv.writeLock.Unlock()
-	srv.codec.WriteResponse(jsonValueFromBuffer(buf))
+	return jsonValueFromBuffer(buf)
 }
 
 // cancel handles a [cancelMethod] request.
@@ -186,6 +278,9 @@ func (mux ServeMux) JSONRPC(ctx context.Context, req *Request) (*Response, error
 
 type serverRequest struct {
 	id RequestID
+	// timeout is the value of the request's [timeoutField]
+	// or zero if the request did not have one.
+	timeout time.Duration
 	Request
 }
 
@@ -243,6 +338,15 @@ keys:
 			if err := req.id.UnmarshalJSONFrom(dec); err != nil {
 				return Error(InvalidRequest, fmt.Errorf("jsonrpc id: %v", err))
 			}
+		case timeoutField:
+			var ms int64
+			if err := jsonv2.UnmarshalDecode(dec, &ms); err != nil {
+				return Error(InvalidRequest, fmt.Errorf("jsonrpc timeout: %v", err))
+			}
+			if ms <= 0 {
+				return Error(InvalidRequest, fmt.Errorf("jsonrpc timeout must be positive (got %d)", ms))
+			}
+			req.timeout = time.Duration(min(ms, int64(math.MaxInt64/time.Millisecond))) * time.Millisecond
 		default:
 			if isReservedRequestField(key) {
 				if err := dec.SkipValue(); err != nil {
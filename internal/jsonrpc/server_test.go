@@ -80,6 +80,35 @@ func TestServe(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "Timeout",
+			requests: []jsontext.Value{
+				jsontext.Value(`{"jsonrpc": "2.0", "method": "hang", "id": 1, "timeout": 1}`),
+			},
+			responses: []any{
+				map[string]any{
+					"jsonrpc": "2.0",
+					"result":  nil,
+					"id":      1.0,
+				},
+			},
+		},
+		{
+			name: "InvalidTimeout",
+			requests: []jsontext.Value{
+				jsontext.Value(`{"jsonrpc": "2.0", "method": "hang", "id": 1, "timeout": -5}`),
+			},
+			responses: []any{
+				map[string]any{
+					"jsonrpc": "2.0",
+					"error": map[string]any{
+						"code": -32600.0,
+					},
+					"id": nil,
+				},
+			},
+			ignoreErrorMessages: true,
+		},
 		{
 			name: "NonExistentNotification",
 			requests: []jsontext.Value{
@@ -87,6 +116,62 @@ func TestServe(t *testing.T) {
 			},
 			responses: []any{},
 		},
+		{
+			name: "Batch",
+			requests: []jsontext.Value{
+				jsontext.Value(`[` +
+					`{"jsonrpc": "2.0", "method": "subtract", "params": [42, 23], "id": 1},` +
+					`{"jsonrpc": "2.0", "method": "subtract", "params": [1, 2]},` +
+					`{"jsonrpc": "2.0"},` +
+					`{"jsonrpc": "2.0", "method": "subtract", "params": [23, 42], "id": 2}` +
+					`]`),
+			},
+			responses: []any{
+				[]any{
+					map[string]any{
+						"jsonrpc": "2.0",
+						"result":  19.0,
+						"id":      1.0,
+					},
+					map[string]any{
+						"jsonrpc": "2.0",
+						"error": map[string]any{
+							"code":    -32600.0,
+							"message": "jsonrpc method missing in request",
+						},
+						"id": nil,
+					},
+					map[string]any{
+						"jsonrpc": "2.0",
+						"result":  -19.0,
+						"id":      2.0,
+					},
+				},
+			},
+		},
+		{
+			name: "BatchNotifications",
+			requests: []jsontext.Value{
+				jsontext.Value(`[{"jsonrpc": "2.0", "method": "subtract", "params": [1, 2]}]`),
+			},
+			responses: []any{},
+		},
+		{
+			name: "EmptyBatch",
+			requests: []jsontext.Value{
+				jsontext.Value(`[]`),
+			},
+			responses: []any{
+				map[string]any{
+					"jsonrpc": "2.0",
+					"error": map[string]any{
+						"code": -32600.0,
+					},
+					"id": nil,
+				},
+			},
+			ignoreErrorMessages: true,
+		},
 		{
 			name: "InvalidJSON",
 			requests: []jsontext.Value{
@@ -4,8 +4,10 @@
 package lua
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"sync/atomic"
 
 	"zb.256lights.llc/pkg/internal/luacode"
 )
@@ -295,3 +297,105 @@ func sourceToString(source Source) string {
 	}
 	return source.String()
 }
+
+// HookMask is a set of events that trigger a [Hook].
+type HookMask uint8
+
+// Hook events.
+const (
+	// MaskCount calls the hook before the interpreter executes
+	// every count instructions of Lua code.
+	MaskCount HookMask = 1 << iota
+	// MaskLine calls the hook when the interpreter is about to start
+	// executing a new line of Lua code,
+	// or when it jumps back in the code (even to the same line).
+	MaskLine
+)
+
+// A Hook is a function that the interpreter calls during execution.
+// event has exactly one bit set: the event that triggered the call.
+// See [*State.SetHook] for details.
+type Hook func(ctx context.Context, l *State, event HookMask)
+
+// SetHook sets a function that the interpreter calls
+// for the events in mask.
+// count is only meaningful when mask includes [MaskCount].
+// While the hook is running, level 0 in [*State.Info]
+// is the Lua function that is about to execute an instruction.
+// The hook must leave the stack as it found it.
+// The hook is not called recursively:
+// any Lua code that the hook calls does not trigger it.
+// If hook is nil or mask is zero, then SetHook removes any existing hook.
+// Hooks are specific to a State and are not affected by [*State.Close].
+func (l *State) SetHook(hook Hook, mask HookMask, count int) {
+	if count <= 0 {
+		mask &^= MaskCount
+		count = 0
+	}
+	if hook == nil || mask == 0 {
+		hook = nil
+		mask = 0
+		count = 0
+	}
+	l.hook = hook
+	l.hookMask = mask
+	l.hookCount = count
+	l.hookCountdown = count
+}
+
+// AllocStats counts the values that Lua states create.
+// A single AllocStats may be shared by states running concurrently.
+// Use [*State.SetAllocStats] to start counting.
+type AllocStats struct {
+	// Tables is the number of tables created.
+	Tables atomic.Int64
+	// Functions is the number of Lua and Go closures created.
+	Functions atomic.Int64
+	// Userdata is the number of full userdata values created.
+	Userdata atomic.Int64
+	// Strings is the number of strings created by concatenation.
+	Strings atomic.Int64
+}
+
+// SetAllocStats sets the counters that l adds to
+// when it creates a value that requires an allocation.
+// If stats is nil, then SetAllocStats stops counting.
+// Like hooks, the counters are not affected by [*State.Close].
+func (l *State) SetAllocStats(stats *AllocStats) {
+	l.allocStats = stats
+}
+
+// runHooks calls the hook for the instruction at pc in the current frame
+// if any hook events apply.
+func (l *State) runHooks(ctx context.Context, f luaFunction, pc int) {
+	if l.inHook {
+		return
+	}
+	frame := l.frame()
+	lastPC := frame.hookPC - 1
+	frame.hookPC = pc + 1
+
+	var events HookMask
+	if l.hookMask&MaskCount != 0 {
+		l.hookCountdown--
+		if l.hookCountdown <= 0 {
+			l.hookCountdown = l.hookCount
+			events |= MaskCount
+		}
+	}
+	if l.hookMask&MaskLine != 0 && (lastPC < 0 || pc <= lastPC ||
+		f.proto.LineInfo.At(pc) != f.proto.LineInfo.At(lastPC)) {
+		events |= MaskLine
+	}
+	if events == 0 {
+		return
+	}
+
+	l.inHook = true
+	defer func() { l.inHook = false }()
+	for _, event := range [...]HookMask{MaskCount, MaskLine} {
+		if events&event != 0 {
+			l.hook(ctx, l, event)
+		}
+	}
+}
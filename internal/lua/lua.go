@@ -97,6 +97,14 @@ type State struct {
 	typeMetatables   [9]*table
 	pendingVariables []*upvalue
 	tbc              sets.Bit
+
+	hook          Hook
+	hookMask      HookMask
+	hookCount     int
+	hookCountdown int
+	inHook        bool
+
+	allocStats *AllocStats
 }
 
 func (l *State) init() {
@@ -660,7 +668,7 @@ func (l *State) StringContext(idx int) sets.Set[string] {
 		return nil
 	}
 	if v, ok := v.(stringValue); ok {
-		return v.context.Clone()
+		return sets.Collect(v.context.All())
 	}
 	return nil
 }
@@ -967,7 +975,7 @@ func (l *State) PushStringContext(s string, context sets.Set[string]) {
 	l.init()
 	v := stringValue{s: s}
 	if len(context) > 0 {
-		v.context = context.Clone()
+		v.context = sets.CollectPersistent(context.All())
 	}
 	l.push(v)
 }
@@ -1031,6 +1039,9 @@ func (l *State) pushGoFunction(n int, f Function, pure bool) {
 		upvalues: upvalues,
 		pure:     pure,
 	})
+	if l.allocStats != nil {
+		l.allocStats.Functions.Add(1)
+	}
 }
 
 // Global pushes onto the stack the value of the global with the given name,
@@ -1215,6 +1226,9 @@ func (l *State) RawField(idx int, k string) Type {
 func (l *State) CreateTable(nArr, nRec int) {
 	l.init()
 	l.push(newTable(nArr + nRec))
+	if l.allocStats != nil {
+		l.allocStats.Tables.Add(1)
+	}
 }
 
 // NewUserdata creates and pushes on the stack a new full userdata,
@@ -1226,6 +1240,9 @@ func (l *State) CreateTable(nArr, nRec int) {
 func (l *State) NewUserdata(x any, numUserValues int) {
 	l.init()
 	l.push(newUserdata(x, numUserValues))
+	if l.allocStats != nil {
+		l.allocStats.Userdata.Add(1)
+	}
 }
 
 // Metatable reports whether the value at the given index has a metatable
@@ -1977,6 +1994,9 @@ func (l *State) Load(r io.ByteScanner, chunkName Source, mode string) (err error
 			closedUpvalue(l.registry.get(integerValue(RegistryIndexGlobals))),
 		},
 	})
+	if l.allocStats != nil {
+		l.allocStats.Functions.Add(1)
+	}
 	return nil
 }
 
@@ -2105,18 +2125,13 @@ func (l *State) concat(ctx context.Context, n int) error {
 			// Find the longest run of values that can be coerced to a string,
 			// and perform raw string concatenation.
 			concatStart := firstArg + stringerTailStart(l.stack[firstArg:len(l.stack)-2])
-			initialCapacity, hasContext := minConcatSize(l.stack[concatStart:])
 			sb := new(strings.Builder)
-			sb.Grow(initialCapacity)
-			var sctx sets.Set[string]
-			if hasContext {
-				sctx = make(sets.Set[string])
-			}
-
+			sb.Grow(minConcatSize(l.stack[concatStart:]))
+			var sctx sets.Persistent[string]
 			for _, v := range l.stack[concatStart:] {
 				sv := v.(valueStringer).stringValue()
 				sb.WriteString(sv.s)
-				sctx.AddSeq(sv.context.All())
+				sctx = sctx.Union(sv.context)
 			}
 
 			l.stack[concatStart] = stringValue{
@@ -2124,6 +2139,9 @@ func (l *State) concat(ctx context.Context, n int) error {
 				context: sctx,
 			}
 			l.setTop(concatStart + 1)
+			if l.allocStats != nil {
+				l.allocStats.Strings.Add(1)
+			}
 		}
 	}
 	return nil
@@ -2174,11 +2192,10 @@ func stringerTailStart(values []value) int {
 
 // minConcatSize returns the minimum buffer size necessary
 // to concatenate the given values.
-func minConcatSize(values []value) (n int, hasContext bool) {
+func minConcatSize(values []value) (n int) {
 	for _, v := range values {
 		if sv, ok := v.(stringValue); ok {
 			n += len(sv.s)
-			hasContext = hasContext || len(sv.context) > 0
 		} else {
 			// Numbers are non-empty, so add 1.
 			n++
@@ -1760,6 +1760,164 @@ func TestXMove(t *testing.T) {
 	})
 }
 
+func TestSetHook(t *testing.T) {
+	ctx := context.Background()
+	state := new(State)
+	defer func() {
+		if err := state.Close(); err != nil {
+			t.Error("Close:", err)
+		}
+	}()
+
+	const source = "local x = 0\n" +
+		"for i = 1, 10 do\n" +
+		"  x = x + i\n" +
+		"end\n" +
+		"return x\n"
+	var lines []int
+	state.SetHook(func(ctx context.Context, l *State, event HookMask) {
+		if event != MaskCount {
+			t.Errorf("event = %v; want %v", event, MaskCount)
+		}
+		db := l.Info(0)
+		if db == nil {
+			t.Error("l.Info(0) = nil")
+			return
+		}
+		if got, want := db.What, "main"; got != want {
+			t.Errorf("l.Info(0).What = %q; want %q", got, want)
+		}
+		lines = append(lines, db.CurrentLine)
+	}, MaskCount, 1)
+	if err := state.Load(strings.NewReader(source), LiteralSource(source), "t"); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.Call(ctx, 0, 1); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := state.ToInteger(-1); got != 55 || !ok {
+		t.Errorf("result = %d, %t; want 55, true", got, ok)
+	}
+	state.Pop(1)
+	if got, want := lines[0], 1; got != want {
+		t.Errorf("first hook line = %d; want %d", got, want)
+	}
+	if got, want := countValue(lines, 3), 10; got != want {
+		t.Errorf("hook called %d times on line 3; want %d", got, want)
+	}
+
+	// Hook every other instruction.
+	totalCalls := len(lines)
+	lines = nil
+	state.SetHook(func(ctx context.Context, l *State, event HookMask) {
+		lines = append(lines, l.Info(0).CurrentLine)
+	}, MaskCount, 2)
+	if err := state.Load(strings.NewReader(source), LiteralSource(source), "t"); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.Call(ctx, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(lines), totalCalls/2; got != want {
+		t.Errorf("with count = 2, hook called %d times; want %d", got, want)
+	}
+
+	// Line hook.
+	lines = nil
+	state.SetHook(func(ctx context.Context, l *State, event HookMask) {
+		if event != MaskLine {
+			t.Errorf("event = %v; want %v", event, MaskLine)
+		}
+		lines = append(lines, l.Info(0).CurrentLine)
+	}, MaskLine, 0)
+	if err := state.Load(strings.NewReader(source), LiteralSource(source), "t"); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.Call(ctx, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := countValue(lines, 1), 1; got != want {
+		t.Errorf("line hook called %d times for line 1; want %d (lines = %v)", got, want, lines)
+	}
+	if got, want := countValue(lines, 3), 10; got != want {
+		t.Errorf("line hook called %d times for line 3; want %d (lines = %v)", got, want, lines)
+	}
+	if got, want := countValue(lines, 5), 1; got != want {
+		t.Errorf("line hook called %d times for line 5; want %d (lines = %v)", got, want, lines)
+	}
+
+	// Remove the hook.
+	lines = nil
+	state.SetHook(nil, 0, 0)
+	if err := state.Load(strings.NewReader(source), LiteralSource(source), "t"); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.Call(ctx, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) > 0 {
+		t.Errorf("after removing hook, hook called %d times", len(lines))
+	}
+}
+
+func TestSetAllocStats(t *testing.T) {
+	ctx := context.Background()
+	state := new(State)
+	defer func() {
+		if err := state.Close(); err != nil {
+			t.Error("Close:", err)
+		}
+	}()
+
+	const source = "local t = {}\n" +
+		"for i = 1, 3 do\n" +
+		"  t[i] = function() return 'x' .. i end\n" +
+		"end\n" +
+		"return {t[1](), t[2]()}\n"
+	stats := new(AllocStats)
+	state.SetAllocStats(stats)
+	if err := state.Load(strings.NewReader(source), LiteralSource(source), "t"); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.Call(ctx, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	state.NewUserdata(nil, 0)
+	state.Pop(1)
+
+	if got, want := stats.Tables.Load(), int64(2); got != want {
+		t.Errorf("Tables = %d; want %d", got, want)
+	}
+	// The main chunk plus one closure per loop iteration.
+	if got, want := stats.Functions.Load(), int64(4); got != want {
+		t.Errorf("Functions = %d; want %d", got, want)
+	}
+	if got, want := stats.Userdata.Load(), int64(1); got != want {
+		t.Errorf("Userdata = %d; want %d", got, want)
+	}
+	if got, want := stats.Strings.Load(), int64(2); got != want {
+		t.Errorf("Strings = %d; want %d", got, want)
+	}
+
+	// Stop counting.
+	state.SetAllocStats(nil)
+	state.CreateTable(0, 0)
+	state.Pop(1)
+	if got, want := stats.Tables.Load(), int64(2); got != want {
+		t.Errorf("after SetAllocStats(nil), Tables = %d; want %d", got, want)
+	}
+}
+
+func countValue[S ~[]E, E comparable](s S, v E) int {
+	n := 0
+	for _, elem := range s {
+		if elem == v {
+			n++
+		}
+	}
+	return n
+}
+
 func TestRotate(t *testing.T) {
 	tests := []struct {
 		s    []int
@@ -23,6 +23,9 @@ const TableLibraryName = "table"
 // This function is intended to be used as an argument to [Require].
 //
 // All functions in the table library are pure (as per [*State.PushPureFunction]).
+// As an extension, table.sort accepts an optional third argument:
+// if it is true, then the sort is stable
+// (equal elements keep their original order).
 //
 // [table manipulation library]: https://www.lua.org/manual/5.4/manual.html#6.6
 func OpenTable(ctx context.Context, l *State) (int, error) {
@@ -76,20 +79,25 @@ func tableConcat(ctx context.Context, l *State) (int, error) {
 	if first < last {
 		resultContext.AddSeq(separatorContext.All())
 	}
+	// Read elements directly instead of through the stack
+	// to avoid pushing and converting each one.
+	t, _, _ := l.valueByIndex(1)
 	sb := new(strings.Builder)
 	add := func(i int64) error {
-		defer l.SetTop(l.Top())
-		tp, err := l.Index(ctx, 1, i)
+		v, err := l.index(ctx, t, integerValue(i))
 		if err != nil {
 			return err
 		}
-		if tp != TypeString && tp != TypeNumber {
+		switch v := v.(type) {
+		case stringValue:
+			sb.WriteString(v.s)
+			resultContext.AddSeq(v.context.All())
+		case valueStringer:
+			sb.WriteString(v.stringValue().s)
+		default:
 			return fmt.Errorf("%sinvalid value (%s) at index %d in table for 'concat'",
-				Where(l, 1), tp.String(), i)
+				Where(l, 1), valueType(v).String(), i)
 		}
-		s, _ := l.ToString(-1)
-		sb.WriteString(s)
-		resultContext.AddSeq(l.StringContext(-1).All())
 		return nil
 	}
 
@@ -344,34 +352,73 @@ func tableSort(ctx context.Context, l *State) (int, error) {
 	if n < 1 {
 		return 0, err
 	}
-	if n > math.MaxInt {
+	// Same limit as the reference implementation.
+	// It also keeps a bogus __len from allocating an enormous slice.
+	if n > math.MaxInt32 {
 		return 0, NewArgError(l, 1, "array too big")
 	}
 	if tp := l.Type(2); tp != TypeNone && tp != TypeNil && tp != TypeFunction {
 		return 0, NewTypeError(l, 2, TypeFunction.String())
 	}
-	l.SetTop(2)
+	stable := l.ToBoolean(3)
+	l.SetTop(3)
 
+	// Copy the elements into a Go slice, sort the slice,
+	// then write the elements back.
+	// This avoids going through the stack and the table
+	// for every comparison and swap.
+	// The slice grows as elements are read
+	// instead of trusting the length up front,
+	// and reading stops if ctx is canceled.
+	t, _, _ := l.valueByIndex(1)
 	sorter := &tableSorter{
-		ctx: ctx,
-		l:   l,
-		n:   int(n),
+		ctx:    ctx,
+		l:      l,
+		values: make([]value, 0, min(n, 1<<16)),
 	}
-	sort.Sort(sorter)
-	return 0, sorter.err
+	if l.Type(2) == TypeFunction {
+		sorter.compare, _, _ = l.valueByIndex(2)
+	}
+	for i := int64(1); i <= n; i++ {
+		if i%1024 == 0 {
+			if err := ctx.Err(); err != nil {
+				return 0, err
+			}
+		}
+		v, err := l.index(ctx, t, integerValue(i))
+		if err != nil {
+			return 0, err
+		}
+		sorter.values = append(sorter.values, v)
+	}
+	if stable {
+		sort.Stable(sorter)
+	} else {
+		sort.Sort(sorter)
+	}
+	if sorter.err != nil {
+		return 0, sorter.err
+	}
+	for i, v := range sorter.values {
+		if err := l.setIndex(ctx, t, integerValue(i+1), v); err != nil {
+			return 0, err
+		}
+	}
+	return 0, nil
 }
 
 // tableSorter is the helper type that implements [sort.Interface]
 // for [tableSort].
 type tableSorter struct {
-	ctx context.Context
-	l   *State
-	n   int
-	err error
+	ctx     context.Context
+	l       *State
+	values  []value
+	compare value // nil to use the < operator
+	err     error
 }
 
 func (ts *tableSorter) Len() int {
-	return ts.n
+	return len(ts.values)
 }
 
 func (ts *tableSorter) Less(i, j int) bool {
@@ -379,26 +426,28 @@ func (ts *tableSorter) Less(i, j int) bool {
 		// If we errored out, pretend everything is sorted.
 		return i < j
 	}
-	defer ts.l.SetTop(ts.l.Top())
-	hasCompareFunction := !ts.l.IsNoneOrNil(2)
-	if hasCompareFunction {
-		ts.l.PushValue(2)
-	}
-	if _, ts.err = ts.l.Index(ts.ctx, 1, int64(1+i)); ts.err != nil {
-		return i < j
-	}
-	if _, ts.err = ts.l.Index(ts.ctx, 1, int64(1+j)); ts.err != nil {
-		return i < j
-	}
-	if hasCompareFunction {
-		ts.err = ts.l.Call(ts.ctx, 2, 1)
+	v1, v2 := ts.values[i], ts.values[j]
+	if ts.compare != nil {
+		var result value
+		result, ts.err = ts.l.call1(ts.ctx, ts.compare, v1, v2)
 		if ts.err != nil {
 			return i < j
 		}
-		return ts.l.ToBoolean(-1)
+		return toBoolean(result)
+	}
+	// Fast paths for the most common lists.
+	switch v1 := v1.(type) {
+	case integerValue:
+		if v2, ok := v2.(integerValue); ok {
+			return v1 < v2
+		}
+	case stringValue:
+		if v2, ok := v2.(stringValue); ok {
+			return v1.s < v2.s
+		}
 	}
 	var less bool
-	less, ts.err = ts.l.Compare(ts.ctx, -2, -1, Less)
+	less, ts.err = ts.l.compare(ts.ctx, Less, v1, v2)
 	if ts.err != nil {
 		return i < j
 	}
@@ -406,22 +455,7 @@ func (ts *tableSorter) Less(i, j int) bool {
 }
 
 func (ts *tableSorter) Swap(i, j int) {
-	if ts.err != nil {
-		return
-	}
-	defer ts.l.SetTop(ts.l.Top())
-	if _, ts.err = ts.l.Index(ts.ctx, 1, int64(1+i)); ts.err != nil {
-		return
-	}
-	if _, ts.err = ts.l.Index(ts.ctx, 1, int64(1+j)); ts.err != nil {
-		return
-	}
-	if ts.err = ts.l.SetIndex(ts.ctx, 1, int64(1+i)); ts.err != nil {
-		return
-	}
-	if ts.err = ts.l.SetIndex(ts.ctx, 1, int64(1+j)); ts.err != nil {
-		return
-	}
+	ts.values[i], ts.values[j] = ts.values[j], ts.values[i]
 }
 
 func checkTable(l *State, arg int, methods ...luacode.TagMethod) error {
@@ -6,6 +6,7 @@ package lua
 import (
 	"context"
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -311,3 +312,133 @@ func TestTableSort(t *testing.T) {
 		})
 	}
 }
+
+func TestTableSortLua(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  string
+		wantErr bool
+	}{
+		{
+			name: "Strings",
+			source: `local t = {"pear", "apple", "fig"}
+table.sort(t)
+assert(table.concat(t, ",") == "apple,fig,pear")`,
+		},
+		{
+			name: "MixedNumbers",
+			source: `local t = {3, 1.5, 2, -1}
+table.sort(t)
+assert(table.concat(t, ",") == "-1,1.5,2,3")`,
+		},
+		{
+			name: "Stable",
+			source: `local t = {}
+for i = 1, 100 do t[i] = {key = i % 3, i = i} end
+table.sort(t, function(a, b) return a.key < b.key end, true)
+for i = 2, #t do
+  assert(t[i-1].key < t[i].key or (t[i-1].key == t[i].key and t[i-1].i < t[i].i),
+    "not stable at " .. i)
+end`,
+		},
+		{
+			name: "Metamethod",
+			source: `local mt = {__lt = function(a, b) return a.n > b.n end}
+local t = {}
+for i = 1, 5 do t[i] = setmetatable({n = i}, mt) end
+table.sort(t)
+assert(t[1].n == 5 and t[5].n == 1)`,
+		},
+		{
+			name: "TooBig",
+			source: `local t = setmetatable({}, {__len = function() return 1 << 40 end})
+local ok, err = pcall(table.sort, t)
+assert(not ok)
+assert(string.find(err, "array too big", 1, true), err)`,
+		},
+		{
+			name:    "Incomparable",
+			source:  `table.sort({1, "x", 2})`,
+			wantErr: true,
+		},
+		{
+			name: "CompareError",
+			source: `local t = {3, 2, 1}
+local ok = pcall(table.sort, t, function(a, b) error("boom") end)
+assert(not ok)
+assert(table.concat(t, ",") == "3,2,1", "list modified after error")`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			state := new(State)
+			defer func() {
+				if err := state.Close(); err != nil {
+					t.Error("Close:", err)
+				}
+			}()
+			if err := OpenLibraries(ctx, state); err != nil {
+				t.Fatal(err)
+			}
+			if err := state.Load(strings.NewReader(test.source), AbstractSource(test.name), "t"); err != nil {
+				t.Fatal(err)
+			}
+			err := state.Call(ctx, 0, 0)
+			if test.wantErr {
+				if err == nil {
+					t.Error("Call did not return an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func BenchmarkTableSort(b *testing.B) {
+	ctx := context.Background()
+	state := new(State)
+	defer func() {
+		if err := state.Close(); err != nil {
+			b.Error("Close:", err)
+		}
+	}()
+	if err := OpenLibraries(ctx, state); err != nil {
+		b.Fatal(err)
+	}
+
+	const source = `local n, comp = ...
+local t = {}
+for i = 1, n do t[i] = string.format("pkg%08d", (i * 7919) % n) end
+table.sort(t, comp)`
+	benchmarks := []struct {
+		name    string
+		compare string
+	}{
+		{name: "Default", compare: "nil"},
+		{name: "Function", compare: "function(a, b) return a < b end"},
+	}
+	for _, bench := range benchmarks {
+		b.Run(bench.name, func(b *testing.B) {
+			for b.Loop() {
+				if err := state.Load(strings.NewReader(source), AbstractSource("sort"), "t"); err != nil {
+					b.Fatal(err)
+				}
+				state.PushInteger(50000)
+				if err := state.Load(strings.NewReader("return "+bench.compare), AbstractSource("compare"), "t"); err != nil {
+					b.Fatal(err)
+				}
+				if err := state.Call(ctx, 0, 1); err != nil {
+					b.Fatal(err)
+				}
+				if err := state.Call(ctx, 2, 0); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -409,9 +409,11 @@ func (v floatValue) stringValue() stringValue {
 // This interpreter's string values include an optional "context",
 // which is a set of strings that are unioned upon concatenation.
 // Their meaning is application-defined.
+// The context is persistent so that concatenation can share it
+// instead of copying it.
 type stringValue struct {
 	s       string
-	context sets.Set[string]
+	context sets.Persistent[string]
 }
 
 func (v stringValue) valueType() Type {
@@ -427,7 +429,7 @@ func (v stringValue) len() integerValue {
 }
 
 func (v stringValue) isEmpty() bool {
-	return len(v.s) == 0 && len(v.context) == 0
+	return len(v.s) == 0 && v.context.Len() == 0
 }
 
 func (v stringValue) stringValue() stringValue {
@@ -35,6 +35,11 @@ type callFrame struct {
 
 	isTailCall bool
 
+	// hookPC is one more than the pc of the last instruction
+	// that [*State.runHooks] observed in this frame
+	// or zero if no instructions have been observed.
+	hookPC int
+
 	messageHandler *messageHandlerState
 }
 
@@ -197,6 +202,9 @@ func (l *State) exec(ctx context.Context) (err error) {
 				l.setTop(frame.registerStart() + int(currFunction.proto.MaxStackSize))
 			}
 		}
+		if l.hook != nil {
+			l.runHooks(ctx, currFunction, l.frame().pc-1)
+		}
 
 		switch opCode := i.OpCode(); opCode {
 		case luacode.OpMove:
@@ -493,6 +501,9 @@ func (l *State) exec(ctx context.Context) (err error) {
 				return err
 			}
 			*ra = newTable(hashSize + arraySize)
+			if l.allocStats != nil {
+				l.allocStats.Tables.Add(1)
+			}
 		case luacode.OpSelf:
 			r := registers()
 			a := i.ArgA()
@@ -1342,6 +1353,9 @@ func (l *State) exec(ctx context.Context) (err error) {
 				proto:    p,
 				upvalues: upvalues,
 			}
+			if l.allocStats != nil {
+				l.allocStats.Functions.Add(1)
+			}
 		case luacode.OpVararg:
 			frame := l.frame()
 			numWanted := int(i.ArgC()) - 1
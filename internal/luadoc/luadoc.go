@@ -0,0 +1,401 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+// Package luadoc extracts documentation comments from Lua source files.
+//
+// A doc comment is a run of comments on consecutive lines
+// that ends on the line immediately before a definition.
+// Comments that start with "---" (as used by the Lua language server)
+// and plain "--" comments are both recognized.
+// Lines in a doc comment that start with "@" are annotations
+// and are reported separately from the text.
+package luadoc
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"zb.256lights.llc/pkg/internal/lualex"
+)
+
+// Kind is the kind of a documented definition.
+type Kind string
+
+// Known kinds.
+const (
+	// Function is a function definition.
+	Function Kind = "function"
+	// Derivation is a value created by calling derivation
+	// or one of the built-in functions that return a derivation.
+	Derivation Kind = "derivation"
+	// Value is any other value.
+	Value Kind = "value"
+)
+
+// Doc is the documentation for a single definition.
+type Doc struct {
+	// Name is the dotted name of the definition
+	// as it would be accessed by users of the file
+	// (e.g. "hello" or "os.getenv").
+	Name string `json:"name"`
+	Kind Kind   `json:"kind"`
+	// Params is the list of parameter names of a [Function].
+	Params []string `json:"params,omitempty"`
+	// Text is the text of the doc comment without comment markers or annotations.
+	Text string `json:"doc"`
+	// Annotations is the list of annotation lines (e.g. "@param x string")
+	// in the doc comment.
+	Annotations []string `json:"annotations,omitempty"`
+	// Line is the 1-based line number of the definition in the source file.
+	// Zero indicates that the definition does not come from a source file.
+	Line int `json:"line,omitempty"`
+}
+
+// Signature returns the name of the definition
+// followed by its parameters if it is a function.
+func (doc *Doc) Signature() string {
+	if doc.Kind != Function {
+		return doc.Name
+	}
+	return doc.Name + "(" + strings.Join(doc.Params, ", ") + ")"
+}
+
+// derivationFunctions is the set of built-in functions that return a derivation.
+var derivationFunctions = map[string]struct{}{
+	"derivation":   {},
+	"extract":      {},
+	"fetchArchive": {},
+	"fetchurl":     {},
+}
+
+// Extract returns the documentation for the definitions
+// that the Lua source read from r exports.
+// If the source's top-level block ends by returning a local table
+// or a table constructor, then the exported definitions are the fields of that table.
+// Otherwise, the exported definitions are the global variables and functions that the source assigns.
+// Definitions are returned in source order.
+func Extract(r io.ByteScanner) ([]*Doc, error) {
+	tokens, err := scanAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens, locals: make(map[string]struct{})}
+	p.run()
+
+	var docs []*Doc
+	switch {
+	case p.returnTable:
+		docs = p.fields
+	case p.returnName != "":
+		prefix := p.returnName + "."
+		for _, doc := range p.assigns {
+			if name, ok := strings.CutPrefix(doc.Name, prefix); ok {
+				doc.Name = name
+				docs = append(docs, doc)
+			}
+		}
+	default:
+		for _, doc := range p.assigns {
+			first, _, _ := strings.Cut(doc.Name, ".")
+			if _, isLocal := p.locals[first]; !isLocal {
+				docs = append(docs, doc)
+			}
+		}
+	}
+	return docs, nil
+}
+
+// scanAll reads all the tokens (including comments) from r.
+func scanAll(r io.ByteScanner) ([]lualex.Token, error) {
+	s := lualex.NewScanner(r)
+	s.KeepComments = true
+	var tokens []lualex.Token
+	for {
+		tok, err := s.Scan()
+		if err == io.EOF {
+			return tokens, nil
+		}
+		if err != nil {
+			if tok.Position.IsValid() {
+				return nil, fmt.Errorf("%v: %w", tok.Position, err)
+			}
+			return nil, err
+		}
+		tokens = append(tokens, tok)
+	}
+}
+
+type parser struct {
+	tokens []lualex.Token
+	pos    int
+
+	// blockDepth is the number of open blocks (functions, loops, etc.).
+	blockDepth int
+	// nestDepth is the number of open parentheses, braces, and brackets.
+	nestDepth int
+	// prev is the last non-comment token consumed.
+	prev lualex.Token
+	// comments is the run of comments that precedes the current token.
+	comments []lualex.Token
+
+	// locals is the set of local variable names declared in the top-level block.
+	locals map[string]struct{}
+	// assigns is the list of top-level assignments and function statements.
+	assigns []*Doc
+
+	// returnName is the name of the variable that the top-level block returns.
+	returnName string
+	// returnTable is true if the top-level block returns a table constructor.
+	returnTable bool
+	// fieldDepth is the nestDepth of the fields of the returned table constructor
+	// or zero if the parser is not inside the constructor.
+	fieldDepth int
+	// fields is the list of fields in the returned table constructor.
+	fields []*Doc
+}
+
+func (p *parser) run() {
+	for p.pos < len(p.tokens) {
+		tok := p.tokens[p.pos]
+		if tok.Kind == lualex.CommentToken {
+			p.comment(tok)
+			p.pos++
+			continue
+		}
+		doc := p.comments
+		p.comments = nil
+		if len(doc) > 0 && commentEndLine(doc[len(doc)-1])+1 != tok.Position.Line {
+			doc = nil
+		}
+
+		if p.blockDepth == 0 {
+			switch {
+			case p.nestDepth == 0:
+				p.statement(tok, doc)
+			case p.fieldDepth > 0 && p.nestDepth == p.fieldDepth:
+				p.field(tok, doc)
+			}
+		}
+
+		switch tok.Kind {
+		case lualex.FunctionToken, lualex.DoToken, lualex.IfToken, lualex.RepeatToken:
+			p.blockDepth++
+		case lualex.EndToken, lualex.UntilToken:
+			p.blockDepth = max(p.blockDepth-1, 0)
+		case lualex.LParenToken, lualex.LBraceToken, lualex.LBracketToken:
+			p.nestDepth++
+		case lualex.RParenToken, lualex.RBraceToken, lualex.RBracketToken:
+			if p.nestDepth == p.fieldDepth {
+				p.fieldDepth = 0
+			}
+			p.nestDepth = max(p.nestDepth-1, 0)
+		}
+		p.prev = tok
+		p.pos++
+	}
+}
+
+// comment adds tok to the current run of comments.
+// Comments at the end of a line with code
+// and comments separated from the run by a blank line
+// start a new run.
+func (p *parser) comment(tok lualex.Token) {
+	if p.prev.Kind != lualex.ErrorToken && p.prev.Position.Line == tok.Position.Line {
+		// Trailing comment.
+		p.comments = nil
+		return
+	}
+	if len(p.comments) > 0 && commentEndLine(p.comments[len(p.comments)-1])+1 != tok.Position.Line {
+		p.comments = nil
+	}
+	p.comments = append(p.comments, tok)
+}
+
+// statement checks whether tok begins a top-level definition.
+func (p *parser) statement(tok lualex.Token, doc []lualex.Token) {
+	startsStatement := p.prev.Kind == lualex.ErrorToken ||
+		p.prev.Kind == lualex.SemiToken ||
+		p.prev.Position.Line < tok.Position.Line
+	switch tok.Kind {
+	case lualex.LocalToken:
+		for i := p.pos + 1; i < len(p.tokens); i++ {
+			switch next := p.tokens[i]; next.Kind {
+			case lualex.IdentifierToken:
+				p.locals[next.Value] = struct{}{}
+				continue
+			case lualex.FunctionToken, lualex.CommaToken, lualex.LessToken, lualex.GreaterToken, lualex.CommentToken:
+				continue
+			}
+			break
+		}
+	case lualex.FunctionToken:
+		if p.prev.Kind == lualex.LocalToken || !startsStatement {
+			return
+		}
+		name, end := p.dottedName(p.pos + 1)
+		if name == "" {
+			return
+		}
+		if end < len(p.tokens) && p.tokens[end].Kind == lualex.ColonToken &&
+			end+1 < len(p.tokens) && p.tokens[end+1].Kind == lualex.IdentifierToken {
+			name += "." + p.tokens[end+1].Value
+			end += 2
+		}
+		p.assigns = append(p.assigns, &Doc{
+			Name:   name,
+			Kind:   Function,
+			Params: p.params(end),
+			Line:   tok.Position.Line,
+		})
+		setText(p.assigns[len(p.assigns)-1], doc)
+	case lualex.IdentifierToken:
+		if !startsStatement {
+			return
+		}
+		name, end := p.dottedName(p.pos)
+		if end >= len(p.tokens) || p.tokens[end].Kind != lualex.AssignToken {
+			return
+		}
+		d := p.value(name, end+1, tok.Position.Line)
+		setText(d, doc)
+		p.assigns = append(p.assigns, d)
+	case lualex.ReturnToken:
+		if p.pos+1 >= len(p.tokens) {
+			return
+		}
+		switch next := p.tokens[p.pos+1]; {
+		case next.Kind == lualex.LBraceToken:
+			p.returnTable = true
+			p.fieldDepth = p.nestDepth + 1
+		case next.Kind == lualex.IdentifierToken && next.Value == "setmetatable":
+			// return setmetatable(module, ...)
+			if p.pos+3 < len(p.tokens) &&
+				p.tokens[p.pos+2].Kind == lualex.LParenToken &&
+				p.tokens[p.pos+3].Kind == lualex.IdentifierToken {
+				p.returnName = p.tokens[p.pos+3].Value
+			}
+		case next.Kind == lualex.IdentifierToken:
+			p.returnName = next.Value
+		}
+	}
+}
+
+// field checks whether tok begins a field in the returned table constructor.
+func (p *parser) field(tok lualex.Token, doc []lualex.Token) {
+	if tok.Kind != lualex.IdentifierToken {
+		return
+	}
+	switch p.prev.Kind {
+	case lualex.LBraceToken, lualex.CommaToken, lualex.SemiToken:
+	default:
+		return
+	}
+	if p.pos+1 >= len(p.tokens) || p.tokens[p.pos+1].Kind != lualex.AssignToken {
+		return
+	}
+	d := p.value(tok.Value, p.pos+2, tok.Position.Line)
+	setText(d, doc)
+	p.fields = append(p.fields, d)
+}
+
+// dottedName parses a sequence of identifiers separated by dots
+// starting at the token at index i.
+// It returns the name and the index of the first token after the name.
+func (p *parser) dottedName(i int) (name string, end int) {
+	sb := new(strings.Builder)
+	for i < len(p.tokens) && p.tokens[i].Kind == lualex.IdentifierToken {
+		if sb.Len() > 0 {
+			sb.WriteString(".")
+		}
+		sb.WriteString(p.tokens[i].Value)
+		i++
+		if i+1 >= len(p.tokens) || p.tokens[i].Kind != lualex.DotToken {
+			break
+		}
+		i++
+	}
+	return sb.String(), i
+}
+
+// value returns a [Doc] for a definition
+// whose value expression starts at the token at index i.
+func (p *parser) value(name string, i int, line int) *Doc {
+	d := &Doc{Name: name, Kind: Value, Line: line}
+	if i >= len(p.tokens) {
+		return d
+	}
+	switch tok := p.tokens[i]; tok.Kind {
+	case lualex.FunctionToken:
+		d.Kind = Function
+		d.Params = p.params(i + 1)
+	case lualex.IdentifierToken:
+		if _, ok := derivationFunctions[tok.Value]; ok {
+			d.Kind = Derivation
+		}
+	}
+	return d
+}
+
+// params returns the parameter names of a function
+// whose parameter list starts at the token at index i.
+func (p *parser) params(i int) []string {
+	if i >= len(p.tokens) || p.tokens[i].Kind != lualex.LParenToken {
+		return nil
+	}
+	var params []string
+	for i++; i < len(p.tokens); i++ {
+		switch tok := p.tokens[i]; tok.Kind {
+		case lualex.IdentifierToken:
+			params = append(params, tok.Value)
+		case lualex.VarargToken:
+			params = append(params, "...")
+		case lualex.CommaToken, lualex.CommentToken:
+		default:
+			return params
+		}
+	}
+	return params
+}
+
+// setText sets the Text and Annotations fields of d from the given comments.
+// An annotation continues onto the following lines
+// as long as it has unclosed braces or parentheses
+// (as in multi-line type annotations).
+func setText(d *Doc, comments []lualex.Token) {
+	var lines []string
+	open := 0
+	for _, c := range comments {
+		for line := range strings.Lines(c.Value) {
+			line = strings.TrimRight(line, "\r\n")
+			// LuaLS doc comments start with "---".
+			line = strings.TrimPrefix(line, "-")
+			line = strings.TrimPrefix(line, " ")
+			switch {
+			case open > 0:
+				d.Annotations[len(d.Annotations)-1] += "\n" + line
+			case strings.HasPrefix(line, "@"):
+				d.Annotations = append(d.Annotations, strings.TrimSpace(line))
+			default:
+				lines = append(lines, line)
+				continue
+			}
+			open += strings.Count(line, "{") + strings.Count(line, "(") -
+				strings.Count(line, "}") - strings.Count(line, ")")
+			open = max(open, 0)
+		}
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	d.Text = strings.Join(lines, "\n")
+}
+
+// commentEndLine returns the line number of the last line of a comment token.
+func commentEndLine(tok lualex.Token) int {
+	return tok.Position.Line + strings.Count(tok.Value, "\n")
+}
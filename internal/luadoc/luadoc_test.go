@@ -0,0 +1,176 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package luadoc
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestExtract(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   []*Doc
+	}{
+		{
+			name: "Globals",
+			source: "-- Copyright 2026 The zb Authors\n" +
+				"\n" +
+				"---Greet someone.\n" +
+				"---@param name string\n" +
+				"---@param opts {\n" +
+				"---  loud: boolean,\n" +
+				"---}\n" +
+				"function greet(name, opts, ...)\n" +
+				"  local x = 1\n" +
+				"  y = 2\n" +
+				"end\n" +
+				"\n" +
+				"-- The hello package.\n" +
+				"hello = derivation {\n" +
+				"  name = \"hello\";\n" +
+				"}\n" +
+				"\n" +
+				"local helper = 42\n" +
+				"answer = helper -- not a doc comment\n",
+			want: []*Doc{
+				{
+					Name:        "greet",
+					Kind:        Function,
+					Params:      []string{"name", "opts", "..."},
+					Text:        "Greet someone.",
+					Annotations: []string{"@param name string", "@param opts {\n loud: boolean,\n}"},
+					Line:        8,
+				},
+				{
+					Name: "hello",
+					Kind: Derivation,
+					Text: "The hello package.",
+					Line: 14,
+				},
+				{
+					Name: "answer",
+					Kind: Value,
+					Line: 19,
+				},
+			},
+		},
+		{
+			name: "ReturnedLocal",
+			source: "local M <const> = {}\n" +
+				"\n" +
+				"--[[\n" +
+				"Add two numbers.\n" +
+				"]]\n" +
+				"function M.add(a, b)\n" +
+				"  return a + b\n" +
+				"end\n" +
+				"\n" +
+				"---A constant.\n" +
+				"M.pi = 3.14\n" +
+				"\n" +
+				"---Not exported.\n" +
+				"function helper() end\n" +
+				"\n" +
+				"return M\n",
+			want: []*Doc{
+				{
+					Name:   "add",
+					Kind:   Function,
+					Params: []string{"a", "b"},
+					Text:   "Add two numbers.",
+					Line:   6,
+				},
+				{
+					Name: "pi",
+					Kind: Value,
+					Text: "A constant.",
+					Line: 11,
+				},
+			},
+		},
+		{
+			name: "ReturnedTable",
+			source: "local function f() return {x = 1} end\n" +
+				"return {\n" +
+				"  ---Build the thing.\n" +
+				"  thing = fetchurl {\n" +
+				"    url = \"https://example.com/\";\n" +
+				"  };\n" +
+				"  ---Make a value.\n" +
+				"  make = function(x)\n" +
+				"    return { nested = x }\n" +
+				"  end,\n" +
+				"  other = f(),\n" +
+				"}\n",
+			want: []*Doc{
+				{
+					Name: "thing",
+					Kind: Derivation,
+					Text: "Build the thing.",
+					Line: 4,
+				},
+				{
+					Name:   "make",
+					Kind:   Function,
+					Params: []string{"x"},
+					Text:   "Make a value.",
+					Line:   8,
+				},
+				{
+					Name: "other",
+					Kind: Value,
+					Line: 11,
+				},
+			},
+		},
+		{
+			name: "LocalTableNotReturned",
+			source: "local t = {}\n" +
+				"---Not exported.\n" +
+				"function t.f() end\n" +
+				"---Exported.\n" +
+				"function g.f() end\n",
+			want: []*Doc{
+				{
+					Name: "g.f",
+					Kind: Function,
+					Text: "Exported.",
+					Line: 5,
+				},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := Extract(strings.NewReader(test.source))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.want, got, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("Extract(...) (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDocSignature(t *testing.T) {
+	tests := []struct {
+		doc  *Doc
+		want string
+	}{
+		{doc: &Doc{Name: "hello", Kind: Derivation}, want: "hello"},
+		{doc: &Doc{Name: "os.getenv", Kind: Function, Params: []string{"varname"}}, want: "os.getenv(varname)"},
+		{doc: &Doc{Name: "f", Kind: Function}, want: "f()"},
+	}
+	for _, test := range tests {
+		if got := test.doc.Signature(); got != test.want {
+			t.Errorf("(%+v).Signature() = %q; want %q", test.doc, got, test.want)
+		}
+	}
+}
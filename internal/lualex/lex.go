@@ -17,6 +17,10 @@ import (
 
 // A Scanner parses Lua tokens from a byte stream.
 type Scanner struct {
+	// KeepComments causes Scan to return comments as [CommentToken] values
+	// instead of skipping them.
+	KeepComments bool
+
 	r    io.ByteScanner
 	next Position
 	prev Position
@@ -114,23 +118,20 @@ func (s *Scanner) Scan() (Token, error) {
 				return Token{Kind: SubToken, Position: pos}, nil
 			}
 
-			if n, err := s.longOpenBracket(); err == nil {
-				// Long comment.
-				if err := s.findClosingLongBracket(discardByteWriter{}, n); err != nil {
-					s.err = err
-					return Token{Kind: ErrorToken, Position: pos}, err
-				}
-			} else {
-				// Short comment.
-				for {
-					b, err := s.readByte()
-					if err != nil {
-						return Token{}, err
-					}
-					if b == '\n' {
-						break
-					}
-				}
+			var w io.ByteWriter = discardByteWriter{}
+			llw := new(longLiteralWriter)
+			if s.KeepComments {
+				w = llw
+			}
+			err = s.comment(w)
+			switch {
+			case err == io.EOF && !s.KeepComments:
+				return Token{}, err
+			case err != nil && err != io.EOF:
+				s.err = err
+				return Token{Kind: ErrorToken, Position: pos}, err
+			case s.KeepComments:
+				return Token{Kind: CommentToken, Position: pos, Value: llw.String()}, nil
 			}
 		case b == '*':
 			return Token{Kind: MulToken, Position: s.prev}, nil
@@ -284,6 +285,47 @@ func (s *Scanner) Scan() (Token, error) {
 	}
 }
 
+// comment copies the text of a comment to w.
+// The leading "--" must have already been consumed.
+// Long brackets and the newline that ends a short comment are not copied.
+// comment returns [io.EOF] if a short comment ends at the end of the stream.
+func (s *Scanner) comment(w io.ByteWriter) error {
+	b, err := s.readByte()
+	if err != nil {
+		return err
+	}
+	s.unreadByte()
+	if b == '[' {
+		n, err := s.longOpenBracket()
+		if err == nil {
+			// Long comment.
+			return s.findClosingLongBracket(w, n)
+		}
+		// Short comment that starts with an opening bracket.
+		// Copy what longOpenBracket consumed.
+		w.WriteByte('[')
+		for range n {
+			w.WriteByte('=')
+		}
+	}
+
+	// Short comment.
+	for {
+		b, err := s.readByte()
+		if err != nil {
+			return err
+		}
+		if b == '\n' {
+			return nil
+		}
+		if b != '\r' {
+			if err := w.WriteByte(b); err != nil {
+				return err
+			}
+		}
+	}
+}
+
 func (s *Scanner) shortLiteralString(end byte) (string, error) {
 	sb := new(strings.Builder)
 	for {
@@ -337,6 +337,73 @@ func TestScanner(t *testing.T) {
 	}
 }
 
+func TestScannerKeepComments(t *testing.T) {
+	tests := []struct {
+		s    string
+		want []Token
+	}{
+		{
+			s: "-- hello comment\ntest\n",
+			want: []Token{
+				{Kind: CommentToken, Position: Pos(1, 1), Value: " hello comment"},
+				{Kind: IdentifierToken, Position: Pos(2, 1), Value: "test"},
+			},
+		},
+		{
+			s: "---Doc comment\r\nx",
+			want: []Token{
+				{Kind: CommentToken, Position: Pos(1, 1), Value: "-Doc comment"},
+				{Kind: IdentifierToken, Position: Pos(2, 1), Value: "x"},
+			},
+		},
+		{
+			s: "x -- trailing",
+			want: []Token{
+				{Kind: IdentifierToken, Position: Pos(1, 1), Value: "x"},
+				{Kind: CommentToken, Position: Pos(1, 3), Value: " trailing"},
+			},
+		},
+		{
+			s: "--[=[ hello comment\nfake-out: ]]\n]=]\ntest\n",
+			want: []Token{
+				{Kind: CommentToken, Position: Pos(1, 1), Value: " hello comment\nfake-out: ]]\n"},
+				{Kind: IdentifierToken, Position: Pos(4, 1), Value: "test"},
+			},
+		},
+		{
+			s: "--[= not long\n",
+			want: []Token{
+				{Kind: CommentToken, Position: Pos(1, 1), Value: "[= not long"},
+			},
+		},
+		{
+			s: "--",
+			want: []Token{
+				{Kind: CommentToken, Position: Pos(1, 1), Value: ""},
+			},
+		},
+	}
+	for _, test := range tests {
+		s := NewScanner(strings.NewReader(test.s))
+		s.KeepComments = true
+		var got []Token
+		for {
+			tok, err := s.Scan()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Errorf("scan of %q error: %v", test.s, err)
+				break
+			}
+			got = append(got, tok)
+		}
+		if diff := cmp.Diff(test.want, got, cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("scan of %q (-want +got):\n%s", test.s, diff)
+		}
+	}
+}
+
 func TestUnquote(t *testing.T) {
 	tests := []struct {
 		s    string
@@ -12,12 +12,13 @@ type Token struct {
 	Kind     TokenKind
 	Position Position
 	// Value holds information for
-	// an [IdentifierToken], a [StringToken], or a [NumeralToken].
+	// an [IdentifierToken], a [StringToken], a [NumeralToken], or a [CommentToken].
 	Value string
 }
 
 // String formats the token as it would appear in Lua source.
-// String returns "<eof>" for [ErrorToken].
+// String returns "<eof>" for [ErrorToken]
+// and the name of the kind for [CommentToken].
 func (tok Token) String() string {
 	switch tok.Kind {
 	case ErrorToken:
@@ -87,6 +88,11 @@ const (
 	// NumeralToken indicates a numeric constant.
 	// The Value field of [Token] will contain the constant as written.
 	NumeralToken
+	// CommentToken indicates a comment.
+	// The Value field of [Token] will contain the text of the comment
+	// without the leading "--" or long brackets.
+	// Comments are only returned if [Scanner.KeepComments] is true.
+	CommentToken
 
 	// Keywords
 
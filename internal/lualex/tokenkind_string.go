@@ -12,70 +12,72 @@ func _() {
 	_ = x[IdentifierToken-1]
 	_ = x[StringToken-2]
 	_ = x[NumeralToken-3]
-	_ = x[AndToken-4]
-	_ = x[BreakToken-5]
-	_ = x[DoToken-6]
-	_ = x[ElseToken-7]
-	_ = x[ElseifToken-8]
-	_ = x[EndToken-9]
-	_ = x[FalseToken-10]
-	_ = x[ForToken-11]
-	_ = x[FunctionToken-12]
-	_ = x[GotoToken-13]
-	_ = x[IfToken-14]
-	_ = x[InToken-15]
-	_ = x[LocalToken-16]
-	_ = x[NilToken-17]
-	_ = x[NotToken-18]
-	_ = x[OrToken-19]
-	_ = x[RepeatToken-20]
-	_ = x[ReturnToken-21]
-	_ = x[ThenToken-22]
-	_ = x[TrueToken-23]
-	_ = x[UntilToken-24]
-	_ = x[WhileToken-25]
-	_ = x[AddToken-26]
-	_ = x[SubToken-27]
-	_ = x[MulToken-28]
-	_ = x[DivToken-29]
-	_ = x[ModToken-30]
-	_ = x[PowToken-31]
-	_ = x[LenToken-32]
-	_ = x[BitAndToken-33]
-	_ = x[BitXorToken-34]
-	_ = x[BitOrToken-35]
-	_ = x[LShiftToken-36]
-	_ = x[RShiftToken-37]
-	_ = x[IntDivToken-38]
-	_ = x[EqualToken-39]
-	_ = x[NotEqualToken-40]
-	_ = x[LessEqualToken-41]
-	_ = x[GreaterEqualToken-42]
-	_ = x[LessToken-43]
-	_ = x[GreaterToken-44]
-	_ = x[AssignToken-45]
-	_ = x[LParenToken-46]
-	_ = x[RParenToken-47]
-	_ = x[LBraceToken-48]
-	_ = x[RBraceToken-49]
-	_ = x[LBracketToken-50]
-	_ = x[RBracketToken-51]
-	_ = x[LabelToken-52]
-	_ = x[SemiToken-53]
-	_ = x[ColonToken-54]
-	_ = x[CommaToken-55]
-	_ = x[DotToken-56]
-	_ = x[ConcatToken-57]
-	_ = x[VarargToken-58]
+	_ = x[CommentToken-4]
+	_ = x[AndToken-5]
+	_ = x[BreakToken-6]
+	_ = x[DoToken-7]
+	_ = x[ElseToken-8]
+	_ = x[ElseifToken-9]
+	_ = x[EndToken-10]
+	_ = x[FalseToken-11]
+	_ = x[ForToken-12]
+	_ = x[FunctionToken-13]
+	_ = x[GotoToken-14]
+	_ = x[IfToken-15]
+	_ = x[InToken-16]
+	_ = x[LocalToken-17]
+	_ = x[NilToken-18]
+	_ = x[NotToken-19]
+	_ = x[OrToken-20]
+	_ = x[RepeatToken-21]
+	_ = x[ReturnToken-22]
+	_ = x[ThenToken-23]
+	_ = x[TrueToken-24]
+	_ = x[UntilToken-25]
+	_ = x[WhileToken-26]
+	_ = x[AddToken-27]
+	_ = x[SubToken-28]
+	_ = x[MulToken-29]
+	_ = x[DivToken-30]
+	_ = x[ModToken-31]
+	_ = x[PowToken-32]
+	_ = x[LenToken-33]
+	_ = x[BitAndToken-34]
+	_ = x[BitXorToken-35]
+	_ = x[BitOrToken-36]
+	_ = x[LShiftToken-37]
+	_ = x[RShiftToken-38]
+	_ = x[IntDivToken-39]
+	_ = x[EqualToken-40]
+	_ = x[NotEqualToken-41]
+	_ = x[LessEqualToken-42]
+	_ = x[GreaterEqualToken-43]
+	_ = x[LessToken-44]
+	_ = x[GreaterToken-45]
+	_ = x[AssignToken-46]
+	_ = x[LParenToken-47]
+	_ = x[RParenToken-48]
+	_ = x[LBraceToken-49]
+	_ = x[RBraceToken-50]
+	_ = x[LBracketToken-51]
+	_ = x[RBracketToken-52]
+	_ = x[LabelToken-53]
+	_ = x[SemiToken-54]
+	_ = x[ColonToken-55]
+	_ = x[CommaToken-56]
+	_ = x[DotToken-57]
+	_ = x[ConcatToken-58]
+	_ = x[VarargToken-59]
 }
 
-const _TokenKind_name = "ErrorTokenIdentifierTokenStringTokenNumeralTokenandbreakdoelseelseifendfalseforfunctiongotoifinlocalnilnotorrepeatreturnthentrueuntilwhile+-*/%^#&~|<<>>//==~=<=>=<>=(){}[]::;:,......"
+const _TokenKind_name = "ErrorTokenIdentifierTokenStringTokenNumeralTokenCommentTokenandbreakdoelseelseifendfalseforfunctiongotoifinlocalnilnotorrepeatreturnthentrueuntilwhile+-*/%^#&~|<<>>//==~=<=>=<>=(){}[]::;:,......"
 
-var _TokenKind_index = [...]uint8{0, 10, 25, 36, 48, 51, 56, 58, 62, 68, 71, 76, 79, 87, 91, 93, 95, 100, 103, 106, 108, 114, 120, 124, 128, 133, 138, 139, 140, 141, 142, 143, 144, 145, 146, 147, 148, 150, 152, 154, 156, 158, 160, 162, 163, 164, 165, 166, 167, 168, 169, 170, 171, 173, 174, 175, 176, 177, 179, 182}
+var _TokenKind_index = [...]uint8{0, 10, 25, 36, 48, 60, 63, 68, 70, 74, 80, 83, 88, 91, 99, 103, 105, 107, 112, 115, 118, 120, 126, 132, 136, 140, 145, 150, 151, 152, 153, 154, 155, 156, 157, 158, 159, 160, 162, 164, 166, 168, 170, 172, 174, 175, 176, 177, 178, 179, 180, 181, 182, 183, 185, 186, 187, 188, 189, 191, 194}
 
 func (i TokenKind) String() string {
-	if i < 0 || i >= TokenKind(len(_TokenKind_index)-1) {
+	idx := int(i) - 0
+	if i < 0 || idx >= len(_TokenKind_index)-1 {
 		return "TokenKind(" + strconv.FormatInt(int64(i), 10) + ")"
 	}
-	return _TokenKind_name[_TokenKind_index[i]:_TokenKind_index[i+1]]
+	return _TokenKind_name[_TokenKind_index[idx]:_TokenKind_index[idx+1]]
 }
@@ -0,0 +1,415 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+// Package ociimage writes container images
+// in the [OCI image layout] format.
+//
+// [OCI image layout]: https://github.com/opencontainers/image-spec/blob/main/image-layout.md
+package ociimage
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	jsonv2 "github.com/go-json-experiment/json"
+	"zb.256lights.llc/pkg/bytebuffer"
+	"zb.256lights.llc/pkg/sets"
+)
+
+// Media types used in OCI images.
+const (
+	IndexMediaType    = "application/vnd.oci.image.index.v1+json"
+	ManifestMediaType = "application/vnd.oci.image.manifest.v1+json"
+	ConfigMediaType   = "application/vnd.oci.image.config.v1+json"
+	LayerMediaType    = "application/vnd.oci.image.layer.v1.tar"
+)
+
+// modTime is the modification time used for all files in layers
+// so that images are reproducible.
+var modTime = time.Unix(1, 0).UTC()
+
+// Image is the description of a container image to write.
+type Image struct {
+	// Layers is the list of filesystem layers in the image
+	// from bottom to top.
+	Layers []*Layer
+	// Config is the image's configuration.
+	Config Config
+	// Tag is an optional reference for the image (e.g. "hello:latest").
+	Tag string
+}
+
+// A Layer is a set of files in an [Image].
+type Layer struct {
+	// Paths is the list of absolute, slash-separated paths
+	// of files or directories on the local filesystem to include in the layer.
+	// Each path is placed at the same location in the image,
+	// along with its parent directories.
+	Paths []string
+}
+
+// Config is the subset of an [image configuration] that this package supports.
+//
+// [image configuration]: https://github.com/opencontainers/image-spec/blob/main/config.md
+type Config struct {
+	Architecture string
+	OS           string
+	Variant      string
+	Entrypoint   []string
+	Cmd          []string
+	Env          []string
+	WorkingDir   string
+}
+
+// Write writes img to w as a tar archive in the OCI image layout.
+// The archive also includes a manifest.json file
+// so that it can be loaded with "docker load".
+// Layers are staged in buffers created by bufferCreator before being written to w.
+func Write(w io.Writer, img *Image, bufferCreator bytebuffer.Creator) error {
+	tw := tar.NewWriter(w)
+	writtenBlobs := make(sets.Set[string])
+	var layerDescriptors []descriptor
+	var diffIDs []string
+	for i, layer := range img.Layers {
+		desc, err := writeLayer(tw, writtenBlobs, layer, bufferCreator)
+		if err != nil {
+			return fmt.Errorf("write image: layer %d: %v", i+1, err)
+		}
+		layerDescriptors = append(layerDescriptors, desc)
+		// Layers are uncompressed, so the diff ID is the layer digest.
+		diffIDs = append(diffIDs, desc.Digest)
+	}
+
+	configDesc, err := writeJSONBlob(tw, writtenBlobs, ConfigMediaType, &imageConfig{
+		Architecture: img.Config.Architecture,
+		OS:           img.Config.OS,
+		Variant:      img.Config.Variant,
+		Config: containerConfig{
+			Entrypoint: img.Config.Entrypoint,
+			Cmd:        img.Config.Cmd,
+			Env:        img.Config.Env,
+			WorkingDir: img.Config.WorkingDir,
+		},
+		RootFS: rootFS{
+			Type:    "layers",
+			DiffIDs: diffIDs,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("write image: config: %v", err)
+	}
+	manifestDesc, err := writeJSONBlob(tw, writtenBlobs, ManifestMediaType, &manifest{
+		SchemaVersion: 2,
+		MediaType:     ManifestMediaType,
+		Config:        configDesc,
+		Layers:        layerDescriptors,
+	})
+	if err != nil {
+		return fmt.Errorf("write image: manifest: %v", err)
+	}
+
+	if img.Tag != "" {
+		manifestDesc.Annotations = map[string]string{
+			"io.containerd.image.name":          img.Tag,
+			"org.opencontainers.image.ref.name": tagName(img.Tag),
+		}
+	}
+	if err := writeJSONFile(tw, "index.json", &index{
+		SchemaVersion: 2,
+		MediaType:     IndexMediaType,
+		Manifests:     []descriptor{manifestDesc},
+	}); err != nil {
+		return fmt.Errorf("write image: %v", err)
+	}
+	if err := writeJSONFile(tw, "oci-layout", &layoutFile{Version: "1.0.0"}); err != nil {
+		return fmt.Errorf("write image: %v", err)
+	}
+
+	dockerManifest := dockerManifestEntry{
+		Config: blobPath(configDesc.Digest),
+		Layers: make([]string, 0, len(layerDescriptors)),
+	}
+	if img.Tag != "" {
+		dockerManifest.RepoTags = []string{img.Tag}
+	}
+	for _, desc := range layerDescriptors {
+		dockerManifest.Layers = append(dockerManifest.Layers, blobPath(desc.Digest))
+	}
+	if err := writeJSONFile(tw, "manifest.json", []dockerManifestEntry{dockerManifest}); err != nil {
+		return fmt.Errorf("write image: %v", err)
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("write image: %v", err)
+	}
+	return nil
+}
+
+// writeLayer writes the layer as a blob to tw.
+func writeLayer(tw *tar.Writer, writtenBlobs sets.Set[string], layer *Layer, bufferCreator bytebuffer.Creator) (descriptor, error) {
+	buf, err := bufferCreator.CreateBuffer(-1)
+	if err != nil {
+		return descriptor{}, err
+	}
+	defer buf.Close()
+
+	h := sha256.New()
+	cw := &countWriter{w: io.MultiWriter(buf, h)}
+	layerWriter := tar.NewWriter(cw)
+	writtenDirs := make(sets.Set[string])
+	for _, p := range layer.Paths {
+		if err := addParentDirectories(layerWriter, writtenDirs, p); err != nil {
+			return descriptor{}, err
+		}
+		if err := addTree(layerWriter, p); err != nil {
+			return descriptor{}, err
+		}
+	}
+	if err := layerWriter.Close(); err != nil {
+		return descriptor{}, err
+	}
+
+	desc := descriptor{
+		MediaType: LayerMediaType,
+		Digest:    "sha256:" + hex.EncodeToString(h.Sum(nil)),
+		Size:      cw.n,
+	}
+	if writtenBlobs.Has(desc.Digest) {
+		return desc, nil
+	}
+	if _, err := buf.Seek(0, io.SeekStart); err != nil {
+		return descriptor{}, err
+	}
+	if err := writeBlobHeader(tw, desc); err != nil {
+		return descriptor{}, err
+	}
+	if _, err := io.CopyN(tw, buf, desc.Size); err != nil {
+		return descriptor{}, err
+	}
+	writtenBlobs.Add(desc.Digest)
+	return desc, nil
+}
+
+// addParentDirectories writes directory entries for each of the parents of p
+// that are not in writtenDirs.
+func addParentDirectories(tw *tar.Writer, writtenDirs sets.Set[string], p string) error {
+	name := strings.TrimPrefix(path.Clean(p), "/")
+	var parents []string
+	for dir := path.Dir(name); dir != "." && !writtenDirs.Has(dir); dir = path.Dir(dir) {
+		parents = append(parents, dir)
+		writtenDirs.Add(dir)
+	}
+	for i := len(parents) - 1; i >= 0; i-- {
+		err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeDir,
+			Name:     parents[i] + "/",
+			Mode:     0o755,
+			ModTime:  modTime,
+			Format:   tar.FormatPAX,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addTree writes the file or directory tree at root to tw.
+// Ownership and timestamps are normalized.
+func addTree(tw *tar.Writer, root string) error {
+	return filepath.WalkDir(filepath.FromSlash(root), func(fsPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		hdr := &tar.Header{
+			Name:    strings.TrimPrefix(filepath.ToSlash(fsPath), "/"),
+			ModTime: modTime,
+			Format:  tar.FormatPAX,
+		}
+		switch info.Mode().Type() {
+		case 0:
+			hdr.Typeflag = tar.TypeReg
+			hdr.Size = info.Size()
+			hdr.Mode = 0o444
+			if info.Mode()&0o111 != 0 {
+				hdr.Mode = 0o555
+			}
+		case fs.ModeDir:
+			hdr.Typeflag = tar.TypeDir
+			hdr.Name += "/"
+			hdr.Mode = 0o555
+		case fs.ModeSymlink:
+			hdr.Typeflag = tar.TypeSymlink
+			hdr.Mode = 0o777
+			hdr.Linkname, err = os.Readlink(fsPath)
+			if err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("%s: unsupported file type", fsPath)
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			return nil
+		}
+		f, err := os.Open(fsPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if _, err := io.CopyN(tw, f, hdr.Size); err != nil {
+			return fmt.Errorf("%s: %v", fsPath, err)
+		}
+		return nil
+	})
+}
+
+func writeJSONBlob(tw *tar.Writer, writtenBlobs sets.Set[string], mediaType string, v any) (descriptor, error) {
+	data, err := jsonv2.Marshal(v)
+	if err != nil {
+		return descriptor{}, err
+	}
+	sum := sha256.Sum256(data)
+	desc := descriptor{
+		MediaType: mediaType,
+		Digest:    "sha256:" + hex.EncodeToString(sum[:]),
+		Size:      int64(len(data)),
+	}
+	if writtenBlobs.Has(desc.Digest) {
+		return desc, nil
+	}
+	if err := writeBlobHeader(tw, desc); err != nil {
+		return descriptor{}, err
+	}
+	if _, err := tw.Write(data); err != nil {
+		return descriptor{}, err
+	}
+	writtenBlobs.Add(desc.Digest)
+	return desc, nil
+}
+
+func writeBlobHeader(tw *tar.Writer, desc descriptor) error {
+	return tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     blobPath(desc.Digest),
+		Size:     desc.Size,
+		Mode:     0o444,
+		ModTime:  modTime,
+		Format:   tar.FormatPAX,
+	})
+}
+
+func writeJSONFile(tw *tar.Writer, name string, v any) error {
+	data, err := jsonv2.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	err = tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     int64(len(data)),
+		Mode:     0o444,
+		ModTime:  modTime,
+		Format:   tar.FormatPAX,
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	if _, err := tw.Write(data); err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	return nil
+}
+
+// blobPath returns the path of the blob with the given digest
+// in the image layout.
+func blobPath(digest string) string {
+	algo, encoded, _ := strings.Cut(digest, ":")
+	return "blobs/" + algo + "/" + encoded
+}
+
+// tagName returns the tag portion of an image reference
+// (e.g. "latest" for "hello:latest").
+func tagName(ref string) string {
+	i := strings.LastIndexAny(ref, ":/")
+	if i < 0 || ref[i] != ':' {
+		return "latest"
+	}
+	return ref[i+1:]
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+type descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitzero"`
+}
+
+type index struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType"`
+	Manifests     []descriptor `json:"manifests"`
+}
+
+type manifest struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType"`
+	Config        descriptor   `json:"config"`
+	Layers        []descriptor `json:"layers"`
+}
+
+type imageConfig struct {
+	Architecture string          `json:"architecture"`
+	OS           string          `json:"os"`
+	Variant      string          `json:"variant,omitzero"`
+	Config       containerConfig `json:"config"`
+	RootFS       rootFS          `json:"rootfs"`
+}
+
+type containerConfig struct {
+	Entrypoint []string `json:"Entrypoint,omitzero"`
+	Cmd        []string `json:"Cmd,omitzero"`
+	Env        []string `json:"Env,omitzero"`
+	WorkingDir string   `json:"WorkingDir,omitzero"`
+}
+
+type rootFS struct {
+	Type    string   `json:"type"`
+	DiffIDs []string `json:"diff_ids"`
+}
+
+type layoutFile struct {
+	Version string `json:"imageLayoutVersion"`
+}
+
+type dockerManifestEntry struct {
+	Config   string   `json:"Config"`
+	RepoTags []string `json:"RepoTags"`
+	Layers   []string `json:"Layers"`
+}
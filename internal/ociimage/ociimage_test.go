@@ -0,0 +1,169 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package ociimage
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	jsonv2 "github.com/go-json-experiment/json"
+	"github.com/google/go-cmp/cmp"
+	"zb.256lights.llc/pkg/bytebuffer"
+)
+
+func TestWrite(t *testing.T) {
+	dir := t.TempDir()
+	libDir := filepath.Join(dir, "lib")
+	appDir := filepath.Join(dir, "app")
+	if err := os.MkdirAll(filepath.Join(appDir, "bin"), 0o777); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(libDir), []byte("library\n"), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(appDir, "bin", "hello"), []byte("#!/bin/sh\n"), 0o777); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("hello", filepath.Join(appDir, "bin", "hi")); err != nil {
+		t.Fatal(err)
+	}
+
+	img := &Image{
+		Layers: []*Layer{
+			{Paths: []string{filepath.ToSlash(libDir)}},
+			{Paths: []string{filepath.ToSlash(appDir)}},
+		},
+		Config: Config{
+			Architecture: "amd64",
+			OS:           "linux",
+			Entrypoint:   []string{filepath.ToSlash(filepath.Join(appDir, "bin", "hello"))},
+		},
+		Tag: "hello:latest",
+	}
+	buf := new(bytes.Buffer)
+	if err := Write(buf, img, bytebuffer.BufferCreator{}); err != nil {
+		t.Fatal(err)
+	}
+	files := readTar(t, bytes.NewReader(buf.Bytes()))
+
+	for name, data := range files {
+		if !strings.HasPrefix(name, "blobs/sha256/") {
+			continue
+		}
+		sum := sha256.Sum256(data)
+		if got, want := hex.EncodeToString(sum[:]), strings.TrimPrefix(name, "blobs/sha256/"); got != want {
+			t.Errorf("%s has digest %s", name, got)
+		}
+	}
+
+	var layout layoutFile
+	if err := jsonv2.Unmarshal(files["oci-layout"], &layout); err != nil {
+		t.Error("oci-layout:", err)
+	} else if layout.Version != "1.0.0" {
+		t.Errorf("imageLayoutVersion = %q; want %q", layout.Version, "1.0.0")
+	}
+
+	var idx index
+	if err := jsonv2.Unmarshal(files["index.json"], &idx); err != nil {
+		t.Fatal("index.json:", err)
+	}
+	if len(idx.Manifests) != 1 {
+		t.Fatalf("index.json has %d manifests; want 1", len(idx.Manifests))
+	}
+	if got, want := idx.Manifests[0].Annotations["org.opencontainers.image.ref.name"], "latest"; got != want {
+		t.Errorf("ref.name annotation = %q; want %q", got, want)
+	}
+	var m manifest
+	if err := jsonv2.Unmarshal(files[blobPath(idx.Manifests[0].Digest)], &m); err != nil {
+		t.Fatal("manifest:", err)
+	}
+	if len(m.Layers) != 2 {
+		t.Fatalf("manifest has %d layers; want 2", len(m.Layers))
+	}
+	var config imageConfig
+	if err := jsonv2.Unmarshal(files[blobPath(m.Config.Digest)], &config); err != nil {
+		t.Fatal("config:", err)
+	}
+	if diff := cmp.Diff(img.Config.Entrypoint, config.Config.Entrypoint); diff != "" {
+		t.Errorf("entrypoint (-want +got):\n%s", diff)
+	}
+	if got, want := config.RootFS.DiffIDs, []string{m.Layers[0].Digest, m.Layers[1].Digest}; !slices.Equal(got, want) {
+		t.Errorf("diff_ids = %q; want %q", got, want)
+	}
+
+	var dockerManifest []dockerManifestEntry
+	if err := jsonv2.Unmarshal(files["manifest.json"], &dockerManifest); err != nil {
+		t.Fatal("manifest.json:", err)
+	}
+	wantDockerManifest := []dockerManifestEntry{{
+		Config:   blobPath(m.Config.Digest),
+		RepoTags: []string{"hello:latest"},
+		Layers:   []string{blobPath(m.Layers[0].Digest), blobPath(m.Layers[1].Digest)},
+	}}
+	if diff := cmp.Diff(wantDockerManifest, dockerManifest); diff != "" {
+		t.Errorf("manifest.json (-want +got):\n%s", diff)
+	}
+
+	appLayer := readTar(t, bytes.NewReader(files[blobPath(m.Layers[1].Digest)]))
+	appName := strings.TrimPrefix(filepath.ToSlash(appDir), "/")
+	if got, want := string(appLayer[appName+"/bin/hello"]), "#!/bin/sh\n"; got != want {
+		t.Errorf("app layer %s/bin/hello = %q; want %q", appName, got, want)
+	}
+	if _, ok := appLayer[strings.TrimPrefix(filepath.ToSlash(libDir), "/")]; ok {
+		t.Errorf("app layer contains lib")
+	}
+}
+
+func TestWriteReproducible(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "file"), []byte("Hello\n"), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	img := &Image{
+		Layers: []*Layer{{Paths: []string{filepath.ToSlash(dir)}}},
+		Config: Config{Architecture: "amd64", OS: "linux"},
+	}
+	buf1 := new(bytes.Buffer)
+	if err := Write(buf1, img, bytebuffer.BufferCreator{}); err != nil {
+		t.Fatal(err)
+	}
+	buf2 := new(bytes.Buffer)
+	if err := Write(buf2, img, bytebuffer.BufferCreator{}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf1.Bytes(), buf2.Bytes()) {
+		t.Error("Image differs between writes")
+	}
+}
+
+func readTar(tb testing.TB, r io.Reader) map[string][]byte {
+	tb.Helper()
+	files := make(map[string][]byte)
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		if err != nil {
+			tb.Fatal(err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		files[hdr.Name] = data
+	}
+}
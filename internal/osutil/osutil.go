@@ -88,6 +88,8 @@ const (
 // If the path names a directory,
 // then this applies recursively to any filesystem objects in the directory.
 // If epoch is non-zero, the modification time of all files is set to epoch.
+// Symbolic links (and directory junctions on Windows) are left as-is:
+// changing their permissions or times would modify their targets.
 //
 // If onError is not nil, it will be used to handle any errors encountered.
 // Its return value is handled in the same manner as in [io/fs.WalkDirFunc].
@@ -99,6 +101,16 @@ func Freeze(path string, epoch time.Time, onError func(error) error) error {
 		if err != nil {
 			return onError(err)
 		}
+		if isLink(entry) {
+			if IsRoot() {
+				if err := os.Lchown(path, rootUID, rootGID); err != nil {
+					if err = onError(err); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		}
 
 		existingMode := os.FileMode(0o666)
 		if runtime.GOOS != "windows" {
@@ -140,6 +152,91 @@ func Freeze(path string, epoch time.Time, onError func(error) error) error {
 	})
 }
 
+// StripXattrs removes the extended attributes
+// from the filesystem object at the given path.
+// If the path names a directory,
+// then this applies recursively to any filesystem objects in the directory.
+// Symbolic links are not followed.
+// On Linux, this includes POSIX ACLs
+// but not attributes in the "security" namespace (like SELinux labels),
+// which are managed by the operating system.
+// On macOS, this includes resource forks and Finder information.
+// On other platforms, StripXattrs does nothing.
+//
+// StripXattrs must be called before [Freeze]:
+// removing an extended attribute requires write permission.
+// If onError is not nil, it will be used to handle any errors encountered.
+// Its return value is handled in the same manner as in [io/fs.WalkDirFunc].
+func StripXattrs(path string, onError func(error) error) error {
+	if !supportsXattrs {
+		return nil
+	}
+	if onError == nil {
+		onError = func(err error) error { return err }
+	}
+	return filepath.WalkDir(path, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return onError(err)
+		}
+		if err := removeXattrs(path); err != nil {
+			if err = onError(err); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ForceRemoveAll removes path and any children it contains like [os.RemoveAll],
+// but if the removal fails, ForceRemoveAll restores write permissions
+// (as removed by [Freeze]) to path and its children and then tries again.
+// On Windows, this clears the read-only attribute,
+// which otherwise prevents files from being deleted.
+// Symbolic links (and directory junctions on Windows) are removed
+// without following them or modifying their targets.
+// Long paths on Windows are handled by the [os] package.
+func ForceRemoveAll(path string) error {
+	err := os.RemoveAll(path)
+	if err == nil {
+		return nil
+	}
+	makeWritable(path)
+	return os.RemoveAll(path)
+}
+
+// makeWritable adds write permissions for the owner
+// to the directories in the tree rooted at path.
+// On Windows, it also adds write permissions to files.
+// makeWritable does the best it can and ignores any errors.
+func makeWritable(path string) {
+	filepath.WalkDir(path, func(path string, entry os.DirEntry, err error) error {
+		if err != nil || isLink(entry) {
+			return nil
+		}
+		if !entry.IsDir() && runtime.GOOS != "windows" {
+			// Unix permits removing a read-only file from a writable directory.
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		mode := info.Mode().Perm() | 0o200
+		if entry.IsDir() {
+			mode |= 0o700
+		}
+		os.Chmod(path, mode)
+		return nil
+	})
+}
+
+// isLink reports whether entry is a symbolic link
+// or some other kind of link like a Windows directory junction.
+// os.Chmod, os.Chtimes, and os.Chown on such entries follow the link.
+func isLink(entry os.DirEntry) bool {
+	return entry.Type()&(os.ModeSymlink|os.ModeIrregular) != 0
+}
+
 // MkdirAllInRoot creates a directory inside root named path,
 // along with any necessary parents, and returns nil,
 // or else returns an error.
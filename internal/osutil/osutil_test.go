@@ -0,0 +1,65 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package osutil
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFreezeAndForceRemoveAll(t *testing.T) {
+	dir := t.TempDir()
+	outside := filepath.Join(dir, "outside.txt")
+	if err := os.WriteFile(outside, []byte("outside\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	outsideInfo, err := os.Stat(outside)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := filepath.Join(dir, "obj")
+	if err := os.MkdirAll(filepath.Join(root, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "sub", "file.txt"), []byte("hello\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "link")); err != nil {
+		t.Skip("Cannot create symlinks:", err)
+	}
+
+	if err := Freeze(root, time.Unix(0, 0), nil); err != nil {
+		t.Fatal("Freeze:", err)
+	}
+	info, err := os.Stat(filepath.Join(root, "sub", "file.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := info.Mode().Perm(); got&0o222 != 0 {
+		t.Errorf("after Freeze, file mode = %v; want no write permissions", got)
+	}
+	newOutsideInfo, err := os.Stat(outside)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := newOutsideInfo.Mode(), outsideInfo.Mode(); got != want {
+		t.Errorf("after Freeze, symlink target mode = %v; want %v", got, want)
+	}
+	if got, want := newOutsideInfo.ModTime(), outsideInfo.ModTime(); !got.Equal(want) {
+		t.Errorf("after Freeze, symlink target modification time = %v; want %v", got, want)
+	}
+
+	if err := ForceRemoveAll(root); err != nil {
+		t.Error("ForceRemoveAll:", err)
+	}
+	if _, err := os.Lstat(root); !os.IsNotExist(err) {
+		t.Errorf("after ForceRemoveAll, Lstat(%q) = _, %v; want not exist", root, err)
+	}
+	if _, err := os.Stat(outside); err != nil {
+		t.Errorf("symlink target was removed: %v", err)
+	}
+}
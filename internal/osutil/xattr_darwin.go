@@ -0,0 +1,14 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package osutil
+
+import "golang.org/x/sys/unix"
+
+// errNoXattr is the error returned when removing an extended attribute that does not exist.
+const errNoXattr = unix.ENOATTR
+
+// isSystemXattr reports whether the extended attribute is managed by the operating system.
+func isSystemXattr(name string) bool {
+	return false
+}
@@ -0,0 +1,12 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+//go:build !(linux || darwin)
+
+package osutil
+
+const supportsXattrs = false
+
+func removeXattrs(path string) error {
+	return nil
+}
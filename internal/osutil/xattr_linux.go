@@ -0,0 +1,19 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package osutil
+
+import (
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// errNoXattr is the error returned when removing an extended attribute that does not exist.
+const errNoXattr = unix.ENODATA
+
+// isSystemXattr reports whether the extended attribute is managed by the operating system.
+// Attributes in the "security" namespace are used by Linux Security Modules like SELinux.
+func isSystemXattr(name string) bool {
+	return strings.HasPrefix(name, "security.")
+}
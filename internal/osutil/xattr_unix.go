@@ -0,0 +1,72 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+//go:build linux || darwin
+
+package osutil
+
+import (
+	"bytes"
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+const supportsXattrs = true
+
+// removeXattrs removes the extended attributes from the file at path
+// without following symbolic links.
+func removeXattrs(path string) error {
+	names, err := listXattrs(path)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if isSystemXattr(name) {
+			continue
+		}
+		err := ignoringEINTR(func() error {
+			return unix.Lremovexattr(path, name)
+		})
+		if err != nil && !errors.Is(err, errNoXattr) {
+			return &os.PathError{Op: "removexattr " + name, Path: path, Err: err}
+		}
+	}
+	return nil
+}
+
+func listXattrs(path string) ([]string, error) {
+	var buf []byte
+	for {
+		n, err := ignoringEINTR2(func() (int, error) {
+			return unix.Llistxattr(path, buf)
+		})
+		if errors.Is(err, unix.ENOTSUP) {
+			// Filesystem does not support extended attributes.
+			return nil, nil
+		}
+		if errors.Is(err, unix.ERANGE) {
+			// Attributes were added since the size query.
+			buf = nil
+			continue
+		}
+		if err != nil {
+			return nil, &os.PathError{Op: "listxattr", Path: path, Err: err}
+		}
+		if buf == nil {
+			if n == 0 {
+				return nil, nil
+			}
+			buf = make([]byte, n)
+			continue
+		}
+		var names []string
+		for name := range bytes.SplitSeq(buf[:n], []byte{0}) {
+			if len(name) > 0 {
+				names = append(names, string(name))
+			}
+		}
+		return names, nil
+	}
+}
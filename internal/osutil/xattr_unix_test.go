@@ -0,0 +1,61 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+//go:build linux || darwin
+
+package osutil
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestStripXattrs(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "obj")
+	if err := os.MkdirAll(filepath.Join(root, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	paths := []string{
+		root,
+		filepath.Join(root, "sub"),
+		filepath.Join(root, "sub", "file.txt"),
+	}
+	if err := os.WriteFile(paths[len(paths)-1], []byte("hello\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	const attrName = "user.zb.test"
+	for _, p := range paths {
+		if err := unix.Lsetxattr(p, attrName, []byte("x"), 0); errors.Is(err, unix.ENOTSUP) || errors.Is(err, unix.EPERM) {
+			t.Skip("Extended attributes not supported:", err)
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := StripXattrs(root, nil); err != nil {
+		t.Error("StripXattrs:", err)
+	}
+	if err := Freeze(root, time.Unix(0, 0), nil); err != nil {
+		t.Error("Freeze:", err)
+	}
+	for _, p := range paths {
+		names, err := listXattrs(p)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		for _, name := range names {
+			if name == attrName {
+				t.Errorf("%s still has extended attribute %s", p, name)
+			}
+		}
+	}
+	if err := ForceRemoveAll(root); err != nil {
+		t.Error("ForceRemoveAll:", err)
+	}
+}
@@ -0,0 +1,378 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+// Package selfextract reads and writes self-extracting bundles of store objects.
+//
+// A bundle is an executable (the stub)
+// followed by a zstd-compressed tar archive of store objects,
+// a JSON-encoded [Manifest], and a fixed-size trailer.
+// When a bundle is extracted, references to the original store directory
+// are rewritten to a new directory of the same length
+// so that binaries continue to work.
+package selfextract
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	jsonv2 "github.com/go-json-experiment/json"
+	"github.com/klauspost/compress/zstd"
+	"zb.256lights.llc/pkg/zbstore"
+)
+
+// magic is the last bytes of a bundle.
+const magic = "zbbundl1"
+
+// trailerSize is the number of bytes at the end of a bundle
+// that describe the location of the payload and manifest.
+const trailerSize = 8 + 8 + len(magic)
+
+// ErrNotBundle is returned by [Open] for files that are not bundles.
+var ErrNotBundle = errors.New("not a bundle")
+
+// Manifest describes the contents of a bundle.
+type Manifest struct {
+	// StoreDirectory is the store directory that the objects were built for.
+	StoreDirectory zbstore.Directory `json:"storeDirectory"`
+	// Objects is the list of store objects in the bundle.
+	Objects []zbstore.Path `json:"objects"`
+	// Program is the path of the program to run after extraction.
+	// It must be inside one of the objects.
+	Program string `json:"program"`
+}
+
+// Write writes a bundle to w.
+// stub is copied to the beginning of the bundle.
+// The store objects in m are read from the local filesystem.
+func Write(w io.Writer, stub io.Reader, m *Manifest) error {
+	if err := m.validate(); err != nil {
+		return fmt.Errorf("write bundle: %v", err)
+	}
+	manifestJSON, err := jsonv2.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("write bundle: %v", err)
+	}
+	if _, err := io.Copy(w, stub); err != nil {
+		return fmt.Errorf("write bundle: %v", err)
+	}
+
+	cw := &countWriter{w: w}
+	zw, err := zstd.NewWriter(cw, zstd.WithEncoderLevel(zstd.SpeedBestCompression))
+	if err != nil {
+		return fmt.Errorf("write bundle: %v", err)
+	}
+	tw := tar.NewWriter(zw)
+	for _, obj := range m.Objects {
+		if err := addTree(tw, string(m.StoreDirectory), obj.Base()); err != nil {
+			return fmt.Errorf("write bundle: %v", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("write bundle: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("write bundle: %v", err)
+	}
+
+	trailer := make([]byte, 0, len(manifestJSON)+trailerSize)
+	trailer = append(trailer, manifestJSON...)
+	trailer = binary.BigEndian.AppendUint64(trailer, uint64(cw.n))
+	trailer = binary.BigEndian.AppendUint64(trailer, uint64(len(manifestJSON)))
+	trailer = append(trailer, magic...)
+	if _, err := w.Write(trailer); err != nil {
+		return fmt.Errorf("write bundle: %v", err)
+	}
+	return nil
+}
+
+func (m *Manifest) validate() error {
+	if !strings.HasPrefix(string(m.StoreDirectory), "/") {
+		return fmt.Errorf("store directory %s is not an absolute Unix path", m.StoreDirectory)
+	}
+	if len(m.Objects) == 0 {
+		return errors.New("no store objects")
+	}
+	for _, obj := range m.Objects {
+		if obj.Dir() != m.StoreDirectory {
+			return fmt.Errorf("%s is not in %s", obj, m.StoreDirectory)
+		}
+	}
+	storePath, _, err := m.StoreDirectory.ParsePath(m.Program)
+	if err != nil {
+		return fmt.Errorf("program: %v", err)
+	}
+	found := false
+	for _, obj := range m.Objects {
+		if obj == storePath {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("program %s is not in bundle", m.Program)
+	}
+	return nil
+}
+
+// addTree writes the file or directory tree at dir/name to tw,
+// using names relative to dir.
+func addTree(tw *tar.Writer, dir, name string) error {
+	root := filepath.Join(filepath.FromSlash(dir), name)
+	return filepath.WalkDir(root, func(fsPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(filepath.FromSlash(dir), fsPath)
+		if err != nil {
+			return err
+		}
+		hdr := &tar.Header{
+			Name:   filepath.ToSlash(rel),
+			Format: tar.FormatPAX,
+		}
+		switch info.Mode().Type() {
+		case 0:
+			hdr.Typeflag = tar.TypeReg
+			hdr.Size = info.Size()
+			hdr.Mode = 0o444
+			if info.Mode()&0o111 != 0 {
+				hdr.Mode = 0o555
+			}
+		case fs.ModeDir:
+			hdr.Typeflag = tar.TypeDir
+			hdr.Name += "/"
+			hdr.Mode = 0o555
+		case fs.ModeSymlink:
+			hdr.Typeflag = tar.TypeSymlink
+			hdr.Mode = 0o777
+			hdr.Linkname, err = os.Readlink(fsPath)
+			if err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("%s: unsupported file type", fsPath)
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			return nil
+		}
+		f, err := os.Open(fsPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if _, err := io.CopyN(tw, f, hdr.Size); err != nil {
+			return fmt.Errorf("%s: %v", fsPath, err)
+		}
+		return nil
+	})
+}
+
+// Bundle is an open bundle.
+type Bundle struct {
+	Manifest *Manifest
+
+	r             io.ReaderAt
+	payloadOffset int64
+	payloadSize   int64
+}
+
+// Open reads the manifest of the bundle stored in r,
+// which has the given size in bytes.
+// If r does not end with a bundle trailer,
+// Open returns an error that wraps [ErrNotBundle].
+func Open(r io.ReaderAt, size int64) (*Bundle, error) {
+	if size < int64(trailerSize) {
+		return nil, ErrNotBundle
+	}
+	var trailer [trailerSize]byte
+	if _, err := r.ReadAt(trailer[:], size-int64(len(trailer))); err != nil {
+		return nil, fmt.Errorf("open bundle: %v", err)
+	}
+	if string(trailer[16:]) != magic {
+		return nil, ErrNotBundle
+	}
+	payloadSize := binary.BigEndian.Uint64(trailer[:8])
+	manifestSize := binary.BigEndian.Uint64(trailer[8:16])
+	manifestOffset := size - int64(trailerSize) - int64(manifestSize)
+	payloadOffset := manifestOffset - int64(payloadSize)
+	if payloadSize > uint64(size) || manifestSize > uint64(size) || payloadOffset < 0 {
+		return nil, fmt.Errorf("open bundle: %w (corrupt trailer)", ErrNotBundle)
+	}
+	manifestJSON := make([]byte, manifestSize)
+	if _, err := r.ReadAt(manifestJSON, manifestOffset); err != nil {
+		return nil, fmt.Errorf("open bundle: %v", err)
+	}
+	b := &Bundle{
+		Manifest:      new(Manifest),
+		r:             r,
+		payloadOffset: payloadOffset,
+		payloadSize:   int64(payloadSize),
+	}
+	if err := jsonv2.Unmarshal(manifestJSON, b.Manifest); err != nil {
+		return nil, fmt.Errorf("open bundle: manifest: %v", err)
+	}
+	if err := b.Manifest.validate(); err != nil {
+		return nil, fmt.Errorf("open bundle: manifest: %v", err)
+	}
+	return b, nil
+}
+
+// StubSize returns the size of the stub executable at the beginning of the bundle.
+func (b *Bundle) StubSize() int64 {
+	return b.payloadOffset
+}
+
+// ExtractDirectory returns the directory that [Bundle.Extract]
+// places store objects in for the given prefix.
+// The prefix is padded with slashes to match the length of the original store directory.
+// ExtractDirectory returns an error if prefix is longer than the original store directory.
+func (b *Bundle) ExtractDirectory(prefix string) (string, error) {
+	if !path.IsAbs(prefix) {
+		return "", fmt.Errorf("bundle prefix %s is not absolute", prefix)
+	}
+	prefix = path.Clean(prefix)
+	n := len(b.Manifest.StoreDirectory)
+	if len(prefix) > n {
+		return "", fmt.Errorf("bundle prefix %s is longer than %s (%d bytes)", prefix, b.Manifest.StoreDirectory, n)
+	}
+	return prefix + strings.Repeat("/", n-len(prefix)), nil
+}
+
+// Extract extracts the bundle's store objects into dir,
+// which should be obtained from [Bundle.ExtractDirectory].
+// Store objects that already exist in dir are left untouched.
+// Extract returns the path of the manifest's program inside dir.
+func (b *Bundle) Extract(dir string) (program string, err error) {
+	oldDir := string(b.Manifest.StoreDirectory)
+	if len(dir) != len(oldDir) {
+		return "", fmt.Errorf("extract bundle: %s is not the same length as %s", dir, oldDir)
+	}
+	program = dir + strings.TrimPrefix(b.Manifest.Program, oldDir)
+
+	missing := make(map[string]bool)
+	for _, obj := range b.Manifest.Objects {
+		if _, err := os.Lstat(filepath.Join(dir, obj.Base())); errors.Is(err, os.ErrNotExist) {
+			missing[obj.Base()] = true
+		} else if err != nil {
+			return "", fmt.Errorf("extract bundle: %v", err)
+		}
+	}
+	if len(missing) == 0 {
+		return program, nil
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("extract bundle: %v", err)
+	}
+	tempDir, err := os.MkdirTemp(dir, ".extract-*")
+	if err != nil {
+		return "", fmt.Errorf("extract bundle: %v", err)
+	}
+	defer func() {
+		if err2 := os.RemoveAll(tempDir); err == nil && err2 != nil {
+			err = fmt.Errorf("extract bundle: %v", err2)
+		}
+	}()
+
+	zr, err := zstd.NewReader(io.NewSectionReader(b.r, b.payloadOffset, b.payloadSize))
+	if err != nil {
+		return "", fmt.Errorf("extract bundle: %v", err)
+	}
+	defer zr.Close()
+	rw := &rewriter{old: []byte(oldDir), new: []byte(dir)}
+	if err := rw.extractTar(tempDir, tar.NewReader(zr), missing); err != nil {
+		return "", fmt.Errorf("extract bundle: %v", err)
+	}
+
+	for base := range missing {
+		dst := filepath.Join(dir, base)
+		if err := os.Rename(filepath.Join(tempDir, base), dst); err != nil {
+			// Another process may have extracted the same object concurrently.
+			if _, statErr := os.Lstat(dst); statErr != nil {
+				return "", fmt.Errorf("extract bundle: %v", err)
+			}
+		}
+	}
+	return program, nil
+}
+
+// rewriter replaces occurrences of old with new in extracted files.
+// old and new must be the same length.
+type rewriter struct {
+	old []byte
+	new []byte
+}
+
+func (rw *rewriter) extractTar(dst string, tr *tar.Reader, include map[string]bool) error {
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := strings.TrimSuffix(hdr.Name, "/")
+		if !fs.ValidPath(name) {
+			return fmt.Errorf("invalid name %q", hdr.Name)
+		}
+		base, _, _ := strings.Cut(name, "/")
+		if !include[base] {
+			continue
+		}
+		fsPath := filepath.Join(dst, filepath.FromSlash(name))
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(fsPath, 0o755); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			target := string(bytes.ReplaceAll([]byte(hdr.Linkname), rw.old, rw.new))
+			if err := os.Symlink(target, fsPath); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			data, err := io.ReadAll(tr)
+			if err != nil {
+				return fmt.Errorf("%s: %v", hdr.Name, err)
+			}
+			data = bytes.ReplaceAll(data, rw.old, rw.new)
+			perm := os.FileMode(0o644)
+			if hdr.Mode&0o111 != 0 {
+				perm = 0o755
+			}
+			if err := os.WriteFile(fsPath, data, perm); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("%s: unsupported file type %q", hdr.Name, hdr.Typeflag)
+		}
+	}
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,122 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package selfextract
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"zb.256lights.llc/pkg/zbstore"
+)
+
+func TestRoundTrip(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Bundles require Unix paths")
+	}
+	storeDir := zbstore.Directory(filepath.Join(t.TempDir(), "store-directory"))
+	libPath, err := storeDir.Object("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa-lib")
+	if err != nil {
+		t.Fatal(err)
+	}
+	appPath, err := storeDir.Object("bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb-app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(appPath.Join("bin"), 0o777); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(string(libPath), []byte("library\n"), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	const scriptPrefix = "#!/bin/sh\nexec cat "
+	if err := os.WriteFile(appPath.Join("bin", "app"), []byte(scriptPrefix+string(libPath)+"\n"), 0o777); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(string(libPath), appPath.Join("lib")); err != nil {
+		t.Fatal(err)
+	}
+
+	const stub = "#!stub\n"
+	buf := new(bytes.Buffer)
+	err = Write(buf, strings.NewReader(stub), &Manifest{
+		StoreDirectory: storeDir,
+		Objects:        []zbstore.Path{libPath, appPath},
+		Program:        appPath.Join("bin", "app"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(buf.Bytes(), []byte(stub)) {
+		t.Error("Bundle does not start with stub")
+	}
+
+	b, err := Open(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := b.StubSize(); got != int64(len(stub)) {
+		t.Errorf("StubSize() = %d; want %d", got, len(stub))
+	}
+	dir, err := b.ExtractDirectory(filepath.Join(t.TempDir(), "x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dir) != len(storeDir) {
+		t.Fatalf("ExtractDirectory(...) = %q; want length %d", dir, len(storeDir))
+	}
+	program, err := b.Extract(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := dir + "/" + appPath.Base() + "/bin/app"; program != want {
+		t.Errorf("Extract(...) = %q; want %q", program, want)
+	}
+	newLibPath := dir + "/" + libPath.Base()
+	got, err := os.ReadFile(program)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := scriptPrefix + newLibPath + "\n"; string(got) != want {
+		t.Errorf("extracted program = %q; want %q", got, want)
+	}
+	if info, err := os.Stat(program); err != nil {
+		t.Error(err)
+	} else if info.Mode()&0o100 == 0 {
+		t.Errorf("extracted program mode = %v; want executable", info.Mode())
+	}
+	if target, err := os.Readlink(filepath.Join(dir, appPath.Base(), "lib")); err != nil {
+		t.Error(err)
+	} else if target != newLibPath {
+		t.Errorf("extracted symlink target = %q; want %q", target, newLibPath)
+	}
+
+	// Extracting again should be a no-op.
+	if _, err := b.Extract(dir); err != nil {
+		t.Error("Second extract:", err)
+	}
+}
+
+func TestOpenNotBundle(t *testing.T) {
+	data := []byte("#!/bin/sh\necho 'Hello, World!'\n")
+	if _, err := Open(bytes.NewReader(data), int64(len(data))); !errors.Is(err, ErrNotBundle) {
+		t.Errorf("Open(...) = _, %v; want %v", err, ErrNotBundle)
+	}
+}
+
+func TestExtractDirectoryTooLong(t *testing.T) {
+	b := &Bundle{Manifest: &Manifest{StoreDirectory: "/zb/store"}}
+	if got, err := b.ExtractDirectory("/home/example/.zb"); err == nil {
+		t.Errorf("ExtractDirectory(...) = %q, <nil>; want error", got)
+	}
+	if got, err := b.ExtractDirectory("/tmp/zb"); err != nil {
+		t.Error(err)
+	} else if want := "/tmp/zb//"; got != want {
+		t.Errorf("ExtractDirectory(...) = %q; want %q", got, want)
+	}
+}
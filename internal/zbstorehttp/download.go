@@ -0,0 +1,325 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package zbstorehttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+	"zb.256lights.llc/pkg/internal/xhttp"
+	"zb.256lights.llc/pkg/internal/xtime"
+	"zombiezen.com/go/log"
+)
+
+// DefaultDownloadRetries is the number of retries used
+// when [Store.DownloadRetries] is zero.
+const DefaultDownloadRetries = 3
+
+// downloadBackoffTable is the sequence of durations
+// to wait between retries of a failed download.
+var downloadBackoffTable = []time.Duration{
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	1 * time.Second,
+	2 * time.Second,
+	4 * time.Second,
+	8 * time.Second,
+}
+
+const downloadBackoffJitter = 0.25
+
+// maxRetryAfter is the longest Retry-After delay that will be honored.
+const maxRetryAfter = 1 * time.Minute
+
+// downloadLimits is the shared state of a [Store]'s download limits.
+type downloadLimits struct {
+	// slots is a semaphore of download slots.
+	// If slots is nil, then downloads are not limited.
+	slots chan struct{}
+	// requestsPerSecond is the maximum rate of requests to a single host.
+	// If non-positive, then requests are not rate-limited.
+	requestsPerSecond float64
+	retries           int
+
+	mu    sync.Mutex
+	hosts map[string]*rate.Limiter
+}
+
+func (s *Store) downloadLimits() *downloadLimits {
+	s.limitsInit.Do(func() {
+		s.limits = &downloadLimits{
+			requestsPerSecond: s.MaxRequestsPerSecond,
+			retries:           s.DownloadRetries,
+		}
+		if s.limits.retries == 0 {
+			s.limits.retries = DefaultDownloadRetries
+		}
+		if s.MaxConcurrentDownloads > 0 {
+			s.limits.slots = make(chan struct{}, s.MaxConcurrentDownloads)
+		}
+	})
+	return s.limits
+}
+
+// acquire waits until a request to host is permitted by the limits.
+// The caller must call release when the response body is closed.
+func (dl *downloadLimits) acquire(ctx context.Context, host string) (release func(), err error) {
+	if dl.requestsPerSecond > 0 {
+		dl.mu.Lock()
+		limiter := dl.hosts[host]
+		if limiter == nil {
+			if dl.hosts == nil {
+				dl.hosts = make(map[string]*rate.Limiter)
+			}
+			limiter = rate.NewLimiter(rate.Limit(dl.requestsPerSecond), max(1, int(dl.requestsPerSecond)))
+			dl.hosts[host] = limiter
+		}
+		dl.mu.Unlock()
+		if err := limiter.Wait(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if dl.slots == nil {
+		return func() {}, nil
+	}
+	select {
+	case dl.slots <- struct{}{}:
+		return sync.OnceFunc(func() { <-dl.slots }), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// limitedClient is a [Client] that applies a [Store]'s download limits
+// to GET requests and retries GET requests that fail with transient errors.
+// Other requests are passed through unchanged.
+type limitedClient struct {
+	client Client
+	limits *downloadLimits
+}
+
+func (c *limitedClient) Do(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet && req.Method != "" || req.Body != nil && req.Body != http.NoBody {
+		return c.client.Do(req)
+	}
+	ctx := req.Context()
+	backoff := xtime.NewBackoffTimer(downloadBackoffTable, downloadBackoffJitter)
+	for attempt := 0; ; attempt++ {
+		resp, err := c.do(req)
+		if attempt >= c.limits.retries || !isTransientResponse(ctx, resp, err) {
+			return resp, err
+		}
+
+		var retryAfter time.Duration
+		if err != nil {
+			log.Debugf(ctx, "Retrying GET %s: %v", req.URL.Redacted(), err)
+		} else {
+			log.Debugf(ctx, "Retrying GET %s: http %s", req.URL.Redacted(), resp.Status)
+			retryAfter = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+			resp.Body.Close()
+		}
+		if retryAfter > 0 {
+			err = xtime.Sleep(ctx, min(retryAfter, maxRetryAfter))
+		} else {
+			err = backoff.Sleep(ctx)
+		}
+		if err != nil {
+			return nil, &url.Error{Op: "Get", URL: req.URL.Redacted(), Err: err}
+		}
+	}
+}
+
+// do sends a single request within the limits.
+func (c *limitedClient) do(req *http.Request) (*http.Response, error) {
+	release, err := c.limits.acquire(req.Context(), req.URL.Host)
+	if err != nil {
+		return nil, &url.Error{Op: "Get", URL: req.URL.Redacted(), Err: err}
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	resp.Body = &releaseOnClose{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+// isTransientResponse reports whether a response or error from a GET request
+// indicates a failure that may succeed if retried.
+func isTransientResponse(ctx context.Context, resp *http.Response, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if err != nil {
+		return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// parseRetryAfter parses the value of a [Retry-After header field].
+// It returns zero if the value is invalid or in the past.
+//
+// [Retry-After header field]: https://datatracker.ietf.org/doc/html/rfc9110#section-10.2.3
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.ParseUint(value, 10, 32); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	t, err := http.ParseTime(value)
+	if err != nil {
+		return 0
+	}
+	return max(t.Sub(now), 0)
+}
+
+type releaseOnClose struct {
+	io.ReadCloser
+	release func()
+}
+
+func (r *releaseOnClose) Close() error {
+	err := r.ReadCloser.Close()
+	r.release()
+	return err
+}
+
+// resumingBody is the body of a GET response
+// that continues from where it left off with a range request
+// if reading fails partway through.
+type resumingBody struct {
+	ctx    context.Context
+	client Client
+	req    *http.Request
+	// validator is the If-Range field value
+	// that ensures the resumed response is the same representation.
+	validator string
+	// contentEncoding is the Content-Encoding of the original response.
+	// Ranges are over the encoded content.
+	contentEncoding string
+	resumesLeft     int
+
+	body   io.ReadCloser
+	offset int64
+}
+
+// newResumingBody returns a body that reads from resp
+// and resumes up to maxResumes times.
+// If the server does not advertise support for range requests
+// or resp does not have a validator,
+// then the body is not resumed.
+func newResumingBody(ctx context.Context, client Client, req *http.Request, resp *http.Response, maxResumes int) *resumingBody {
+	rb := &resumingBody{
+		ctx:             ctx,
+		client:          client,
+		req:             req,
+		contentEncoding: resp.Header.Get("Content-Encoding"),
+		body:            resp.Body,
+	}
+	if !strings.EqualFold(strings.TrimSpace(resp.Header.Get("Accept-Ranges")), "bytes") {
+		return rb
+	}
+	validators := xhttp.ExtractValidatorFields(resp.Header)
+	switch {
+	case validators.ETag != "" && !strings.HasPrefix(string(validators.ETag), "W/"):
+		// If-Range requires a strong validator.
+		rb.validator = string(validators.ETag)
+	case !validators.LastModified.IsZero():
+		rb.validator = validators.LastModified.UTC().Format(http.TimeFormat)
+	default:
+		return rb
+	}
+	rb.resumesLeft = max(maxResumes, 0)
+	return rb
+}
+
+func (rb *resumingBody) Read(p []byte) (int, error) {
+	for {
+		n, err := rb.body.Read(p)
+		rb.offset += int64(n)
+		if err == nil || err == io.EOF || rb.resumesLeft <= 0 || rb.ctx.Err() != nil {
+			return n, err
+		}
+		if resumeErr := rb.resume(); resumeErr != nil {
+			log.Debugf(rb.ctx, "Resuming GET %s: %v", rb.req.URL.Redacted(), resumeErr)
+			return n, err
+		}
+		log.Debugf(rb.ctx, "Resumed GET %s at byte %d after: %v", rb.req.URL.Redacted(), rb.offset, err)
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// resume replaces rb.body with a response for the rest of the content.
+func (rb *resumingBody) resume() error {
+	rb.resumesLeft--
+	rb.body.Close()
+	rb.body = http.NoBody
+
+	req := rb.req.Clone(rb.ctx)
+	req.Header = maps.Clone(rb.req.Header)
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", rb.offset))
+	req.Header.Set("If-Range", rb.validator)
+	resp, err := rb.client.Do(req)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return fmt.Errorf("server responded with http %s instead of partial content", resp.Status)
+	}
+	if got := resp.Header.Get("Content-Encoding"); got != rb.contentEncoding {
+		resp.Body.Close()
+		return fmt.Errorf("content encoding changed from %q to %q", rb.contentEncoding, got)
+	}
+	if start, ok := parseContentRangeStart(resp.Header.Get("Content-Range")); !ok || start != rb.offset {
+		resp.Body.Close()
+		return fmt.Errorf("server responded with unexpected range %q", resp.Header.Get("Content-Range"))
+	}
+	rb.body = resp.Body
+	return nil
+}
+
+func (rb *resumingBody) Close() error {
+	return rb.body.Close()
+}
+
+// parseContentRangeStart returns the first byte position
+// of a Content-Range header field value.
+func parseContentRangeStart(value string) (int64, bool) {
+	rest, ok := strings.CutPrefix(value, "bytes ")
+	if !ok {
+		return 0, false
+	}
+	start, _, ok := strings.Cut(rest, "-")
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(start, 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return n, true
+}
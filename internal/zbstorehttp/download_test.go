@@ -0,0 +1,223 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package zbstorehttp
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"zb.256lights.llc/pkg/internal/testcontext"
+)
+
+func TestLimitedClientRetry(t *testing.T) {
+	setFastBackoff(t)
+
+	tests := []struct {
+		name         string
+		failures     int
+		failStatus   int
+		retries      int
+		wantStatus   int
+		wantRequests int32
+	}{
+		{
+			name:         "Success",
+			failures:     2,
+			failStatus:   http.StatusServiceUnavailable,
+			retries:      3,
+			wantStatus:   http.StatusOK,
+			wantRequests: 3,
+		},
+		{
+			name:         "TooManyRequests",
+			failures:     1,
+			failStatus:   http.StatusTooManyRequests,
+			retries:      3,
+			wantStatus:   http.StatusOK,
+			wantRequests: 2,
+		},
+		{
+			name:         "Exhausted",
+			failures:     10,
+			failStatus:   http.StatusBadGateway,
+			retries:      2,
+			wantStatus:   http.StatusBadGateway,
+			wantRequests: 3,
+		},
+		{
+			name:         "NotTransient",
+			failures:     10,
+			failStatus:   http.StatusNotFound,
+			retries:      3,
+			wantStatus:   http.StatusNotFound,
+			wantRequests: 1,
+		},
+		{
+			name:         "Disabled",
+			failures:     1,
+			failStatus:   http.StatusServiceUnavailable,
+			retries:      -1,
+			wantStatus:   http.StatusServiceUnavailable,
+			wantRequests: 1,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := testcontext.New(t)
+			var requests atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if int(requests.Add(1)) <= test.failures {
+					http.Error(w, "try again", test.failStatus)
+					return
+				}
+				io.WriteString(w, "Hello, World!\n")
+			}))
+			defer srv.Close()
+
+			store := &Store{
+				HTTPClient:      srv.Client(),
+				DownloadRetries: test.retries,
+			}
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := store.client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != test.wantStatus {
+				t.Errorf("status = %d; want %d", resp.StatusCode, test.wantStatus)
+			}
+			if got := requests.Load(); got != test.wantRequests {
+				t.Errorf("server received %d requests; want %d", got, test.wantRequests)
+			}
+		})
+	}
+}
+
+func TestLimitedClientMaxConcurrentDownloads(t *testing.T) {
+	ctx := testcontext.New(t)
+
+	const maxDownloads = 2
+	var active, maxActive atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := active.Add(1)
+		defer active.Add(-1)
+		for {
+			m := maxActive.Load()
+			if n <= m || maxActive.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		io.WriteString(w, "Hello, World!\n")
+	}))
+	defer srv.Close()
+
+	store := &Store{
+		HTTPClient:             srv.Client(),
+		MaxConcurrentDownloads: maxDownloads,
+	}
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Go(func() {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp, err := store.client().Do(req)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		})
+	}
+	wg.Wait()
+
+	if got := maxActive.Load(); got > maxDownloads {
+		t.Errorf("server handled %d concurrent requests; want <=%d", got, maxDownloads)
+	}
+}
+
+func TestResumingBody(t *testing.T) {
+	ctx := testcontext.New(t)
+
+	content := bytes.Repeat([]byte("Hello, World!\n"), 10000)
+	var rangeRequests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"abc"`)
+		if r.Header.Get("Range") == "" {
+			// Send half of the content, then drop the connection.
+			w.Header().Set("Accept-Ranges", "bytes")
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			w.Write(content[:len(content)/2])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		rangeRequests.Add(1)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	store := &Store{HTTPClient: srv.Client()}
+	client := store.client()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := newResumingBody(ctx, client, req, resp, DefaultDownloadRetries)
+	got, err := io.ReadAll(body)
+	body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Errorf("read %d bytes; want %d bytes of content", len(got), len(content))
+	}
+	if got := rangeRequests.Load(); got != 1 {
+		t.Errorf("server received %d range requests; want 1", got)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2026, time.January, 2, 15, 4, 5, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"120", 2 * time.Minute},
+		{"Fri, 02 Jan 2026 15:04:35 GMT", 30 * time.Second},
+		{"Fri, 02 Jan 2026 15:00:00 GMT", 0},
+		{"soon", 0},
+	}
+	for _, test := range tests {
+		if got := parseRetryAfter(test.value, now); got != test.want {
+			t.Errorf("parseRetryAfter(%q, now) = %v; want %v", test.value, got, test.want)
+		}
+	}
+}
+
+// setFastBackoff shortens the waits between retries for the duration of the test.
+func setFastBackoff(tb testing.TB) {
+	old := downloadBackoffTable
+	downloadBackoffTable = []time.Duration{time.Millisecond}
+	tb.Cleanup(func() { downloadBackoffTable = old })
+}
@@ -15,6 +15,7 @@ import (
 	"iter"
 	"net/http"
 	"net/url"
+	"sync"
 	"time"
 
 	jsonv2 "github.com/go-json-experiment/json"
@@ -60,13 +61,37 @@ type Store struct {
 	// RealizationsCacheControl is the Cache-Control header value to use
 	// when uploading a realizations document.
 	RealizationsCacheControl string
+
+	// MaxConcurrentDownloads is the maximum number of GET requests
+	// (including NAR downloads) that the store sends at once.
+	// If MaxConcurrentDownloads is non-positive, then the number of requests is not limited.
+	MaxConcurrentDownloads int
+	// MaxRequestsPerSecond is the maximum rate of GET requests
+	// that the store sends to a single host.
+	// If MaxRequestsPerSecond is non-positive, then the rate is not limited.
+	MaxRequestsPerSecond float64
+	// DownloadRetries is the number of times that a GET request is retried
+	// after a network error or a transient HTTP status (429 or 5xx),
+	// waiting with exponential backoff between attempts.
+	// It is also the number of times that an interrupted NAR download is resumed
+	// with a range request.
+	// If DownloadRetries is zero, then [DefaultDownloadRetries] is used.
+	// If DownloadRetries is negative, then requests are not retried.
+	DownloadRetries int
+
+	limitsInit sync.Once
+	limits     *downloadLimits
 }
 
 func (s *Store) client() Client {
-	if s.HTTPClient == nil {
-		return http.DefaultClient
+	var c Client = http.DefaultClient
+	if s.HTTPClient != nil {
+		c = s.HTTPClient
+	}
+	return &limitedClient{
+		client: c,
+		limits: s.downloadLimits(),
 	}
-	return s.HTTPClient
 }
 
 func (s *Store) discover(ctx context.Context) (*hal.Resource, error) {
@@ -105,9 +130,10 @@ func (s *Store) Object(ctx context.Context, path zbstore.Path) (zbstore.Object,
 		info, _, err := s.fetchNARInfo(ctx, u)
 		if err == nil {
 			return &httpObject{
-				base:   u,
-				client: s.client(),
-				info:   info,
+				base:    u,
+				client:  s.client(),
+				info:    info,
+				resumes: s.downloadLimits().retries,
 			}, nil
 		}
 		if isNotFound(err) {
@@ -384,6 +410,9 @@ type httpObject struct {
 	client Client
 	base   *url.URL
 	info   *NARInfo
+	// resumes is the maximum number of times
+	// to resume an interrupted NAR download.
+	resumes int
 }
 
 func (obj *httpObject) Trailer() *zbstore.ExportTrailer {
@@ -405,24 +434,26 @@ func (obj *httpObject) WriteNAR(ctx context.Context, dst io.Writer) error {
 		return fmt.Errorf("download %s: %v", obj.info.StorePath, err)
 	}
 
-	req := &http.Request{
+	req := (&http.Request{
 		Method: http.MethodGet,
 		URL:    narFileURL,
 		Header: http.Header{
 			"Accept":          {"*/*"},
 			"Accept-Encoding": {httpencoding.Accept},
 		},
-	}
+	}).WithContext(ctx)
 	resp, err := obj.client.Do(req)
 	if err != nil {
 		return fmt.Errorf("download %s: get %s: %v", obj.info.StorePath, narFileURL.Redacted(), err)
 	}
-	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
 		err := httpErrorFromResponse(resp)
 		return fmt.Errorf("download %s: get %s: %v", obj.info.StorePath, narFileURL.Redacted(), err)
 	}
-	decodedBody, err := httpencoding.Decode(resp.Body, resp.Header.Get("Content-Encoding"))
+	body := newResumingBody(ctx, obj.client, req, resp, obj.resumes)
+	defer body.Close()
+	decodedBody, err := httpencoding.Decode(body, resp.Header.Get("Content-Encoding"))
 	if err != nil {
 		return fmt.Errorf("download %s: get %s: %v", obj.info.StorePath, narFileURL.Redacted(), err)
 	}
@@ -11,6 +11,7 @@ import (
 	"maps"
 	"strconv"
 	"sync"
+	"sync/atomic"
 
 	"github.com/go-json-experiment/json/jsontext"
 	"zb.256lights.llc/pkg/internal/jsonrpc"
@@ -32,8 +33,13 @@ const maxAPIMessageSize = 1 << 20 // 1 MiB
 // using the Language Server Protocol "base protocol" for framing.
 // A Codec must only be used as a ServerCodec or as a ClientCodec, not both.
 type Codec struct {
-	w *jsonrpc.Writer
-	c io.Closer
+	writeMu sync.Mutex
+	w       *jsonrpc.Writer
+	c       io.Closer
+
+	offerMsgpack bool
+	// sendMsgpack is set once the remote has indicated that it understands MessagePack.
+	sendMsgpack atomic.Bool
 
 	messages  <-chan jsontext.Value
 	readError error // can only be read after messages is closed
@@ -45,6 +51,12 @@ type CodecOptions struct {
 	// If Importer is non-nil, then it is used to handle application/zb-store-export messages.
 	// If Importer is nil, such messages are discarded.
 	Importer Importer
+	// If Msgpack is true, then the codec advertises support for
+	// application/zb-store-rpc+msgpack messages
+	// and switches to sending them
+	// once the remote indicates that it supports them too.
+	// Regardless of Msgpack, a [Codec] accepts MessagePack messages it receives.
+	Msgpack bool
 }
 
 // Importer is the interface used by [Codec] to handle application/zb-store-export messages.
@@ -80,17 +92,18 @@ func NewCodec(rwc io.ReadWriteCloser, opts *CodecOptions) *Codec {
 	messages := make(chan jsontext.Value)
 	readDone := make(chan struct{})
 	*c = Codec{
-		w:        jsonrpc.NewWriter(rwc),
-		c:        rwc,
-		messages: messages,
-		readDone: readDone,
+		w:            jsonrpc.NewWriter(rwc),
+		c:            rwc,
+		offerMsgpack: opts != nil && opts.Msgpack,
+		messages:     messages,
+		readDone:     readDone,
 	}
 	go func() {
 		defer func() {
 			close(messages)
 			close(readDone)
 		}()
-		c.readError = readLoop(messages, importer, jsonrpc.NewReader(rwc))
+		c.readError = c.readLoop(messages, importer, jsonrpc.NewReader(rwc))
 	}()
 	return c
 }
@@ -109,14 +122,14 @@ func (c *Codec) ReadResponse() (jsontext.Value, error) {
 	return msg, nil
 }
 
-func readLoop(messages chan<- jsontext.Value, importer Importer, r *jsonrpc.Reader) error {
+func (c *Codec) readLoop(messages chan<- jsontext.Value, importer Importer, r *jsonrpc.Reader) error {
 	for {
 		header, bodySize, err := r.NextMessage()
 		if err != nil {
 			return err
 		}
 		switch ct := header.Get("Content-Type"); ct {
-		case rpcContentType:
+		case rpcContentType, msgpackContentType:
 			if bodySize < 0 {
 				return fmt.Errorf("remote sent api message without valid Content-Length")
 			}
@@ -127,6 +140,17 @@ func readLoop(messages chan<- jsontext.Value, importer Importer, r *jsonrpc.Read
 			if err != nil {
 				return err
 			}
+			if ct == msgpackContentType {
+				body, err = msgpackToJSON(body)
+				if err != nil {
+					return fmt.Errorf("remote sent invalid api message: %v", err)
+				}
+			}
+			// Switch encodings before handing off the message
+			// so that a response to this message uses the new encoding.
+			if c.offerMsgpack && (ct == msgpackContentType || acceptsMsgpack(header.Get("Accept"))) {
+				c.sendMsgpack.Store(true)
+			}
 			messages <- body
 		case exportContentType:
 			if err := importer.Import(header, r); err != nil {
@@ -146,11 +170,24 @@ func readLoop(messages chan<- jsontext.Value, importer Importer, r *jsonrpc.Read
 
 // WriteRequest implements [jsonrpc.ClientCodec].
 func (c *Codec) WriteRequest(request jsontext.Value) error {
+	body := []byte(request)
 	hdr := jsonrpc.Header{
-		"Content-Length": {strconv.Itoa(len(request))},
-		"Content-Type":   {rpcContentType},
+		"Content-Type": {rpcContentType},
 	}
-	return c.w.WriteMessage(hdr, bytes.NewReader(request))
+	if c.sendMsgpack.Load() {
+		var err error
+		body, err = jsonToMsgpack(request)
+		if err != nil {
+			return err
+		}
+		hdr.Set("Content-Type", msgpackContentType)
+	} else if c.offerMsgpack {
+		hdr.Set("Accept", msgpackContentType+", "+rpcContentType)
+	}
+	hdr.Set("Content-Length", strconv.Itoa(len(body)))
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.w.WriteMessage(hdr, bytes.NewReader(body))
 }
 
 // WriteResponse implements [jsonrpc.ServerCodec].
@@ -160,10 +197,18 @@ func (c *Codec) WriteResponse(response jsontext.Value) error {
 
 // Export sends a `nix-store --export` dump.
 // The Content-Type header is always sent as "application/zb-store-export".
+//
+// Export streams r to the connection as it is read,
+// so the producer of r is only asked for more data
+// as fast as the remote end consumes it.
+// Messages written concurrently with Export
+// are sent after the export finishes.
 func (c *Codec) Export(header jsonrpc.Header, r io.Reader) error {
 	fullHeader := make(jsonrpc.Header, len(header)+1)
 	maps.Copy(fullHeader, header)
 	fullHeader.Set("Content-Type", exportContentType)
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
 	return c.w.WriteMessage(fullHeader, r)
 }
 
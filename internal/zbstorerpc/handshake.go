@@ -0,0 +1,181 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package zbstorerpc
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"zb.256lights.llc/pkg/internal/jsonrpc"
+)
+
+// ProtocolVersion is the version of the store RPC protocol
+// implemented by this package.
+// Stores that do not implement [HandshakeMethod]
+// are assumed to implement version 0.
+const ProtocolVersion = 1
+
+// HandshakeMethod is the name of the method
+// that exchanges protocol versions and capabilities between a client and a store.
+// [HandshakeRequest] is used for the request
+// and [HandshakeResponse] is used for the response.
+// Clients should use [Handshake] instead of calling the method directly.
+const HandshakeMethod = "zb.handshake"
+
+// HandshakeRequest is the set of parameters for [HandshakeMethod].
+type HandshakeRequest struct {
+	// ProtocolVersion is the client's protocol version.
+	ProtocolVersion int `json:"protocolVersion"`
+	// Capabilities is the set of optional features that the client understands.
+	Capabilities []Capability `json:"capabilities"`
+}
+
+// HandshakeResponse is the result for [HandshakeMethod].
+type HandshakeResponse struct {
+	// ProtocolVersion is the store's protocol version.
+	ProtocolVersion int `json:"protocolVersion"`
+	// Capabilities is the set of optional features that the store supports.
+	// Stores may list capabilities that the client did not send,
+	// but clients must ignore capabilities they do not understand.
+	Capabilities []Capability `json:"capabilities"`
+	// SystemFeatures is the sorted list of optional system features
+	// (e.g. "kvm" or "big-parallel") that the store's builders support.
+	// Derivations that list other features
+	// in their __requiredSystemFeatures environment variable
+	// will fail to build on the store.
+	// Only stores with [CapabilitySystemFeatures] fill in this field.
+	SystemFeatures []string `json:"systemFeatures,omitempty"`
+}
+
+// Has reports whether resp lists the given capability.
+// A nil response has no capabilities.
+func (resp *HandshakeResponse) Has(c Capability) bool {
+	return resp != nil && slices.Contains(resp.Capabilities, c)
+}
+
+// Require returns an error if resp does not list the given capability.
+// The feature string describes what the client is trying to do
+// (e.g. "zb build --check")
+// and is used in the error message.
+func (resp *HandshakeResponse) Require(c Capability, feature string) error {
+	if resp.Has(c) {
+		return nil
+	}
+	version := 0
+	if resp != nil {
+		version = resp.ProtocolVersion
+	}
+	return fmt.Errorf("store does not support %s (protocol version %d, missing %q capability); upgrade the store to a newer version of zb", feature, version, c)
+}
+
+// Capability is the name of an optional feature of the store RPC protocol.
+type Capability string
+
+// Defined capabilities.
+const (
+	// CapabilityProvenance indicates that the store fills in [RealizeOutput.Provenance].
+	CapabilityProvenance Capability = "provenance"
+	// CapabilityCheck indicates that the store honors [RealizeRequest.Check].
+	// Stores without this capability ignore the field.
+	CapabilityCheck Capability = "check"
+	// CapabilityAttestations indicates that the store implements [AttestationsMethod].
+	CapabilityAttestations Capability = "attestations"
+	// CapabilityAddRoot indicates that the store implements [AddRootMethod].
+	CapabilityAddRoot Capability = "addRoot"
+	// CapabilityRealizations indicates that the store implements
+	// [RealizationsMethod] and [RealizationsByPathMethod].
+	CapabilityRealizations Capability = "realizations"
+	// CapabilityAddSignatures indicates that the store implements [AddSignaturesMethod].
+	CapabilityAddSignatures Capability = "addSignatures"
+	// CapabilityLogTimestamps indicates that the store prefixes each line
+	// of a builder log with the time elapsed since the builder started
+	// when [ReadLogRequest.Prefixed] is set
+	// (see [CutLogPrefix])
+	// and fills in [BuildResult.Phases].
+	CapabilityLogTimestamps Capability = "logTimestamps"
+	// CapabilityLogStreams indicates that the store records
+	// whether each line of a builder log was written to standard output or standard error
+	// in the line's [LogPrefix].
+	CapabilityLogStreams Capability = "logStreams"
+	// CapabilitySubstituteOnly indicates that the store honors [RealizeRequest.SubstituteOnly].
+	// Stores without this capability ignore the field.
+	CapabilitySubstituteOnly Capability = "substituteOnly"
+	// CapabilityOffline indicates that the store honors [RealizeRequest.Offline].
+	// Stores without this capability ignore the field.
+	CapabilityOffline Capability = "offline"
+	// CapabilityPruneRealizations indicates that the store implements [PruneRealizationsMethod].
+	CapabilityPruneRealizations Capability = "pruneRealizations"
+	// CapabilitySystemFeatures indicates that the store fills in [HandshakeResponse.SystemFeatures]
+	// and refuses to build derivations whose __requiredSystemFeatures it does not support.
+	CapabilitySystemFeatures Capability = "systemFeatures"
+	// CapabilityPrefetch indicates that the store implements [PrefetchMethod].
+	CapabilityPrefetch Capability = "prefetch"
+	// CapabilityClearSubstituterMisses indicates that the store implements [ClearSubstituterMissesMethod].
+	CapabilityClearSubstituterMisses Capability = "clearSubstituterMisses"
+	// CapabilityPathInfo indicates that the store implements [PathInfoMethod].
+	CapabilityPathInfo Capability = "pathInfo"
+	// CapabilityReferrers indicates that the store implements [ReferrersMethod].
+	CapabilityReferrers Capability = "referrers"
+	// CapabilityReplaySchedule indicates that the store honors [RealizeRequest.ReplaySchedule]
+	// and fills in [BuildResult.Schedule].
+	// Stores without this capability ignore the field.
+	CapabilityReplaySchedule Capability = "replaySchedule"
+	// CapabilityExecTrace indicates that the store honors [RealizeRequest.TraceExec]
+	// and [ReadLogRequest.Trace].
+	// A store only advertises this capability if it can trace builders on its platform.
+	CapabilityExecTrace Capability = "execTrace"
+	// CapabilityInputUsage indicates that the store honors [RealizeRequest.TraceInputs]
+	// and fills in [BuildResult.InputUsage].
+	// A store only advertises this capability if it can trace builders on its platform.
+	CapabilityInputUsage Capability = "inputUsage"
+	// CapabilityBatch indicates that the store accepts JSON-RPC batch requests.
+	// Clients must send requests individually to stores without this capability.
+	CapabilityBatch Capability = "batch"
+)
+
+// Capabilities returns the capabilities understood by this package.
+func Capabilities() []Capability {
+	return []Capability{
+		CapabilityProvenance,
+		CapabilityCheck,
+		CapabilityAttestations,
+		CapabilityAddRoot,
+		CapabilityRealizations,
+		CapabilityAddSignatures,
+		CapabilityLogTimestamps,
+		CapabilityLogStreams,
+		CapabilitySubstituteOnly,
+		CapabilityOffline,
+		CapabilityPruneRealizations,
+		CapabilitySystemFeatures,
+		CapabilityPrefetch,
+		CapabilityClearSubstituterMisses,
+		CapabilityPathInfo,
+		CapabilityReferrers,
+		CapabilityReplaySchedule,
+		CapabilityExecTrace,
+		CapabilityInputUsage,
+		CapabilityBatch,
+	}
+}
+
+// Handshake calls [HandshakeMethod] on the given handler
+// with this package's protocol version and capabilities.
+// If the store does not implement [HandshakeMethod],
+// then Handshake returns a response with protocol version 0 and no capabilities.
+func Handshake(ctx context.Context, h jsonrpc.Handler) (*HandshakeResponse, error) {
+	resp := new(HandshakeResponse)
+	err := jsonrpc.Do(ctx, h, HandshakeMethod, resp, &HandshakeRequest{
+		ProtocolVersion: ProtocolVersion,
+		Capabilities:    Capabilities(),
+	})
+	if code, _ := jsonrpc.CodeFromError(err); code == jsonrpc.MethodNotFound {
+		return &HandshakeResponse{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("handshake: %w", err)
+	}
+	return resp, nil
+}
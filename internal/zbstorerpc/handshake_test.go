@@ -0,0 +1,26 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package zbstorerpc
+
+import (
+	"context"
+	"testing"
+
+	"zb.256lights.llc/pkg/internal/jsonrpc"
+)
+
+func TestHandshakeMethodNotFound(t *testing.T) {
+	resp, err := Handshake(context.Background(), jsonrpc.MethodNotFoundHandler{})
+	if err != nil {
+		t.Fatal("Handshake:", err)
+	}
+	if resp.ProtocolVersion != 0 || len(resp.Capabilities) > 0 {
+		t.Errorf("Handshake(...) = %+v; want protocol version 0 with no capabilities", resp)
+	}
+	if err := resp.Require(CapabilityCheck, "zb build --check"); err == nil {
+		t.Error("resp.Require(CapabilityCheck, ...) = <nil>; want error")
+	} else {
+		t.Log(err)
+	}
+}
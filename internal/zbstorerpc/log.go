@@ -0,0 +1,143 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package zbstorerpc
+
+import (
+	"bytes"
+	"strconv"
+	"time"
+)
+
+// PhaseMarkerPrefix is the prefix of a builder log line
+// that starts a new phase of the build.
+// The rest of the line (with surrounding whitespace trimmed) is the name of the phase.
+// See [BuildResult.Phases].
+const PhaseMarkerPrefix = "@zb-phase "
+
+// maxPhaseNameLength is the maximum number of bytes in a phase name.
+const maxPhaseNameLength = 128
+
+// ParsePhaseMarker reports whether the given builder log line
+// (without a timestamp) is a phase marker,
+// returning the name of the phase if so.
+func ParsePhaseMarker(line []byte) (name string, ok bool) {
+	rest, ok := bytes.CutPrefix(line, []byte(PhaseMarkerPrefix))
+	if !ok {
+		return "", false
+	}
+	rest = bytes.TrimSpace(rest)
+	if len(rest) == 0 || len(rest) > maxPhaseNameLength {
+		return "", false
+	}
+	return string(rest), true
+}
+
+// LogStream identifies the output stream that a builder log line was written to.
+type LogStream string
+
+// Log streams.
+const (
+	// LogStreamUnspecified is used for lines that the store wrote to the log itself
+	// (like hook output and error messages)
+	// and for lines in logs from stores that do not have [CapabilityLogStreams].
+	LogStreamUnspecified LogStream = ""
+	// LogStreamStdout is used for lines that the builder wrote to its standard output.
+	LogStreamStdout LogStream = "out"
+	// LogStreamStderr is used for lines that the builder wrote to its standard error.
+	LogStreamStderr LogStream = "err"
+)
+
+// LogPrefix is the information at the start of each builder log line
+// written by a store with [CapabilityLogTimestamps].
+type LogPrefix struct {
+	// Elapsed is the time between the start of the builder
+	// and the start of the line.
+	Elapsed time.Duration
+	// Stream is the stream that the line was written to.
+	Stream LogStream
+}
+
+// AppendLogPrefix appends the formatted prefix to dst
+// and returns the resulting slice.
+// The prefix is the number of seconds with millisecond precision
+// followed by the stream (if specified), all in brackets,
+// followed by a space.
+// For example, a line that a builder wrote to standard error
+// one and a half seconds into the build
+// has the prefix "[1.500 err] ".
+func AppendLogPrefix(dst []byte, prefix LogPrefix) []byte {
+	ms := max(prefix.Elapsed.Milliseconds(), 0)
+	dst = append(dst, '[')
+	dst = strconv.AppendInt(dst, ms/1000, 10)
+	dst = append(dst, '.')
+	frac := ms % 1000
+	if frac < 100 {
+		dst = append(dst, '0')
+	}
+	if frac < 10 {
+		dst = append(dst, '0')
+	}
+	dst = strconv.AppendInt(dst, frac, 10)
+	if prefix.Stream != LogStreamUnspecified {
+		dst = append(dst, ' ')
+		dst = append(dst, prefix.Stream...)
+	}
+	dst = append(dst, "] "...)
+	return dst
+}
+
+// CutLogPrefix parses the prefix written by [AppendLogPrefix]
+// from the start of a builder log line.
+// If the line does not start with a prefix,
+// then CutLogPrefix returns the line unchanged and ok is false.
+func CutLogPrefix(line []byte) (prefix LogPrefix, rest []byte, ok bool) {
+	if len(line) == 0 || line[0] != '[' {
+		return LogPrefix{}, line, false
+	}
+	end := bytes.Index(line, []byte("] "))
+	if end < 0 {
+		return LogPrefix{}, line, false
+	}
+	timestamp, stream, hasStream := bytes.Cut(line[1:end], []byte(" "))
+	if hasStream {
+		switch prefix.Stream = LogStream(stream); prefix.Stream {
+		case LogStreamStdout, LogStreamStderr:
+		default:
+			return LogPrefix{}, line, false
+		}
+	}
+	secs, frac, ok := bytes.Cut(timestamp, []byte("."))
+	if !ok || len(secs) == 0 || len(frac) != 3 || !isDigits(secs) || !isDigits(frac) {
+		return LogPrefix{}, line, false
+	}
+	s, err := strconv.ParseInt(string(secs), 10, 64)
+	if err != nil {
+		return LogPrefix{}, line, false
+	}
+	ms, _ := strconv.ParseInt(string(frac), 10, 64)
+	prefix.Elapsed = time.Duration(s)*time.Second + time.Duration(ms)*time.Millisecond
+	return prefix, line[end+len("] "):], true
+}
+
+// StripLogPrefixes appends the content of src to dst
+// with the prefixes removed from the start of each line
+// and returns the resulting slice.
+// src should start at the beginning of a line.
+// Lines that do not have a prefix are appended unchanged.
+func StripLogPrefixes(dst, src []byte) []byte {
+	for line := range bytes.Lines(src) {
+		_, line, _ = CutLogPrefix(line)
+		dst = append(dst, line...)
+	}
+	return dst
+}
+
+func isDigits(b []byte) bool {
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
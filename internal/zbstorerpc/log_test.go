@@ -0,0 +1,83 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package zbstorerpc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLogPrefix(t *testing.T) {
+	tests := []struct {
+		prefix LogPrefix
+		want   string
+	}{
+		{LogPrefix{}, "[0.000] "},
+		{LogPrefix{Elapsed: 7 * time.Millisecond}, "[0.007] "},
+		{LogPrefix{Elapsed: 1500 * time.Millisecond, Stream: LogStreamStdout}, "[1.500 out] "},
+		{LogPrefix{Elapsed: 62*time.Second + 45*time.Millisecond + 999*time.Microsecond, Stream: LogStreamStderr}, "[62.045 err] "},
+	}
+	for _, test := range tests {
+		got := string(AppendLogPrefix(nil, test.prefix))
+		if got != test.want {
+			t.Errorf("AppendLogPrefix(nil, %+v) = %q; want %q", test.prefix, got, test.want)
+		}
+		prefix, rest, ok := CutLogPrefix([]byte(got + "hello\n"))
+		want := test.prefix
+		want.Elapsed = want.Elapsed.Truncate(time.Millisecond)
+		if prefix != want || string(rest) != "hello\n" || !ok {
+			t.Errorf("CutLogPrefix(%q) = %+v, %q, %t; want %+v, %q, true",
+				got+"hello\n", prefix, rest, ok, want, "hello\n")
+		}
+	}
+}
+
+func TestCutLogPrefixInvalid(t *testing.T) {
+	for _, line := range []string{
+		"",
+		"hello\n",
+		"[1.5] hello\n",
+		"[.500] hello\n",
+		"[1.500]hello\n",
+		"[-1.500] hello\n",
+		"[1.5x0] hello\n",
+		"[1.500 foo] hello\n",
+		"[1.500 ] hello\n",
+		"[1.500 out err] hello\n",
+	} {
+		prefix, rest, ok := CutLogPrefix([]byte(line))
+		if prefix != (LogPrefix{}) || string(rest) != line || ok {
+			t.Errorf("CutLogPrefix(%q) = %+v, %q, %t; want {}, %q, false", line, prefix, rest, ok, line)
+		}
+	}
+}
+
+func TestStripLogPrefixes(t *testing.T) {
+	const src = "[0.000 out] Hello\n[0.010 err] World\nno timestamp\n[1.250] partial"
+	const want = "Hello\nWorld\nno timestamp\npartial"
+	if got := string(StripLogPrefixes(nil, []byte(src))); got != want {
+		t.Errorf("StripLogPrefixes(nil, %q) = %q; want %q", src, got, want)
+	}
+}
+
+func TestParsePhaseMarker(t *testing.T) {
+	tests := []struct {
+		line string
+		name string
+		ok   bool
+	}{
+		{"@zb-phase configure\n", "configure", true},
+		{"@zb-phase  install \r\n", "install", true},
+		{"@zb-phase \n", "", false},
+		{"@zb-phaseconfigure\n", "", false},
+		{" @zb-phase configure\n", "", false},
+		{"configure\n", "", false},
+	}
+	for _, test := range tests {
+		name, ok := ParsePhaseMarker([]byte(test.line))
+		if name != test.name || ok != test.ok {
+			t.Errorf("ParsePhaseMarker(%q) = %q, %t; want %q, %t", test.line, name, ok, test.name, test.ok)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package zbstorerpc
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"strconv"
+	"strings"
+
+	"github.com/go-json-experiment/json/jsontext"
+	"github.com/tinylib/msgp/msgp"
+)
+
+// msgpackContentType is the MIME media type for zb store API messages
+// encoded in MessagePack.
+const msgpackContentType = "application/zb-store-rpc+msgpack"
+
+// maxMsgpackHeaderSize is the size of the largest MessagePack map or array header.
+const maxMsgpackHeaderSize = 5
+
+// jsonToMsgpack converts a JSON value to its MessagePack equivalent.
+// Integers that fit in 64 bits are encoded as MessagePack integers
+// and all other numbers are encoded as 64-bit floats.
+func jsonToMsgpack(v jsontext.Value) ([]byte, error) {
+	dec := jsontext.NewDecoder(bytes.NewReader(v))
+	buf, err := appendMsgpackValue(make([]byte, 0, len(v)), dec)
+	if err != nil {
+		return nil, fmt.Errorf("convert json to msgpack: %v", err)
+	}
+	return buf, nil
+}
+
+func appendMsgpackValue(dst []byte, dec *jsontext.Decoder) ([]byte, error) {
+	tok, err := dec.ReadToken()
+	if err != nil {
+		return dst, err
+	}
+	switch tok.Kind() {
+	case 'n':
+		return msgp.AppendNil(dst), nil
+	case 't', 'f':
+		return msgp.AppendBool(dst, tok.Bool()), nil
+	case '"':
+		return msgp.AppendString(dst, tok.String()), nil
+	case '0':
+		s := tok.String()
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return msgp.AppendInt64(dst, i), nil
+		}
+		if u, err := strconv.ParseUint(s, 10, 64); err == nil {
+			return msgp.AppendUint64(dst, u), nil
+		}
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return dst, err
+		}
+		return msgp.AppendFloat64(dst, f), nil
+	case '{':
+		start := len(dst)
+		dst = append(dst, make([]byte, maxMsgpackHeaderSize)...)
+		var n uint32
+		for dec.PeekKind() != '}' {
+			// Keys are always strings.
+			if dst, err = appendMsgpackValue(dst, dec); err != nil {
+				return dst, err
+			}
+			if dst, err = appendMsgpackValue(dst, dec); err != nil {
+				return dst, err
+			}
+			n++
+		}
+		if _, err := dec.ReadToken(); err != nil {
+			return dst, err
+		}
+		return fillMsgpackHeader(dst, start, msgp.AppendMapHeader(nil, n)), nil
+	case '[':
+		start := len(dst)
+		dst = append(dst, make([]byte, maxMsgpackHeaderSize)...)
+		var n uint32
+		for dec.PeekKind() != ']' {
+			if dst, err = appendMsgpackValue(dst, dec); err != nil {
+				return dst, err
+			}
+			n++
+		}
+		if _, err := dec.ReadToken(); err != nil {
+			return dst, err
+		}
+		return fillMsgpackHeader(dst, start, msgp.AppendArrayHeader(nil, n)), nil
+	default:
+		return dst, fmt.Errorf("unexpected %v", tok.Kind())
+	}
+}
+
+// fillMsgpackHeader replaces the placeholder of [maxMsgpackHeaderSize] bytes
+// at dst[start:] with hdr,
+// moving the elements that follow the placeholder as needed.
+func fillMsgpackHeader(dst []byte, start int, hdr []byte) []byte {
+	shift := maxMsgpackHeaderSize - len(hdr)
+	copy(dst[start+len(hdr):], dst[start+maxMsgpackHeaderSize:])
+	copy(dst[start:], hdr)
+	return dst[:len(dst)-shift]
+}
+
+// msgpackToJSON converts a single MessagePack value to JSON.
+func msgpackToJSON(msg []byte) (jsontext.Value, error) {
+	rest, err := msgp.Skip(msg)
+	if err != nil {
+		return nil, fmt.Errorf("convert msgpack to json: %v", err)
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("convert msgpack to json: %d bytes of trailing data", len(rest))
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, len(msg)))
+	if _, err := msgp.UnmarshalAsJSON(buf, msg); err != nil {
+		return nil, fmt.Errorf("convert msgpack to json: %v", err)
+	}
+	v := jsontext.Value(buf.Bytes())
+	if !v.IsValid() {
+		// For example, maps with non-string keys.
+		return nil, fmt.Errorf("convert msgpack to json: value has no json equivalent")
+	}
+	return v, nil
+}
+
+// acceptsMsgpack reports whether the given Accept header value
+// lists [msgpackContentType].
+func acceptsMsgpack(accept string) bool {
+	for mediaRange := range strings.SplitSeq(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(mediaRange)
+		if err == nil && mediaType == msgpackContentType {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,63 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package zbstorerpc
+
+import (
+	"testing"
+
+	"github.com/go-json-experiment/json/jsontext"
+)
+
+const exampleRequest = `{"jsonrpc":"2.0","id":1,"method":"zb.info","params":{"path":"/opt/zb/store/ffffffffffffffffffffffffffffffff-hello.txt"}}`
+
+func TestMsgpackRoundTrip(t *testing.T) {
+	tests := []string{
+		`null`,
+		`true`,
+		`false`,
+		`""`,
+		`"hello, \"world\"\n"`,
+		`0`,
+		`-42`,
+		`9223372036854775807`,
+		`18446744073709551615`,
+		`1.5`,
+		`[]`,
+		`{}`,
+		`[1,"two",[3],{"four":4}]`,
+		exampleRequest,
+	}
+	for _, test := range tests {
+		msg, err := jsonToMsgpack(jsontext.Value(test))
+		if err != nil {
+			t.Errorf("jsonToMsgpack(%s): %v", test, err)
+			continue
+		}
+		got, err := msgpackToJSON(msg)
+		if err != nil {
+			t.Errorf("msgpackToJSON(jsonToMsgpack(%s)): %v", test, err)
+			continue
+		}
+		if string(got) != test {
+			t.Errorf("msgpackToJSON(jsonToMsgpack(%s)) = %s", test, got)
+		}
+	}
+}
+
+func TestMsgpackSize(t *testing.T) {
+	msg, err := jsonToMsgpack(jsontext.Value(exampleRequest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msg) >= len(exampleRequest) {
+		t.Errorf("jsonToMsgpack(%s) is %d bytes; want < %d", exampleRequest, len(msg), len(exampleRequest))
+	}
+}
+
+func TestMsgpackToJSONTrailingData(t *testing.T) {
+	// Two positive fixints.
+	if got, err := msgpackToJSON([]byte{0x01, 0x02}); err == nil {
+		t.Errorf("msgpackToJSON([1 2]) = %s, <nil>; want error", got)
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package zbstorerpc
+
+import (
+	"strconv"
+	"strings"
+
+	"zb.256lights.llc/pkg/internal/jsonrpc"
+	"zb.256lights.llc/pkg/zbstore"
+)
+
+// ExportNARSizesHeaderName is the name of the header
+// that an export message uses to announce the size of each store object it contains.
+// The value is a comma-separated list of decimal NAR sizes in bytes,
+// in the same order as the store objects in the export.
+// Senders may omit the header, such as when the export is too large to list.
+const ExportNARSizesHeaderName = "Zb-Export-Nar-Sizes"
+
+// MaxExportNARSizes is the maximum number of sizes
+// that a sender should list in [ExportNARSizesHeaderName].
+const MaxExportNARSizes = 4096
+
+// FormatExportNARSizes formats a value for [ExportNARSizesHeaderName].
+func FormatExportNARSizes(sizes []int64) string {
+	sb := new(strings.Builder)
+	for i, n := range sizes {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		sb.WriteString(strconv.FormatInt(n, 10))
+	}
+	return sb.String()
+}
+
+// ParseExportNARSizes parses the [ExportNARSizesHeaderName] field of the given header.
+// It returns nil if the header is missing or malformed.
+func ParseExportNARSizes(header jsonrpc.Header) []int64 {
+	v := header.Get(ExportNARSizesHeaderName)
+	if v == "" {
+		return nil
+	}
+	var sizes []int64
+	for part := range strings.SplitSeq(v, ",") {
+		n, err := strconv.ParseInt(strings.TrimSpace(part), 10, 64)
+		if err != nil || n < 0 {
+			return nil
+		}
+		sizes = append(sizes, n)
+	}
+	return sizes
+}
+
+// ExportProgress is a snapshot of the transfer of a store object in an export.
+type ExportProgress struct {
+	// Index is the zero-based position of the store object in the export.
+	Index int
+	// Count is the number of store objects in the export
+	// or -1 if the sender did not announce it.
+	Count int
+	// StorePath is the path of the store object.
+	// It is empty until the store object has been fully transferred,
+	// since an export names each store object after its content.
+	StorePath zbstore.Path
+	// Transferred is the number of bytes of the store object's NAR transferred so far.
+	Transferred int64
+	// Total is the size of the store object's NAR in bytes
+	// or -1 if the sender did not announce it.
+	Total int64
+}
+
+// Done reports whether the store object has been fully transferred.
+func (p ExportProgress) Done() bool {
+	return p.StorePath != ""
+}
+
+// ProgressReceiver is a [zbstore.NARReceiver]
+// that reports the progress of each store object in an export
+// while passing the export through to another [zbstore.NARReceiver].
+// It calls its callback after every write
+// and once more when each store object has been fully received.
+type ProgressReceiver struct {
+	receiver zbstore.NARReceiver
+	progress func(ExportProgress)
+	sizes    []int64
+	curr     ExportProgress
+}
+
+// NewProgressReceiver returns a new [ProgressReceiver]
+// for an export message with the given header.
+// If receiver is nil, the store objects are discarded.
+// NewProgressReceiver panics if progress is nil.
+func NewProgressReceiver(receiver zbstore.NARReceiver, header jsonrpc.Header, progress func(ExportProgress)) *ProgressReceiver {
+	if progress == nil {
+		panic("nil progress callback")
+	}
+	if receiver == nil {
+		receiver = nopReceiver{}
+	}
+	pr := &ProgressReceiver{
+		receiver: receiver,
+		progress: progress,
+		sizes:    ParseExportNARSizes(header),
+	}
+	pr.reset(0)
+	return pr
+}
+
+func (pr *ProgressReceiver) reset(index int) {
+	pr.curr = ExportProgress{
+		Index: index,
+		Count: -1,
+		Total: -1,
+	}
+	if pr.sizes != nil {
+		pr.curr.Count = len(pr.sizes)
+		if index < len(pr.sizes) {
+			pr.curr.Total = pr.sizes[index]
+		}
+	}
+}
+
+// Write passes p through to the underlying receiver
+// and reports the bytes written.
+func (pr *ProgressReceiver) Write(p []byte) (int, error) {
+	n, err := pr.receiver.Write(p)
+	if n > 0 {
+		pr.curr.Transferred += int64(n)
+		pr.progress(pr.curr)
+	}
+	return n, err
+}
+
+// ReceiveNAR passes the trailer through to the underlying receiver
+// and reports that the store object has been fully received.
+func (pr *ProgressReceiver) ReceiveNAR(trailer *zbstore.ExportTrailer) {
+	pr.receiver.ReceiveNAR(trailer)
+	pr.curr.StorePath = trailer.StorePath
+	pr.progress(pr.curr)
+	pr.reset(pr.curr.Index + 1)
+}
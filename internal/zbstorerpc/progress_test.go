@@ -0,0 +1,87 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package zbstorerpc
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"zb.256lights.llc/pkg/internal/jsonrpc"
+	"zb.256lights.llc/pkg/internal/storetest"
+	"zb.256lights.llc/pkg/zbstore"
+	"zombiezen.com/go/nix"
+)
+
+func TestProgressReceiver(t *testing.T) {
+	dir := zbstore.DefaultDirectory()
+	exportBuffer := new(bytes.Buffer)
+	exporter := zbstore.NewExportWriter(exportBuffer)
+	var paths []zbstore.Path
+	var sizes []int64
+	for _, data := range []string{"Hello, World!\n", "Goodbye\n"} {
+		narBuffer := new(bytes.Buffer)
+		if err := storetest.SingleFileNAR(narBuffer, []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+		sizes = append(sizes, int64(narBuffer.Len()))
+		path, _, err := storetest.ExportFlatFile(exporter, dir, "file.txt", []byte(data), nix.SHA256)
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	if err := exporter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	header := make(jsonrpc.Header)
+	header.Set(ExportNARSizesHeaderName, FormatExportNARSizes(sizes))
+	var finished []ExportProgress
+	var last ExportProgress
+	recv := NewProgressReceiver(nil, header, func(p ExportProgress) {
+		if p.Transferred < last.Transferred && p.Index == last.Index {
+			t.Errorf("progress went backward from %+v to %+v", last, p)
+		}
+		last = p
+		if p.Done() {
+			finished = append(finished, p)
+		}
+	})
+	if err := zbstore.ReceiveExport(recv, exportBuffer); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []ExportProgress{
+		{Index: 0, Count: 2, StorePath: paths[0], Transferred: sizes[0], Total: sizes[0]},
+		{Index: 1, Count: 2, StorePath: paths[1], Transferred: sizes[1], Total: sizes[1]},
+	}
+	if diff := cmp.Diff(want, finished); diff != "" {
+		t.Errorf("finished objects (-want +got):\n%s", diff)
+	}
+}
+
+func TestParseExportNARSizes(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []int64
+	}{
+		{value: "", want: nil},
+		{value: "0", want: []int64{0}},
+		{value: "112,4096", want: []int64{112, 4096}},
+		{value: "112, 4096", want: []int64{112, 4096}},
+		{value: "112,-1", want: nil},
+		{value: "112,,4096", want: nil},
+	}
+	for _, test := range tests {
+		header := make(jsonrpc.Header)
+		if test.value != "" {
+			header.Set(ExportNARSizesHeaderName, test.value)
+		}
+		got := ParseExportNARSizes(header)
+		if !cmp.Equal(test.want, got) {
+			t.Errorf("ParseExportNARSizes(%q) = %v; want %v", test.value, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package zbstorerpc
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+
+	jsonv2 "github.com/go-json-experiment/json"
+	"github.com/go-json-experiment/json/jsontext"
+	"zb.256lights.llc/pkg/internal/jsonrpc"
+)
+
+// Interaction is a single JSON-RPC exchange
+// as written by a [Recorder] and read by a [Replayer].
+// Interactions are stored one per line as JSON objects.
+type Interaction struct {
+	Method       string            `json:"method"`
+	Params       jsontext.Value    `json:"params,omitempty"`
+	Notification bool              `json:"notification,omitzero"`
+	Result       jsontext.Value    `json:"result,omitempty"`
+	Error        *InteractionError `json:"error,omitempty"`
+}
+
+// InteractionError is the error returned for an [Interaction].
+type InteractionError struct {
+	Code    jsonrpc.ErrorCode `json:"code"`
+	Message string            `json:"message"`
+}
+
+// Recorder is a [jsonrpc.Handler] that forwards requests to another handler
+// and writes each exchange to a log that a [Replayer] can read.
+// Exchanges that fail without a JSON-RPC error code
+// (for example, because the connection was lost or the request was canceled)
+// are not recorded.
+// Methods on Recorder are safe to call from multiple goroutines.
+type Recorder struct {
+	handler jsonrpc.Handler
+
+	mu  sync.Mutex
+	w   io.Writer
+	err error
+}
+
+// NewRecorder returns a new [Recorder] that forwards requests to h
+// and writes the exchanges to w.
+func NewRecorder(h jsonrpc.Handler, w io.Writer) *Recorder {
+	return &Recorder{handler: h, w: w}
+}
+
+// JSONRPC implements [jsonrpc.Handler].
+func (r *Recorder) JSONRPC(ctx context.Context, req *jsonrpc.Request) (*jsonrpc.Response, error) {
+	resp, err := r.handler.JSONRPC(ctx, req)
+	code, hasCode := jsonrpc.CodeFromError(err)
+	if err != nil && (!hasCode || code == jsonrpc.RequestCancelled) {
+		return resp, err
+	}
+
+	interaction := &Interaction{
+		Method:       req.Method,
+		Params:       req.Params,
+		Notification: req.Notification,
+	}
+	if err != nil {
+		interaction.Error = &InteractionError{
+			Code:    code,
+			Message: err.Error(),
+		}
+	} else if resp != nil && !req.Notification {
+		interaction.Result = resp.Result
+	}
+	line, marshalError := jsonv2.Marshal(interaction)
+	if marshalError == nil {
+		line = append(line, '\n')
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err == nil {
+		if marshalError != nil {
+			r.err = fmt.Errorf("record %s: %v", req.Method, marshalError)
+		} else if _, writeError := r.w.Write(line); writeError != nil {
+			r.err = fmt.Errorf("record %s: %v", req.Method, writeError)
+		}
+	}
+	return resp, err
+}
+
+// StoreImport implements [zbstore.Importer]
+// by importing to the underlying handler as [*Store.StoreImport] does.
+// Imports are not recorded:
+// a [Replayer] accepts and discards all imports.
+func (r *Recorder) StoreImport(ctx context.Context, src io.Reader) error {
+	return (&Store{Handler: r.handler}).StoreImport(ctx, src)
+}
+
+// Err returns the first error encountered while writing the log, if any.
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// Replayer is a [jsonrpc.Handler] that responds to requests
+// with the exchanges recorded by a [Recorder].
+// A request is answered by the first unused interaction
+// with the same method and equivalent parameters,
+// so concurrent requests can be replayed in a different order than they were recorded.
+// Once all matching interactions have been used,
+// the last one is used again,
+// which allows polling requests like [GetBuildMethod] to be repeated.
+// Methods on Replayer are safe to call from multiple goroutines.
+type Replayer struct {
+	mu           sync.Mutex
+	interactions []*replayInteraction
+}
+
+type replayInteraction struct {
+	*Interaction
+	params jsontext.Value
+	used   bool
+}
+
+// NewReplayer reads a log written by a [Recorder]
+// and returns a new [Replayer] that replays it.
+func NewReplayer(r io.Reader) (*Replayer, error) {
+	rep := new(Replayer)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 64<<20)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		i := new(Interaction)
+		if err := jsonv2.Unmarshal(scanner.Bytes(), i); err != nil {
+			return nil, fmt.Errorf("read store interactions: line %d: %v", lineno, err)
+		}
+		if i.Method == "" {
+			return nil, fmt.Errorf("read store interactions: line %d: missing method", lineno)
+		}
+		params, err := canonicalParams(i.Params)
+		if err != nil {
+			return nil, fmt.Errorf("read store interactions: line %d: params: %v", lineno, err)
+		}
+		rep.interactions = append(rep.interactions, &replayInteraction{
+			Interaction: i,
+			params:      params,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read store interactions: %v", err)
+	}
+	return rep, nil
+}
+
+// JSONRPC implements [jsonrpc.Handler].
+// If there is no matching interaction,
+// JSONRPC returns an error with the [jsonrpc.InternalError] code.
+func (rep *Replayer) JSONRPC(ctx context.Context, req *jsonrpc.Request) (*jsonrpc.Response, error) {
+	params, err := canonicalParams(req.Params)
+	if err != nil {
+		return nil, jsonrpc.Error(jsonrpc.InvalidParams, err)
+	}
+
+	rep.mu.Lock()
+	var match *replayInteraction
+	for _, i := range rep.interactions {
+		if i.Method != req.Method || !slices.Equal(i.params, params) {
+			continue
+		}
+		match = i
+		if !i.used {
+			break
+		}
+	}
+	if match != nil {
+		match.used = true
+	}
+	rep.mu.Unlock()
+
+	if match == nil {
+		return nil, jsonrpc.Error(jsonrpc.InternalError, fmt.Errorf("replay %s: no recorded interaction for params %s", req.Method, req.Params))
+	}
+	if match.Error != nil {
+		return nil, jsonrpc.Error(match.Error.Code, errors.New(match.Error.Message))
+	}
+	return &jsonrpc.Response{Result: match.Result}, nil
+}
+
+// StoreImport implements [zbstore.Importer] by discarding the data in src.
+func (rep *Replayer) StoreImport(ctx context.Context, src io.Reader) error {
+	if _, err := io.Copy(io.Discard, src); err != nil {
+		return fmt.Errorf("import store objects: %v", err)
+	}
+	return nil
+}
+
+// canonicalParams returns params in a form that can be compared byte-wise.
+func canonicalParams(params jsontext.Value) (jsontext.Value, error) {
+	if len(params) == 0 {
+		return nil, nil
+	}
+	params = slices.Clone(params)
+	if err := params.Canonicalize(); err != nil {
+		return nil, err
+	}
+	return params, nil
+}
@@ -0,0 +1,114 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package zbstorerpc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"zb.256lights.llc/pkg/internal/jsonrpc"
+	"zb.256lights.llc/pkg/zbstore"
+)
+
+func TestRecordReplay(t *testing.T) {
+	ctx := context.Background()
+	dir := zbstore.Directory("/zb/store")
+	existsPath, err := dir.Object("00000000000000000000000000000000-exists.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	missingPath, err := dir.Object("11111111111111111111111111111111-missing.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buildPolls := 0
+	server := jsonrpc.ServeMux{
+		InfoMethod: jsonrpc.HandlerFunc(func(ctx context.Context, req *jsonrpc.Request) (*jsonrpc.Response, error) {
+			if strings.Contains(string(req.Params), string(existsPath)) {
+				return &jsonrpc.Response{Result: []byte(`{"info":{"narHash":"sha256:0000000000000000000000000000000000000000000000000000","narSize":42}}`)}, nil
+			}
+			return &jsonrpc.Response{Result: []byte(`{"info":null}`)}, nil
+		}),
+		GetBuildMethod: jsonrpc.HandlerFunc(func(ctx context.Context, req *jsonrpc.Request) (*jsonrpc.Response, error) {
+			buildPolls++
+			if buildPolls < 2 {
+				return &jsonrpc.Response{Result: []byte(`{"status":"active"}`)}, nil
+			}
+			return &jsonrpc.Response{Result: []byte(`{"status":"success"}`)}, nil
+		}),
+		RealizeMethod: jsonrpc.HandlerFunc(func(ctx context.Context, req *jsonrpc.Request) (*jsonrpc.Response, error) {
+			return nil, jsonrpc.Error(jsonrpc.InvalidParams, errors.New("bad derivation"))
+		}),
+	}
+
+	logBuffer := new(bytes.Buffer)
+	recorder := NewRecorder(server, logBuffer)
+	recordedStore := &Store{Handler: recorder}
+	if _, err := recordedStore.Object(ctx, existsPath); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := recordedStore.Object(ctx, missingPath); !errors.Is(err, zbstore.ErrNotFound) {
+		t.Fatalf("Object(ctx, %s) error = %v; want %v", missingPath, err, zbstore.ErrNotFound)
+	}
+	for range 2 {
+		if err := jsonrpc.Do(ctx, recorder, GetBuildMethod, nil, &GetBuildRequest{BuildID: "123"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := jsonrpc.Do(ctx, recorder, RealizeMethod, nil, &RealizeRequest{}); err == nil {
+		t.Fatalf("%s did not return an error", RealizeMethod)
+	}
+	if err := recorder.Err(); err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("Recorded interactions:\n%s", logBuffer)
+
+	replayer, err := NewReplayer(logBuffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayedStore := &Store{Handler: replayer}
+
+	// Requests can be replayed in a different order.
+	if _, err := replayedStore.Object(ctx, missingPath); !errors.Is(err, zbstore.ErrNotFound) {
+		t.Errorf("replayed Object(ctx, %s) error = %v; want %v", missingPath, err, zbstore.ErrNotFound)
+	}
+	if _, err := replayedStore.Object(ctx, existsPath); err != nil {
+		t.Errorf("replayed Object(ctx, %s): %v", existsPath, err)
+	}
+
+	// Repeated requests get the recorded responses in order,
+	// then the last response again.
+	var gotStatuses []BuildStatus
+	for range 3 {
+		resp := new(Build)
+		if err := jsonrpc.Do(ctx, replayer, GetBuildMethod, resp, &GetBuildRequest{BuildID: "123"}); err != nil {
+			t.Fatal(err)
+		}
+		gotStatuses = append(gotStatuses, resp.Status)
+	}
+	wantStatuses := []BuildStatus{BuildActive, BuildSuccess, BuildSuccess}
+	if diff := cmp.Diff(wantStatuses, gotStatuses); diff != "" {
+		t.Errorf("replayed build statuses (-want +got):\n%s", diff)
+	}
+
+	err = jsonrpc.Do(ctx, replayer, RealizeMethod, nil, &RealizeRequest{})
+	if code, _ := jsonrpc.CodeFromError(err); code != jsonrpc.InvalidParams {
+		t.Errorf("replayed %s error = %v (code %d); want code %d", RealizeMethod, err, code, jsonrpc.InvalidParams)
+	}
+
+	err = jsonrpc.Do(ctx, replayer, GetBuildMethod, nil, &GetBuildRequest{BuildID: "456"})
+	if err == nil {
+		t.Errorf("replayed %s for unrecorded build did not return an error", GetBuildMethod)
+	}
+
+	if err := replayedStore.StoreImport(ctx, strings.NewReader("ignored")); err != nil {
+		t.Errorf("replayed StoreImport: %v", err)
+	}
+}
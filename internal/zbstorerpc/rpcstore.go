@@ -62,8 +62,13 @@ func (s *Store) Object(ctx context.Context, path zbstore.Path) (zbstore.Object,
 
 // StoreImport implements [zbstore.Importer]
 // by sending the `nix-store --export` data over the underlying connection.
-// StoreImport will return an error if s.Handler is not a [*jsonrpc.Client] using a [*Codec].
+// If s.Handler implements [zbstore.Importer] (like [*Recorder] and [*Replayer]),
+// then StoreImport calls its StoreImport method instead.
+// Otherwise, StoreImport will return an error if s.Handler is not a [*jsonrpc.Client] using a [*Codec].
 func (s *Store) StoreImport(ctx context.Context, r io.Reader) error {
+	if importer, ok := s.Handler.(zbstore.Importer); ok {
+		return importer.StoreImport(ctx, r)
+	}
 	client, ok := s.Handler.(*jsonrpc.Client)
 	if !ok {
 		return fmt.Errorf("import store objects: store handler is %T (want %T)", s.Handler, (*jsonrpc.Client)(nil))
@@ -63,6 +63,47 @@ type ObjectInfo struct {
 	CA zbstore.ContentAddress `json:"ca"`
 }
 
+// PathInfoMethod is the name of the method that returns
+// everything the store has recorded about a store object.
+// [PathInfoRequest] is used for the request
+// and [PathInfoResponse] is used for the response.
+const PathInfoMethod = "zb.pathInfo"
+
+// PathInfoRequest is the set of parameters for [PathInfoMethod].
+type PathInfoRequest struct {
+	Path zbstore.Path `json:"path"`
+	// ClosureSize indicates that the store should compute [PathInfo.ClosureSize].
+	ClosureSize bool `json:"closureSize,omitzero"`
+	// Signatures indicates that the store should fill in [PathInfo.Signatures].
+	Signatures bool `json:"signatures,omitzero"`
+}
+
+// PathInfoResponse is the result for [PathInfoMethod].
+type PathInfoResponse struct {
+	// Info is the information for the requested path,
+	// or null if the path does not exist.
+	Info *PathInfo `json:"info"`
+}
+
+// PathInfo is the information about a store object used in [PathInfoResponse].
+type PathInfo struct {
+	ObjectInfo `json:",inline"`
+	// Derivers is the sorted list of derivations
+	// whose builds in this store produced the store object.
+	Derivers []zbstore.Path `json:"derivers,omitempty"`
+	// RegistrationTime is the time that the store object was added to the store.
+	// It is the zero time if the store did not record when the object was added.
+	RegistrationTime time.Time `json:"registrationTime,omitzero"`
+	// ClosureSize is the sum of the NAR sizes of the store object
+	// and all the store objects it transitively references.
+	// It is only set if [PathInfoRequest.ClosureSize] is true.
+	ClosureSize int64 `json:"closureSize,omitzero"`
+	// Signatures is the list of signatures
+	// of the realizations that name the store object as their output.
+	// It is only set if [PathInfoRequest.Signatures] is true.
+	Signatures []*zbstore.RealizationSignature `json:"signatures,omitempty"`
+}
+
 // RealizeMethod is the name of the method that triggers a build of a store path.
 // [RealizeRequest] is used for the request
 // and [RealizeResponse] is used for the response.
@@ -76,6 +117,55 @@ type RealizeRequest struct {
 	KeepFailed bool `json:"keepFailed"`
 	// Reuse defines the set of realizations that the server can use from previous builds.
 	Reuse *ReusePolicy `json:"reuse"`
+	// Check indicates that the server should run the builders
+	// of the derivations named in DrvPaths
+	// even if their outputs have been realized previously
+	// and fail the build if the new outputs differ from the existing realizations.
+	// Dependencies are realized normally.
+	Check bool `json:"check,omitzero"`
+	// Priority is the scheduling priority of the build's builders.
+	// When the server limits the number of concurrent builders,
+	// builders of builds with a higher priority start
+	// before those of builds with a lower priority.
+	// The default priority is zero.
+	// The server may lower priorities above a configured limit.
+	Priority int `json:"priority,omitzero"`
+	// SubstituteOnly indicates that the server must not run any builders.
+	// Realizations and store objects may still be reused
+	// or downloaded from the server's substituters,
+	// but any derivation that would need to be built locally
+	// fails instead of running its builder.
+	// SubstituteOnly cannot be combined with Check.
+	SubstituteOnly bool `json:"substituteOnly,omitzero"`
+	// Offline indicates that the server must not use the network
+	// while realizing the derivations.
+	// The server will not download from its substituters
+	// or upload to its cache,
+	// and derivations that require network access
+	// (like fixed-output fetches)
+	// fail without running their builders.
+	Offline bool `json:"offline,omitzero"`
+	// ReplaySchedule is the ID of a previous build
+	// whose scheduling decisions the server should follow.
+	// The server processes derivations in the order that the previous build did,
+	// runs builders as the same build users when they are available,
+	// and runs the builders of derivations that the previous build ran builders for
+	// even if their outputs have been realized since,
+	// failing the build if the new outputs differ as with Check.
+	// Derivations that the previous build did not process are scheduled normally.
+	// The default is to not replay a schedule.
+	ReplaySchedule string `json:"replaySchedule,omitempty"`
+	// TraceExec indicates that the server should record
+	// every program that the builders execute.
+	// The trace for a derivation can be read with [ReadLogMethod]
+	// by setting [ReadLogRequest.Trace].
+	// Servers that cannot trace builders reject the request.
+	TraceExec bool `json:"traceExec,omitzero"`
+	// TraceInputs indicates that the server should record
+	// which of each builder's inputs the builder opens
+	// and report them in [BuildResult.InputUsage].
+	// Servers that cannot trace builders reject the request.
+	TraceInputs bool `json:"traceInputs,omitzero"`
 }
 
 // ReusePolicy specifies a policy for [RealizeRequest] or [ExpandRequest]
@@ -239,6 +329,69 @@ type BuildResult struct {
 	Status  BuildStatus        `json:"status"`
 	Outputs []*RealizeOutput   `json:"outputs"`
 	LogSize int64              `json:"logSize"`
+	// RebuildReason explains why the store ran the derivation's builder
+	// instead of reusing an existing realization.
+	// It is nil if the builder was not run
+	// or the store does not record reasons.
+	RebuildReason *RebuildReason `json:"rebuildReason,omitempty"`
+	// Phases is the list of phases that the builder announced
+	// with phase markers in its log, in the order they started.
+	// It is empty if the builder did not announce any phases
+	// or the store does not record phases.
+	Phases []*BuildPhase `json:"phases,omitempty"`
+	// Schedule is the set of scheduling decisions the store made for the derivation.
+	// It is nil if the store does not record scheduling decisions.
+	Schedule *BuildSchedule `json:"schedule,omitempty"`
+	// InputUsage reports which of the derivation's inputs the builder opened.
+	// It is nil unless the build set [RealizeRequest.TraceInputs]
+	// and the builder ran.
+	InputUsage *InputUsage `json:"inputUsage,omitempty"`
+}
+
+// InputUsage reports which of a derivation's inputs its builder opened.
+// The inputs of a derivation are its input sources
+// and the outputs of its input derivations.
+// An input is used if the builder opened or executed any file in it,
+// including through a symlink.
+// Inputs that the builder only refers to by path
+// (e.g. a runtime dependency whose path is written into a script)
+// are reported as unused.
+type InputUsage struct {
+	// Used is the sorted list of inputs that the builder opened.
+	Used []zbstore.Path `json:"used"`
+	// Unused is the sorted list of inputs that the builder did not open.
+	Unused []zbstore.Path `json:"unused"`
+}
+
+// BuildSchedule is the set of scheduling decisions
+// that the store made for a derivation in a build.
+// [RealizeRequest.ReplaySchedule] can be used to replay the decisions in a later build.
+type BuildSchedule struct {
+	// Seq is the 1-based position of the derivation
+	// in the order that the build processed derivations.
+	Seq int `json:"seq"`
+	// Built is true if the store ran the derivation's builder
+	// instead of reusing existing realizations.
+	Built bool `json:"built"`
+	// BuildUser is the Unix user that the builder ran as
+	// in the form "UID:GID".
+	// It is empty if the builder did not run
+	// or the store did not use a build user.
+	BuildUser string `json:"buildUser,omitempty"`
+}
+
+// BuildPhase is a span of a builder's execution
+// started by a phase marker in the builder's log.
+// A phase ends when the next phase starts or when the builder exits.
+type BuildPhase struct {
+	Name      string    `json:"name"`
+	StartedAt time.Time `json:"startedAt"`
+	EndedAt   time.Time `json:"endedAt"`
+}
+
+// Duration returns the length of the phase.
+func (phase *BuildPhase) Duration() time.Duration {
+	return phase.EndedAt.Sub(phase.StartedAt)
 }
 
 // OutputForName returns the [*RealizeOutput] with the given name.
@@ -297,6 +450,311 @@ type RealizeOutput struct {
 	Path Nullable[zbstore.Path] `json:"path"`
 	// Signatures is the set of signatures for the realization.
 	Signatures []*zbstore.RealizationSignature `json:"signatures"`
+	// Provenance describes how the store satisfied the output.
+	// It is null if the output has not been realized
+	// or the store does not record provenance.
+	Provenance *OutputProvenance `json:"provenance,omitempty"`
+}
+
+// ProvenanceMethod is an enumeration of ways that a store can satisfy
+// a [RealizeOutput].
+type ProvenanceMethod string
+
+// Defined provenance methods.
+const (
+	// ProvenanceBuilt indicates that the store ran the derivation's builder
+	// to produce the output.
+	ProvenanceBuilt ProvenanceMethod = "built"
+	// ProvenanceReused indicates that the store reused a realization
+	// whose store object was already present in the store.
+	ProvenanceReused ProvenanceMethod = "reused"
+	// ProvenanceSubstituted indicates that the store reused a realization
+	// and copied its store object from another store.
+	ProvenanceSubstituted ProvenanceMethod = "substituted"
+	// ProvenanceRemoteBuilt indicates that the store delegated the build
+	// to another machine.
+	ProvenanceRemoteBuilt ProvenanceMethod = "remoteBuilt"
+)
+
+// OutputProvenance is the provenance of a [RealizeOutput].
+type OutputProvenance struct {
+	Method ProvenanceMethod `json:"method"`
+	// Store is a human-readable identifier (typically a URL)
+	// for the store that the object was copied from.
+	// It is only set for [ProvenanceSubstituted].
+	Store string `json:"store,omitempty"`
+	// Machine is a human-readable identifier for the machine that built the object.
+	// It is only set for [ProvenanceRemoteBuilt].
+	Machine string `json:"machine,omitempty"`
+}
+
+// String returns a short human-readable description of the provenance
+// like "built" or "substituted from https://example.com/".
+func (p *OutputProvenance) String() string {
+	if p == nil {
+		return "unknown"
+	}
+	switch {
+	case p.Method == ProvenanceSubstituted && p.Store != "":
+		return string(p.Method) + " from " + p.Store
+	case p.Method == ProvenanceRemoteBuilt && p.Machine != "":
+		return string(p.Method) + " on " + p.Machine
+	default:
+		return string(p.Method)
+	}
+}
+
+// RebuildReasonKind is an enumeration of reasons that a store
+// could not reuse an existing realization for a derivation.
+type RebuildReasonKind string
+
+// Defined rebuild reasons.
+const (
+	// RebuildNoRealizations indicates that the store has never realized the derivation.
+	RebuildNoRealizations RebuildReasonKind = "noRealizations"
+	// RebuildInputsChanged indicates that the store has realized the derivation before,
+	// but one or more of its inputs were realized to different store objects this time.
+	// [RebuildReason.ChangedInputs] lists the differing inputs.
+	RebuildInputsChanged RebuildReasonKind = "inputsChanged"
+	// RebuildReusePolicy indicates that realizations exist,
+	// but the request's [ReusePolicy] did not permit reusing any realizations.
+	RebuildReusePolicy RebuildReasonKind = "reusePolicy"
+	// RebuildMissingSignature indicates that realizations exist,
+	// but none were signed by a key in the request's [ReusePolicy].
+	RebuildMissingSignature RebuildReasonKind = "missingSignature"
+	// RebuildConflictingClosure indicates that permitted realizations exist,
+	// but each either references store objects that conflict
+	// with other realizations used in the build
+	// or is one of several equally suitable realizations.
+	RebuildConflictingClosure RebuildReasonKind = "conflictingClosure"
+	// RebuildUnavailable indicates that permitted realizations exist,
+	// but their store objects are not present in the store
+	// and could not be copied from another store.
+	RebuildUnavailable RebuildReasonKind = "unavailable"
+	// RebuildCheck indicates that the client requested that the derivation be rebuilt
+	// to check its existing realizations.
+	RebuildCheck RebuildReasonKind = "check"
+)
+
+// RebuildReason is the explanation in a [BuildResult]
+// of why the store ran a derivation's builder.
+type RebuildReason struct {
+	Kind RebuildReasonKind `json:"kind"`
+	// OutputName is the name of the output that did not have a reusable realization.
+	// It is empty for [RebuildCheck].
+	OutputName string `json:"outputName,omitempty"`
+	// PreviousBuildID is the ID of the most recent build
+	// that ran the derivation's builder with different inputs.
+	// It is only set for [RebuildInputsChanged].
+	PreviousBuildID string `json:"previousBuildID,omitempty"`
+	// ChangedInputs is the list of inputs whose store objects differ
+	// from the build named by PreviousBuildID.
+	// Inputs that the previous build did not record are omitted.
+	// It is only set for [RebuildInputsChanged].
+	ChangedInputs []*ChangedInput `json:"changedInputs,omitempty"`
+}
+
+// ChangedInput is an input in [RebuildReason]
+// that was realized to a different store object than in a previous build.
+type ChangedInput struct {
+	Input   zbstore.OutputReference `json:"input"`
+	OldPath zbstore.Path            `json:"oldPath"`
+	NewPath zbstore.Path            `json:"newPath"`
+}
+
+// String returns a short human-readable description of the reason
+// like "no realizations exist".
+func (r *RebuildReason) String() string {
+	if r == nil {
+		return "unknown reason"
+	}
+	var s string
+	switch r.Kind {
+	case RebuildNoRealizations:
+		s = "no realizations exist"
+	case RebuildInputsChanged:
+		s = "inputs changed since build " + r.PreviousBuildID
+	case RebuildReusePolicy:
+		s = "reuse policy does not permit existing realizations"
+	case RebuildMissingSignature:
+		s = "no realizations are signed by a trusted key"
+	case RebuildConflictingClosure:
+		s = "existing realizations conflict with other outputs in the build"
+	case RebuildUnavailable:
+		s = "existing realizations are not available"
+	case RebuildCheck:
+		return "checking existing realizations"
+	default:
+		s = string(r.Kind)
+	}
+	if r.OutputName != "" {
+		s = r.OutputName + ": " + s
+	}
+	return s
+}
+
+// AttestationsMethod is the name of the method
+// that returns the signed build attestations for a store object.
+// [AttestationsRequest] is used for the request
+// and [AttestationsResponse] is used for the response.
+const AttestationsMethod = "zb.attestations"
+
+// AttestationsRequest is the set of parameters for [AttestationsMethod].
+type AttestationsRequest struct {
+	Path zbstore.Path `json:"path"`
+}
+
+// AttestationsResponse is the result for [AttestationsMethod].
+type AttestationsResponse struct {
+	// Attestations is the list of attestations recorded for the requested path
+	// in the order they were created.
+	// It is empty if the store has no attestations for the path.
+	Attestations []*zbstore.AttestationEnvelope `json:"attestations"`
+}
+
+// RealizationsMethod is the name of the method
+// that returns the realizations the store has recorded for a derivation.
+// [RealizationsRequest] is used for the request
+// and [RealizationsResponse] is used for the response.
+const RealizationsMethod = "zb.realizations"
+
+// RealizationsRequest is the set of parameters for [RealizationsMethod].
+// At most one of DerivationHash or DrvPath may be set.
+// If neither is set, then the store returns every realization it has recorded.
+type RealizationsRequest struct {
+	// DerivationHash is the hash of the derivation to look up.
+	DerivationHash nix.Hash `json:"derivationHash,omitzero"`
+	// DrvPath is the path of a derivation to look up.
+	// The store uses the derivation hashes recorded by previous builds of DrvPath,
+	// so realizations are only returned for derivations that the store has built.
+	DrvPath zbstore.Path `json:"drvPath,omitzero"`
+	// OutputName restricts the results to the given output if not empty.
+	OutputName string `json:"outputName,omitempty"`
+}
+
+// RealizationsByPathMethod is the name of the method
+// that returns the realizations that produced a store object.
+// [RealizationsByPathRequest] is used for the request
+// and [RealizationsResponse] is used for the response.
+const RealizationsByPathMethod = "zb.realizationsByPath"
+
+// RealizationsByPathRequest is the set of parameters for [RealizationsByPathMethod].
+type RealizationsByPathRequest struct {
+	Path zbstore.Path `json:"path"`
+}
+
+// RealizationsResponse is the result for [RealizationsMethod]
+// and [RealizationsByPathMethod].
+type RealizationsResponse struct {
+	// Realizations is the list of matching realizations
+	// grouped by derivation hash.
+	// Each map's realizations include their reference classes
+	// and every signature the store has for them.
+	// It is empty if the store has no matching realizations.
+	Realizations []*zbstore.RealizationMap `json:"realizations"`
+}
+
+// ReferrersMethod is the name of the method
+// that returns the store objects and realizations that reference a store path.
+// [ReferrersRequest] is used for the request
+// and [ReferrersResponse] is used for the response.
+const ReferrersMethod = "zb.referrers"
+
+// ReferrersRequest is the set of parameters for [ReferrersMethod].
+type ReferrersRequest struct {
+	Path zbstore.Path `json:"path"`
+	// Recursive indicates that the store should also return
+	// the store objects and realizations that transitively reference Path.
+	Recursive bool `json:"recursive,omitzero"`
+}
+
+// ReferrersResponse is the result for [ReferrersMethod].
+type ReferrersResponse struct {
+	// Referrers is the sorted list of store objects in the store
+	// that reference the requested path.
+	// It does not include the requested path itself.
+	Referrers []zbstore.Path `json:"referrers"`
+	// Realizations is the list of realizations
+	// with a reference class that names the requested path
+	// (or one of Referrers if the request was recursive),
+	// grouped by derivation hash.
+	// Only the output paths of the realizations are set.
+	Realizations []*zbstore.RealizationMap `json:"realizations"`
+}
+
+// AddSignaturesMethod is the name of the method
+// that adds signatures to realizations that the store has already recorded.
+// [AddSignaturesRequest] is used for the request
+// and [AddSignaturesResponse] is used for the response.
+const AddSignaturesMethod = "zb.addSignatures"
+
+// AddSignaturesRequest is the set of parameters for [AddSignaturesMethod].
+type AddSignaturesRequest struct {
+	// Realizations is the list of realizations to add signatures to.
+	// Only the output paths and signatures are used:
+	// the store verifies each signature against the reference classes it has recorded
+	// and rejects the request if any signature is invalid
+	// or names a realization that the store does not have.
+	Realizations []*zbstore.RealizationMap `json:"realizations"`
+}
+
+// AddSignaturesResponse is the result for [AddSignaturesMethod].
+type AddSignaturesResponse struct {
+	// Added is the number of signatures that the store did not already have.
+	Added int `json:"added"`
+}
+
+// PruneRealizationsMethod is the name of the method
+// that removes stale and duplicate realization data from the store.
+// Realizations whose output is no longer present in the store
+// (and that no other realization refers to) are deleted,
+// and duplicate signatures and reference classes of the same realization are merged.
+// [PruneRealizationsRequest] is used for the request
+// and [PruneRealizationsResponse] is used for the response.
+const PruneRealizationsMethod = "zb.pruneRealizations"
+
+// PruneRealizationsRequest is the set of parameters for [PruneRealizationsMethod].
+type PruneRealizationsRequest struct{}
+
+// PruneRealizationsResponse is the result for [PruneRealizationsMethod].
+type PruneRealizationsResponse struct {
+	// DeletedRealizations is the number of stale realizations that were deleted.
+	DeletedRealizations int64 `json:"deletedRealizations"`
+	// MergedReferenceClasses is the number of duplicate reference classes that were removed.
+	MergedReferenceClasses int64 `json:"mergedReferenceClasses"`
+	// MergedSignatures is the number of duplicate signatures that were removed.
+	MergedSignatures int64 `json:"mergedSignatures"`
+}
+
+// ClearSubstituterMissesMethod is the name of the method
+// that makes the store forget which derivations
+// its fallback store was found to have no realizations for.
+// The next build of such a derivation queries the fallback store again.
+// [ClearSubstituterMissesRequest] is used for the request
+// and [ClearSubstituterMissesResponse] is used for the response.
+const ClearSubstituterMissesMethod = "zb.clearSubstituterMisses"
+
+// ClearSubstituterMissesRequest is the set of parameters for [ClearSubstituterMissesMethod].
+type ClearSubstituterMissesRequest struct{}
+
+// ClearSubstituterMissesResponse is the result for [ClearSubstituterMissesMethod].
+type ClearSubstituterMissesResponse struct {
+	// Deleted is the number of remembered misses that were removed.
+	Deleted int64 `json:"deleted"`
+}
+
+// AddRootMethod is the name of the method
+// that registers a symlink outside the store as a garbage collection root.
+// The store object that the symlink points to will not be deleted
+// as long as the symlink continues to point to it.
+// [AddRootRequest] is used for the request
+// and the response is null.
+const AddRootMethod = "zb.addRoot"
+
+// AddRootRequest is the set of parameters for [AddRootMethod].
+type AddRootRequest struct {
+	// Link is the absolute path of a symlink to a store object.
+	Link string `json:"link"`
 }
 
 // CancelBuildMethod is the name of the method that informs the store
@@ -311,6 +769,19 @@ type CancelBuildNotification struct {
 	BuildID string `json:"buildID"`
 }
 
+// PrefetchMethod is the name of the method that informs the store
+// that the client is likely to realize the given derivations soon.
+// The store may begin querying its substituters for the derivations' outputs
+// in the background so that a later [RealizeMethod] call does not wait on them.
+// [PrefetchNotification] is used for the request
+// and the response is ignored.
+const PrefetchMethod = "zb.prefetch"
+
+// PrefetchNotification is the set of parameters for [PrefetchMethod].
+type PrefetchNotification struct {
+	DrvPaths []zbstore.Path `json:"drvPaths"`
+}
+
 // ReadLogMethod is the name of the method that reads the build log from a running build.
 // [ReadLogRequest] is used for the request
 // and [ReadLogResponse] is used for the response.
@@ -333,6 +804,48 @@ type ReadLogRequest struct {
 	// If non-null, it must be greater than RangeStart.
 	// This method may return less bytes than requested.
 	RangeEnd Nullable[int64] `json:"rangeEnd"`
+	// Trace indicates that the derivation's exec trace
+	// should be read instead of its builder log.
+	// The exec trace is a sequence of [ExecTraceEvent] JSON objects,
+	// each on its own line.
+	// The exec trace is empty unless the build set [RealizeRequest.TraceExec].
+	Trace bool `json:"trace,omitzero"`
+	// Prefixed indicates that each line of the builder log
+	// should start with its [LogPrefix].
+	// Otherwise, the log contains only the lines that were written.
+	// RangeStart and RangeEnd are offsets into the requested form of the log.
+	// Stores without [CapabilityLogTimestamps] ignore the field.
+	Prefixed bool `json:"prefixed,omitzero"`
+}
+
+// ExecTraceEvent is a program that a builder executed.
+// See [RealizeRequest.TraceExec].
+type ExecTraceEvent struct {
+	// PID is the ID of the process that executed the program.
+	// Sandboxed builders may run in their own PID namespace,
+	// so PIDs are only meaningful for relating events in the same trace.
+	PID int `json:"pid"`
+	// Argv is the program's argument list,
+	// starting with the name of the program.
+	Argv []string `json:"argv"`
+	// Dir is the working directory of the process
+	// as seen by the builder.
+	Dir string `json:"dir"`
+	// StartedAt is the time that the process executed the program.
+	StartedAt time.Time `json:"startedAt"`
+	// EndedAt is the time that the program exited
+	// or that the process executed another program.
+	EndedAt time.Time `json:"endedAt"`
+	// ExitCode is the program's exit code.
+	// A program terminated by a signal has an exit code of 128 plus the signal number.
+	// It is null if the process executed another program
+	// or the builder finished before the program exited.
+	ExitCode Nullable[int] `json:"exitCode"`
+}
+
+// Duration returns the length of time that the program ran.
+func (ev *ExecTraceEvent) Duration() time.Duration {
+	return ev.EndedAt.Sub(ev.StartedAt)
 }
 
 // ReadLogResponse is the result for [ReadLogMethod].
@@ -393,6 +906,56 @@ type ExportRequest struct {
 	ExcludeReferences bool `json:"excludeReferences"`
 }
 
+// IsReadOnlyMethod reports whether the method with the given name
+// does not modify the store.
+// Clients may send such requests again if the connection to the store is lost
+// (for example, because the store was restarted),
+// since calling them more than once is harmless.
+func IsReadOnlyMethod(method string) bool {
+	switch method {
+	case NopMethod,
+		HandshakeMethod,
+		ExistsMethod,
+		InfoMethod,
+		PathInfoMethod,
+		GetBuildMethod,
+		GetBuildResultMethod,
+		ReadLogMethod,
+		AttestationsMethod,
+		RealizationsMethod,
+		RealizationsByPathMethod,
+		ReferrersMethod:
+		return true
+	default:
+		return false
+	}
+}
+
+// MetadataMethods returns the names of the methods
+// that only read or write metadata.
+// Stores are expected to respond to such methods promptly,
+// so clients can use a short timeout for them.
+// Methods that can wait on builds or transfer store objects are not included.
+func MetadataMethods() []string {
+	return []string{
+		NopMethod,
+		HandshakeMethod,
+		ExistsMethod,
+		InfoMethod,
+		PathInfoMethod,
+		GetBuildMethod,
+		GetBuildResultMethod,
+		CancelBuildMethod,
+		PrefetchMethod,
+		AttestationsMethod,
+		AddRootMethod,
+		RealizationsMethod,
+		RealizationsByPathMethod,
+		ReferrersMethod,
+		AddSignaturesMethod,
+	}
+}
+
 // Nullable wraps a type to permit a null JSON serialization.
 // The zero value is null.
 type Nullable[T any] = zbstore.Nullable[T]
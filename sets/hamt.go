@@ -0,0 +1,243 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package sets
+
+import (
+	"hash/maphash"
+	"iter"
+	"math/bits"
+	"slices"
+)
+
+// hamtBits is the number of hash bits consumed at each level of a [hamtNode] trie.
+const hamtBits = 5
+
+// hamtSeed is the seed used to hash keys in all [hamtNode] tries.
+var hamtSeed = maphash.MakeSeed()
+
+// hashKey returns the hash of a key stored in a [hamtNode] trie.
+func hashKey[K comparable](key K) uint64 {
+	return maphash.Comparable(hamtSeed, key)
+}
+
+// hamtNode is a node in a hash array mapped trie.
+// Nodes are never modified after they are added to a trie:
+// operations that change a trie copy the nodes along the path to the change
+// and share the rest.
+//
+// A node at a shift less than 64 has one slot per set bit in bitmap,
+// in the order of the hash bits they correspond to.
+// A node at a shift of 64 or more has exhausted the hash,
+// so its bitmap is zero and it holds a list of keys with identical hashes.
+type hamtNode[K comparable, V any] struct {
+	bitmap uint32
+	slots  []hamtSlot[K, V]
+}
+
+// hamtSlot is either an entry in a [hamtNode] or a pointer to a child node.
+type hamtSlot[K comparable, V any] struct {
+	// child is the subtree for the slot.
+	// If child is nil, then the slot is an entry.
+	child *hamtNode[K, V]
+
+	hash  uint64
+	key   K
+	value V
+}
+
+// index returns the slot index and bitmap bit for the given hash at the given shift.
+func (n *hamtNode[K, V]) index(shift uint, h uint64) (i int, bit uint32) {
+	bit = 1 << ((h >> shift) & (1<<hamtBits - 1))
+	return bits.OnesCount32(n.bitmap & (bit - 1)), bit
+}
+
+func (n *hamtNode[K, V]) clone() *hamtNode[K, V] {
+	return &hamtNode[K, V]{
+		bitmap: n.bitmap,
+		slots:  slices.Clone(n.slots),
+	}
+}
+
+// get returns the value for key in the subtree rooted at n.
+func (n *hamtNode[K, V]) get(shift uint, h uint64, key K) (_ V, found bool) {
+	for n != nil {
+		if shift >= 64 {
+			for _, s := range n.slots {
+				if s.key == key {
+					return s.value, true
+				}
+			}
+			break
+		}
+		i, bit := n.index(shift, h)
+		if n.bitmap&bit == 0 {
+			break
+		}
+		s := &n.slots[i]
+		if s.child == nil {
+			if s.hash == h && s.key == key {
+				return s.value, true
+			}
+			break
+		}
+		n = s.child
+		shift += hamtBits
+	}
+	var zero V
+	return zero, false
+}
+
+// insert returns the subtree rooted at n with the given entry added.
+// If the key is already present and replace is false,
+// then insert returns n unchanged.
+func (n *hamtNode[K, V]) insert(shift uint, h uint64, key K, value V, replace bool) (_ *hamtNode[K, V], added bool) {
+	entry := hamtSlot[K, V]{hash: h, key: key, value: value}
+	if n == nil {
+		n = new(hamtNode[K, V])
+	}
+	if shift >= 64 {
+		for i, s := range n.slots {
+			if s.key == key {
+				if !replace {
+					return n, false
+				}
+				n = n.clone()
+				n.slots[i] = entry
+				return n, false
+			}
+		}
+		return &hamtNode[K, V]{slots: append(slices.Clip(n.slots), entry)}, true
+	}
+
+	i, bit := n.index(shift, h)
+	if n.bitmap&bit == 0 {
+		return &hamtNode[K, V]{
+			bitmap: n.bitmap | bit,
+			slots:  slices.Insert(slices.Clip(n.slots), i, entry),
+		}, true
+	}
+	s := &n.slots[i]
+	if s.child != nil {
+		child, added := s.child.insert(shift+hamtBits, h, key, value, replace)
+		if child == s.child {
+			return n, false
+		}
+		n = n.clone()
+		n.slots[i].child = child
+		return n, added
+	}
+	if s.hash == h && s.key == key {
+		if !replace {
+			return n, false
+		}
+		n = n.clone()
+		n.slots[i] = entry
+		return n, false
+	}
+	child := newHAMTPair(shift+hamtBits, *s, entry)
+	n = n.clone()
+	n.slots[i] = hamtSlot[K, V]{child: child}
+	return n, true
+}
+
+// newHAMTPair returns a new subtree that contains the two given entries,
+// which must have different keys.
+func newHAMTPair[K comparable, V any](shift uint, a, b hamtSlot[K, V]) *hamtNode[K, V] {
+	if shift >= 64 {
+		return &hamtNode[K, V]{slots: []hamtSlot[K, V]{a, b}}
+	}
+	const mask = 1<<hamtBits - 1
+	ia := (a.hash >> shift) & mask
+	ib := (b.hash >> shift) & mask
+	if ia == ib {
+		return &hamtNode[K, V]{
+			bitmap: 1 << ia,
+			slots:  []hamtSlot[K, V]{{child: newHAMTPair(shift+hamtBits, a, b)}},
+		}
+	}
+	if ia > ib {
+		a, b = b, a
+	}
+	return &hamtNode[K, V]{
+		bitmap: 1<<ia | 1<<ib,
+		slots:  []hamtSlot[K, V]{a, b},
+	}
+}
+
+// delete returns the subtree rooted at n with key removed.
+// delete returns nil if the subtree would be empty
+// and n unchanged if the key is not present.
+func (n *hamtNode[K, V]) delete(shift uint, h uint64, key K) (_ *hamtNode[K, V], removed bool) {
+	if n == nil {
+		return nil, false
+	}
+	if shift >= 64 {
+		for i, s := range n.slots {
+			if s.key == key {
+				if len(n.slots) == 1 {
+					return nil, true
+				}
+				return &hamtNode[K, V]{slots: slices.Delete(slices.Clone(n.slots), i, i+1)}, true
+			}
+		}
+		return n, false
+	}
+
+	i, bit := n.index(shift, h)
+	if n.bitmap&bit == 0 {
+		return n, false
+	}
+	s := &n.slots[i]
+	if s.child != nil {
+		child, removed := s.child.delete(shift+hamtBits, h, key)
+		if !removed {
+			return n, false
+		}
+		switch {
+		case child == nil:
+			// Fall through to removing the slot.
+		case len(child.slots) == 1 && child.slots[0].child == nil:
+			// Pull lone entries up so that lookups stay shallow.
+			n = n.clone()
+			n.slots[i] = child.slots[0]
+			return n, true
+		default:
+			n = n.clone()
+			n.slots[i].child = child
+			return n, true
+		}
+	} else if s.hash != h || s.key != key {
+		return n, false
+	}
+	if len(n.slots) == 1 {
+		return nil, true
+	}
+	return &hamtNode[K, V]{
+		bitmap: n.bitmap &^ bit,
+		slots:  slices.Delete(slices.Clone(n.slots), i, i+1),
+	}, true
+}
+
+// all returns an iterator over the entries in the subtree rooted at n.
+func (n *hamtNode[K, V]) all() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		n.walk(yield)
+	}
+}
+
+func (n *hamtNode[K, V]) walk(yield func(K, V) bool) bool {
+	if n == nil {
+		return true
+	}
+	for _, s := range n.slots {
+		if s.child != nil {
+			if !s.child.walk(yield) {
+				return false
+			}
+		} else if !yield(s.key, s.value) {
+			return false
+		}
+	}
+	return true
+}
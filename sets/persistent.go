@@ -0,0 +1,181 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package sets
+
+import (
+	"fmt"
+	"iter"
+	"slices"
+)
+
+// Persistent is an immutable unordered set.
+// Methods that change the set return a new set
+// that shares most of its memory with the original,
+// so keeping old versions of a set around is cheap.
+// Lookup, insertion, and deletion take O(log n) time
+// with a large logarithm base.
+// The zero value is an empty set.
+// Persistent values are safe to use concurrently from multiple goroutines.
+type Persistent[T comparable] struct {
+	m PersistentMap[T, struct{}]
+}
+
+// NewPersistent returns a new set that contains the arguments passed to it.
+func NewPersistent[T comparable](elem ...T) Persistent[T] {
+	return Persistent[T]{}.Add(elem...)
+}
+
+// CollectPersistent returns a new set that contains the elements of the given iterator.
+func CollectPersistent[T comparable](seq iter.Seq[T]) Persistent[T] {
+	return Persistent[T]{}.AddSeq(seq)
+}
+
+// Add returns a set that contains the elements of s and the arguments.
+// If s already contains all of the arguments, then Add returns s.
+func (s Persistent[T]) Add(elem ...T) Persistent[T] {
+	return s.AddSeq(slices.Values(elem))
+}
+
+// AddSeq returns a set that contains the elements of s and the values from seq.
+// If s already contains all of the values, then AddSeq returns s.
+func (s Persistent[T]) AddSeq(seq iter.Seq[T]) Persistent[T] {
+	for x := range seq {
+		var added bool
+		s.m.root, added = s.m.root.insert(0, hashKey(x), x, struct{}{}, false)
+		if added {
+			s.m.n++
+		}
+	}
+	return s
+}
+
+// Union returns a set that contains the elements of both s and other.
+// If one set contains all the elements of the other,
+// then Union returns the larger set.
+func (s Persistent[T]) Union(other Persistent[T]) Persistent[T] {
+	if s.Len() < other.Len() {
+		s, other = other, s
+	}
+	if s.m.root == other.m.root {
+		return s
+	}
+	return s.AddSeq(other.All())
+}
+
+// Delete returns a set that contains the elements of s except x.
+// If s does not contain x, then Delete returns s.
+func (s Persistent[T]) Delete(x T) Persistent[T] {
+	s.m = s.m.Delete(x)
+	return s
+}
+
+// Has reports whether the set contains x.
+func (s Persistent[T]) Has(x T) bool {
+	_, present := s.m.Get(x)
+	return present
+}
+
+// Len returns the number of elements in the set.
+func (s Persistent[T]) Len() int {
+	return s.m.Len()
+}
+
+// Equal reports whether s and other contain the same elements.
+func (s Persistent[T]) Equal(other Persistent[T]) bool {
+	if s.Len() != other.Len() {
+		return false
+	}
+	if s.m.root == other.m.root {
+		return true
+	}
+	for x := range s.All() {
+		if !other.Has(x) {
+			return false
+		}
+	}
+	return true
+}
+
+// All returns an iterator of the elements of s.
+func (s Persistent[T]) All() iter.Seq[T] {
+	return s.m.Keys()
+}
+
+// Format implements [fmt.Formatter]
+// by formatting its elements according to the printer state and verb
+// surrounded by braces.
+func (s Persistent[T]) Format(f fmt.State, verb rune) {
+	format(f, verb, s.All())
+}
+
+// PersistentMap is an immutable unordered map.
+// Methods that change the map return a new map
+// that shares most of its memory with the original,
+// so keeping old versions of a map around is cheap.
+// The zero value is an empty map.
+// PersistentMap values are safe to use concurrently from multiple goroutines.
+type PersistentMap[K comparable, V any] struct {
+	root *hamtNode[K, V]
+	n    int
+}
+
+// Get returns the value associated with key
+// and whether the key is present in the map.
+func (m PersistentMap[K, V]) Get(key K) (_ V, found bool) {
+	return m.root.get(0, hashKey(key), key)
+}
+
+// Set returns a map that contains the entries of m
+// with key associated to value.
+func (m PersistentMap[K, V]) Set(key K, value V) PersistentMap[K, V] {
+	var added bool
+	m.root, added = m.root.insert(0, hashKey(key), key, value, true)
+	if added {
+		m.n++
+	}
+	return m
+}
+
+// Delete returns a map that contains the entries of m except for key.
+// If m does not contain key, then Delete returns m.
+func (m PersistentMap[K, V]) Delete(key K) PersistentMap[K, V] {
+	var removed bool
+	m.root, removed = m.root.delete(0, hashKey(key), key)
+	if removed {
+		m.n--
+	}
+	return m
+}
+
+// Len returns the number of entries in the map.
+func (m PersistentMap[K, V]) Len() int {
+	return m.n
+}
+
+// All returns an iterator of the entries in m.
+func (m PersistentMap[K, V]) All() iter.Seq2[K, V] {
+	return m.root.all()
+}
+
+// Keys returns an iterator of the keys in m.
+func (m PersistentMap[K, V]) Keys() iter.Seq[K] {
+	return func(yield func(K) bool) {
+		for k := range m.root.all() {
+			if !yield(k) {
+				return
+			}
+		}
+	}
+}
+
+// Values returns an iterator of the values in m.
+func (m PersistentMap[K, V]) Values() iter.Seq[V] {
+	return func(yield func(V) bool) {
+		for _, v := range m.root.all() {
+			if !yield(v) {
+				return
+			}
+		}
+	}
+}
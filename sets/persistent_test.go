@@ -0,0 +1,200 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package sets
+
+import (
+	"maps"
+	"math/rand/v2"
+	"slices"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestPersistent(t *testing.T) {
+	check := func(t *testing.T, s Persistent[uint], want []uint) {
+		t.Helper()
+
+		if got := s.Len(); got != len(want) {
+			t.Errorf("s.Len() = %d; want %d", got, len(want))
+		}
+		for _, x := range want {
+			if !s.Has(x) {
+				t.Errorf("s.Has(%d) = false; want true", x)
+			}
+		}
+		if diff := cmp.Diff(want, slices.Collect(s.All()), cmpopts.EquateEmpty(), sortUintSlices); diff != "" {
+			t.Errorf("slices.Collect(s.All()) (-want +got):\n%s", diff)
+		}
+	}
+
+	t.Run("Empty", func(t *testing.T) {
+		var s Persistent[uint]
+		check(t, s, []uint{})
+		if s.Has(123) {
+			t.Error("s.Has(123) = true; want false")
+		}
+		check(t, s.Delete(123), []uint{})
+	})
+
+	t.Run("Add", func(t *testing.T) {
+		s1 := NewPersistent[uint](10)
+		s2 := s1.Add(123, 100)
+		s3 := s2.Add(10)
+
+		check(t, s1, []uint{10})
+		check(t, s2, []uint{10, 100, 123})
+		check(t, s3, []uint{10, 100, 123})
+		if s2.m.root != s3.m.root {
+			t.Error("adding an existing element copied the set")
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		s1 := NewPersistent[uint](10, 123)
+		s2 := s1.Delete(123)
+		s3 := s2.Delete(456)
+
+		check(t, s1, []uint{10, 123})
+		check(t, s2, []uint{10})
+		check(t, s3, []uint{10})
+		if s2.m.root != s3.m.root {
+			t.Error("deleting a missing element copied the set")
+		}
+	})
+
+	t.Run("Union", func(t *testing.T) {
+		s1 := NewPersistent[uint](1, 2, 3)
+		s2 := NewPersistent[uint](3, 4)
+		u := s1.Union(s2)
+
+		check(t, u, []uint{1, 2, 3, 4})
+		check(t, s1, []uint{1, 2, 3})
+		check(t, s2, []uint{3, 4})
+
+		sub := s1.Delete(2)
+		if got := sub.Union(s1); got.m.root != s1.m.root {
+			t.Error("union with a superset copied the superset")
+		}
+	})
+
+	t.Run("Equal", func(t *testing.T) {
+		tests := []struct {
+			s1, s2 Persistent[uint]
+			want   bool
+		}{
+			{Persistent[uint]{}, Persistent[uint]{}, true},
+			{NewPersistent[uint](1, 2), NewPersistent[uint](2, 1), true},
+			{NewPersistent[uint](1, 2), NewPersistent[uint](1, 2, 3).Delete(3), true},
+			{NewPersistent[uint](1, 2), NewPersistent[uint](1), false},
+			{NewPersistent[uint](1, 2), NewPersistent[uint](1, 3), false},
+		}
+		for _, test := range tests {
+			if got := test.s1.Equal(test.s2); got != test.want {
+				t.Errorf("%v.Equal(%v) = %t; want %t", test.s1, test.s2, got, test.want)
+			}
+		}
+	})
+
+	t.Run("Random", func(t *testing.T) {
+		rng := rand.New(rand.NewPCG(1, 2))
+		want := make(Set[uint])
+		var s Persistent[uint]
+		var history []Persistent[uint]
+		var wantHistory [][]uint
+		for range 5000 {
+			x := rng.UintN(2000)
+			if rng.IntN(3) == 0 {
+				want.Delete(x)
+				s = s.Delete(x)
+			} else {
+				want.Add(x)
+				s = s.Add(x)
+			}
+			if rng.IntN(100) == 0 {
+				history = append(history, s)
+				wantHistory = append(wantHistory, slices.Collect(want.All()))
+			}
+		}
+		check(t, s, slices.Collect(want.All()))
+		for i, old := range history {
+			check(t, old, wantHistory[i])
+		}
+	})
+}
+
+func TestPersistentMap(t *testing.T) {
+	m1 := PersistentMap[string, int]{}.Set("a", 1).Set("b", 2)
+	m2 := m1.Set("a", 3).Set("c", 4)
+	m3 := m2.Delete("b")
+
+	tests := []struct {
+		name string
+		m    PersistentMap[string, int]
+		want map[string]int
+	}{
+		{"m1", m1, map[string]int{"a": 1, "b": 2}},
+		{"m2", m2, map[string]int{"a": 3, "b": 2, "c": 4}},
+		{"m3", m3, map[string]int{"a": 3, "c": 4}},
+	}
+	for _, test := range tests {
+		if got := test.m.Len(); got != len(test.want) {
+			t.Errorf("%s.Len() = %d; want %d", test.name, got, len(test.want))
+		}
+		if diff := cmp.Diff(test.want, maps.Collect(test.m.All())); diff != "" {
+			t.Errorf("maps.Collect(%s.All()) (-want +got):\n%s", test.name, diff)
+		}
+		for k, v := range test.want {
+			if got, ok := test.m.Get(k); got != v || !ok {
+				t.Errorf("%s.Get(%q) = %d, %t; want %d, true", test.name, k, got, ok, v)
+			}
+		}
+		if got, ok := test.m.Get("z"); ok {
+			t.Errorf("%s.Get(\"z\") = %d, true; want _, false", test.name, got)
+		}
+	}
+}
+
+func TestHAMTCollisions(t *testing.T) {
+	// Use hashes that share every bit so that the trie bottoms out.
+	const h = 0xdeadbeef
+	var root *hamtNode[string, int]
+	for i, k := range []string{"a", "b", "c"} {
+		var added bool
+		root, added = root.insert(0, h, k, i, false)
+		if !added {
+			t.Fatalf("insert(%q) did not add", k)
+		}
+	}
+	want := map[string]int{"a": 0, "b": 1, "c": 2}
+	if diff := cmp.Diff(want, maps.Collect(root.all())); diff != "" {
+		t.Errorf("entries (-want +got):\n%s", diff)
+	}
+	for k, v := range want {
+		if got, ok := root.get(0, h, k); got != v || !ok {
+			t.Errorf("get(%q) = %d, %t; want %d, true", k, got, ok, v)
+		}
+	}
+	if got, ok := root.get(0, h, "z"); ok {
+		t.Errorf("get(\"z\") = %d, true; want _, false", got)
+	}
+
+	var removed bool
+	root, removed = root.delete(0, h, "b")
+	if !removed {
+		t.Error("delete(\"b\") did not remove")
+	}
+	root, _ = root.delete(0, h, "a")
+	if got, ok := root.get(0, h, "c"); got != 2 || !ok {
+		t.Errorf("after deletes, get(\"c\") = %d, %t; want 2, true", got, ok)
+	}
+	if len(root.slots) != 1 || root.slots[0].child != nil {
+		t.Error("lone entry was not moved to the root")
+	}
+	root, _ = root.delete(0, h, "c")
+	if root != nil {
+		t.Errorf("root = %p after deleting all entries; want nil", root)
+	}
+}
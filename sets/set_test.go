@@ -195,3 +195,64 @@ func TestBit(t *testing.T) {
 		s.Delete(123)
 	})
 }
+
+func TestSortedRange(t *testing.T) {
+	s := NewSorted[uint](10, 20, 30, 40)
+	tests := []struct {
+		lo, hi uint
+		want   []uint
+	}{
+		{0, 100, []uint{10, 20, 30, 40}},
+		{10, 40, []uint{10, 20, 30}},
+		{11, 40, []uint{20, 30}},
+		{20, 21, []uint{20}},
+		{20, 20, []uint{}},
+		{30, 10, []uint{}},
+		{50, 100, []uint{}},
+	}
+	for _, test := range tests {
+		var got []uint
+		for i, x := range s.Range(test.lo, test.hi) {
+			if s.At(i) != x {
+				t.Errorf("s.Range(%d, %d) yielded (%d, %d), but s.At(%d) = %d", test.lo, test.hi, i, x, i, s.At(i))
+			}
+			got = append(got, x)
+		}
+		if diff := cmp.Diff(test.want, got, cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("s.Range(%d, %d) (-want +got):\n%s", test.lo, test.hi, diff)
+		}
+	}
+
+	var nilSet *Sorted[uint]
+	for i, x := range nilSet.Range(0, 100) {
+		t.Errorf("nil.Range(0, 100) yielded (%d, %d)", i, x)
+	}
+}
+
+func TestWithPrefix(t *testing.T) {
+	s := NewSorted("a", "ab", "abc", "abd", "b", "ba")
+	tests := []struct {
+		prefix string
+		want   []string
+	}{
+		{"", []string{"a", "ab", "abc", "abd", "b", "ba"}},
+		{"a", []string{"a", "ab", "abc", "abd"}},
+		{"ab", []string{"ab", "abc", "abd"}},
+		{"abc", []string{"abc"}},
+		{"b", []string{"b", "ba"}},
+		{"c", []string{}},
+		{"aa", []string{}},
+	}
+	for _, test := range tests {
+		var got []string
+		for i, x := range WithPrefix(s, test.prefix) {
+			if s.At(i) != x {
+				t.Errorf("WithPrefix(s, %q) yielded (%d, %q), but s.At(%d) = %q", test.prefix, i, x, i, s.At(i))
+			}
+			got = append(got, x)
+		}
+		if diff := cmp.Diff(test.want, got, cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("WithPrefix(s, %q) (-want +got):\n%s", test.prefix, diff)
+		}
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"iter"
 	"slices"
+	"strings"
 )
 
 // Sorted is a sorted list of unique items.
@@ -113,6 +114,42 @@ func (s *Sorted[T]) Values() iter.Seq[T] {
 	}
 }
 
+// Search returns the index of the smallest element in s
+// that is greater than or equal to x.
+// If there is no such element, Search returns s.Len().
+func (s *Sorted[T]) Search(x T) int {
+	if s == nil {
+		return 0
+	}
+	i, _ := slices.BinarySearch(s.elems, x)
+	return i
+}
+
+// Range returns an iterator of the indices and elements of s
+// that are greater than or equal to lo and less than hi
+// in ascending order.
+func (s *Sorted[T]) Range(lo, hi T) iter.Seq2[int, T] {
+	return func(yield func(int, T) bool) {
+		for i := s.Search(lo); i < s.Len() && cmp.Less(s.At(i), hi); i++ {
+			if !yield(i, s.At(i)) {
+				return
+			}
+		}
+	}
+}
+
+// WithPrefix returns an iterator of the indices and elements of s
+// that begin with prefix in ascending order.
+func WithPrefix[T ~string](s *Sorted[T], prefix string) iter.Seq2[int, T] {
+	return func(yield func(int, T) bool) {
+		for i := s.Search(T(prefix)); i < s.Len() && strings.HasPrefix(string(s.At(i)), prefix); i++ {
+			if !yield(i, s.At(i)) {
+				return
+			}
+		}
+	}
+}
+
 // Delete removes x from the set if present.
 func (s *Sorted[T]) Delete(x T) {
 	if s == nil {
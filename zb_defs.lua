@@ -16,11 +16,47 @@ dofile = nil
 ---@field [string] string|number|boolean|derivation|(string|number|boolean|derivation)[]
 ---@operator concat:string
 
+---@class derivationMeta
+---@field description string? Short, one-line description of the package.
+---@field license string? SPDX license expression.
+---@field homepage string? http or https URL of the package's website.
+---@field mainProgram string? Name of the program in the output's `bin` directory that runs the package.
+---@field platforms string[]? Systems that the package supports. Evaluating for another system produces a warning.
+
 ---Create a derivation (a buildable target).
----@param args { name: string, system: string, builder: string, args: string[], [string]: string|number|boolean|(string|number|boolean)[] }
+---`outputs` lists the names of the derivation's outputs (default `{"out"}`).
+---Each output is available as a field of the returned derivation,
+---and converting the derivation to a string uses the first output.
+---`checks` attaches test derivations that `zb build --with-checks` also builds:
+---either a table of derivations or a function that takes the derivation and returns one.
+---Checks are not passed to the builder and do not change the derivation.
+---`meta` describes the package for tools like `zb search` and `zb sbom`
+---and is not passed to the builder either.
+---Unknown `meta` fields produce a warning.
+---@param args { name: string, system: string, builder: string, args: string[], outputs: string[]?, checks: (table<string|integer, derivation>|fun(drv: derivation): table<string|integer, derivation>)?, meta: derivationMeta?, [string]: string|number|boolean|(string|number|boolean)[] }
 ---@return derivation
 function derivation(args) end
 
+---Raise an error with `msg` if `cond` is false or nil.
+---Unlike `assert`, the error message includes
+---the position of the call to `assertMsg`.
+---@generic T
+---@param cond T
+---@param msg string
+---@return T
+function assertMsg(cond, msg) end
+
+---Raise an error with `msg` prefixed by the position of the call to `throw`.
+---@param msg string
+function throw(msg) end
+
+---Declare a test named `name` that calls `f`.
+---A test fails if `f` raises an error (for example, with `assert`).
+---Only available in `*_test.lua` files run by `zb test`.
+---@param name string
+---@param f fun()
+function test(name, f) end
+
 --- Force a module to load.
 --- @param x (any)
 --- @return any
@@ -31,11 +67,59 @@ function await(x) end
 --- @return any
 function import(path) end
 
+---Return the string that a derivation's builder sees
+---in place of the path of one of the derivation's own outputs.
+---@param outputName string? defaults to "out"
+---@return string
+function placeholder(outputName) end
+
+---Return the string that stands in for the path of another derivation's output
+---until the output is realized.
+---Using the string in a derivation's arguments
+---adds a dependency on the output.
+---@param drv derivation
+---@param outputName string? defaults to "out"
+---@return string
+function outputPlaceholder(drv, outputName) end
+
+---@alias stringContext table<string, {path: boolean?, outputs: string[]?}>
+
+---Return the store objects and derivation outputs that a string depends on.
+---Each key is a store path.
+---`path` is true if the string depends on the store object itself
+---and `outputs` lists the outputs of a derivation that the string depends on.
+---@param s string
+---@return stringContext
+function getContext(s) end
+
+---Return a copy of s without any dependencies.
+---This is useful for strings that mention store paths
+---but should not cause them to be built, like documentation.
+---@param s string
+---@return string
+function discardContext(s) end
+
+---Return a copy of s with the given dependencies added.
+---Every store path must exist in the store.
+---@param s string
+---@param context stringContext in the format returned by getContext
+---@return string
+function addContext(s, context) end
+
 ---Make a file or directory available to a derivation.
 ---@param p (string|{path: string, name: string?, filter: (fun(name: string, type: "regular"|"directory"|"symlink"): boolean)?}) path to import, relative to the source file that called `path`
 ---@return string # store path of the copied file or directory
 function path(p) end
 
+---Make a project's source directory available to a derivation,
+---leaving out files that version control ignores.
+---Inside a git working copy, only the files that `git ls-files` lists are kept.
+---Otherwise, `.gitignore` files are read directly.
+---Paths matched by `.zbignore` files (which use the same syntax) are always left out.
+---@param p (string|{path: string, name: string?, filter: (fun(name: string, type: "regular"|"directory"|"symlink"): boolean)?}) directory to import, relative to the source file that called `sourceTree`
+---@return string # store path of the copied directory
+function sourceTree(p) end
+
 ---Adds a dependency on an existing store path.
 ---If the store object named by the path does not exist in the store,
 ---storePath raises an error.
@@ -67,6 +151,54 @@ function fetchurl(args) end
 ---@return derivation
 function extract(args) end
 
+---Create a derivation that copies src and applies the unified diffs in patches to it in order.
+---strip is the number of leading path components to remove from file names in the patches
+---(like patch -p, default 1).
+---The patches are applied inside the store without running a builder program.
+---@param args {src: string, patches: string[], name: string?, strip: integer?}
+---@return derivation
+function applyPatches(args) end
+
+---Create a derivation that copies src and replaces literal strings in it.
+---replacements is a list of {from, to} pairs.
+---If src is a directory, files lists the slash-separated paths of the files to change.
+---The build fails if a file does not contain a string to replace.
+---The replacements are performed inside the store without running a builder program.
+---@param args {src: string, replacements: string[][], files: string[]?, name: string?}
+---@return derivation
+function substitute(args) end
+
+---@alias treeEntry {text: string, executable: boolean?}|{symlink: string}|{copy: string}
+
+---Create a derivation whose output is a directory built from a description of its contents.
+---tree maps slash-separated paths to entries:
+---`{text = s, executable = b}` creates a file,
+---`{symlink = target}` creates a symbolic link,
+---and `{copy = path}` copies a store path.
+---Every file and symlink in each directory in paths is symlinked at the same location.
+---Two entries with the same path are an error unless ignoreCollisions is true,
+---in which case the first one wins (tree entries come before paths).
+---The output is created inside the store without running a builder program.
+---@param args {name: string, tree: table<string, treeEntry>?, paths: string[]?, ignoreCollisions: boolean?}
+---@return derivation
+function composeTree(args) end
+
+---Create a derivation whose output is a directory of symbolic links.
+---links maps slash-separated paths to link targets.
+---This is a convenience wrapper around composeTree.
+---@param name string
+---@param links table<string, string>
+---@return derivation
+function linkFarm(name, links) end
+
+---Create a derivation that merges the directories in paths
+---by symlinking every file in each of them at the same location in the output.
+---This is useful for profiles and development environments.
+---This is a convenience wrapper around composeTree.
+---@param args {name: string, paths: string[], ignoreCollisions: boolean?}
+---@return derivation
+function symlinkJoin(args) end
+
 ---Create a derivation that extracts an archive from a URL.
 ---This is a convenience wrapper around fetchurl and extract.
 ---@param args {url: string, hash: string, name: string?, stripFirstComponent: boolean?}
@@ -88,3 +220,102 @@ os = {}
 ---@param varname string
 ---@return string|nil
 function os.getenv(varname) end
+
+zb = {}
+
+---The version of zb evaluating the code,
+---or `nil` if the version is unknown (e.g. a development build).
+---`major`, `minor`, `patch`, and `prerelease` are only set
+---if `string` is a semantic version like "1.2.3" or "1.2.3-rc.1".
+---@type {string: string, major: integer?, minor: integer?, patch: integer?, prerelease: string?}?
+zb.version = nil
+
+---Information about the system that evaluation targets.
+---`current` is the target system triple (set with `--system`)
+---and `host` is the triple of the system running zb.
+zb.system = {
+  ---@type string
+  current = "",
+  ---@type string
+  host = "",
+}
+
+---Parse a system string into its components.
+---Raises an error if `s` is not a valid system.
+---@param s string
+---@return {arch: string, vendor: string, os: string, env: string, triple: string}
+function zb.system.parse(s) end
+
+---Report whether the system (default `zb.system.current`) runs Linux.
+---@param sys string?
+---@return boolean
+function zb.system.isLinux(sys) end
+
+---Report whether the system (default `zb.system.current`) runs a Darwin-based OS.
+---@param sys string?
+---@return boolean
+function zb.system.isDarwin(sys) end
+
+---Report whether the system (default `zb.system.current`) runs macOS.
+---@param sys string?
+---@return boolean
+function zb.system.isMacOS(sys) end
+
+---Report whether the system (default `zb.system.current`) runs Windows.
+---@param sys string?
+---@return boolean
+function zb.system.isWindows(sys) end
+
+---Report whether the system (default `zb.system.current`) has an x86 processor.
+---@param sys string?
+---@return boolean
+function zb.system.isX86(sys) end
+
+---Report whether the system (default `zb.system.current`) has an ARM processor.
+---@param sys string?
+---@return boolean
+function zb.system.isARM(sys) end
+
+---Report whether the system (default `zb.system.current`) has a RISC-V processor.
+---@param sys string?
+---@return boolean
+function zb.system.isRISCV(sys) end
+
+---Report whether the system (default `zb.system.current`) has a 32-bit processor.
+---@param sys string?
+---@return boolean
+function zb.system.is32Bit(sys) end
+
+---Report whether the system (default `zb.system.current`) has a 64-bit processor.
+---@param sys string?
+---@return boolean
+function zb.system.is64Bit(sys) end
+
+---Report whether the zb evaluating the code supports the named feature.
+---Feature names are usually the names of built-in functions (e.g. `"lazy"` or `"os.getenv"`).
+---`zb doctor` lists the supported features.
+---@param name string
+---@return boolean
+function zb.hasFeature(name) end
+
+---Emit a warning without stopping evaluation,
+---for example to deprecate an attribute.
+---Warnings are reported once per `key`, which defaults to `msg`.
+---`zb` prints them on standard error unless `--quiet` is passed.
+---@param msg string
+---@param key string?
+function zb.warn(msg, key) end
+
+---@alias optionType "any"|"boolean"|"derivation"|"function"|"integer"|"number"|"string"|"table"
+
+---Validate and merge tables of options.
+---`schema` maps each option name to a declaration.
+---The remaining arguments are tables of option values (or `nil`)
+---that are merged from left to right into a new table.
+---Values of options with type `"table"` are merged field by field.
+---Unknown options, values of the wrong type,
+---and missing options without a default raise an error.
+---@param schema table<string, {type: optionType?, default: any, optional: boolean?, description: string?}>
+---@param ... table<string, any>?
+---@return table<string, any>
+function zb.options(schema, ...) end
@@ -0,0 +1,128 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+// Package zbbuiltin allows programs that run a zb store
+// to add builders that run inside the store process.
+// A derivation uses a builtin builder
+// by setting its system to "builtin"
+// and its builder to "builtin:" followed by the builder's name
+// (e.g. "builtin:fetchurl").
+//
+// Builders are registered with [Register],
+// typically from the init function of a package
+// that is imported by the program that runs the store.
+// Builtin builders are not sandboxed,
+// so they must only read their inputs and write their outputs
+// to produce reproducible results.
+package zbbuiltin
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+
+	"zb.256lights.llc/pkg/zbstore"
+)
+
+// Func is the signature of a builtin builder.
+// If Func returns an error, the build fails
+// and the error is written to the builder's log.
+type Func func(ctx context.Context, inv *Invocation) error
+
+// Invocation is a request to run a builtin builder.
+type Invocation struct {
+	// Derivation is the derivation being built.
+	// Placeholders in its environment have been replaced with paths,
+	// so each output's path can be found in the environment variable
+	// named after the output (e.g. "out").
+	Derivation *zbstore.Derivation
+	// DerivationPath is the store path of Derivation.
+	DerivationPath zbstore.Path
+	// OutputPaths is a map of output names to the paths
+	// that the builder must create.
+	// Use [*Invocation.RealPath] to find where to write the outputs.
+	OutputPaths map[string]zbstore.Path
+	// RealStoreDirectory is the local directory where the store's objects are located.
+	// It may differ from the store's directory, Derivation.Dir.
+	RealStoreDirectory string
+	// BuildDirectory is a temporary directory created for the builder.
+	// It is removed after the builder finishes.
+	BuildDirectory string
+	// Stdout and Stderr are written to the builder's log.
+	Stdout io.Writer
+	Stderr io.Writer
+	// Cores is a hint for the number of concurrent jobs that the builder should use.
+	Cores int
+}
+
+// RealPath returns the local filesystem path of the given path
+// inside the store directory.
+// It is used to read inputs and write outputs
+// when the store's objects are located in a different directory
+// than the store directory.
+func (inv *Invocation) RealPath(path string) string {
+	if inv.RealStoreDirectory == "" {
+		return path
+	}
+	rest, ok := strings.CutPrefix(path, string(inv.Derivation.Dir))
+	if !ok {
+		return path
+	}
+	return inv.RealStoreDirectory + rest
+}
+
+// reservedNames is the set of builders that the store implements itself.
+var reservedNames = []string{"fetchurl", "extract", "patch", "substitute", "compose"}
+
+var registry struct {
+	mu    sync.RWMutex
+	funcs map[string]Func
+}
+
+// Register makes a builtin builder available
+// under the builder name "builtin:" followed by name.
+// Register panics if it is called twice with the same name,
+// if name is empty or contains a colon,
+// if name is the name of a builder built into zb,
+// or if f is nil.
+func Register(name string, f Func) {
+	if name == "" || strings.Contains(name, ":") {
+		panic(fmt.Errorf("zbbuiltin: invalid builder name %q", name))
+	}
+	if slices.Contains(reservedNames, name) {
+		panic(fmt.Errorf("zbbuiltin: builder %q is built into zb", name))
+	}
+	if f == nil {
+		panic(fmt.Errorf("zbbuiltin: Register %q with nil function", name))
+	}
+
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	if _, dup := registry.funcs[name]; dup {
+		panic(fmt.Errorf("zbbuiltin: Register called twice for %q", name))
+	}
+	if registry.funcs == nil {
+		registry.funcs = make(map[string]Func)
+	}
+	registry.funcs[name] = f
+}
+
+// Lookup returns the builder registered with the given name
+// (without the "builtin:" prefix)
+// or nil if no such builder has been registered.
+func Lookup(name string) Func {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+	return registry.funcs[name]
+}
+
+// Names returns the sorted names of the registered builders.
+func Names() []string {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+	return slices.Sorted(maps.Keys(registry.funcs))
+}
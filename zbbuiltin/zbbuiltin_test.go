@@ -0,0 +1,100 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package zbbuiltin
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"zb.256lights.llc/pkg/zbstore"
+)
+
+func TestRegister(t *testing.T) {
+	called := false
+	f := func(ctx context.Context, inv *Invocation) error {
+		called = true
+		return nil
+	}
+	Register("zbbuiltin-test", f)
+	got := Lookup("zbbuiltin-test")
+	if got == nil {
+		t.Fatal(`Lookup("zbbuiltin-test") = nil`)
+	}
+	if err := got(context.Background(), new(Invocation)); err != nil {
+		t.Error(err)
+	}
+	if !called {
+		t.Error("Lookup returned a different function")
+	}
+	if names := Names(); !slices.Contains(names, "zbbuiltin-test") {
+		t.Errorf("Names() = %q; want to contain %q", names, "zbbuiltin-test")
+	}
+	if got := Lookup("zbbuiltin-missing"); got != nil {
+		t.Error(`Lookup("zbbuiltin-missing") != nil`)
+	}
+
+	tests := []struct {
+		name string
+		f    Func
+	}{
+		{name: "zbbuiltin-test", f: f},
+		{name: "", f: f},
+		{name: "foo:bar", f: f},
+		{name: "fetchurl", f: f},
+		{name: "extract", f: f},
+		{name: "patch", f: f},
+		{name: "substitute", f: f},
+		{name: "compose", f: f},
+		{name: "zbbuiltin-nil", f: nil},
+	}
+	for _, test := range tests {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Register(%q, ...) did not panic", test.name)
+				}
+			}()
+			Register(test.name, test.f)
+		}()
+	}
+}
+
+func TestRealPath(t *testing.T) {
+	tests := []struct {
+		storeDir zbstore.Directory
+		realDir  string
+		path     string
+		want     string
+	}{
+		{
+			storeDir: "/zb/store",
+			realDir:  "",
+			path:     "/zb/store/ffffffffffffffffffffffffffffffff-foo",
+			want:     "/zb/store/ffffffffffffffffffffffffffffffff-foo",
+		},
+		{
+			storeDir: "/zb/store",
+			realDir:  "/var/lib/zb/store",
+			path:     "/zb/store/ffffffffffffffffffffffffffffffff-foo/bin",
+			want:     "/var/lib/zb/store/ffffffffffffffffffffffffffffffff-foo/bin",
+		},
+		{
+			storeDir: "/zb/store",
+			realDir:  "/var/lib/zb/store",
+			path:     "/tmp/foo",
+			want:     "/tmp/foo",
+		},
+	}
+	for _, test := range tests {
+		inv := &Invocation{
+			Derivation:         &zbstore.Derivation{Dir: test.storeDir},
+			RealStoreDirectory: test.realDir,
+		}
+		if got := inv.RealPath(test.path); got != test.want {
+			t.Errorf("(&Invocation{Dir: %q, RealStoreDirectory: %q}).RealPath(%q) = %q; want %q",
+				test.storeDir, test.realDir, test.path, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package zbstore
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	jsonv2 "github.com/go-json-experiment/json"
+	"zombiezen.com/go/nix"
+)
+
+// Identifiers used in attestations.
+const (
+	// InTotoStatementType is the value of [AttestationStatement.Type]
+	// for in-toto v1 statements.
+	InTotoStatementType = "https://in-toto.io/Statement/v1"
+	// InTotoPayloadType is the value of [AttestationEnvelope.PayloadType]
+	// for envelopes containing an in-toto statement.
+	InTotoPayloadType = "application/vnd.in-toto+json"
+	// SLSAProvenancePredicateType is the value of [AttestationStatement.PredicateType]
+	// for [SLSA provenance] predicates.
+	//
+	// [SLSA provenance]: https://slsa.dev/spec/v1.0/provenance
+	SLSAProvenancePredicateType = "https://slsa.dev/provenance/v1"
+	// SLSABuildType is the value of [SLSABuildDefinition.BuildType]
+	// for a derivation built by a zb store.
+	SLSABuildType = "https://zb.256lights.llc/derivations"
+)
+
+// An AttestationStatement is an [in-toto statement]
+// that makes a claim about how one or more store objects were produced.
+//
+// [in-toto statement]: https://github.com/in-toto/attestation/blob/main/spec/v1/statement.md
+type AttestationStatement struct {
+	Type          string                `json:"_type"`
+	Subject       []*ResourceDescriptor `json:"subject"`
+	PredicateType string                `json:"predicateType"`
+	Predicate     *SLSAProvenance       `json:"predicate"`
+}
+
+// A ResourceDescriptor identifies an artifact in an [AttestationStatement].
+// For store objects, Name is the store path
+// and Digest is the hash of the store object's NAR serialization.
+type ResourceDescriptor struct {
+	Name   string            `json:"name,omitzero"`
+	URI    string            `json:"uri,omitzero"`
+	Digest map[string]string `json:"digest,omitzero"`
+}
+
+// NewObjectResourceDescriptor returns a [ResourceDescriptor]
+// for the store object at path with the given NAR hash.
+func NewObjectResourceDescriptor(path Path, narHash nix.Hash) *ResourceDescriptor {
+	return &ResourceDescriptor{
+		Name: string(path),
+		Digest: map[string]string{
+			narHash.Type().String(): narHash.RawBase16(),
+		},
+	}
+}
+
+// MatchesNARHash reports whether rd's digest for narHash's algorithm
+// is equal to narHash.
+func (rd *ResourceDescriptor) MatchesNARHash(narHash nix.Hash) bool {
+	d, ok := rd.Digest[narHash.Type().String()]
+	return ok && strings.EqualFold(d, narHash.RawBase16())
+}
+
+// SLSAProvenance is the predicate of a [SLSA provenance] statement.
+//
+// [SLSA provenance]: https://slsa.dev/spec/v1.0/provenance
+type SLSAProvenance struct {
+	BuildDefinition SLSABuildDefinition `json:"buildDefinition"`
+	RunDetails      SLSARunDetails      `json:"runDetails"`
+}
+
+// SLSABuildDefinition describes the inputs to a build in [SLSAProvenance].
+type SLSABuildDefinition struct {
+	BuildType          string                  `json:"buildType"`
+	ExternalParameters *SLSAExternalParameters `json:"externalParameters"`
+	InternalParameters *SLSAInternalParameters `json:"internalParameters,omitzero"`
+	// ResolvedDependencies is the set of store objects
+	// that were available to the builder.
+	ResolvedDependencies []*ResourceDescriptor `json:"resolvedDependencies,omitzero"`
+}
+
+// SLSAExternalParameters is the set of parameters for a [SLSABuildType] build
+// that are under the control of the user requesting the build.
+type SLSAExternalParameters struct {
+	DerivationPath Path     `json:"derivationPath"`
+	DerivationHash nix.Hash `json:"derivationHash"`
+	OutputName     string   `json:"outputName"`
+}
+
+// SLSAInternalParameters is the set of parameters for a [SLSABuildType] build
+// that are under the control of the builder.
+type SLSAInternalParameters struct {
+	System    string `json:"system,omitzero"`
+	Sandboxed bool   `json:"sandboxed"`
+}
+
+// SLSARunDetails describes a particular execution of a build in [SLSAProvenance].
+type SLSARunDetails struct {
+	Builder  SLSABuilder        `json:"builder"`
+	Metadata *SLSABuildMetadata `json:"metadata,omitzero"`
+}
+
+// SLSABuilder identifies the entity that executed a build.
+type SLSABuilder struct {
+	ID string `json:"id"`
+}
+
+// SLSABuildMetadata is the metadata about a build execution.
+type SLSABuildMetadata struct {
+	InvocationID string    `json:"invocationId,omitzero"`
+	StartedOn    time.Time `json:"startedOn,omitzero"`
+	FinishedOn   time.Time `json:"finishedOn,omitzero"`
+}
+
+// An AttestationEnvelope is a [DSSE envelope]
+// that holds a signed [AttestationStatement].
+//
+// [DSSE envelope]: https://github.com/secure-systems-lab/dsse/blob/master/envelope.md
+type AttestationEnvelope struct {
+	PayloadType string                  `json:"payloadType"`
+	Payload     []byte                  `json:"payload,format:base64"`
+	Signatures  []*AttestationSignature `json:"signatures"`
+}
+
+// An AttestationSignature is a signature in an [AttestationEnvelope].
+// KeyID is the public key's format and base64-encoded data separated by a colon
+// (e.g. "ed25519:...").
+type AttestationSignature struct {
+	KeyID string `json:"keyid"`
+	Sig   []byte `json:"sig,format:base64"`
+}
+
+// NewAttestationEnvelope returns a new unsigned envelope containing stmt.
+func NewAttestationEnvelope(stmt *AttestationStatement) (*AttestationEnvelope, error) {
+	payload, err := jsonv2.Marshal(stmt)
+	if err != nil {
+		return nil, fmt.Errorf("marshal attestation: %v", err)
+	}
+	return &AttestationEnvelope{
+		PayloadType: InTotoPayloadType,
+		Payload:     payload,
+		Signatures:  []*AttestationSignature{},
+	}, nil
+}
+
+// Statement parses the statement in env's payload.
+// Statement does not verify env's signatures.
+func (env *AttestationEnvelope) Statement() (*AttestationStatement, error) {
+	if env.PayloadType != InTotoPayloadType {
+		return nil, fmt.Errorf("parse attestation: unsupported payload type %q", env.PayloadType)
+	}
+	stmt := new(AttestationStatement)
+	if err := jsonv2.Unmarshal(env.Payload, stmt, jsonv2.RejectUnknownMembers(false)); err != nil {
+		return nil, fmt.Errorf("parse attestation: %v", err)
+	}
+	if stmt.Type != InTotoStatementType {
+		return nil, fmt.Errorf("parse attestation: unsupported statement type %q", stmt.Type)
+	}
+	return stmt, nil
+}
+
+// SignEd25519 adds a signature to env using the given private key.
+func (env *AttestationEnvelope) SignEd25519(key ed25519.PrivateKey) {
+	env.Signatures = append(env.Signatures, &AttestationSignature{
+		KeyID: attestationKeyID(&RealizationPublicKey{
+			Format: Ed25519SignatureFormat,
+			Data:   key.Public().(ed25519.PublicKey),
+		}),
+		Sig: ed25519.Sign(key, env.preAuthEncoding()),
+	})
+}
+
+// Verify verifies all of env's signatures
+// and returns the public keys that signed env.
+// Verify returns an error if env has no signatures
+// or any signature is invalid.
+func (env *AttestationEnvelope) Verify() ([]*RealizationPublicKey, error) {
+	if len(env.Signatures) == 0 {
+		return nil, errors.New("verify attestation: no signatures")
+	}
+	msg := env.preAuthEncoding()
+	keys := make([]*RealizationPublicKey, 0, len(env.Signatures))
+	for _, sig := range env.Signatures {
+		pub, err := parseAttestationKeyID(sig.KeyID)
+		if err != nil {
+			return nil, fmt.Errorf("verify attestation: %v", err)
+		}
+		switch pub.Format {
+		case Ed25519SignatureFormat:
+			if got, want := len(pub.Data), ed25519.PublicKeySize; got != want {
+				return nil, fmt.Errorf("verify attestation: ed25519 public key is the wrong size (%d instead of %d bytes)", got, want)
+			}
+			if !ed25519.Verify(ed25519.PublicKey(pub.Data), msg, sig.Sig) {
+				return nil, fmt.Errorf("verify attestation: signature by %s does not match", sig.KeyID)
+			}
+		default:
+			return nil, fmt.Errorf("verify attestation: unsupported format %q", pub.Format)
+		}
+		keys = append(keys, pub)
+	}
+	return keys, nil
+}
+
+// preAuthEncoding returns the [DSSE pre-authentication encoding] of env,
+// which is the message that is signed.
+//
+// [DSSE pre-authentication encoding]: https://github.com/secure-systems-lab/dsse/blob/master/protocol.md
+func (env *AttestationEnvelope) preAuthEncoding() []byte {
+	var buf []byte
+	buf = append(buf, "DSSEv1 "...)
+	buf = strconv.AppendInt(buf, int64(len(env.PayloadType)), 10)
+	buf = append(buf, ' ')
+	buf = append(buf, env.PayloadType...)
+	buf = append(buf, ' ')
+	buf = strconv.AppendInt(buf, int64(len(env.Payload)), 10)
+	buf = append(buf, ' ')
+	buf = append(buf, env.Payload...)
+	return buf
+}
+
+func attestationKeyID(pub *RealizationPublicKey) string {
+	return string(pub.Format) + ":" + base64.StdEncoding.EncodeToString(pub.Data)
+}
+
+func parseAttestationKeyID(keyID string) (*RealizationPublicKey, error) {
+	format, data, ok := strings.Cut(keyID, ":")
+	if !ok {
+		return nil, fmt.Errorf("invalid key ID %q", keyID)
+	}
+	pub := &RealizationPublicKey{Format: RealizationSignatureFormat(format)}
+	var err error
+	pub.Data, err = base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key ID %q: %v", keyID, err)
+	}
+	return pub, nil
+}
@@ -0,0 +1,78 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package zbstore
+
+import (
+	"crypto/ed25519"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestAttestationEnvelope(t *testing.T) {
+	testKey := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	narHash := mustParseHash(t, "sha256-AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=")
+	stmt := &AttestationStatement{
+		Type: InTotoStatementType,
+		Subject: []*ResourceDescriptor{
+			NewObjectResourceDescriptor("/opt/zb/store/aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa-foo", narHash),
+		},
+		PredicateType: SLSAProvenancePredicateType,
+		Predicate: &SLSAProvenance{
+			BuildDefinition: SLSABuildDefinition{
+				BuildType: SLSABuildType,
+				ExternalParameters: &SLSAExternalParameters{
+					DerivationPath: "/opt/zb/store/bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb-foo.drv",
+					DerivationHash: narHash,
+					OutputName:     "out",
+				},
+			},
+			RunDetails: SLSARunDetails{
+				Builder: SLSABuilder{ID: "https://example.com/builder"},
+				Metadata: &SLSABuildMetadata{
+					InvocationID: "123",
+					StartedOn:    time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC),
+					FinishedOn:   time.Date(2026, time.January, 1, 0, 1, 0, 0, time.UTC),
+				},
+			},
+		},
+	}
+
+	env, err := NewAttestationEnvelope(stmt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := env.Verify(); err == nil {
+		t.Error("Verify() on unsigned envelope did not return an error")
+	}
+	env.SignEd25519(testKey)
+
+	gotKeys, err := env.Verify()
+	if err != nil {
+		t.Fatal("Verify:", err)
+	}
+	wantKeys := []*RealizationPublicKey{{
+		Format: Ed25519SignatureFormat,
+		Data:   testKey.Public().(ed25519.PublicKey),
+	}}
+	if diff := cmp.Diff(wantKeys, gotKeys); diff != "" {
+		t.Errorf("Verify() keys (-want +got):\n%s", diff)
+	}
+	gotStmt, err := env.Statement()
+	if err != nil {
+		t.Fatal("Statement:", err)
+	}
+	if diff := cmp.Diff(stmt, gotStmt); diff != "" {
+		t.Errorf("Statement() (-want +got):\n%s", diff)
+	}
+	if !gotStmt.Subject[0].MatchesNARHash(narHash) {
+		t.Errorf("Subject[0].MatchesNARHash(%v) = false; want true", narHash)
+	}
+
+	env.Payload[len(env.Payload)-2] ^= 1
+	if _, err := env.Verify(); err == nil {
+		t.Error("Verify() on tampered envelope did not return an error")
+	}
+}
@@ -586,6 +586,20 @@ func RecursiveFileFloatingCAOutput(hashAlgo nix.HashType) *DerivationOutputType
 	}
 }
 
+// TextFloatingCAOutput returns a [DerivationOutputType]
+// that must be a single non-executable file
+// and will be hashed as text with the given algorithm.
+// Unlike [FlatFileFloatingCAOutput], the output may reference other store objects,
+// but it may not reference itself.
+// The hash will not be known until the derivation is realized.
+func TextFloatingCAOutput(hashAlgo nix.HashType) *DerivationOutputType {
+	return &DerivationOutputType{
+		typ:      floatingCAOutputType,
+		method:   textIngestionMethod,
+		hashAlgo: hashAlgo,
+	}
+}
+
 // IsFixed reports whether the output was created by [FixedCAOutput].
 func (t *DerivationOutputType) IsFixed() bool {
 	if t == nil {
@@ -597,7 +611,7 @@ func (t *DerivationOutputType) IsFixed() bool {
 // IsFloating reports whether the output's content hash cannot be known
 // until the derivation is realized.
 // This is true for outputs returned by
-// [FlatFileFloatingCAOutput] and [RecursiveFileFloatingCAOutput].
+// [FlatFileFloatingCAOutput], [RecursiveFileFloatingCAOutput], and [TextFloatingCAOutput].
 func (t *DerivationOutputType) IsFloating() bool {
 	if t == nil {
 		return false
@@ -639,6 +653,19 @@ func (t *DerivationOutputType) IsRecursiveFile() bool {
 	}
 }
 
+// IsText reports whether the derivation output
+// is hashed as text.
+func (t *DerivationOutputType) IsText() bool {
+	switch {
+	case t.IsFixed():
+		return t.ca.IsText()
+	case t.IsFloating():
+		return t.method == textIngestionMethod
+	default:
+		return false
+	}
+}
+
 func (t *DerivationOutputType) marshalText(dst []byte, storeDir Directory, drvName, outName string) ([]byte, error) {
 	dst = append(dst, '(')
 	dst = aterm.AppendString(dst, outName)
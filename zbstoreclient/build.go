@@ -0,0 +1,148 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package zbstoreclient
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"zb.256lights.llc/pkg/internal/jsonrpc"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+)
+
+// DefaultPollInterval is the default value of [WaitOptions.PollInterval].
+const DefaultPollInterval = 250 * time.Millisecond
+
+// Realize asks the store to realize the derivations in req
+// and returns the ID of the new build.
+// Realize returns once the store has started the build:
+// use [*Client.WaitForBuild] to wait for the build to finish.
+func (c *Client) Realize(ctx context.Context, req *RealizeRequest) (buildID string, err error) {
+	resp := new(zbstorerpc.RealizeResponse)
+	if err := jsonrpc.Do(ctx, c.rpc, zbstorerpc.RealizeMethod, resp, req); err != nil {
+		return "", fmt.Errorf("realize: %w", err)
+	}
+	if resp.BuildID == "" {
+		return "", fmt.Errorf("realize: store did not return a build ID")
+	}
+	return resp.BuildID, nil
+}
+
+// GetBuild returns the current state of the build with the given ID.
+// It returns an error if the store does not know about the build.
+func (c *Client) GetBuild(ctx context.Context, buildID string) (*Build, error) {
+	resp := new(Build)
+	err := jsonrpc.Do(ctx, c.rpc, zbstorerpc.GetBuildMethod, resp, &zbstorerpc.GetBuildRequest{
+		BuildID: buildID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get build %s: %w", buildID, err)
+	}
+	if resp.Status == BuildUnknown {
+		return nil, fmt.Errorf("get build %s: not found in store", buildID)
+	}
+	return resp, nil
+}
+
+// CancelBuild informs the store that the client is no longer interested
+// in the build with the given ID.
+// The store may continue building derivations that other builds depend on.
+func (c *Client) CancelBuild(ctx context.Context, buildID string) error {
+	err := jsonrpc.Notify(ctx, c.rpc, zbstorerpc.CancelBuildMethod, &zbstorerpc.CancelBuildNotification{
+		BuildID: buildID,
+	})
+	if err != nil {
+		return fmt.Errorf("cancel build %s: %w", buildID, err)
+	}
+	return nil
+}
+
+// WaitOptions is the set of optional parameters to [*Client.WaitForBuild].
+type WaitOptions struct {
+	// PollInterval is the amount of time between requests for the build's state.
+	// If PollInterval is not positive, then [DefaultPollInterval] is used.
+	PollInterval time.Duration
+	// Progress is called with each state of the build that the store reports,
+	// including the final state.
+	Progress func(*Build)
+	// If CancelOnExit is true and WaitForBuild returns before the build finishes
+	// (for example, because ctx was canceled),
+	// then WaitForBuild cancels the build with [*Client.CancelBuild].
+	CancelOnExit bool
+}
+
+// WaitForBuild waits until the build with the given ID finishes
+// and returns its final state.
+// opts may be nil, in which case it is treated the same as the zero value.
+// If the build did not succeed,
+// WaitForBuild returns the build's final state along with a [*StatusError].
+func (c *Client) WaitForBuild(ctx context.Context, buildID string, opts *WaitOptions) (_ *Build, err error) {
+	if opts == nil {
+		opts = new(WaitOptions)
+	}
+	if opts.CancelOnExit {
+		defer func() {
+			if err == nil || isStatusError(err) {
+				// Build finished.
+				return
+			}
+			cancelCtx, cleanupCtx := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+			defer cleanupCtx()
+			// The caller is more interested in the error that stopped the wait.
+			_ = c.CancelBuild(cancelCtx, buildID)
+		}()
+	}
+
+	pollInterval := opts.PollInterval
+	if pollInterval <= 0 {
+		pollInterval = DefaultPollInterval
+	}
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		build, err := c.GetBuild(ctx, buildID)
+		if err != nil {
+			return nil, fmt.Errorf("wait for build %s: %w", buildID, err)
+		}
+		if opts.Progress != nil {
+			opts.Progress(build)
+		}
+		if build.Status.IsFinished() {
+			if build.Status != BuildSuccess {
+				return build, &StatusError{BuildID: buildID, Status: build.Status}
+			}
+			return build, nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil, fmt.Errorf("wait for build %s: %w", buildID, ctx.Err())
+		}
+	}
+}
+
+// StatusError is the error returned by [*Client.WaitForBuild]
+// for a build that finished without succeeding.
+type StatusError struct {
+	BuildID string
+	Status  BuildStatus
+}
+
+// Error returns a description of the build's status.
+func (e *StatusError) Error() string {
+	switch e.Status {
+	case BuildFail:
+		return fmt.Sprintf("build %s failed", e.BuildID)
+	case BuildError:
+		return fmt.Sprintf("build %s encountered an internal error", e.BuildID)
+	default:
+		return fmt.Sprintf("build %s finished with status %q", e.BuildID, e.Status)
+	}
+}
+
+func isStatusError(err error) bool {
+	_, ok := err.(*StatusError)
+	return ok
+}
@@ -0,0 +1,100 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package zbstoreclient
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"zb.256lights.llc/pkg/internal/jsonrpc"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+	"zb.256lights.llc/pkg/zbstore"
+)
+
+// ReadLog reads the builder log of the given derivation in a build
+// starting at the given byte offset.
+// If the derivation is still building and has not written past offset,
+// ReadLog blocks until more of the log is available.
+// ReadLog returns [io.EOF] along with the last part of the log
+// once the derivation has finished building.
+//
+// If the store has the "logTimestamps" capability,
+// each line of the log starts with a prefix
+// that can be parsed with [CutLogPrefix].
+func (c *Client) ReadLog(ctx context.Context, buildID string, drvPath zbstore.Path, offset int64) ([]byte, error) {
+	resp := new(zbstorerpc.ReadLogResponse)
+	err := jsonrpc.Do(ctx, c.rpc, zbstorerpc.ReadLogMethod, resp, &zbstorerpc.ReadLogRequest{
+		BuildID:    buildID,
+		DrvPath:    drvPath,
+		RangeStart: offset,
+		Prefixed:   true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("read log for %s in build %s: %w", drvPath, buildID, err)
+	}
+	payload, err := resp.Payload()
+	if err != nil {
+		return nil, fmt.Errorf("read log for %s in build %s: %v", drvPath, buildID, err)
+	}
+	if resp.EOF {
+		return payload, io.EOF
+	}
+	return payload, nil
+}
+
+// LogReader is an [io.Reader] that streams a builder log from a store.
+// Reads block until more of the log is available
+// or the derivation finishes building.
+type LogReader struct {
+	ctx     context.Context
+	client  *Client
+	buildID string
+	drvPath zbstore.Path
+
+	off int64
+	buf []byte
+	err error
+}
+
+// NewLogReader returns a [LogReader] that reads the builder log
+// of the given derivation in a build from the beginning.
+// ctx is used for the requests that the reader makes.
+func (c *Client) NewLogReader(ctx context.Context, buildID string, drvPath zbstore.Path) *LogReader {
+	return &LogReader{
+		ctx:     ctx,
+		client:  c,
+		buildID: buildID,
+		drvPath: drvPath,
+	}
+}
+
+// Read reads the next bytes of the log into p.
+func (r *LogReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.buf, r.err = r.client.ReadLog(r.ctx, r.buildID, r.drvPath, r.off)
+		r.off += int64(len(r.buf))
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// CutLogPrefix splits a line of a builder log read from a store
+// with the "logTimestamps" capability into its prefix and the rest of the line.
+// If the line does not start with a prefix,
+// CutLogPrefix returns the line unchanged and ok is false.
+func CutLogPrefix(line []byte) (prefix LogPrefix, rest []byte, ok bool) {
+	return zbstorerpc.CutLogPrefix(line)
+}
+
+// StripLogPrefixes appends the lines of a builder log in src to dst
+// with their prefixes removed
+// and returns the resulting slice.
+func StripLogPrefixes(dst, src []byte) []byte {
+	return zbstorerpc.StripLogPrefixes(dst, src)
+}
@@ -0,0 +1,238 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+// Package zbstoreclient provides a Go client for a zb store
+// that speaks the [zb store RPC protocol].
+// It is intended for tools that integrate with a store,
+// like CI plugins or dashboards.
+//
+// [zb store RPC protocol]: https://github.com/256lights/zb/blob/main/internal/zbstorerpc/README.md
+package zbstoreclient
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"zb.256lights.llc/pkg/internal/jsonrpc"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+	"zb.256lights.llc/pkg/sets"
+	"zb.256lights.llc/pkg/zbstore"
+)
+
+// Types used in requests and responses.
+type (
+	// HandshakeResponse describes the store's protocol version and capabilities.
+	HandshakeResponse = zbstorerpc.HandshakeResponse
+	// Capability is the name of an optional feature of the store protocol.
+	Capability = zbstorerpc.Capability
+	// ObjectInfo is information about a store object.
+	ObjectInfo = zbstorerpc.ObjectInfo
+	// RealizeRequest is the set of parameters for [*Client.Realize].
+	RealizeRequest = zbstorerpc.RealizeRequest
+	// ReusePolicy is the set of realizations that a build can reuse.
+	ReusePolicy = zbstorerpc.ReusePolicy
+	// Build is the state of a build.
+	Build = zbstorerpc.Build
+	// BuildStatus is the state of a [Build] or [BuildResult].
+	BuildStatus = zbstorerpc.BuildStatus
+	// BuildResult is the result of a single derivation in a [Build].
+	BuildResult = zbstorerpc.BuildResult
+	// BuildPhase is a span of a builder's execution.
+	BuildPhase = zbstorerpc.BuildPhase
+	// RealizeOutput is a single output of a [BuildResult].
+	RealizeOutput = zbstorerpc.RealizeOutput
+	// LogPrefix is the information at the start of a line of a builder log.
+	LogPrefix = zbstorerpc.LogPrefix
+	// LogStream is the stream that a builder wrote a line of its log to.
+	LogStream = zbstorerpc.LogStream
+)
+
+// Build states.
+const (
+	BuildUnknown = zbstorerpc.BuildUnknown
+	BuildActive  = zbstorerpc.BuildActive
+	BuildSuccess = zbstorerpc.BuildSuccess
+	BuildFail    = zbstorerpc.BuildFail
+	BuildError   = zbstorerpc.BuildError
+)
+
+// Builder log streams.
+const (
+	LogStreamStdout = zbstorerpc.LogStreamStdout
+	LogStreamStderr = zbstorerpc.LogStreamStderr
+)
+
+// DefaultMethodTimeout is the default value of [Options.MethodTimeout].
+const DefaultMethodTimeout = 1 * time.Minute
+
+// Options is the set of optional parameters to [New].
+type Options struct {
+	// Dial opens a new connection to the store.
+	// If Dial is nil, then the client connects to the Unix socket passed to [New].
+	Dial func(ctx context.Context) (net.Conn, error)
+	// MethodTimeout is the maximum amount of time
+	// that the client waits for the store to answer a request
+	// that only reads or writes metadata.
+	// Requests that can wait on builds or transfer store objects never time out.
+	// If MethodTimeout is zero, then [DefaultMethodTimeout] is used.
+	// If MethodTimeout is negative, then requests only time out
+	// if their context has a deadline.
+	MethodTimeout time.Duration
+	// Reconnected is called after the client reconnects to the store
+	// because the previous connection was lost.
+	// It is passed the handshake response from the new connection.
+	Reconnected func(ctx context.Context, resp *HandshakeResponse)
+}
+
+// Client is a connection to a zb store.
+// If the connection is lost (for example, because the store restarted),
+// the client reconnects
+// and retries any pending requests that do not modify the store.
+// Methods on Client are safe to call from multiple goroutines.
+type Client struct {
+	rpc   *jsonrpc.Client
+	store *zbstorerpc.Store
+
+	handshakeMu sync.Mutex
+	handshake   *HandshakeResponse
+}
+
+// New returns a new [Client] for the store listening on the given Unix socket.
+// opts may be nil, in which case it is treated the same as the zero value.
+// New does not wait for the connection to be established:
+// errors connecting to the store are returned from the first request.
+// The caller is responsible for calling [*Client.Close]
+// when the Client is no longer in use.
+func New(socketPath string, opts *Options) *Client {
+	if opts == nil {
+		opts = new(Options)
+	}
+	dial := opts.Dial
+	if dial == nil {
+		dial = func(ctx context.Context) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+		}
+	}
+	timeout := opts.MethodTimeout
+	if timeout == 0 {
+		timeout = DefaultMethodTimeout
+	}
+	methodTimeouts := make(map[string]time.Duration)
+	if timeout > 0 {
+		for _, method := range zbstorerpc.MetadataMethods() {
+			methodTimeouts[method] = timeout
+		}
+	}
+
+	c := new(Client)
+	di := new(zbstorerpc.DeferredImporter)
+	codecOptions := &zbstorerpc.CodecOptions{
+		Importer: di,
+		Msgpack:  true,
+	}
+	reconnected := opts.Reconnected
+	c.rpc = jsonrpc.NewClient(func(ctx context.Context) (jsonrpc.ClientCodec, error) {
+		conn, err := dial(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return zbstorerpc.NewCodec(conn, codecOptions), nil
+	}, &jsonrpc.ClientOptions{
+		MethodTimeouts: methodTimeouts,
+		Replay:         zbstorerpc.IsReadOnlyMethod,
+		Renegotiate: func(ctx context.Context, h jsonrpc.Handler) error {
+			resp, err := zbstorerpc.Handshake(ctx, h)
+			if err != nil {
+				return err
+			}
+			// The new connection may be to a different version of the store.
+			c.handshakeMu.Lock()
+			c.handshake = resp
+			c.handshakeMu.Unlock()
+			if reconnected != nil {
+				reconnected(ctx, resp)
+			}
+			return nil
+		},
+	})
+	c.store = &zbstorerpc.Store{Handler: c.rpc}
+	di.SetImporter(c.store)
+	return c
+}
+
+// Close closes the connection to the store.
+// Pending requests fail.
+func (c *Client) Close() error {
+	return c.rpc.Close()
+}
+
+// Handshake returns the store's protocol version and capabilities.
+// The response is cached for the lifetime of the connection,
+// so Handshake only makes a request the first time it is called
+// and after reconnecting.
+func (c *Client) Handshake(ctx context.Context) (*HandshakeResponse, error) {
+	c.handshakeMu.Lock()
+	resp := c.handshake
+	c.handshakeMu.Unlock()
+	if resp != nil {
+		return resp, nil
+	}
+
+	resp, err := zbstorerpc.Handshake(ctx, c.rpc)
+	if err != nil {
+		return nil, err
+	}
+	c.handshakeMu.Lock()
+	if c.handshake == nil {
+		c.handshake = resp
+	}
+	c.handshakeMu.Unlock()
+	return resp, nil
+}
+
+// Info returns information about the store object at the given path.
+// If the store object does not exist, Info returns an error
+// that wraps [zbstore.ErrNotFound].
+func (c *Client) Info(ctx context.Context, path zbstore.Path) (*ObjectInfo, error) {
+	resp := new(zbstorerpc.InfoResponse)
+	err := jsonrpc.Do(ctx, c.rpc, zbstorerpc.InfoMethod, resp, &zbstorerpc.InfoRequest{Path: path})
+	if err != nil {
+		return nil, fmt.Errorf("stat %s: %w", path, err)
+	}
+	if resp.Info == nil {
+		return nil, fmt.Errorf("stat %s: %w", path, zbstore.ErrNotFound)
+	}
+	return resp.Info, nil
+}
+
+// Exists reports whether the store object at the given path exists.
+func (c *Client) Exists(ctx context.Context, path zbstore.Path) (bool, error) {
+	var exists bool
+	err := jsonrpc.Do(ctx, c.rpc, zbstorerpc.ExistsMethod, &exists, &zbstorerpc.ExistsRequest{Path: string(path)})
+	if err != nil {
+		return false, fmt.Errorf("check %s exists: %w", path, err)
+	}
+	return exists, nil
+}
+
+// Import adds the store objects in r to the store.
+// r must be in the format written by [zbstore.Exporter].
+func (c *Client) Import(ctx context.Context, r io.Reader) error {
+	return c.store.StoreImport(ctx, r)
+}
+
+// Export writes the store objects at the given paths to dst
+// in the format read by [zbstore.Importer].
+func (c *Client) Export(ctx context.Context, dst io.Writer, paths sets.Set[zbstore.Path], opts *zbstore.ExportOptions) error {
+	return c.store.StoreExport(ctx, dst, paths, opts)
+}
+
+// Store returns a [zbstore.Store] that reads store objects from c.
+// The returned value also implements [zbstore.Importer] and [zbstore.Exporter].
+func (c *Client) Store() zbstore.Store {
+	return c.store
+}
@@ -0,0 +1,226 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package zbstoreclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"zb.256lights.llc/pkg/bytebuffer"
+	"zb.256lights.llc/pkg/internal/backend"
+	"zb.256lights.llc/pkg/internal/backendtest"
+	"zb.256lights.llc/pkg/internal/jsonrpc"
+	"zb.256lights.llc/pkg/internal/storetest"
+	"zb.256lights.llc/pkg/internal/system"
+	"zb.256lights.llc/pkg/internal/testcontext"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+	"zb.256lights.llc/pkg/zbstore"
+	"zombiezen.com/go/nix"
+)
+
+func TestClient(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Test uses /bin/sh")
+	}
+	ctx := testcontext.New(t)
+	dir := backendtest.NewStoreDirectory(t)
+	client, serverConns := newTestClient(ctx, t, dir)
+
+	exportBuffer := new(bytes.Buffer)
+	exporter := zbstore.NewExportWriter(exportBuffer)
+	newDerivation := func(name, script string) zbstore.Path {
+		t.Helper()
+		drvPath, _, err := storetest.ExportDerivation(exporter, &zbstore.Derivation{
+			Name:    name,
+			Dir:     dir,
+			System:  system.Current().String(),
+			Builder: "/bin/sh",
+			Args:    []string{"-c", script},
+			Env: map[string]string{
+				"out": zbstore.HashPlaceholder("out"),
+			},
+			Outputs: map[string]*zbstore.DerivationOutputType{
+				zbstore.DefaultDerivationOutputName: zbstore.RecursiveFileFloatingCAOutput(nix.SHA256),
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return drvPath
+	}
+	goodDrvPath := newDerivation("good.txt", "echo 'Hello, World!' ; echo hi > \"$out\"")
+	badDrvPath := newDerivation("bad.txt", "echo 'Oh no' ; exit 1")
+	if err := exporter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Import(ctx, exportBuffer); err != nil {
+		t.Fatal("Import:", err)
+	}
+
+	if exists, err := client.Exists(ctx, goodDrvPath); err != nil {
+		t.Error("Exists:", err)
+	} else if !exists {
+		t.Errorf("Exists(ctx, %q) = false; want true", goodDrvPath)
+	}
+	missingPath, err := dir.Object("00000000000000000000000000000000-missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Info(ctx, missingPath); !errors.Is(err, zbstore.ErrNotFound) {
+		t.Errorf("Info(ctx, missing) error = %v; want %v", err, zbstore.ErrNotFound)
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		buildID, err := client.Realize(ctx, &RealizeRequest{
+			DrvPaths: []zbstore.Path{goodDrvPath},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var progressCalls int
+		build, err := client.WaitForBuild(ctx, buildID, &WaitOptions{
+			Progress: func(*Build) { progressCalls++ },
+		})
+		if err != nil {
+			t.Fatal("WaitForBuild:", err)
+		}
+		if build.Status != BuildSuccess {
+			t.Errorf("build.Status = %q; want %q", build.Status, BuildSuccess)
+		}
+		if progressCalls == 0 {
+			t.Error("Progress never called")
+		}
+
+		logContent, err := io.ReadAll(client.NewLogReader(ctx, buildID, goodDrvPath))
+		if err != nil {
+			t.Error("Read log:", err)
+		}
+		if got, want := string(StripLogPrefixes(nil, logContent)), "Hello, World!\n"; got != want {
+			t.Errorf("log = %q; want %q", got, want)
+		}
+
+		outputPath, err := build.FindRealizeOutput(zbstore.OutputReference{
+			DrvPath:    goodDrvPath,
+			OutputName: zbstore.DefaultDerivationOutputName,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !outputPath.Valid {
+			t.Fatal("output path is null")
+		}
+		if _, err := client.Info(ctx, outputPath.X); err != nil {
+			t.Error("Info:", err)
+		}
+	})
+
+	t.Run("Failure", func(t *testing.T) {
+		buildID, err := client.Realize(ctx, &RealizeRequest{
+			DrvPaths: []zbstore.Path{badDrvPath},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		build, err := client.WaitForBuild(ctx, buildID, nil)
+		var statusError *StatusError
+		if !errors.As(err, &statusError) {
+			t.Fatalf("WaitForBuild error = %v; want %T", err, statusError)
+		}
+		if statusError.BuildID != buildID || statusError.Status != BuildFail {
+			t.Errorf("WaitForBuild error = %#v; want {BuildID: %q, Status: %q}", statusError, buildID, BuildFail)
+		}
+		if build == nil || build.Status != BuildFail {
+			t.Errorf("WaitForBuild(...) = %+v; want status %q", build, BuildFail)
+		}
+	})
+
+	t.Run("Reconnect", func(t *testing.T) {
+		if _, err := client.Handshake(ctx); err != nil {
+			t.Fatal(err)
+		}
+		for _, conn := range serverConns.take() {
+			conn.Close()
+		}
+		if exists, err := client.Exists(ctx, goodDrvPath); err != nil {
+			t.Fatal("Exists after connection lost:", err)
+		} else if !exists {
+			t.Errorf("Exists(ctx, %q) = false; want true", goodDrvPath)
+		}
+		if serverConns.dials.Load() < 2 {
+			t.Error("Client did not reconnect")
+		}
+	})
+}
+
+// connList is the list of server-side connections opened by a test client.
+type connList struct {
+	dials atomic.Int32
+
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func (cl *connList) add(conn net.Conn) {
+	cl.dials.Add(1)
+	cl.mu.Lock()
+	cl.conns = append(cl.conns, conn)
+	cl.mu.Unlock()
+}
+
+func (cl *connList) take() []net.Conn {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	conns := cl.conns
+	cl.conns = nil
+	return conns
+}
+
+// newTestClient starts a store and returns a [Client] connected to it.
+func newTestClient(ctx context.Context, tb testing.TB, dir zbstore.Directory) (*Client, *connList) {
+	srv, _, err := backendtest.NewServer(ctx, tb, dir, &backendtest.Options{
+		TempDir: tb.TempDir(),
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	serveCtx, stopServe := context.WithCancel(context.WithoutCancel(ctx))
+	var wg sync.WaitGroup
+	conns := new(connList)
+	client := New("", &Options{
+		Dial: func(ctx context.Context) (net.Conn, error) {
+			serverConn, clientConn := net.Pipe()
+			conns.add(serverConn)
+			receiver := srv.NewNARReceiver(serveCtx, bytebuffer.BufferCreator{})
+			serverCodec := zbstorerpc.NewCodec(serverConn, &zbstorerpc.CodecOptions{
+				Importer: zbstorerpc.NewReceiverImporter(receiver),
+				Msgpack:  true,
+			})
+			wg.Go(func() {
+				jsonrpc.Serve(backend.WithExporter(serveCtx, serverCodec), serverCodec, srv)
+				serverCodec.Close()
+				receiver.Cleanup(context.WithoutCancel(serveCtx))
+			})
+			return clientConn, nil
+		},
+	})
+	tb.Cleanup(func() {
+		if err := client.Close(); err != nil {
+			tb.Errorf("client.Close: %v", err)
+		}
+		stopServe()
+		for _, conn := range conns.take() {
+			conn.Close()
+		}
+		wg.Wait()
+	})
+	return client, conns
+}
@@ -0,0 +1,112 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+// Package zbstoreclienttest provides an ephemeral zb store for tests.
+// The store keeps its metadata in memory
+// and its objects in a temporary directory,
+// so tests that use it do not depend on (or modify) a store on the host.
+package zbstoreclienttest
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+
+	"zb.256lights.llc/pkg/bytebuffer"
+	"zb.256lights.llc/pkg/internal/backend"
+	"zb.256lights.llc/pkg/internal/backendtest"
+	"zb.256lights.llc/pkg/internal/jsonrpc"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+	"zb.256lights.llc/pkg/zbstore"
+	"zb.256lights.llc/pkg/zbstoreclient"
+)
+
+// Options is the set of optional parameters to [New].
+type Options struct {
+	// Directory is the store directory to use.
+	// Builders expect store objects to be at their store paths,
+	// so tests that build derivations generally use the default.
+	// If Directory is empty, then a new temporary directory is created
+	// and removed when the test finishes.
+	Directory zbstore.Directory
+
+	// If Sandbox is true, then the store runs builders in a sandbox
+	// when the host supports it.
+	// By default, builders run without a sandbox.
+	Sandbox bool
+}
+
+// Store is an ephemeral store started by [New].
+type Store struct {
+	// Directory is the store directory.
+	Directory zbstore.Directory
+	// Client is connected to the store.
+	Client *zbstoreclient.Client
+}
+
+// New starts a new ephemeral store and returns a client connected to it.
+// The store and the client are closed as part of test cleanup.
+// opts may be nil, in which case it is treated the same as the zero value.
+// If the store cannot be started, New terminates the test by calling [testing.TB.Fatal].
+// As such, New must be called from the goroutine running the test or benchmark function.
+func New(tb testing.TB, opts *Options) *Store {
+	tb.Helper()
+	if opts == nil {
+		opts = new(Options)
+	}
+	dir := opts.Directory
+	if dir == "" {
+		dir = backendtest.NewStoreDirectory(tb)
+	}
+	ctx := tb.Context()
+	srv, _, err := backendtest.NewServer(ctx, tb, dir, &backendtest.Options{
+		TempDir: tb.TempDir(),
+		Sandbox: opts.Sandbox,
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	serveCtx, stopServe := context.WithCancel(context.WithoutCancel(ctx))
+	var wg sync.WaitGroup
+	var connsMu sync.Mutex
+	var conns []net.Conn
+	client := zbstoreclient.New("", &zbstoreclient.Options{
+		Dial: func(ctx context.Context) (net.Conn, error) {
+			serverConn, clientConn := net.Pipe()
+			connsMu.Lock()
+			conns = append(conns, serverConn)
+			connsMu.Unlock()
+			receiver := srv.NewNARReceiver(serveCtx, bytebuffer.BufferCreator{})
+			serverCodec := zbstorerpc.NewCodec(serverConn, &zbstorerpc.CodecOptions{
+				Importer: zbstorerpc.NewReceiverImporter(receiver),
+				Msgpack:  true,
+			})
+			wg.Go(func() {
+				jsonrpc.Serve(backend.WithExporter(serveCtx, serverCodec), serverCodec, srv)
+				serverCodec.Close()
+				receiver.Cleanup(context.WithoutCancel(serveCtx))
+			})
+			return clientConn, nil
+		},
+	})
+	tb.Cleanup(func() {
+		if err := client.Close(); err != nil {
+			tb.Errorf("client.Close: %v", err)
+		}
+		stopServe()
+		connsMu.Lock()
+		for _, conn := range conns {
+			conn.Close()
+		}
+		conns = nil
+		connsMu.Unlock()
+		wg.Wait()
+	})
+
+	return &Store{
+		Directory: dir,
+		Client:    client,
+	}
+}
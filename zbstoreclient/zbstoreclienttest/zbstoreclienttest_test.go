@@ -0,0 +1,84 @@
+// Copyright 2026 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package zbstoreclienttest_test
+
+import (
+	"bytes"
+	"errors"
+	"runtime"
+	"testing"
+
+	"zb.256lights.llc/pkg/internal/storetest"
+	"zb.256lights.llc/pkg/internal/system"
+	"zb.256lights.llc/pkg/internal/testcontext"
+	"zb.256lights.llc/pkg/zbstore"
+	"zb.256lights.llc/pkg/zbstoreclient"
+	"zb.256lights.llc/pkg/zbstoreclient/zbstoreclienttest"
+	"zombiezen.com/go/nix"
+)
+
+func TestNew(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Test uses /bin/sh")
+	}
+	ctx := testcontext.New(t)
+	store := zbstoreclienttest.New(t, nil)
+
+	exportBuffer := new(bytes.Buffer)
+	exporter := zbstore.NewExportWriter(exportBuffer)
+	drvPath, _, err := storetest.ExportDerivation(exporter, &zbstore.Derivation{
+		Name:    "hello.txt",
+		Dir:     store.Directory,
+		System:  system.Current().String(),
+		Builder: "/bin/sh",
+		Args:    []string{"-c", `echo hi > "$out"`},
+		Env: map[string]string{
+			"out": zbstore.HashPlaceholder("out"),
+		},
+		Outputs: map[string]*zbstore.DerivationOutputType{
+			zbstore.DefaultDerivationOutputName: zbstore.RecursiveFileFloatingCAOutput(nix.SHA256),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exporter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Client.Import(ctx, exportBuffer); err != nil {
+		t.Fatal("Import:", err)
+	}
+
+	buildID, err := store.Client.Realize(ctx, &zbstoreclient.RealizeRequest{
+		DrvPaths: []zbstore.Path{drvPath},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	build, err := store.Client.WaitForBuild(ctx, buildID, nil)
+	if err != nil {
+		t.Fatal("WaitForBuild:", err)
+	}
+	outputPath, err := build.FindRealizeOutput(zbstore.OutputReference{
+		DrvPath:    drvPath,
+		OutputName: zbstore.DefaultDerivationOutputName,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !outputPath.Valid {
+		t.Fatal("output path is null")
+	}
+	if _, err := store.Client.Info(ctx, outputPath.X); err != nil {
+		t.Error("Info:", err)
+	}
+
+	// A second store must not see the first store's objects.
+	other := zbstoreclienttest.New(t, &zbstoreclienttest.Options{
+		Directory: store.Directory,
+	})
+	if _, err := other.Client.Info(ctx, drvPath); !errors.Is(err, zbstore.ErrNotFound) {
+		t.Errorf("Info(ctx, %s) on second store error = %v; want %v", drvPath, err, zbstore.ErrNotFound)
+	}
+}